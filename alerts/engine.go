@@ -0,0 +1,603 @@
+// Package alerts watches console output for configured patterns and long
+// boots, and sends notifications with the surrounding console excerpt.
+package alerts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+	"ipmiserial/playbooks"
+	"ipmiserial/sol"
+)
+
+const (
+	// maxHistory bounds how many fired alerts are kept for the API.
+	maxHistory = 200
+	// maxLine bounds a console line held while waiting for its newline.
+	maxLine = 4096
+	// excerptWait is how long an alert waits for lines after its match.
+	excerptWait = 5 * time.Second
+
+	defaultCooldown = 15 * time.Minute
+	defaultWindow   = 10 * time.Minute
+	defaultSeverity = "warning"
+)
+
+// Alert is one fired rule.
+type Alert struct {
+	ID          string    `json:"id"`
+	Rule        string    `json:"rule"`
+	Severity    string    `json:"severity"`
+	Server      string    `json:"server"`
+	Time        time.Time `json:"time"`
+	Message     string    `json:"message"`
+	Count       int       `json:"count,omitempty"` // matches within the rule's window
+	Excerpt     []string  `json:"excerpt,omitempty"`
+	PlaybookRun string    `json:"playbookRun,omitempty"`
+}
+
+type rule struct {
+	config.AlertRule
+	re      *regexp.Regexp
+	servers map[string]bool
+}
+
+func (r *rule) appliesTo(server string) bool {
+	return len(r.servers) == 0 || r.servers[server]
+}
+
+// serverState is the per-server matching state.
+type serverState struct {
+	partial   strings.Builder
+	lines     []string               // recent lines for excerpts
+	hits      map[string][]time.Time // rule -> match times within its window
+	lastFired map[string]time.Time   // rule -> last alert, for cooldown
+	bootStart time.Time              // zero when no boot is in progress
+	bootFired map[string]bool        // boot_timeout rules fired for this boot
+	pending   []*pendingAlert
+}
+
+// pendingAlert collects lines after a match before it is sent.
+type pendingAlert struct {
+	alert    *Alert
+	rule     *rule
+	need     int
+	deadline time.Time
+}
+
+type delivery struct {
+	name  string
+	n     notifier
+	alert *Alert
+}
+
+// Engine evaluates alert rules against every server's console.
+type Engine struct {
+	solManager *sol.Manager
+	playbooks  *playbooks.Engine
+
+	mu           sync.Mutex
+	rules        []*rule
+	notifiers    map[string]notifier
+	excerptLines int
+	excerptAfter int
+	servers      map[string]*serverState
+	onFire       func(*Alert)
+
+	histMu  sync.RWMutex
+	history []*Alert
+
+	queue chan delivery
+}
+
+// NewEngine creates the engine and hooks it to the console text of every
+// server. It must be called before SOL sessions start.
+func NewEngine(cfg config.AlertsConfig, solManager *sol.Manager, playbookEngine *playbooks.Engine) *Engine {
+	e := &Engine{
+		solManager: solManager,
+		playbooks:  playbookEngine,
+		servers:    make(map[string]*serverState),
+		queue:      make(chan delivery, 100),
+	}
+	e.SetConfig(cfg)
+	solManager.OnConsoleText(e.processText)
+	if playbookEngine != nil {
+		playbookEngine.OnNotify(e.playbookNotify)
+	}
+	return e
+}
+
+// SetConfig replaces the rules and notifiers. Invalid entries are logged
+// and skipped.
+func (e *Engine) SetConfig(cfg config.AlertsConfig) {
+	notifiers := make(map[string]notifier)
+	for _, nc := range cfg.Notifiers {
+		n, err := newNotifier(nc)
+		if err != nil {
+			log.Errorf("Skipping alert notifier %q: %v", nc.Name, err)
+			continue
+		}
+		notifiers[nc.Name] = n
+	}
+
+	var rules []*rule
+	for _, rc := range cfg.Rules {
+		r, err := compileRule(rc)
+		if err != nil {
+			log.Errorf("Skipping alert rule %q: %v", rc.Name, err)
+			continue
+		}
+		for _, name := range r.Notify {
+			if _, ok := notifiers[name]; !ok {
+				log.Warnf("Alert rule %s: unknown notifier %q", r.Name, name)
+			}
+		}
+		rules = append(rules, r)
+	}
+
+	e.mu.Lock()
+	e.rules = rules
+	e.notifiers = notifiers
+	e.excerptLines = cfg.ExcerptLines
+	e.excerptAfter = cfg.ExcerptAfter
+	e.mu.Unlock()
+	if len(rules) > 0 {
+		log.Infof("Alerts: %d rules, %d notifiers", len(rules), len(notifiers))
+	}
+}
+
+func compileRule(rc config.AlertRule) (*rule, error) {
+	if rc.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if rc.Pattern == "" && rc.BootTimeout <= 0 && !rc.RebootLoop {
+		return nil, fmt.Errorf("pattern, boot_timeout or reboot_loop is required")
+	}
+	r := &rule{AlertRule: rc}
+	if rc.Pattern != "" {
+		re, err := regexp.Compile(rc.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.re = re
+	}
+	if r.Count < 1 {
+		r.Count = 1
+	}
+	if r.Count > 1 && r.Window <= 0 {
+		r.Window = defaultWindow
+	}
+	if r.Cooldown <= 0 {
+		r.Cooldown = defaultCooldown
+	}
+	if r.Severity == "" {
+		r.Severity = defaultSeverity
+	}
+	if len(rc.Servers) > 0 {
+		r.servers = make(map[string]bool)
+		for _, s := range rc.Servers {
+			r.servers[s] = true
+		}
+	}
+	return r, nil
+}
+
+// Run tracks boots for boot_timeout rules, sends pending alerts and
+// delivers notifications until ctx is done.
+func (e *Engine) Run(ctx context.Context) {
+	events := e.solManager.SubscribeAllNotify()
+	defer e.solManager.UnsubscribeAllNotify(events)
+
+	go e.deliver(ctx)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			if ev.Channel == sol.ChannelAnalytics {
+				e.analyticsEvent(ev)
+			}
+		case now := <-ticker.C:
+			e.tick(now)
+		}
+	}
+}
+
+func (e *Engine) state(server string) *serverState {
+	st := e.servers[server]
+	if st == nil {
+		st = &serverState{
+			hits:      make(map[string][]time.Time),
+			lastFired: make(map[string]time.Time),
+			bootFired: make(map[string]bool),
+		}
+		e.servers[server] = st
+	}
+	return st
+}
+
+// processText receives console text from the server's actor.
+func (e *Engine) processText(server, text string, at time.Time) {
+	var ready []*pendingAlert
+	defer func() { e.fire(ready) }()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.rules) == 0 {
+		return
+	}
+	st := e.state(server)
+
+	for len(text) > 0 {
+		i := strings.IndexAny(text, "\r\n")
+		if i < 0 {
+			if st.partial.Len() < maxLine {
+				st.partial.WriteString(text)
+			}
+			return
+		}
+		st.partial.WriteString(text[:i])
+		text = text[i+1:]
+		line := strings.TrimRight(st.partial.String(), " \t")
+		st.partial.Reset()
+		if line != "" {
+			ready = append(ready, e.processLine(server, st, line, at)...)
+		}
+	}
+}
+
+func (e *Engine) processLine(server string, st *serverState, line string, at time.Time) []*pendingAlert {
+	var ready []*pendingAlert
+
+	st.lines = append(st.lines, line)
+	if n := e.excerptLines; n <= 0 {
+		st.lines = st.lines[:0]
+	} else if len(st.lines) > n {
+		st.lines = st.lines[len(st.lines)-n:]
+	}
+
+	// Lines after an earlier match complete its excerpt
+	kept := st.pending[:0]
+	for _, p := range st.pending {
+		p.alert.Excerpt = append(p.alert.Excerpt, line)
+		if p.need--; p.need <= 0 {
+			ready = append(ready, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	st.pending = kept
+
+	for _, r := range e.rules {
+		if r.re == nil || !r.appliesTo(server) || !r.re.MatchString(line) {
+			continue
+		}
+		hits := []time.Time{at}
+		if r.Count > 1 {
+			hits = append(st.hits[r.Name], at)
+			cutoff := at.Add(-r.Window)
+			for len(hits) > 0 && hits[0].Before(cutoff) {
+				hits = hits[1:]
+			}
+			if len(hits) > r.Count {
+				hits = hits[len(hits)-r.Count:]
+			}
+		}
+		st.hits[r.Name] = hits
+		if len(hits) < r.Count || at.Sub(st.lastFired[r.Name]) < r.Cooldown {
+			continue
+		}
+		st.hits[r.Name] = nil
+		st.lastFired[r.Name] = at
+
+		p := &pendingAlert{
+			alert: &Alert{
+				Rule:     r.Name,
+				Severity: r.Severity,
+				Server:   server,
+				Time:     at,
+				Message:  line,
+				Excerpt:  append([]string(nil), st.lines...),
+			},
+			rule:     r,
+			need:     e.excerptAfter,
+			deadline: time.Now().Add(excerptWait),
+		}
+		if r.Count > 1 {
+			p.alert.Count = len(hits)
+			p.alert.Message = fmt.Sprintf("%d matches within %v: %s", len(hits), r.Window, line)
+		}
+		if p.need > 0 {
+			st.pending = append(st.pending, p)
+		} else {
+			ready = append(ready, p)
+		}
+	}
+	return ready
+}
+
+// analyticsEvent follows boots for boot_timeout rules and fires
+// reboot_loop and power_on_degraded rules.
+func (e *Engine) analyticsEvent(ev sol.SSEEvent) {
+	var a sol.AnalyticsEvent
+	if err := json.Unmarshal([]byte(ev.Data), &a); err != nil {
+		return
+	}
+	switch a.Type {
+	case sol.EventBootStart, sol.EventBootComplete:
+		e.trackBoot(ev.Server, a)
+	case sol.EventRebootLoop:
+		e.fire(e.analyticsAlerts(ev.Server, a, func(r *rule) bool { return r.RebootLoop }, "reboot loop: "))
+	case sol.EventPowerOnDegraded:
+		e.fire(e.analyticsAlerts(ev.Server, a, func(r *rule) bool { return r.PowerOnDegraded }, "power-on latency degraded: "))
+	}
+}
+
+// trackBoot follows boot start and completion for boot_timeout rules.
+func (e *Engine) trackBoot(server string, a sol.AnalyticsEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.state(server)
+	if a.Type == sol.EventBootStart {
+		st.bootStart = a.Time
+	} else {
+		st.bootStart = time.Time{}
+	}
+	st.bootFired = make(map[string]bool)
+}
+
+// analyticsAlerts builds alerts for the rules selected by match that apply
+// to server, for an analytics event; the message is prefix and its detail.
+func (e *Engine) analyticsAlerts(server string, a sol.AnalyticsEvent, match func(*rule) bool, prefix string) []*pendingAlert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var ready []*pendingAlert
+	st := e.state(server)
+	for _, r := range e.rules {
+		if !match(r) || !r.appliesTo(server) || a.Time.Sub(st.lastFired[r.Name]) < r.Cooldown {
+			continue
+		}
+		st.lastFired[r.Name] = a.Time
+		ready = append(ready, &pendingAlert{
+			alert: &Alert{
+				Rule:     r.Name,
+				Severity: r.Severity,
+				Server:   server,
+				Time:     a.Time,
+				Message:  prefix + a.Detail,
+				Excerpt:  append([]string(nil), st.lines...),
+			},
+			rule: r,
+		})
+	}
+	return ready
+}
+
+// playbookNotify fires a playbook's notify step as an alert named
+// playbook:<name>, to the step's notifiers. There is no cooldown: the
+// playbook decides when to notify.
+func (e *Engine) playbookNotify(n playbooks.Notification) {
+	name := "playbook:" + n.Playbook
+	severity := n.Severity
+	if severity == "" {
+		severity = defaultSeverity
+	}
+	e.mu.Lock()
+	excerpt := append([]string(nil), e.state(n.Server).lines...)
+	e.mu.Unlock()
+	e.fire([]*pendingAlert{{
+		alert: &Alert{
+			Rule:        name,
+			Severity:    severity,
+			Server:      n.Server,
+			Time:        time.Now(),
+			Message:     n.Message,
+			Excerpt:     excerpt,
+			PlaybookRun: n.RunID,
+		},
+		rule: &rule{AlertRule: config.AlertRule{Name: name, Notify: n.Notify}},
+	}})
+}
+
+func (e *Engine) tick(now time.Time) {
+	var ready []*pendingAlert
+	defer func() { e.fire(ready) }()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for server, st := range e.servers {
+		kept := st.pending[:0]
+		for _, p := range st.pending {
+			if now.After(p.deadline) {
+				ready = append(ready, p)
+			} else {
+				kept = append(kept, p)
+			}
+		}
+		st.pending = kept
+
+		if st.bootStart.IsZero() {
+			continue
+		}
+		elapsed := now.Sub(st.bootStart)
+		for _, r := range e.rules {
+			if r.BootTimeout <= 0 || !r.appliesTo(server) || st.bootFired[r.Name] || elapsed < r.BootTimeout {
+				continue
+			}
+			st.bootFired[r.Name] = true
+			if now.Sub(st.lastFired[r.Name]) < r.Cooldown {
+				continue
+			}
+			st.lastFired[r.Name] = now
+			ready = append(ready, &pendingAlert{
+				alert: &Alert{
+					Rule:     r.Name,
+					Severity: r.Severity,
+					Server:   server,
+					Time:     now,
+					Message:  fmt.Sprintf("boot running for %v without completing", elapsed.Round(time.Second)),
+					Excerpt:  append([]string(nil), st.lines...),
+				},
+				rule: r,
+			})
+		}
+	}
+}
+
+// fire records alerts, queues their notifications and starts playbooks.
+// Called without e.mu held.
+func (e *Engine) fire(ready []*pendingAlert) {
+	if len(ready) == 0 {
+		return
+	}
+	e.mu.Lock()
+	notifiers, onFire := e.notifiers, e.onFire
+	e.mu.Unlock()
+
+	for _, p := range ready {
+		a, r := p.alert, p.rule
+		a.ID = newAlertID()
+		log.Warnf("Alert %s (%s) on %s: %s", a.Rule, a.Severity, a.Server, a.Message)
+
+		if r.Playbook != "" && e.playbooks != nil {
+			run, err := e.playbooks.Start(r.Playbook, a.Server, "alert:"+r.Name)
+			if err != nil {
+				log.Errorf("Alert %s: playbook %s on %s: %v", r.Name, r.Playbook, a.Server, err)
+			} else {
+				a.PlaybookRun = run.ID
+			}
+		}
+
+		e.histMu.Lock()
+		e.history = append(e.history, a)
+		if len(e.history) > maxHistory {
+			e.history = e.history[len(e.history)-maxHistory:]
+		}
+		e.histMu.Unlock()
+		if onFire != nil {
+			onFire(a)
+		}
+
+		names := r.Notify
+		if len(names) == 0 {
+			for name := range notifiers {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			n := notifiers[name]
+			if n == nil {
+				continue
+			}
+			select {
+			case e.queue <- delivery{name: name, n: n, alert: a}:
+			default:
+				log.Warnf("Alert queue full, dropping %s notification for %s", name, a.Rule)
+			}
+		}
+	}
+}
+
+// deliver sends queued notifications, retrying transient failures.
+func (e *Engine) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-e.queue:
+			var err error
+			for attempt, wait := 0, 2*time.Second; attempt < 3; attempt, wait = attempt+1, wait*2 {
+				if err = d.n.send(ctx, d.alert); err == nil {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+			if err != nil {
+				log.Errorf("Alert %s: notifier %s failed: %v", d.alert.Rule, d.name, err)
+			} else {
+				log.Debugf("Alert %s sent via %s", d.alert.Rule, d.name)
+			}
+		}
+	}
+}
+
+// OnFire registers fn to be called with every fired alert.
+func (e *Engine) OnFire(fn func(*Alert)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onFire = fn
+}
+
+// History returns fired alerts, newest first, optionally for one server.
+func (e *Engine) History(server string, limit int) []*Alert {
+	e.histMu.RLock()
+	defer e.histMu.RUnlock()
+	out := make([]*Alert, 0)
+	for i := len(e.history) - 1; i >= 0; i-- {
+		a := e.history[i]
+		if server != "" && a.Server != server {
+			continue
+		}
+		out = append(out, a)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+// Forget drops a removed server's matching state, including alerts still
+// collecting their excerpt. Its history is kept.
+func (e *Engine) Forget(server string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.servers, server)
+}
+
+// HasNotifier reports whether a notifier is configured.
+func (e *Engine) HasNotifier(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.notifiers[name] != nil
+}
+
+// Test sends a sample alert through one notifier, synchronously.
+func (e *Engine) Test(ctx context.Context, name string) error {
+	e.mu.Lock()
+	n := e.notifiers[name]
+	e.mu.Unlock()
+	if n == nil {
+		return fmt.Errorf("unknown notifier %q", name)
+	}
+	return n.send(ctx, &Alert{
+		ID:       newAlertID(),
+		Rule:     "test",
+		Severity: "info",
+		Server:   "ipmiserial",
+		Time:     time.Now(),
+		Message:  "Test notification from ipmiserial",
+	})
+}
+
+func newAlertID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,152 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"ipmiserial/config"
+)
+
+// notifyTimeout bounds a single notification attempt.
+const notifyTimeout = 10 * time.Second
+
+var httpClient = &http.Client{Timeout: notifyTimeout}
+
+type notifier interface {
+	send(ctx context.Context, a *Alert) error
+}
+
+func newNotifier(nc config.Notifier) (notifier, error) {
+	if nc.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	switch nc.Type {
+	case "webhook":
+		if nc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &webhookNotifier{url: nc.URL, headers: nc.Headers}, nil
+	case "slack":
+		if nc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &slackNotifier{url: nc.URL}, nil
+	case "email":
+		if nc.SMTPHost == "" || nc.From == "" || len(nc.To) == 0 {
+			return nil, fmt.Errorf("smtp_host, from and to are required")
+		}
+		if _, _, err := net.SplitHostPort(nc.SMTPHost); err != nil {
+			return nil, fmt.Errorf("smtp_host must be host:port")
+		}
+		return &emailNotifier{Notifier: nc}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q (use webhook, slack or email)", nc.Type)
+}
+
+// summary is the one-line description used in chat and mail subjects.
+func summary(a *Alert) string {
+	return fmt.Sprintf("[%s] %s on %s: %s", a.Severity, a.Rule, a.Server, a.Message)
+}
+
+// webhookNotifier POSTs the alert as JSON.
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+}
+
+func (n *webhookNotifier) send(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return post(ctx, n.url, body, n.headers)
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) send(ctx context.Context, a *Alert) error {
+	text := fmt.Sprintf("*[%s] %s* on `%s`: %s", a.Severity, a.Rule, a.Server, a.Message)
+	if len(a.Excerpt) > 0 {
+		// Triple backticks in console output would end the code block early
+		excerpt := strings.ReplaceAll(strings.Join(a.Excerpt, "\n"), "```", "'''")
+		text += "\n```\n" + excerpt + "\n```"
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return post(ctx, n.url, body, nil)
+}
+
+func post(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// emailNotifier sends a plain-text mail over SMTP, using STARTTLS when the
+// server offers it.
+type emailNotifier struct {
+	config.Notifier
+}
+
+func (n *emailNotifier) send(ctx context.Context, a *Alert) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [ipmiserial] %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(summary(a)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Server:   %s\r\nRule:     %s\r\nSeverity: %s\r\nTime:     %s\r\n\r\n%s\r\n",
+		a.Server, a.Rule, a.Severity, a.Time.Format(time.RFC3339), a.Message)
+	if len(a.Excerpt) > 0 {
+		msg.WriteString("\r\nConsole:\r\n\r\n")
+		for _, line := range a.Excerpt {
+			msg.WriteString("    " + line + "\r\n")
+		}
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, _ := net.SplitHostPort(n.SMTPHost)
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	// smtp.SendMail has no timeout or context; don't let a stalled server
+	// hold up the delivery queue
+	ctx, cancel := context.WithTimeout(ctx, 3*notifyTimeout)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- smtp.SendMail(n.SMTPHost, auth, n.From, n.To, []byte(msg.String())) }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,63 @@
+// Package bmcproxy reaches BMC networks ipmiserial has no route to, through
+// a SOCKS5 proxy (UDP ASSOCIATE) or an SSH jump host. SSH carries no UDP,
+// so over a jump host each BMC socket runs a relay command there
+// (cmd/udprelay) that passes the BMC's datagrams over the session's stdin
+// and stdout.
+package bmcproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"ipmiserial/config"
+)
+
+// DialFunc opens a connection to a BMC: "udp" for IPMI sessions and pings,
+// "tcp" for Redfish. It has the signature of go-sol's Config.Dial.
+type DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialTimeout bounds reaching the proxy or jump host and its handshake.
+const dialTimeout = 15 * time.Second
+
+// New returns a dialer through the proxy cfg describes, or nil for type
+// "none" or empty (dial BMCs directly).
+func New(cfg config.ProxyConfig) (DialFunc, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "", "none":
+		return nil, nil
+	case "socks5":
+		p, err := NewSOCKS5(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return p.Dial, nil
+	case "ssh":
+		j, err := NewJump(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return j.Dial, nil
+	default:
+		return nil, fmt.Errorf("unknown proxy type %q (socks5, ssh or none)", cfg.Type)
+	}
+}
+
+// withPort adds port to addr when it has none.
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// proxyAddr names the far end of a proxied connection, which has no
+// local socket address.
+type proxyAddr struct {
+	network, addr string
+}
+
+func (a proxyAddr) Network() string { return a.network }
+func (a proxyAddr) String() string  { return a.addr }
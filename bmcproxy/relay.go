@@ -0,0 +1,248 @@
+package bmcproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The relay carries datagrams over a byte stream (an SSH session's stdin
+// and stdout), each as a 2-byte big-endian length and the payload.
+
+// relayDepth is the datagrams queued for Read; like a UDP socket buffer,
+// datagrams beyond it are dropped.
+const relayDepth = 256
+
+// writeFrame writes one datagram.
+func writeFrame(w io.Writer, p []byte) error {
+	if len(p) > 0xFFFF {
+		return errors.New("datagram too large")
+	}
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(p)), uint16(len(p)))
+	_, err := w.Write(append(frame, p...))
+	return err
+}
+
+// readFrame reads one datagram into buf, which must hold 64KiB.
+func readFrame(r io.Reader, buf []byte) ([]byte, error) {
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(buf[:2]))
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Relay passes framed datagrams from r to conn and conn's datagrams to w
+// as frames, until r ends or conn fails. It is the jump host side of an
+// SSH proxied socket: cmd/udprelay runs it on stdin and stdout.
+func Relay(r io.Reader, w io.Writer, conn net.Conn) error {
+	errc := make(chan error, 2)
+	go func() {
+		buf := make([]byte, 0x10000)
+		for {
+			p, err := readFrame(r, buf)
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+			// A refused send (ICMP unreachable) is UDP loss, not the end
+			conn.Write(p)
+		}
+	}()
+	go func() {
+		buf := make([]byte, 0x10000)
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				// Port unreachable from an earlier send: keep listening
+				continue
+			}
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+			if err := writeFrame(w, buf[:n]); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	err := <-errc
+	conn.Close()
+	return err
+}
+
+// relayConn is the ipmiserial side of a relayed socket: datagrams framed
+// over a stream, with the deadlines of a net.Conn.
+type relayConn struct {
+	w       io.Writer
+	wmu     sync.Mutex
+	onClose func() error
+	remote  net.Addr
+
+	in      chan []byte
+	done    chan struct{} // closed when the stream ends; err says why
+	err     error
+	closed  chan struct{}
+	closeMu sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{} // closed and replaced when the read deadline moves
+}
+
+// newRelayConn reads datagrams from r and writes them to w; onClose ends
+// the stream. eofErr, if set, explains an early end of r.
+func newRelayConn(r io.Reader, w io.Writer, remote net.Addr, onClose func() error, eofErr func() error) *relayConn {
+	c := &relayConn{
+		w:       w,
+		onClose: onClose,
+		remote:  remote,
+		in:      make(chan []byte, relayDepth),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		buf := make([]byte, 0x10000)
+		for {
+			p, err := readFrame(r, buf)
+			if err != nil {
+				c.err = io.EOF
+				if eofErr != nil {
+					if e := eofErr(); e != nil {
+						c.err = e
+					}
+				}
+				return
+			}
+			select {
+			case c.in <- append([]byte(nil), p...):
+			default:
+				// Reader is behind: dropped
+			}
+		}
+	}()
+	return c
+}
+
+// Read returns the next datagram, truncated to b like a UDP read.
+func (c *relayConn) Read(b []byte) (int, error) {
+	for {
+		// A datagram already waiting is returned even past the deadline
+		select {
+		case p := <-c.in:
+			return copy(b, p), nil
+		case <-c.closed:
+			return 0, net.ErrClosed
+		default:
+		}
+
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.changed
+		c.mu.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+		n, err := c.wait(b, timeout, changed)
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != errDeadlineMoved {
+			return n, err
+		}
+	}
+}
+
+// errDeadlineMoved sends Read round again with the new deadline.
+var errDeadlineMoved = errors.New("read deadline moved")
+
+// wait blocks Read until a datagram, the end, the timeout or a deadline
+// change.
+func (c *relayConn) wait(b []byte, timeout <-chan time.Time, changed <-chan struct{}) (int, error) {
+	select {
+	case p := <-c.in:
+		return copy(b, p), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.done:
+		select {
+		case p := <-c.in:
+			return copy(b, p), nil
+		default:
+		}
+		return 0, c.err
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-changed:
+		return 0, errDeadlineMoved
+	}
+}
+
+// Write sends b as one datagram. Write deadlines are not enforced: the
+// stream only blocks while the SSH window is full.
+func (c *relayConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.done:
+		return 0, c.err
+	default:
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := writeFrame(c.w, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *relayConn) Close() error {
+	err := net.ErrClosed
+	c.closeMu.Do(func() {
+		close(c.closed)
+		err = c.onClose()
+	})
+	return err
+}
+
+func (c *relayConn) LocalAddr() net.Addr  { return proxyAddr{"udp", "relay"} }
+func (c *relayConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *relayConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *relayConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return nil
+}
+
+func (c *relayConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,328 @@
+package bmcproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"ipmiserial/config"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socksVersion      = 0x05
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksConnect      = 0x01
+	socksUDPAssociate = 0x03
+	socksATYPIPv4     = 0x01
+	socksATYPDomain   = 0x03
+	socksATYPIPv6     = 0x04
+)
+
+var socksReplies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// SOCKS5 dials through a SOCKS5 proxy: UDP with UDP ASSOCIATE, one
+// association per socket, and TCP with CONNECT. The BMC's address is sent
+// as given, so a host name is resolved by the proxy.
+type SOCKS5 struct {
+	addr     string
+	username string
+	password string
+}
+
+// NewSOCKS5 returns a SOCKS5 proxy at cfg.Address (default port 1080),
+// with username/password authentication if cfg.Username is set.
+func NewSOCKS5(cfg config.ProxyConfig) (*SOCKS5, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("socks5 proxy needs an address")
+	}
+	if len(cfg.Username) > 255 || len(cfg.Password) > 255 {
+		return nil, errors.New("socks5 username and password are limited to 255 bytes")
+	}
+	return &SOCKS5{addr: withPort(cfg.Address, "1080"), username: cfg.Username, password: cfg.Password}, nil
+}
+
+// Dial connects to addr through the proxy.
+func (p *SOCKS5) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var cmd byte
+	switch network {
+	case "udp", "udp4", "udp6":
+		cmd = socksUDPAssociate
+	case "tcp", "tcp4", "tcp6":
+		cmd = socksConnect
+	default:
+		return nil, fmt.Errorf("socks5: unsupported network %s", network)
+	}
+	dst, err := socksAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d := net.Dialer{Timeout: dialTimeout}
+	ctrl, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("socks5 proxy %s: %w", p.addr, err)
+	}
+	// The handshake gives up with ctx or the dial timeout
+	deadline := time.Now().Add(dialTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	ctrl.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { ctrl.SetDeadline(time.Unix(1, 0)) })
+	// done ends the handshake, failing if ctx cut it short
+	done := func() error {
+		if !stop() {
+			return ctx.Err()
+		}
+		return ctrl.SetDeadline(time.Time{})
+	}
+
+	if cmd == socksConnect {
+		if _, err := p.request(ctrl, socksConnect, dst); err != nil {
+			stop()
+			ctrl.Close()
+			return nil, err
+		}
+		if err := done(); err != nil {
+			ctrl.Close()
+			return nil, err
+		}
+		return ctrl, nil
+	}
+
+	// UDP ASSOCIATE: the client address is left unspecified, as it is
+	// only known once the UDP socket exists
+	relay, err := p.request(ctrl, socksUDPAssociate, []byte{socksATYPIPv4, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		stop()
+		ctrl.Close()
+		return nil, err
+	}
+	// A relay on the unspecified address is on the proxy's host
+	if relay.IP.IsUnspecified() {
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+	udp, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		stop()
+		ctrl.Close()
+		return nil, fmt.Errorf("socks5 relay %s: %w", relay, err)
+	}
+	if err := done(); err != nil {
+		udp.Close()
+		ctrl.Close()
+		return nil, err
+	}
+
+	c := &socksUDPConn{UDPConn: udp, ctrl: ctrl, header: append([]byte{0, 0, 0}, dst...), remote: proxyAddr{"udp", addr}}
+	// The association lasts as long as the control connection: when the
+	// proxy drops it, close the socket so reads fail instead of hanging
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		c.Close()
+	}()
+	return c, nil
+}
+
+// request authenticates and sends one command, returning the bound
+// address from the proxy's reply.
+func (p *SOCKS5) request(conn net.Conn, cmd byte, dst []byte) (*net.UDPAddr, error) {
+	method := byte(socksAuthNone)
+	if p.username != "" {
+		method = socksAuthPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return nil, fmt.Errorf("socks5: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, fmt.Errorf("socks5 greeting: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return nil, fmt.Errorf("socks5: proxy speaks version %d", reply[0])
+	}
+	if reply[1] != method {
+		return nil, errors.New("socks5: proxy refused the authentication method")
+	}
+	if method == socksAuthPassword {
+		auth := []byte{0x01, byte(len(p.username))}
+		auth = append(auth, p.username...)
+		auth = append(auth, byte(len(p.password)))
+		auth = append(auth, p.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return nil, fmt.Errorf("socks5: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, fmt.Errorf("socks5 authentication: %w", err)
+		}
+		if reply[1] != 0x00 {
+			return nil, errors.New("socks5: authentication failed")
+		}
+	}
+
+	req := append([]byte{socksVersion, cmd, 0}, dst...)
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("socks5: %w", err)
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, fmt.Errorf("socks5 reply: %w", err)
+	}
+	if head[1] != 0x00 {
+		msg, ok := socksReplies[head[1]]
+		if !ok {
+			msg = fmt.Sprintf("reply 0x%02X", head[1])
+		}
+		return nil, fmt.Errorf("socks5: %s", msg)
+	}
+	var host []byte
+	switch head[3] {
+	case socksATYPIPv4:
+		host = make([]byte, net.IPv4len)
+	case socksATYPIPv6:
+		host = make([]byte, net.IPv6len)
+	case socksATYPDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return nil, fmt.Errorf("socks5 reply: %w", err)
+		}
+		host = make([]byte, n[0])
+	default:
+		return nil, fmt.Errorf("socks5: reply address type 0x%02X", head[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, host); err != nil {
+		return nil, fmt.Errorf("socks5 reply: %w", err)
+	}
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, fmt.Errorf("socks5 reply: %w", err)
+	}
+	bound := &net.UDPAddr{Port: int(binary.BigEndian.Uint16(port))}
+	if head[3] == socksATYPDomain {
+		ip, err := net.ResolveIPAddr("ip", string(host))
+		if err != nil {
+			return nil, fmt.Errorf("socks5 relay: %w", err)
+		}
+		bound.IP = ip.IP
+	} else {
+		bound.IP = net.IP(host)
+	}
+	return bound, nil
+}
+
+// socksAddress encodes addr as a SOCKS5 ATYP, address and port.
+func socksAddress(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	var b []byte
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host name too long: %s", host)
+		}
+		b = append([]byte{socksATYPDomain, byte(len(host))}, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{socksATYPIPv4}, ip4...)
+	} else {
+		b = append([]byte{socksATYPIPv6}, ip...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// socksUDPConn is a UDP association: datagrams to and from the BMC, each
+// carrying the SOCKS5 UDP request header on the wire.
+type socksUDPConn struct {
+	*net.UDPConn
+	ctrl   net.Conn
+	header []byte // RSV, FRAG, ATYP, DST.ADDR, DST.PORT
+	remote net.Addr
+
+	rmu       sync.Mutex
+	buf       []byte // receive buffer, guarded by rmu
+	closeOnce sync.Once
+}
+
+func (c *socksUDPConn) Write(b []byte) (int, error) {
+	packet := append(append(make([]byte, 0, len(c.header)+len(b)), c.header...), b...)
+	if _, err := c.UDPConn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read returns the next datagram from the relay without its header.
+// Fragments, which BMC traffic never needs, are dropped.
+func (c *socksUDPConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.buf == nil {
+		c.buf = make([]byte, 65535)
+	}
+	for {
+		n, err := c.UDPConn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		off, ok := socksPayload(c.buf[:n])
+		if !ok {
+			continue
+		}
+		return copy(b, c.buf[off:n]), nil
+	}
+}
+
+// socksPayload returns where a relayed datagram's payload starts, or false
+// for a short or fragmented one.
+func socksPayload(p []byte) (int, bool) {
+	if len(p) < 4 || p[2] != 0 {
+		return 0, false
+	}
+	var off int
+	switch p[3] {
+	case socksATYPIPv4:
+		off = 4 + net.IPv4len + 2
+	case socksATYPIPv6:
+		off = 4 + net.IPv6len + 2
+	case socksATYPDomain:
+		if len(p) < 5 {
+			return 0, false
+		}
+		off = 5 + int(p[4]) + 2
+	default:
+		return 0, false
+	}
+	return off, len(p) >= off
+}
+
+func (c *socksUDPConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *socksUDPConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.UDPConn.Close()
+		c.ctrl.Close()
+	})
+	return err
+}
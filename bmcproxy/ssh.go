@@ -0,0 +1,284 @@
+package bmcproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"ipmiserial/config"
+)
+
+const (
+	// defaultRelay is the command run on the jump host for each UDP
+	// socket, with the BMC's host:port appended.
+	defaultRelay = "udprelay"
+
+	// jumpIdle is how long the SSH connection outlives the last
+	// connection through it.
+	jumpIdle = time.Minute
+
+	// stderrLimit bounds the relay's error output kept for the error it
+	// ends a connection with.
+	stderrLimit = 512
+)
+
+// Jump dials through an SSH jump host: TCP with direct-tcpip channels and
+// UDP through the relay command. It keeps one SSH connection, opened on
+// first use and closed once idle.
+type Jump struct {
+	addr   string
+	relay  string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+	open   int         // connections through client
+	idle   *time.Timer // closes client once nothing uses it
+}
+
+// NewJump returns a jump host at cfg.Address (default port 22), logging in
+// as cfg.Username with cfg.KeyFile and/or cfg.Password. The host key is
+// checked against cfg.KnownHostsFile unless cfg.InsecureIgnoreHostKey.
+func NewJump(cfg config.ProxyConfig) (*Jump, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("ssh jump host needs an address")
+	}
+	if cfg.Username == "" {
+		return nil, errors.New("ssh jump host needs a username")
+	}
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ssh key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("ssh key %s: %w", cfg.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("ssh jump host needs a key_file or password")
+	}
+
+	var hostKey ssh.HostKeyCallback
+	switch {
+	case cfg.KnownHostsFile != "":
+		cb, err := knownhosts.New(cfg.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("known_hosts: %w", err)
+		}
+		hostKey = cb
+	case cfg.InsecureIgnoreHostKey:
+		hostKey = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("ssh jump host needs a known_hosts_file (or insecure_ignore_host_key)")
+	}
+
+	relay := cfg.Relay
+	if relay == "" {
+		relay = defaultRelay
+	}
+	return &Jump{
+		addr:  withPort(cfg.Address, "22"),
+		relay: relay,
+		config: &ssh.ClientConfig{
+			User:            cfg.Username,
+			Auth:            auth,
+			HostKeyCallback: hostKey,
+			Timeout:         dialTimeout,
+		},
+	}, nil
+}
+
+// Dial connects to addr from the jump host.
+func (j *Jump) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("ssh jump: unsupported network %s", network)
+	}
+	client, err := j.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	release := func() { once.Do(func() { j.release(client) }) }
+
+	if strings.HasPrefix(network, "tcp") {
+		conn, err := client.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("ssh jump %s: %w", j.addr, err)
+		}
+		return &jumpConn{Conn: conn, release: release}, nil
+	}
+
+	conn, err := j.startRelay(client, addr, release)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// startRelay runs the relay command for addr in a new session and returns
+// its datagram connection.
+func (j *Jump) startRelay(client *ssh.Client, addr string, release func()) (net.Conn, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("ssh jump %s: %w", j.addr, err)
+	}
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	stderr := &headBuffer{}
+	sess.Stderr = stderr
+	if err := sess.Start(j.relay + " " + shellQuote(addr)); err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("ssh jump %s: relay: %w", j.addr, err)
+	}
+
+	onClose := func() error {
+		stdin.Close()
+		sess.Close()
+		release()
+		return nil
+	}
+	// The relay only stops on its own when it fails, e.g. isn't installed
+	relayErr := func() error {
+		err := sess.Wait()
+		msg := stderr.String()
+		if err == nil && msg == "" {
+			return nil
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("relay on %s ended: %s", j.addr, msg)
+	}
+	return newRelayConn(stdout, stdin, proxyAddr{"udp", addr}, onClose, relayErr), nil
+}
+
+// acquire returns the SSH connection, opening it if need be, and counts a
+// connection through it.
+func (j *Jump) acquire(ctx context.Context) (*ssh.Client, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.client == nil {
+		client, err := j.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		j.client, j.open = client, 0
+		go func() {
+			// Forget a connection that drops, so the next dial reopens it
+			client.Wait()
+			j.mu.Lock()
+			if j.client == client {
+				j.client = nil
+			}
+			j.mu.Unlock()
+		}()
+	}
+	if j.idle != nil {
+		j.idle.Stop()
+		j.idle = nil
+	}
+	j.open++
+	return j.client, nil
+}
+
+// release uncounts a connection through client, closing client after
+// jumpIdle once none are left.
+func (j *Jump) release(client *ssh.Client) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.client != client {
+		return // dropped and forgotten already
+	}
+	if j.open--; j.open > 0 {
+		return
+	}
+	j.idle = time.AfterFunc(jumpIdle, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.client == client && j.open == 0 {
+			client.Close()
+			j.client = nil
+		}
+	})
+}
+
+func (j *Jump) connect(ctx context.Context) (*ssh.Client, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", j.addr)
+	if err != nil {
+		return nil, fmt.Errorf("ssh jump %s: %w", j.addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, j.addr, j.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh jump %s: %w", j.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// jumpConn is a TCP connection through the jump host.
+type jumpConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// headBuffer keeps the start of the relay's stderr.
+type headBuffer struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (t *headBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if room := stderrLimit - len(t.b); room > 0 {
+		t.b = append(t.b, p[:min(len(p), room)]...)
+	}
+	return len(p), nil
+}
+
+func (t *headBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.b))
+}
+
+// shellQuote quotes s for the jump host's shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
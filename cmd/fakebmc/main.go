@@ -0,0 +1,177 @@
+// Command fakebmc runs go-sol's fake BMC on a UDP socket, so ipmiserial can
+// be developed and demoed without hardware. Point a static server at it:
+//
+//	servers:
+//	  - name: fake1
+//	    host: 127.0.0.1
+//	    port: 16623
+//
+// It replays a boot's console output (a built-in sample, or -replay file)
+// at the serial line's pace, and again whenever the host is powered on,
+// cycled or reset over IPMI, so reboot detection and analytics have
+// something to see.
+//
+//	go run ./cmd/fakebmc -listen 127.0.0.1:16623 -baud 115200 -echo
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	sol "github.com/gwest/go-sol"
+	"github.com/gwest/go-sol/soltest"
+	log "github.com/sirupsen/logrus"
+)
+
+var sampleBoot = strings.Join([]string{
+	"American Megatrends Version 2.20.1271. Copyright (C) 2020 American Megatrends, Inc.\r\n",
+	"Press <DEL> to run Setup\r\n",
+	"Intel(R) Boot Agent GE v1.5.89\r\nCLIENT MAC ADDR: 00 25 90 AA BB CC\r\n",
+	"iPXE initialising devices...ok\r\niPXE 1.21.1+ -- Open Source Network Boot Firmware\r\n",
+	"http://10.0.0.1/coreos-kernel... ok\r\nhttp://10.0.0.1/coreos-initramfs... ok\r\n",
+	"[    0.000000] Linux version 6.5.6-300.fc39.x86_64 (mockbuild@) (gcc 13.2.1)\r\n",
+	"[    1.234567] systemd[1]: systemd 254.5-2.fc39 running in system mode (+PAM +AUDIT)\r\n",
+	"[  OK  ] Started Journal Service.\r\n[  OK  ] Reached target Local File Systems.\r\n",
+	"[    5.111111] eth0: link up, 10000Mbps, full-duplex\r\n",
+	"Welcome to Fedora CoreOS 39.20231101.3.0!\r\n",
+	"[  OK  ] Started OpenSSH server daemon.\r\n",
+	"\r\nnode1 login: ",
+}, "")
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:16623", "UDP address to serve IPMI on")
+	user := flag.String("user", "ADMIN", "IPMI username")
+	password := flag.String("password", "ADMIN", "IPMI password")
+	kg := flag.String("kg", "", "BMC key for two-key RAKP (default: one-key)")
+	replay := flag.String("replay", "", "File of console output to replay at each boot (default: a sample boot)")
+	baud := flag.Int("baud", 115200, "Serial speed the replay is paced at (0 = as fast as possible)")
+	loop := flag.Duration("loop", 0, "Reboot on its own this long after each boot finishes (0 = only when asked over IPMI)")
+	echo := flag.Bool("echo", false, "Echo console input back as output")
+	v15 := flag.Bool("v15", false, "Speak IPMI v1.5 only, as a legacy board without RMCP+")
+	manufacturer := flag.Uint("manufacturer", 0, "IANA enterprise number reported by Get Device ID, e.g. 10876 for Supermicro")
+	noIntegrity := flag.Bool("no-integrity", false, "Refuse cipher suite 2, as a BMC offering suite 1 only")
+	flag.Parse()
+
+	boot := []byte(sampleBoot)
+	if *replay != "" {
+		data, err := os.ReadFile(*replay)
+		if err != nil {
+			log.Fatalf("Failed to read replay file: %v", err)
+		}
+		boot = data
+	}
+
+	pc, err := net.ListenPacket("udp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listen, err)
+	}
+
+	r := &replayer{boot: boot, baud: *baud, loop: *loop}
+	cfg := soltest.Config{
+		Username:     *user,
+		Password:     *password,
+		Echo:         *echo,
+		V15:          *v15,
+		Manufacturer: uint32(*manufacturer),
+		NoIntegrity:  *noIntegrity,
+		OnPower: func(action sol.ChassisAction) {
+			log.Infof("Chassis control: action 0x%02X", uint8(action))
+			switch action {
+			case sol.ChassisPowerOn, sol.ChassisPowerCycle, sol.ChassisHardReset:
+				r.start()
+			default:
+				r.stop()
+			}
+		},
+	}
+	if *kg != "" {
+		cfg.Kg = []byte(*kg)
+	}
+	bmc := soltest.NewBMC(cfg)
+	r.bmc = bmc
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		r.stop()
+		bmc.Close()
+	}()
+
+	log.Infof("Fake BMC listening on %s (user %s)", pc.LocalAddr(), *user)
+	r.start()
+	if err := bmc.Serve(pc); err != nil {
+		log.Fatalf("Serve failed: %v", err)
+	}
+	log.Infof("Stats: %+v", bmc.Stats())
+}
+
+// replayer writes the boot to the BMC's console, one boot at a time.
+type replayer struct {
+	bmc  *soltest.BMC
+	boot []byte
+	baud int
+	loop time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// start begins a fresh boot, abandoning any under way.
+func (r *replayer) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.run(ctx)
+}
+
+func (r *replayer) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+func (r *replayer) run(ctx context.Context) {
+	for {
+		log.Info("Booting")
+		// A line at a time, at the pace the serial line would allow
+		for data := r.boot; len(data) > 0; {
+			n := bytes.IndexByte(data, '\n') + 1
+			if n == 0 {
+				n = len(data)
+			}
+			r.bmc.Console(data[:n])
+			data = data[n:]
+			if r.baud > 0 {
+				select {
+				case <-time.After(time.Duration(n) * 10 * time.Second / time.Duration(r.baud)):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if r.loop <= 0 {
+			return
+		}
+		select {
+		case <-time.After(r.loop):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"ipmiserial/websocket"
+)
+
+const escapeUsage = "Supported escape sequences:\r\n" +
+	"  ~.  detach\r\n" +
+	"  ~B  send a break\r\n" +
+	"  ~?  this help\r\n" +
+	"  ~~  send a literal ~\r\n"
+
+// escaper finds ssh-style escapes (~ at the start of a line) in typed
+// input, as ipmitool sol activate does.
+type escaper struct {
+	lineStart bool
+	tilde     bool // ~ seen at the start of a line
+}
+
+// escapeAction is what an escape sequence asks for.
+type escapeAction int
+
+const (
+	escapeNone escapeAction = iota
+	escapeDetach
+	escapeBreak
+	escapeShowHelp
+)
+
+// feed splits typed bytes into input to send and, if one was completed,
+// an escape action; input after the escape is returned in rest.
+func (e *escaper) feed(data []byte) (input []byte, action escapeAction, rest []byte) {
+	for i, b := range data {
+		if e.tilde {
+			e.tilde = false
+			switch b {
+			case '.':
+				return input, escapeDetach, data[i+1:]
+			case 'B':
+				return input, escapeBreak, data[i+1:]
+			case '?':
+				return input, escapeShowHelp, data[i+1:]
+			case '~':
+				input = append(input, '~')
+				e.lineStart = false
+				continue
+			}
+			input = append(input, '~')
+		} else if e.lineStart && b == '~' {
+			e.tilde = true
+			continue
+		}
+		input = append(input, b)
+		e.lineStart = b == '\r' || b == '\n'
+	}
+	return input, escapeNone, nil
+}
+
+func cmdAttach(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("attach", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Take console input over from another client")
+	watch := fs.Bool("watch", false, "View only, without taking input")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected a server name")
+	}
+	name := fs.Arg(0)
+	query := url.Values{}
+	if *force {
+		query.Set("force", "true")
+	}
+	if *watch {
+		query.Set("watch", "true")
+	}
+
+	conn, err := c.dial(ctx, serverPath(name, "/attach"), query)
+	if err != nil {
+		return err
+	}
+
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ipmiserial] stdin is not a terminal (%v); input is line-buffered\n", err)
+	} else {
+		defer restore()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// cur is the connection keystrokes go to, replaced on reconnect
+	var cur atomic.Pointer[websocket.Conn]
+	go func() {
+		defer cancel()
+		esc := &escaper{lineStart: true}
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			conn := cur.Load()
+			data := buf[:n]
+			for len(data) > 0 {
+				input, action, rest := esc.feed(data)
+				data = rest
+				if len(input) > 0 {
+					conn.WriteMessage(websocket.OpBinary, input)
+				}
+				switch action {
+				case escapeDetach:
+					return
+				case escapeBreak:
+					conn.WriteMessage(websocket.OpText, []byte(`{"type":"break"}`))
+				case escapeShowHelp:
+					fmt.Fprint(os.Stderr, "\r\n"+escapeUsage)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		cur.Store(conn)
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		err := readConsole(conn)
+		stop()
+		conn.Close()
+		if ctx.Err() != nil {
+			fmt.Fprint(os.Stderr, "\r\n[ipmiserial] Detached\r\n")
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "\r\n[ipmiserial] %v; reconnecting\r\n", err)
+		for {
+			select {
+			case <-ctx.Done():
+				fmt.Fprint(os.Stderr, "\r\n[ipmiserial] Detached\r\n")
+				return nil
+			case <-time.After(2 * time.Second):
+			}
+			if conn, err = c.dial(ctx, serverPath(name, "/attach"), query); err == nil {
+				break
+			}
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				return err
+			}
+		}
+	}
+}
+
+// readConsole writes console output to stdout and reports events until
+// the connection ends.
+func readConsole(conn *websocket.Conn) error {
+	for {
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if op == websocket.OpBinary {
+			os.Stdout.Write(data)
+			continue
+		}
+		var ev struct {
+			Event  string `json:"event"`
+			Server string `json:"server"`
+			Holder string `json:"holder"`
+			Detail string `json:"detail"`
+		}
+		if json.Unmarshal(data, &ev) != nil {
+			continue
+		}
+		switch ev.Event {
+		case "connected":
+			fmt.Fprintf(os.Stderr, "[ipmiserial] Connected to %s console. Type ~. to detach, ~? for help.\r\n", ev.Server)
+			if ev.Detail != "" {
+				fmt.Fprintf(os.Stderr, "[ipmiserial] Input unavailable: %s\r\n", ev.Detail)
+			}
+		case "input_rejected":
+			fmt.Fprintf(os.Stderr, "\r\n[ipmiserial] Input rejected: %s\r\n", ev.Detail)
+		}
+	}
+}
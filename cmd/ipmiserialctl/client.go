@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ipmiserial/websocket"
+)
+
+// client talks to the ipmiserial REST API.
+type client struct {
+	base     string // scheme://host[:port], no trailing slash
+	token    string
+	user     string
+	password string
+	tls      *tls.Config
+	http     *http.Client
+}
+
+func newClient(base, token, user, password string, insecure bool) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	transport.TLSClientConfig = tlsConfig
+	return &client{
+		base:     strings.TrimSuffix(base, "/"),
+		token:    token,
+		user:     user,
+		password: password,
+		tls:      tlsConfig,
+		http:     &http.Client{Transport: transport},
+	}
+}
+
+// apiError is a problem+json error response.
+type apiError struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Code   string `json:"code"`
+}
+
+func (e *apiError) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.Title
+	}
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	return fmt.Sprintf("%s (%d)", msg, e.Status)
+}
+
+// request sends a request to an /api/v1 path. body is JSON-encoded unless
+// it is already an io.Reader. Non-2xx responses are returned as *apiError.
+func (c *client) request(ctx context.Context, method, path string, query url.Values, body interface{}, header http.Header) (*http.Response, error) {
+	u := c.base + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+		contentType = "text/plain"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.authorize(req.Header)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// authorize adds the client's credentials to a request's headers.
+func (c *client) authorize(h http.Header) {
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	} else if c.user != "" {
+		r := &http.Request{Header: h}
+		r.SetBasicAuth(c.user, c.password)
+	}
+}
+
+// responseError reads an error response into an *apiError.
+func responseError(resp *http.Response) error {
+	e := &apiError{}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, e) != nil || (e.Detail == "" && e.Title == "") {
+		e.Detail = strings.TrimSpace(string(data))
+	}
+	e.Status = resp.StatusCode
+	return e
+}
+
+// dial opens a WebSocket to an /api/v1 path. A refused upgrade is returned
+// as *apiError.
+func (c *client) dial(ctx context.Context, path string, query url.Values) (*websocket.Conn, error) {
+	u := c.base + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	header := http.Header{}
+	c.authorize(header)
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, resp, err := websocket.Dial(dialCtx, u, header, c.tls)
+	if err == websocket.ErrBadHandshake {
+		return nil, responseError(resp)
+	}
+	return conn, err
+}
+
+// call sends a request and decodes a JSON response into out, if not nil.
+func (c *client) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.request(ctx, method, path, query, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// sseEvent is one server-sent event. Name is "message" for plain data
+// frames.
+type sseEvent struct {
+	Name string
+	ID   string
+	Data string
+}
+
+// stream follows an SSE endpoint, calling fn for each event, until ctx is
+// done or fn returns false. A dropped stream is reopened, passing the last
+// event id back so the server can resume where it left off.
+func (c *client) stream(ctx context.Context, path string, query url.Values, fn func(sseEvent) bool) error {
+	lastID := ""
+	for {
+		header := http.Header{}
+		if lastID != "" {
+			header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := c.request(ctx, "GET", path, query, nil, header)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if _, ok := err.(*apiError); ok {
+				return err
+			}
+		} else {
+			done := readEvents(resp.Body, func(ev sseEvent) bool {
+				if ev.ID != "" {
+					lastID = ev.ID
+				}
+				return fn(ev)
+			})
+			resp.Body.Close()
+			if done || ctx.Err() != nil {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// readEvents parses an SSE body. It returns true if fn asked to stop, false
+// if the stream ended.
+func readEvents(r io.Reader, fn func(sseEvent) bool) bool {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 4<<20)
+	var ev sseEvent
+	var data []string
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				if ev.Name == "" {
+					ev.Name = "message"
+				}
+				if !fn(ev) {
+					return true
+				}
+			}
+			ev, data = sseEvent{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Name = value
+		case "id":
+			ev.ID = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return false
+}
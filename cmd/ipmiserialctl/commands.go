@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// usageError reports bad arguments to a command.
+func usageError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, args...))
+}
+
+// parseFlags parses a command's flags, leaving its positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+	return nil
+}
+
+// serverPath is the API path of a server's resource.
+func serverPath(name, rest string) string {
+	return "/servers/" + url.PathEscape(name) + rest
+}
+
+type serverInfo struct {
+	Name          string `json:"name"`
+	IP            string `json:"ip"`
+	Online        bool   `json:"online"`
+	Connected     bool   `json:"connected"`
+	Standby       bool   `json:"standby"`
+	State         string `json:"state"`    // session state; empty from older servers
+	Attempts      int    `json:"attempts"` // connect attempts since the last success
+	LastError     string `json:"lastError"`
+	InputHolder   string `json:"inputHolder"`
+	RebootLooping bool   `json:"rebootLooping"`
+}
+
+func cmdList(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "Only servers whose name starts with this")
+	asJSON := fs.Bool("json", false, "Print the API's JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *prefix != "" {
+		query.Set("prefix", *prefix)
+	}
+	var servers []json.RawMessage
+	if err := c.call(ctx, "GET", "/servers", query, nil, &servers); err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(servers)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tIP\tSTATE\tINPUT\tERROR")
+	for _, raw := range servers {
+		var s serverInfo
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		state := "offline"
+		switch {
+		case s.RebootLooping:
+			state = "reboot-loop"
+		case s.State == "discovering" && !s.Online:
+			// offline
+		case s.State == "backoff" && s.Attempts > 0:
+			state = fmt.Sprintf("backoff (%d)", s.Attempts)
+		case s.State != "":
+			state = s.State
+		case s.Standby:
+			state = "standby"
+		case s.Connected:
+			state = "connected"
+		case s.Online:
+			state = "online"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.IP, state, s.InputHolder, s.LastError)
+	}
+	return tw.Flush()
+}
+
+func cmdTail(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	lines := fs.Int("n", 20, "Lines of the current log to print")
+	follow := fs.Bool("f", false, "Follow the console after the log")
+	raw := fs.Bool("raw", false, "With -f, pass console output through unmodified (escape sequences included) instead of as clean lines")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected a server name")
+	}
+	name := fs.Arg(0)
+
+	if *lines > 0 {
+		var files []string
+		if err := c.call(ctx, "GET", serverPath(name, "/logs"), nil, nil, &files); err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			resp, err := c.request(ctx, "GET", serverPath(name, "/logs/"+url.PathEscape(files[0])), nil, nil, nil)
+			if err != nil {
+				return err
+			}
+			data, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(lastLines(data, *lines))
+		}
+	}
+	if !*follow {
+		return nil
+	}
+
+	query := url.Values{"catchup": {"none"}}
+	if *raw {
+		return c.stream(ctx, serverPath(name, "/stream"), query, func(ev sseEvent) bool {
+			if ev.Name == "message" {
+				if data, err := base64.StdEncoding.DecodeString(ev.Data); err == nil {
+					os.Stdout.Write(data)
+				}
+			}
+			return true
+		})
+	}
+	query.Set("channels", "dedup")
+	return c.stream(ctx, serverPath(name, "/stream"), query, func(ev sseEvent) bool {
+		var line struct {
+			Text   string `json:"text"`
+			Repeat int    `json:"repeat"`
+		}
+		switch ev.Name {
+		case "line":
+			if json.Unmarshal([]byte(ev.Data), &line) == nil {
+				fmt.Println(line.Text)
+			}
+		case "repeat":
+			if json.Unmarshal([]byte(ev.Data), &line) == nil {
+				fmt.Printf("[last line repeated %d times]\n", line.Repeat)
+			}
+		}
+		return true
+	})
+}
+
+// lastLines returns the last n lines of data.
+func lastLines(data []byte, n int) []byte {
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			n--
+			if n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+func cmdPower(ctx context.Context, c *client, args []string) error {
+	if len(args) != 2 {
+		return usageError("expected a server name and an action")
+	}
+	name, action := args[0], args[1]
+	if action == "status" {
+		var resp struct {
+			PoweredOn bool `json:"poweredOn"`
+		}
+		if err := c.call(ctx, "GET", serverPath(name, "/power"), nil, nil, &resp); err != nil {
+			return err
+		}
+		if resp.PoweredOn {
+			fmt.Printf("%s: on\n", name)
+		} else {
+			fmt.Printf("%s: off\n", name)
+		}
+		return nil
+	}
+	if err := c.call(ctx, "POST", serverPath(name, "/power"), nil, map[string]string{"action": action}, nil); err != nil {
+		return err
+	}
+	fmt.Printf("%s: power %s sent\n", name, action)
+	return nil
+}
+
+type viewerInfo struct {
+	Who        string    `json:"who"`
+	Via        string    `json:"via"`
+	Since      time.Time `json:"since"`
+	ReadOnly   bool      `json:"readOnly"`
+	Holder     bool      `json:"holder"`
+	Controller bool      `json:"controller"`
+}
+
+func cmdViewers(ctx context.Context, c *client, args []string) error {
+	if len(args) != 1 {
+		return usageError("expected a server name")
+	}
+	var resp struct {
+		Viewers []viewerInfo `json:"viewers"`
+	}
+	if err := c.call(ctx, "GET", serverPath(args[0], "/viewers"), nil, nil, &resp); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "WHO\tVIA\tSINCE\tMODE")
+	for _, v := range resp.Viewers {
+		mode := "read-write"
+		switch {
+		case v.Holder:
+			mode = "holds input"
+		case v.ReadOnly:
+			mode = "read-only"
+		}
+		if v.Controller && !v.Holder {
+			mode += ", typing"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.Who, v.Via, v.Since.Local().Format("2006-01-02 15:04:05"), mode)
+	}
+	return tw.Flush()
+}
+
+func cmdLogs(ctx context.Context, c *client, args []string) error {
+	if len(args) == 0 {
+		return usageError("expected list or download")
+	}
+	switch args[0] {
+	case "list":
+		if len(args) != 2 {
+			return usageError("expected a server name")
+		}
+		var files []string
+		if err := c.call(ctx, "GET", serverPath(args[1], "/logs"), nil, nil, &files); err != nil {
+			return err
+		}
+		for _, f := range files {
+			fmt.Println(f)
+		}
+		return nil
+	case "download":
+		return downloadLogs(ctx, c, args[1:])
+	}
+	return usageError("unknown logs command %q", args[0])
+}
+
+func downloadLogs(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("logs download", flag.ContinueOnError)
+	dir := fs.String("o", ".", "Directory to write the logs to")
+	raw := fs.Bool("raw", false, "Download the raw SOL captures instead of the cleaned logs")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return usageError("expected a server name")
+	}
+	name, files := fs.Arg(0), fs.Args()[1:]
+	if len(files) == 0 {
+		if err := c.call(ctx, "GET", serverPath(name, "/logs"), nil, nil, &files); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+
+	var query url.Values
+	if *raw {
+		query = url.Values{"raw": {"true"}}
+	}
+	for _, f := range files {
+		if f != filepath.Base(f) || strings.HasPrefix(f, ".") {
+			return fmt.Errorf("refusing to write log file %q", f)
+		}
+		resp, err := c.request(ctx, "GET", serverPath(name, "/logs/"+url.PathEscape(f)), query, nil, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		dest := filepath.Join(*dir, f)
+		if *raw {
+			dest = strings.TrimSuffix(dest, ".log") + ".raw"
+		}
+		err = writeFile(dest, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Println(dest)
+	}
+	return nil
+}
+
+// writeFile writes r to path, removing a partial file on error.
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,97 @@
+// Command ipmiserialctl is a command-line client for the ipmiserial REST
+// API, for operators on a jump host without a browser:
+//
+//	ipmiserialctl list
+//	ipmiserialctl tail -f node1
+//	ipmiserialctl attach node1
+//	ipmiserialctl power node1 cycle
+//	ipmiserialctl logs download -o ./node1-logs node1
+//
+// The server and credentials come from -url, -token and -user, or the
+// IPMISERIAL_URL, IPMISERIAL_TOKEN, IPMISERIAL_USER and IPMISERIAL_PASSWORD
+// environment variables.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `Usage: ipmiserialctl [flags] <command> [args]
+
+Commands:
+  list                         List servers
+  tail [-n N] [-f] <server>    Print the end of a server's console log, -f to follow
+  attach [-force] [-watch] <server>
+                               Interactive console; ~. detaches, ~? lists escapes
+  power <server> <action>      Power on, off, cycle, reset, soft or status
+  viewers <server>             Who is watching or attached to a console
+  logs list <server>           List a server's log files
+  logs download [-o DIR] <server> [file...]
+                               Download a server's logs (all of them by default)
+
+Flags:
+`
+
+type command func(ctx context.Context, c *client, args []string) error
+
+var commands = map[string]command{
+	"list":    cmdList,
+	"tail":    cmdTail,
+	"attach":  cmdAttach,
+	"power":   cmdPower,
+	"viewers": cmdViewers,
+	"logs":    cmdLogs,
+}
+
+// errUsage reports bad arguments; the usage text is printed with it.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	baseURL := flag.String("url", envOr("IPMISERIAL_URL", "http://localhost:8080"), "ipmiserial server URL ($IPMISERIAL_URL)")
+	token := flag.String("token", os.Getenv("IPMISERIAL_TOKEN"), "API token ($IPMISERIAL_TOKEN)")
+	user := flag.String("user", os.Getenv("IPMISERIAL_USER"), "Basic auth user, with the password in $IPMISERIAL_PASSWORD ($IPMISERIAL_USER)")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ipmiserialctl: unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := newClient(*baseURL, *token, *user, os.Getenv("IPMISERIAL_PASSWORD"), *insecure)
+	if err := cmd(ctx, c, flag.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "ipmiserialctl %s: %v\n\n", flag.Arg(0), err)
+			flag.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "ipmiserialctl %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
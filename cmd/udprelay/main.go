@@ -0,0 +1,34 @@
+// Command udprelay runs on an SSH jump host and carries one BMC socket for
+// ipmiserial: datagrams framed on stdin are sent to the BMC, and its
+// replies are framed on stdout. ipmiserial starts it over SSH with the
+// BMC's address when a server's proxy is an SSH jump host:
+//
+//	udprelay 10.0.10.21:623
+//
+// Install it on the jump host's PATH, or set the proxy's relay command.
+// It exits when ipmiserial closes the socket.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"ipmiserial/bmcproxy"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: udprelay host:port")
+		os.Exit(2)
+	}
+	conn, err := net.Dial("udp", os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "udprelay: %v\n", err)
+		os.Exit(1)
+	}
+	if err := bmcproxy.Relay(os.Stdin, os.Stdout, conn); err != nil {
+		fmt.Fprintf(os.Stderr, "udprelay: %v\n", err)
+		os.Exit(1)
+	}
+}
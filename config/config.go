@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"time"
 
@@ -9,41 +10,589 @@ import (
 
 type Config struct {
 	IPMI            IPMIConfig            `yaml:"ipmi"`
+	Vault           VaultConfig           `yaml:"vault"`
 	Servers         []ServerEntry         `yaml:"servers"`
 	Discovery       DiscoveryConfig       `yaml:"discovery"`
 	RebootDetection RebootDetectionConfig `yaml:"reboot_detection"`
 	Logs            LogsConfig            `yaml:"logs"`
 	Server          ServerConfig          `yaml:"server"`
+	SEL             SELConfig             `yaml:"sel"`
+	Playbooks       []Playbook            `yaml:"playbooks"`
+	Auth            AuthConfig            `yaml:"auth"`
+	SSH             SSHConfig             `yaml:"ssh"`
+	ConsoleProxy    ConsoleProxyConfig    `yaml:"console_proxy"`
+	Conserver       ConserverConfig       `yaml:"conserver"`
+	Alerts          AlertsConfig          `yaml:"alerts"`
+	Events          EventsConfig          `yaml:"events"`
+	MQTT            MQTTConfig            `yaml:"mqtt"`
+	Prune           PruneConfig           `yaml:"prune"`
+	Analytics       AnalyticsConfig       `yaml:"analytics"`
+	Sensors         SensorsConfig         `yaml:"sensors"`
+	Power           PowerConfig           `yaml:"power"`
+	Telemetry       TelemetryConfig       `yaml:"telemetry"`
 }
 
 type ServerEntry struct {
-	Name string   `yaml:"name"`
-	Host string   `yaml:"host"`
-	MACs []string `yaml:"macs"` // List of MAC addresses for this server
+	Name     string   `yaml:"name"`
+	Host     string   `yaml:"host"`
+	MACs     []string `yaml:"macs"`     // List of MAC addresses for this server
+	Username string   `yaml:"username"` // Optional BMC username (overrides ipmi.username)
+	Password string   `yaml:"password"` // Optional BMC password (overrides ipmi.password)
+	Kg       string   `yaml:"kg"`       // Optional BMC key for two-key auth (overrides ipmi.kg; "0x" prefix = hex)
+	Port     int      `yaml:"port"`     // Optional IPMI UDP port (default 623)
+
+	Transport string `yaml:"transport"` // Optional SOL transport: native or ipmitool (overrides ipmi.transport)
+
+	RetentionDays int     `yaml:"retention_days"` // Optional log retention (overrides logs.retention_days)
+	ScrollbackMB  float64 `yaml:"scrollback_mb"`  // Optional console scrollback held in memory (overrides logs.scrollback_mb)
+
+	Serial  *SerialConfig  `yaml:"serial"`  // Optional serial MUX/UART selection (replaces ipmi.serial)
+	Backoff *BackoffConfig `yaml:"backoff"` // Optional reconnect backoff; unset fields inherit ipmi.backoff
+	Proxy   *ProxyConfig   `yaml:"proxy"`   // Optional route to the BMC (replaces ipmi.proxy; type none = direct)
+	Quirks  *QuirksConfig  `yaml:"quirks"`  // Optional BMC handshake quirks; unset fields inherit ipmi.quirks
+
+	Connection *ConnectionConfig `yaml:"connection"` // Optional timeouts, keepalive and cipher suite; unset fields inherit ipmi.connection
+
+	Labels map[string]string `yaml:"labels"` // Optional labels, e.g. rack: r12 (see server.group_label)
 }
 
 type IPMIConfig struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	Kg       string `yaml:"kg"` // BMC key (Kg) for two-key RAKP; empty = password ("0x" prefix = hex)
+
+	// Outbound SOL retransmission: resends of an unacknowledged packet
+	// (0 = default 7, negative = none) and the ACK wait (0 = default 500ms).
+	SOLRetries       int           `yaml:"sol_retries"`
+	SOLRetryInterval time.Duration `yaml:"sol_retry_interval"`
+
+	// Session attempts allowed against one BMC in any minute, across
+	// reconnects, restarts and command-only sessions (0 = unlimited), so
+	// BMCs that lock out rapid logins aren't tripped.
+	AttemptsPerMinute int `yaml:"attempts_per_minute"`
+
+	// Sessions connecting (clearing stale sessions, authenticating and
+	// activating SOL) at once across all BMCs (0 = unlimited); the rest
+	// queue, so a large fleet starting up doesn't flood the management
+	// network.
+	MaxConnecting int `yaml:"max_connecting"`
+
+	// Connect attempts kept per server in <logs>/<server>/history.json
+	ConnectHistory int `yaml:"connect_history"`
+
+	// Where BMC sockets (SOL, command sessions, probes) send from, for
+	// management networks that only admit known source ports: the local
+	// IP, a port or "low-high" range each socket takes a free port from,
+	// and the DSCP marked on their packets. Empty or 0 = any / unmarked.
+	SourceAddress string `yaml:"source_address"`
+	SourcePorts   string `yaml:"source_ports"`
+	DSCP          int    `yaml:"dscp"`
+
+	// Route to BMC networks ipmiserial can't reach directly
+	Proxy ProxyConfig `yaml:"proxy"`
+
+	// How SOL consoles are reached: native (go-sol, the default) or
+	// ipmitool, which runs ipmitool sol activate under a PTY for BMCs the
+	// native stack can't handle.
+	Transport string         `yaml:"transport"`
+	IPMITool  IPMIToolConfig `yaml:"ipmitool"`
+
+	SOLConfig  SOLConfig        `yaml:"sol_config"`
+	Serial     SerialConfig     `yaml:"serial"`
+	Backoff    BackoffConfig    `yaml:"backoff"`
+	Quirks     QuirksConfig     `yaml:"quirks"`
+	Connection ConnectionConfig `yaml:"connection"`
+}
+
+// IPMIToolConfig runs ipmitool for the ipmitool transport. The BMC's
+// address, port and credentials are added; the password and BMC key are
+// passed in the environment (-E, -K).
+type IPMIToolConfig struct {
+	Path        string   `yaml:"path"`         // executable (default ipmitool on PATH)
+	Interface   string   `yaml:"interface"`    // -I: lanplus (default) or lan for IPMI v1.5
+	CipherSuite int      `yaml:"cipher_suite"` // -C (0 = ipmitool's default)
+	Args        []string `yaml:"args"`         // more options, before sol activate
+}
+
+// QuirksConfig adjusts the SOL handshake to a BMC's firmware. Profile
+// names a built-in set of quirks; auto picks one from the BMC's Get Device
+// ID. The other fields override the profile's.
+type QuirksConfig struct {
+	Profile            string        `yaml:"profile"`              // auto (default), generic, dell, supermicro or hpe
+	Privilege          string        `yaml:"privilege"`            // when privilege is raised: first, before_activate or skip
+	ActivateRetries    int           `yaml:"activate_retries"`     // retries of an activation refused as already active (negative = none)
+	ActivateRetryDelay time.Duration `yaml:"activate_retry_delay"` // wait before each retry
+	SOLInstance        int           `yaml:"sol_instance"`         // SOL payload instance to activate, 1-15
+	DeactivateActive   *bool         `yaml:"deactivate_active"`    // deactivate every SOL instance the BMC reports active first
+}
+
+// ConnectionConfig tunes SOL sessions' connections to their BMCs: how
+// long connecting may take, how long a BMC may stay silent before the
+// session reconnects, how often an idle session is probed, and the RMCP+
+// cipher suite proposed. Chatty consoles suit a short inactivity timeout;
+// silent machines and slow BMC networks a longer one.
+type ConnectionConfig struct {
+	Timeout           time.Duration `yaml:"timeout"`            // authentication to SOL activation (default 30s)
+	InactivityTimeout time.Duration `yaml:"inactivity_timeout"` // BMC silence before reconnecting (default 2m)
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval"` // Get Device ID on an idle session (default inactivity_timeout/3, at least 10s)
+	CipherSuite       int           `yaml:"cipher_suite"`       // preferred: 1 (default) or 2, adding HMAC-SHA1-96 integrity; BMCs refusing 2 get 1
+	AllowV15          *bool         `yaml:"allow_v15"`          // accept IPMI v1.5 (MD5, no encryption) from a BMC without RMCP+ (default false)
+}
+
+// BackoffConfig spaces a session's reconnect attempts: the wait starts at
+// Min and doubles after each failure up to Max, with a random Jitter
+// fraction taken off so servers that dropped together don't reconnect
+// together.
+type BackoffConfig struct {
+	Min    time.Duration `yaml:"min"`    // first wait (default 1s)
+	Max    time.Duration `yaml:"max"`    // longest wait (default 60s)
+	Reset  time.Duration `yaml:"reset"`  // a connection that lasted this long starts again from min (default 30s)
+	Jitter float64       `yaml:"jitter"` // fraction of each wait randomised away, 0-1 (default 0.2)
 }
 
+// ProxyConfig reaches BMCs through a SOCKS5 proxy or an SSH jump host.
+// SOCKS5 carries IPMI's UDP with UDP ASSOCIATE. SSH carries no UDP, so
+// each BMC socket runs relay on the jump host (cmd/udprelay), which passes
+// datagrams over the session. Redfish session clearing goes through too.
+type ProxyConfig struct {
+	Type                  string `yaml:"type"`                     // socks5, ssh or none (default: direct)
+	Address               string `yaml:"address"`                  // proxy or jump host, host:port (default port 1080 / 22)
+	Username              string `yaml:"username"`                 // SOCKS5 (optional) or SSH user
+	Password              string `yaml:"password"`                 // SOCKS5 password, or SSH password login
+	KeyFile               string `yaml:"key_file"`                 // SSH private key
+	KnownHostsFile        string `yaml:"known_hosts_file"`         // SSH: known_hosts file the jump host's key is checked against
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"` // SSH: accept any jump host key
+	Relay                 string `yaml:"relay"`                    // SSH: command run with the BMC's host:port (default udprelay)
+}
+
+// SerialConfig routes a server's serial console to SOL before activation,
+// for boards whose SOL defaults to a serial port nothing is attached to.
+type SerialConfig struct {
+	Mux         string `yaml:"mux"`          // Set Serial/Modem Mux: system, bmc, force-system or force-bmc (empty = leave alone)
+	Channel     int    `yaml:"channel"`      // serial channel (0 = the first RS-232 channel)
+	UARTCommand string `yaml:"uart_command"` // raw IPMI request selecting the console UART, "netfn cmd data..." in hex (vendor-specific)
+}
+
+// SOLConfig holds SOL configuration parameters written to each BMC before
+// SOL is activated, e.g. to fix garbled output from a BMC left at 9600
+// baud. Unset fields leave the BMC's setting alone.
+type SOLConfig struct {
+	BitRate            int           `yaml:"bit_rate"`            // non-volatile bit rate, baud: 9600, 19200, 38400, 57600 or 115200
+	VolatileBitRate    int           `yaml:"volatile_bit_rate"`   // bit rate until the BMC resets (default bit_rate)
+	RetryCount         int           `yaml:"retry_count"`         // BMC resends of an unacknowledged packet, 1-7 (negative = none)
+	RetryInterval      time.Duration `yaml:"retry_interval"`      // between BMC resends, 10ms steps
+	AccumulateInterval time.Duration `yaml:"accumulate_interval"` // how long the BMC gathers characters before sending, 5ms steps
+	SendThreshold      int           `yaml:"send_threshold"`      // characters that make the BMC send at once, 1-255
+	AutoEnable         bool          `yaml:"auto_enable"`         // enable SOL on the BMC and for the user when activation finds it disabled (0x81)
+}
+
+// VaultConfig reads per-server BMC credentials from a HashiCorp Vault KV v2
+// secrets engine: <mount>/data/<path>/<server name>, with username,
+// password and optional kg keys. They take precedence over discovered and
+// configured credentials.
+type VaultConfig struct {
+	Address            string        `yaml:"address"`              // e.g. https://vault:8200 (empty = disabled)
+	Token              string        `yaml:"token"`                // static token; or role_id and secret_id
+	RoleID             string        `yaml:"role_id"`              // AppRole login
+	SecretID           string        `yaml:"secret_id"`            // with role_id
+	AppRoleMount       string        `yaml:"approle_mount"`        // default approle
+	Namespace          string        `yaml:"namespace"`            // Vault Enterprise namespace
+	Mount              string        `yaml:"mount"`                // KV v2 mount (default secret)
+	Path               string        `yaml:"path"`                 // under the mount; the server name is appended (default ipmiserial)
+	CacheTTL           time.Duration `yaml:"cache_ttl"`            // re-read cached credentials this often (default 5m)
+	CAFile             string        `yaml:"ca_file"`              // default system roots
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"` // don't verify the Vault certificate
+}
+
+// DiscoveryConfig configures where servers are discovered. The inline
+// fields describe a single source; sources lists several, each merged into
+// one inventory.
 type DiscoveryConfig struct {
-	BMHURL    string `yaml:"bmh_url"`
-	Namespace string `yaml:"namespace"` // filter BMH by namespace (e.g. "g11")
+	DiscoverySource `yaml:",inline"`
+	Sources         []DiscoverySource `yaml:"sources"`
+	Probe           ProbeConfig       `yaml:"probe"`
+	CacheKey        string            `yaml:"cache_key"` // encrypts bmh-cache.json (default $IPMISERIAL_CACHE_KEY; empty = plaintext)
+}
+
+// ProbeConfig controls BMC reachability probing. A server whose BMC misses
+// Failures probes in a row is marked offline until it answers again.
+type ProbeConfig struct {
+	Interval time.Duration `yaml:"interval"` // 0 disables probing
+	Timeout  time.Duration `yaml:"timeout"`
+	Failures int           `yaml:"failures"`
+}
+
+// DiscoverySource is one BMH endpoint.
+type DiscoverySource struct {
+	Name       string           `yaml:"name"`
+	Mode       string           `yaml:"mode"` // "mkube" (default) or "kubernetes"
+	BMHURL     string           `yaml:"bmh_url"`
+	Namespace  string           `yaml:"namespace"` // filter BMH by namespace (e.g. "g11")
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+	Username   string           `yaml:"username"` // BMC credentials for hosts that carry none
+	Password   string           `yaml:"password"`
+}
+
+// AllSources returns the configured sources, or the single inline source
+// (named "default") when sources is empty. Unnamed sources are numbered.
+func (d DiscoveryConfig) AllSources() []DiscoverySource {
+	if len(d.Sources) > 0 {
+		sources := make([]DiscoverySource, len(d.Sources))
+		for i, src := range d.Sources {
+			if src.Name == "" {
+				src.Name = fmt.Sprintf("source-%d", i+1)
+			}
+			sources[i] = src
+		}
+		return sources
+	}
+	src := d.DiscoverySource
+	if src.Name == "" {
+		src.Name = "default"
+	}
+	return []DiscoverySource{src}
+}
+
+// KubernetesConfig points discovery at a Kubernetes API server with metal3
+// BareMetalHost CRDs. Empty fields default to the in-cluster ServiceAccount.
+type KubernetesConfig struct {
+	APIServer          string `yaml:"api_server"`           // default https://$KUBERNETES_SERVICE_HOST:$KUBERNETES_SERVICE_PORT
+	Token              string `yaml:"token"`                // bearer token; overrides token_file
+	TokenFile          string `yaml:"token_file"`           // default ServiceAccount token, re-read on every request
+	CAFile             string `yaml:"ca_file"`              // default ServiceAccount CA
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // don't verify the API server certificate
+	LabelSelector      string `yaml:"label_selector"`       // e.g. "ipmiserial=enabled"
 }
 
 type RebootDetectionConfig struct {
 	SOLPatterns         []string      `yaml:"sol_patterns"`
-	ChassisPollInterval time.Duration `yaml:"chassis_poll_interval"`
+	ChassisPollInterval time.Duration `yaml:"chassis_poll_interval"` // power-on polling for powered-off servers on SOL standby (0 = no standby)
+	LoopReboots         int           `yaml:"loop_reboots"`          // boots within LoopWindow that make a reboot loop (0 = off)
+	LoopWindow          time.Duration `yaml:"loop_window"`
+	LoopPauseRotation   bool          `yaml:"loop_pause_rotation"` // refuse log rotation while looping, keeping the loop in one file
 }
 
 type LogsConfig struct {
-	Path          string `yaml:"path"`
-	RetentionDays int    `yaml:"retention_days"`
+	Path           string                `yaml:"path"`
+	RetentionDays  int                   `yaml:"retention_days"`
+	MaxFileSizeMB  int                   `yaml:"max_file_size_mb"`    // rotate current.log past this size (0 = unlimited)
+	CompressDays   int                   `yaml:"compress_after_days"` // gzip rotated logs older than this (0 = never)
+	MaxTotalSizeGB float64               `yaml:"max_total_size_gb"`   // prune the oldest rotated logs across servers past this total (0 = no quota)
+	RawCapture     bool                  `yaml:"raw_capture"`         // keep the untouched SOL stream in <name>.raw
+	PendingFlush   time.Duration         `yaml:"pending_flush"`       // write console rows drawn but not finished after this long idle (0 = wait for more data)
+	ScrollbackMB   float64               `yaml:"scrollback_mb"`       // raw console output held in memory per server, for catchup and /scrollback
+	Daemon         DaemonLogConfig       `yaml:"daemon"`              // ipmiserial's own log (ipmiserial.log)
+	Loki           LokiConfig            `yaml:"loki"`                // push cleaned console lines to Grafana Loki
+	Syslog         SyslogConfig          `yaml:"syslog"`              // forward cleaned console lines to syslog
+	NATS           NATSConfig            `yaml:"nats"`                // publish console output to NATS JetStream
+	Sinks          map[string]SinkConfig `yaml:"sinks"`               // per-sink queue and enable flag, by sink name (loki, syslog)
+}
+
+// SinkConfig tunes a log sink: a destination console output is fanned out
+// to besides the log files.
+type SinkConfig struct {
+	Enabled   *bool `yaml:"enabled"`    // default true; reloadable
+	QueueSize int   `yaml:"queue_size"` // chunks buffered before new ones are dropped (default 1024)
+}
+
+// SyslogConfig forwards cleaned console lines to a syslog server as RFC 5424
+// messages, one per line, with the server name as HOSTNAME.
+type SyslogConfig struct {
+	Address  string `yaml:"address"`  // udp://host:514 or tcp://host:601 (empty = disabled)
+	Facility string `yaml:"facility"` // e.g. local0, daemon
+	Tag      string `yaml:"tag"`      // APP-NAME
+}
+
+// NATSConfig publishes console output to NATS subjects
+// <subject_prefix>.<server>.cleaned (one message per line) and .raw (SOL
+// output as received), persisted by a JetStream stream.
+type NATSConfig struct {
+	URL                string            `yaml:"url"`      // nats://host:4222 or tls://host:4222 (empty = disabled)
+	Username           string            `yaml:"username"` // or credentials in the URL
+	Password           string            `yaml:"password"`
+	Token              string            `yaml:"token"`                // instead of username/password
+	SubjectPrefix      string            `yaml:"subject_prefix"`       // default ipmiserial.console
+	Output             string            `yaml:"output"`               // cleaned, raw, both or none (default cleaned)
+	Servers            map[string]string `yaml:"servers"`              // per-server output, overriding output
+	Stream             string            `yaml:"stream"`               // JetStream stream, created if missing (default IPMISERIAL_CONSOLE)
+	MaxAge             time.Duration     `yaml:"max_age"`              // retention of a created stream (default 168h)
+	MaxMB              int               `yaml:"max_mb"`               // size limit of a created stream (0 = unlimited)
+	Replicas           int               `yaml:"replicas"`             // replicas of a created stream (default 1)
+	CAFile             string            `yaml:"ca_file"`              // for tls://; default system roots
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify"` // don't verify the server certificate
+}
+
+// LokiConfig pushes cleaned console lines to Grafana Loki, labelled
+// {server, source="sol"} plus Labels. Batches that cannot be delivered are
+// spooled to disk and replayed once Loki is back.
+type LokiConfig struct {
+	URL         string            `yaml:"url"`       // e.g. http://loki:3100 (empty = disabled)
+	TenantID    string            `yaml:"tenant_id"` // sent as X-Scope-OrgID
+	Username    string            `yaml:"username"`  // basic auth
+	Password    string            `yaml:"password"`
+	BearerToken string            `yaml:"bearer_token"` // instead of basic auth
+	Labels      map[string]string `yaml:"labels"`       // extra static labels
+	BatchSize   int               `yaml:"batch_size"`   // lines per push
+	BatchWait   time.Duration     `yaml:"batch_wait"`   // longest a line waits before a push
+	SpoolDir    string            `yaml:"spool_dir"`    // default <data>/loki-spool
+	SpoolMaxMB  int               `yaml:"spool_max_mb"` // oldest spooled batches are dropped past this
+}
+
+// DaemonLogConfig controls ipmiserial's own log, ipmiserial.log in the log
+// directory.
+type DaemonLogConfig struct {
+	Format     string `yaml:"format"`       // text (default) or json
+	Level      string `yaml:"level"`        // debug, info (default), warn or error
+	MaxSizeMB  int    `yaml:"max_size_mb"`  // rotate past this size (0 = never)
+	MaxAgeDays int    `yaml:"max_age_days"` // delete rotated files older than this (0 = keep)
+	MaxBackups int    `yaml:"max_backups"`  // rotated files to keep (0 = all)
+}
+
+// AuthConfig protects the HTTP API. Once any credential is configured, /api
+// and /htmx (including console streams) require one of them or a scoped API
+// key created through /api/admin/keys, except for Exempt routes.
+type AuthConfig struct {
+	AdminToken string      `yaml:"admin_token"` // full access, including key management
+	Tokens     []AuthToken `yaml:"tokens"`      // static bearer tokens
+	Users      []AuthUser  `yaml:"users"`       // HTTP basic auth users
+	Exempt     []string    `yaml:"exempt"`      // routes served without auth: "/api/version", "GET /api/servers/{name}/status"
+}
+
+// AuthToken is a static API token. Empty Scopes grants full access.
+type AuthToken struct {
+	Name     string   `yaml:"name"`
+	Token    string   `yaml:"token"`
+	Scopes   []string `yaml:"scopes"`    // servers, logs, analytics, control, read, admin
+	Servers  []string `yaml:"servers"`   // restrict to these servers or "label:<selector>" (empty = all)
+	ReadOnly *bool    `yaml:"read_only"` // overrides server.read_only for this token
+}
+
+// AuthUser is a basic auth user. Password may be given in plain text or as
+// a bcrypt hash ($2a$, $2b$ or $2y$, as htpasswd -B writes) in
+// PasswordBcrypt. Empty Scopes grants full access.
+type AuthUser struct {
+	Username       string   `yaml:"username"`
+	Password       string   `yaml:"password"`
+	PasswordBcrypt string   `yaml:"password_bcrypt"`
+	Scopes         []string `yaml:"scopes"`
+	Servers        []string `yaml:"servers"`   // as for tokens
+	ReadOnly       *bool    `yaml:"read_only"` // overrides server.read_only for this user
+}
+
+type SELConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"` // 0 disables SEL collection
+}
+
+// TelemetryConfig exports OpenTelemetry traces and metrics over OTLP/HTTP.
+type TelemetryConfig struct {
+	Endpoint       string            `yaml:"endpoint"`        // collector base URL, e.g. http://otel-collector:4318 (empty = disabled)
+	ServiceName    string            `yaml:"service_name"`    // service.name resource attribute
+	Headers        map[string]string `yaml:"headers"`         // sent with every export, e.g. auth
+	ExportInterval time.Duration     `yaml:"export_interval"` // how often spans and metrics are pushed
+}
+
+// SensorsConfig controls polling of BMC sensor readings (temperatures, fans,
+// voltages, PSU status).
+type SensorsConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"` // 0 disables sensor polling
+}
+
+// PowerConfig controls polling of DCMI power readings.
+type PowerConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"` // 0 disables power polling
+	History      int           `yaml:"history"`       // samples kept per server
+}
+
+// AnalyticsConfig controls boot analytics retention and persistence.
+type AnalyticsConfig struct {
+	BootHistory   int           `yaml:"boot_history"`   // past boots kept per server
+	FlushInterval time.Duration `yaml:"flush_interval"` // how often changed analytics are written
+}
+
+// PruneConfig controls archiving of servers that have left discovery.
+type PruneConfig struct {
+	After       time.Duration `yaml:"after"`        // absence before a server is archived; 0 = never
+	ArchivePath string        `yaml:"archive_path"` // cold storage; empty = <data>/archive
+}
+
+// Playbook is a named remediation sequence that can be run against a server.
+type Playbook struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	Steps       []PlaybookStep `yaml:"steps"`
+}
+
+// PlaybookStep is one action in a playbook. Which fields apply depends on Action:
+//
+//	power:    State (on, off, cycle, reset, soft)
+//	bootdev:  Device (pxe, disk, cdrom, bios), Persistent
+//	sleep:    Duration
+//	wait_for: Pattern, Timeout, OnSuccess, OnTimeout
+//	wait_boot: Timeout, OnSuccess, OnTimeout (a boot since the run started reaches the OS)
+//	notify:   Message
+//	rotate:   LogName (optional)
+//
+// OnSuccess/OnTimeout take "continue", "done", "fail", or the name of a step to jump to.
+type PlaybookStep struct {
+	Name       string        `yaml:"name"`
+	Action     string        `yaml:"action"`
+	State      string        `yaml:"state"`
+	Device     string        `yaml:"device"`
+	Persistent bool          `yaml:"persistent"`
+	Duration   time.Duration `yaml:"duration"`
+	Pattern    string        `yaml:"pattern"`
+	Timeout    time.Duration `yaml:"timeout"`
+	OnSuccess  string        `yaml:"on_success"`
+	OnTimeout  string        `yaml:"on_timeout"`
+	Message    string        `yaml:"message"`
+	Severity   string        `yaml:"severity"` // notify: alert severity (default warning)
+	Notify     []string      `yaml:"notify"`   // notify: alert notifier names; empty = all
+	LogName    string        `yaml:"log_name"`
 }
 
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	Port           int             `yaml:"port"`
+	SSECompression bool            `yaml:"sse_compression"` // gzip SSE and deflate WebSocket console streams for clients that accept it
+	Catchup        string          `yaml:"catchup"`         // initial screen for console streams: auto, screen, log, none
+	GRPCPort       int             `yaml:"grpc_port"`       // gRPC ConsoleService (proto/console.proto); 0 = off
+	ReadOnly       bool            `yaml:"read_only"`       // refuse mutating API calls (clear, rotate, power, input) with 403
+	GroupLabel     string          `yaml:"group_label"`     // label whose value groups servers in the UI (default group)
+	TLS            TLSConfig       `yaml:"tls"`
+	AccessLog      AccessLogConfig `yaml:"access_log"`
+	Streams        StreamsConfig   `yaml:"streams"`
+}
+
+// StreamsConfig bounds live streams (SSE, WebSocket attach and gRPC console
+// streams) and disconnects viewers that stop keeping up.
+type StreamsConfig struct {
+	MaxPerServer int           `yaml:"max_per_server"` // open streams per server (0 = unlimited)
+	MaxTotal     int           `yaml:"max_total"`      // open streams overall (0 = unlimited)
+	EvictAfter   time.Duration `yaml:"evict_after"`    // disconnect a viewer saturated or blocked this long (0 = never)
+}
+
+// AccessLogConfig controls per-request access logging to the daemon log.
+// With it off, requests are logged at debug level.
+type AccessLogConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Format  string   `yaml:"format"`  // text (default, fields in logs.daemon.format), clf (Apache combined) or json
+	Exclude []string `yaml:"exclude"` // path prefixes not logged (default /healthz, /readyz, /metrics)
+}
+
+// AlertsConfig defines console alert rules and where their notifications go.
+type AlertsConfig struct {
+	Rules        []AlertRule `yaml:"rules"`
+	Notifiers    []Notifier  `yaml:"notifiers"`
+	ExcerptLines int         `yaml:"excerpt_lines"` // console lines before a match included in the alert
+	ExcerptAfter int         `yaml:"excerpt_after"` // lines after a match to wait for (up to 5s)
+}
+
+// AlertRule fires on a console pattern, on a boot that runs too long, on
+// a reboot loop or on degraded power-on latency.
+// Count and Window turn a pattern into "N matches within Window" (e.g.
+// repeated PXE failures).
+type AlertRule struct {
+	Name            string        `yaml:"name"`
+	Severity        string        `yaml:"severity"` // free-form, e.g. critical, warning (default warning)
+	Pattern         string        `yaml:"pattern"`  // regexp matched against cleaned console lines
+	Count           int           `yaml:"count"`    // matches needed within Window (default 1)
+	Window          time.Duration `yaml:"window"`
+	BootTimeout     time.Duration `yaml:"boot_timeout"`      // fire when a boot hasn't completed after this long
+	RebootLoop      bool          `yaml:"reboot_loop"`       // fire when the server enters a reboot loop (reboot_detection.loop_reboots)
+	PowerOnDegraded bool          `yaml:"power_on_degraded"` // fire when a boot's power-on delay is well above the server's median
+	Servers         []string      `yaml:"servers"`           // empty = all servers
+	Notify          []string      `yaml:"notify"`            // notifier names; empty = all
+	Cooldown        time.Duration `yaml:"cooldown"`          // per server (default 15m)
+	Playbook        string        `yaml:"playbook"`          // optional playbook to run on the server
+}
+
+// Notifier delivers alerts. Type is webhook (JSON POST of the alert), slack
+// (incoming webhook URL) or email (SMTP).
+type Notifier struct {
+	Name     string            `yaml:"name"`
+	Type     string            `yaml:"type"`
+	URL      string            `yaml:"url"`
+	Headers  map[string]string `yaml:"headers"`   // extra webhook headers, e.g. Authorization
+	SMTPHost string            `yaml:"smtp_host"` // host:port
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	From     string            `yaml:"from"`
+	To       []string          `yaml:"to"`
+}
+
+// EventsConfig sends lifecycle events (discovery, sessions, boots, alerts)
+// to webhooks.
+type EventsConfig struct {
+	Webhooks []Webhook `yaml:"webhooks"`
+}
+
+// Webhook receives events as signed JSON POSTs.
+type Webhook struct {
+	Name       string            `yaml:"name"`
+	URL        string            `yaml:"url"`
+	Secret     string            `yaml:"secret"`      // HMAC-SHA256 key for X-Ipmiserial-Signature; "" = unsigned
+	Events     []string          `yaml:"events"`      // event types; empty = all
+	Servers    []string          `yaml:"servers"`     // empty = all servers
+	Headers    map[string]string `yaml:"headers"`     // extra headers, e.g. Authorization
+	MaxRetries *int              `yaml:"max_retries"` // retries after a failed delivery (default 5)
+}
+
+// MQTTConfig publishes per-server status, lifecycle events and optionally
+// console lines to an MQTT broker under <topic_prefix>/<server>/.
+type MQTTConfig struct {
+	Broker             string        `yaml:"broker"`    // tcp://host:1883 or tls://host:8883 (empty = disabled)
+	ClientID           string        `yaml:"client_id"` // default ipmiserial-<hostname>
+	Username           string        `yaml:"username"`
+	Password           string        `yaml:"password"`
+	TopicPrefix        string        `yaml:"topic_prefix"`         // default ipmiserial
+	QoS                int           `yaml:"qos"`                  // 0 or 1 for status and events; console lines use 0
+	Console            bool          `yaml:"console"`              // publish cleaned console lines to <prefix>/<server>/console
+	Servers            []string      `yaml:"servers"`              // empty = all servers
+	KeepAlive          time.Duration `yaml:"keepalive"`            // MQTT keep alive (default 60s)
+	StatusInterval     time.Duration `yaml:"status_interval"`      // republish every server's status this often (default 1m)
+	CAFile             string        `yaml:"ca_file"`              // for tls://; default system roots
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"` // don't verify the broker certificate
+}
+
+// SSHConfig enables the SSH console gateway: `ssh <server>@host -p <port>`.
+type SSHConfig struct {
+	Port               int      `yaml:"port"`                 // 0 = disabled
+	HostKey            string   `yaml:"host_key"`             // default <data>/ssh_host_ed25519_key, generated if missing
+	AuthorizedKeys     []string `yaml:"authorized_keys"`      // authorized_keys lines; the comment names the user in control banners
+	AuthorizedKeysFile string   `yaml:"authorized_keys_file"` // read in addition to authorized_keys
+}
+
+// ConsoleProxyConfig serves consoles to tools written for a BMC or a
+// terminal server: `ipmitool -I lanplus -H <address> sol activate` against an
+// IPMI listener, or telnet to a server's telnet port.
+type ConsoleProxyConfig struct {
+	Username string          `yaml:"username"` // login the IPMI listeners accept (RAKP user name)
+	Password string          `yaml:"password"`
+	Security string          `yaml:"security"` // cipher suites the IPMI listeners accept: integrity (default; 2, 3, 16, 17), encryption (AES: 3, 17) or none (all)
+	IPMI     []ProxyListener `yaml:"ipmi"`     // UDP listeners, usually an alias IP per server on port 623
+	Telnet   []ProxyListener `yaml:"telnet"`   // TCP telnet listeners, one port per server
+}
+
+// ProxyListener binds one server's console to an address.
+type ProxyListener struct {
+	Server  string `yaml:"server"`
+	Address string `yaml:"address"` // host:port or :port; IPMI defaults to port 623
+}
+
+// ConserverConfig serves consoles over the conserver client protocol:
+// `console -M <host> -p <port> <server>`.
+type ConserverConfig struct {
+	Port     int               `yaml:"port"`      // 0 = disabled (conserver's own default is 782)
+	Users    map[string]string `yaml:"users"`     // login -> password; empty = any login, no password
+	ReadOnly []string          `yaml:"read_only"` // logins that may only spy
+}
+
+// TLSConfig enables HTTPS on server.port when cert and key files are set,
+// or self_signed is on.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	SelfSigned   bool   `yaml:"self_signed"`   // generate a certificate if the files don't exist (default <data>/tls)
+	RedirectPort int    `yaml:"redirect_port"` // plain HTTP port redirecting to HTTPS (0 = off)
 }
 
 func Load(path string) (*Config, error) {
@@ -53,25 +602,112 @@ func Load(path string) (*Config, error) {
 	}
 
 	cfg := &Config{
+		IPMI: IPMIConfig{
+			AttemptsPerMinute: 10,
+			MaxConnecting:     32,
+			ConnectHistory:    100,
+			Backoff: BackoffConfig{
+				Min:    time.Second,
+				Max:    time.Minute,
+				Reset:  30 * time.Second,
+				Jitter: 0.2,
+			},
+		},
 		Discovery: DiscoveryConfig{
-			BMHURL: "http://192.168.200.2:8082",
+			DiscoverySource: DiscoverySource{BMHURL: "http://192.168.200.2:8082"},
+			Probe: ProbeConfig{
+				Interval: 30 * time.Second,
+				Timeout:  2 * time.Second,
+				Failures: 3,
+			},
 		},
 		RebootDetection: RebootDetectionConfig{
 			SOLPatterns:         []string{"POST", "BIOS", "Booting"},
 			ChassisPollInterval: 30 * time.Second,
+			LoopReboots:         5,
+			LoopWindow:          30 * time.Minute,
 		},
 		Logs: LogsConfig{
 			Path:          "/data/logs",
 			RetentionDays: 30,
+			CompressDays:  7,
+			PendingFlush:  2 * time.Second,
+			ScrollbackMB:  1,
+			Daemon: DaemonLogConfig{
+				Format:     "text",
+				Level:      "info",
+				MaxSizeMB:  100,
+				MaxBackups: 5,
+			},
+			Loki: LokiConfig{
+				BatchSize:  1000,
+				BatchWait:  time.Second,
+				SpoolMaxMB: 100,
+			},
+			Syslog: SyslogConfig{
+				Facility: "local0",
+				Tag:      "ipmiserial",
+			},
+			NATS: NATSConfig{
+				SubjectPrefix: "ipmiserial.console",
+				Output:        "cleaned",
+				Stream:        "IPMISERIAL_CONSOLE",
+				MaxAge:        7 * 24 * time.Hour,
+				Replicas:      1,
+			},
 		},
 		Server: ServerConfig{
-			Port: 8080,
+			Port:           8080,
+			SSECompression: true,
+			Catchup:        "auto",
+			GroupLabel:     "group",
+			Streams: StreamsConfig{
+				MaxPerServer: 50,
+				MaxTotal:     1000,
+				EvictAfter:   30 * time.Second,
+			},
+		},
+		SEL: SELConfig{
+			PollInterval: 5 * time.Minute,
+		},
+		Analytics: AnalyticsConfig{
+			BootHistory:   10,
+			FlushInterval: 30 * time.Second,
+		},
+		Sensors: SensorsConfig{
+			PollInterval: time.Minute,
+		},
+		Power: PowerConfig{
+			PollInterval: 30 * time.Second,
+			History:      120,
+		},
+		Telemetry: TelemetryConfig{
+			ServiceName:    "ipmiserial",
+			ExportInterval: 10 * time.Second,
+		},
+		Auth: AuthConfig{
+			Exempt: []string{"/api/version", "/api/openapi.json", "/api/docs"},
+		},
+		Alerts: AlertsConfig{
+			ExcerptLines: 20,
+			ExcerptAfter: 5,
+		},
+		MQTT: MQTTConfig{
+			TopicPrefix:    "ipmiserial",
+			KeepAlive:      60 * time.Second,
+			StatusInterval: time.Minute,
 		},
 	}
 
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.expandSecrets(); err != nil {
+		return nil, err
+	}
+	if cfg.Discovery.CacheKey == "" {
+		cfg.Discovery.CacheKey = os.Getenv("IPMISERIAL_CACHE_KEY")
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Credentials and keys in config.yaml may reference the environment or a
+// file instead of holding the secret itself:
+//
+//	password: ${BMC_PASSWORD}             # environment variable
+//	password: file:/run/secrets/bmc_pass  # file contents, trailing newline trimmed
+//	password: file:${SECRETS}/bmc_pass    # both
+//
+// "$${" stands for a literal "${".
+
+var envRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandSecret resolves the references in one value.
+func expandSecret(v string) (string, error) {
+	var err error
+	v = envRef.ReplaceAllStringFunc(v, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		name := ref[2 : len(ref)-1]
+		val, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return val
+	})
+	if err != nil {
+		return "", err
+	}
+	if path, ok := strings.CutPrefix(v, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		v = strings.TrimRight(string(data), "\r\n")
+	}
+	return v, nil
+}
+
+// expandSecrets resolves references in every credential, token and key
+// setting.
+func (c *Config) expandSecrets() error {
+	type field struct {
+		name string
+		v    *string
+	}
+	fields := []field{
+		{"ipmi.username", &c.IPMI.Username},
+		{"ipmi.password", &c.IPMI.Password},
+		{"ipmi.kg", &c.IPMI.Kg},
+		{"ipmi.proxy.password", &c.IPMI.Proxy.Password},
+		{"vault.token", &c.Vault.Token},
+		{"vault.role_id", &c.Vault.RoleID},
+		{"vault.secret_id", &c.Vault.SecretID},
+		{"server.tls.cert_file", &c.Server.TLS.CertFile},
+		{"server.tls.key_file", &c.Server.TLS.KeyFile},
+		{"discovery.cache_key", &c.Discovery.CacheKey},
+		{"ssh.host_key", &c.SSH.HostKey},
+		{"auth.admin_token", &c.Auth.AdminToken},
+		{"logs.loki.password", &c.Logs.Loki.Password},
+		{"logs.loki.bearer_token", &c.Logs.Loki.BearerToken},
+		{"logs.nats.url", &c.Logs.NATS.URL},
+		{"logs.nats.password", &c.Logs.NATS.Password},
+		{"logs.nats.token", &c.Logs.NATS.Token},
+		{"console_proxy.password", &c.ConsoleProxy.Password},
+		{"mqtt.username", &c.MQTT.Username},
+		{"mqtt.password", &c.MQTT.Password},
+	}
+	for i := range c.Servers {
+		s := &c.Servers[i]
+		prefix := fmt.Sprintf("servers[%d].", i)
+		fields = append(fields,
+			field{prefix + "username", &s.Username},
+			field{prefix + "password", &s.Password},
+			field{prefix + "kg", &s.Kg})
+		if s.Proxy != nil {
+			fields = append(fields, field{prefix + "proxy.password", &s.Proxy.Password})
+		}
+	}
+	discovery := func(prefix string, src *DiscoverySource) {
+		fields = append(fields,
+			field{prefix + "username", &src.Username},
+			field{prefix + "password", &src.Password},
+			field{prefix + "kubernetes.token", &src.Kubernetes.Token})
+	}
+	discovery("discovery.", &c.Discovery.DiscoverySource)
+	for i := range c.Discovery.Sources {
+		discovery(fmt.Sprintf("discovery.sources[%d].", i), &c.Discovery.Sources[i])
+	}
+	for i := range c.Auth.Tokens {
+		fields = append(fields, field{fmt.Sprintf("auth.tokens[%d].token", i), &c.Auth.Tokens[i].Token})
+	}
+	for i := range c.Auth.Users {
+		fields = append(fields, field{fmt.Sprintf("auth.users[%d].password", i), &c.Auth.Users[i].Password})
+	}
+	for i := range c.Alerts.Notifiers {
+		n := &c.Alerts.Notifiers[i]
+		prefix := fmt.Sprintf("alerts.notifiers[%d].", i)
+		fields = append(fields,
+			field{prefix + "url", &n.URL},
+			field{prefix + "password", &n.Password})
+	}
+	for i := range c.Events.Webhooks {
+		h := &c.Events.Webhooks[i]
+		prefix := fmt.Sprintf("events.webhooks[%d].", i)
+		fields = append(fields,
+			field{prefix + "url", &h.URL},
+			field{prefix + "secret", &h.Secret})
+	}
+
+	for _, f := range fields {
+		v, err := expandSecret(*f.v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.v = v
+	}
+	for user, pass := range c.Conserver.Users {
+		v, err := expandSecret(pass)
+		if err != nil {
+			return fmt.Errorf("conserver.users.%s: %w", user, err)
+		}
+		c.Conserver.Users[user] = v
+	}
+	return nil
+}
@@ -1,19 +1,56 @@
 package discovery
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+// cacheCipher names the encryption in an encrypted cache file.
+const cacheCipher = "AES-256-GCM"
+
+// cacheKDF names how an encrypted cache file's key is derived from the
+// passphrase. Files without one predate it and used the bare SHA-256.
+const cacheKDF = "scrypt"
+
+// scrypt cost parameters, the package's recommended interactive ones, and
+// the salt length. Deriving a key takes tens of milliseconds, which only
+// SetKey and a Load of a cache written under another salt pay.
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	cacheSaltSize = 16
 )
 
 // Cache persists discovered BMH servers to disk so they're available
 // immediately on startup before the BMH API is reachable.
 type Cache struct {
-	path string
-	mu   sync.Mutex
+	path       string
+	mu         sync.Mutex
+	passphrase string
+	salt       []byte      // the salt aead's key was derived with
+	aead       cipher.AEAD // nil = plaintext
+}
+
+// encryptedCache is the on-disk form of an encrypted cache. The servers are
+// sealed as one JSON document since they carry BMC passwords.
+type encryptedCache struct {
+	Cipher     string `json:"cipher"`
+	KDF        string `json:"kdf,omitempty"`
+	Salt       []byte `json:"salt,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
 }
 
 func NewCache(dataDir string) *Cache {
@@ -22,6 +59,47 @@ func NewCache(dataDir string) *Cache {
 	}
 }
 
+// SetKey encrypts the cache with AES-256-GCM under a key derived from
+// passphrase with scrypt and a random salt, which is stored alongside the
+// ciphertext; "" stores it in plaintext. A plaintext cache, or one keyed
+// by the passphrase's bare SHA-256 as older versions wrote, is read once
+// and rewritten.
+func (c *Cache) SetKey(passphrase string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if passphrase == "" {
+		c.passphrase, c.salt, c.aead = "", nil, nil
+		return nil
+	}
+	salt := make([]byte, cacheSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := deriveCacheAEAD(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	c.passphrase, c.salt, c.aead = passphrase, salt, aead
+	return nil
+}
+
+// deriveCacheAEAD derives the cache key from passphrase and salt.
+func deriveCacheAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newCacheAEAD(key)
+}
+
+func newCacheAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 // Load reads cached servers from disk. Returns nil map if no cache exists.
 func (c *Cache) Load() map[string]*Server {
 	c.mu.Lock()
@@ -35,6 +113,16 @@ func (c *Cache) Load() map[string]*Server {
 		return nil
 	}
 
+	current := c.aead == nil
+	var env encryptedCache
+	if json.Unmarshal(data, &env) == nil && env.Cipher != "" {
+		current = env.KDF == cacheKDF
+		if data, err = c.open(env); err != nil {
+			log.Warnf("Failed to decrypt BMH cache: %v", err)
+			return nil
+		}
+	}
+
 	var servers map[string]*Server
 	if err := json.Unmarshal(data, &servers); err != nil {
 		log.Warnf("Failed to parse BMH cache: %v", err)
@@ -42,20 +130,86 @@ func (c *Cache) Load() map[string]*Server {
 	}
 
 	log.Infof("Loaded %d servers from BMH cache", len(servers))
+	if !current && c.aead != nil {
+		if env.Cipher == "" {
+			log.Info("Encrypting plaintext BMH cache")
+		} else {
+			log.Info("Re-encrypting BMH cache under a scrypt-derived key")
+		}
+		c.save(servers)
+	}
 	return servers
 }
 
+// open decrypts an encrypted cache.
+func (c *Cache) open(env encryptedCache) ([]byte, error) {
+	if env.Cipher != cacheCipher {
+		return nil, fmt.Errorf("unsupported cipher %q", env.Cipher)
+	}
+	if c.aead == nil {
+		return nil, fmt.Errorf("cache is encrypted but no discovery.cache_key is set")
+	}
+	aead := c.aead
+	switch env.KDF {
+	case cacheKDF:
+		if !bytes.Equal(env.Salt, c.salt) {
+			var err error
+			if aead, err = deriveCacheAEAD(c.passphrase, env.Salt); err != nil {
+				return nil, err
+			}
+		}
+	case "":
+		key := sha256.Sum256([]byte(c.passphrase))
+		var err error
+		if aead, err = newCacheAEAD(key[:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", env.KDF)
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("malformed nonce")
+	}
+	data, err := aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong key or corrupt cache")
+	}
+	return data, nil
+}
+
 // Save writes the current server map to disk atomically.
 func (c *Cache) Save(servers map[string]*Server) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.save(servers)
+}
 
+func (c *Cache) save(servers map[string]*Server) {
 	data, err := json.MarshalIndent(servers, "", "  ")
 	if err != nil {
 		log.Warnf("Failed to marshal BMH cache: %v", err)
 		return
 	}
 
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			log.Warnf("Failed to generate BMH cache nonce: %v", err)
+			return
+		}
+		data, err = json.MarshalIndent(encryptedCache{
+			Cipher:     cacheCipher,
+			KDF:        cacheKDF,
+			Salt:       c.salt,
+			Nonce:      nonce,
+			Ciphertext: c.aead.Seal(nil, nonce, data, nil),
+		}, "", "  ")
+		if err != nil {
+			log.Warnf("Failed to marshal BMH cache: %v", err)
+			return
+		}
+	}
+
 	// Atomic write: tmp file + rename
 	dir := filepath.Dir(c.path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Annotations on a BareMetalHost (or labels of the same names) overriding
+// ipmi.connection for that host
+const (
+	ConnectTimeoutAnnotation    = "ipmiserial/connect-timeout"    // e.g. 45s
+	InactivityTimeoutAnnotation = "ipmiserial/inactivity-timeout" // e.g. 10m
+	KeepaliveIntervalAnnotation = "ipmiserial/keepalive-interval" // e.g. 1m
+	CipherSuiteAnnotation       = "ipmiserial/cipher-suite"       // 1 or 2
+	AllowV15Annotation          = "ipmiserial/allow-v15"          // true or false
+)
+
+// Connection holds a host's connection overrides from its annotations.
+// Zero fields leave the configured settings.
+type Connection struct {
+	Timeout           time.Duration `json:"timeout,omitempty"`
+	InactivityTimeout time.Duration `json:"inactivity_timeout,omitempty"`
+	KeepaliveInterval time.Duration `json:"keepalive_interval,omitempty"`
+	CipherSuite       int           `json:"cipher_suite,omitempty"`
+	AllowV15          *bool         `json:"allow_v15,omitempty"`
+}
+
+func (c Connection) equal(o Connection) bool {
+	if (c.AllowV15 == nil) != (o.AllowV15 == nil) || c.AllowV15 != nil && *c.AllowV15 != *o.AllowV15 {
+		return false
+	}
+	c.AllowV15, o.AllowV15 = nil, nil
+	return c == o
+}
+
+// bmhConnection returns a host's connection annotations, each annotation
+// taking precedence over a label. Values that don't parse are ignored.
+func bmhConnection(bmh BareMetalHost) Connection {
+	return Connection{
+		Timeout:           bmhDuration(bmh, ConnectTimeoutAnnotation),
+		InactivityTimeout: bmhDuration(bmh, InactivityTimeoutAnnotation),
+		KeepaliveInterval: bmhDuration(bmh, KeepaliveIntervalAnnotation),
+		CipherSuite:       bmhCipherSuite(bmh),
+		AllowV15:          bmhAllowV15(bmh),
+	}
+}
+
+// bmhValue returns a host's annotation, or the label of the same name.
+func bmhValue(bmh BareMetalHost, key string) (string, bool) {
+	v, ok := bmh.Metadata.Annotations[key]
+	if !ok {
+		v, ok = bmh.Metadata.Labels[key]
+	}
+	return strings.TrimSpace(v), ok
+}
+
+// bmhDuration returns a duration annotation, or 0 when it is unset or not
+// a positive duration.
+func bmhDuration(bmh BareMetalHost, key string) time.Duration {
+	v, ok := bmhValue(bmh, key)
+	if !ok {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Warnf("BMH %s: ignoring %s=%q, not a positive duration", bmh.Metadata.Name, key, v)
+		return 0
+	}
+	return d
+}
+
+// bmhCipherSuite returns a host's CipherSuiteAnnotation, or 0 when it is
+// unset or not a suite go-sol proposes.
+func bmhCipherSuite(bmh BareMetalHost) int {
+	v, ok := bmhValue(bmh, CipherSuiteAnnotation)
+	if !ok {
+		return 0
+	}
+	suite, err := strconv.Atoi(v)
+	if err != nil || suite < 1 || suite > 2 {
+		log.Warnf("BMH %s: ignoring %s=%q, want 1 or 2", bmh.Metadata.Name, CipherSuiteAnnotation, v)
+		return 0
+	}
+	return suite
+}
+
+// bmhAllowV15 returns a host's AllowV15Annotation, or nil when it is unset
+// or not a boolean.
+func bmhAllowV15(bmh BareMetalHost) *bool {
+	v, ok := bmhValue(bmh, AllowV15Annotation)
+	if !ok {
+		return nil
+	}
+	allow, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warnf("BMH %s: ignoring %s=%q, want true or false", bmh.Metadata.Name, AllowV15Annotation, v)
+		return nil
+	}
+	return &allow
+}
@@ -0,0 +1,193 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+// In-cluster ServiceAccount files
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// metal3BMHPath is the API group path of metal3 BareMetalHost resources.
+const metal3BMHPath = "/apis/metal3.io/v1alpha1"
+
+// kubeClient talks to a Kubernetes API server with a bearer token. Requests
+// go straight to the REST API, so watching BareMetalHosts needs nothing
+// beyond the standard library.
+type kubeClient struct {
+	apiServer     string
+	token         string
+	tokenFile     string
+	labelSelector string
+	client        *http.Client // bounded requests (list, secrets)
+	watchClient   *http.Client // long-lived watches
+}
+
+func newKubeClient(cfg config.KubernetesConfig) (*kubeClient, error) {
+	k := &kubeClient{
+		apiServer:     strings.TrimSuffix(cfg.APIServer, "/"),
+		token:         cfg.Token,
+		tokenFile:     cfg.TokenFile,
+		labelSelector: cfg.LabelSelector,
+	}
+	if k.apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("kubernetes.api_server is not set and not running in a cluster")
+		}
+		k.apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if k.token == "" && k.tokenFile == "" {
+		k.tokenFile = serviceAccountToken
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	caFile := cfg.CAFile
+	if caFile == "" {
+		if _, err := os.Stat(serviceAccountCA); err == nil {
+			caFile = serviceAccountCA
+		}
+	}
+	if caFile != "" && !cfg.InsecureSkipVerify {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	k.client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	k.watchClient = &http.Client{Transport: transport}
+	return k, nil
+}
+
+// listURL returns the BareMetalHost collection URL, scoped by namespace and
+// filtered by the label selector.
+func (k *kubeClient) listURL(namespace string) string {
+	u := k.apiServer + metal3BMHPath
+	if namespace != "" {
+		u += "/namespaces/" + url.PathEscape(namespace)
+	}
+	u += "/baremetalhosts"
+	if k.labelSelector != "" {
+		u += "?labelSelector=" + url.QueryEscape(k.labelSelector)
+	}
+	return u
+}
+
+// authorize adds the bearer token. The token file is re-read every time
+// because projected ServiceAccount tokens are rotated.
+func (k *kubeClient) authorize(req *http.Request) error {
+	token := k.token
+	if token == "" {
+		data, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return fmt.Errorf("read token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// secret is the part of a core/v1 Secret discovery reads. Values are
+// base64 in JSON, which decoding into []byte undoes.
+type secret struct {
+	Data map[string][]byte `json:"data"`
+}
+
+// credentials reads the username and password from a BMC credentials
+// Secret, as referenced by a BareMetalHost's spec.bmc.credentialsName.
+func (k *kubeClient) credentials(ctx context.Context, namespace, name string) (string, string, error) {
+	u := k.apiServer + "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets/" + url.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", "", err
+	}
+	if err := k.authorize(req); err != nil {
+		return "", "", err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", "", fmt.Errorf("secret %s/%s: status %d", namespace, name, resp.StatusCode)
+	}
+	var s secret
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return "", "", err
+	}
+	return string(s.Data["username"]), string(s.Data["password"]), nil
+}
+
+// resolveCredentials fills in a BareMetalHost's BMC credentials from its
+// Secret, or from the source's own credentials when it has neither. Secrets
+// already read in this pass are reused via seen.
+func (s *Scanner) resolveCredentials(ctx context.Context, src *source, bmh *BareMetalHost, seen map[string][2]string) {
+	bmc := &bmh.Spec.BMC
+	if bmc.Username != "" {
+		return
+	}
+	if src.kube == nil || bmc.CredentialsName == "" {
+		bmc.Username, bmc.Password = src.username, src.password
+		return
+	}
+	key := bmh.Metadata.Namespace + "/" + bmc.CredentialsName
+	creds, ok := seen[key]
+	if !ok {
+		user, pass, err := src.kube.credentials(ctx, bmh.Metadata.Namespace, bmc.CredentialsName)
+		if err != nil {
+			log.Warnf("BMC credentials for %s: %v", bmh.Metadata.Name, err)
+			return
+		}
+		creds = [2]string{user, pass}
+		if seen != nil {
+			seen[key] = creds
+		}
+	}
+	bmc.Username, bmc.Password = creds[0], creds[1]
+}
+
+// parseBMCAddress accepts a bare host or IP (mkube) or a metal3 BMC URL such
+// as ipmi://10.0.0.5:623. Other drivers (redfish, idrac, ...) have no IPMI
+// SOL and are rejected.
+func parseBMCAddress(addr string) (host string, port int, ok bool) {
+	if !strings.Contains(addr, "://") {
+		return addr, 0, true
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "ipmi" || u.Hostname() == "" {
+		return "", 0, false
+	}
+	if p := u.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", 0, false
+		}
+	}
+	return u.Hostname(), port, true
+}
@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector matches server labels. It is parsed from a comma-separated list
+// of requirements, all of which must hold: key=value, key!=value, key (the
+// label is set) and !key (it is not).
+type Selector []requirement
+
+type requirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool // only the key's presence matters
+}
+
+// ParseSelector parses a label selector such as "rack=r12,env!=prod". An
+// empty selector matches everything.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var req requirement
+		switch {
+		case strings.Contains(term, "!="):
+			req.key, req.value, _ = strings.Cut(term, "!=")
+			req.negate = true
+		case strings.Contains(term, "="):
+			req.key, req.value, _ = strings.Cut(term, "=")
+		case strings.HasPrefix(term, "!"):
+			req.key, req.negate, req.exists = term[1:], true, true
+		default:
+			req.key, req.exists = term, true
+		}
+		req.key, req.value = strings.TrimSpace(req.key), strings.TrimSpace(req.value)
+		if req.key == "" {
+			return nil, fmt.Errorf("label selector %q: missing key in %q", s, term)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// Matches reports whether labels satisfy every requirement.
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, req := range sel {
+		v, ok := labels[req.key]
+		if !req.exists {
+			ok = ok && v == req.value
+		}
+		if ok == req.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchLabels reports whether labels satisfy a selector string. A malformed
+// selector matches nothing.
+func MatchLabels(labels map[string]string, selector string) bool {
+	sel, err := ParseSelector(selector)
+	return err == nil && sel.Matches(labels)
+}
+
+// Labels returns a server's labels, nil if it is unknown or has none. The
+// map is replaced rather than modified when the labels change, so callers
+// may keep it but must not modify it.
+func (s *Scanner) Labels(name string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if srv, ok := s.servers[name]; ok {
+		return srv.Labels
+	}
+	return nil
+}
+
+// copyLabels returns a copy of labels, nil when there are none.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gwest/go-sol"
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/bmcproxy"
+	"ipmiserial/config"
+)
+
+// probeConcurrency bounds how many BMCs are pinged at once.
+const probeConcurrency = 16
+
+// RunProber pings every server's BMC (RMCP presence ping, UDP 623) at the
+// configured interval until ctx is cancelled. A server that misses
+// cfg.Failures probes in a row is marked offline, so the SOL manager stops
+// reconnecting to it; it comes back online on its next answer. Changes are
+// pushed through OnChange. A zero interval disables probing.
+func (s *Scanner) RunProber(ctx context.Context, cfg config.ProbeConfig) {
+	if cfg.Interval <= 0 {
+		return
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.Failures <= 0 {
+		cfg.Failures = 3
+	}
+
+	misses := make(map[string]int)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.probe(ctx, cfg, misses)
+		}
+	}
+}
+
+// SetProbeDialer sets how probes open their sockets, e.g. from permitted
+// source ports (sol.SourcePorts.Dial); nil is any source port.
+func (s *Scanner) SetProbeDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dialer = dial
+}
+
+// SetProbeProxies sets the proxies probes reach BMCs through, by default
+// and per server, as the SOL manager's; nil is direct, with the probe
+// dialer.
+func (s *Scanner) SetProbeProxies(def bmcproxy.DialFunc, servers map[string]bmcproxy.DialFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proxy, s.proxies = def, servers
+}
+
+// probe pings every server once and applies the results.
+func (s *Scanner) probe(ctx context.Context, cfg config.ProbeConfig, misses map[string]int) {
+	type target struct {
+		name, ip string
+		port     int
+		dial     bmcproxy.DialFunc
+	}
+	s.mu.RLock()
+	targets := make([]target, 0, len(s.servers))
+	for name, srv := range s.servers {
+		dial, ok := s.proxies[name]
+		if !ok {
+			dial = s.proxy
+		}
+		if dial == nil {
+			dial = s.dialer
+		}
+		targets = append(targets, target{name, srv.IP, srv.Port, dial})
+	}
+	s.mu.RUnlock()
+
+	results := make(map[string]error, len(targets))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, probeConcurrency)
+	for _, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(t target) {
+			defer func() { <-sem; wg.Done() }()
+			err := sol.PingDial(ctx, t.dial, t.ip, t.port, cfg.Timeout)
+			resultsMu.Lock()
+			results[t.name] = err
+			resultsMu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	changed := false
+	s.mu.Lock()
+	for name := range misses {
+		if _, exists := s.servers[name]; !exists {
+			delete(misses, name)
+		}
+	}
+	for name, err := range results {
+		srv, exists := s.servers[name]
+		if !exists {
+			continue
+		}
+		if err == nil {
+			delete(misses, name)
+			if !srv.Online {
+				srv.Online = true
+				changed = true
+				log.Infof("BMC for %s (%s) is reachable again, marking online", name, srv.IP)
+			}
+			continue
+		}
+		misses[name]++
+		log.Debugf("BMC probe for %s (%s) failed (%d/%d): %v", name, srv.IP, misses[name], cfg.Failures, err)
+		if srv.Online && misses[name] >= cfg.Failures {
+			srv.Online = false
+			changed = true
+			log.Warnf("BMC for %s (%s) missed %d probes, marking offline: %v", name, srv.IP, misses[name], err)
+		}
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.cache.Save(s.GetServers())
+		if s.onChange != nil {
+			s.onChange(s.GetServers())
+		}
+	}
+}
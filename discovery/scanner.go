@@ -4,12 +4,19 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
+	"maps"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/bmcproxy"
 )
 
 type Server struct {
@@ -19,19 +26,38 @@ type Server struct {
 	MAC      string `json:"mac,omitempty"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Kg       string `json:"-"`                // BMC key for two-key auth, "" = global/none
+	Port     int    `json:"port,omitempty"`   // IPMI UDP port, 0 = default 623
+	Static   bool   `json:"static,omitempty"` // configured in config.yaml, never pruned by BMH sync
+	Source   string `json:"source,omitempty"` // discovery source that owns a discovered server
+
+	RetentionDays int        `json:"retention_days,omitempty"` // log retention override, 0 = logs.retention_days
+	Connection    Connection `json:"connection,omitzero"`      // connection overrides from BMH annotations
+
+	// Labels from config.yaml or the BMH's metadata.labels, for filtering,
+	// grouping and access control. Replaced, never modified in place.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
-// BareMetalHost represents a BMH object from the mkube API
+// RetentionAnnotation on a BareMetalHost (or a label of the same name)
+// overrides logs.retention_days for that host, in days.
+const RetentionAnnotation = "ipmiserial/retention-days"
+
+// BareMetalHost represents a BMH object from the mkube API or a metal3
+// BareMetalHost resource
 type BareMetalHost struct {
 	Metadata struct {
-		Name      string `json:"name"`
-		Namespace string `json:"namespace"`
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
 	} `json:"metadata"`
 	Spec struct {
 		BMC struct {
-			Address  string `json:"address"`
-			Username string `json:"username"`
-			Password string `json:"password"`
+			Address         string `json:"address"`
+			Username        string `json:"username"`
+			Password        string `json:"password"`
+			CredentialsName string `json:"credentialsName"` // metal3: Secret with username/password
 		} `json:"bmc"`
 		BootMACAddress string `json:"bootMACAddress"`
 	} `json:"spec"`
@@ -52,34 +78,28 @@ type WatchEvent struct {
 }
 
 type Scanner struct {
-	servers    map[string]*Server
-	mu         sync.RWMutex
-	onChange   func(servers map[string]*Server)
-	bmhURL     string
-	namespace  string
-	httpClient *http.Client
-	cache      *Cache
+	servers  map[string]*Server
+	mu       sync.RWMutex
+	onChange func(servers map[string]*Server)
+	sources  []*source
+	cache    *Cache
+	running  atomic.Bool
+	dialer   func(ctx context.Context, network, addr string) (net.Conn, error) // probe sockets; nil = any source port
+	proxy    bmcproxy.DialFunc            // probe route through a proxy; nil = direct
+	proxies  map[string]bmcproxy.DialFunc // per-server probe routes replacing proxy; nil = direct
 }
 
-func NewScanner(bmhURL, namespace, dataDir string) *Scanner {
+func NewScanner(dataDir string) *Scanner {
 	return &Scanner{
-		servers:    make(map[string]*Server),
-		bmhURL:     bmhURL,
-		namespace:  namespace,
-		httpClient: &http.Client{Timeout: 10 * time.Second},
-		cache:      NewCache(dataDir),
-	}
-}
-
-// BMHListURL returns the URL for listing BMH objects, scoped by namespace if configured.
-func (s *Scanner) BMHListURL() string {
-	if s.namespace != "" {
-		return s.bmhURL + "/api/v1/namespaces/" + s.namespace + "/baremetalhosts"
+		servers: make(map[string]*Server),
+		cache:   NewCache(dataDir),
 	}
-	return s.bmhURL + "/api/v1/baremetalhosts"
 }
 
-func (s *Scanner) AddServer(name, host string) {
+// AddServer registers a statically configured server. Empty credentials fall
+// back to the global ipmi block; port 0 means the IPMI default (623) and
+// retentionDays 0 the global log retention.
+func (s *Scanner) AddServer(name, host, username, password, kg string, port, retentionDays int, labels map[string]string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -92,15 +112,56 @@ func (s *Scanner) AddServer(name, host string) {
 		IP:       ip,
 		Hostname: name,
 		Online:   true,
+		Username: username,
+		Password: password,
+		Kg:       kg,
+		Port:     port,
+		Static:   true,
+
+		RetentionDays: retentionDays,
+		Labels:        copyLabels(labels),
 	}
 
 	log.Infof("Added server: %s (%s -> %s)", name, host, ip)
 }
 
+// RemoveServer drops a statically configured server. Discovered servers are
+// left alone; the BMH sync owns those.
+func (s *Scanner) RemoveServer(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	srv, exists := s.servers[name]
+	if !exists || !srv.Static {
+		return false
+	}
+	delete(s.servers, name)
+	log.Infof("Removed server: %s", name)
+	return true
+}
+
+// NotifyChange invokes the change callback with the current server set.
+func (s *Scanner) NotifyChange() {
+	if s.onChange != nil {
+		s.onChange(s.GetServers())
+	}
+}
+
+// Running reports whether the discovery loop is running.
+func (s *Scanner) Running() bool {
+	return s.running.Load()
+}
+
 func (s *Scanner) OnChange(fn func(servers map[string]*Server)) {
 	s.onChange = fn
 }
 
+// SetCacheKey encrypts the BMH cache, which holds BMC passwords, under
+// passphrase. Call before Start.
+func (s *Scanner) SetCacheKey(passphrase string) error {
+	return s.cache.SetKey(passphrase)
+}
+
 func (s *Scanner) GetServers() map[string]*Server {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -112,25 +173,40 @@ func (s *Scanner) GetServers() map[string]*Server {
 	return result
 }
 
-func (s *Scanner) BMHURL() string {
-	return s.bmhURL
+// Refresh re-lists every source.
+func (s *Scanner) Refresh() {
+	for _, src := range s.getSources() {
+		s.fetchBMH(src)
+	}
 }
 
-func (s *Scanner) Refresh() {
-	s.fetchBMH()
+func (s *Scanner) getSources() []*source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*source(nil), s.sources...)
 }
 
 func (s *Scanner) Run(ctx context.Context) {
+	s.running.Store(true)
+	defer s.running.Store(false)
 	defer func() {
 		if r := recover(); r != nil {
 			log.Errorf("Scanner goroutine panicked: %v", r)
 		}
 	}()
 
+	sources := s.getSources()
+
 	// Load from cache first for immediate availability
 	if cached := s.cache.Load(); len(cached) > 0 {
 		s.mu.Lock()
 		for name, srv := range cached {
+			if srv.Source == "" && !srv.Static && len(sources) > 0 {
+				// Cached before sources were tracked
+				srv.Source = sources[0].name
+			}
+			// Reachability is probed afresh
+			srv.Online = true
 			if _, exists := s.servers[name]; !exists {
 				s.servers[name] = srv
 				log.Infof("Cache loaded: %s (ip=%s)", name, srv.IP)
@@ -145,18 +221,33 @@ func (s *Scanner) Run(ctx context.Context) {
 		log.Info("No BMH cache found or cache empty")
 	}
 
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src *source) {
+			defer wg.Done()
+			s.runSource(ctx, src)
+		}(src)
+	}
+	wg.Wait()
+}
+
+// runSource lists a source until it returns servers, then watches it with
+// a periodic re-list, reconnecting the watch when it drops.
+func (s *Scanner) runSource(ctx context.Context, src *source) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Scanner goroutine for %s panicked: %v", src.name, r)
+		}
+	}()
+
 	// Retry fetchBMH until it gets servers (network may not be ready at startup)
 	for i := 0; ; i++ {
-		s.fetchBMH()
-		s.mu.RLock()
-		serverCount := len(s.servers)
-		s.mu.RUnlock()
-		if serverCount > 0 {
-			log.Infof("fetchBMH: %d servers discovered", serverCount)
+		if s.fetchBMH(src) > 0 {
 			break
 		}
 		if i > 0 && i%6 == 0 {
-			log.Warnf("fetchBMH: still 0 servers after %d attempts, retrying...", i)
+			log.Warnf("fetchBMH %s: still 0 servers after %d attempts, retrying...", src.name, i)
 		}
 		select {
 		case <-ctx.Done():
@@ -181,7 +272,7 @@ func (s *Scanner) Run(ctx context.Context) {
 		watchCtx, watchCancel := context.WithCancel(ctx)
 		watchDone := make(chan struct{})
 		go func() {
-			s.watchBMH(watchCtx)
+			s.watchBMH(watchCtx, src)
 			close(watchDone)
 		}()
 
@@ -198,7 +289,7 @@ func (s *Scanner) Run(ctx context.Context) {
 				refreshTicker.Stop()
 				break watchLoop
 			case <-refreshTicker.C:
-				s.fetchBMH()
+				s.fetchBMH(src)
 			}
 		}
 		watchCancel()
@@ -208,41 +299,42 @@ func (s *Scanner) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-time.After(5 * time.Second):
-			log.Info("Reconnecting BMH watch...")
-			s.fetchBMH()
+			log.Infof("Reconnecting BMH watch for %s...", src.name)
+			s.fetchBMH(src)
 		}
 	}
 }
 
-func (s *Scanner) fetchBMH() {
-	if s.bmhURL == "" {
-		log.Warn("fetchBMH: bmhURL is empty, skipping")
-		return
+// fetchBMH lists a source and syncs the servers it owns, returning how many
+// it lists.
+func (s *Scanner) fetchBMH(src *source) int {
+	if src.bmhURL == "" {
+		log.Warnf("fetchBMH %s: bmhURL is empty, skipping", src.name)
+		return 0
 	}
 
-	url := s.BMHListURL()
-	log.Infof("fetchBMH: fetching %s", url)
+	url := src.listURL()
+	log.Infof("fetchBMH %s: fetching %s", src.name, url)
 
-	resp, err := s.httpClient.Get(url)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	list, err := s.listBMH(ctx, src, url)
 	if err != nil {
-		log.Warnf("fetchBMH: HTTP request failed: %v", err)
-		return
+		log.Warnf("fetchBMH %s: %v", src.name, err)
+		s.mu.Lock()
+		src.recordError(err)
+		s.mu.Unlock()
+		return 0
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		log.Warnf("fetchBMH: unexpected status %d", resp.StatusCode)
-		return
-	}
+	log.Infof("fetchBMH %s: decoded %d BMH items", src.name, len(list.Items))
 
-	var list BareMetalHostList
-	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
-		log.Warnf("fetchBMH: JSON decode failed: %v", err)
-		return
+	// Credentials Secrets are read before taking the lock
+	seen := make(map[string][2]string)
+	for i := range list.Items {
+		s.resolveCredentials(ctx, src, &list.Items[i], seen)
 	}
 
-	log.Infof("fetchBMH: decoded %d BMH items", len(list.Items))
-
 	// Build set of current BMH names
 	bmhNames := make(map[string]bool, len(list.Items))
 	for _, bmh := range list.Items {
@@ -253,15 +345,17 @@ func (s *Scanner) fetchBMH() {
 
 	changed := false
 	s.mu.Lock()
+	src.lastSync = time.Now()
+	src.conflicts = make(map[string]string)
 	for _, bmh := range list.Items {
-		if s.applyBMH(bmh) {
+		if s.applyBMH(src, bmh) {
 			changed = true
 		}
 	}
-	// Remove servers no longer in BMH list
-	for name := range s.servers {
-		if !bmhNames[name] {
-			log.Infof("Removing stale server: %s (no longer in BMH)", name)
+	// Remove this source's servers no longer in its BMH list
+	for name, srv := range s.servers {
+		if srv.Source == src.name && !bmhNames[name] && !srv.Static {
+			log.Infof("Removing stale server: %s (no longer in BMH from %s)", name, src.name)
 			delete(s.servers, name)
 			changed = true
 		}
@@ -274,29 +368,78 @@ func (s *Scanner) fetchBMH() {
 			go s.onChange(s.GetServers())
 		}
 	}
+	return len(bmhNames)
 }
 
-func (s *Scanner) watchBMH(ctx context.Context) {
-	if s.bmhURL == "" {
+func (s *Scanner) listBMH(ctx context.Context, src *source, url string) (*BareMetalHostList, error) {
+	req, err := src.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := src.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var list BareMetalHostList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("JSON decode failed: %w", err)
+	}
+	return &list, nil
+}
+
+func (s *Scanner) watchBMH(ctx context.Context, src *source) {
+	if src.bmhURL == "" {
 		return
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", s.BMHListURL()+"?watch=true", nil)
+	watchURL := src.listURL()
+	if strings.Contains(watchURL, "?") {
+		watchURL += "&watch=true"
+	} else {
+		watchURL += "?watch=true"
+	}
+	req, err := src.newRequest(ctx, watchURL)
 	if err != nil {
-		log.Warnf("Failed to create BMH watch request: %v", err)
+		log.Warnf("Failed to create BMH watch request for %s: %v", src.name, err)
 		return
 	}
 
 	// Use a client without timeout for the long-lived watch connection
 	watchClient := &http.Client{}
+	if src.kube != nil {
+		watchClient = src.kube.watchClient
+	}
 	resp, err := watchClient.Do(req)
+	if err == nil && resp.StatusCode != 200 {
+		resp.Body.Close()
+		err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
 	if err != nil {
-		log.Warnf("BMH watch failed: %v", err)
+		log.Warnf("BMH watch for %s failed: %v", src.name, err)
+		if ctx.Err() == nil {
+			s.mu.Lock()
+			src.recordError(err)
+			s.mu.Unlock()
+		}
 		return
 	}
 	defer resp.Body.Close()
 
-	log.Info("BMH watch connected")
+	log.Infof("BMH watch for %s connected", src.name)
+	s.mu.Lock()
+	src.watching = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		src.watching = false
+		s.mu.Unlock()
+	}()
 
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
@@ -311,11 +454,20 @@ func (s *Scanner) watchBMH(ctx context.Context) {
 			continue
 		}
 
+		if event.Type == "ERROR" {
+			// e.g. 410 Gone once the watch's resource version expires
+			log.Warnf("BMH watch error event from %s, reconnecting", src.name)
+			return
+		}
+		if event.Type == "ADDED" || event.Type == "MODIFIED" {
+			s.resolveCredentials(ctx, src, &event.Object, nil)
+		}
+
 		changed := false
 		s.mu.Lock()
 		switch event.Type {
 		case "ADDED", "MODIFIED":
-			changed = s.applyBMH(event.Object)
+			changed = s.applyBMH(src, event.Object)
 		case "DELETED":
 			// Ignore DELETE events — BMH objects represent physical hardware.
 			// Watch DELETE events are often spurious (namespace scoping issues,
@@ -333,28 +485,58 @@ func (s *Scanner) watchBMH(ctx context.Context) {
 	}
 }
 
-// applyBMH updates the server map from a BMH object. Must be called with s.mu held.
+// hasSource reports whether a source is configured. Must be called with s.mu held.
+func (s *Scanner) hasSource(name string) bool {
+	for _, src := range s.sources {
+		if src.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyBMH updates the server map from a source's BMH object. A server
+// owned by another source or configured statically is recorded as a
+// conflict and left alone. Must be called with s.mu held.
 // Returns true if there was a change.
-func (s *Scanner) applyBMH(bmh BareMetalHost) bool {
-	addr := bmh.Spec.BMC.Address
-	if addr == "" {
+func (s *Scanner) applyBMH(src *source, bmh BareMetalHost) bool {
+	if bmh.Spec.BMC.Address == "" {
 		return false
 	}
 
 	name := bmh.Metadata.Name
+	addr, port, ok := parseBMCAddress(bmh.Spec.BMC.Address)
+	if !ok {
+		log.Debugf("Skipping BMH %s: %s is not an IPMI address", name, bmh.Spec.BMC.Address)
+		return false
+	}
 
 	existing, exists := s.servers[name]
+	if exists && !existing.Static && existing.Source != src.name && !s.hasSource(existing.Source) {
+		// Left over from a source that is no longer configured
+		existing.Source = src.name
+	}
+	if exists && (existing.Static || existing.Source != src.name) {
+		owner := existing.Source
+		if existing.Static {
+			owner = "static config"
+		}
+		if src.conflicts[name] != owner {
+			log.Warnf("Discovery source %s: %s is already provided by %s, ignoring", src.name, name, owner)
+		}
+		src.conflicts[name] = owner
+		return false
+	}
+	delete(src.conflicts, name)
 	if exists {
 		changed := false
-		if existing.IP != addr {
+		if existing.IP != addr || existing.Port != port {
 			existing.IP = addr
+			existing.Port = port
 			changed = true
 		}
-		// BMC is always reachable regardless of host power state
-		if !existing.Online {
-			existing.Online = true
-			changed = true
-		}
+		// Online is left to the prober: BMH objects exist whether or not
+		// their BMC is reachable
 		if bmh.Spec.BootMACAddress != "" && existing.MAC != bmh.Spec.BootMACAddress {
 			existing.MAC = bmh.Spec.BootMACAddress
 			changed = true
@@ -367,6 +549,18 @@ func (s *Scanner) applyBMH(bmh BareMetalHost) bool {
 			existing.Password = bmh.Spec.BMC.Password
 			changed = true
 		}
+		if days := bmhRetention(bmh); existing.RetentionDays != days {
+			existing.RetentionDays = days
+			changed = true
+		}
+		if conn := bmhConnection(bmh); !existing.Connection.equal(conn) {
+			existing.Connection = conn
+			changed = true
+		}
+		if !maps.Equal(existing.Labels, bmh.Metadata.Labels) {
+			existing.Labels = copyLabels(bmh.Metadata.Labels)
+			changed = true
+		}
 		return changed
 	}
 
@@ -377,7 +571,32 @@ func (s *Scanner) applyBMH(bmh BareMetalHost) bool {
 		MAC:      bmh.Spec.BootMACAddress,
 		Username: bmh.Spec.BMC.Username,
 		Password: bmh.Spec.BMC.Password,
+		Port:     port,
+		Source:   src.name,
+
+		RetentionDays: bmhRetention(bmh),
+		Connection:    bmhConnection(bmh),
+		Labels:        copyLabels(bmh.Metadata.Labels),
 	}
-	log.Infof("Discovered BMH: %s (%s)", name, addr)
+	log.Infof("Discovered BMH: %s (%s) from %s", name, addr, src.name)
 	return true
 }
+
+// bmhRetention returns a host's RetentionAnnotation, the annotation taking
+// precedence over a label, or 0 when it has neither or it is not a number
+// of days.
+func bmhRetention(bmh BareMetalHost) int {
+	v, ok := bmh.Metadata.Annotations[RetentionAnnotation]
+	if !ok {
+		v, ok = bmh.Metadata.Labels[RetentionAnnotation]
+	}
+	if !ok {
+		return 0
+	}
+	days, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || days < 0 {
+		log.Warnf("BMH %s: ignoring %s=%q, not a number of days", bmh.Metadata.Name, RetentionAnnotation, v)
+		return 0
+	}
+	return days
+}
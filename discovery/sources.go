@@ -0,0 +1,193 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"ipmiserial/config"
+)
+
+// source is one BMH endpoint: an mkube API or a Kubernetes API server. Each
+// source owns the servers it discovered; a name already owned by another
+// source (or configured statically) is a conflict and is left alone.
+type source struct {
+	name       string
+	mode       string
+	bmhURL     string
+	namespace  string
+	kube       *kubeClient // set for Kubernetes sources
+	username   string      // BMC credentials for hosts that carry none
+	password   string
+	httpClient *http.Client
+
+	// Health, guarded by Scanner.mu
+	lastSync    time.Time
+	lastError   string
+	lastErrorAt time.Time
+	watching    bool
+	conflicts   map[string]string // server name -> source that owns it
+}
+
+// SourceStatus is the health of one discovery source.
+type SourceStatus struct {
+	Name          string     `json:"name"`
+	Mode          string     `json:"mode"`
+	URL           string     `json:"url"`
+	Namespace     string     `json:"namespace,omitempty"`
+	Healthy       bool       `json:"healthy"` // last sync succeeded after any error
+	Watching      bool       `json:"watching"`
+	Servers       int        `json:"servers"`
+	LastSync      *time.Time `json:"lastSync,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+	Conflicts     []string   `json:"conflicts,omitempty"` // e.g. "node1 (owned by rack-b)"
+}
+
+func newSource(cfg config.DiscoverySource) (*source, error) {
+	src := &source{
+		name:       cfg.Name,
+		mode:       cfg.Mode,
+		bmhURL:     cfg.BMHURL,
+		namespace:  cfg.Namespace,
+		username:   cfg.Username,
+		password:   cfg.Password,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		conflicts:  make(map[string]string),
+	}
+	switch cfg.Mode {
+	case "", "mkube":
+		src.mode = "mkube"
+	case "kubernetes":
+		k, err := newKubeClient(cfg.Kubernetes)
+		if err != nil {
+			return nil, err
+		}
+		src.kube = k
+		src.bmhURL = k.apiServer
+	default:
+		return nil, fmt.Errorf("unknown mode %q (want mkube or kubernetes)", cfg.Mode)
+	}
+	return src, nil
+}
+
+// listURL returns the URL for listing BMH objects, scoped by namespace if configured.
+func (src *source) listURL() string {
+	if src.kube != nil {
+		return src.kube.listURL(src.namespace)
+	}
+	if src.namespace != "" {
+		return src.bmhURL + "/api/v1/namespaces/" + src.namespace + "/baremetalhosts"
+	}
+	return src.bmhURL + "/api/v1/baremetalhosts"
+}
+
+// newRequest builds a GET against the source, authorized for Kubernetes.
+func (src *source) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if src.kube != nil {
+		if err := src.kube.authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func (src *source) client() *http.Client {
+	if src.kube != nil {
+		return src.kube.client
+	}
+	return src.httpClient
+}
+
+// AddSource registers a discovery source. Sources are polled and watched
+// by Run.
+func (s *Scanner) AddSource(cfg config.DiscoverySource) error {
+	src, err := newSource(cfg)
+	if err != nil {
+		return fmt.Errorf("discovery source %s: %w", cfg.Name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.sources {
+		if existing.name == src.name {
+			return fmt.Errorf("duplicate discovery source name %q", src.name)
+		}
+	}
+	s.sources = append(s.sources, src)
+	return nil
+}
+
+// Sources reports the health of every discovery source.
+func (s *Scanner) Sources() []SourceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, srv := range s.servers {
+		counts[srv.Source]++
+	}
+	out := make([]SourceStatus, 0, len(s.sources))
+	for _, src := range s.sources {
+		st := SourceStatus{
+			Name:      src.name,
+			Mode:      src.mode,
+			URL:       src.listURL(),
+			Namespace: src.namespace,
+			Healthy:   !src.lastSync.IsZero() && src.lastSync.After(src.lastErrorAt),
+			Watching:  src.watching,
+			Servers:   counts[src.name],
+			LastError: src.lastError,
+		}
+		if !src.lastSync.IsZero() {
+			t := src.lastSync
+			st.LastSync = &t
+		}
+		if !src.lastErrorAt.IsZero() {
+			t := src.lastErrorAt
+			st.LastErrorTime = &t
+		}
+		for name, owner := range src.conflicts {
+			st.Conflicts = append(st.Conflicts, name+" (owned by "+owner+")")
+		}
+		sort.Strings(st.Conflicts)
+		out = append(out, st)
+	}
+	return out
+}
+
+// ProbeBMH requests each source's BMH list once and reports the HTTP
+// status or error per source.
+func (s *Scanner) ProbeBMH(ctx context.Context) map[string]string {
+	s.mu.RLock()
+	sources := append([]*source(nil), s.sources...)
+	s.mu.RUnlock()
+
+	out := make(map[string]string, len(sources))
+	for _, src := range sources {
+		req, err := src.newRequest(ctx, src.listURL())
+		if err != nil {
+			out[src.name] = err.Error()
+			continue
+		}
+		resp, err := src.client().Do(req)
+		if err != nil {
+			out[src.name] = err.Error()
+			continue
+		}
+		resp.Body.Close()
+		out[src.name] = resp.Status
+	}
+	return out
+}
+
+// recordError notes a failed sync or watch. Must be called with s.mu held.
+func (src *source) recordError(err error) {
+	src.lastError = err.Error()
+	src.lastErrorAt = time.Now()
+}
@@ -0,0 +1,201 @@
+// Package events turns discovery, SOL session, boot analytics and alert
+// activity into structured lifecycle events on an internal bus, and
+// delivers them to webhooks.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"ipmiserial/alerts"
+	"ipmiserial/discovery"
+	"ipmiserial/sol"
+)
+
+// Event types.
+const (
+	ServerDiscovered    = "server_discovered"    // detail: BMC address
+	ServerRemoved       = "server_removed"       // the server left discovery
+	SessionConnected    = "session_connected"    // SOL session up
+	SessionDisconnected = "session_disconnected" // detail: error, if any
+	RebootDetected      = "reboot_detected"      // a new boot started
+	BootComplete        = "boot_complete"        // detail: boot duration
+	OSDetected          = "os_detected"          // detail: OS name
+	PowerOnDegraded     = "power_on_degraded"    // detail: power-on delay vs the server's median
+	AlertFired          = "alert_fired"          // data: the alert
+)
+
+// Types lists every event type, for validating subscriptions.
+var Types = []string{
+	ServerDiscovered, ServerRemoved, SessionConnected, SessionDisconnected,
+	RebootDetected, BootComplete, OSDetected, PowerOnDegraded, AlertFired,
+}
+
+// Event is one lifecycle event.
+type Event struct {
+	ID     string      `json:"id"`
+	Type   string      `json:"type"`
+	Server string      `json:"server,omitempty"`
+	Time   time.Time   `json:"time"`
+	Detail string      `json:"detail,omitempty"`
+	Data   interface{} `json:"data,omitempty"` // *alerts.Alert for alert_fired
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before events to it are dropped.
+const subscriberBuffer = 256
+
+// Bus fans events out to subscribers. Publishing never blocks.
+type Bus struct {
+	solManager *sol.Manager
+
+	mu   sync.RWMutex
+	subs map[chan Event]struct{}
+
+	serversMu sync.Mutex
+	servers   map[string]bool // nil until the first server list is seen
+}
+
+// NewBus creates a bus that, once Run, carries the SOL manager's session
+// and analytics events.
+func NewBus(solManager *sol.Manager) *Bus {
+	return &Bus{
+		solManager: solManager,
+		subs:       make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on.
+func (b *Bus) Subscribe() chan Event {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops delivery to ch and closes it.
+func (b *Bus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish stamps an event with an ID (and the time, if unset) and hands it
+// to every subscriber with room for it.
+func (b *Bus) Publish(ev Event) {
+	if ev.ID == "" {
+		ev.ID = newEventID()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Run forwards SOL session and boot analytics events until ctx is done.
+func (b *Bus) Run(ctx context.Context) {
+	notify := b.solManager.SubscribeAllNotify()
+	defer b.solManager.UnsubscribeAllNotify(notify)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-notify:
+			b.translate(ev)
+		}
+	}
+}
+
+// translate maps a SOL notification to a lifecycle event, if it is one.
+func (b *Bus) translate(ev sol.SSEEvent) {
+	switch ev.Channel {
+	case sol.ChannelState:
+		var st sol.StateEvent
+		if json.Unmarshal([]byte(ev.Data), &st) != nil {
+			return
+		}
+		switch st.Type {
+		case sol.StateConnected:
+			b.Publish(Event{Type: SessionConnected, Server: st.Server, Time: st.Time})
+		case sol.StateDisconnected:
+			b.Publish(Event{Type: SessionDisconnected, Server: st.Server, Time: st.Time, Detail: st.Error})
+		}
+	case sol.ChannelAnalytics:
+		var a sol.AnalyticsEvent
+		if json.Unmarshal([]byte(ev.Data), &a) != nil {
+			return
+		}
+		typ := map[string]string{
+			sol.EventBootStart:       RebootDetected,
+			sol.EventBootComplete:    BootComplete,
+			sol.EventOSDetected:      OSDetected,
+			sol.EventPowerOnDegraded: PowerOnDegraded,
+		}[a.Type]
+		if typ != "" {
+			b.Publish(Event{Type: typ, Server: a.Server, Time: a.Time, Detail: a.Detail})
+		}
+	}
+}
+
+// ServersChanged publishes server_discovered and server_removed for the
+// differences from the previous server list. The first list seen is taken
+// as the starting point, so a restart doesn't announce every server again.
+func (b *Bus) ServersChanged(servers map[string]*discovery.Server) {
+	b.serversMu.Lock()
+	prev := b.servers
+	b.servers = make(map[string]bool, len(servers))
+	for name := range servers {
+		b.servers[name] = true
+	}
+	b.serversMu.Unlock()
+	if prev == nil {
+		return
+	}
+
+	var added, removed []string
+	for name := range servers {
+		if !prev[name] {
+			added = append(added, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := servers[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	for _, name := range added {
+		b.Publish(Event{Type: ServerDiscovered, Server: name, Detail: servers[name].IP})
+	}
+	for _, name := range removed {
+		b.Publish(Event{Type: ServerRemoved, Server: name})
+	}
+}
+
+// PublishAlert publishes a fired alert.
+func (b *Bus) PublishAlert(a *alerts.Alert) {
+	b.Publish(Event{Type: AlertFired, Server: a.Server, Time: a.Time, Detail: a.Rule, Data: a})
+}
+
+func newEventID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,372 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+const (
+	// deliveryTimeout bounds a single POST.
+	deliveryTimeout = 10 * time.Second
+	// defaultMaxRetries applies when a webhook doesn't set max_retries.
+	defaultMaxRetries = 5
+	// maxRetryDelay caps the exponential backoff between attempts.
+	maxRetryDelay = 5 * time.Minute
+	// webhookWorkers is how many deliveries run at once.
+	webhookWorkers = 4
+	// webhookQueue is how many deliveries may wait; more are dropped.
+	webhookQueue = 1000
+)
+
+// Webhook request headers.
+const (
+	HeaderEvent     = "X-Ipmiserial-Event"     // event type
+	HeaderDelivery  = "X-Ipmiserial-Delivery"  // event ID, the same on every retry
+	HeaderTimestamp = "X-Ipmiserial-Timestamp" // Unix seconds when the attempt was signed
+	HeaderSignature = "X-Ipmiserial-Signature" // "sha256=" + hex HMAC of "timestamp.body"
+)
+
+// WebhookStatus is a webhook's configuration and delivery counters.
+type WebhookStatus struct {
+	Name         string    `json:"name"`
+	URL          string    `json:"url"`
+	Signed       bool      `json:"signed"`
+	Events       []string  `json:"events,omitempty"`
+	Servers      []string  `json:"servers,omitempty"`
+	Delivered    int64     `json:"delivered"`
+	Failed       int64     `json:"failed"` // gave up after the last retry
+	Retries      int64     `json:"retries"`
+	LastDelivery time.Time `json:"lastDelivery,omitempty"`
+	LastError    string    `json:"lastError,omitempty"`
+	LastErrorAt  time.Time `json:"lastErrorAt,omitempty"`
+}
+
+type webhook struct {
+	cfg     config.Webhook
+	events  map[string]bool // nil = all
+	servers map[string]bool // nil = all
+	status  WebhookStatus
+}
+
+func (h *webhook) wants(ev Event) bool {
+	if h.events != nil && !h.events[ev.Type] {
+		return false
+	}
+	if h.servers != nil && !h.servers[ev.Server] {
+		return false
+	}
+	return true
+}
+
+func (h *webhook) maxRetries() int {
+	if h.cfg.MaxRetries != nil {
+		return *h.cfg.MaxRetries
+	}
+	return defaultMaxRetries
+}
+
+// delivery is one event on its way to one webhook.
+type delivery struct {
+	hook    string
+	event   Event
+	body    []byte
+	attempt int
+}
+
+// Webhooks POSTs bus events to the configured webhooks. Failed deliveries
+// are retried with exponential backoff without holding up other events.
+type Webhooks struct {
+	bus    *Bus
+	client *http.Client
+	queue  chan *delivery
+
+	mu    sync.Mutex
+	hooks map[string]*webhook
+	order []string
+}
+
+// NewWebhooks creates the dispatcher for a bus.
+func NewWebhooks(cfg config.EventsConfig, bus *Bus) *Webhooks {
+	w := &Webhooks{
+		bus:    bus,
+		client: &http.Client{Timeout: deliveryTimeout},
+		queue:  make(chan *delivery, webhookQueue),
+		hooks:  make(map[string]*webhook),
+	}
+	w.SetConfig(cfg)
+	return w
+}
+
+// SetConfig replaces the webhooks. Counters are kept for webhooks whose
+// name is unchanged; deliveries to removed webhooks are dropped.
+func (w *Webhooks) SetConfig(cfg config.EventsConfig) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hooks := make(map[string]*webhook)
+	var order []string
+	for _, c := range cfg.Webhooks {
+		if c.Name == "" || c.URL == "" {
+			log.Errorf("Webhook %q: name and url are required, skipping", c.Name)
+			continue
+		}
+		if _, dup := hooks[c.Name]; dup {
+			log.Errorf("Webhook %q: duplicate name, skipping", c.Name)
+			continue
+		}
+		h := &webhook{cfg: c}
+		if len(c.Events) > 0 {
+			h.events = make(map[string]bool)
+			for _, t := range c.Events {
+				h.events[t] = true
+			}
+		}
+		if len(c.Servers) > 0 {
+			h.servers = make(map[string]bool)
+			for _, s := range c.Servers {
+				h.servers[s] = true
+			}
+		}
+		if old := w.hooks[c.Name]; old != nil {
+			h.status = old.status
+		}
+		h.status.Name, h.status.URL, h.status.Signed = c.Name, redactURL(c.URL), c.Secret != ""
+		h.status.Events, h.status.Servers = c.Events, c.Servers
+		hooks[c.Name] = h
+		order = append(order, c.Name)
+	}
+	w.hooks, w.order = hooks, order
+	if len(order) > 0 {
+		log.Infof("Webhooks: %s", strings.Join(order, ", "))
+	}
+}
+
+// Run delivers events until ctx is done.
+func (w *Webhooks) Run(ctx context.Context) {
+	events := w.bus.Subscribe()
+	defer w.bus.Unsubscribe(events)
+	for i := 0; i < webhookWorkers; i++ {
+		go w.worker(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			w.dispatch(ev)
+		}
+	}
+}
+
+// dispatch queues an event for every webhook that wants it.
+func (w *Webhooks) dispatch(ev Event) {
+	w.mu.Lock()
+	var names []string
+	for _, name := range w.order {
+		if w.hooks[name].wants(ev) {
+			names = append(names, name)
+		}
+	}
+	w.mu.Unlock()
+	if len(names) == 0 {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Errorf("Webhooks: encoding %s event: %v", ev.Type, err)
+		return
+	}
+	for _, name := range names {
+		w.enqueue(&delivery{hook: name, event: ev, body: body})
+	}
+}
+
+func (w *Webhooks) enqueue(d *delivery) {
+	select {
+	case w.queue <- d:
+	default:
+		log.Warnf("Webhook queue full, dropping %s event for %s", d.event.Type, d.hook)
+	}
+}
+
+func (w *Webhooks) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-w.queue:
+			w.attempt(ctx, d)
+		}
+	}
+}
+
+// attempt makes one delivery attempt and schedules a retry if it failed
+// and may succeed later.
+func (w *Webhooks) attempt(ctx context.Context, d *delivery) {
+	w.mu.Lock()
+	h := w.hooks[d.hook]
+	var cfg config.Webhook
+	maxRetries := 0
+	if h != nil {
+		cfg, maxRetries = h.cfg, h.maxRetries()
+	}
+	w.mu.Unlock()
+	if h == nil {
+		return // removed by a reload
+	}
+
+	retry, err := w.post(ctx, cfg, d.event, d.body)
+	if ctx.Err() != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Counters go to the current webhook of that name, which a reload may
+	// have replaced
+	st := &h.status
+	if cur := w.hooks[d.hook]; cur != nil {
+		st = &cur.status
+	}
+	if err == nil {
+		st.Delivered++
+		st.LastDelivery = time.Now()
+		log.Debugf("Webhook %s: delivered %s %s", d.hook, d.event.Type, d.event.ID)
+		return
+	}
+	st.LastError, st.LastErrorAt = err.Error(), time.Now()
+	if !retry || d.attempt >= maxRetries {
+		st.Failed++
+		log.Errorf("Webhook %s: %s event %s failed after %d attempts: %v", d.hook, d.event.Type, d.event.ID, d.attempt+1, err)
+		return
+	}
+	st.Retries++
+	wait := retryDelay(d.attempt)
+	log.Warnf("Webhook %s: %s event %s failed (%v), retrying in %v", d.hook, d.event.Type, d.event.ID, err, wait)
+	d.attempt++
+	time.AfterFunc(wait, func() {
+		if ctx.Err() == nil {
+			w.enqueue(d)
+		}
+	})
+}
+
+// retryDelay is the backoff before retry n+1: 1s, 2s, 4s... up to
+// maxRetryDelay.
+func retryDelay(n int) time.Duration {
+	if n > 16 {
+		return maxRetryDelay
+	}
+	d := time.Second << n
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+// post sends one signed request. retry reports whether a failure is worth
+// retrying: network errors, 5xx and 429 are, other responses are not.
+func (w *Webhooks) post(ctx context.Context, cfg config.Webhook, ev Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ipmiserial-webhook")
+	req.Header.Set(HeaderEvent, ev.Type)
+	req.Header.Set(HeaderDelivery, ev.ID)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderTimestamp, ts)
+	if cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(cfg.Secret, ts, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Sign returns the X-Ipmiserial-Signature value for a body sent at
+// timestamp ts. Receivers recompute it with their copy of the secret and
+// compare in constant time, and should reject stale timestamps.
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Status returns every webhook's counters, in configuration order.
+func (w *Webhooks) Status() []WebhookStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]WebhookStatus, 0, len(w.order))
+	for _, name := range w.order {
+		out = append(out, w.hooks[name].status)
+	}
+	return out
+}
+
+// Has reports whether a webhook is configured.
+func (w *Webhooks) Has(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.hooks[name] != nil
+}
+
+// Test sends a sample event to one webhook, synchronously and without
+// retries.
+func (w *Webhooks) Test(ctx context.Context, name string) error {
+	w.mu.Lock()
+	h := w.hooks[name]
+	w.mu.Unlock()
+	if h == nil {
+		return fmt.Errorf("unknown webhook %q", name)
+	}
+	ev := Event{ID: newEventID(), Type: "test", Server: "ipmiserial", Time: time.Now(), Detail: "Test event from ipmiserial"}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = w.post(ctx, h.cfg, ev, body)
+	return err
+}
+
+// redactURL drops credentials and the query from a URL shown in status,
+// since webhook URLs often embed tokens.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
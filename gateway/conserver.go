@@ -0,0 +1,491 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/sol"
+	"ipmiserial/vt"
+)
+
+// conserverEscape is conserver's default escape sequence, ^E c.
+var conserverEscape = [2]byte{0x05, 'c'}
+
+// conserverReplayRows is how much of the screen ^Ec r replays.
+const conserverReplayRows = 20
+
+const conserverHelp = "help\r\n" +
+	" .    disconnect\r\n" +
+	" a    attach read-write\r\n" +
+	" f    force attach read-write\r\n" +
+	" s    switch to read-only (spy)\r\n" +
+	" w    who is on this console\r\n" +
+	" u    show host status\r\n" +
+	" r    replay the screen\r\n" +
+	" o    reopen the SOL session\r\n" +
+	" l0   send a break\r\n" +
+	" eXY  change the escape sequence to XY\r\n" +
+	" ?    print this message\r\n"
+
+// Conserver speaks the conserver client protocol, so `console -M host -p
+// port <server>` attaches to a console as it would to a conserver master.
+// The listener is both master and group server: `call` answers with its own
+// port. A read-write attach holds the console's input like the API's
+// /input/acquire; spies only watch.
+type Conserver struct {
+	consoles
+	port int
+
+	mu       sync.RWMutex
+	users    map[string]string // login -> password
+	readOnly map[string]bool
+}
+
+// NewConserver returns a gateway for the conserver settings.
+func NewConserver(cfg config.ConserverConfig, scanner *discovery.Scanner, solManager *sol.Manager) *Conserver {
+	g := &Conserver{
+		consoles: consoles{scanner: scanner, solManager: solManager},
+		port:     cfg.Port,
+	}
+	g.SetUsers(cfg)
+	return g
+}
+
+// SetUsers replaces the logins and read-only list; sessions already
+// attached keep their mode.
+func (g *Conserver) SetUsers(cfg config.ConserverConfig) {
+	readOnly := make(map[string]bool, len(cfg.ReadOnly))
+	for _, u := range cfg.ReadOnly {
+		readOnly[u] = true
+	}
+	if len(cfg.Users) == 0 {
+		log.Warn("Conserver gateway has no users; any login is accepted without a password")
+	}
+	g.mu.Lock()
+	g.users = cfg.Users
+	g.readOnly = readOnly
+	g.mu.Unlock()
+}
+
+// Run accepts conserver clients until ctx is done.
+func (g *Conserver) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", g.port))
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	log.Infof("Conserver gateway on port %d", g.port)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Warnf("Conserver accept failed: %v", err)
+			continue
+		}
+		go g.handleConn(ctx, conn)
+	}
+}
+
+// handleConn runs the command phase: login, then call or attach.
+func (g *Conserver) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	host := conn.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	reply("ok")
+	var user string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		arg = strings.TrimSpace(arg)
+
+		switch cmd {
+		case "login":
+			if arg == "" {
+				reply("login requires a user name")
+				continue
+			}
+			if ok := g.login(conn, r, arg); !ok {
+				log.Warnf("Conserver login as %q from %s failed", arg, host)
+				reply("invalid password")
+				continue
+			}
+			user = arg
+			reply("ok")
+		case "call", "attach", "spy", "force":
+			if user == "" {
+				reply("login first")
+				continue
+			}
+			name, err := g.lookup(arg)
+			if err != nil {
+				reply("%v", err)
+				continue
+			}
+			if cmd == "call" {
+				// This listener is also the group server for every console
+				reply("%d", g.port)
+				continue
+			}
+			g.attachConsole(ctx, conn, r, name, user, user+"@"+host, cmd)
+			return
+		case "groups":
+			reply("%d", g.port)
+		case "hosts":
+			if user == "" {
+				reply("login first")
+				continue
+			}
+			var b strings.Builder
+			for _, c := range g.states() {
+				fmt.Fprintf(&b, "%s:%s:%s\r\n", c.name, c.state, c.holder)
+			}
+			conn.Write([]byte(b.String()))
+		case "pid":
+			reply("%d", os.Getpid())
+		case "help":
+			reply("login <user>; call|attach|spy|force <console>; groups; hosts; pid; exit")
+		case "exit", "bye", "quit":
+			reply("goodbye")
+			return
+		case "":
+		default:
+			reply("unknown command")
+		}
+	}
+}
+
+// login checks a user, prompting for the password when users are
+// configured.
+func (g *Conserver) login(conn net.Conn, r *bufio.Reader, user string) bool {
+	g.mu.RLock()
+	users := g.users
+	g.mu.RUnlock()
+	if len(users) == 0 {
+		return true
+	}
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(conn, "passwd? %s\r\n", hostname)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	want, ok := users[user]
+	got := strings.TrimRight(line, "\r\n")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// lookup resolves a console name, or an unambiguous prefix of one.
+func (g *Conserver) lookup(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("console name required")
+	}
+	servers := g.scanner.GetServers()
+	if _, ok := servers[name]; ok {
+		return name, nil
+	}
+	var matches []string
+	for n := range servers {
+		if strings.HasPrefix(n, name) {
+			matches = append(matches, n)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("console `%s' not found", name)
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", fmt.Errorf("ambiguous console abbreviation, `%s': %s", name, strings.Join(matches, ", "))
+}
+
+// conserverSession is a client attached to a console.
+type conserverSession struct {
+	g        *Conserver
+	conn     net.Conn
+	name     string
+	who      string
+	readOnly bool // the login may only spy
+
+	rw  bool
+	esc [2]byte
+}
+
+// attachConsole bridges an attached client to the console: mode is attach,
+// spy or force.
+func (g *Conserver) attachConsole(ctx context.Context, conn net.Conn, r *bufio.Reader, name, user, who, mode string) {
+	g.mu.RLock()
+	readOnly := g.readOnly[user] || g.solManager.ReadOnly()
+	g.mu.RUnlock()
+	s := &conserverSession{g: g, conn: conn, name: name, who: who, readOnly: readOnly, esc: conserverEscape}
+
+	catchup, out := g.solManager.Attach(name, -1)
+	defer g.solManager.Unsubscribe(name, out)
+	defer g.solManager.TrackViewer(name, who, "conserver", readOnly || mode == "spy")()
+	log.Infof("Conserver %s by %s for console %s", mode, who, name)
+
+	if mode == "spy" {
+		s.msg("spy")
+	} else {
+		s.acquire(mode == "force")
+	}
+	if len(catchup.Data) > 0 {
+		conn.Write(append([]byte("\x1b[2J\x1b[H"), catchup.Data...))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.readInput(r)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.msg("console server shutting down")
+			return
+		case <-done:
+			return
+		case chunk, ok := <-out:
+			if !ok {
+				s.msg("console down")
+				return
+			}
+			if _, err := conn.Write(chunk.Data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// msg writes a bracketed status line, as conserver does.
+func (s *conserverSession) msg(format string, args ...interface{}) {
+	fmt.Fprintf(s.conn, "["+format+"]\r\n", args...)
+}
+
+// acquire takes the console read-write, falling back to spying.
+func (s *conserverSession) acquire(force bool) {
+	if s.readOnly {
+		s.msg("read-only -- %s may only spy", s.who)
+		return
+	}
+	if err := s.g.solManager.AcquireInput(s.name, s.who, force); err != nil {
+		s.rw = false
+		s.msg("read-only -- %v, use %sf to force", err, s.escString())
+		return
+	}
+	s.rw = true
+	s.msg("attached")
+}
+
+func (s *conserverSession) escString() string {
+	show := func(c byte) string {
+		if c < 0x20 {
+			return "^" + string(c+'@')
+		}
+		return string(c)
+	}
+	return show(s.esc[0]) + show(s.esc[1])
+}
+
+// readInput passes keystrokes to the console and runs escape commands,
+// until the client disconnects.
+func (s *conserverSession) readInput(r io.Reader) {
+	const (
+		stateData = iota
+		stateEsc1 // first escape character seen
+		stateCmd  // full escape sequence seen
+		stateBreak
+		stateNewEsc
+	)
+	state := stateData
+	var newEsc []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		var data []byte
+		for _, c := range buf[:n] {
+			switch state {
+			case stateData:
+				if c == s.esc[0] {
+					state = stateEsc1
+				} else {
+					data = append(data, c)
+				}
+			case stateEsc1:
+				state = stateData
+				if c == s.esc[1] {
+					state = stateCmd
+				} else {
+					data = append(data, s.esc[0], c)
+				}
+			case stateCmd:
+				s.input(data)
+				data = nil
+				state = stateData
+				switch c {
+				case '.':
+					s.msg("disconnect")
+					return
+				case 'l':
+					state = stateBreak
+				case 'e':
+					state, newEsc = stateNewEsc, nil
+				default:
+					s.command(c)
+				}
+			case stateBreak:
+				state = stateData
+				switch {
+				case c == '?':
+					s.msg("breaks: l0-l9 send a serial break")
+				case c >= '0' && c <= '9':
+					if !s.rw {
+						s.msg("read-only -- use %sa to attach", s.escString())
+					} else if err := s.g.sendBreak(s.name, s.who); err != nil {
+						s.msg("break failed: %v", err)
+					} else {
+						s.msg("halt sent")
+					}
+				default:
+					s.msg("unknown break -- use %sl?", s.escString())
+				}
+			case stateNewEsc:
+				newEsc = append(newEsc, c)
+				if len(newEsc) == 2 {
+					s.esc = [2]byte{newEsc[0], newEsc[1]}
+					state = stateData
+					s.msg("redef escape sequence to %s", s.escString())
+				}
+			}
+		}
+		s.input(data)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// input sends typed data to the console, or reminds a spy it is read-only.
+func (s *conserverSession) input(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if !s.rw {
+		s.msg("read-only -- use %sa to attach, %s? for help", s.escString(), s.escString())
+		return
+	}
+	if err := s.g.sendInput(s.name, s.who, data); err != nil {
+		s.msg("input rejected: %v", err)
+	}
+}
+
+// command runs a one-character escape command.
+func (s *conserverSession) command(c byte) {
+	sm := s.g.solManager
+	switch c {
+	case 'a', 'f':
+		s.acquire(c == 'f')
+	case 's':
+		if s.rw {
+			sm.ReleaseInput(s.name, s.who, false)
+			s.rw = false
+		}
+		s.msg("spy")
+	case 'w':
+		holder := sm.InputHolder(s.name)
+		var who []string
+		for _, v := range sm.Viewers(s.name) {
+			if v == holder {
+				v += " (rw)"
+			}
+			who = append(who, v)
+		}
+		s.msg("who: %s", strings.Join(who, ", "))
+	case 'u', 'i':
+		s.status()
+	case 'r':
+		term := vt.New(80, 25)
+		term.Write(sm.GetScreenBuffer(s.name))
+		lines := strings.Split(strings.TrimRight(term.String(), "\n"), "\n")
+		if len(lines) > conserverReplayRows {
+			lines = lines[len(lines)-conserverReplayRows:]
+		}
+		s.msg("replay")
+		fmt.Fprintf(s.conn, "%s\r\n", strings.Join(lines, "\r\n"))
+	case 'o':
+		if !s.rw {
+			s.msg("read-only -- use %sa to attach", s.escString())
+			return
+		}
+		s.msg("reopening SOL session")
+		go sm.RestartSession(s.name)
+	case '?', 'h':
+		fmt.Fprint(s.conn, "["+conserverHelp+"]\r\n")
+	default:
+		s.msg("unknown -- use %s? for help", s.escString())
+	}
+}
+
+type consoleState struct {
+	name, state, holder string
+}
+
+// states returns every console with its SOL state and read-write holder,
+// by name.
+func (g *Conserver) states() []consoleState {
+	servers := g.scanner.GetServers()
+	names := make([]string, 0, len(servers))
+	for n := range servers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	out := make([]consoleState, 0, len(names))
+	for _, n := range names {
+		c := consoleState{name: n, state: "down", holder: g.solManager.InputHolder(n)}
+		if session := g.solManager.GetSession(n); session != nil && session.Connected {
+			c.state = "up"
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// status lists every console with its SOL state and read-write holder.
+func (s *conserverSession) status() {
+	var b strings.Builder
+	b.WriteString("status\r\n")
+	for _, c := range s.g.states() {
+		line := fmt.Sprintf(" %-20s %-4s %s", c.name, c.state, c.holder)
+		b.WriteString(strings.TrimRight(line, " ") + "\r\n")
+	}
+	fmt.Fprint(s.conn, "["+b.String()+"]\r\n")
+}
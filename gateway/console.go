@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"ipmiserial/discovery"
+	"ipmiserial/sol"
+)
+
+// detachKey (Ctrl-]) ends a console session, as in telnet. ssh itself
+// intercepts ~. so the usual escape never reaches the gateway.
+const detachKey = 0x1d
+
+// errReadOnly rejects console input while server.read_only is set.
+var errReadOnly = errors.New("server is read-only")
+
+// consoles gives a gateway access to the servers and their SOL streams.
+type consoles struct {
+	scanner    *discovery.Scanner
+	solManager *sol.Manager
+}
+
+// attach bridges a client to a console until it detaches or the stream ends:
+// the screen so far, then live output, with everything the client types sent
+// as input. It returns the exit status for the client.
+func (c *consoles) attach(ctx context.Context, ch io.ReadWriter, name, who, via string) uint32 {
+	servers := c.scanner.GetServers()
+	if _, ok := servers[name]; !ok {
+		names := make([]string, 0, len(servers))
+		for n := range servers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(ch, "Unknown server %q. Connect as one of:\r\n  %s\r\n", name, strings.Join(names, "\r\n  "))
+		return 1
+	}
+
+	readOnly := c.solManager.ReadOnly()
+	catchup, out := c.solManager.Attach(name, -1)
+	defer c.solManager.Unsubscribe(name, out)
+	defer c.solManager.TrackViewer(name, who, via, readOnly)()
+
+	if readOnly {
+		fmt.Fprintf(ch, "[ipmiserial] Connected to %s console, read-only. Press Ctrl-] to detach.\r\n", name)
+	} else {
+		fmt.Fprintf(ch, "[ipmiserial] Connected to %s console. Press Ctrl-] to detach.\r\n", name)
+	}
+	if len(catchup.Data) > 0 {
+		ch.Write(append([]byte("\x1b[2J\x1b[H"), catchup.Data...))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1024)
+		for {
+			n, err := ch.Read(buf)
+			if n > 0 {
+				data := buf[:n]
+				detach := false
+				if i := strings.IndexByte(string(data), detachKey); i >= 0 {
+					data, detach = data[:i], true
+				}
+				if len(data) > 0 {
+					if err := c.sendInput(name, who, data); err != nil {
+						fmt.Fprintf(ch, "\r\n[ipmiserial] input rejected: %v\r\n", err)
+					}
+				}
+				if detach {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprint(ch, "\r\n[ipmiserial] Server shutting down\r\n")
+			return 0
+		case <-done:
+			fmt.Fprint(ch, "\r\n[ipmiserial] Detached\r\n")
+			return 0
+		case chunk, ok := <-out:
+			if !ok {
+				fmt.Fprint(ch, "\r\n[ipmiserial] Console stream closed\r\n")
+				return 0
+			}
+			if _, err := ch.Write(chunk.Data); err != nil {
+				return 0
+			}
+		}
+	}
+}
+
+// sendInput passes a gateway client's input to the console, unless the
+// server is read-only.
+func (c *consoles) sendInput(name, who string, data []byte) error {
+	if c.solManager.ReadOnly() {
+		return errReadOnly
+	}
+	return c.solManager.SendInput(name, who, data)
+}
+
+// sendBreak sends a gateway client's serial break, unless the server is
+// read-only.
+func (c *consoles) sendBreak(name, who string) error {
+	if c.solManager.ReadOnly() {
+		return errReadOnly
+	}
+	return c.solManager.SendBreak(name, who, "")
+}
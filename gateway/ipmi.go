@@ -0,0 +1,752 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/sol"
+)
+
+// IPMI commands served (NetFn App)
+const (
+	netFnApp                  = 0x06
+	cmdGetDeviceID            = 0x01
+	cmdGetChannelAuthCaps     = 0x38
+	cmdSetSessionPriv         = 0x3B
+	cmdCloseSession           = 0x3C
+	cmdActivatePayload        = 0x48
+	cmdDeactivatePayload      = 0x49
+	cmdGetChannelCipherSuites = 0x54
+)
+
+// IPMI completion codes
+const (
+	ccOK               = 0x00
+	ccPayloadState     = 0x80 // activate: already active; deactivate: not active
+	ccPrivExceeded     = 0x81
+	ccInvalidCommand   = 0xC1
+	ccDataLength       = 0xC7
+	ccInvalidField     = 0xCC
+	ccInsufficientPriv = 0xD4
+)
+
+const (
+	ipmiDefaultPort    = "623"
+	ipmiMaxSessions    = 16
+	ipmiSessionTimeout = 60 * time.Second // idle sessions are dropped, as BMCs do
+	ipmiSweepInterval  = 10 * time.Second
+
+	// SOL payload size offered both ways, header included
+	solPayloadSize = 255
+	solHeaderSize  = 4
+	solRetry       = time.Second
+	solRetries     = 5
+	solQueueMax    = 64 << 10 // output kept for a console that is not acking
+)
+
+// cipherSuites lists the supported suites as Get Channel Cipher Suites
+// records: HMAC-SHA1 or HMAC-SHA256 authentication, with or without
+// integrity and AES-CBC-128. console_proxy.security narrows them.
+var cipherSuites = []byte{
+	0xC0, 1, authHMACSHA1, 0x40 | integrityNone, 0x80 | cryptNone,
+	0xC0, 2, authHMACSHA1, 0x40 | integrityHMACSHA1, 0x80 | cryptNone,
+	0xC0, 3, authHMACSHA1, 0x40 | integrityHMACSHA1, 0x80 | cryptAESCBC,
+	0xC0, 15, authHMACSHA256, 0x40 | integrityNone, 0x80 | cryptNone,
+	0xC0, 16, authHMACSHA256, 0x40 | integrityHMACSHA256, 0x80 | cryptNone,
+	0xC0, 17, authHMACSHA256, 0x40 | integrityHMACSHA256, 0x80 | cryptAESCBC,
+}
+
+// IPMI answers `ipmitool -I lanplus -H <address> sol activate` on each
+// configured address as the server's BMC would, with the SOL payload bridged
+// to the managed console. Only SOL is served: anything else a BMC does is
+// refused as an invalid command.
+type IPMI struct {
+	consoles
+	username  string
+	password  string
+	security  string // console_proxy.security; "" = integrity
+	suites    []byte // cipherSuites records the security allows
+	listeners []config.ProxyListener
+}
+
+// NewIPMI returns a gateway for the console_proxy.ipmi listeners.
+func NewIPMI(cfg config.ConsoleProxyConfig, scanner *discovery.Scanner, solManager *sol.Manager) *IPMI {
+	g := &IPMI{
+		consoles:  consoles{scanner: scanner, solManager: solManager},
+		username:  cfg.Username,
+		password:  cfg.Password,
+		security:  cfg.Security,
+		listeners: cfg.IPMI,
+	}
+	for i := 0; i+5 <= len(cipherSuites); i += 5 {
+		if g.allows(cipherSuites[i+3]&0x3f, cipherSuites[i+4]&0x3f) {
+			g.suites = append(g.suites, cipherSuites[i:i+5]...)
+		}
+	}
+	return g
+}
+
+// allows reports whether console_proxy.security accepts a session with
+// these integrity and confidentiality algorithms: by default integrity is
+// required, with encryption AES as well, and with none anything goes.
+func (g *IPMI) allows(integrityAlg, cryptAlg uint8) bool {
+	switch g.security {
+	case "none":
+		return true
+	case "encryption":
+		return integrityAlg != integrityNone && cryptAlg == cryptAESCBC
+	default:
+		return integrityAlg != integrityNone
+	}
+}
+
+// Run serves every listener until ctx is done.
+func (g *IPMI) Run(ctx context.Context) error {
+	var ls []*ipmiListener
+	closeAll := func() {
+		for _, l := range ls {
+			l.conn.Close()
+		}
+	}
+	for _, cfg := range g.listeners {
+		addr := cfg.Address
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, ipmiDefaultPort)
+		}
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		guid := sha256.Sum256([]byte(cfg.Server))
+		l := &ipmiListener{
+			g:        g,
+			conn:     conn,
+			name:     cfg.Server,
+			port:     uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+			sessions: make(map[uint32]*ipmiSession),
+		}
+		copy(l.guid[:], guid[:])
+		ls = append(ls, l)
+		log.Infof("IPMI SOL proxy for %s on %s", cfg.Server, conn.LocalAddr())
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range ls {
+		wg.Add(1)
+		go func(l *ipmiListener) {
+			defer wg.Done()
+			l.serve(ctx)
+		}(l)
+	}
+	<-ctx.Done()
+	closeAll()
+	wg.Wait()
+	return nil
+}
+
+// kuid is the RAKP user key: the password, padded or cut to 20 bytes.
+func (g *IPMI) kuid() []byte {
+	k := make([]byte, 20)
+	copy(k, g.password)
+	return k
+}
+
+// ipmiListener is one address answering for one server. Sessions are only
+// touched by its serve loop; SOL output is sent from each session's pump.
+type ipmiListener struct {
+	g        *IPMI
+	conn     net.PacketConn
+	name     string
+	port     uint16
+	guid     [16]byte
+	sessions map[uint32]*ipmiSession // by managed system session ID
+}
+
+// ipmiSession is an RMCP+ session, from Open Session until closed or idle.
+type ipmiSession struct {
+	l         *ipmiListener
+	addr      net.Addr
+	id        uint32 // managed system (our) session ID
+	consoleID uint32 // remote console session ID
+	who       string
+
+	authAlg, integrityAlg, cryptAlg uint8
+
+	user     string
+	role     uint8
+	priv     uint8
+	rm, rc   []byte // console and managed system RAKP random numbers
+	sik      []byte
+	k1, k2   []byte
+	active   bool // RAKP completed
+	lastSeen time.Time
+	sol      *solPayload
+
+	mu     sync.Mutex // outSeq and sends
+	outSeq uint32
+}
+
+// solPayload is an activated SOL payload.
+type solPayload struct {
+	stop  chan struct{}
+	acks  chan solAck
+	notes chan []byte // messages for the client, sent as console output
+	inSeq uint8       // last packet received from the console
+}
+
+// solAck is the console's acknowledgement of a packet sent to it.
+type solAck struct {
+	seq      uint8
+	accepted uint8
+	nack     bool
+}
+
+func (l *ipmiListener) serve(ctx context.Context) {
+	buf := make([]byte, 2048)
+	lastSweep := time.Now()
+	for {
+		l.conn.SetReadDeadline(time.Now().Add(ipmiSweepInterval))
+		n, addr, err := l.conn.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				log.Warnf("IPMI proxy for %s: %v", l.name, err)
+			}
+		} else {
+			l.handle(append([]byte(nil), buf[:n]...), addr)
+		}
+		if time.Since(lastSweep) >= ipmiSweepInterval {
+			l.expire()
+			lastSweep = time.Now()
+		}
+	}
+}
+
+// expire drops sessions the console has stopped talking to.
+func (l *ipmiListener) expire() {
+	for id, s := range l.sessions {
+		if time.Since(s.lastSeen) > ipmiSessionTimeout {
+			if s.active {
+				log.Infof("IPMI session %s for %s timed out", s.who, l.name)
+			}
+			s.stopSOL()
+			delete(l.sessions, id)
+		}
+	}
+}
+
+func (l *ipmiListener) authLen(sessionID uint32) int {
+	if s := l.sessions[sessionID]; s != nil {
+		return integrityLen(s.integrityAlg)
+	}
+	return 0
+}
+
+func (l *ipmiListener) handle(b []byte, addr net.Addr) {
+	p, err := parseRMCP(b, l.authLen)
+	if err != nil {
+		log.Debugf("IPMI proxy for %s: %v from %s", l.name, err, addr)
+		return
+	}
+	payloadType := p.payloadType &^ (payloadEncrypted | payloadAuthenticated)
+
+	if p.sessionID == 0 {
+		switch {
+		case !p.v20 || payloadType == payloadIPMI:
+			l.handleIPMI(nil, addr, p.v20, p.payload)
+		case payloadType == payloadOpenReq:
+			l.openSession(addr, p.payload)
+		case payloadType == payloadRAKP1:
+			l.rakp1(addr, p.payload)
+		case payloadType == payloadRAKP3:
+			l.rakp3(addr, p.payload)
+		}
+		return
+	}
+
+	s := l.sessions[p.sessionID]
+	if s == nil || !s.active || !p.v20 || s.addr.String() != addr.String() {
+		return
+	}
+	payload, ok := s.unseal(p)
+	if !ok {
+		log.Debugf("IPMI proxy for %s: dropped a packet failing integrity from %s", l.name, addr)
+		return
+	}
+	s.lastSeen = time.Now()
+	switch payloadType {
+	case payloadIPMI:
+		l.handleIPMI(s, addr, true, payload)
+	case payloadSOL:
+		l.handleSOL(s, payload)
+	}
+}
+
+// unseal checks a session packet's AuthCode and decrypts its payload.
+func (s *ipmiSession) unseal(p *rmcpPacket) ([]byte, bool) {
+	if s.integrityAlg != integrityNone {
+		if p.authCode == nil {
+			return nil, false
+		}
+		mac := hmacFor(s.integrityAlg, s.k1, p.signed)
+		if !hmac.Equal(mac[:len(p.authCode)], p.authCode) {
+			return nil, false
+		}
+	}
+	if s.cryptAlg == cryptNone {
+		return p.payload, true
+	}
+	if p.payloadType&payloadEncrypted == 0 {
+		return nil, false
+	}
+	payload, err := decryptAES(s.k2[:16], p.payload)
+	return payload, err == nil
+}
+
+// send seals a payload for the session and sends it to the console.
+func (s *ipmiSession) send(payloadType uint8, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outSeq++
+	if s.cryptAlg == cryptAESCBC {
+		payload = encryptAES(s.k2[:16], payload)
+		payloadType |= payloadEncrypted
+	}
+	if s.integrityAlg != integrityNone {
+		payloadType |= payloadAuthenticated
+	}
+	pkt := buildRMCP(true, payloadType, s.consoleID, s.outSeq, payload)
+	if s.integrityAlg != integrityNone {
+		// Integrity pad: AuthType through Next Header is a multiple of 4
+		pad := (4 - (len(pkt)-4+2)%4) % 4
+		pkt = append(pkt, bytes.Repeat([]byte{0xff}, pad)...)
+		pkt = append(pkt, uint8(pad), 0x07)
+		pkt = append(pkt, hmacFor(s.integrityAlg, s.k1, pkt[4:])[:integrityLen(s.integrityAlg)]...)
+	}
+	s.l.conn.WriteTo(pkt, s.addr)
+}
+
+// openSession answers an RMCP+ Open Session Request.
+func (l *ipmiListener) openSession(addr net.Addr, req []byte) {
+	if len(req) < 32 {
+		return
+	}
+	resp := make([]byte, 8, 36)
+	resp[0] = req[0]
+	copy(resp[4:8], req[4:8])
+	fail := func(status uint8) {
+		resp[1] = status
+		l.conn.WriteTo(buildRMCP(true, payloadOpenResp, 0, 0, resp), addr)
+	}
+
+	authAlg := algorithm(req[8:16], authHMACSHA1)
+	integrityAlg := algorithm(req[16:24], integrityHMACSHA1)
+	cryptAlg := algorithm(req[24:32], cryptAESCBC)
+	switch {
+	case authAlg != authHMACSHA1 && authAlg != authHMACSHA256:
+		fail(rmcpStatusInvalidAuth)
+		return
+	case integrityAlg != integrityNone && integrityAlg != integrityHMACSHA1 && integrityAlg != integrityHMACSHA256:
+		fail(rmcpStatusInvalidIntegrity)
+		return
+	case cryptAlg != cryptNone && cryptAlg != cryptAESCBC:
+		fail(rmcpStatusInvalidCrypt)
+		return
+	case integrityAlg == integrityNone && !l.g.allows(integrityAlg, cryptAlg):
+		log.Warnf("IPMI session for %s from %s refused: no integrity (console_proxy.security)", l.name, addr)
+		fail(rmcpStatusInvalidIntegrity)
+		return
+	case !l.g.allows(integrityAlg, cryptAlg):
+		log.Warnf("IPMI session for %s from %s refused: no encryption (console_proxy.security)", l.name, addr)
+		fail(rmcpStatusInvalidCrypt)
+		return
+	case len(l.sessions) >= ipmiMaxSessions:
+		l.expire()
+		if len(l.sessions) >= ipmiMaxSessions {
+			fail(rmcpStatusNoResources)
+			return
+		}
+	}
+
+	var id uint32
+	for id == 0 || l.sessions[id] != nil {
+		var b [4]byte
+		rand.Read(b[:])
+		id = binary.LittleEndian.Uint32(b[:])
+	}
+	l.sessions[id] = &ipmiSession{
+		l:            l,
+		addr:         addr,
+		id:           id,
+		consoleID:    binary.LittleEndian.Uint32(req[4:8]),
+		authAlg:      authAlg,
+		integrityAlg: integrityAlg,
+		cryptAlg:     cryptAlg,
+		lastSeen:     time.Now(),
+	}
+
+	resp[2] = privAdmin
+	resp = binary.LittleEndian.AppendUint32(resp, id)
+	for i, alg := range []uint8{authAlg, integrityAlg, cryptAlg} {
+		resp = append(resp, uint8(i), 0, 0, 8, alg, 0, 0, 0)
+	}
+	l.conn.WriteTo(buildRMCP(true, payloadOpenResp, 0, 0, resp), addr)
+}
+
+// algorithm reads an Open Session algorithm payload. An empty one lets the
+// managed system choose.
+func algorithm(b []byte, def uint8) uint8 {
+	if b[3] == 0 {
+		return def
+	}
+	return b[4] & 0x3f
+}
+
+// rakp1 checks the user and answers with RAKP Message 2, deriving the
+// session keys.
+func (l *ipmiListener) rakp1(addr net.Addr, req []byte) {
+	if len(req) < 28 || len(req) < 28+int(req[27]) {
+		return
+	}
+	s := l.sessions[binary.LittleEndian.Uint32(req[4:8])]
+	resp := []byte{req[0], 0, 0, 0}
+	if s == nil || s.active || s.addr.String() != addr.String() {
+		resp[1] = rmcpStatusInvalidSession
+		l.conn.WriteTo(buildRMCP(true, payloadRAKP2, 0, 0, append(resp, 0, 0, 0, 0)), addr)
+		return
+	}
+	resp = binary.LittleEndian.AppendUint32(resp, s.consoleID)
+
+	role := req[24]
+	user := string(req[28 : 28+int(req[27])])
+	status := uint8(0)
+	switch {
+	case role&0x0f > privAdmin:
+		status = rmcpStatusInvalidRole
+	case user != l.g.username:
+		status = rmcpStatusUnauthorizedName
+	}
+	if status != 0 {
+		log.Warnf("IPMI login as %q for %s from %s refused", user, l.name, addr)
+		resp[1] = status
+		l.conn.WriteTo(buildRMCP(true, payloadRAKP2, 0, 0, resp), addr)
+		delete(l.sessions, s.id)
+		return
+	}
+
+	s.user, s.role, s.priv = user, role, role&0x0f
+	s.rm = append([]byte(nil), req[8:24]...)
+	s.rc = make([]byte, 16)
+	rand.Read(s.rc)
+	s.lastSeen = time.Now()
+
+	kuid := l.g.kuid()
+	name := append([]byte{role, uint8(len(user))}, user...)
+	s.sik = hmacFor(s.authAlg, kuid, s.rm, s.rc, name)
+	s.k1 = hmacFor(s.authAlg, s.sik, bytes.Repeat([]byte{1}, 20))
+	s.k2 = hmacFor(s.authAlg, s.sik, bytes.Repeat([]byte{2}, 20))
+
+	ids := binary.LittleEndian.AppendUint32(nil, s.consoleID)
+	ids = binary.LittleEndian.AppendUint32(ids, s.id)
+	resp = append(resp, s.rc...)
+	resp = append(resp, l.guid[:]...)
+	resp = append(resp, hmacFor(s.authAlg, kuid, ids, s.rm, s.rc, l.guid[:], name)...)
+	l.conn.WriteTo(buildRMCP(true, payloadRAKP2, 0, 0, resp), addr)
+}
+
+// rakp3 checks the console's proof of the password and completes the
+// session with RAKP Message 4.
+func (l *ipmiListener) rakp3(addr net.Addr, req []byte) {
+	if len(req) < 8 {
+		return
+	}
+	s := l.sessions[binary.LittleEndian.Uint32(req[4:8])]
+	resp := []byte{req[0], 0, 0, 0}
+	if s == nil || s.rc == nil || s.active || s.addr.String() != addr.String() {
+		resp[1] = rmcpStatusInvalidSession
+		l.conn.WriteTo(buildRMCP(true, payloadRAKP4, 0, 0, append(resp, 0, 0, 0, 0)), addr)
+		return
+	}
+	if req[1] != 0 {
+		// The console rejected RAKP 2
+		delete(l.sessions, s.id)
+		return
+	}
+	resp = binary.LittleEndian.AppendUint32(resp, s.consoleID)
+
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	want := hmacFor(s.authAlg, l.g.kuid(), s.rc, binary.LittleEndian.AppendUint32(nil, s.consoleID),
+		[]byte{s.role, uint8(len(s.user))}, []byte(s.user))
+	if !hmac.Equal(req[8:], want) {
+		log.Warnf("IPMI login as %q for %s from %s failed: wrong password", s.user, l.name, host)
+		resp[1] = rmcpStatusInvalidICV
+		l.conn.WriteTo(buildRMCP(true, payloadRAKP4, 0, 0, resp), addr)
+		delete(l.sessions, s.id)
+		return
+	}
+
+	icv := hmacFor(s.authAlg, s.sik, s.rm, binary.LittleEndian.AppendUint32(nil, s.id), l.guid[:])
+	resp = append(resp, icv[:rakpICVLen(s.authAlg)]...)
+	l.conn.WriteTo(buildRMCP(true, payloadRAKP4, 0, 0, resp), addr)
+
+	s.active = true
+	s.lastSeen = time.Now()
+	s.who = s.user + "@" + host
+	if s.user == "" {
+		s.who = "ipmi@" + host
+	}
+	log.Infof("IPMI session for %s opened by %s", l.name, s.who)
+}
+
+// handleIPMI answers an IPMI request, in or out of a session.
+func (l *ipmiListener) handleIPMI(s *ipmiSession, addr net.Addr, v20 bool, payload []byte) {
+	req, err := parseIPMIRequest(payload)
+	if err != nil {
+		return
+	}
+	cc, data := l.command(s, req)
+	resp := req.response(cc, data)
+	if s == nil {
+		l.conn.WriteTo(buildRMCP(v20, payloadIPMI, 0, 0, resp), addr)
+		return
+	}
+	s.send(payloadIPMI, resp)
+	if req.netFn == netFnApp && req.cmd == cmdCloseSession && cc == ccOK {
+		log.Infof("IPMI session %s for %s closed", s.who, l.name)
+		s.stopSOL()
+		delete(l.sessions, s.id)
+	}
+}
+
+// command runs an IPMI command; s is nil outside a session.
+func (l *ipmiListener) command(s *ipmiSession, req *ipmiRequest) (uint8, []byte) {
+	if req.netFn != netFnApp {
+		return ccInvalidCommand, nil
+	}
+	switch req.cmd {
+	case cmdGetDeviceID:
+		// Device 0x20 rev 1, firmware 1.0, IPMI 2.0
+		return ccOK, []byte{0x20, 0x01, 0x01, 0x00, 0x02, 0x00, 0, 0, 0, 0, 0}
+	case cmdGetChannelAuthCaps:
+		// IPMI v2.0 extended data, RMCP+ only; non-null or null user names
+		users := uint8(0x08)
+		if l.g.username == "" {
+			users = 0x04
+		}
+		return ccOK, []byte{0x01, 0x80, users, 0x02, 0, 0, 0, 0}
+	case cmdGetChannelCipherSuites:
+		if len(req.data) < 3 {
+			return ccDataLength, nil
+		}
+		// Records are read 16 bytes at a time; a short read ends the list
+		start := int(req.data[2]&0x3f) * 16
+		resp := []byte{0x01}
+		if suites := l.g.suites; start < len(suites) {
+			resp = append(resp, suites[start:min(start+16, len(suites))]...)
+		}
+		return ccOK, resp
+	}
+	if s == nil {
+		return ccInsufficientPriv, nil
+	}
+
+	switch req.cmd {
+	case cmdSetSessionPriv:
+		if len(req.data) < 1 {
+			return ccDataLength, nil
+		}
+		priv := req.data[0] & 0x0f
+		if priv > s.role&0x0f {
+			return ccPrivExceeded, nil
+		}
+		if priv != 0 {
+			s.priv = priv
+		}
+		return ccOK, []byte{s.priv}
+	case cmdCloseSession:
+		return ccOK, nil
+	case cmdActivatePayload:
+		if len(req.data) < 2 {
+			return ccDataLength, nil
+		}
+		if req.data[0]&0x3f != payloadSOL || req.data[1] != 1 {
+			return ccInvalidField, nil
+		}
+		if s.sol != nil {
+			return ccPayloadState, nil
+		}
+		l.startSOL(s)
+		resp := make([]byte, 4, 12)
+		resp = binary.LittleEndian.AppendUint16(resp, solPayloadSize)
+		resp = binary.LittleEndian.AppendUint16(resp, solPayloadSize)
+		resp = binary.LittleEndian.AppendUint16(resp, l.port)
+		return ccOK, append(resp, 0xff, 0xff)
+	case cmdDeactivatePayload:
+		if s.sol == nil {
+			return ccPayloadState, nil
+		}
+		s.stopSOL()
+		return ccOK, nil
+	}
+	return ccInvalidCommand, nil
+}
+
+// startSOL attaches the session to the console and starts sending output.
+func (l *ipmiListener) startSOL(s *ipmiSession) {
+	p := &solPayload{
+		stop:  make(chan struct{}),
+		acks:  make(chan solAck, 16),
+		notes: make(chan []byte, 4),
+	}
+	s.sol = p
+	sm := l.g.solManager
+	catchup, out := sm.Attach(l.name, -1)
+	untrack := sm.TrackViewer(l.name, s.who, "ipmi", sm.ReadOnly())
+	log.Infof("IPMI SOL for %s activated by %s", l.name, s.who)
+
+	var queue []byte
+	if len(catchup.Data) > 0 {
+		queue = append([]byte("\x1b[2J\x1b[H"), catchup.Data...)
+	}
+	go func() {
+		defer untrack()
+		defer sm.Unsubscribe(l.name, out)
+		s.pumpSOL(p, out, queue)
+	}()
+}
+
+// stopSOL deactivates the session's SOL payload, if active.
+func (s *ipmiSession) stopSOL() {
+	if s.sol != nil {
+		close(s.sol.stop)
+		s.sol = nil
+	}
+}
+
+// pumpSOL sends console output one packet at a time, resending a packet
+// until the console acknowledges it, and any characters it did not accept.
+func (s *ipmiSession) pumpSOL(p *solPayload, out chan sol.Chunk, queue []byte) {
+	var inflight []byte
+	var seq uint8
+	tries := 0
+	retry := time.NewTimer(solRetry)
+	retry.Stop()
+	defer retry.Stop()
+
+	send := func(status uint8) {
+		seq = seq%15 + 1
+		s.send(payloadSOL, append([]byte{seq, 0, 0, status}, inflight...))
+		retry.Reset(solRetry)
+	}
+
+	for {
+		if inflight == nil && len(queue) > 0 {
+			n := min(len(queue), solPayloadSize-solHeaderSize)
+			inflight = append([]byte(nil), queue[:n]...)
+			queue = queue[n:]
+			tries = 0
+			send(0)
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case chunk, ok := <-out:
+			if !ok {
+				// Tell the console the payload is gone
+				inflight = nil
+				send(0x10)
+				return
+			}
+			queue = append(queue, chunk.Data...)
+		case note := <-p.notes:
+			queue = append(queue, note...)
+		case ack := <-p.acks:
+			if inflight == nil || ack.seq != seq {
+				continue
+			}
+			// Characters not accepted are sent again in a new packet
+			if n := int(ack.accepted); ack.nack || n > 0 && n < len(inflight) {
+				queue = append(inflight[n:], queue...)
+			}
+			inflight = nil
+			retry.Stop()
+		case <-retry.C:
+			if inflight == nil {
+				continue
+			}
+			if tries++; tries > solRetries {
+				inflight = nil
+				continue
+			}
+			s.send(payloadSOL, append([]byte{seq, 0, 0, 0}, inflight...))
+			retry.Reset(solRetry)
+		}
+		if len(queue) > solQueueMax {
+			queue = queue[len(queue)-solQueueMax:]
+		}
+	}
+}
+
+// handleSOL takes a SOL packet from the console: an acknowledgement of
+// output, and keystrokes or a break to pass on.
+func (l *ipmiListener) handleSOL(s *ipmiSession, payload []byte) {
+	p := s.sol
+	if p == nil || len(payload) < solHeaderSize {
+		return
+	}
+	seq, ackSeq, accepted, op := payload[0], payload[1], payload[2], payload[3]
+	data := payload[solHeaderSize:]
+	if ackSeq != 0 {
+		select {
+		case p.acks <- solAck{seq: ackSeq, accepted: accepted, nack: op&0x40 != 0}:
+		default:
+		}
+	}
+	if seq == 0 {
+		return
+	}
+
+	// A repeated sequence number is a resend of a packet whose ACK was lost
+	if seq != p.inSeq {
+		p.inSeq = seq
+		if op&0x10 != 0 {
+			if err := l.g.sendBreak(l.name, s.who); err != nil {
+				l.note(p, err)
+			}
+		}
+		if len(data) > 0 {
+			if err := l.g.sendInput(l.name, s.who, data); err != nil {
+				l.note(p, err)
+			}
+		}
+	}
+	s.send(payloadSOL, []byte{0, seq, uint8(len(data)), 0})
+}
+
+// note reports rejected input to the client as console output.
+func (l *ipmiListener) note(p *solPayload, err error) {
+	select {
+	case p.notes <- []byte("\r\n[ipmiserial] input rejected: " + err.Error() + "\r\n"):
+	default:
+	}
+}
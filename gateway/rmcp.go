@@ -0,0 +1,242 @@
+package gateway
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// The managed-system side of RMCP+ (IPMI v2.0 section 13), just enough to
+// accept an `ipmitool -I lanplus` session and serve SOL over it.
+
+const (
+	rmcpVersion   = 0x06
+	rmcpClassIPMI = 0x07
+
+	authTypeNone  = 0x00
+	authTypeRMCPP = 0x06
+
+	payloadIPMI     = 0x00
+	payloadSOL      = 0x01
+	payloadOpenReq  = 0x10
+	payloadOpenResp = 0x11
+	payloadRAKP1    = 0x12
+	payloadRAKP2    = 0x13
+	payloadRAKP3    = 0x14
+	payloadRAKP4    = 0x15
+
+	payloadEncrypted     = 0x80
+	payloadAuthenticated = 0x40
+
+	authHMACSHA1   = 0x01
+	authHMACSHA256 = 0x03
+
+	integrityNone       = 0x00
+	integrityHMACSHA1   = 0x01
+	integrityHMACSHA256 = 0x04
+
+	cryptNone   = 0x00
+	cryptAESCBC = 0x01
+
+	privAdmin = 0x04
+)
+
+// RMCP+ status codes
+const (
+	rmcpStatusNoResources      = 0x01
+	rmcpStatusInvalidSession   = 0x02
+	rmcpStatusInvalidAuth      = 0x04
+	rmcpStatusInvalidIntegrity = 0x05
+	rmcpStatusInvalidRole      = 0x09
+	rmcpStatusUnauthorizedName = 0x0D
+	rmcpStatusInvalidICV       = 0x0F
+	rmcpStatusInvalidCrypt     = 0x10
+)
+
+var errBadPacket = errors.New("malformed RMCP packet")
+
+// rmcpPacket is a received IPMI packet: v1.5 (session-less, authType none)
+// or v2.0.
+type rmcpPacket struct {
+	v20         bool
+	payloadType uint8 // v2.0, with the encrypted/authenticated bits
+	sessionID   uint32
+	seq         uint32
+	payload     []byte
+	signed      []byte // v2.0 authenticated: AuthType through Next Header
+	authCode    []byte
+}
+
+// parseRMCP decodes a datagram. authLen is the integrity trailer length
+// expected for the packet's session; it is looked up once the session ID is
+// known.
+func parseRMCP(b []byte, authLen func(sessionID uint32) int) (*rmcpPacket, error) {
+	if len(b) < 5 || b[0] != rmcpVersion || b[3]&0x1f != rmcpClassIPMI {
+		return nil, errBadPacket
+	}
+	p := &rmcpPacket{}
+	switch b[4] {
+	case authTypeNone:
+		if len(b) < 14 {
+			return nil, errBadPacket
+		}
+		p.seq = binary.LittleEndian.Uint32(b[5:9])
+		p.sessionID = binary.LittleEndian.Uint32(b[9:13])
+		n := int(b[13])
+		if len(b) < 14+n {
+			return nil, errBadPacket
+		}
+		p.payload = b[14 : 14+n]
+	case authTypeRMCPP:
+		if len(b) < 16 {
+			return nil, errBadPacket
+		}
+		p.v20 = true
+		p.payloadType = b[5]
+		p.sessionID = binary.LittleEndian.Uint32(b[6:10])
+		p.seq = binary.LittleEndian.Uint32(b[10:14])
+		n := int(binary.LittleEndian.Uint16(b[14:16]))
+		if len(b) < 16+n {
+			return nil, errBadPacket
+		}
+		p.payload = b[16 : 16+n]
+		if p.payloadType&payloadAuthenticated != 0 {
+			size := authLen(p.sessionID)
+			if size == 0 || len(b) < 16+n+2+size {
+				return nil, errBadPacket
+			}
+			p.signed = b[4 : len(b)-size]
+			p.authCode = b[len(b)-size:]
+		}
+	default:
+		return nil, errBadPacket
+	}
+	return p, nil
+}
+
+// buildRMCP encodes a v2.0 packet, or v1.5 when v20 is false.
+func buildRMCP(v20 bool, payloadType uint8, sessionID, seq uint32, payload []byte) []byte {
+	pkt := []byte{rmcpVersion, 0, 0xff, rmcpClassIPMI}
+	if !v20 {
+		pkt = append(pkt, authTypeNone)
+		pkt = binary.LittleEndian.AppendUint32(pkt, seq)
+		pkt = binary.LittleEndian.AppendUint32(pkt, sessionID)
+		pkt = append(pkt, uint8(len(payload)))
+		return append(pkt, payload...)
+	}
+	pkt = append(pkt, authTypeRMCPP, payloadType)
+	pkt = binary.LittleEndian.AppendUint32(pkt, sessionID)
+	pkt = binary.LittleEndian.AppendUint32(pkt, seq)
+	pkt = binary.LittleEndian.AppendUint16(pkt, uint16(len(payload)))
+	return append(pkt, payload...)
+}
+
+// ipmiRequest is an IPMI message addressed to the BMC.
+type ipmiRequest struct {
+	netFn, cmd    uint8
+	rqAddr, rqSeq uint8 // rqSeq includes the requester's LUN
+	data          []byte
+}
+
+func parseIPMIRequest(b []byte) (*ipmiRequest, error) {
+	if len(b) < 7 || checksum(b[:2]) != b[2] || checksum(b[3:len(b)-1]) != b[len(b)-1] {
+		return nil, errBadPacket
+	}
+	return &ipmiRequest{
+		netFn:  b[1] >> 2,
+		cmd:    b[5],
+		rqAddr: b[3],
+		rqSeq:  b[4],
+		data:   b[6 : len(b)-1],
+	}, nil
+}
+
+// response encodes the reply to req.
+func (req *ipmiRequest) response(cc uint8, data []byte) []byte {
+	msg := []byte{req.rqAddr, (req.netFn+1)<<2 | req.rqSeq&3}
+	msg = append(msg, checksum(msg))
+	msg = append(msg, 0x20, req.rqSeq&^3, req.cmd, cc)
+	msg = append(msg, data...)
+	return append(msg, checksum(msg[3:]))
+}
+
+// checksum is the IPMI 2's complement checksum.
+func checksum(b []byte) uint8 {
+	var sum uint8
+	for _, c := range b {
+		sum -= c
+	}
+	return sum
+}
+
+// hmacFor returns the HMAC of data for an authentication (RAKP) or integrity
+// algorithm; both number SHA-1 as 1, and SHA-256 is RAKP 3, integrity 4.
+func hmacFor(alg uint8, key []byte, data ...[]byte) []byte {
+	var h func() hash.Hash = sha1.New
+	if alg == authHMACSHA256 || alg == integrityHMACSHA256 {
+		h = sha256.New
+	}
+	mac := hmac.New(h, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// integrityLen is the AuthCode length of an integrity algorithm:
+// HMAC-SHA1-96 or HMAC-SHA256-128.
+func integrityLen(alg uint8) int {
+	switch alg {
+	case integrityHMACSHA1:
+		return 12
+	case integrityHMACSHA256:
+		return 16
+	}
+	return 0
+}
+
+// rakpICVLen is the length of the RAKP 4 integrity check value for an
+// authentication algorithm.
+func rakpICVLen(alg uint8) int {
+	if alg == authHMACSHA256 {
+		return 16
+	}
+	return 12
+}
+
+// encryptAES encrypts a payload with AES-CBC-128: IV, then the data with its
+// confidentiality trailer.
+func encryptAES(key, payload []byte) []byte {
+	pad := (aes.BlockSize - (len(payload)+1)%aes.BlockSize) % aes.BlockSize
+	out := make([]byte, aes.BlockSize, aes.BlockSize+len(payload)+pad+1)
+	rand.Read(out)
+	out = append(out, payload...)
+	for i := 1; i <= pad; i++ {
+		out = append(out, byte(i))
+	}
+	out = append(out, byte(pad))
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], out[aes.BlockSize:])
+	return out
+}
+
+// decryptAES reverses encryptAES.
+func decryptAES(key, data []byte) ([]byte, error) {
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errBadPacket
+	}
+	block, _ := aes.NewCipher(key)
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+	pad := int(plain[len(plain)-1])
+	if pad+1 > len(plain) {
+		return nil, errBadPacket
+	}
+	return plain[:len(plain)-pad-1], nil
+}
@@ -0,0 +1,228 @@
+// Package gateway serves consoles outside the web UI: over SSH
+// (`ssh <server>@host -p 2222`), telnet, or an IPMI listener that answers
+// `ipmitool -I lanplus sol activate` like the server's own BMC. Each attaches
+// to the server's SOL stream with full keyboard input.
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/sol"
+)
+
+// SSH serves console sessions to clients holding an authorized key.
+type SSH struct {
+	consoles
+	port    int
+	hostKey ssh.Signer
+
+	mu   sync.RWMutex
+	keys map[string]string // marshaled public key -> identity (key comment or fingerprint)
+}
+
+// NewSSH loads or generates the host key and the authorized keys.
+func NewSSH(cfg config.SSHConfig, dataDir string, scanner *discovery.Scanner, solManager *sol.Manager) (*SSH, error) {
+	keyPath := cfg.HostKey
+	if keyPath == "" {
+		keyPath = filepath.Join(dataDir, "ssh_host_ed25519_key")
+	}
+	hostKey, err := loadHostKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("SSH host key: %w", err)
+	}
+	g := &SSH{
+		consoles: consoles{scanner: scanner, solManager: solManager},
+		port:     cfg.Port,
+		hostKey:  hostKey,
+	}
+	g.SetAuthorizedKeys(cfg)
+	return g, nil
+}
+
+// loadHostKey reads the host key, generating an ed25519 key on first start.
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, priv, genErr := ed25519.GenerateKey(rand.Reader)
+		if genErr != nil {
+			return nil, genErr
+		}
+		block, genErr := ssh.MarshalPrivateKey(priv, "ipmiserial")
+		if genErr != nil {
+			return nil, genErr
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+		log.Infof("Generated SSH host key %s", path)
+	} else if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("SSH host key fingerprint: %s", ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
+
+// SetAuthorizedKeys replaces the keys allowed to log in, from the inline
+// list and the authorized_keys file. Unparseable lines are logged and skipped.
+func (g *SSH) SetAuthorizedKeys(cfg config.SSHConfig) {
+	lines := append([]string(nil), cfg.AuthorizedKeys...)
+	if cfg.AuthorizedKeysFile != "" {
+		f, err := os.Open(cfg.AuthorizedKeysFile)
+		if err != nil {
+			log.Errorf("Failed to read SSH authorized keys: %v", err)
+		} else {
+			sc := bufio.NewScanner(f)
+			for sc.Scan() {
+				lines = append(lines, sc.Text())
+			}
+			f.Close()
+		}
+	}
+
+	keys := make(map[string]string)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			log.Errorf("Skipping SSH authorized key %.40q: %v", line, err)
+			continue
+		}
+		if comment == "" {
+			comment = ssh.FingerprintSHA256(pub)
+		}
+		keys[string(pub.Marshal())] = comment
+	}
+	if len(keys) == 0 {
+		log.Warn("SSH gateway has no authorized keys; all logins will be refused")
+	}
+
+	g.mu.Lock()
+	g.keys = keys
+	g.mu.Unlock()
+}
+
+func (g *SSH) checkKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	g.mu.RLock()
+	identity, ok := g.keys[string(key.Marshal())]
+	g.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown public key for %s", meta.User())
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"identity": identity}}, nil
+}
+
+// Run accepts SSH connections until ctx is done.
+func (g *SSH) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", g.port))
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	log.Infof("SSH console gateway on port %d", g.port)
+
+	cfg := &ssh.ServerConfig{PublicKeyCallback: g.checkKey}
+	cfg.AddHostKey(g.hostKey)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Warnf("SSH accept failed: %v", err)
+			continue
+		}
+		go g.handleConn(ctx, conn, cfg)
+	}
+}
+
+func (g *SSH) handleConn(ctx context.Context, conn net.Conn, cfg *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		log.Debugf("SSH handshake from %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	host := sconn.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	who := sconn.Permissions.Extensions["identity"] + "@" + host
+	log.Infof("SSH login by %s for console %s", who, sconn.User())
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go g.serveSession(ctx, ch, chReqs, sconn.User(), who)
+	}
+}
+
+// serveSession waits for a shell request, then bridges the channel to the
+// console until the client detaches or the stream ends.
+func (g *SSH) serveSession(ctx context.Context, ch ssh.Channel, reqs <-chan *ssh.Request, name, who string) {
+	defer ch.Close()
+
+	started := false
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req", "env", "window-change":
+			// The SOL console has a fixed size; nothing to apply
+			req.Reply(req.Type != "window-change", nil)
+		case "shell":
+			req.Reply(!started, nil)
+			if !started {
+				started = true
+				go func() {
+					status := g.attach(ctx, ch, name, who, "ssh")
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					ch.Close()
+				}()
+			}
+		default:
+			// exec and subsystems have no meaning on a console
+			req.Reply(false, nil)
+			if !started {
+				fmt.Fprintf(ch.Stderr(), "Only interactive sessions are supported: ssh -t %s@<host>\r\n", name)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/sol"
+)
+
+// Telnet commands and options (RFC 854, 857, 858)
+const (
+	telnetSE   = 240
+	telnetBRK  = 243
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptEcho = 1
+	telnetOptSGA  = 3
+)
+
+// Telnet serves each configured server's console on its own TCP port, as a
+// terminal server does. There is no login: bind the ports to a trusted
+// network.
+type Telnet struct {
+	consoles
+	listeners []config.ProxyListener
+}
+
+// NewTelnet returns a gateway for the console_proxy.telnet listeners.
+func NewTelnet(cfg config.ConsoleProxyConfig, scanner *discovery.Scanner, solManager *sol.Manager) *Telnet {
+	return &Telnet{
+		consoles:  consoles{scanner: scanner, solManager: solManager},
+		listeners: cfg.Telnet,
+	}
+}
+
+// Run accepts telnet connections on every listener until ctx is done.
+func (g *Telnet) Run(ctx context.Context) error {
+	var lns []net.Listener
+	for _, l := range g.listeners {
+		ln, err := net.Listen("tcp", l.Address)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
+		log.Infof("Telnet console for %s on %s", l.Server, ln.Addr())
+	}
+
+	var wg sync.WaitGroup
+	for i, ln := range lns {
+		wg.Add(1)
+		go func(ln net.Listener, name string) {
+			defer wg.Done()
+			g.serve(ctx, ln, name)
+		}(ln, g.listeners[i].Server)
+	}
+	<-ctx.Done()
+	for _, ln := range lns {
+		ln.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (g *Telnet) serve(ctx context.Context, ln net.Listener, name string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("Telnet accept for %s failed: %v", name, err)
+			continue
+		}
+		go g.handleConn(ctx, conn, name)
+	}
+}
+
+func (g *Telnet) handleConn(ctx context.Context, conn net.Conn, name string) {
+	defer conn.Close()
+	host := conn.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	who := "telnet@" + host
+	log.Infof("Telnet connection from %s for console %s", host, name)
+
+	// Character at a time, echoed by the console rather than the client
+	conn.Write([]byte{telnetIAC, telnetWILL, telnetOptEcho, telnetIAC, telnetWILL, telnetOptSGA})
+
+	tc := &telnetConn{Conn: conn, onBreak: func() {
+		if err := g.sendBreak(name, who); err != nil {
+			log.Debugf("Telnet break for %s: %v", name, err)
+		}
+	}}
+	g.attach(ctx, tc, name, who, "telnet")
+}
+
+// telnetConn strips telnet protocol from what the client sends and escapes
+// IAC bytes in console output. A BRK command sends a serial break.
+type telnetConn struct {
+	net.Conn
+	onBreak func()
+
+	cmd   byte // command after IAC being parsed, 0 if none
+	iac   bool // IAC seen
+	sub   bool // inside subnegotiation
+	cr    bool // last data byte was CR
+	reply []byte
+}
+
+// Read returns the next data the client typed, answering option requests
+// along the way. CR LF and CR NUL become a single CR, as a serial console
+// expects for Enter.
+func (t *telnetConn) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for {
+		n, err := t.Conn.Read(buf)
+		out := p[:0]
+		for _, c := range buf[:n] {
+			if b, ok := t.filter(c); ok {
+				out = append(out, b)
+			}
+		}
+		if len(t.reply) > 0 {
+			t.Conn.Write(t.reply)
+			t.reply = t.reply[:0]
+		}
+		if len(out) > 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// filter advances the protocol parser by one byte, returning it if it is
+// data.
+func (t *telnetConn) filter(c byte) (byte, bool) {
+	switch {
+	case t.cmd != 0:
+		// Option negotiation: refuse anything but the options we offered
+		switch t.cmd {
+		case telnetDO:
+			if c != telnetOptEcho && c != telnetOptSGA {
+				t.reply = append(t.reply, telnetIAC, telnetWONT, c)
+			}
+		case telnetWILL:
+			if c != telnetOptSGA {
+				t.reply = append(t.reply, telnetIAC, telnetDONT, c)
+			}
+		}
+		t.cmd = 0
+		return 0, false
+	case t.iac:
+		t.iac = false
+		switch c {
+		case telnetIAC:
+			if !t.sub {
+				return t.data(c)
+			}
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			t.cmd = c
+		case telnetSB:
+			t.sub = true
+		case telnetSE:
+			t.sub = false
+		case telnetBRK:
+			if t.onBreak != nil {
+				t.onBreak()
+			}
+		}
+		return 0, false
+	case c == telnetIAC:
+		t.iac = true
+		return 0, false
+	case t.sub:
+		return 0, false
+	}
+	return t.data(c)
+}
+
+func (t *telnetConn) data(c byte) (byte, bool) {
+	cr := t.cr
+	t.cr = c == '\r'
+	if cr && (c == '\n' || c == 0) {
+		return 0, false
+	}
+	return c, true
+}
+
+// Write sends console output, doubling IAC bytes.
+func (t *telnetConn) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, telnetIAC) < 0 {
+		return t.Conn.Write(p)
+	}
+	escaped := bytes.ReplaceAll(p, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})
+	if _, err := t.Conn.Write(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
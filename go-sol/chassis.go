@@ -0,0 +1,80 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+)
+
+// Chassis commands (netFn Chassis)
+const (
+	cmdGetChassisStatus  = 0x01
+	cmdChassisControl    = 0x02
+	cmdSetSystemBootOpts = 0x08
+)
+
+// ChassisAction is a Chassis Control request value.
+type ChassisAction uint8
+
+const (
+	ChassisPowerOff   ChassisAction = 0x00
+	ChassisPowerOn    ChassisAction = 0x01
+	ChassisPowerCycle ChassisAction = 0x02
+	ChassisHardReset  ChassisAction = 0x03
+	ChassisSoftOff    ChassisAction = 0x05 // ACPI soft shutdown
+)
+
+// BootDevice is a boot device selector for the boot flags parameter.
+type BootDevice uint8
+
+const (
+	BootNone  BootDevice = 0x00
+	BootPXE   BootDevice = 0x04
+	BootDisk  BootDevice = 0x08
+	BootCDROM BootDevice = 0x14
+	BootBIOS  BootDevice = 0x18
+)
+
+// ChassisStatus is the decoded Get Chassis Status response.
+type ChassisStatus struct {
+	PowerOn         bool
+	PowerOverload   bool
+	PowerFault      bool
+	LastPowerEvent  uint8
+	IntrusionActive bool
+}
+
+// GetChassisStatus reads the current chassis power state.
+func (s *Session) GetChassisStatus(ctx context.Context) (*ChassisStatus, error) {
+	data, err := s.Command(ctx, netFnChassis, cmdGetChassisStatus, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 3 {
+		return nil, fmt.Errorf("chassis status response too short: %d", len(data))
+	}
+	return &ChassisStatus{
+		PowerOn:         data[0]&0x01 != 0,
+		PowerOverload:   data[0]&0x02 != 0,
+		PowerFault:      data[0]&0x08 != 0,
+		LastPowerEvent:  data[1],
+		IntrusionActive: data[2]&0x01 != 0,
+	}, nil
+}
+
+// ChassisControl sends a power on/off/cycle/reset request.
+func (s *Session) ChassisControl(ctx context.Context, action ChassisAction) error {
+	_, err := s.Command(ctx, netFnChassis, cmdChassisControl, []byte{uint8(action)})
+	return err
+}
+
+// SetBootDevice sets the boot device override (boot flags parameter 5).
+// When persistent is false the override applies to the next boot only.
+func (s *Session) SetBootDevice(ctx context.Context, dev BootDevice, persistent bool) error {
+	flags := uint8(0x80) // boot flags valid
+	if persistent {
+		flags |= 0x40
+	}
+	data := []byte{0x05, flags, uint8(dev), 0x00, 0x00, 0x00}
+	_, err := s.Command(ctx, netFnChassis, cmdSetSystemBootOpts, data)
+	return err
+}
@@ -0,0 +1,123 @@
+package sol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Additional network functions used by Command helpers
+const (
+	netFnSensor  = 0x04
+	netFnStorage = 0x0A
+)
+
+// commandTimeout bounds how long Command waits for a BMC response.
+const commandTimeout = 5 * time.Second
+
+// CompletionError is returned by Command when the BMC answers with a
+// non-zero completion code.
+type CompletionError struct {
+	NetFn uint8
+	Cmd   uint8
+	Code  uint8
+}
+
+func (e *CompletionError) Error() string {
+	return fmt.Sprintf("netFn 0x%02X cmd 0x%02X: completion code 0x%02X", e.NetFn, e.Cmd, e.Code)
+}
+
+// Command sends an IPMI request over the authenticated session and returns
+// the response data (without completion code). It is safe to call while SOL
+// is active: the read loop routes IPMI responses back to the caller.
+// Requests are serialized; only one command is outstanding at a time.
+func (s *Session) Command(ctx context.Context, netFn, cmd uint8, data []byte) ([]byte, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, errors.New("session closed")
+	}
+	if s.conn == nil {
+		return nil, errors.New("session not connected")
+	}
+
+	s.cmdMu.Lock()
+	defer s.cmdMu.Unlock()
+
+	// Sequence 0 is used by keepalives; commands use 1..63
+	s.cmdSeq = (s.cmdSeq + 1) & 0x3F
+	if s.cmdSeq == 0 {
+		s.cmdSeq = 1
+	}
+	seq := s.cmdSeq
+
+	msg := buildIPMIMessage(0x20, netFn, 0, 0x81, seq, 0, cmd, data)
+	s.mu.Lock()
+	packet := s.buildAuthenticatedPacket(payloadIPMI, msg)
+	s.mu.Unlock()
+
+	var resp []byte
+	if !s.running.Load() {
+		// Before the read loop starts (during Connect) talk to the socket directly
+		r, err := s.sendRecv(ctx, packet, commandTimeout)
+		if err != nil {
+			return nil, err
+		}
+		resp = r
+	} else {
+		// Drop any stale responses (e.g. keepalive replies)
+		for len(s.cmdResp) > 0 {
+			<-s.cmdResp
+		}
+		s.conn.SetWriteDeadline(s.clock.Now().Add(2 * time.Second))
+		if _, err := s.conn.Write(packet); err != nil {
+			return nil, fmt.Errorf("write failed: %w", err)
+		}
+
+		timeout := s.clock.After(commandTimeout)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-s.done:
+				return nil, errors.New("session closed")
+			case <-timeout:
+				return nil, fmt.Errorf("netFn 0x%02X cmd 0x%02X: timeout", netFn, cmd)
+			case r := <-s.cmdResp:
+				// Match on rqSeq and command so keepalive replies are skipped
+				if len(r) >= 23 && r[20]>>2 == seq && r[21] == cmd {
+					resp = r
+					break wait
+				}
+			}
+		}
+	}
+
+	return parseCommandResponse(resp, netFn, cmd)
+}
+
+// parseCommandResponse extracts the response data from an RMCP+ IPMI
+// response: RMCP(4) + Session(12) + rqAddr, netFn, chk, rsAddr, rqSeq, cmd, CC, data..., chk2
+func parseCommandResponse(resp []byte, netFn, cmd uint8) ([]byte, error) {
+	if len(resp) < 23 {
+		return nil, fmt.Errorf("netFn 0x%02X cmd 0x%02X: response too short: %d", netFn, cmd, len(resp))
+	}
+	if cc := resp[22]; cc != 0x00 {
+		return nil, &CompletionError{NetFn: netFn, Cmd: cmd, Code: cc}
+	}
+	payloadLen := int(binary.LittleEndian.Uint16(resp[14:16]))
+	end := 16 + payloadLen - 1 // strip trailing checksum
+	if end > len(resp) {
+		end = len(resp)
+	}
+	if end < 23 {
+		return []byte{}, nil
+	}
+	out := make([]byte, end-23)
+	copy(out, resp[23:end])
+	return out, nil
+}
@@ -0,0 +1,57 @@
+package sol
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// DCMI commands (netFn Group Extension, group DCMI)
+const (
+	netFnGroupExt      = 0x2C
+	dcmiGroupID        = 0xDC
+	cmdGetPowerReading = 0x02
+
+	// dcmiSystemPower selects system power statistics
+	dcmiSystemPower = 0x01
+)
+
+// PowerReading is the DCMI Get Power Reading response. Min, Max and Average
+// cover the BMC's statistics period, which ends at Timestamp.
+type PowerReading struct {
+	Current   int // watts
+	Min       int
+	Max       int
+	Average   int
+	Timestamp time.Time     // BMC clock
+	Period    time.Duration // statistics reporting period
+	Active    bool          // power measurement is active; readings are meaningless otherwise
+}
+
+// GetPowerReading reads the system power statistics with DCMI Get Power
+// Reading. BMCs without DCMI power management fail with a CompletionError
+// (typically 0xC1, invalid command).
+func (s *Session) GetPowerReading(ctx context.Context) (*PowerReading, error) {
+	data, err := s.Command(ctx, netFnGroupExt, cmdGetPowerReading, []byte{dcmiGroupID, dcmiSystemPower, 0x00, 0x00})
+	if err != nil {
+		return nil, err
+	}
+	// Group ID, current, min, max, average (16-bit each), timestamp,
+	// period (32-bit each), reading state
+	if len(data) < 18 {
+		return nil, fmt.Errorf("power reading response too short: %d", len(data))
+	}
+	if data[0] != dcmiGroupID {
+		return nil, fmt.Errorf("power reading: group 0x%02X is not DCMI", data[0])
+	}
+	return &PowerReading{
+		Current:   int(binary.LittleEndian.Uint16(data[1:3])),
+		Min:       int(binary.LittleEndian.Uint16(data[3:5])),
+		Max:       int(binary.LittleEndian.Uint16(data[5:7])),
+		Average:   int(binary.LittleEndian.Uint16(data[7:9])),
+		Timestamp: time.Unix(int64(binary.LittleEndian.Uint32(data[9:13])), 0),
+		Period:    time.Duration(binary.LittleEndian.Uint32(data[13:17])) * time.Millisecond,
+		Active:    data[17]&0x40 != 0,
+	}, nil
+}
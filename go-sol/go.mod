@@ -0,0 +1,3 @@
+module github.com/gwest/go-sol
+
+go 1.24.2
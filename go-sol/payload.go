@@ -0,0 +1,490 @@
+package sol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SOL payload constants
+const (
+	solPayloadType = 0x01
+
+	// SOL operation/status bits (outbound)
+	solOpNack          = 0x40 // Request packet retransmission
+	solOpRingWor       = 0x20 // Ring indicator / Wake on Ring
+	solOpBreak         = 0x10 // Generate serial break
+	solOpCtsDeassert   = 0x08 // Deassert CTS
+	solOpDropDcdDsr    = 0x04 // Drop DCD/DSR
+	solOpFlushInbound  = 0x02 // Flush inbound data
+	solOpFlushOutbound = 0x01 // Flush outbound data
+
+	// SOL status bits (inbound from BMC)
+	solStatusNack       = 0x40
+	solStatusTransfer   = 0x20 // Character transfer unavailable
+	solStatusBreak      = 0x10 // Break detected
+	solStatusRxOverrun  = 0x08 // Receive overrun
+	solStatusDeassert   = 0x04 // CTS/DCD/DSR deasserted
+	solStatusFlushOut   = 0x02
+	solStatusFlushIn    = 0x01
+)
+
+// solPacketHeader is the 4-byte SOL packet header
+type solPacketHeader struct {
+	PacketSeq    uint8 // Packet sequence number
+	AckSeq       uint8 // Acknowledged packet number
+	AcceptedChar uint8 // Number of accepted characters
+	OpStatus     uint8 // Operation/status byte
+}
+
+// solWrite is one queued outbound SOL packet: character data, operation
+// bits (e.g. solOpBreak), or both.
+type solWrite struct {
+	data []byte
+	op   uint8
+}
+
+func (h solPacketHeader) pack() []byte {
+	return []byte{h.PacketSeq, h.AckSeq, h.AcceptedChar, h.OpStatus}
+}
+
+func parseSolHeader(data []byte) solPacketHeader {
+	if len(data) < 4 {
+		return solPacketHeader{}
+	}
+	return solPacketHeader{
+		PacketSeq:    data[0],
+		AckSeq:       data[1],
+		AcceptedChar: data[2],
+		OpStatus:     data[3],
+	}
+}
+
+// ErrSOLActive is wrapped by the Connect error when Activate Payload fails
+// with completion code 0x80: the SOL payload is active, e.g. on a session
+// the BMC has not yet noticed is gone.
+var ErrSOLActive = errors.New("payload already active")
+
+// activateSOL activates the SOL payload
+func (s *Session) activateSOL(ctx context.Context) error {
+	instance := s.quirks.instance()
+
+	// Activate Payload request
+	// Payload type (1) + Payload instance (1) + Aux data (4)
+	data := []byte{
+		solPayloadType, // Payload type = SOL
+		instance,       // Payload instance (Quirks.SOLInstance)
+		0x00,           // Aux data byte 1: no special options
+		0x00,           // Aux data byte 2
+		0x00,           // Aux data byte 3
+		0x00,           // Aux data byte 4
+	}
+
+	if s.integrityAlg != integrityNone {
+		data[2] |= 0x40 // SOL packets carry the session's auth code
+	}
+
+	msg := buildIPMIMessage(0x20, netFnApp, 0, 0x81, 0, 0, cmdActivatePayload, data)
+	packet := s.buildAuthenticatedPacket(payloadIPMI, msg)
+
+	resp, err := s.sendRecv(ctx, packet, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	// Parse Activate Payload response
+	// Minimum: RMCP(4) + Session(12) + IPMI msg header(6) + CC(1) = 23 bytes
+	if len(resp) < 23 {
+		return fmt.Errorf("activate payload response too short: %d", len(resp))
+	}
+
+	// Completion code at offset 22: RMCP(4) + Session(12) + rsAddr(1) + netFn(1) + chk(1) + rqAddr(1) + rqSeq(1) + cmd(1)
+	cc := resp[22]
+	if cc != 0x00 {
+		extra := ""
+		switch cc {
+		case 0x80:
+			return fmt.Errorf("activate payload failed: completion code 0x80 (%w) (crypto=%d integrity=%d)", ErrSOLActive, s.cryptoAlg, s.integrityAlg)
+		case 0x81:
+			return fmt.Errorf("activate payload failed: completion code 0x81 (%w) (crypto=%d integrity=%d)", ErrSOLDisabled, s.cryptoAlg, s.integrityAlg)
+		case 0x82:
+			extra = " (payload activation limit reached)"
+		case 0x83:
+			extra = " (cannot activate payload with encryption)"
+		case 0x84:
+			extra = " (cannot activate payload without encryption)"
+		}
+		return fmt.Errorf("activate payload failed: completion code 0x%02X%s (crypto=%d integrity=%d)", cc, extra, s.cryptoAlg, s.integrityAlg)
+	}
+
+	// Use PayloadLen from session header to find response data length.
+	// PayloadLen covers the full IPMI message: header(6) + CC(1) + data(N) + chk2(1) = N+8
+	// Some BMCs (Dell iDRAC) return no response data at all (PayloadLen=8, just CC + checksum).
+	payloadLen := int(binary.LittleEndian.Uint16(resp[14:16]))
+	dataLen := payloadLen - 8 // Subtract IPMI overhead (6 header + 1 CC + 1 chk2)
+	if dataLen >= 8 && len(resp) >= 23+dataLen {
+		respData := resp[23 : 23+dataLen]
+		s.maxOutbound = binary.LittleEndian.Uint16(respData[6:8])
+	}
+	if s.maxOutbound == 0 || s.maxOutbound > 255 {
+		s.maxOutbound = 200 // Default safe value
+	}
+
+	s.solPayloadInstance = instance
+	s.solSeqNum = 1 // Start sequence at 1
+
+	return nil
+}
+
+// deactivateSOL deactivates the SOL payload
+func (s *Session) deactivateSOL(ctx context.Context) error {
+	instance := s.solPayloadInstance
+	if instance == 0 {
+		instance = s.quirks.instance() // Default instance for pre-activation cleanup
+	}
+	return s.deactivateInstance(ctx, instance)
+}
+
+// deactivateInstance deactivates one SOL payload instance
+func (s *Session) deactivateInstance(ctx context.Context, instance uint8) error {
+	data := []byte{
+		solPayloadType, // Payload type = SOL
+		instance,       // Payload instance
+		0x00, 0x00, 0x00, 0x00, // Aux data
+	}
+
+	msg := buildIPMIMessage(0x20, netFnApp, 0, 0x81, 0, 0, cmdDeactivatePayload, data)
+	packet := s.buildAuthenticatedPacket(payloadIPMI, msg)
+
+	_, err := s.sendRecv(ctx, packet, 2*time.Second)
+	return err
+}
+
+// readLoop reads SOL data from BMC as fast as possible
+func (s *Session) readLoop() {
+	defer close(s.readCh)
+	defer s.running.Store(false)
+
+	// Internal queue for bursty traffic - read fast, drain separately
+	queue := make(chan []byte, 10000)
+	done := make(chan struct{})
+
+	// Goroutine to drain queue to readCh
+	go func() {
+		defer close(done)
+		for data := range queue {
+			select {
+			case s.readCh <- data:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1024)
+	logInterval := s.clock.NewTicker(60 * time.Second)
+	defer logInterval.Stop()
+	var totalReads, totalTimeouts, totalPackets, totalSOL, totalData, totalDuplicates int64
+
+	s.logf("readLoop started for %s:%d", s.host, s.port)
+
+	for {
+		select {
+		case <-s.done:
+			close(queue)
+			<-done
+			return
+		case <-logInterval.C():
+			stats := s.Stats()
+			s.logf("readLoop stats for %s: reads=%d timeouts=%d packets=%d sol=%d data=%d duplicates=%d sent=%d retransmits=%d nacks=%d dropped=%d in=%dB out=%dB ack=%.1fms",
+				s.host, totalReads, totalTimeouts, totalPackets, totalSOL, totalData, totalDuplicates,
+				stats.Sent, stats.Retransmits, stats.Nacks, stats.Dropped, stats.BytesIn, stats.BytesOut, stats.AckLatency)
+		default:
+		}
+
+		s.conn.SetReadDeadline(s.clock.Now().Add(100 * time.Millisecond))
+		n, err := s.conn.Read(buf)
+		totalReads++
+		if err != nil {
+			// Timeout is normal - check inactivity
+			if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+				totalTimeouts++
+				if s.inactivityTimeout > 0 {
+					last := time.Unix(0, s.lastRecvTime.Load())
+					if idle := s.clock.Now().Sub(last); idle > s.inactivityTimeout {
+						s.logf("readLoop inactivity timeout for %s (last recv %v ago)", s.host, idle)
+						select {
+						case s.errCh <- errors.New("SOL inactivity timeout"):
+						default:
+						}
+						close(queue)
+						<-done
+						return
+					}
+				}
+				continue
+			}
+			s.logf("readLoop error for %s: %v", s.host, err)
+			select {
+			case s.errCh <- err:
+			default:
+			}
+			close(queue)
+			<-done
+			return
+		}
+
+		totalPackets++
+
+		if s.v15 {
+			// Parse v1.5 packets in the RMCP+ layout
+			v20 := v15ToV20(buf[:n])
+			if v20 == nil {
+				continue
+			}
+			n = copy(buf, v20)
+		}
+
+		// Any packet from the BMC means the session is alive
+		s.lastRecvTime.Store(s.clock.Now().UnixNano())
+
+		if n < 20 {
+			continue // Too short for SOL, but BMC responded
+		}
+
+		// Check if this is a SOL packet
+		// RMCP header (4) + Session header (12) + SOL header (4) + data
+		payloadType := buf[5] & 0x3F // Mask out encrypted/authenticated bits
+		if payloadType == payloadIPMI {
+			// IPMI response (keepalive or Command) - hand to a waiting caller
+			resp := make([]byte, n)
+			copy(resp, buf[:n])
+			select {
+			case s.cmdResp <- resp:
+			default:
+			}
+			continue
+		}
+		if payloadType != solPayloadType {
+			continue // Not SOL data
+		}
+		totalSOL++
+		s.statPacketsIn.Add(1)
+
+		// Get payload length from session header (offset 14-15, little endian)
+		payloadLen := int(binary.LittleEndian.Uint16(buf[14:16]))
+		if payloadLen < 4 || 16+payloadLen > n {
+			continue // Invalid payload length
+		}
+
+		header := parseSolHeader(buf[16:20])
+		header.PacketSeq &= 0x0F
+		header.AckSeq &= 0x0F
+
+		// ACK or NACK of one of our packets - hand to the write loop
+		if header.AckSeq != 0 {
+			select {
+			case s.ackCh <- solAck{seq: header.AckSeq, accepted: header.AcceptedChar, nack: header.OpStatus&solStatusNack != 0}:
+			default:
+			}
+		}
+
+		// Update our ACK sequence. A repeated sequence number is a
+		// retransmission because our ACK was lost: ACK it again, but
+		// don't deliver the data twice.
+		dataLen := payloadLen - 4
+		duplicate := false
+		if header.PacketSeq != 0 {
+			s.mu.Lock()
+			duplicate = header.PacketSeq == s.ackSeqNum
+			s.ackSeqNum = header.PacketSeq
+			s.mu.Unlock()
+		}
+		if duplicate && dataLen > 0 {
+			totalDuplicates++
+			s.statDuplicates.Add(1)
+			s.sendSolAck(dataLen)
+			continue
+		}
+
+		// Extract character data (payload minus 4-byte SOL header)
+		if dataLen > 0 {
+			totalData++
+			s.statBytesIn.Add(uint64(dataLen))
+			data := make([]byte, dataLen)
+			copy(data, buf[20:20+dataLen])
+
+			// Send ACK immediately
+			s.sendSolAck(dataLen)
+
+			// Queue data - never block the read loop
+			select {
+			case queue <- data:
+			default:
+			}
+		} else if header.PacketSeq != 0 {
+			// ACK-only packet from BMC, send our ACK
+			s.sendSolAck(0)
+		}
+	}
+}
+
+// writeLoop sends SOL data to BMC
+func (s *Session) writeLoop() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case w := <-s.writeCh:
+			s.sendSolData(w.data, w.op)
+		}
+	}
+}
+
+// sendSolData sends character data to BMC, one acknowledged packet at a
+// time. Operation bits go out with the first packet, which may carry no
+// data (e.g. a bare break). If a packet is dropped after all retries the
+// rest of the data is dropped with it.
+func (s *Session) sendSolData(data []byte, op uint8) error {
+	// Chunk data if too large
+	maxData := int(s.maxOutbound) - 4 // Subtract header size
+	if s.v15 {
+		maxData -= v15SolReserved
+	}
+	if maxData < 1 {
+		maxData = 200
+	}
+
+	for len(data) > 0 || op != 0 {
+		chunk := data
+		if len(chunk) > maxData {
+			chunk = data[:maxData]
+		}
+		if err := s.sendReliable(chunk, op); err != nil {
+			if rest := len(data) - len(chunk); rest > 0 {
+				s.statDroppedBytes.Add(uint64(rest))
+			}
+			s.logf("SOL write to %s failed: %v", s.host, err)
+			return err
+		}
+		data = data[len(chunk):]
+		op = 0
+	}
+
+	return nil
+}
+
+// sendSolAck sends an ACK-only packet for the last BMC packet, reporting
+// how many of its characters were accepted
+func (s *Session) sendSolAck(accepted int) error {
+	s.mu.Lock()
+	ackSeq := s.ackSeqNum
+	s.mu.Unlock()
+
+	header := solPacketHeader{
+		PacketSeq:    0, // 0 = ACK only, no data
+		AckSeq:       ackSeq,
+		AcceptedChar: uint8(accepted),
+		OpStatus:     0,
+	}
+
+	payload := header.pack()
+	packet := s.buildSolPacket(payload)
+
+	s.conn.SetWriteDeadline(s.clock.Now().Add(1 * time.Second))
+	_, err := s.conn.Write(packet)
+	if err == nil {
+		s.statPacketsOut.Add(1)
+	}
+	return err
+}
+
+// keepaliveLoop periodically sends authenticated IPMI commands to detect dead sessions.
+// Unlike ASF Presence Pings which bypass the RMCP+ session, this uses Get Device ID
+// over the authenticated session. If the session is dead (e.g., BMC reset after power
+// cycle), the BMC silently drops the packet and the session-level inactivity timer fires.
+func (s *Session) keepaliveLoop() {
+	interval := s.keepaliveInterval
+	if interval == 0 {
+		interval = s.inactivityTimeout / 3
+		if interval < 10*time.Second {
+			interval = 10 * time.Second
+		}
+	}
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C():
+			s.sendSessionKeepalive()
+		}
+	}
+}
+
+// sendSessionKeepalive sends a Get Device ID command over the authenticated RMCP+ session.
+// If the session is still valid, the BMC responds and readLoop updates lastRecvTime.
+// If the BMC has reset (power cycle), the session ID is invalid and the packet is dropped,
+// causing the inactivity timeout to fire and trigger reconnection.
+func (s *Session) sendSessionKeepalive() {
+	// Get Device ID: netFn=App(0x06), cmd=0x01, no data
+	msg := buildIPMIMessage(0x20, netFnApp, 0, 0x81, 0, 0, 0x01, nil)
+	packet := s.buildAuthenticatedPacket(payloadIPMI, msg)
+	s.conn.SetWriteDeadline(s.clock.Now().Add(2 * time.Second))
+	s.conn.Write(packet)
+}
+
+// buildSolPacket builds a complete SOL packet
+func (s *Session) buildSolPacket(payload []byte) []byte {
+	if s.v15 {
+		return s.buildV15SolPacket(payload)
+	}
+
+	// SOL uses payload type 1
+	payloadType := uint8(solPayloadType)
+
+	// Add authenticated bit if we have integrity
+	if s.integrityAlg != integrityNone {
+		payloadType |= 0x40
+	}
+
+	// Build RMCP + session header
+	rmcp := rmcpHeader{
+		Version:  rmcpVersion,
+		Reserved: 0,
+		Sequence: rmcpSequence,
+		Class:    rmcpClassIPMI,
+	}
+
+	session := ipmi20SessionHeader{
+		AuthType:    ipmiAuthRMCPP,
+		PayloadType: payloadType,
+		SessionID:   s.remoteSessionID,
+		Sequence:    0, // SOL doesn't use session sequence
+		PayloadLen:  uint16(len(payload)),
+	}
+
+	packet := make([]byte, 0, 4+12+len(payload)+16)
+	packet = append(packet, rmcp.pack()...)
+	packet = append(packet, session.pack()...)
+	packet = append(packet, payload...)
+
+	// Add integrity if needed
+	if s.integrityAlg != integrityNone {
+		padLen := (4 - (len(payload) % 4)) % 4
+		for i := 0; i < padLen; i++ {
+			packet = append(packet, 0xFF)
+		}
+		packet = append(packet, uint8(padLen))
+		packet = append(packet, 0x07)
+
+		authCode := hmacHash(s.integrityAlg, s.k1, packet[4:])
+		packet = append(packet, authCode[:12]...)
+	}
+
+	return packet
+}
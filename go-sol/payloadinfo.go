@@ -0,0 +1,87 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+)
+
+// Payload types, for GetPayloadActivationStatus and ChannelPayloads
+const (
+	PayloadIPMI = payloadIPMI
+	PayloadSOL  = solPayloadType
+)
+
+// PayloadActivation is the Get Payload Activation Status response.
+type PayloadActivation struct {
+	Capacity int   // instances of the payload the BMC allows at once
+	Active   []int // instances (1-16) active, on any session
+}
+
+// GetPayloadActivationStatus reports how many instances of a payload type
+// the BMC allows and which are active. An active SOL instance belonging to
+// another session makes Activate Payload fail with completion code 0x80.
+func (s *Session) GetPayloadActivationStatus(ctx context.Context, payloadType uint8) (*PayloadActivation, error) {
+	data, err := s.Command(ctx, netFnApp, cmdGetPayloadStatus, []byte{payloadType})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 3 {
+		return nil, fmt.Errorf("payload activation status response too short: %d", len(data))
+	}
+	p := &PayloadActivation{Capacity: int(data[0] & 0x0F), Active: []int{}}
+	for i := 0; i < 16; i++ {
+		if data[1+i/8]&(1<<(i%8)) != 0 {
+			p.Active = append(p.Active, i+1)
+		}
+	}
+	return p, nil
+}
+
+// ChannelPayloads is the Get Channel Payload Support response: the payload
+// types the session's channel carries.
+type ChannelPayloads struct {
+	Standard     []uint8 // 0x00-0x0F: PayloadIPMI, PayloadSOL, 0x02 OEM explicit
+	SessionSetup []uint8 // 0x10-0x1F: RMCP+ open session and RAKP messages
+	OEM          []uint8 // 0x20-0x2F
+}
+
+// Supports reports whether the channel carries a payload type.
+func (p *ChannelPayloads) Supports(payloadType uint8) bool {
+	for _, list := range [][]uint8{p.Standard, p.SessionSetup, p.OEM} {
+		for _, t := range list {
+			if t == payloadType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetChannelPayloadSupport reads which payload types the channel the
+// session arrived on supports.
+func (s *Session) GetChannelPayloadSupport(ctx context.Context) (*ChannelPayloads, error) {
+	data, err := s.Command(ctx, netFnApp, cmdGetChannelPayloads, []byte{currentChannel})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 {
+		return nil, fmt.Errorf("channel payload support response too short: %d", len(data))
+	}
+	return &ChannelPayloads{
+		Standard:     payloadBits(data[0:2], 0x00),
+		SessionSetup: payloadBits(data[2:4], 0x10),
+		OEM:          payloadBits(data[4:6], 0x20),
+	}, nil
+}
+
+// payloadBits lists the payload types set in a 16-bit little-endian mask,
+// numbered from base.
+func payloadBits(mask []byte, base uint8) []uint8 {
+	types := []uint8{}
+	for i := 0; i < 16; i++ {
+		if mask[i/8]&(1<<(i%8)) != 0 {
+			types = append(types, base+uint8(i))
+		}
+	}
+	return types
+}
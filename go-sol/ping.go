@@ -0,0 +1,86 @@
+package sol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ASF presence ping (DMTF DSP0136)
+const (
+	asfIANA         = 4542
+	asfPresencePing = 0x80
+	asfPresencePong = 0x40
+)
+
+// Ping checks that a BMC answers on its RMCP port without opening a
+// session. It sends an ASF presence ping and an unauthenticated Get Channel
+// Authentication Capabilities request, since some BMCs only answer one of
+// them, and returns nil on the first reply. Port 0 means 623.
+func Ping(ctx context.Context, host string, port int, timeout time.Duration) error {
+	return PingDial(ctx, nil, host, port, timeout)
+}
+
+// PingDial is Ping over a socket from dial, e.g. SocketOptions.Dial or
+// the dialer given as Config.Dial; nil means any source port.
+func PingDial(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), host string, port int, timeout time.Duration) error {
+	if port == 0 {
+		port = 623
+	}
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > timeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	ping := []byte{
+		rmcpVersion, 0, rmcpSequence, rmcpClassASF,
+		0, 0, asfIANA >> 8, asfIANA & 0xFF, // IANA enterprise number, big endian
+		asfPresencePing, 0x01, 0x00, 0x00, // type, tag, reserved, data length
+	}
+	authCaps := buildIPMI15Packet(0, 0,
+		buildIPMIMessage(0x20, netFnApp, 0, 0x81, 0, 0, cmdGetChannelAuthCaps, []byte{0x8E, privAdmin}))
+	for _, packet := range [][]byte{ping, authCaps} {
+		if _, err := conn.Write(packet); err != nil {
+			return fmt.Errorf("write failed: %w", err)
+		}
+	}
+
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return errors.New("no response")
+			}
+			return err
+		}
+		if n < 4 || buf[0] != rmcpVersion {
+			continue
+		}
+		switch buf[3] {
+		case rmcpClassASF:
+			if n >= 9 && buf[8] == asfPresencePong {
+				return nil
+			}
+		case rmcpClassIPMI:
+			return nil
+		}
+	}
+}
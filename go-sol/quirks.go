@@ -0,0 +1,203 @@
+package sol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// cmdGetDeviceID is Get Device ID (netFn App).
+const cmdGetDeviceID = 0x01
+
+// Manufacturer IDs (IANA enterprise numbers) reported by Get Device ID
+const (
+	ManufacturerHP         = 11
+	ManufacturerDell       = 674
+	ManufacturerSupermicro = 10876
+	ManufacturerHPE        = 47196
+)
+
+// DeviceID is the Get Device ID response.
+type DeviceID struct {
+	DeviceID       uint8
+	DeviceRevision uint8
+	FirmwareMajor  uint8
+	FirmwareMinor  uint8  // BCD, e.g. 0x45 for x.45
+	IPMIVersion    uint8  // BCD, e.g. 0x20 for 2.0
+	ManufacturerID uint32 // IANA enterprise number
+	ProductID      uint16
+}
+
+// Firmware returns the firmware revision, e.g. "3.45".
+func (d *DeviceID) Firmware() string {
+	return fmt.Sprintf("%d.%02x", d.FirmwareMajor, d.FirmwareMinor)
+}
+
+// GetDeviceID reads the BMC's identity: manufacturer, product and
+// firmware revision.
+func (s *Session) GetDeviceID(ctx context.Context) (*DeviceID, error) {
+	data, err := s.Command(ctx, netFnApp, cmdGetDeviceID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 11 {
+		return nil, fmt.Errorf("device ID response too short: %d", len(data))
+	}
+	return &DeviceID{
+		DeviceID:       data[0],
+		DeviceRevision: data[1] & 0x0F,
+		FirmwareMajor:  data[2] & 0x7F,
+		FirmwareMinor:  data[3],
+		IPMIVersion:    data[4],
+		ManufacturerID: uint32(data[6]) | uint32(data[7])<<8 | uint32(data[8]&0x0F)<<16,
+		ProductID:      binary.LittleEndian.Uint16(data[9:11]),
+	}, nil
+}
+
+// PrivilegeOrder is when Connect sends Set Session Privilege Level.
+type PrivilegeOrder uint8
+
+const (
+	PrivilegeFirst          PrivilegeOrder = iota // right after authentication, before anything else (default)
+	PrivilegeBeforeActivate                       // after SOL configuration and serial routing, just before activation
+	PrivilegeSkip                                 // never: the session keeps the privilege it authenticated with
+)
+
+// Quirks adjust the Connect handshake to a BMC's firmware. The zero value
+// is the generic handshake.
+type Quirks struct {
+	Profile            string         // name of the profile these came from, for logs
+	Privilege          PrivilegeOrder // when Set Session Privilege Level is sent
+	ActivateRetries    int            // retries of an Activate Payload refused as already active (0x80)
+	ActivateRetryDelay time.Duration  // wait before each retry; default 1s
+	SOLInstance        uint8          // SOL payload instance to activate; 0 = 1
+	DeactivateActive   bool           // before activating, deactivate every SOL instance Get Payload Activation Status reports active, not just SOLInstance
+}
+
+// instance returns the SOL payload instance to activate.
+func (q Quirks) instance() uint8 {
+	if q.SOLInstance == 0 {
+		return 1
+	}
+	return q.SOLInstance
+}
+
+// quirkProfiles are the built-in profiles, by name.
+var quirkProfiles = map[string]Quirks{
+	"generic": {Profile: "generic"},
+
+	// iDRAC refuses Activate Payload until the session privilege has been
+	// raised, so it goes first whatever the default becomes
+	"dell": {Profile: "dell", Privilege: PrivilegeFirst},
+
+	// Supermicro BMCs report the SOL payload active (0x80) for a few
+	// seconds after it is deactivated or its session is dropped
+	"supermicro": {Profile: "supermicro", ActivateRetries: 3, ActivateRetryDelay: 2 * time.Second},
+
+	// iLO leaves a dropped session's SOL payload active on whichever
+	// instance it had, so deactivate what it reports rather than assume 1
+	"hpe": {Profile: "hpe", SOLInstance: 1, DeactivateActive: true},
+}
+
+// quirkMatches pick a profile from Get Device ID; the first match wins. A
+// product of -1 matches any product.
+var quirkMatches = []struct {
+	manufacturer uint32
+	product      int
+	profile      string
+}{
+	{ManufacturerDell, -1, "dell"},
+	{ManufacturerSupermicro, -1, "supermicro"},
+	{ManufacturerHP, -1, "hpe"},
+	{ManufacturerHPE, -1, "hpe"},
+}
+
+// QuirksProfile returns a built-in profile: generic, dell, supermicro or
+// hpe.
+func QuirksProfile(name string) (Quirks, bool) {
+	q, ok := quirkProfiles[name]
+	return q, ok
+}
+
+// QuirksProfiles lists the built-in profile names.
+func QuirksProfiles() []string {
+	names := make([]string, 0, len(quirkProfiles))
+	for name := range quirkProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// QuirksFor returns the profile for a BMC by its Get Device ID, or the
+// generic one for an unknown BMC or a nil id (Get Device ID failed).
+func QuirksFor(id *DeviceID) Quirks {
+	if id != nil {
+		for _, m := range quirkMatches {
+			if m.manufacturer == id.ManufacturerID && (m.product < 0 || m.product == int(id.ProductID)) {
+				return quirkProfiles[m.profile]
+			}
+		}
+	}
+	return quirkProfiles["generic"]
+}
+
+// detectQuirks reads the BMC's Get Device ID and chooses its quirks with
+// Config.Quirks. A BMC that doesn't answer gets the quirks for a nil id.
+func (s *Session) detectQuirks(ctx context.Context) {
+	start := s.clock.Now()
+	id, err := s.GetDeviceID(ctx)
+	s.phase(PhaseDeviceID, start, err)
+	if err != nil {
+		s.logf("Get Device ID from %s failed: %v", s.host, err)
+		id = nil
+	}
+	s.deviceID = id
+	s.quirks = s.quirksFor(id)
+	if id != nil {
+		s.logf("%s: manufacturer %d product 0x%04X firmware %s, quirks %q",
+			s.host, id.ManufacturerID, id.ProductID, id.Firmware(), s.quirks.Profile)
+	}
+}
+
+// deactivateStale deactivates a SOL payload left active, e.g. by a dropped
+// session, so activation can take it.
+func (s *Session) deactivateStale(ctx context.Context) {
+	if !s.quirks.DeactivateActive {
+		s.deactivateSOL(ctx) // Ignore errors
+		return
+	}
+	status, err := s.GetPayloadActivationStatus(ctx, PayloadSOL)
+	if err != nil {
+		s.logf("payload activation status from %s: %v", s.host, err)
+		s.deactivateSOL(ctx)
+		return
+	}
+	for _, instance := range status.Active {
+		s.deactivateInstance(ctx, uint8(instance))
+	}
+}
+
+// activateWithRetry activates SOL, retrying as Quirks.ActivateRetries says
+// while the BMC reports the payload still active.
+func (s *Session) activateWithRetry(ctx context.Context) error {
+	delay := s.quirks.ActivateRetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	err := s.activateSOL(ctx)
+	for i := 0; i < s.quirks.ActivateRetries && errors.Is(err, ErrSOLActive); i++ {
+		s.logf("SOL on %s still active, retrying activation in %v", s.host, delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.clock.After(delay):
+		}
+		s.deactivateStale(ctx)
+		err = s.activateSOL(ctx)
+	}
+	return err
+}
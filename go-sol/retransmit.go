@@ -0,0 +1,191 @@
+package sol
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Outbound retransmission defaults (Config.RetryCount, Config.RetryInterval)
+const (
+	defaultRetryCount    = 7
+	defaultRetryInterval = 500 * time.Millisecond
+)
+
+// Stats counts a session's SOL traffic since SOL was activated.
+type Stats struct {
+	Since          time.Time `json:"since"`          // when SOL was activated
+	Sent           uint64    `json:"sent"`           // packets sent, not counting retransmissions
+	Accepted       uint64    `json:"accepted"`       // characters the BMC reported accepting
+	Retransmits    uint64    `json:"retransmits"`    // packets resent after a timeout, NACK or partial ACK
+	PartialAccepts uint64    `json:"partialAccepts"` // ACKs/NACKs accepting only part of a packet
+	Nacks          uint64    `json:"nacks"`          // NACKs received from the BMC
+	Dropped        uint64    `json:"dropped"`        // packets given up on after all retries
+	DroppedBytes   uint64    `json:"droppedBytes"`   // characters lost with them
+	PacketsOut     uint64    `json:"packetsOut"`     // SOL packets written: data, retransmissions and ACKs
+	BytesOut       uint64    `json:"bytesOut"`       // characters written, counting retransmissions
+	PacketsIn      uint64    `json:"packetsIn"`      // SOL packets received
+	BytesIn        uint64    `json:"bytesIn"`        // characters received, not counting duplicates
+	Duplicates     uint64    `json:"duplicates"`     // inbound packets the BMC resent because our ACK was lost
+	AckLatency     float64   `json:"ackLatency"`     // average milliseconds from sending a packet to its ACK
+}
+
+// solAck is the acknowledgement carried by an inbound SOL packet.
+type solAck struct {
+	seq      uint8
+	accepted uint8
+	nack     bool
+}
+
+// Stats returns the session's traffic counters.
+func (s *Session) Stats() Stats {
+	s.mu.Lock()
+	since := s.activeSince
+	s.mu.Unlock()
+	var latency float64
+	if n := s.statAckSamples.Load(); n > 0 {
+		latency = float64(s.statAckTime.Load()) / float64(n) / float64(time.Millisecond)
+	}
+	return Stats{
+		Since:          since,
+		Sent:           s.statSent.Load(),
+		Accepted:       s.statAccepted.Load(),
+		Retransmits:    s.statRetransmits.Load(),
+		PartialAccepts: s.statPartial.Load(),
+		Nacks:          s.statNacks.Load(),
+		Dropped:        s.statDropped.Load(),
+		DroppedBytes:   s.statDroppedBytes.Load(),
+		PacketsOut:     s.statPacketsOut.Load(),
+		BytesOut:       s.statBytesOut.Load(),
+		PacketsIn:      s.statPacketsIn.Load(),
+		BytesIn:        s.statBytesIn.Load(),
+		Duplicates:     s.statDuplicates.Load(),
+		AckLatency:     latency,
+	}
+}
+
+// nextSolSeq allocates an outbound packet sequence number. SOL sequence
+// numbers are 4 bits and 0 means "no packet", so they run 1..15.
+func (s *Session) nextSolSeq() uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := s.solSeqNum
+	s.solSeqNum = s.solSeqNum%15 + 1
+	return seq
+}
+
+// sendReliable sends one SOL packet and waits for the BMC to acknowledge it.
+// A packet that is not acknowledged within the retry interval is resent with
+// the same sequence number. An ACK reports how many characters the BMC
+// accepted; the unaccepted remainder of a partial ACK or NACK is resent in a
+// new packet, after the retry interval for a NACK since the BMC is busy.
+// Accepted characters reset the retry budget, so a slow BMC UART draining a
+// few characters per packet does not cause a drop; the packet is dropped
+// after retryCount resends without progress.
+//
+// ACK latency is sampled only for packets sent once, since an ACK of a
+// resent packet can't be matched to one of the copies.
+func (s *Session) sendReliable(data []byte, op uint8) error {
+	seq := s.nextSolSeq()
+	s.statSent.Add(1)
+	retries := 0
+	resent := false
+	for {
+		sentAt := s.clock.Now()
+		if err := s.writeSolPacket(seq, data, op); err != nil {
+			s.statDropped.Add(1)
+			s.statDroppedBytes.Add(uint64(len(data)))
+			return err
+		}
+
+		ack, err := s.waitSolAck(seq)
+		if err != nil {
+			return err
+		}
+		if ack != nil {
+			if !resent {
+				s.statAckTime.Add(int64(s.clock.Now().Sub(sentAt)))
+				s.statAckSamples.Add(1)
+			}
+			n := int(ack.accepted)
+			if n > len(data) {
+				n = len(data)
+			}
+			s.statAccepted.Add(uint64(n))
+			if ack.nack {
+				s.statNacks.Add(1)
+			}
+			if !ack.nack && n == len(data) {
+				return nil
+			}
+			if n > 0 {
+				s.statPartial.Add(1)
+				data = data[n:]
+				op = 0 // delivered with the accepted characters
+				retries = 0
+				if len(data) == 0 {
+					return nil
+				}
+			}
+		}
+
+		if retries >= s.retryCount {
+			s.statDropped.Add(1)
+			s.statDroppedBytes.Add(uint64(len(data)))
+			return fmt.Errorf("SOL packet %d not acknowledged after %d retries", seq, retries)
+		}
+		retries++
+		s.statRetransmits.Add(1)
+		if ack == nil {
+			resent = true
+			continue // timed out, resend as is
+		}
+
+		seq, resent = s.nextSolSeq(), false
+		if ack.nack {
+			select {
+			case <-s.clock.After(s.retryInterval):
+			case <-s.done:
+				return errors.New("session closed")
+			}
+		}
+	}
+}
+
+// waitSolAck waits up to the retry interval for the BMC to acknowledge seq.
+// It returns nil on timeout; acknowledgements of older packets are skipped.
+func (s *Session) waitSolAck(seq uint8) (*solAck, error) {
+	timeout := s.clock.After(s.retryInterval)
+	for {
+		select {
+		case ack := <-s.ackCh:
+			if ack.seq == seq {
+				return &ack, nil
+			}
+		case <-timeout:
+			return nil, nil
+		case <-s.done:
+			return nil, errors.New("session closed")
+		}
+	}
+}
+
+// writeSolPacket sends a single SOL data packet. Inbound data is
+// acknowledged separately by readLoop, so the ACK fields stay zero.
+func (s *Session) writeSolPacket(seq uint8, data []byte, op uint8) error {
+	header := solPacketHeader{
+		PacketSeq: seq,
+		OpStatus:  op,
+	}
+	payload := make([]byte, 4+len(data))
+	copy(payload[0:4], header.pack())
+	copy(payload[4:], data)
+
+	s.conn.SetWriteDeadline(s.clock.Now().Add(5 * time.Second))
+	_, err := s.conn.Write(s.buildSolPacket(payload))
+	if err == nil {
+		s.statPacketsOut.Add(1)
+		s.statBytesOut.Add(uint64(len(data)))
+	}
+	return err
+}
@@ -0,0 +1,362 @@
+package sol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// SDR repository commands (netFn Storage) and sensor commands (netFn Sensor)
+const (
+	cmdGetSDRRepositoryInfo = 0x20
+	cmdReserveSDRRepository = 0x22
+	cmdGetSDR               = 0x23
+	cmdGetSensorReading     = 0x2D
+
+	// SDRFirstRecord and SDRLastRecord are the special record IDs accepted
+	// by GetSDR. A NextID of SDRLastRecord means no more records.
+	SDRFirstRecord = 0x0000
+	SDRLastRecord  = 0xFFFF
+
+	// SDR record types with a sensor behind them
+	SDRTypeFull    = 0x01
+	SDRTypeCompact = 0x02
+)
+
+// ccReservationCancelled is returned by Get SDR when the reservation was
+// cancelled by a repository change or another requester.
+const ccReservationCancelled = 0xC5
+
+// sdrHeaderLen is the record ID, SDR version, type and length prefix.
+const sdrHeaderLen = 5
+
+// sdrChunk is how much of a record is requested at a time. Many BMCs can't
+// return a whole record in one response, so records are read in pieces.
+const sdrChunk = 16
+
+// SDRInfo is the decoded Get SDR Repository Info response.
+type SDRInfo struct {
+	Version      uint8
+	Records      uint16
+	FreeBytes    uint16
+	LastAddition time.Time
+	LastErase    time.Time
+}
+
+// SDRRecord is a Sensor Data Record. Full (type 0x01) and compact (type
+// 0x02) records have their sensor fields decoded; full records also carry
+// the factors for converting raw readings. Other types only carry Raw.
+type SDRRecord struct {
+	ID             uint16
+	Type           uint8
+	OwnerID        uint8
+	OwnerLUN       uint8
+	Number         uint8
+	EntityID       uint8
+	EntityInstance uint8
+	SensorType     uint8
+	EventType      uint8 // event/reading type code; 0x01 is threshold-based
+	Name           string
+	Unit           string
+	Raw            []byte
+
+	// Analog conversion factors (full records only)
+	Format        uint8 // 0 unsigned, 1 one's complement, 2 two's complement, 3 none
+	Linearization uint8
+	M, B          int
+	BExp, RExp    int
+}
+
+// SensorReading is the decoded Get Sensor Reading response.
+type SensorReading struct {
+	Raw              uint8
+	Unavailable      bool
+	ScanningDisabled bool
+	// State holds the threshold comparison bits (bit 0 lower non-critical
+	// through bit 5 upper non-recoverable) for threshold sensors, or the
+	// asserted state offsets for discrete sensors.
+	State uint16
+}
+
+// GetSDRRepositoryInfo reads the BMC's SDR repository summary.
+func (s *Session) GetSDRRepositoryInfo(ctx context.Context) (*SDRInfo, error) {
+	data, err := s.Command(ctx, netFnStorage, cmdGetSDRRepositoryInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 13 {
+		return nil, fmt.Errorf("SDR repository info response too short: %d", len(data))
+	}
+	return &SDRInfo{
+		Version:      data[0],
+		Records:      binary.LittleEndian.Uint16(data[1:3]),
+		FreeBytes:    binary.LittleEndian.Uint16(data[3:5]),
+		LastAddition: ipmiTime(binary.LittleEndian.Uint32(data[5:9])),
+		LastErase:    ipmiTime(binary.LittleEndian.Uint32(data[9:13])),
+	}, nil
+}
+
+// ReserveSDRRepository returns a reservation ID for partial record reads.
+func (s *Session) ReserveSDRRepository(ctx context.Context) (uint16, error) {
+	data, err := s.Command(ctx, netFnStorage, cmdReserveSDRRepository, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 2 {
+		return 0, fmt.Errorf("reserve SDR response too short: %d", len(data))
+	}
+	return binary.LittleEndian.Uint16(data[0:2]), nil
+}
+
+// GetSDR reads one record under a reservation and returns it with the ID of
+// the next record. The header is read first and the body in chunks. A
+// CompletionError with code 0xC5 means the reservation was cancelled and
+// must be renewed.
+func (s *Session) GetSDR(ctx context.Context, reservation, id uint16) (*SDRRecord, uint16, error) {
+	next, header, err := s.getSDRPart(ctx, reservation, id, 0, sdrHeaderLen)
+	if err != nil {
+		return nil, SDRLastRecord, err
+	}
+	if len(header) < sdrHeaderLen {
+		return nil, SDRLastRecord, fmt.Errorf("SDR header too short: %d", len(header))
+	}
+
+	length := int(header[4])
+	rec := append(make([]byte, 0, sdrHeaderLen+length), header[:sdrHeaderLen]...)
+	for len(rec) < sdrHeaderLen+length {
+		n := sdrHeaderLen + length - len(rec)
+		if n > sdrChunk {
+			n = sdrChunk
+		}
+		_, part, err := s.getSDRPart(ctx, reservation, id, uint8(len(rec)), uint8(n))
+		if err != nil {
+			return nil, SDRLastRecord, err
+		}
+		if len(part) == 0 {
+			return nil, SDRLastRecord, fmt.Errorf("SDR 0x%04X: empty read at offset %d", id, len(rec))
+		}
+		rec = append(rec, part...)
+	}
+	return parseSDRRecord(rec), next, nil
+}
+
+func (s *Session) getSDRPart(ctx context.Context, reservation, id uint16, offset, count uint8) (uint16, []byte, error) {
+	req := make([]byte, 6)
+	binary.LittleEndian.PutUint16(req[0:2], reservation)
+	binary.LittleEndian.PutUint16(req[2:4], id)
+	req[4] = offset
+	req[5] = count
+
+	data, err := s.Command(ctx, netFnStorage, cmdGetSDR, req)
+	if err != nil {
+		return SDRLastRecord, nil, err
+	}
+	if len(data) < 2 {
+		return SDRLastRecord, nil, fmt.Errorf("SDR response too short: %d", len(data))
+	}
+	return binary.LittleEndian.Uint16(data[0:2]), data[2:], nil
+}
+
+// ListSDR reads every record in the repository, renewing the reservation
+// if the BMC cancels it part way through.
+func (s *Session) ListSDR(ctx context.Context) ([]*SDRRecord, error) {
+	info, err := s.GetSDRRepositoryInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reservation, err := s.ReserveSDRRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*SDRRecord
+	id := uint16(SDRFirstRecord)
+	for retries := 0; len(records) <= int(info.Records) && id != SDRLastRecord; {
+		rec, next, err := s.GetSDR(ctx, reservation, id)
+		var ce *CompletionError
+		if errors.As(err, &ce) && ce.Code == ccReservationCancelled && retries < 3 {
+			retries++
+			if reservation, err = s.ReserveSDRRepository(ctx); err != nil {
+				return records, err
+			}
+			continue
+		}
+		if err != nil {
+			return records, fmt.Errorf("get SDR 0x%04X: %w", id, err)
+		}
+		records = append(records, rec)
+		id = next
+		retries = 0
+	}
+	return records, nil
+}
+
+func parseSDRRecord(rec []byte) *SDRRecord {
+	r := &SDRRecord{
+		ID:   binary.LittleEndian.Uint16(rec[0:2]),
+		Type: rec[3],
+		Raw:  rec,
+	}
+	var nameAt int
+	switch {
+	case r.Type == SDRTypeFull && len(rec) >= 48:
+		nameAt = 47
+	case r.Type == SDRTypeCompact && len(rec) >= 32:
+		nameAt = 31
+	default:
+		return r
+	}
+
+	r.OwnerID = rec[5]
+	r.OwnerLUN = rec[6] & 0x03
+	r.Number = rec[7]
+	r.EntityID = rec[8]
+	r.EntityInstance = rec[9]
+	r.SensorType = rec[12]
+	r.EventType = rec[13] & 0x7F
+	r.Unit = sensorUnit(rec[20], rec[21])
+	if n := int(rec[nameAt] & 0x1F); nameAt+1+n <= len(rec) {
+		r.Name = trimName(rec[nameAt+1 : nameAt+1+n])
+	}
+
+	if r.Type == SDRTypeFull {
+		r.Format = rec[20] >> 6
+		r.Linearization = rec[23] & 0x7F
+		r.M = signExtend(int(rec[24])|int(rec[25]&0xC0)<<2, 10)
+		r.B = signExtend(int(rec[26])|int(rec[27]&0xC0)<<2, 10)
+		r.RExp = signExtend(int(rec[29]>>4), 4)
+		r.BExp = signExtend(int(rec[29]&0x0F), 4)
+	} else {
+		r.Format = 3
+	}
+	return r
+}
+
+// Convert turns a raw reading into the sensor's unit. It returns false for
+// sensors without an analog reading.
+func (r *SDRRecord) Convert(raw uint8) (float64, bool) {
+	if r.Type != SDRTypeFull {
+		return 0, false
+	}
+	var x float64
+	switch r.Format {
+	case 0:
+		x = float64(raw)
+	case 1:
+		x = float64(int8(raw))
+		if raw&0x80 != 0 {
+			x++
+		}
+	case 2:
+		x = float64(int8(raw))
+	default:
+		return 0, false
+	}
+
+	y := (float64(r.M)*x + float64(r.B)*math.Pow10(r.BExp)) * math.Pow10(r.RExp)
+	switch r.Linearization {
+	case 1:
+		y = math.Log(y)
+	case 2:
+		y = math.Log10(y)
+	case 3:
+		y = math.Log2(y)
+	case 4:
+		y = math.Exp(y)
+	case 5:
+		y = math.Pow(10, y)
+	case 6:
+		y = math.Exp2(y)
+	case 7:
+		if y == 0 {
+			return 0, false
+		}
+		y = 1 / y
+	case 8:
+		y = y * y
+	case 9:
+		y = y * y * y
+	case 10:
+		y = math.Sqrt(y)
+	case 11:
+		y = math.Cbrt(y)
+	}
+	return y, true
+}
+
+// GetSensorReading reads the current value of a sensor owned by the BMC.
+func (s *Session) GetSensorReading(ctx context.Context, number uint8) (*SensorReading, error) {
+	data, err := s.Command(ctx, netFnSensor, cmdGetSensorReading, []byte{number})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("sensor reading response too short: %d", len(data))
+	}
+	r := &SensorReading{
+		Raw:              data[0],
+		ScanningDisabled: data[1]&0x40 == 0,
+		Unavailable:      data[1]&0x20 != 0,
+	}
+	if len(data) > 2 {
+		r.State = uint16(data[2])
+	}
+	if len(data) > 3 {
+		r.State |= uint16(data[3]&0x7F) << 8
+	}
+	return r, nil
+}
+
+func signExtend(v, bits int) int {
+	if v&(1<<(bits-1)) != 0 {
+		v -= 1 << bits
+	}
+	return v
+}
+
+func trimName(b []byte) string {
+	end := len(b)
+	for end > 0 && (b[end-1] == 0 || b[end-1] == ' ') {
+		end--
+	}
+	return string(b[:end])
+}
+
+// sensorUnit names the base unit of a sensor (IPMI v2.0 table 43-15).
+func sensorUnit(units1, base uint8) string {
+	if units1&0x01 != 0 {
+		return "%"
+	}
+	return sensorUnits[base]
+}
+
+var sensorUnits = map[uint8]string{
+	1:  "degrees C",
+	2:  "degrees F",
+	3:  "degrees K",
+	4:  "Volts",
+	5:  "Amps",
+	6:  "Watts",
+	7:  "Joules",
+	9:  "VA",
+	14: "kPa",
+	15: "PSI",
+	17: "CFM",
+	18: "RPM",
+	19: "Hz",
+	20: "microseconds",
+	21: "milliseconds",
+	22: "seconds",
+	23: "minutes",
+	24: "hours",
+	25: "days",
+	58: "PPM",
+	60: "dB",
+	66: "bits",
+	70: "bytes",
+	88: "errors",
+	89: "correctable errors",
+}
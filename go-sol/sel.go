@@ -0,0 +1,110 @@
+package sol
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// SEL commands (netFn Storage)
+const (
+	cmdGetSELInfo  = 0x40
+	cmdGetSELEntry = 0x43
+
+	// SELFirstRecord and SELLastRecord are the special record IDs accepted
+	// by GetSELEntry. A NextID of SELLastRecord means no more records.
+	SELFirstRecord = 0x0000
+	SELLastRecord  = 0xFFFF
+)
+
+// SELInfo is the decoded Get SEL Info response.
+type SELInfo struct {
+	Version      uint8
+	Entries      uint16
+	FreeBytes    uint16
+	LastAddition time.Time
+	LastErase    time.Time
+}
+
+// SELRecord is a single System Event Log record. Standard (type 0x02)
+// records have their event fields decoded; OEM records only carry Raw.
+type SELRecord struct {
+	ID           uint16
+	Type         uint8
+	Timestamp    time.Time
+	GeneratorID  uint16
+	SensorType   uint8
+	SensorNumber uint8
+	EventType    uint8 // event/reading type code (bits 6:0)
+	Deasserted   bool
+	EventData    [3]byte
+	Raw          []byte
+}
+
+// GetSELInfo reads the BMC's SEL summary.
+func (s *Session) GetSELInfo(ctx context.Context) (*SELInfo, error) {
+	data, err := s.Command(ctx, netFnStorage, cmdGetSELInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 13 {
+		return nil, fmt.Errorf("SEL info response too short: %d", len(data))
+	}
+	return &SELInfo{
+		Version:      data[0],
+		Entries:      binary.LittleEndian.Uint16(data[1:3]),
+		FreeBytes:    binary.LittleEndian.Uint16(data[3:5]),
+		LastAddition: ipmiTime(binary.LittleEndian.Uint32(data[5:9])),
+		LastErase:    ipmiTime(binary.LittleEndian.Uint32(data[9:13])),
+	}, nil
+}
+
+// GetSELEntry reads one SEL record and returns it with the ID of the next record.
+func (s *Session) GetSELEntry(ctx context.Context, id uint16) (*SELRecord, uint16, error) {
+	req := make([]byte, 6)
+	// req[0:2] reservation ID = 0 (not needed for full-record reads)
+	binary.LittleEndian.PutUint16(req[2:4], id)
+	req[4] = 0x00 // offset into record
+	req[5] = 0xFF // read entire record
+
+	data, err := s.Command(ctx, netFnStorage, cmdGetSELEntry, req)
+	if err != nil {
+		return nil, SELLastRecord, err
+	}
+	if len(data) < 2+16 {
+		return nil, SELLastRecord, fmt.Errorf("SEL entry response too short: %d", len(data))
+	}
+	next := binary.LittleEndian.Uint16(data[0:2])
+	return parseSELRecord(data[2:18]), next, nil
+}
+
+func parseSELRecord(rec []byte) *SELRecord {
+	r := &SELRecord{
+		ID:   binary.LittleEndian.Uint16(rec[0:2]),
+		Type: rec[2],
+		Raw:  append([]byte{}, rec...),
+	}
+	if r.Type < 0xE0 {
+		// Standard or timestamped OEM record
+		r.Timestamp = ipmiTime(binary.LittleEndian.Uint32(rec[3:7]))
+	}
+	if r.Type == 0x02 {
+		r.GeneratorID = binary.LittleEndian.Uint16(rec[7:9])
+		r.SensorType = rec[10]
+		r.SensorNumber = rec[11]
+		r.EventType = rec[12] & 0x7F
+		r.Deasserted = rec[12]&0x80 != 0
+		copy(r.EventData[:], rec[13:16])
+	}
+	return r
+}
+
+// ipmiTime converts an IPMI timestamp (seconds since epoch) to time.Time.
+// 0xFFFFFFFF means unspecified and returns the zero time.
+func ipmiTime(ts uint32) time.Time {
+	if ts == 0xFFFFFFFF || ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(ts), 0)
+}
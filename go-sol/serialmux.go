@@ -0,0 +1,116 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+)
+
+// Serial/modem commands
+const (
+	cmdGetChannelInfo = 0x42 // netFn App
+	cmdSetSerialMux   = 0x12 // netFn Transport
+)
+
+// mediumRS232 is the Get Channel Info medium type of a serial channel.
+const mediumRS232 = 0x05
+
+// MuxSetting is a Set Serial/Modem Mux request.
+type MuxSetting uint8
+
+// Serial MUX requests. A request is refused while the BMC blocks switching
+// (e.g. during a serial alert); a force is not.
+const (
+	MuxGet           MuxSetting = 0x00 // read the MUX without changing it
+	MuxRequestSystem MuxSetting = 0x01 // connect the serial connector to the system UART
+	MuxRequestBMC    MuxSetting = 0x02 // connect the serial connector to the BMC
+	MuxForceSystem   MuxSetting = 0x03
+	MuxForceBMC      MuxSetting = 0x04
+)
+
+// MuxStatus is the Set Serial/Modem Mux response.
+type MuxStatus struct {
+	BMC           bool // the MUX is set to the BMC; false = the system
+	Rejected      bool // the request was refused
+	BMCBlocked    bool // requests to switch to the BMC are blocked
+	SystemBlocked bool // requests to switch to the system are blocked
+}
+
+// SerialConfig routes the host's serial console before SOL is activated,
+// for boards whose SOL defaults to a serial port nothing is attached to.
+type SerialConfig struct {
+	Channel uint8      // serial channel; 0 = the first RS-232 channel
+	Mux     MuxSetting // MuxGet leaves the MUX alone
+	UART    []byte     // optional raw request (netFn, cmd, data...) selecting the console UART; vendor-specific
+}
+
+// IsZero reports whether c changes nothing.
+func (c SerialConfig) IsZero() bool {
+	return c.Mux == MuxGet && len(c.UART) == 0
+}
+
+// SerialChannel finds the BMC's serial channel: the first channel whose
+// medium is RS-232.
+func (s *Session) SerialChannel(ctx context.Context) (uint8, error) {
+	for ch := uint8(0); ch <= 0x0B; ch++ {
+		data, err := s.Command(ctx, netFnApp, cmdGetChannelInfo, []byte{ch})
+		if err != nil || len(data) < 2 {
+			continue // not implemented
+		}
+		if data[1]&0x7F == mediumRS232 {
+			return ch, nil
+		}
+	}
+	return 0, fmt.Errorf("no serial channel")
+}
+
+// SetSerialMux sends a Set Serial/Modem Mux request for a serial channel
+// (0 = SerialChannel) and returns the MUX status. A rejected request is
+// not an error; check Rejected.
+func (s *Session) SetSerialMux(ctx context.Context, channel uint8, setting MuxSetting) (*MuxStatus, error) {
+	if setting > MuxForceBMC {
+		return nil, fmt.Errorf("mux setting %d: want 0-4", setting)
+	}
+	if channel == 0 {
+		var err error
+		if channel, err = s.SerialChannel(ctx); err != nil {
+			return nil, err
+		}
+	}
+	data, err := s.Command(ctx, netFnTransport, cmdSetSerialMux, []byte{channel & 0x0F, uint8(setting)})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 {
+		return nil, fmt.Errorf("serial mux response too short: %d", len(data))
+	}
+	return &MuxStatus{
+		BMC:           data[0]&0x01 != 0,
+		Rejected:      data[0]&0x02 != 0,
+		BMCBlocked:    data[0]&0x40 != 0,
+		SystemBlocked: data[0]&0x80 != 0,
+	}, nil
+}
+
+// SetSerial applies c: the UART request first, so a MUX switch lands on
+// the UART it selects, then the MUX.
+func (s *Session) SetSerial(ctx context.Context, c SerialConfig) error {
+	if len(c.UART) > 0 {
+		if len(c.UART) < 2 {
+			return fmt.Errorf("UART request: want netFn and cmd")
+		}
+		if _, err := s.Command(ctx, c.UART[0], c.UART[1], c.UART[2:]); err != nil {
+			return fmt.Errorf("UART request: %w", err)
+		}
+	}
+	if c.Mux == MuxGet {
+		return nil
+	}
+	st, err := s.SetSerialMux(ctx, c.Channel, c.Mux)
+	if err != nil {
+		return fmt.Errorf("set serial mux: %w", err)
+	}
+	if st.Rejected {
+		return fmt.Errorf("set serial mux: request rejected")
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package sol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// getChannelAuthCaps retrieves channel authentication capabilities and
+// chooses the kind of session: RMCP+, or IPMI v1.5 when the BMC reports no
+// IPMI v2.0 support (see session15.go)
+func (s *Session) getChannelAuthCaps(ctx context.Context) error {
+	// Channel 0x0E = current channel, with the IPMI v2.0 bit
+	caps, err := s.authCaps(ctx, 0x8E)
+	var ce *CompletionError
+	if errors.As(err, &ce) {
+		// BMCs that predate IPMI v2.0 may refuse the v2.0 bit
+		caps, err = s.authCaps(ctx, 0x0E)
+		if errors.As(err, &ce) {
+			// Nothing to go on: assume RMCP+, as a BMC refusing both
+			// requests has always been treated
+			s.logf("auth caps for %s refused (%v), assuming RMCP+", s.host, err)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.authTypes = caps[1] & 0x3F
+	if caps[1]&authCapV20Data != 0 && caps[3]&authCapRMCPP != 0 {
+		return nil
+	}
+	if s.noV15 {
+		return ErrNoRMCPP
+	}
+	s.logf("%s has no RMCP+, using an IPMI v1.5 session (auth types 0x%02X)", s.host, s.authTypes)
+	s.v15 = true
+	return nil
+}
+
+// openSessionStatus is the status code of a refused Open Session Request.
+type openSessionStatus uint8
+
+func (e openSessionStatus) Error() string {
+	return fmt.Sprintf("open session failed with status: 0x%02X", uint8(e))
+}
+
+// openSession sends RMCP+ Open Session Request, proposing cipher suite 1
+// (RAKP-HMAC-SHA1, no integrity, no encryption) or 2 (adds HMAC-SHA1-96
+// integrity); 0 is 1.
+func (s *Session) openSession(ctx context.Context, suite int) error {
+	integrity := uint8(integrityNone)
+	switch suite {
+	case 0, 1:
+	case 2:
+		integrity = integrityHmacSHA1
+	default:
+		return fmt.Errorf("cipher suite %d not supported: want 1 or 2", suite)
+	}
+
+	// Generate random console session ID
+	randBytes, err := generateRandomBytes(4)
+	if err != nil {
+		return err
+	}
+	s.sessionID = binary.LittleEndian.Uint32(randBytes)
+
+	// Open Session Request payload
+	// Message tag (1) + Requested max priv (1) + Reserved (2) + Console Session ID (4)
+	// + Auth payload (8) + Integrity payload (8) + Confidentiality payload (8)
+	payload := make([]byte, 32)
+	payload[0] = 0 // Message tag
+	payload[1] = privAdmin
+	// payload[2:4] reserved
+	binary.LittleEndian.PutUint32(payload[4:8], s.sessionID)
+
+	// Authentication algorithm payload
+	payload[8] = 0x00  // Payload type
+	payload[9] = 0x00  // Reserved
+	payload[10] = 0x00 // Reserved
+	payload[11] = 0x08 // Payload length
+	payload[12] = authRakpHmacSHA1 // Auth algorithm
+	// payload[13:16] reserved
+
+	// Integrity algorithm payload
+	payload[16] = 0x01 // Payload type
+	payload[17] = 0x00
+	payload[18] = 0x00
+	payload[19] = 0x08
+	payload[20] = integrity // Integrity algorithm
+	// payload[21:24] reserved
+
+	// Confidentiality algorithm payload
+	payload[24] = 0x02 // Payload type
+	payload[25] = 0x00
+	payload[26] = 0x00
+	payload[27] = 0x08
+	payload[28] = cryptoNone // No encryption for simplicity
+	// payload[29:32] reserved
+
+	packet := buildRMCPPacket(ipmiAuthRMCPP, payloadOpenReq, 0, 0, payload)
+
+	resp, err := s.sendRecv(ctx, packet, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	// Parse Open Session Response. A refusal may carry only the message
+	// tag, status and console session ID.
+	if len(resp) >= 18 && resp[17] != 0 {
+		return openSessionStatus(resp[17])
+	}
+	if len(resp) < 36 {
+		return fmt.Errorf("open session response too short: %d", len(resp))
+	}
+
+	// Skip RMCP header (4) + IPMI session header (12)
+	respData := resp[16:]
+
+	// Check status code
+	if len(respData) < 20 {
+		return fmt.Errorf("open session response data too short")
+	}
+
+	// Extract BMC session ID (Managed System Session ID is at offset 8, not 4)
+	// Offset 4 is the echo of our Console Session ID
+	s.remoteSessionID = binary.LittleEndian.Uint32(respData[8:12])
+	s.authAlg = respData[16]      // Auth payload starts at 12, algorithm at 12+4
+	s.integrityAlg = respData[24] // Integrity payload starts at 20, algorithm at 20+4
+	s.cryptoAlg = respData[32]    // Crypto payload starts at 28, algorithm at 28+4
+
+	return nil
+}
+
+// rakpHandshake performs RAKP 1-4 authentication
+func (s *Session) rakpHandshake(ctx context.Context) error {
+	// Generate random number for console
+	rmRand, err := generateRandomBytes(16)
+	if err != nil {
+		return err
+	}
+
+	// RAKP Message 1
+	rakp1 := make([]byte, 28+len(s.username))
+	rakp1[0] = 0 // Message tag
+	// rakp1[1:4] reserved
+	binary.LittleEndian.PutUint32(rakp1[4:8], s.remoteSessionID)
+	copy(rakp1[8:24], rmRand) // Console random number
+	rakp1[24] = privAdmin     // Requested role
+	// rakp1[25:26] reserved
+	rakp1[27] = uint8(len(s.username))
+	copy(rakp1[28:], []byte(s.username))
+
+	packet := buildRMCPPacket(ipmiAuthRMCPP, payloadRAKP1, 0, 0, rakp1)
+	resp, err := s.sendRecv(ctx, packet, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("RAKP1 failed: %w", err)
+	}
+
+	// Parse RAKP Message 2
+	if len(resp) < 40 {
+		return fmt.Errorf("RAKP2 response too short")
+	}
+	respData := resp[16:] // Skip headers
+
+	if respData[1] != 0 {
+		return fmt.Errorf("RAKP2 status error: 0x%02X", respData[1])
+	}
+
+	mcRand := respData[8:24]          // BMC random number
+	mcGUID := respData[24:40]         // BMC GUID
+	_ = mcGUID                        // Not used currently
+
+	// Generate session keys. The user key (Kuid) is the password
+	// padded/truncated to 20 bytes and authenticates RAKP; the SIK is keyed
+	// with the BMC key Kg, which defaults to Kuid when the BMC has none set.
+	kuid := make([]byte, 20)
+	copy(kuid, []byte(s.password))
+	kg := kuid
+	if len(s.kg) > 0 {
+		kg = make([]byte, 20)
+		copy(kg, s.kg)
+	}
+
+	s.sik = generateSIK(s.authAlg, kg, rmRand, mcRand, privAdmin, s.username)
+	s.k1 = generateK1(s.authAlg, s.sik)
+	s.k2 = generateK2(s.authAlg, s.sik)
+
+	// RAKP Message 3
+	// Calculate auth code for RAKP3
+	authData := make([]byte, 22+len(s.username))
+	copy(authData[0:16], mcRand)
+	binary.LittleEndian.PutUint32(authData[16:20], s.sessionID)
+	authData[20] = privAdmin
+	authData[21] = uint8(len(s.username))
+	copy(authData[22:], []byte(s.username))
+
+	authCode := hmacHash(s.authAlg, kuid, authData)
+
+	rakp3 := make([]byte, 8+len(authCode))
+	rakp3[0] = 0 // Message tag
+	// rakp3[1:4] reserved
+	binary.LittleEndian.PutUint32(rakp3[4:8], s.remoteSessionID)
+	copy(rakp3[8:], authCode)
+
+	packet = buildRMCPPacket(ipmiAuthRMCPP, payloadRAKP3, 0, 0, rakp3)
+	resp, err = s.sendRecv(ctx, packet, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("RAKP3 failed: %w", err)
+	}
+
+	// Parse RAKP Message 4
+	if len(resp) < 24 {
+		return fmt.Errorf("RAKP4 response too short")
+	}
+	respData = resp[16:]
+
+	if respData[1] != 0 {
+		return fmt.Errorf("RAKP4 status error: 0x%02X", respData[1])
+	}
+
+	// Authentication complete
+	return nil
+}
+
+// setSessionPrivilege elevates the session to the requested privilege level.
+// Some BMCs (Dell iDRAC) require this before allowing SOL payload activation.
+func (s *Session) setSessionPrivilege(ctx context.Context) error {
+	data := []byte{privAdmin}
+	msg := buildIPMIMessage(0x20, netFnApp, 0, 0x81, 0, 0, cmdSetSessionPriv, data)
+	packet := s.buildAuthenticatedPacket(payloadIPMI, msg)
+
+	resp, err := s.sendRecv(ctx, packet, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	// Minimum: RMCP(4) + Session(12) + IPMI header(6) + CC(1) = 23 bytes
+	if len(resp) < 23 {
+		return fmt.Errorf("set privilege response too short: %d", len(resp))
+	}
+
+	cc := resp[22]
+	if cc != 0x00 {
+		return fmt.Errorf("set privilege failed: completion code 0x%02X", cc)
+	}
+
+	return nil
+}
+
+// closeSession closes the RMCP+ session
+func (s *Session) closeSession(ctx context.Context) error {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, s.remoteSessionID)
+
+	msg := buildIPMIMessage(0x20, netFnApp, 0, 0x81, 0, 0, cmdCloseSession, data)
+	packet := s.buildAuthenticatedPacket(payloadIPMI, msg)
+
+	_, err := s.sendRecv(ctx, packet, 2*time.Second)
+	return err
+}
+
+// buildAuthenticatedPacket builds a packet with integrity
+func (s *Session) buildAuthenticatedPacket(payloadType uint8, payload []byte) []byte {
+	// Increment session sequence
+	s.sessionSeq++
+
+	if s.v15 {
+		return s.buildV15Packet(payload, s.sessionSeq)
+	}
+
+	// For unauthenticated/unencrypted, just wrap normally
+	if s.integrityAlg == integrityNone {
+		return buildRMCPPacket(ipmiAuthRMCPP, payloadType, s.remoteSessionID, s.sessionSeq, payload)
+	}
+
+	// With integrity: add AuthCode trailer
+	// Payload type has authenticated bit set (0x40)
+	packet := buildRMCPPacket(ipmiAuthRMCPP, payloadType|0x40, s.remoteSessionID, s.sessionSeq, payload)
+
+	// Add pad and AuthCode
+	padLen := (4 - (len(payload) % 4)) % 4
+	for i := 0; i < padLen; i++ {
+		packet = append(packet, 0xFF)
+	}
+	packet = append(packet, uint8(padLen))  // Pad length
+	packet = append(packet, 0x07)           // Next header (always 0x07)
+
+	// Calculate AuthCode over packet starting from AuthType
+	authCode := hmacHash(s.integrityAlg, s.k1, packet[4:])
+	packet = append(packet, authCode[:12]...) // Use first 12 bytes
+
+	return packet
+}
+
+// sendRecv sends a packet and waits for response
+func (s *Session) sendRecv(ctx context.Context, packet []byte, timeout time.Duration) ([]byte, error) {
+	if err := s.conn.SetDeadline(s.clock.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.conn.Write(packet); err != nil {
+		return nil, fmt.Errorf("write failed: %w", err)
+	}
+
+	resp := make([]byte, 1024)
+	n, err := s.conn.Read(resp)
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %w", err)
+	}
+
+	if s.v15 {
+		// Parsers expect the RMCP+ layout
+		v20 := v15ToV20(resp[:n])
+		if v20 == nil {
+			return nil, fmt.Errorf("malformed IPMI v1.5 response: %d bytes", n)
+		}
+		return v20, nil
+	}
+	return resp[:n], nil
+}
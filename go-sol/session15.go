@@ -0,0 +1,274 @@
+package sol
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IPMI v1.5 sessions, for BMCs that predate RMCP+. Get Channel
+// Authentication Capabilities selects them when the BMC reports no IPMI
+// v2.0 support: the session is activated with Get Session Challenge and
+// Activate Session, and every request carries an MD5 (or straight
+// password) auth code instead of RMCP+ integrity. There is no encryption.
+//
+// On the wire a v1.5 packet has no payload type, so responses are told
+// apart by their first byte: an IPMI response starts with our requester
+// address (0x81), a SOL packet with its sequence number (0-15). SOL packets
+// travel with auth type none and a 5-byte header, the 4 bytes of the v2.0
+// SOL header and a reserved byte.
+//
+// Received v1.5 packets are rewritten into the RMCP+ layout (see
+// v15ToV20), so the parsers and the read loop handle both kinds of session
+// the same way.
+
+// IPMI v1.5 authentication types
+const (
+	ipmiAuthMD2      = 0x01
+	ipmiAuthMD5      = 0x02
+	ipmiAuthPassword = 0x04
+)
+
+// Auth type support bits in Get Channel Authentication Capabilities
+const (
+	authCapMD5      = 1 << ipmiAuthMD5
+	authCapPassword = 1 << ipmiAuthPassword
+	authCapV20Data  = 0x80 // the extended (IPMI v2.0) capabilities byte is valid
+	authCapRMCPP    = 0x02 // extended capabilities: IPMI v2.0 sessions
+)
+
+// v15Requester is our requester address, the first byte of every IPMI
+// response addressed to us.
+const v15Requester = 0x81
+
+// v15SolReserved is the byte a v1.5 SOL header has after the v2.0 fields.
+const v15SolReserved = 1
+
+// ErrNoRMCPP is returned by Connect and Open when the BMC supports only
+// IPMI v1.5 sessions and Config.NoV15 forbids falling back to one.
+var ErrNoRMCPP = errors.New("BMC does not support IPMI v2.0 (RMCP+) sessions")
+
+// authCaps sends Get Channel Authentication Capabilities for channel
+// (0x0E is the current channel; 0x80 asks for IPMI v2.0 data) and returns
+// the response data.
+func (s *Session) authCaps(ctx context.Context, channel uint8) ([]byte, error) {
+	msg := buildIPMIMessage(0x20, netFnApp, 0, v15Requester, 0, 0, cmdGetChannelAuthCaps, []byte{channel, privAdmin})
+	resp, err := s.sendRecv(ctx, buildIPMI15Packet(0, 0, msg), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// Sent before the session kind is chosen, so always answered in v1.5
+	if resp = v15ToV20(resp); resp == nil {
+		return nil, errors.New("auth caps response malformed")
+	}
+	data, err := parseCommandResponse(resp, netFnApp, cmdGetChannelAuthCaps)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("auth caps response too short: %d bytes of data", len(data))
+	}
+	return data, nil
+}
+
+// v15AuthType picks the strongest authentication type the BMC offers that
+// we support.
+func (s *Session) v15AuthType() (uint8, error) {
+	switch {
+	case s.authTypes&authCapMD5 != 0:
+		return ipmiAuthMD5, nil
+	case s.authTypes&authCapPassword != 0:
+		return ipmiAuthPassword, nil
+	}
+	return 0, fmt.Errorf("no supported v1.5 authentication type (MD5 or password) offered: 0x%02X", s.authTypes)
+}
+
+// getSessionChallenge starts a v1.5 session: the BMC answers with a
+// temporary session ID and the challenge for Activate Session.
+func (s *Session) getSessionChallenge(ctx context.Context) ([]byte, error) {
+	authType, err := s.v15AuthType()
+	if err != nil {
+		return nil, err
+	}
+	s.v15Auth = authType
+
+	data := make([]byte, 17)
+	data[0] = authType
+	copy(data[1:], s.username) // null-padded to 16 bytes
+	msg := buildIPMIMessage(0x20, netFnApp, 0, v15Requester, 0, 0, cmdGetSessionChallenge, data)
+	resp, err := s.sendRecv(ctx, buildIPMI15Packet(0, 0, msg), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	out, err := parseCommandResponse(resp, netFnApp, cmdGetSessionChallenge)
+	var ce *CompletionError
+	if errors.As(err, &ce) {
+		switch ce.Code {
+		case 0x81:
+			return nil, fmt.Errorf("authentication failed: unknown user name %q (completion code 0x81)", s.username)
+		case 0x82:
+			return nil, errors.New("authentication failed: null user name not enabled (completion code 0x82)")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) < 20 {
+		return nil, fmt.Errorf("session challenge response too short: %d", len(out))
+	}
+	s.remoteSessionID = binary.LittleEndian.Uint32(out[0:4])
+	return out[4:20], nil
+}
+
+// activateSession completes the v1.5 handshake, authenticating with the
+// challenge. A BMC drops a request with the wrong auth code, so a bad
+// password shows as a timeout.
+func (s *Session) activateSession(ctx context.Context, challenge []byte) error {
+	outSeq, err := generateRandomBytes(4)
+	if err != nil {
+		return err
+	}
+	outSeq[0] |= 1 // the BMC's initial sequence number must not be 0
+
+	data := make([]byte, 0, 22)
+	data = append(data, s.v15Auth, privAdmin)
+	data = append(data, challenge...)
+	data = append(data, outSeq...)
+	msg := buildIPMIMessage(0x20, netFnApp, 0, v15Requester, 0, 0, cmdActivateSession, data)
+
+	// Sent in the temporary session, with sequence number 0
+	resp, err := s.sendRecv(ctx, s.buildV15Packet(msg, 0), 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("%w (wrong password?)", err)
+	}
+	out, err := parseCommandResponse(resp, netFnApp, cmdActivateSession)
+	if err != nil {
+		return err
+	}
+	if len(out) < 10 {
+		return fmt.Errorf("activate session response too short: %d", len(out))
+	}
+
+	// The BMC may change the auth type and the session ID for the rest of
+	// the session; our sequence numbers start where it says
+	s.v15Auth = out[0]
+	s.remoteSessionID = binary.LittleEndian.Uint32(out[1:5])
+	s.sessionSeq = binary.LittleEndian.Uint32(out[5:9]) - 1
+	return nil
+}
+
+// buildV15Packet wraps an IPMI message in a v1.5 session header with the
+// session's auth type and auth code.
+func (s *Session) buildV15Packet(msg []byte, seq uint32) []byte {
+	rmcp := rmcpHeader{
+		Version:  rmcpVersion,
+		Reserved: 0,
+		Sequence: rmcpSequence,
+		Class:    rmcpClassIPMI,
+	}
+	session := ipmi15SessionHeader{
+		AuthType:   s.v15Auth,
+		Sequence:   seq,
+		SessionID:  s.remoteSessionID,
+		PayloadLen: uint8(len(msg)),
+	}
+	head := session.pack()
+
+	packet := make([]byte, 0, 4+26+len(msg))
+	packet = append(packet, rmcp.pack()...)
+	packet = append(packet, head[:9]...)
+	if s.v15Auth != ipmiAuthNone {
+		packet = append(packet, v15AuthCode(s.v15Auth, s.password, s.remoteSessionID, seq, msg)...)
+	}
+	packet = append(packet, head[9])
+	return append(packet, msg...)
+}
+
+// buildV15SolPacket wraps a SOL payload (v2.0 header and data) in a v1.5
+// packet, adding the reserved header byte.
+func (s *Session) buildV15SolPacket(payload []byte) []byte {
+	rmcp := rmcpHeader{
+		Version:  rmcpVersion,
+		Reserved: 0,
+		Sequence: rmcpSequence,
+		Class:    rmcpClassIPMI,
+	}
+	session := ipmi15SessionHeader{
+		AuthType:   ipmiAuthNone, // SOL packets are not authenticated
+		Sequence:   0,
+		SessionID:  s.remoteSessionID,
+		PayloadLen: uint8(len(payload) + v15SolReserved),
+	}
+
+	packet := make([]byte, 0, 4+10+len(payload)+v15SolReserved)
+	packet = append(packet, rmcp.pack()...)
+	packet = append(packet, session.pack()...)
+	packet = append(packet, payload[:4]...)
+	packet = append(packet, 0)
+	return append(packet, payload[4:]...)
+}
+
+// v15AuthCode is the 16-byte auth code of a v1.5 message: the password
+// itself, or MD5(password + session ID + message + sequence + password).
+func v15AuthCode(authType uint8, password string, sessionID, seq uint32, msg []byte) []byte {
+	pw := make([]byte, 16)
+	copy(pw, password)
+	if authType != ipmiAuthMD5 {
+		return pw
+	}
+	var ids [8]byte
+	binary.LittleEndian.PutUint32(ids[0:4], sessionID)
+	binary.LittleEndian.PutUint32(ids[4:8], seq)
+	h := md5.New()
+	h.Write(pw)
+	h.Write(ids[0:4])
+	h.Write(msg)
+	h.Write(ids[4:8])
+	h.Write(pw)
+	return h.Sum(nil)
+}
+
+// v15ToV20 rewrites a received v1.5 packet into the RMCP+ layout: RMCP
+// header, 12-byte session header with the payload type and a 16-bit
+// length, then the payload, with a SOL header cut to its 4 v2.0 bytes. It
+// returns nil for a packet that isn't IPMI v1.5.
+func v15ToV20(p []byte) []byte {
+	if len(p) < 14 || p[0] != rmcpVersion || p[3] != rmcpClassIPMI || p[4] == ipmiAuthRMCPP {
+		return nil
+	}
+	lenAt := 13
+	if p[4] != ipmiAuthNone {
+		lenAt += 16 // auth code
+	}
+	if len(p) <= lenAt {
+		return nil
+	}
+	end := lenAt + 1 + int(p[lenAt])
+	if end > len(p) {
+		return nil
+	}
+	payload := p[lenAt+1 : end]
+
+	payloadType := uint8(payloadIPMI)
+	if len(payload) > 0 && payload[0] != v15Requester {
+		if len(payload) < 4+v15SolReserved {
+			return nil
+		}
+		payloadType = solPayloadType
+		payload = append(payload[:4:4], payload[4+v15SolReserved:]...)
+	}
+	session := ipmi20SessionHeader{
+		AuthType:    ipmiAuthRMCPP,
+		PayloadType: payloadType,
+		SessionID:   binary.LittleEndian.Uint32(p[9:13]),
+		Sequence:    binary.LittleEndian.Uint32(p[5:9]),
+		PayloadLen:  uint16(len(payload)),
+	}
+	out := make([]byte, 0, 16+len(payload))
+	out = append(out, p[:4]...)
+	out = append(out, session.pack()...)
+	return append(out, payload...)
+}
@@ -0,0 +1,47 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// SocketOptions shape the UDP socket to the BMC, for networks that only
+// let management traffic through from known source ports or with a given
+// DiffServ marking.
+type SocketOptions struct {
+	LocalAddr string // local "ip:port" to send from; empty, an empty ip or port 0 = any
+	DSCP      int    // DiffServ code point (0-63) marked on outgoing packets; 0 = unmarked
+}
+
+// Dial opens a UDP socket to addr bound and marked as o asks. It is the
+// default Config.Dial; a custom dialer can call it per attempt, e.g. to
+// walk a range of permitted source ports.
+func (o SocketOptions) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if o.DSCP < 0 || o.DSCP > 63 {
+		return nil, fmt.Errorf("DSCP %d out of range 0-63", o.DSCP)
+	}
+	d := net.Dialer{Timeout: 10 * time.Second}
+	if o.LocalAddr != "" {
+		local, err := net.ResolveUDPAddr(network, o.LocalAddr)
+		if err != nil {
+			return nil, fmt.Errorf("local address %q: %w", o.LocalAddr, err)
+		}
+		d.LocalAddr = local
+	}
+	if o.DSCP != 0 {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) { err = setDSCP(fd, network, o.DSCP) }); cerr != nil {
+				return cerr
+			}
+			if err != nil {
+				return fmt.Errorf("set DSCP %d: %w", o.DSCP, err)
+			}
+			return nil
+		}
+	}
+	return d.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd)
+
+package sol
+
+import (
+	"errors"
+	"runtime"
+)
+
+func setDSCP(fd uintptr, network string, dscp int) error {
+	return errors.New("not supported on " + runtime.GOOS)
+}
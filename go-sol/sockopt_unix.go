@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package sol
+
+import "syscall"
+
+// setDSCP marks the socket's packets with dscp: the traffic class on IPv6
+// sockets, the TOS byte on IPv4 ones.
+func setDSCP(fd uintptr, network string, dscp int) error {
+	if network == "udp6" {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+}
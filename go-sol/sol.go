@@ -0,0 +1,511 @@
+// Package sol implements IPMI Serial Over LAN (SOL) in pure Go.
+// This provides a bidirectional console connection to server BMCs
+// without requiring ipmitool or PTY allocation.
+package sol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session represents an active SOL connection to a BMC.
+type Session struct {
+	conn     net.Conn
+	host     string
+	port     int
+	username string
+	password string
+	kg       []byte
+
+	// RMCP+ session state
+	sessionID       uint32
+	remoteSessionID uint32
+	sessionSeq      uint32 // Session sequence number
+	authAlg         uint8
+	integrityAlg    uint8
+	cryptoAlg       uint8
+	cipherSuite     int    // Config.CipherSuite, proposed in Open Session
+	sik             []byte // Session Integrity Key
+	k1              []byte // Integrity key
+	k2              []byte // Encryption key
+
+	// IPMI v1.5 session state (see session15.go)
+	v15       bool  // no RMCP+: a v1.5 session
+	v15Auth   uint8 // v1.5 authentication type
+	authTypes uint8 // v1.5 authentication types the BMC offers
+	noV15     bool  // refuse v1.5 sessions
+
+	// BMC identity and handshake quirks (see quirks.go)
+	deviceID  *DeviceID
+	quirks    Quirks
+	quirksFor func(id *DeviceID) Quirks
+
+	// SOL state
+	solPayloadInstance uint8
+	solSeqNum          uint8
+	ackSeqNum          uint8
+	maxOutbound        uint16
+	retryCount         int
+	retryInterval      time.Duration
+	solConfig          *SOLConfig    // written before activation
+	serialConfig       *SerialConfig // applied before activation
+	autoEnable         bool          // EnableSOL and retry when activation finds SOL disabled
+	ackCh              chan solAck // ACKs/NACKs of our packets, readLoop -> writeLoop
+
+	// Traffic counters (see Stats)
+	statSent         atomic.Uint64
+	statAccepted     atomic.Uint64
+	statRetransmits  atomic.Uint64
+	statPartial      atomic.Uint64
+	statNacks        atomic.Uint64
+	statDropped      atomic.Uint64
+	statDroppedBytes atomic.Uint64
+	statPacketsOut   atomic.Uint64
+	statBytesOut     atomic.Uint64
+	statPacketsIn    atomic.Uint64
+	statBytesIn      atomic.Uint64
+	statDuplicates   atomic.Uint64
+	statAckTime      atomic.Int64 // nanoseconds, summed over statAckSamples
+	statAckSamples   atomic.Uint64
+	activeSince      time.Time // when SOL was activated
+
+	// Data channels
+	readCh  chan []byte
+	writeCh chan solWrite
+	errCh   chan error
+	done    chan struct{}
+
+	// IPMI command requests over the live session (see Command)
+	cmdMu   sync.Mutex
+	cmdSeq  uint8
+	cmdResp chan []byte
+	running atomic.Bool // readLoop owns the socket
+
+	// Inactivity tracking
+	lastRecvTime      atomic.Int64 // Unix nanoseconds
+	inactivityTimeout time.Duration
+	keepaliveInterval time.Duration // Get Device ID this often; 0 = from inactivityTimeout
+
+	// Debug logging
+	logf      func(format string, args ...interface{})
+	phaseHook func(name string, start time.Time, err error)
+
+	// Transport and time source (see Config.Dial, Config.Clock)
+	dial  func(ctx context.Context, network, addr string) (net.Conn, error)
+	clock Clock
+
+	mu        sync.Mutex
+	closed    bool
+	solActive bool // SOL payload activated (Connect rather than Open)
+}
+
+// Config holds SOL connection configuration.
+type Config struct {
+	Host               string
+	Port               int           // Default: 623
+	Username           string
+	Password           string
+	Kg                 []byte        // Optional BMC key for two-key RAKP; nil = one-key (Kg is the password)
+	Timeout            time.Duration // Default: 30s
+	InactivityTimeout  time.Duration // Default: 0 (disabled). Close session if no packets received for this duration.
+	KeepaliveInterval  time.Duration // Default: InactivityTimeout/3, at least 10s; none without either. How often a Get Device ID proves the session alive.
+	CipherSuite        int           // Default: 1 (RAKP-HMAC-SHA1, no integrity or encryption). 2 adds HMAC-SHA1-96 integrity; a BMC refusing it gets suite 1.
+	RetryCount         int           // Default: 7. Resends of an unacknowledged SOL packet before it is dropped; negative = none.
+	RetryInterval      time.Duration // Default: 500ms. How long to wait for an ACK, and to back off after a NACK.
+	SOL                *SOLConfig    // Optional: SOL configuration (bit rate etc.) written to the BMC before activation
+	Serial             *SerialConfig // Optional: serial MUX and console UART selection applied before activation
+	AutoEnable         bool          // Enable SOL on the BMC and for the user (EnableSOL) when activation finds it disabled, then retry
+	Logf               func(format string, args ...interface{}) // Optional debug logger
+	Phase              func(name string, start time.Time, err error) // Optional: called as each Connect phase ends (Phase* names)
+	Socket             SocketOptions // Optional: source address/port and DSCP marking of the UDP socket (default dialer only)
+	Dial               func(ctx context.Context, network, addr string) (net.Conn, error) // Optional: opens the UDP socket to the BMC; default Socket.Dial
+	Clock              Clock         // Optional: time source for deadlines, retransmits and keepalives; default the system clock
+	NoV15              bool          // Fail with ErrNoRMCPP rather than fall back to an IPMI v1.5 session (MD5, no encryption) when the BMC has no RMCP+
+	Quirks             func(id *DeviceID) Quirks // Optional: handshake quirks for the BMC from its Get Device ID (nil if it failed); default QuirksFor
+}
+
+// Connect phases reported to Config.Phase
+const (
+	PhaseAuthCaps        = "auth_caps"
+	PhaseOpenSession     = "open_session"
+	PhaseRAKP            = "rakp"
+	PhaseChallenge       = "session_challenge" // instead of open_session, for an IPMI v1.5 session
+	PhaseActivateSession = "activate_session"  // instead of rakp, for an IPMI v1.5 session
+	PhaseDeviceID        = "device_id"         // a failure doesn't fail Connect; the quirks are chosen without it
+	PhaseSetPrivilege    = "set_privilege"
+	PhaseSOLConfig       = "sol_config" // only with Config.SOL; a failure doesn't fail Connect
+	PhaseSerial          = "serial"     // only with Config.Serial; a failure doesn't fail Connect
+	PhaseActivate        = "activate"
+	PhaseSOLEnable       = "sol_enable" // only with Config.AutoEnable, after activation failed with ErrSOLDisabled
+)
+
+// New creates a new SOL session (not yet connected).
+func New(cfg Config) *Session {
+	if cfg.Port == 0 {
+		cfg.Port = 623
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.RetryCount == 0 {
+		cfg.RetryCount = defaultRetryCount
+	} else if cfg.RetryCount < 0 {
+		cfg.RetryCount = 0
+	}
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = defaultRetryInterval
+	}
+	logf := cfg.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {} // no-op
+	}
+	if cfg.Dial == nil {
+		cfg.Dial = cfg.Socket.Dial
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+	if cfg.Quirks == nil {
+		cfg.Quirks = QuirksFor
+	}
+	s := &Session{
+		host:              cfg.Host,
+		port:              cfg.Port,
+		username:          cfg.Username,
+		password:          cfg.Password,
+		kg:                cfg.Kg,
+		inactivityTimeout: cfg.InactivityTimeout,
+		keepaliveInterval: cfg.KeepaliveInterval,
+		cipherSuite:       cfg.CipherSuite,
+		retryCount:        cfg.RetryCount,
+		retryInterval:     cfg.RetryInterval,
+		solConfig:         cfg.SOL,
+		serialConfig:      cfg.Serial,
+		autoEnable:        cfg.AutoEnable,
+		noV15:             cfg.NoV15,
+		quirksFor:         cfg.Quirks,
+		ackCh:             make(chan solAck, 16),
+		logf:              logf,
+		phaseHook:         cfg.Phase,
+		dial:              cfg.Dial,
+		clock:             cfg.Clock,
+		readCh:            make(chan []byte, 1000),
+		writeCh:           make(chan solWrite, 100),
+		errCh:             make(chan error, 1),
+		done:              make(chan struct{}),
+		cmdResp:           make(chan []byte, 4),
+	}
+	s.lastRecvTime.Store(s.clock.Now().UnixNano())
+	return s
+}
+
+// Connect establishes the RMCP+ session and activates SOL.
+func (s *Session) Connect(ctx context.Context) error {
+	if err := s.open(ctx, true); err != nil {
+		return err
+	}
+
+	// Step 5: Deactivate any existing SOL session
+	s.deactivateStale(ctx)
+
+	// Step 5b: Write SOL configuration. A BMC that refuses it still gets
+	// a console, at whatever bit rate it has.
+	if s.solConfig != nil && !s.solConfig.IsZero() {
+		start := s.clock.Now()
+		err := s.SetSOLConfig(ctx, *s.solConfig)
+		s.phase(PhaseSOLConfig, start, err)
+		if err != nil {
+			s.logf("SOL configuration for %s not applied: %v", s.host, err)
+		}
+	}
+
+	// Step 5c: Route the console UART to SOL
+	if s.serialConfig != nil && !s.serialConfig.IsZero() {
+		start := s.clock.Now()
+		err := s.SetSerial(ctx, *s.serialConfig)
+		s.phase(PhaseSerial, start, err)
+		if err != nil {
+			s.logf("serial routing for %s not applied: %v", s.host, err)
+		}
+	}
+
+	// Step 5d: Raise privilege late, for BMCs that want it so
+	if s.quirks.Privilege == PrivilegeBeforeActivate {
+		start := s.clock.Now()
+		err := s.setSessionPrivilege(ctx)
+		s.phase(PhaseSetPrivilege, start, err)
+		if err != nil {
+			s.closeSession(ctx)
+			s.conn.Close()
+			return fmt.Errorf("set privilege: %w", err)
+		}
+	}
+
+	// Step 6: Activate SOL payload
+	start := s.clock.Now()
+	err := s.activateWithRetry(ctx)
+	s.phase(PhaseActivate, start, err)
+	if errors.Is(err, ErrSOLDisabled) && s.autoEnable {
+		// Step 6b: SOL is administratively disabled; enable it and retry
+		start = s.clock.Now()
+		enableErr := s.EnableSOL(ctx)
+		s.phase(PhaseSOLEnable, start, enableErr)
+		if enableErr != nil {
+			err = fmt.Errorf("%w; enabling SOL failed: %v", err, enableErr)
+		} else {
+			s.logf("SOL was disabled on %s, enabled it for %s", s.host, s.username)
+			start = s.clock.Now()
+			err = s.activateWithRetry(ctx)
+			s.phase(PhaseActivate, start, err)
+		}
+	}
+	if err != nil {
+		s.closeSession(ctx) // don't leave the RMCP+ session open on the BMC
+		s.conn.Close()
+		return fmt.Errorf("activate SOL: %w", err)
+	}
+	s.mu.Lock()
+	s.solActive = true
+	s.activeSince = s.clock.Now()
+	s.mu.Unlock()
+
+	s.logf("SOL activated: instance=%d maxOutbound=%d", s.solPayloadInstance, s.maxOutbound)
+
+	// Start read/write loops
+	s.lastRecvTime.Store(s.clock.Now().UnixNano())
+	s.running.Store(true)
+	go s.readLoop()
+	go s.writeLoop()
+	if s.inactivityTimeout > 0 || s.keepaliveInterval > 0 {
+		go s.keepaliveLoop()
+	}
+
+	return nil
+}
+
+// Open establishes the RMCP+ session without activating SOL, for IPMI
+// commands only (e.g. Get Chassis Status while the host is powered off,
+// when many BMCs refuse SOL). Read and Write are not available.
+func (s *Session) Open(ctx context.Context) error {
+	return s.open(ctx, false)
+}
+
+// open runs the session handshake, steps 1-4 of Connect. Without activate
+// (Open) the privilege is raised here whatever Quirks.Privilege says,
+// unless it is PrivilegeSkip.
+func (s *Session) open(ctx context.Context, activate bool) error {
+	addr := fmt.Sprintf("%s:%d", s.host, s.port)
+
+	conn, err := s.dial(ctx, "udp", addr)
+	if err != nil {
+		return fmt.Errorf("dial failed: %w", err)
+	}
+	s.conn = conn
+
+	// Step 1: Get Channel Authentication Capabilities
+	start := s.clock.Now()
+	err = s.getChannelAuthCaps(ctx)
+	s.phase(PhaseAuthCaps, start, err)
+	if err != nil {
+		s.conn.Close()
+		return fmt.Errorf("get auth caps: %w", err)
+	}
+
+	if s.v15 {
+		// Steps 2-3 for an IPMI v1.5 session
+		if err := s.open15(ctx); err != nil {
+			s.conn.Close()
+			return err
+		}
+	} else {
+		// Step 2: Open RMCP+ Session
+		start = s.clock.Now()
+		err = s.openSession(ctx, s.cipherSuite)
+		var status openSessionStatus
+		if errors.As(err, &status) && s.cipherSuite > 1 {
+			s.logf("BMC refused cipher suite %d (status 0x%02X), trying suite 1", s.cipherSuite, uint8(status))
+			err = s.openSession(ctx, 1)
+		}
+		s.phase(PhaseOpenSession, start, err)
+		if err != nil {
+			s.conn.Close()
+			return fmt.Errorf("open session: %w", err)
+		}
+
+		// Step 3: RAKP handshake (authentication)
+		start = s.clock.Now()
+		err = s.rakpHandshake(ctx)
+		s.phase(PhaseRAKP, start, err)
+		if err != nil {
+			s.conn.Close()
+			return fmt.Errorf("RAKP handshake: %w", err)
+		}
+	}
+
+	if s.v15 {
+		s.logf("session params: IPMI v1.5 remoteSessionID=0x%08x authType=%d", s.remoteSessionID, s.v15Auth)
+	} else {
+		s.logf("session params: sessionID=0x%08x remoteSessionID=0x%08x auth=%d integrity=%d crypto=%d",
+			s.sessionID, s.remoteSessionID, s.authAlg, s.integrityAlg, s.cryptoAlg)
+	}
+	s.logf("local addr: %s", s.conn.LocalAddr().String())
+
+	// Step 3b: Identify the BMC and choose its quirks
+	s.detectQuirks(ctx)
+
+	// Step 4: Set Session Privilege Level to Admin
+	switch s.quirks.Privilege {
+	case PrivilegeSkip:
+		return nil
+	case PrivilegeBeforeActivate:
+		if activate {
+			return nil // Connect raises it before activation
+		}
+	}
+	start = s.clock.Now()
+	err = s.setSessionPrivilege(ctx)
+	s.phase(PhaseSetPrivilege, start, err)
+	if err != nil {
+		s.closeSession(ctx)
+		s.conn.Close()
+		return fmt.Errorf("set privilege: %w", err)
+	}
+	return nil
+}
+
+// open15 runs the IPMI v1.5 handshake: Get Session Challenge, then
+// Activate Session.
+func (s *Session) open15(ctx context.Context) error {
+	start := s.clock.Now()
+	challenge, err := s.getSessionChallenge(ctx)
+	s.phase(PhaseChallenge, start, err)
+	if err != nil {
+		return fmt.Errorf("session challenge: %w", err)
+	}
+
+	start = s.clock.Now()
+	err = s.activateSession(ctx, challenge)
+	s.phase(PhaseActivateSession, start, err)
+	if err != nil {
+		return fmt.Errorf("activate session: %w", err)
+	}
+	return nil
+}
+
+// DeviceID returns the BMC's Get Device ID response, read as Connect or
+// Open authenticated; nil if the BMC didn't answer.
+func (s *Session) DeviceID() *DeviceID {
+	return s.deviceID
+}
+
+// Quirks returns the handshake quirks Connect or Open chose for the BMC.
+func (s *Session) Quirks() Quirks {
+	return s.quirks
+}
+
+// V15 reports whether the session is IPMI v1.5 rather than RMCP+, known
+// once Connect or Open has got the BMC's authentication capabilities.
+func (s *Session) V15() bool {
+	return s.v15
+}
+
+// CipherSuite returns the cipher suite of the RMCP+ session once Connect or
+// Open has opened it: 1, or 2 with integrity. 0 for an IPMI v1.5 session.
+func (s *Session) CipherSuite() int {
+	switch {
+	case s.v15:
+		return 0
+	case s.integrityAlg == integrityHmacSHA1:
+		return 2
+	}
+	return 1
+}
+
+// phase reports the end of a Connect phase to Config.Phase.
+func (s *Session) phase(name string, start time.Time, err error) {
+	if s.phaseHook != nil {
+		s.phaseHook(name, start, err)
+	}
+}
+
+// Read returns a channel that receives console output data.
+func (s *Session) Read() <-chan []byte {
+	return s.readCh
+}
+
+// Write sends data to the console.
+func (s *Session) Write(data []byte) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("session closed")
+	}
+	s.mu.Unlock()
+
+	return s.queueWrite(solWrite{data: data})
+}
+
+// SendBreak generates a serial break on the host's console port. It is
+// queued behind any pending Write data, so a break followed by a Write of a
+// single key is a Linux magic SysRq sequence.
+func (s *Session) SendBreak() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("session closed")
+	}
+	s.mu.Unlock()
+
+	return s.queueWrite(solWrite{op: solOpBreak})
+}
+
+func (s *Session) queueWrite(w solWrite) error {
+	select {
+	case s.writeCh <- w:
+		return nil
+	case <-s.done:
+		return errors.New("session closed")
+	}
+}
+
+// Close terminates the SOL session.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	solActive := s.solActive
+	s.mu.Unlock()
+
+	close(s.done)
+
+	// Deactivate SOL payload
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if solActive {
+		s.deactivateSOL(ctx)
+	}
+
+	// Close session
+	s.closeSession(ctx)
+
+	return s.conn.Close()
+}
+
+// Err returns any error that caused the session to fail.
+// LastRecvTime returns the time of the last packet received from the BMC,
+// including keepalive responses. This is useful for health monitoring.
+func (s *Session) LastRecvTime() time.Time {
+	return time.Unix(0, s.lastRecvTime.Load())
+}
+
+func (s *Session) Err() <-chan error {
+	return s.errCh
+}
@@ -0,0 +1,277 @@
+package sol_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sol "github.com/gwest/go-sol"
+	"github.com/gwest/go-sol/soltest"
+)
+
+// harness is a Session connected over a Pipe to a fake BMC, both on a
+// manual clock.
+type harness struct {
+	clock   *soltest.Clock
+	bmc     *soltest.BMC
+	session *sol.Session
+}
+
+func newHarness(t *testing.T, bmcCfg soltest.Config, cfg sol.Config) *harness {
+	t.Helper()
+	clock := soltest.NewClock(time.Unix(1700000000, 0))
+	conn, pc := soltest.Pipe(clock)
+	bmcCfg.Username, bmcCfg.Password, bmcCfg.Clock = "ADMIN", "secret", clock
+	bmc := soltest.NewBMC(bmcCfg)
+	go bmc.Serve(pc)
+	t.Cleanup(func() { bmc.Close() })
+
+	cfg.Host, cfg.Dial, cfg.Clock = "bmc", soltest.Dialer(conn), clock
+	if cfg.Username == "" {
+		cfg.Username, cfg.Password = "ADMIN", "secret"
+	}
+	return &harness{clock: clock, bmc: bmc, session: sol.New(cfg)}
+}
+
+func (h *harness) connect(t *testing.T) {
+	t.Helper()
+	if err := h.session.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { h.close(t) })
+}
+
+// close closes the session, advancing the clock through the waits for
+// deactivation and close session replies.
+func (h *harness) close(t *testing.T) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		h.session.Close()
+		close(done)
+	}()
+	h.until(t, time.Second, func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+// until advances the clock a step at a time until cond holds, failing the
+// test if it doesn't within a minute of clock time.
+func (h *harness) until(t *testing.T, step time.Duration, cond func() bool) {
+	t.Helper()
+	for elapsed := time.Duration(0); elapsed < time.Minute; elapsed += step {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		h.clock.Advance(step)
+	}
+	if !cond() {
+		t.Fatal("condition not met within a minute of clock time")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		suite       int
+		noIntegrity bool
+		want        int
+	}{
+		{"suite 1", 0, false, 1},
+		{"suite 2", 2, false, 2},
+		{"suite 2 refused", 2, true, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, soltest.Config{NoIntegrity: tt.noIntegrity}, sol.Config{CipherSuite: tt.suite})
+			h.connect(t)
+			if got := h.session.CipherSuite(); got != tt.want {
+				t.Errorf("CipherSuite() = %d, want %d", got, tt.want)
+			}
+			if st := h.bmc.Stats(); st.Sessions != 1 || st.Activations != 1 {
+				t.Errorf("BMC saw %d sessions, %d activations; want 1, 1", st.Sessions, st.Activations)
+			}
+
+			h.bmc.Console([]byte("login: "))
+			select {
+			case got := <-h.session.Read():
+				if string(got) != "login: " {
+					t.Errorf("Read() = %q, want %q", got, "login: ")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no console output")
+			}
+
+			if err := h.session.Write([]byte("root\n")); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			h.until(t, 100*time.Millisecond, func() bool { return string(h.bmc.Input()) == "root\n" })
+		})
+	}
+}
+
+func TestConnectWrongPassword(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{Username: "ADMIN", Password: "wrong"})
+	if err := h.session.Connect(context.Background()); err == nil {
+		h.session.Close()
+		t.Fatal("Connect succeeded with the wrong password")
+	}
+	if st := h.bmc.Stats(); st.AuthFailures == 0 {
+		t.Error("BMC counted no auth failure")
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{Timeout: 10 * time.Second})
+	h.bmc.SetFaults(soltest.Faults{Silent: true})
+
+	errc := make(chan error, 1)
+	go func() { errc <- h.session.Connect(context.Background()) }()
+	var err error
+	h.until(t, time.Second, func() bool {
+		select {
+		case err = <-errc:
+			return true
+		default:
+			return false
+		}
+	})
+	if err == nil {
+		h.close(t)
+		t.Fatal("Connect to a silent BMC succeeded")
+	}
+}
+
+func TestInactivityTimeout(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{InactivityTimeout: 30 * time.Second})
+	h.connect(t)
+	h.bmc.SetFaults(soltest.Faults{Silent: true})
+
+	var err error
+	h.until(t, time.Second, func() bool {
+		select {
+		case err = <-h.session.Err():
+			return true
+		default:
+			return false
+		}
+	})
+	if err == nil {
+		t.Fatal("session reported a nil error")
+	}
+}
+
+func TestInputNackRetransmit(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{})
+	h.connect(t)
+	h.bmc.SetFaults(soltest.Faults{NackInput: 2})
+
+	if err := h.session.Write([]byte("reboot\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	h.until(t, 100*time.Millisecond, func() bool { return string(h.bmc.Input()) == "reboot\n" })
+
+	st := h.session.Stats()
+	if st.Nacks != 2 || st.Retransmits != 2 || st.Dropped != 0 {
+		t.Errorf("Stats: %d NACKs, %d retransmits, %d dropped; want 2, 2, 0", st.Nacks, st.Retransmits, st.Dropped)
+	}
+	if got := h.bmc.Stats().Nacks; got != 2 {
+		t.Errorf("BMC sent %d NACKs, want 2", got)
+	}
+}
+
+func TestInputLostAck(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{})
+	h.connect(t)
+	h.bmc.SetFaults(soltest.Faults{DropAcks: 1})
+
+	if err := h.session.Write([]byte("ls\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	h.until(t, 100*time.Millisecond, func() bool { return h.session.Stats().Accepted == 3 })
+
+	if got := string(h.bmc.Input()); got != "ls\n" {
+		t.Errorf("BMC input %q, want %q once", got, "ls\n")
+	}
+	if st := h.bmc.Stats(); st.Duplicates != 1 {
+		t.Errorf("BMC saw %d duplicates, want 1", st.Duplicates)
+	}
+}
+
+func TestInputPartialAccept(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{})
+	h.connect(t)
+	h.bmc.SetFaults(soltest.Faults{AcceptLimit: 4})
+
+	in := []byte("echo partial accept\n")
+	if err := h.session.Write(in); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	h.until(t, 100*time.Millisecond, func() bool { return bytes.Equal(h.bmc.Input(), in) })
+
+	st := h.session.Stats()
+	if want := uint64((len(in)+3)/4 - 1); st.PartialAccepts != want {
+		t.Errorf("Stats.PartialAccepts = %d, want %d", st.PartialAccepts, want)
+	}
+	if st.Accepted != uint64(len(in)) || st.Dropped != 0 {
+		t.Errorf("Stats: %d accepted, %d dropped; want %d, 0", st.Accepted, st.Dropped, len(in))
+	}
+}
+
+func TestInputDropped(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{RetryCount: 2})
+	h.connect(t)
+	h.bmc.SetFaults(soltest.Faults{NackInput: 10})
+
+	if err := h.session.Write([]byte("x")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	h.until(t, 100*time.Millisecond, func() bool { return h.session.Stats().Dropped == 1 })
+
+	if st := h.session.Stats(); st.DroppedBytes != 1 || st.Nacks != 3 {
+		t.Errorf("Stats: %d bytes dropped, %d NACKs; want 1, 3", st.DroppedBytes, st.Nacks)
+	}
+	if got := h.bmc.Input(); len(got) != 0 {
+		t.Errorf("BMC input %q, want none", got)
+	}
+}
+
+func TestOutputRetransmit(t *testing.T) {
+	h := newHarness(t, soltest.Config{}, sol.Config{})
+	h.connect(t)
+	h.bmc.SetFaults(soltest.Faults{DropOutput: 1})
+
+	h.bmc.Console([]byte("lost once"))
+	var got []byte
+	h.until(t, 100*time.Millisecond, func() bool {
+		select {
+		case data := <-h.session.Read():
+			got = append(got, data...)
+		default:
+		}
+		return string(got) == "lost once"
+	})
+	if st := h.bmc.Stats(); st.Resends != 1 {
+		t.Errorf("BMC resent %d packets, want 1", st.Resends)
+	}
+}
+
+func TestNoV15(t *testing.T) {
+	h := newHarness(t, soltest.Config{V15: true}, sol.Config{NoV15: true})
+	err := h.session.Connect(context.Background())
+	if !errors.Is(err, sol.ErrNoRMCPP) {
+		if err == nil {
+			h.session.Close()
+		}
+		t.Fatalf("Connect: %v, want ErrNoRMCPP", err)
+	}
+}
@@ -0,0 +1,309 @@
+package sol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SOL configuration commands (netFn Transport)
+const (
+	cmdSetSOLConfig = 0x21
+	cmdGetSOLConfig = 0x22
+)
+
+// User commands (netFn App)
+const (
+	cmdGetUserAccess        = 0x44
+	cmdGetUserName          = 0x46
+	cmdSetUserPayloadAccess = 0x4C
+)
+
+// ErrSOLDisabled is wrapped by the Connect error when Activate Payload
+// fails with completion code 0x81: SOL is disabled on the BMC or for the
+// user. EnableSOL (or Config.AutoEnable) fixes it.
+var ErrSOLDisabled = errors.New("payload type disabled")
+
+// SOL configuration parameters
+const (
+	solParamSetInProgress = 0x00
+	solParamEnable        = 0x01
+	solParamAuth          = 0x02 // force encryption/authentication, privilege level
+	solParamAccumulate    = 0x03 // character accumulate interval, send threshold
+	solParamRetry         = 0x04 // retry count, retry interval
+	solParamBitRate       = 0x05 // non-volatile bit rate
+	solParamVolatileRate  = 0x06 // volatile bit rate
+
+	// Set In Progress values
+	solSetComplete   = 0x00
+	solSetInProgress = 0x01
+	solCommitWrite   = 0x02
+
+	// currentChannel addresses the channel a request arrives on
+	currentChannel = 0x0E
+)
+
+// Parameter units and limits
+const (
+	solAccumulateUnit    = 5 * time.Millisecond
+	solRetryIntervalUnit = 10 * time.Millisecond
+	solMaxRetryCount     = 7
+)
+
+// solBitRates maps baud rates to bit rate parameter values.
+var solBitRates = map[int]uint8{
+	9600:   0x06,
+	19200:  0x07,
+	38400:  0x08,
+	57600:  0x09,
+	115200: 0x0A,
+}
+
+// SOLConfig holds SOL configuration parameters for the BMC, written before
+// activation when set in Config.SOL. Zero fields leave the BMC's setting
+// unchanged.
+type SOLConfig struct {
+	BitRate            int           // non-volatile bit rate, baud: 9600, 19200, 38400, 57600 or 115200
+	VolatileBitRate    int           // bit rate until the BMC resets, as BitRate
+	RetryCount         int           // BMC resends of an unacknowledged packet, 1-7; negative = none
+	RetryInterval      time.Duration // between BMC resends, 10ms-2.55s in 10ms steps
+	AccumulateInterval time.Duration // how long the BMC gathers characters before sending, 5ms-1.275s in 5ms steps
+	SendThreshold      int           // characters that make the BMC send at once, 1-255
+}
+
+// IsZero reports whether c changes nothing.
+func (c SOLConfig) IsZero() bool {
+	return c == SOLConfig{}
+}
+
+// Validate checks every set field is in range.
+func (c SOLConfig) Validate() error {
+	for _, rate := range []int{c.BitRate, c.VolatileBitRate} {
+		if _, ok := solBitRates[rate]; rate != 0 && !ok {
+			return fmt.Errorf("bit rate %d: want 9600, 19200, 38400, 57600 or 115200", rate)
+		}
+	}
+	if c.RetryCount > solMaxRetryCount {
+		return fmt.Errorf("retry count %d: at most %d", c.RetryCount, solMaxRetryCount)
+	}
+	if c.RetryInterval < 0 || c.RetryInterval > 0xFF*solRetryIntervalUnit {
+		return fmt.Errorf("retry interval %v: want 10ms to 2.55s", c.RetryInterval)
+	}
+	if c.AccumulateInterval < 0 || c.AccumulateInterval > 0xFF*solAccumulateUnit {
+		return fmt.Errorf("accumulate interval %v: want 5ms to 1.275s", c.AccumulateInterval)
+	}
+	if c.SendThreshold < 0 || c.SendThreshold > 0xFF {
+		return fmt.Errorf("send threshold %d: want 1 to 255", c.SendThreshold)
+	}
+	return nil
+}
+
+// GetSOLConfig reads the BMC's SOL configuration. A bit rate of 0 means the
+// BMC uses its serial port setting; a retry count of none reads as -1.
+func (s *Session) GetSOLConfig(ctx context.Context) (*SOLConfig, error) {
+	var c SOLConfig
+	data, err := s.getSOLParam(ctx, solParamBitRate, 1)
+	if err != nil {
+		return nil, err
+	}
+	c.BitRate = baudOf(data[0])
+	if data, err = s.getSOLParam(ctx, solParamVolatileRate, 1); err != nil {
+		return nil, err
+	}
+	c.VolatileBitRate = baudOf(data[0])
+	if data, err = s.getSOLParam(ctx, solParamRetry, 2); err != nil {
+		return nil, err
+	}
+	c.RetryCount = int(data[0] & 0x07)
+	if c.RetryCount == 0 {
+		c.RetryCount = -1
+	}
+	c.RetryInterval = time.Duration(data[1]) * solRetryIntervalUnit
+	if data, err = s.getSOLParam(ctx, solParamAccumulate, 2); err != nil {
+		return nil, err
+	}
+	c.AccumulateInterval = time.Duration(data[0]) * solAccumulateUnit
+	c.SendThreshold = int(data[1])
+	return &c, nil
+}
+
+// SOLAccess is the SOL Enable and SOL Authentication parameters: whether
+// and by whom SOL may be activated.
+type SOLAccess struct {
+	Enabled         bool
+	ForceEncryption bool  // SOL must run on an encrypted session
+	ForceAuth       bool  // SOL must run on an authenticated session
+	Privilege       uint8 // minimum session privilege: 1 callback, 2 user, 3 operator, 4 admin, 5 OEM
+}
+
+// GetSOLAccess reads the SOL Enable and SOL Authentication parameters.
+// Activate Payload fails with completion code 0x81 while SOL is disabled.
+func (s *Session) GetSOLAccess(ctx context.Context) (*SOLAccess, error) {
+	enable, err := s.getSOLParam(ctx, solParamEnable, 1)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := s.getSOLParam(ctx, solParamAuth, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &SOLAccess{
+		Enabled:         enable[0]&0x01 != 0,
+		ForceEncryption: auth[0]&0x80 != 0,
+		ForceAuth:       auth[0]&0x40 != 0,
+		Privilege:       auth[0] & 0x0F,
+	}, nil
+}
+
+// EnableSOL turns on the SOL Enable parameter and enables the SOL payload
+// for the session's user (Set User Payload Access), the two settings that
+// make Activate Payload fail with ErrSOLDisabled. It needs an admin
+// session.
+func (s *Session) EnableSOL(ctx context.Context) error {
+	if err := s.setSOLParam(ctx, solParamEnable, 0x01); err != nil {
+		return fmt.Errorf("set SOL enable: %w", err)
+	}
+	id, err := s.userID(ctx)
+	if err != nil {
+		return err
+	}
+	// Channel, user ID with operation 00b (enable the selected payloads),
+	// then standard payload bits 1-7 (SOL is payload 1), 8-15 and OEM
+	// payload bits: only set bits are changed
+	req := []byte{currentChannel, id & 0x3F, 1 << PayloadSOL, 0x00, 0x00, 0x00}
+	if _, err := s.Command(ctx, netFnApp, cmdSetUserPayloadAccess, req); err != nil {
+		return fmt.Errorf("set user payload access for user %d: %w", id, err)
+	}
+	return nil
+}
+
+// userID finds the session user's ID by name among the channel's users.
+func (s *Session) userID(ctx context.Context) (uint8, error) {
+	// Get User Access for user 1 reports how many user IDs there are
+	access, err := s.Command(ctx, netFnApp, cmdGetUserAccess, []byte{currentChannel, 0x01})
+	if err != nil {
+		return 0, fmt.Errorf("get user access: %w", err)
+	}
+	if len(access) < 1 {
+		return 0, fmt.Errorf("get user access response too short: %d", len(access))
+	}
+	maxID := access[0] & 0x3F
+	for id := uint8(1); id <= maxID; id++ {
+		name, err := s.Command(ctx, netFnApp, cmdGetUserName, []byte{id})
+		if err != nil {
+			continue // an unset or reserved slot
+		}
+		if string(bytes.TrimRight(name, "\x00")) == s.username {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("user %q not found among %d BMC users", s.username, maxID)
+}
+
+// SetSOLConfig writes the set fields of c to the BMC. Parameters that pair
+// two values (retry count and interval, accumulate interval and send
+// threshold) are read first when only one of the pair is set. Durations
+// are rounded up to the BMC's units. Call it before SOL is activated: many
+// BMCs only apply a new bit rate on activation.
+func (s *Session) SetSOLConfig(ctx context.Context, c SOLConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.IsZero() {
+		return nil
+	}
+	retrySet := c.RetryCount != 0 || c.RetryInterval != 0
+	accumulateSet := c.AccumulateInterval != 0 || c.SendThreshold != 0
+	if (retrySet && (c.RetryCount == 0 || c.RetryInterval == 0)) ||
+		(accumulateSet && (c.AccumulateInterval == 0 || c.SendThreshold == 0)) {
+		cur, err := s.GetSOLConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("read SOL configuration: %w", err)
+		}
+		if c.RetryCount == 0 {
+			c.RetryCount = cur.RetryCount
+		}
+		if c.RetryInterval == 0 {
+			c.RetryInterval = cur.RetryInterval
+		}
+		if c.AccumulateInterval == 0 {
+			c.AccumulateInterval = cur.AccumulateInterval
+		}
+		if c.SendThreshold == 0 {
+			c.SendThreshold = cur.SendThreshold
+		}
+	}
+
+	// Set In Progress is optional; a BMC without it just applies each
+	// parameter as it is written
+	locked := s.setSOLParam(ctx, solParamSetInProgress, solSetInProgress) == nil
+	if locked {
+		defer s.setSOLParam(ctx, solParamSetInProgress, solSetComplete)
+	}
+
+	if c.BitRate != 0 {
+		if err := s.setSOLParam(ctx, solParamBitRate, solBitRates[c.BitRate]); err != nil {
+			return fmt.Errorf("set bit rate: %w", err)
+		}
+	}
+	if c.VolatileBitRate != 0 {
+		if err := s.setSOLParam(ctx, solParamVolatileRate, solBitRates[c.VolatileBitRate]); err != nil {
+			return fmt.Errorf("set volatile bit rate: %w", err)
+		}
+	}
+	if retrySet {
+		count := max(c.RetryCount, 0)
+		if err := s.setSOLParam(ctx, solParamRetry, uint8(count), ticks(c.RetryInterval, solRetryIntervalUnit, 0)); err != nil {
+			return fmt.Errorf("set retry: %w", err)
+		}
+	}
+	if accumulateSet {
+		// The accumulate interval is 1-based: 0 is reserved
+		if err := s.setSOLParam(ctx, solParamAccumulate, ticks(c.AccumulateInterval, solAccumulateUnit, 1), uint8(max(c.SendThreshold, 1))); err != nil {
+			return fmt.Errorf("set character accumulate: %w", err)
+		}
+	}
+
+	if locked {
+		s.setSOLParam(ctx, solParamSetInProgress, solCommitWrite)
+	}
+	return nil
+}
+
+func (s *Session) setSOLParam(ctx context.Context, param uint8, data ...uint8) error {
+	req := append([]byte{currentChannel, param}, data...)
+	_, err := s.Command(ctx, netFnTransport, cmdSetSOLConfig, req)
+	return err
+}
+
+// getSOLParam reads a parameter, checking it has at least n data bytes.
+func (s *Session) getSOLParam(ctx context.Context, param uint8, n int) ([]byte, error) {
+	// Channel, parameter, set selector, block selector
+	data, err := s.Command(ctx, netFnTransport, cmdGetSOLConfig, []byte{currentChannel, param, 0x00, 0x00})
+	if err != nil {
+		return nil, err
+	}
+	// The parameter revision comes first
+	if len(data) < 1+n {
+		return nil, fmt.Errorf("SOL parameter %d response too short: %d", param, len(data))
+	}
+	return data[1:], nil
+}
+
+// ticks converts d to a count of unit, rounding up, between lo and 255.
+func ticks(d, unit time.Duration, lo int) uint8 {
+	n := int((d + unit - 1) / unit)
+	return uint8(min(max(n, lo), 0xFF))
+}
+
+// baudOf decodes a bit rate parameter; 0 means the serial port setting.
+func baudOf(v uint8) int {
+	for baud, code := range solBitRates {
+		if code == v&0x0F {
+			return baud
+		}
+	}
+	return 0
+}
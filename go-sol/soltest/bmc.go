@@ -0,0 +1,964 @@
+package soltest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	sol "github.com/gwest/go-sol"
+)
+
+// Wire constants, from the IPMI v2.0 spec as used by go-sol
+const (
+	rmcpVersion   = 0x06
+	rmcpClassASF  = 0x06
+	rmcpClassIPMI = 0x07
+
+	authNone     = 0x00
+	authMD5      = 0x02
+	authPassword = 0x04
+	authRMCPP    = 0x06
+
+	payloadIPMI     = 0x00
+	payloadSOL      = 0x01
+	payloadOpenReq  = 0x10
+	payloadOpenResp = 0x11
+	payloadRAKP1    = 0x12
+	payloadRAKP2    = 0x13
+	payloadRAKP3    = 0x14
+	payloadRAKP4    = 0x15
+
+	netFnChassis = 0x00
+	netFnApp     = 0x06
+	netFnStorage = 0x0A
+
+	solOpNack  = 0x40
+	solOpBreak = 0x10
+
+	// RMCP+ status codes
+	statusInvalidSession = 0x02
+	statusUnauthorized   = 0x0D
+	statusInvalidICV     = 0x0F
+	statusNoCipherSuite  = 0x11
+
+	// Completion codes
+	ccPayloadActive   = 0x80
+	ccPayloadInactive = 0x80
+	ccInvalidCommand  = 0xC1
+	ccInvalidData     = 0xCC
+	ccUnknownUser     = 0x81 // Get Session Challenge
+
+	// maxOutput bounds console output queued while no SOL session is
+	// active, as a BMC's own buffer would; the oldest is dropped.
+	maxOutput = 1 << 20
+)
+
+// bmcGUID is the system GUID the BMC reports in RAKP2.
+var bmcGUID = []byte("soltest-fake-bmc")
+
+// Config configures a fake BMC. It supports cipher suites 1 and 2
+// (RAKP-HMAC-SHA1 without encryption, with HMAC-SHA1-96 integrity for 2),
+// which are what a Session proposes, and IPMI v1.5 sessions with MD5 or
+// straight password authentication.
+type Config struct {
+	Username      string
+	Password      string
+	Kg            []byte                         // BMC key for two-key RAKP; nil = one-key (the password)
+	MaxPayload    int                            // SOL packet size reported by Activate Payload; default 200
+	Clock         sol.Clock                      // time source for console output retransmits; nil = real time
+	RetryInterval time.Duration                  // resend unacknowledged console output after this; default 500ms
+	RetryCount    int                            // resends before console output is dropped; default 7
+	Echo          bool                           // send console input back as output, as a shell at a prompt does
+	OnPower       func(action sol.ChassisAction) // called (in its own goroutine) after a Chassis Control request
+	V15           bool                           // IPMI v1.5 only, as a legacy board: no RMCP+, and the v2.0 bit of Get Channel Authentication Capabilities refused
+	Manufacturer  uint32                         // IANA enterprise number reported by Get Device ID, e.g. sol.ManufacturerDell; default 0
+	Product       uint16                         // product ID reported by Get Device ID
+	NoIntegrity   bool                           // refuse cipher suite 2, as a BMC offering suite 1 only
+}
+
+// Faults make the BMC misbehave the ways real ones do. SetFaults may change
+// them at any time; the counters count down as they take effect.
+type Faults struct {
+	Silent       bool // ignore every packet, as a hung BMC or a dead network
+	NackInput    int  // NACK the next this-many console input packets
+	AcceptLimit  int  // accept at most this many characters of each input packet; 0 = all
+	DropAcks     int  // take the next this-many input packets without ACKing them, so they are resent
+	DropOutput   int  // lose the next this-many console output packets on the wire, so they are resent
+	ActivateCode byte // completion code for Activate Payload instead of success, e.g. 0x81 (SOL disabled)
+	ActivateBusy int  // refuse the next this-many activations as already active (0x80), as a BMC slow to free a dropped session's payload
+}
+
+// Stats counts what the BMC has seen and sent.
+type Stats struct {
+	Sessions      int // RMCP+ and v1.5 sessions authenticated
+	AuthFailures  int // handshakes refused for an unknown user or wrong password
+	Activations   int // SOL payload activations
+	Commands      int // IPMI requests answered in session
+	InputPackets  int // console input packets, counting resends
+	Duplicates    int // input packets resent after a lost ACK
+	Nacks         int // input packets NACKed
+	Breaks        int // serial breaks requested
+	OutputPackets int // console output packets sent, counting resends
+	Resends       int // console output packets resent for want of an ACK
+	DroppedOutput int // console output packets given up after RetryCount resends
+}
+
+// BMC is an in-process BMC speaking enough RMCP+, RAKP and SOL for a
+// Session to connect, stream console output and send input: an ASF
+// presence ping, Get Channel Authentication Capabilities, the RMCP+ open
+// session and RAKP 1-4 handshake or the IPMI v1.5 session challenge and
+// activation, session privilege, payload activation,
+// Get Device ID keepalives, chassis status and control, an empty SEL, and
+// SOL with ACKs, NACKs and retransmission. Serve it on one end of a Pipe,
+// or on a UDP socket to stand in for a real BMC.
+type BMC struct {
+	cfg   Config
+	clock sol.Clock
+
+	mu       sync.Mutex
+	pc       net.PacketConn
+	sessions map[uint32]*bmcSession // by BMC session ID
+	nextID   uint32
+	sol      *bmcSession // session holding the SOL payload
+	power    bool
+	faults   Faults
+	stats    Stats
+	input    []byte
+	output   []byte // console output waiting to be sent
+	outSeq   uint8
+
+	acks      chan solAck
+	kick      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type bmcSession struct {
+	id        uint32 // ours
+	consoleID uint32 // the Session's
+	addr      net.Addr
+	active    bool // RAKP complete
+	rmRand    []byte
+	mcRand    []byte
+	role      byte
+	username  string
+	seq       uint32 // outbound session sequence
+	integrity bool   // cipher suite 2: packets carry an HMAC-SHA1-96 auth code
+	k1        []byte // integrity key
+
+	v15       bool   // IPMI v1.5 session
+	authType  byte   // v1.5 authentication type
+	challenge []byte // v1.5 challenge for Activate Session
+
+	lastInput    uint8 // sequence of the last input packet taken
+	lastAccepted int
+}
+
+type solAck struct {
+	seq      uint8
+	accepted int
+	nack     bool
+}
+
+// NewBMC returns a powered-on BMC; call Serve to start it.
+func NewBMC(cfg Config) *BMC {
+	if cfg.MaxPayload <= 4 || cfg.MaxPayload > 255 {
+		cfg.MaxPayload = 200
+	}
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = 500 * time.Millisecond
+	}
+	if cfg.RetryCount == 0 {
+		cfg.RetryCount = 7
+	}
+	clock := cfg.Clock
+	if clock == nil {
+		clock = wallClock{}
+	}
+	return &BMC{
+		cfg:      cfg,
+		clock:    clock,
+		sessions: make(map[uint32]*bmcSession),
+		nextID:   0x0200_0000,
+		power:    true,
+		acks:     make(chan solAck, 16),
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Serve answers packets arriving on pc until Close, when it returns nil,
+// or until pc fails.
+func (b *BMC) Serve(pc net.PacketConn) error {
+	b.mu.Lock()
+	b.pc = pc
+	b.mu.Unlock()
+	go b.sendLoop()
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-b.done:
+				return nil
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		b.handle(buf[:n], addr)
+	}
+}
+
+// Close stops Serve and closes its PacketConn.
+func (b *BMC) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	b.mu.Lock()
+	pc := b.pc
+	b.mu.Unlock()
+	if pc != nil {
+		return pc.Close()
+	}
+	return nil
+}
+
+// Console queues data as serial console output from the host. It is sent
+// while a session holds the SOL payload, and held until one does.
+func (b *BMC) Console(data []byte) {
+	b.mu.Lock()
+	b.output = append(b.output, data...)
+	if over := len(b.output) - maxOutput; over > 0 {
+		b.output = b.output[over:]
+	}
+	b.mu.Unlock()
+	b.wake()
+}
+
+// Pending returns how many bytes of console output are still to be sent.
+func (b *BMC) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.output)
+}
+
+// Input returns the console input accepted so far.
+func (b *BMC) Input() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.input...)
+}
+
+// SetFaults replaces the faults in effect.
+func (b *BMC) SetFaults(f Faults) {
+	b.mu.Lock()
+	b.faults = f
+	b.mu.Unlock()
+}
+
+// Reset forgets every session and the SOL payload, as a BMC does when it
+// reboots: packets for old sessions are silently dropped from then on.
+func (b *BMC) Reset() {
+	b.mu.Lock()
+	b.sessions = make(map[uint32]*bmcSession)
+	b.sol = nil
+	b.mu.Unlock()
+}
+
+// Power reports whether the host is powered on.
+func (b *BMC) Power() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.power
+}
+
+// Stats returns the counters so far.
+func (b *BMC) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+func (b *BMC) wake() {
+	select {
+	case b.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (b *BMC) handle(pkt []byte, addr net.Addr) {
+	if len(pkt) < 5 || pkt[0] != rmcpVersion {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.faults.Silent {
+		return
+	}
+	switch {
+	case pkt[3] == rmcpClassASF:
+		b.handlePing(pkt, addr)
+	case pkt[3] == rmcpClassIPMI && pkt[4] == authRMCPP:
+		if !b.cfg.V15 {
+			b.handleRMCPP(pkt, addr)
+		}
+	case pkt[3] == rmcpClassIPMI:
+		b.handleIPMI15(pkt, addr)
+	}
+}
+
+// handlePing answers an ASF presence ping with a pong announcing IPMI.
+func (b *BMC) handlePing(pkt []byte, addr net.Addr) {
+	if len(pkt) < 12 || pkt[8] != 0x80 {
+		return
+	}
+	pong := []byte{
+		rmcpVersion, 0, 0xFF, rmcpClassASF,
+		0x00, 0x00, 0x11, 0xBE, // ASF IANA
+		0x40, pkt[9], 0x00, 0x10, // pong, message tag, reserved, data length
+		0x00, 0x00, 0x11, 0xBE, // IANA
+		0x00, 0x00, 0x00, 0x00, // OEM
+		0x81, 0x00, // IPMI supported, no interactions
+		0, 0, 0, 0, 0, 0,
+	}
+	b.pc.WriteTo(pong, addr)
+}
+
+// handleIPMI15 answers IPMI v1.5 packets: Get Channel Authentication
+// Capabilities, the v1.5 session handshake, and requests and SOL in a v1.5
+// session.
+func (b *BMC) handleIPMI15(pkt []byte, addr net.Addr) {
+	authType := pkt[4]
+	lenAt := 13
+	if authType != authNone {
+		lenAt += 16 // auth code
+	}
+	if len(pkt) <= lenAt {
+		return
+	}
+	seq := binary.LittleEndian.Uint32(pkt[5:9])
+	id := binary.LittleEndian.Uint32(pkt[9:13])
+	msg := pkt[lenAt+1:]
+	if n := int(pkt[lenAt]); n <= len(msg) {
+		msg = msg[:n]
+	}
+
+	if id == 0 {
+		if len(msg) < 7 || msg[1]>>2 != netFnApp {
+			return
+		}
+		switch msg[5] {
+		case 0x38: // Get Channel Authentication Capabilities
+			b.authCaps(msg, addr)
+		case 0x39: // Get Session Challenge
+			b.sessionChallenge(msg, addr)
+		}
+		return
+	}
+
+	sess := b.sessions[id]
+	if sess == nil || !sess.v15 {
+		return // unknown session: dropped, as after a BMC reset
+	}
+	if authType == authNone && sess.active && len(msg) >= 5 && msg[0] != 0x20 {
+		// SOL, unauthenticated, with a reserved header byte
+		sess.addr = addr
+		b.solPacket(sess, append(msg[:4:4], msg[5:]...))
+		return
+	}
+	if authType != sess.authType || !hmac.Equal(pkt[13:29], authCode15(authType, b.cfg.Password, id, seq, msg)) {
+		// A bad auth code is dropped without an answer
+		if !sess.active {
+			b.stats.AuthFailures++
+			delete(b.sessions, id)
+		}
+		return
+	}
+	sess.addr = addr
+	if !sess.active {
+		b.activateSession(sess, msg)
+		return
+	}
+	b.command(sess, msg)
+}
+
+// authCaps answers Get Channel Authentication Capabilities.
+func (b *BMC) authCaps(req []byte, addr net.Addr) {
+	if b.cfg.V15 && req[6]&0x80 != 0 {
+		// Older than IPMI v2.0: the v2.0 data bit is an invalid channel
+		b.send15(addr, authNone, 0, 0, ipmiResponse(req, ccInvalidData, nil))
+		return
+	}
+	caps := []byte{
+		0x01,                         // channel
+		1<<authMD5 | 1<<authPassword, // MD5, straight password
+		0x04,                         // non-null usernames
+		0x00,                         // extended capabilities
+		0, 0, 0, 0,                   // OEM
+	}
+	if !b.cfg.V15 {
+		caps[1] |= 0x80 // IPMI v2.0 extended capabilities
+		caps[3] = 0x02  // IPMI v2.0 connections
+	}
+	b.send15(addr, authNone, 0, 0, ipmiResponse(req, 0, caps))
+}
+
+// sessionChallenge answers Get Session Challenge, starting a v1.5 session
+// under a temporary ID.
+func (b *BMC) sessionChallenge(req []byte, addr net.Addr) {
+	data := req[6 : len(req)-1]
+	if len(data) < 17 {
+		return
+	}
+	if data[0] != authMD5 && data[0] != authPassword {
+		b.send15(addr, authNone, 0, 0, ipmiResponse(req, ccInvalidData, nil))
+		return
+	}
+	if string(bytes.TrimRight(data[1:17], "\x00")) != b.cfg.Username {
+		b.stats.AuthFailures++
+		b.send15(addr, authNone, 0, 0, ipmiResponse(req, ccUnknownUser, nil))
+		return
+	}
+
+	b.nextID++
+	sess := &bmcSession{id: b.nextID, addr: addr, v15: true, authType: data[0], challenge: make([]byte, 16)}
+	rand.Read(sess.challenge)
+	b.sessions[sess.id] = sess
+
+	resp := binary.LittleEndian.AppendUint32(nil, sess.id)
+	b.send15(addr, authNone, 0, 0, ipmiResponse(req, 0, append(resp, sess.challenge...)))
+}
+
+// activateSession answers Activate Session, whose auth code handleIPMI15
+// has checked.
+func (b *BMC) activateSession(sess *bmcSession, req []byte) {
+	if len(req) < 7 || req[5] != 0x3A {
+		return // nothing else before activation
+	}
+	data := req[6 : len(req)-1]
+	if len(data) < 22 || !bytes.Equal(data[2:18], sess.challenge) {
+		sess.seq++
+		b.send15(sess.addr, sess.authType, sess.id, sess.seq, ipmiResponse(req, ccInvalidData, nil))
+		return
+	}
+	sess.active = true
+	sess.seq = binary.LittleEndian.Uint32(data[18:22]) - 1 // the console's choice
+	b.stats.Sessions++
+
+	resp := []byte{sess.authType}
+	resp = binary.LittleEndian.AppendUint32(resp, sess.id)
+	resp = binary.LittleEndian.AppendUint32(resp, 1) // the console's first sequence number
+	resp = append(resp, data[1])                     // maximum privilege
+	b.sendSession(sess, payloadIPMI, ipmiResponse(req, 0, resp))
+}
+
+func (b *BMC) handleRMCPP(pkt []byte, addr net.Addr) {
+	if len(pkt) < 16 {
+		return
+	}
+	ptype := pkt[5] & 0x3F
+	id := binary.LittleEndian.Uint32(pkt[6:10])
+	n := int(binary.LittleEndian.Uint16(pkt[14:16]))
+	if 16+n > len(pkt) {
+		return
+	}
+	payload := pkt[16 : 16+n]
+
+	switch ptype {
+	case payloadOpenReq:
+		b.openSession(payload, addr)
+	case payloadRAKP1:
+		b.rakp1(payload, addr)
+	case payloadRAKP3:
+		b.rakp3(payload, addr)
+	case payloadIPMI, payloadSOL:
+		sess := b.sessions[id]
+		if sess == nil || !sess.active || sess.v15 {
+			return // unknown session: dropped, as after a BMC reset
+		}
+		if sess.integrity && !b.authentic(sess, pkt, n) {
+			return // missing or wrong auth code: dropped, as the spec says
+		}
+		sess.addr = addr
+		if ptype == payloadIPMI {
+			b.command(sess, payload)
+		} else {
+			b.solPacket(sess, payload)
+		}
+	}
+}
+
+func (b *BMC) openSession(p []byte, addr net.Addr) {
+	if len(p) < 32 {
+		return
+	}
+	consoleID := binary.LittleEndian.Uint32(p[4:8])
+	resp := make([]byte, 36)
+	resp[0] = p[0]
+	binary.LittleEndian.PutUint32(resp[4:8], consoleID)
+	if p[12] != 0x01 || p[20] > 0x01 || p[20] == 0x01 && b.cfg.NoIntegrity || p[28] != 0x00 {
+		resp[1] = statusNoCipherSuite
+		b.send(addr, payloadOpenResp, 0, 0, resp[:8])
+		return
+	}
+
+	b.nextID++
+	sess := &bmcSession{id: b.nextID, consoleID: consoleID, addr: addr, integrity: p[20] == 0x01}
+	b.sessions[sess.id] = sess
+
+	resp[2] = p[1] // maximum privilege
+	binary.LittleEndian.PutUint32(resp[8:12], sess.id)
+	copy(resp[12:36], p[8:32]) // algorithms as proposed
+	b.send(addr, payloadOpenResp, 0, 0, resp)
+}
+
+func (b *BMC) rakp1(p []byte, addr net.Addr) {
+	if len(p) < 28 {
+		return
+	}
+	sess := b.sessions[binary.LittleEndian.Uint32(p[4:8])]
+	if sess == nil || sess.active {
+		b.send(addr, payloadRAKP2, 0, 0, []byte{p[0], statusInvalidSession, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	ulen := int(p[27])
+	if 28+ulen > len(p) {
+		return
+	}
+	sess.rmRand = append([]byte(nil), p[8:24]...)
+	sess.role = p[24]
+	sess.username = string(p[28 : 28+ulen])
+
+	resp := make([]byte, 8, 60)
+	resp[0] = p[0]
+	binary.LittleEndian.PutUint32(resp[4:8], sess.consoleID)
+	if sess.username != b.cfg.Username {
+		resp[1] = statusUnauthorized
+		b.stats.AuthFailures++
+		delete(b.sessions, sess.id)
+		b.send(addr, payloadRAKP2, 0, 0, resp)
+		return
+	}
+	sess.mcRand = make([]byte, 16)
+	rand.Read(sess.mcRand)
+
+	var ids [8]byte
+	binary.LittleEndian.PutUint32(ids[0:4], sess.consoleID)
+	binary.LittleEndian.PutUint32(ids[4:8], sess.id)
+	authCode := b.hmac(b.kuid(), ids[:], sess.rmRand, sess.mcRand, bmcGUID,
+		[]byte{sess.role, byte(ulen)}, []byte(sess.username))
+
+	resp = append(resp, sess.mcRand...)
+	resp = append(resp, bmcGUID...)
+	resp = append(resp, authCode...)
+	b.send(addr, payloadRAKP2, 0, 0, resp)
+}
+
+func (b *BMC) rakp3(p []byte, addr net.Addr) {
+	if len(p) < 8 {
+		return
+	}
+	sess := b.sessions[binary.LittleEndian.Uint32(p[4:8])]
+	if sess == nil || sess.mcRand == nil || sess.active {
+		b.send(addr, payloadRAKP4, 0, 0, []byte{p[0], statusInvalidSession, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	resp := make([]byte, 8, 20)
+	resp[0] = p[0]
+	binary.LittleEndian.PutUint32(resp[4:8], sess.consoleID)
+	if p[1] != 0 {
+		delete(b.sessions, sess.id) // the console gave up on RAKP2
+		return
+	}
+
+	var cid [4]byte
+	binary.LittleEndian.PutUint32(cid[:], sess.consoleID)
+	want := b.hmac(b.kuid(), sess.mcRand, cid[:], []byte{sess.role, byte(len(sess.username))}, []byte(sess.username))
+	if !hmac.Equal(p[8:], want) {
+		resp[1] = statusInvalidICV
+		b.stats.AuthFailures++
+		delete(b.sessions, sess.id)
+		b.send(addr, payloadRAKP4, 0, 0, resp)
+		return
+	}
+
+	kg := b.kuid()
+	if len(b.cfg.Kg) > 0 {
+		kg = make([]byte, 20)
+		copy(kg, b.cfg.Kg)
+	}
+	sik := b.hmac(kg, sess.rmRand, sess.mcRand, []byte{sess.role, byte(len(sess.username))}, []byte(sess.username))
+	var sid [4]byte
+	binary.LittleEndian.PutUint32(sid[:], sess.id)
+	icv := b.hmac(sik, sess.rmRand, sid[:], bmcGUID)
+	if sess.integrity {
+		sess.k1 = b.hmac(sik, bytes.Repeat([]byte{0x01}, 20))
+	}
+
+	sess.active = true
+	b.stats.Sessions++
+	b.send(addr, payloadRAKP4, 0, 0, append(resp, icv[:12]...))
+}
+
+// command answers an in-session IPMI request.
+func (b *BMC) command(sess *bmcSession, msg []byte) {
+	if len(msg) < 7 {
+		return
+	}
+	netFn, cmd, data := msg[1]>>2, msg[5], msg[6:len(msg)-1]
+	b.stats.Commands++
+
+	cc, resp := byte(0), []byte(nil)
+	switch {
+	case netFn == netFnApp && cmd == 0x01: // Get Device ID
+		resp = []byte{0x20, 0x81, 0x01, 0x00, 0x02, 0xBF, 0x00, 0x00, 0x00, 0x00, 0x00}
+		resp[6], resp[7], resp[8] = byte(b.cfg.Manufacturer), byte(b.cfg.Manufacturer>>8), byte(b.cfg.Manufacturer>>16)&0x0F
+		binary.LittleEndian.PutUint16(resp[9:11], b.cfg.Product)
+	case netFn == netFnApp && cmd == 0x3B: // Set Session Privilege Level
+		resp = []byte{0x04}
+		if len(data) > 0 && data[0] != 0 {
+			resp[0] = data[0]
+		}
+	case netFn == netFnApp && cmd == 0x3C: // Close Session
+		defer b.closeSession(sess)
+	case netFn == netFnApp && cmd == 0x48: // Activate Payload
+		switch {
+		case len(data) < 1 || data[0] != payloadSOL:
+			cc = ccInvalidCommand
+		case b.faults.ActivateCode != 0:
+			cc = b.faults.ActivateCode
+		case b.faults.ActivateBusy > 0:
+			b.faults.ActivateBusy--
+			cc = ccPayloadActive
+		case b.sol != nil && b.sol != sess:
+			cc = ccPayloadActive
+		default:
+			b.sol = sess
+			b.outSeq = 0
+			sess.lastInput, sess.lastAccepted = 0, 0
+			b.stats.Activations++
+			resp = make([]byte, 12)
+			binary.LittleEndian.PutUint16(resp[4:6], uint16(b.cfg.MaxPayload))
+			binary.LittleEndian.PutUint16(resp[6:8], uint16(b.cfg.MaxPayload))
+			binary.LittleEndian.PutUint16(resp[8:10], 623)
+			binary.LittleEndian.PutUint16(resp[10:12], 0xFFFF)
+			defer b.wake()
+		}
+	case netFn == netFnApp && cmd == 0x4A: // Get Payload Activation Status
+		resp = []byte{0x01, 0x00, 0x00} // one instance
+		if len(data) > 0 && data[0] == payloadSOL && b.sol != nil {
+			resp[1] = 0x01 // instance 1 active
+		}
+	case netFn == netFnApp && cmd == 0x49: // Deactivate Payload
+		if b.sol != sess {
+			cc = ccPayloadInactive
+		} else {
+			b.sol = nil
+		}
+	case netFn == netFnChassis && cmd == 0x01: // Get Chassis Status
+		resp = []byte{0, 0, 0, 0}
+		if b.power {
+			resp[0] = 0x01
+		}
+	case netFn == netFnChassis && cmd == 0x02: // Chassis Control
+		if len(data) < 1 {
+			cc = ccInvalidCommand
+			break
+		}
+		action := sol.ChassisAction(data[0])
+		switch action {
+		case sol.ChassisPowerOff, sol.ChassisSoftOff:
+			b.power = false
+		case sol.ChassisPowerOn, sol.ChassisPowerCycle, sol.ChassisHardReset:
+			b.power = true
+		}
+		if b.cfg.OnPower != nil {
+			go b.cfg.OnPower(action)
+		}
+	case netFn == netFnChassis && cmd == 0x08: // Set System Boot Options
+	case netFn == netFnStorage && cmd == 0x40: // Get SEL Info: empty
+		resp = []byte{0x51, 0, 0, 0xFF, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	default:
+		cc = ccInvalidCommand
+	}
+
+	b.sendSession(sess, payloadIPMI, ipmiResponse(msg, cc, resp))
+}
+
+func (b *BMC) closeSession(sess *bmcSession) {
+	delete(b.sessions, sess.id)
+	if b.sol == sess {
+		b.sol = nil
+	}
+}
+
+// solPacket takes console input and ACKs of console output.
+func (b *BMC) solPacket(sess *bmcSession, p []byte) {
+	if len(p) < 4 || sess != b.sol {
+		return
+	}
+	seq, ack, accepted, op, data := p[0]&0x0F, p[1]&0x0F, int(p[2]), p[3], p[4:]
+
+	if ack != 0 {
+		select {
+		case b.acks <- solAck{seq: ack, accepted: accepted, nack: op&solOpNack != 0}:
+		default:
+		}
+	}
+	if seq == 0 {
+		return
+	}
+
+	b.stats.InputPackets++
+	if seq == sess.lastInput {
+		b.stats.Duplicates++
+		b.sendSOL(sess, []byte{0, seq, byte(sess.lastAccepted), 0})
+		return
+	}
+	if b.faults.NackInput > 0 {
+		b.faults.NackInput--
+		b.stats.Nacks++
+		b.sendSOL(sess, []byte{0, seq, 0, solOpNack})
+		return
+	}
+
+	n := len(data)
+	if b.faults.AcceptLimit > 0 && n > b.faults.AcceptLimit {
+		n = b.faults.AcceptLimit
+	}
+	b.input = append(b.input, data[:n]...)
+	if op&solOpBreak != 0 {
+		b.stats.Breaks++
+	}
+	sess.lastInput, sess.lastAccepted = seq, n
+	if b.cfg.Echo && n > 0 {
+		b.output = append(b.output, data[:n]...)
+		defer b.wake()
+	}
+
+	if b.faults.DropAcks > 0 {
+		b.faults.DropAcks--
+		return
+	}
+	b.sendSOL(sess, []byte{0, seq, byte(n), 0})
+}
+
+// sendLoop sends console output one packet at a time, resending each until
+// it is acknowledged or RetryCount runs out.
+func (b *BMC) sendLoop() {
+	for {
+		b.mu.Lock()
+		var chunk []byte
+		var seq uint8
+		if b.sol != nil && len(b.output) > 0 {
+			n := min(len(b.output), b.cfg.MaxPayload-4)
+			if b.sol.v15 {
+				n = min(n, b.cfg.MaxPayload-5) // the v1.5 SOL header has a reserved byte
+			}
+			chunk = append([]byte(nil), b.output[:n]...)
+			b.output = b.output[n:]
+			b.outSeq = b.outSeq%15 + 1
+			seq = b.outSeq
+		}
+		b.mu.Unlock()
+
+		if chunk == nil {
+			select {
+			case <-b.kick:
+				continue
+			case <-b.done:
+				return
+			}
+		}
+
+		for attempt := 0; ; attempt++ {
+			b.mu.Lock()
+			sess := b.sol
+			if sess == nil {
+				// Deactivated mid-packet: keep the output for the next session
+				b.output = append(chunk, b.output...)
+				b.mu.Unlock()
+				break
+			}
+			if attempt > 0 {
+				b.stats.Resends++
+			}
+			b.stats.OutputPackets++
+			if b.faults.DropOutput > 0 {
+				b.faults.DropOutput--
+			} else if !b.faults.Silent {
+				b.sendSOL(sess, append([]byte{seq, 0, 0, 0}, chunk...))
+			}
+			b.mu.Unlock()
+
+			ack, ok := b.waitAck(seq)
+			if !ok {
+				return
+			}
+			if ack != nil && !ack.nack && ack.accepted >= len(chunk) {
+				break
+			}
+			if ack != nil {
+				// Partial ACK or NACK: the rest goes again as a new packet
+				rest := chunk[min(ack.accepted, len(chunk)):]
+				b.mu.Lock()
+				b.output = append(append([]byte(nil), rest...), b.output...)
+				b.mu.Unlock()
+				break
+			}
+			if attempt >= b.cfg.RetryCount {
+				b.mu.Lock()
+				b.stats.DroppedOutput++
+				b.mu.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// waitAck waits up to RetryInterval for an ACK of seq, returning nil on
+// timeout and false once the BMC is closed.
+func (b *BMC) waitAck(seq uint8) (*solAck, bool) {
+	timeout := b.clock.After(b.cfg.RetryInterval)
+	defer cancelAfter(b.clock, timeout)
+	for {
+		select {
+		case ack := <-b.acks:
+			if ack.seq == seq {
+				return &ack, true
+			}
+		case <-timeout:
+			return nil, true
+		case <-b.done:
+			return nil, false
+		}
+	}
+}
+
+func (b *BMC) sendSOL(sess *bmcSession, payload []byte) {
+	b.sendSession(sess, payloadSOL, payload)
+}
+
+// sendSession sends a packet in sess: RMCP+, or v1.5 with the session's
+// auth code, except for SOL, which goes unauthenticated with a reserved
+// header byte.
+func (b *BMC) sendSession(sess *bmcSession, ptype byte, payload []byte) {
+	sess.seq++
+	switch {
+	case sess.integrity:
+		b.sendSigned(sess, ptype, payload)
+	case !sess.v15:
+		b.send(sess.addr, ptype, sess.consoleID, sess.seq, payload)
+	case ptype == payloadSOL:
+		b.send15(sess.addr, authNone, sess.id, 0, append(append(payload[:4:4], 0), payload[4:]...))
+	default:
+		b.send15(sess.addr, sess.authType, sess.id, sess.seq, payload)
+	}
+}
+
+// send15 writes an IPMI v1.5 packet, with an auth code unless authType is
+// none.
+func (b *BMC) send15(addr net.Addr, authType byte, id, seq uint32, msg []byte) {
+	pkt := []byte{rmcpVersion, 0, 0xFF, rmcpClassIPMI, authType}
+	pkt = binary.LittleEndian.AppendUint32(pkt, seq)
+	pkt = binary.LittleEndian.AppendUint32(pkt, id)
+	if authType != authNone {
+		pkt = append(pkt, authCode15(authType, b.cfg.Password, id, seq, msg)...)
+	}
+	pkt = append(pkt, byte(len(msg)))
+	b.pc.WriteTo(append(pkt, msg...), addr)
+}
+
+// send writes an RMCP+ packet without integrity or encryption.
+func (b *BMC) send(addr net.Addr, ptype byte, id, seq uint32, payload []byte) {
+	pkt := make([]byte, 16, 16+len(payload))
+	pkt[0], pkt[2], pkt[3] = rmcpVersion, 0xFF, rmcpClassIPMI
+	pkt[4], pkt[5] = authRMCPP, ptype
+	binary.LittleEndian.PutUint32(pkt[6:10], id)
+	binary.LittleEndian.PutUint32(pkt[10:14], seq)
+	binary.LittleEndian.PutUint16(pkt[14:16], uint16(len(payload)))
+	b.pc.WriteTo(append(pkt, payload...), addr)
+}
+
+// sendSigned writes an RMCP+ packet with the session's auth code: the
+// payload padded to 4 bytes, pad length, next header and HMAC-SHA1-96.
+func (b *BMC) sendSigned(sess *bmcSession, ptype byte, payload []byte) {
+	pkt := make([]byte, 16, 16+len(payload)+17)
+	pkt[0], pkt[2], pkt[3] = rmcpVersion, 0xFF, rmcpClassIPMI
+	pkt[4], pkt[5] = authRMCPP, ptype|0x40
+	binary.LittleEndian.PutUint32(pkt[6:10], sess.consoleID)
+	binary.LittleEndian.PutUint32(pkt[10:14], sess.seq)
+	binary.LittleEndian.PutUint16(pkt[14:16], uint16(len(payload)))
+	pkt = append(pkt, payload...)
+	pad := (4 - len(payload)%4) % 4
+	pkt = append(pkt, bytes.Repeat([]byte{0xFF}, pad)...)
+	pkt = append(pkt, byte(pad), 0x07)
+	b.pc.WriteTo(append(pkt, b.hmac(sess.k1, pkt[4:])[:12]...), sess.addr)
+}
+
+// authentic reports whether an in-session RMCP+ packet with an n-byte
+// payload carries the session's auth code.
+func (b *BMC) authentic(sess *bmcSession, pkt []byte, n int) bool {
+	end := 16 + n + (4-n%4)%4 + 2
+	if pkt[5]&0x40 == 0 || len(pkt) != end+12 || pkt[end-1] != 0x07 {
+		return false
+	}
+	return hmac.Equal(pkt[end:], b.hmac(sess.k1, pkt[4:end])[:12])
+}
+
+// kuid is the user key: the password padded to 20 bytes.
+func (b *BMC) kuid() []byte {
+	k := make([]byte, 20)
+	copy(k, b.cfg.Password)
+	return k
+}
+
+func (b *BMC) hmac(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha1.New, key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// authCode15 is the auth code of a v1.5 message: the password, or
+// MD5(password + session ID + message + sequence + password).
+func authCode15(authType byte, password string, id, seq uint32, msg []byte) []byte {
+	pw := make([]byte, 16)
+	copy(pw, password)
+	if authType != authMD5 {
+		return pw
+	}
+	h := md5.New()
+	h.Write(pw)
+	h.Write(binary.LittleEndian.AppendUint32(nil, id))
+	h.Write(msg)
+	h.Write(binary.LittleEndian.AppendUint32(nil, seq))
+	h.Write(pw)
+	return h.Sum(nil)
+}
+
+// ipmiResponse builds the response message to request req.
+func ipmiResponse(req []byte, cc byte, data []byte) []byte {
+	netFn := req[1]>>2 + 1
+	msg := []byte{req[3], netFn<<2 | req[4]&0x03, 0, req[0], req[4], req[5], cc}
+	msg[2] = -(msg[0] + msg[1])
+	msg = append(msg, data...)
+	var chk byte
+	for _, c := range msg[3:] {
+		chk -= c
+	}
+	return append(msg, chk)
+}
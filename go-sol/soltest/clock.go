@@ -0,0 +1,185 @@
+package soltest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	sol "github.com/gwest/go-sol"
+)
+
+// Clock is a manual sol.Clock: time stands still until Advance moves it,
+// firing the timers, tickers and socket deadlines it passes. Give the same
+// Clock to the Session (Config.Clock), Pipe and the BMC, so a test steps
+// through retransmits, keepalives and inactivity timeouts without
+// sleeping.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+	changed chan struct{} // closed and replaced whenever waiters change
+}
+
+// waiter is a pending After, or a ticker when period is set.
+type waiter struct {
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewClock returns a Clock stopped at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start, changed: make(chan struct{})}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &waiter{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.ch <- c.now
+		return w.ch
+	}
+	c.addLocked(w)
+	return w.ch
+}
+
+func (c *Clock) NewTicker(d time.Duration) sol.Ticker {
+	if d <= 0 {
+		panic("soltest: non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &waiter{at: c.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	c.addLocked(w)
+	return &ticker{clock: c, w: w}
+}
+
+// Advance moves the clock forward by d, firing everything due on the way
+// in time order. A ticker that falls several periods behind fires once,
+// as a time.Ticker drops ticks for a slow receiver.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		select {
+		case w.ch <- c.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.at.After(c.now) {
+				w.at = w.at.Add(w.period)
+			}
+			if w.at.After(end) {
+				continue
+			}
+		}
+		c.removeLocked(w)
+	}
+	c.now = end
+	c.notifyLocked()
+}
+
+// Waiters returns the number of timers, tickers and socket deadlines
+// pending on the clock.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n timers, tickers or deadlines are
+// pending, i.e. the goroutines under test have got to the point of waiting
+// on the clock, so a following Advance isn't lost.
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		if len(c.waiters) >= n {
+			c.mu.Unlock()
+			return
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		<-changed
+	}
+}
+
+func (c *Clock) addLocked(w *waiter) {
+	c.waiters = append(c.waiters, w)
+	c.notifyLocked()
+}
+
+func (c *Clock) removeLocked(w *waiter) {
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.notifyLocked()
+			return
+		}
+	}
+}
+
+func (c *Clock) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// cancel drops a pending After that is no longer waited for, e.g. a read
+// deadline whose read completed.
+func (c *Clock) cancel(ch <-chan time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.waiters {
+		if w.ch == ch {
+			c.removeLocked(w)
+			return
+		}
+	}
+}
+
+type ticker struct {
+	clock *Clock
+	w     *waiter
+}
+
+func (t *ticker) C() <-chan time.Time { return t.w.ch }
+
+func (t *ticker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.removeLocked(t.w)
+}
+
+// wallClock is the real time, for a BMC or Pipe given no Clock.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (wallClock) NewTicker(d time.Duration) sol.Ticker   { return wallTicker{time.NewTicker(d)} }
+
+type wallTicker struct {
+	t *time.Ticker
+}
+
+func (t wallTicker) C() <-chan time.Time { return t.t.C }
+func (t wallTicker) Stop()               { t.t.Stop() }
+
+// cancelAfter releases a deadline wait on clock, when it is a Clock.
+func cancelAfter(clock sol.Clock, ch <-chan time.Time) {
+	if c, ok := clock.(*Clock); ok {
+		c.cancel(ch)
+	}
+}
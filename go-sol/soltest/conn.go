@@ -0,0 +1,167 @@
+package soltest
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	sol "github.com/gwest/go-sol"
+)
+
+// pipeDepth is the packets queued at each end of a Pipe; like a UDP
+// socket buffer, packets beyond it are dropped.
+const pipeDepth = 256
+
+// pipeAddr names the ends of a Pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "udp" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// Pipe returns the two ends of an in-memory datagram link: a net.Conn for
+// the Session and a net.PacketConn for BMC.Serve. Packets keep their
+// boundaries, and read and write deadlines run on clock (nil = real time),
+// so a Session with the same Clock times out exactly when the test says.
+func Pipe(clock sol.Clock) (net.Conn, net.PacketConn) {
+	if clock == nil {
+		clock = wallClock{}
+	}
+	a := newEndpoint(clock, "session")
+	b := newEndpoint(clock, "bmc")
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+// Dialer returns a Config.Dial that hands out conn, ignoring the address;
+// it fails once conn has been handed out, as a second session would need
+// its own Pipe.
+func Dialer(conn net.Conn) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var once sync.Once
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var c net.Conn
+		once.Do(func() { c = conn })
+		if c == nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: os.ErrClosed}
+		}
+		return c, nil
+	}
+}
+
+type endpoint struct {
+	clock sol.Clock
+	addr  pipeAddr
+	peer  *endpoint
+	in    chan []byte
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+func newEndpoint(clock sol.Clock, name string) *endpoint {
+	return &endpoint{
+		clock:  clock,
+		addr:   pipeAddr(name),
+		in:     make(chan []byte, pipeDepth),
+		closed: make(chan struct{}),
+	}
+}
+
+func (e *endpoint) Read(b []byte) (int, error) {
+	n, _, err := e.ReadFrom(b)
+	return n, err
+}
+
+func (e *endpoint) ReadFrom(b []byte) (int, net.Addr, error) {
+	e.mu.Lock()
+	deadline := e.readDeadline
+	e.mu.Unlock()
+
+	// A packet already waiting is returned even past the deadline
+	select {
+	case p := <-e.in:
+		return copy(b, p), e.peer.addr, nil
+	case <-e.closed:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := deadline.Sub(e.clock.Now())
+		if d <= 0 {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		timeout = e.clock.After(d)
+		defer cancelAfter(e.clock, timeout)
+	}
+	select {
+	case p := <-e.in:
+		return copy(b, p), e.peer.addr, nil
+	case <-e.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (e *endpoint) Write(b []byte) (int, error) {
+	return e.WriteTo(b, e.peer.addr)
+}
+
+// WriteTo queues a copy of b at the other end; addr is ignored. Writes
+// never block, so only a closed end or a passed deadline fails them.
+func (e *endpoint) WriteTo(b []byte, addr net.Addr) (int, error) {
+	e.mu.Lock()
+	deadline := e.writeDeadline
+	e.mu.Unlock()
+	select {
+	case <-e.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if !deadline.IsZero() && !e.clock.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	select {
+	case <-e.peer.closed:
+		// Sent into the void, as with UDP
+	case e.peer.in <- append([]byte(nil), b...):
+	default:
+		// Peer's buffer is full: dropped
+	}
+	return len(b), nil
+}
+
+func (e *endpoint) Close() error {
+	e.closeOnce.Do(func() { close(e.closed) })
+	return nil
+}
+
+func (e *endpoint) LocalAddr() net.Addr  { return e.addr }
+func (e *endpoint) RemoteAddr() net.Addr { return e.peer.addr }
+
+func (e *endpoint) SetDeadline(t time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.readDeadline, e.writeDeadline = t, t
+	return nil
+}
+
+func (e *endpoint) SetReadDeadline(t time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.readDeadline = t
+	return nil
+}
+
+func (e *endpoint) SetWriteDeadline(t time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.writeDeadline = t
+	return nil
+}
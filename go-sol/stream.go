@@ -0,0 +1,137 @@
+package sol
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReadContext returns the next chunk of console output, waiting until some
+// arrives or ctx is done. Once the session has ended and its output is
+// drained it returns io.EOF. It reads from the same channel as Read.
+func (s *Session) ReadContext(ctx context.Context) ([]byte, error) {
+	return s.readChunk(ctx, nil, nil)
+}
+
+// readChunk waits for a chunk of output, ctx, the timeout or a change
+// signal; a changed wait returns (nil, nil) so the caller can rearm.
+func (s *Session) readChunk(ctx context.Context, timeout <-chan time.Time, changed <-chan struct{}) ([]byte, error) {
+	for {
+		select {
+		case data, ok := <-s.readCh:
+			if !ok {
+				return nil, io.EOF
+			}
+			if len(data) == 0 {
+				continue
+			}
+			return data, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout:
+			return nil, os.ErrDeadlineExceeded
+		case <-changed:
+			return nil, nil
+		case <-s.done:
+			// Closed: hand over what is already queued, then EOF
+			select {
+			case data, ok := <-s.readCh:
+				if ok {
+					return data, nil
+				}
+			default:
+			}
+			return nil, io.EOF
+		}
+	}
+}
+
+// Stream adapts a Session to io.ReadWriteCloser, for bufio, io.Copy and
+// terminal plumbing. Reads take from the same channel as Session.Read, so
+// use one or the other, and one Stream per session.
+type Stream struct {
+	s *Session
+
+	rmu  sync.Mutex // serialises reads
+	rest []byte     // unread tail of the last chunk
+
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{} // closed and replaced when the deadline moves
+}
+
+// Stream returns an io.ReadWriteCloser over the session's console.
+func (s *Session) Stream() *Stream {
+	return &Stream{s: s, changed: make(chan struct{})}
+}
+
+// Read reads console output, blocking until some arrives, the read
+// deadline passes (os.ErrDeadlineExceeded) or the session ends (io.EOF).
+func (st *Stream) Read(p []byte) (int, error) {
+	return st.ReadContext(context.Background(), p)
+}
+
+// ReadContext is Read that also gives up with ctx.Err() when ctx is done.
+func (st *Stream) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	st.rmu.Lock()
+	defer st.rmu.Unlock()
+
+	for len(st.rest) == 0 {
+		st.mu.Lock()
+		deadline, changed := st.deadline, st.changed
+		st.mu.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := deadline.Sub(st.s.clock.Now())
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timeout = st.s.clock.After(d)
+		}
+		data, err := st.s.readChunk(ctx, timeout, changed)
+		if err != nil {
+			return 0, err
+		}
+		st.rest = data // nil when the deadline moved: wait again
+	}
+
+	n := copy(p, st.rest)
+	st.rest = st.rest[n:]
+	return n, nil
+}
+
+// Write queues p as console input and returns once it is queued, not when
+// the BMC has acknowledged it; delivery failures show in Stats.
+func (st *Stream) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// The write loop sends after Write returns, so it gets its own copy
+	if err := st.s.Write(append([]byte(nil), p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the session.
+func (st *Stream) Close() error {
+	return st.s.Close()
+}
+
+// SetReadDeadline sets when pending and future Reads give up with
+// os.ErrDeadlineExceeded, on the session's Clock. The zero time means no
+// deadline. A blocked Read sees the new deadline straight away.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.deadline = t
+	close(st.changed)
+	st.changed = make(chan struct{})
+	return nil
+}
@@ -4,11 +4,14 @@ go 1.24.2
 
 require (
 	github.com/gorilla/mux v1.8.1
-	github.com/gwest/go-sol v0.0.0
+	github.com/gwest/go-sol v0.2.0
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/crypto v0.45.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.13.0 // indirect
+require golang.org/x/sys v0.38.0 // indirect
 
-replace github.com/gwest/go-sol => ../go-sol
+// go-sol is developed in this repository; vendor/ is generated from it
+// with go mod vendor, never edited by hand.
+replace github.com/gwest/go-sol => ./go-sol
@@ -0,0 +1,125 @@
+package logs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// isLogFile reports whether name is a plain or gzip-compressed log file.
+func isLogFile(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")
+}
+
+// gzipFile compresses path to path.gz, keeping the original modification time
+// so retention still applies, then removes the original.
+func gzipFile(path string, modTime time.Time) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	zw.ModTime = modTime
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(path+".gz", modTime, modTime)
+	return os.Remove(path)
+}
+
+// ResolveLogPath returns the on-disk path for a log file, falling back to the
+// compressed copy when the plain file has been gzipped.
+func (w *Writer) ResolveLogPath(serverName, filename string) (path string, compressed bool, err error) {
+	path = filepath.Join(w.BasePath(), serverName, filepath.Base(filename))
+	if strings.HasSuffix(path, ".gz") {
+		_, err = os.Stat(path)
+		return path, true, err
+	}
+	if _, err = os.Stat(path); err == nil {
+		return path, false, nil
+	}
+	if _, gzErr := os.Stat(path + ".gz"); gzErr == nil {
+		return path + ".gz", true, nil
+	}
+	return path, false, err
+}
+
+// readSeekNopCloser adapts an in-memory reader to io.ReadSeekCloser.
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+// OpenLog opens a log file for reading, transparently decompressing .log.gz
+// files. Returns the reader and the uncompressed size.
+func (w *Writer) OpenLog(serverName, filename string) (io.ReadSeekCloser, int64, error) {
+	path, compressed, err := w.ResolveLogPath(serverName, filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !compressed {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, 0, err
+	}
+	return readSeekNopCloser{bytes.NewReader(data)}, int64(len(data)), nil
+}
+
+// ReadLog returns the full (uncompressed) content of a log file.
+func (w *Writer) ReadLog(serverName, filename string) ([]byte, error) {
+	r, _, err := w.OpenLog(serverName, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
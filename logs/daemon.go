@@ -0,0 +1,147 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DaemonLogName is the daemon's own log file in the log directory.
+const DaemonLogName = "ipmiserial.log"
+
+// DaemonLog is the daemon's own log file, rotated by size. Rotated files
+// are renamed ipmiserial-<timestamp>.log and pruned by age and count.
+type DaemonLog struct {
+	mu         sync.Mutex
+	dir        string
+	file       *os.File
+	size       int64
+	maxSize    int64 // bytes, 0 = never rotate
+	maxAge     time.Duration
+	maxBackups int
+}
+
+// OpenDaemonLog opens (appending to) ipmiserial.log in dir.
+func OpenDaemonLog(dir string, maxSizeMB, maxAgeDays, maxBackups int) (*DaemonLog, error) {
+	d := &DaemonLog{dir: dir}
+	d.SetLimits(maxSizeMB, maxAgeDays, maxBackups)
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SetLimits changes the rotation size and how many rotated files are kept.
+func (d *DaemonLog) SetLimits(maxSizeMB, maxAgeDays, maxBackups int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxSize = int64(maxSizeMB) * 1024 * 1024
+	d.maxAge = time.Duration(maxAgeDays) * 24 * time.Hour
+	d.maxBackups = maxBackups
+}
+
+// SetDir moves logging to ipmiserial.log in dir, e.g. after a log
+// directory migration. The old file is closed.
+func (d *DaemonLog) SetDir(dir string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old := d.file
+	prevDir := d.dir
+	d.dir = dir
+	if err := d.open(); err != nil {
+		d.dir = prevDir
+		return err
+	}
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (d *DaemonLog) open() error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(d.dir, DaemonLogName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.file = f
+	d.size = info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if it would take the file past the size
+// limit.
+func (d *DaemonLog) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.maxSize > 0 && d.size > 0 && d.size+int64(len(p)) > d.maxSize {
+		if err := d.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "ipmiserial: rotate %s: %v\n", DaemonLogName, err)
+		}
+	}
+	n, err := d.file.Write(p)
+	d.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (d *DaemonLog) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.Close()
+}
+
+// rotate renames the current file aside, opens a fresh one and prunes old
+// rotated files. Must be called with d.mu held.
+func (d *DaemonLog) rotate() error {
+	d.file.Close()
+	current := filepath.Join(d.dir, DaemonLogName)
+	rotated := filepath.Join(d.dir, "ipmiserial-"+time.Now().Format("20060102-150405.000")+".log")
+	renameErr := os.Rename(current, rotated)
+	if err := d.open(); err != nil {
+		return err
+	}
+	d.prune()
+	return renameErr
+}
+
+// prune removes rotated files beyond maxBackups or older than maxAge.
+// Must be called with d.mu held.
+func (d *DaemonLog) prune() {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	var rotated []string // oldest first, as the timestamps sort
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, "ipmiserial-") && strings.HasSuffix(name, ".log") {
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Strings(rotated)
+
+	for i, name := range rotated {
+		path := filepath.Join(d.dir, name)
+		if d.maxBackups > 0 && i < len(rotated)-d.maxBackups {
+			os.Remove(path)
+			continue
+		}
+		if d.maxAge > 0 {
+			if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > d.maxAge {
+				os.Remove(path)
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+// lokiPushPath is Loki's push endpoint, appended to a base URL.
+const lokiPushPath = "/loki/api/v1/push"
+
+// Loki delivery backoff after a failed push
+const (
+	lokiMinBackoff = time.Second
+	lokiMaxBackoff = 5 * time.Minute
+)
+
+// LokiSink batches cleaned console lines and pushes them to Grafana Loki,
+// one stream per server labelled {server, source="sol"}. Batches Loki does
+// not accept are spooled to disk and replayed, oldest first, with backoff.
+type LokiSink struct {
+	pushURL    string
+	cfg        config.LokiConfig
+	labels     map[string]string
+	spoolDir   string
+	spoolMax   int64
+	client     *http.Client
+	flushCh    chan struct{}
+	mu         sync.Mutex
+	lines      *LineBuffer
+	pending    map[string][][2]string // server -> [timestamp ns, line]
+	pendingLen int
+	backoff    time.Duration
+	retryAt    time.Time
+	failing    bool
+}
+
+// lokiPush is the body of a Loki push request.
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// NewLokiSink creates a sink for cfg. Spooled batches go to cfg.SpoolDir,
+// or <dataDir>/loki-spool.
+func NewLokiSink(cfg config.LokiConfig, dataDir string) (*LokiSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("loki url is required")
+	}
+	pushURL := strings.TrimSuffix(cfg.URL, "/")
+	if !strings.HasSuffix(pushURL, lokiPushPath) {
+		pushURL += lokiPushPath
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = time.Second
+	}
+	spoolDir := cfg.SpoolDir
+	if spoolDir == "" {
+		spoolDir = filepath.Join(dataDir, "loki-spool")
+	}
+	if err := os.MkdirAll(spoolDir, 0755); err != nil {
+		return nil, fmt.Errorf("loki spool: %w", err)
+	}
+
+	labels := map[string]string{"source": "sol"}
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	return &LokiSink{
+		pushURL:  pushURL,
+		cfg:      cfg,
+		labels:   labels,
+		spoolDir: spoolDir,
+		spoolMax: int64(cfg.SpoolMaxMB) << 20,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		flushCh:  make(chan struct{}, 1),
+		lines:    NewLineBuffer(),
+		pending:  make(map[string][][2]string),
+	}, nil
+}
+
+// Write takes a chunk of a server's cleaned log output (a SinkCleaned
+// sink). Complete lines are queued for the next push; blank lines are
+// skipped. Delivery failures are handled by the spool, so it never fails.
+func (l *LokiSink) Write(serverName string, data []byte) error {
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	l.mu.Lock()
+	for _, line := range l.lines.Lines(serverName, data) {
+		l.pending[serverName] = append(l.pending[serverName], [2]string{now, line})
+		l.pendingLen++
+	}
+	full := l.pendingLen >= l.cfg.BatchSize
+	l.mu.Unlock()
+
+	if full {
+		select {
+		case l.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run pushes batches every BatchWait, or sooner when BatchSize lines are
+// waiting, until ctx is cancelled. What is left is pushed (or spooled) on
+// the way out.
+func (l *LokiSink) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.cfg.BatchWait)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.flush(context.Background())
+			return
+		case <-ticker.C:
+		case <-l.flushCh:
+		}
+		l.flush(ctx)
+	}
+}
+
+// flush replays spooled batches and pushes the pending lines. While Loki is
+// failing, pending lines are spooled until the backoff elapses.
+func (l *LokiSink) flush(ctx context.Context) {
+	l.mu.Lock()
+	body := l.takePending()
+	waiting := time.Now().Before(l.retryAt)
+	l.mu.Unlock()
+
+	if waiting {
+		if body != nil {
+			l.spool(body)
+		}
+		return
+	}
+
+	if err := l.replaySpool(ctx); err != nil {
+		l.pushFailed(err)
+		if body != nil {
+			l.spool(body)
+		}
+		return
+	}
+	if body == nil {
+		l.pushSucceeded()
+		return
+	}
+	if err := l.push(ctx, body); err != nil {
+		l.pushFailed(err)
+		l.spool(body)
+		return
+	}
+	l.pushSucceeded()
+}
+
+// takePending builds a push body from the pending lines and clears them.
+// Returns nil when nothing is pending. Must be called with l.mu held.
+func (l *LokiSink) takePending() []byte {
+	if l.pendingLen == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(l.pending))
+	for name := range l.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var req lokiPush
+	for _, name := range names {
+		stream := map[string]string{"server": name}
+		for k, v := range l.labels {
+			stream[k] = v
+		}
+		req.Streams = append(req.Streams, lokiStream{Stream: stream, Values: l.pending[name]})
+	}
+	l.pending = make(map[string][][2]string)
+	l.pendingLen = 0
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Warnf("Loki: encode batch: %v", err)
+		return nil
+	}
+	return body
+}
+
+func (l *LokiSink) push(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", l.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.cfg.TenantID)
+	}
+	if l.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+l.cfg.BearerToken)
+	} else if l.cfg.Username != "" {
+		req.SetBasicAuth(l.cfg.Username, l.cfg.Password)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (l *LokiSink) pushFailed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.backoff == 0 {
+		l.backoff = lokiMinBackoff
+	} else if l.backoff *= 2; l.backoff > lokiMaxBackoff {
+		l.backoff = lokiMaxBackoff
+	}
+	l.retryAt = time.Now().Add(l.backoff)
+	if !l.failing {
+		log.Warnf("Loki push to %s failed, spooling until it recovers: %v", l.pushURL, err)
+	} else {
+		log.Debugf("Loki push failed, retrying in %v: %v", l.backoff, err)
+	}
+	l.failing = true
+}
+
+func (l *LokiSink) pushSucceeded() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failing {
+		log.Infof("Loki push to %s recovered", l.pushURL)
+	}
+	l.failing = false
+	l.backoff = 0
+	l.retryAt = time.Time{}
+}
+
+// spool writes a batch Loki did not take to the spool directory and drops
+// the oldest spooled batches past the size limit.
+func (l *LokiSink) spool(body []byte) {
+	name := filepath.Join(l.spoolDir, strconv.FormatInt(time.Now().UnixNano(), 10)+".json")
+	if err := os.WriteFile(name, body, 0644); err != nil {
+		log.Warnf("Loki: spool batch: %v", err)
+		return
+	}
+	if l.spoolMax <= 0 {
+		return
+	}
+	files := l.spoolFiles()
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; i < len(files) && total > l.spoolMax; i++ {
+		os.Remove(files[i])
+		total -= sizes[i]
+		log.Warnf("Loki spool over %d MB, dropped %s", l.cfg.SpoolMaxMB, filepath.Base(files[i]))
+	}
+}
+
+// replaySpool pushes spooled batches oldest first, removing each once Loki
+// accepts it. It stops at the first failure.
+func (l *LokiSink) replaySpool(ctx context.Context) error {
+	files := l.spoolFiles()
+	for _, f := range files {
+		body, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		if err := l.push(ctx, body); err != nil {
+			return err
+		}
+		os.Remove(f)
+	}
+	if len(files) > 0 {
+		log.Infof("Loki: replayed %d spooled batches", len(files))
+	}
+	return nil
+}
+
+// spoolFiles lists spooled batches, oldest first.
+func (l *LokiSink) spoolFiles() []string {
+	entries, err := os.ReadDir(l.spoolDir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, filepath.Join(l.spoolDir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,306 @@
+package logs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// migrateWorkers is the number of files copied in parallel during migration.
+const migrateWorkers = 4
+
+// MigrationStatus reports the progress of a live log directory migration.
+type MigrationStatus struct {
+	State       string     `json:"state"` // copying, switching, cleaning, done, failed
+	Source      string     `json:"source"`
+	Dest        string     `json:"dest"`
+	RemoveOld   bool       `json:"removeOld"`
+	Files       int        `json:"files"`
+	Bytes       int64      `json:"bytes"`
+	CopiedFiles int        `json:"copiedFiles"`
+	CopiedBytes int64      `json:"copiedBytes"`
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Migration returns a copy of the current or last migration status, or nil.
+func (w *Writer) Migration() *MigrationStatus {
+	w.migMu.Lock()
+	defer w.migMu.Unlock()
+	if w.migration == nil {
+		return nil
+	}
+	c := *w.migration
+	return &c
+}
+
+// Migrate moves the log directory to newPath while the service keeps running.
+// Files are copied and checksum-verified in the background, then writes are
+// paused briefly to copy anything that changed, switch the writer over and
+// call onSwitch so other components can follow. With removeOld the old
+// directory is deleted afterwards.
+func (w *Writer) Migrate(newPath string, removeOld bool, onSwitch func(newPath string)) error {
+	src := w.BasePath()
+	dst, err := filepath.Abs(newPath)
+	if err != nil {
+		return err
+	}
+	if err := checkMigrationTarget(src, dst); err != nil {
+		return err
+	}
+
+	w.migMu.Lock()
+	if w.migration != nil && w.migration.FinishedAt == nil {
+		w.migMu.Unlock()
+		return fmt.Errorf("migration to %s already in progress", w.migration.Dest)
+	}
+	w.migration = &MigrationStatus{
+		State:     "copying",
+		Source:    src,
+		Dest:      dst,
+		RemoveOld: removeOld,
+		StartedAt: time.Now(),
+	}
+	w.migMu.Unlock()
+
+	go w.runMigration(src, dst, removeOld, onSwitch)
+	return nil
+}
+
+// checkMigrationTarget rejects destinations that overlap the source or
+// already contain data.
+func checkMigrationTarget(src, dst string) error {
+	srcAbs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	if dst == srcAbs {
+		return fmt.Errorf("destination is the current log path")
+	}
+	if strings.HasPrefix(dst, srcAbs+string(os.PathSeparator)) || strings.HasPrefix(srcAbs, dst+string(os.PathSeparator)) {
+		return fmt.Errorf("destination %s overlaps current log path %s", dst, srcAbs)
+	}
+	entries, err := os.ReadDir(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("destination %s is not empty", dst)
+	}
+	return nil
+}
+
+func (w *Writer) runMigration(src, dst string, removeOld bool, onSwitch func(string)) {
+	fail := func(err error) {
+		log.Errorf("Log migration to %s failed: %v", dst, err)
+		w.updateMigration(func(m *MigrationStatus) {
+			now := time.Now()
+			m.State = "failed"
+			m.Error = err.Error()
+			m.FinishedAt = &now
+		})
+	}
+
+	log.Infof("Migrating logs from %s to %s", src, dst)
+
+	// Bulk copy while writers keep running
+	if err := w.copyTree(src, dst, false, true); err != nil {
+		fail(err)
+		return
+	}
+
+	// Pause writes, copy what changed meanwhile, and switch over
+	w.updateMigration(func(m *MigrationStatus) { m.State = "switching" })
+	w.mu.Lock()
+	for _, f := range w.files {
+		f.Close()
+	}
+	w.files = make(map[string]*os.File)
+	w.closeRawFiles()
+	w.closeIndexFiles()
+	if err := w.copyTree(src, dst, true, false); err != nil {
+		w.mu.Unlock()
+		fail(err)
+		return
+	}
+	w.pathMu.Lock()
+	w.basePath = dst
+	w.pathMu.Unlock()
+	if onSwitch != nil {
+		onSwitch(dst)
+	}
+	w.mu.Unlock()
+	log.Infof("Log writer switched to %s", dst)
+
+	// Pick up state files other components saved to the old path before
+	// they switched; anything already rewritten at dst is newer and kept
+	if err := w.copyTree(src, dst, true, false); err != nil {
+		log.Warnf("Log migration final sync: %v", err)
+	}
+
+	if removeOld {
+		w.updateMigration(func(m *MigrationStatus) { m.State = "cleaning" })
+		if err := os.RemoveAll(src); err != nil {
+			log.Warnf("Failed to remove old log path %s: %v", src, err)
+		}
+	}
+
+	w.updateMigration(func(m *MigrationStatus) {
+		now := time.Now()
+		m.State = "done"
+		m.FinishedAt = &now
+	})
+	log.Infof("Log migration to %s complete", dst)
+}
+
+func (w *Writer) updateMigration(fn func(m *MigrationStatus)) {
+	w.migMu.Lock()
+	defer w.migMu.Unlock()
+	if w.migration != nil {
+		fn(w.migration)
+	}
+}
+
+// copyTree copies src into dst. When incremental, only files that are missing
+// at dst or newer/different at src are copied; since copies keep the source
+// modification time, files rewritten at dst after the switch are left alone.
+func (w *Writer) copyTree(src, dst string, incremental, verify bool) error {
+	var jobs []string
+	var total int64
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			// current.log points at a sibling file; recreate it as-is
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if existing, err := os.Readlink(target); err == nil && existing == link {
+				return nil
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if incremental {
+				if di, err := os.Stat(target); err == nil {
+					if di.ModTime().After(info.ModTime()) ||
+						(di.ModTime().Equal(info.ModTime()) && di.Size() == info.Size()) {
+						return nil
+					}
+				}
+			}
+			jobs = append(jobs, rel)
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !incremental {
+		w.updateMigration(func(m *MigrationStatus) {
+			m.Files = len(jobs)
+			m.Bytes = total
+		})
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	ch := make(chan string)
+	for i := 0; i < migrateWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range ch {
+				n, err := copyFile(filepath.Join(src, rel), filepath.Join(dst, rel), verify)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%s: %w", rel, err)
+					}
+					mu.Unlock()
+					continue
+				}
+				if !incremental {
+					w.updateMigration(func(m *MigrationStatus) {
+						m.CopiedFiles++
+						m.CopiedBytes += n
+					})
+				}
+			}
+		}()
+	}
+	for _, rel := range jobs {
+		ch <- rel
+	}
+	close(ch)
+	wg.Wait()
+	return firstErr
+}
+
+// copyFile copies one file, preserving mode and modification time. With
+// verify the copy is read back and compared by SHA-256.
+func copyFile(src, dst string, verify bool) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(out, io.TeeReader(in, h))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	os.Chtimes(dst, info.ModTime(), info.ModTime())
+
+	if verify {
+		check, err := os.Open(dst)
+		if err != nil {
+			return 0, err
+		}
+		h2 := sha256.New()
+		_, err = io.Copy(h2, check)
+		check.Close()
+		if err != nil {
+			return 0, err
+		}
+		if string(h.Sum(nil)) != string(h2.Sum(nil)) {
+			return 0, fmt.Errorf("checksum mismatch after copy")
+		}
+	}
+	return n, nil
+}
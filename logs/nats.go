@@ -0,0 +1,296 @@
+package logs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+	"ipmiserial/nats"
+)
+
+const (
+	// natsAckTimeout bounds waiting for JetStream to store a message.
+	natsAckTimeout = 5 * time.Second
+	// natsMaxBackoff caps the wait between connection attempts.
+	natsMaxBackoff = time.Minute
+)
+
+// NATS outputs, per server.
+const (
+	NATSCleaned = "cleaned"
+	NATSRaw     = "raw"
+	NATSBoth    = "both"
+	NATSNone    = "none"
+)
+
+// natsSubjectToken replaces characters that would split or wildcard a
+// subject token.
+var natsSubjectToken = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_")
+
+// NATSSink publishes console output to JetStream: cleaned lines to
+// <prefix>.<server>.cleaned and raw SOL output to <prefix>.<server>.raw,
+// each server getting the output chosen for it. Every message waits for
+// JetStream's acknowledgement, so a message counted as written is stored.
+// The stream is created on first connect if it doesn't exist. While NATS is
+// unreachable writes fail fast and reconnects back off up to a minute.
+type NATSSink struct {
+	cfg   config.NATSConfig
+	url   string // cfg.URL without credentials, for logging
+	opts  nats.Options
+	lines *LineBuffer // cleaned output only, fed from one goroutine
+
+	mu      sync.Mutex
+	conn    *nats.Conn
+	retryAt time.Time
+	backoff time.Duration
+	lastErr error
+}
+
+// NewNATSSink creates a sink for cfg. It connects on first use.
+func NewNATSSink(cfg config.NATSConfig) (*NATSSink, error) {
+	for _, out := range append([]string{cfg.Output}, mapValues(cfg.Servers)...) {
+		switch out {
+		case NATSCleaned, NATSRaw, NATSBoth, NATSNone:
+		default:
+			return nil, fmt.Errorf("nats output %q: want cleaned, raw, both or none", out)
+		}
+	}
+	if cfg.SubjectPrefix == "" || cfg.Stream == "" {
+		return nil, fmt.Errorf("nats subject_prefix and stream are required")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" && !cfg.InsecureSkipVerify {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("nats: read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("nats: no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	display := cfg.URL
+	if u, err := url.Parse(cfg.URL); err == nil {
+		u.User = nil
+		display = u.String()
+	}
+	return &NATSSink{
+		cfg: cfg,
+		url: display,
+		opts: nats.Options{
+			Name:     "ipmiserial",
+			User:     cfg.Username,
+			Password: cfg.Password,
+			Token:    cfg.Token,
+			TLS:      tlsConfig,
+		},
+		lines:   NewLineBuffer(),
+		backoff: time.Second,
+	}, nil
+}
+
+// URL returns the server URL without credentials.
+func (s *NATSSink) URL() string {
+	return s.url
+}
+
+// Wants reports whether any server gets input (NATSCleaned or NATSRaw), so
+// the sink is registered for it.
+func (s *NATSSink) Wants(input string) bool {
+	for _, out := range append([]string{s.cfg.Output}, mapValues(s.cfg.Servers)...) {
+		if out == input || out == NATSBoth {
+			return true
+		}
+	}
+	return false
+}
+
+// Raw returns the sink to register for raw SOL output (SinkRaw). The sink
+// itself takes cleaned output.
+func (s *NATSSink) Raw() Sink {
+	return natsRawSink{s}
+}
+
+type natsRawSink struct{ s *NATSSink }
+
+func (r natsRawSink) Write(serverName string, data []byte) error {
+	if !r.s.serverWants(serverName, NATSRaw) {
+		return nil
+	}
+	return r.s.publish(r.s.subject(serverName, NATSRaw), data)
+}
+
+// Write takes a chunk of a server's cleaned log output (a SinkCleaned sink)
+// and publishes each line it completes.
+func (s *NATSSink) Write(serverName string, data []byte) error {
+	lines := s.lines.Lines(serverName, data)
+	if !s.serverWants(serverName, NATSCleaned) {
+		return nil
+	}
+	subject := s.subject(serverName, NATSCleaned)
+	for _, line := range lines {
+		if err := s.publish(subject, []byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *NATSSink) serverWants(serverName, input string) bool {
+	out, ok := s.cfg.Servers[serverName]
+	if !ok {
+		out = s.cfg.Output
+	}
+	return out == input || out == NATSBoth
+}
+
+func (s *NATSSink) subject(serverName, input string) string {
+	return s.cfg.SubjectPrefix + "." + natsSubjectToken.Replace(serverName) + "." + input
+}
+
+// publish stores one message in JetStream.
+func (s *NATSSink) publish(subject string, data []byte) error {
+	conn, err := s.connection()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), natsAckTimeout)
+	defer cancel()
+	resp, err := conn.Request(ctx, subject, data)
+	if err != nil {
+		return err
+	}
+	var ack struct {
+		Error *natsError `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &ack); err != nil {
+		return fmt.Errorf("nats: bad publish ack: %w", err)
+	}
+	if ack.Error != nil {
+		return ack.Error
+	}
+	return nil
+}
+
+// connection returns the live connection, dialling (and making sure the
+// stream exists) if there is none and the backoff allows.
+func (s *NATSSink) connection() (*nats.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		select {
+		case <-s.conn.Done():
+			log.Warnf("NATS: connection to %s lost: %v", s.url, s.conn.Err())
+			s.conn = nil
+		default:
+			return s.conn, nil
+		}
+	}
+	if time.Now().Before(s.retryAt) {
+		return nil, s.lastErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := nats.Dial(ctx, s.cfg.URL, s.opts)
+	if err == nil {
+		if err = s.ensureStream(ctx, conn); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		s.lastErr = err
+		s.retryAt = time.Now().Add(s.backoff)
+		s.backoff = min(s.backoff*2, natsMaxBackoff)
+		return nil, err
+	}
+	s.conn, s.backoff = conn, time.Second
+	log.Infof("NATS: connected to %s, publishing to stream %s", s.url, s.cfg.Stream)
+	return conn, nil
+}
+
+// natsError is a JetStream API error.
+type natsError struct {
+	Code        int    `json:"code"`
+	ErrCode     int    `json:"err_code"`
+	Description string `json:"description"`
+}
+
+func (e *natsError) Error() string {
+	return fmt.Sprintf("jetstream: %s (%d)", e.Description, e.Code)
+}
+
+// ensureStream creates the stream, capturing <prefix>.>, unless it exists.
+// An existing stream is left as configured.
+func (s *NATSSink) ensureStream(ctx context.Context, conn *nats.Conn) error {
+	var info struct {
+		Error *natsError `json:"error"`
+	}
+	resp, err := conn.Request(ctx, "$JS.API.STREAM.INFO."+s.cfg.Stream, nil)
+	if err != nil {
+		return fmt.Errorf("%w (is JetStream enabled?)", err)
+	}
+	if err := json.Unmarshal(resp, &info); err != nil {
+		return fmt.Errorf("nats: bad stream info: %w", err)
+	}
+	if info.Error == nil {
+		return nil
+	}
+	if info.Error.Code != 404 {
+		return info.Error
+	}
+
+	maxBytes := int64(-1)
+	if s.cfg.MaxMB > 0 {
+		maxBytes = int64(s.cfg.MaxMB) << 20
+	}
+	replicas := s.cfg.Replicas
+	if replicas <= 0 {
+		replicas = 1
+	}
+	req, _ := json.Marshal(map[string]interface{}{
+		"name":         s.cfg.Stream,
+		"subjects":     []string{s.cfg.SubjectPrefix + ".>"},
+		"retention":    "limits",
+		"storage":      "file",
+		"discard":      "old",
+		"max_age":      s.cfg.MaxAge.Nanoseconds(),
+		"max_bytes":    maxBytes,
+		"max_msgs":     -1,
+		"num_replicas": replicas,
+	})
+	resp, err = conn.Request(ctx, "$JS.API.STREAM.CREATE."+s.cfg.Stream, req)
+	if err != nil {
+		return err
+	}
+	var created struct {
+		Error *natsError `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &created); err != nil {
+		return fmt.Errorf("nats: bad stream create response: %w", err)
+	}
+	if created.Error != nil {
+		return created.Error
+	}
+	log.Infof("NATS: created stream %s for %s.>", s.cfg.Stream, s.cfg.SubjectPrefix)
+	return nil
+}
+
+func mapValues(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,248 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogQuery selects lines from a server's logs, oldest first, across rotated
+// files. Since and Until select lines by the time index written with each
+// log, to the second. Logs written before indexes existed are selected as
+// whole files: those whose span, from the start time in the file name to
+// the last write, overlaps the range.
+type LogQuery struct {
+	Since      time.Time // zero = from the oldest log
+	Until      time.Time // zero = up to now
+	Grep       string    // only lines containing this ("" = all)
+	Regex      bool
+	IgnoreCase bool
+	Cursor     string // NextCursor of the previous page
+	Limit      int
+}
+
+// LogRecord is one line of a LogQuery result.
+type LogRecord struct {
+	File      string     `json:"file"`
+	Line      int        `json:"line"`
+	Text      string     `json:"text"`
+	Time      *time.Time `json:"time,omitempty"`      // when the line was written, from the time index
+	FileStart *time.Time `json:"fileStart,omitempty"` // from the log file name
+	FileEnd   time.Time  `json:"fileEnd"`             // last modification of the log file
+}
+
+// LogQueryResult is a page of records. NextCursor is set when there are
+// more.
+type LogQueryResult struct {
+	Server     string      `json:"server"`
+	Records    []LogRecord `json:"records"`
+	Files      int         `json:"files"` // files in the time range
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+type queryFile struct {
+	name  string
+	start *time.Time
+	end   time.Time
+	index timeIndex // nil = no time index
+}
+
+// Query returns the lines of serverName's logs selected by q.
+func (w *Writer) Query(ctx context.Context, serverName string, q LogQuery) (*LogQueryResult, error) {
+	if serverName == "" || strings.ContainsAny(serverName, `/\`) || serverName == ".." {
+		return nil, fmt.Errorf("invalid server name %q", serverName)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultSearchLimit
+	}
+	if q.Limit > maxSearchLimit {
+		q.Limit = maxSearchLimit
+	}
+	match := func(string) bool { return true }
+	if q.Grep != "" {
+		sq := SearchQuery{Pattern: q.Grep, Regex: q.Regex, IgnoreCase: q.IgnoreCase}
+		m, err := sq.compile()
+		if err != nil {
+			return nil, err
+		}
+		match = m
+	}
+	afterFile, afterLine, err := parseCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := w.queryFiles(serverName, q.Since, q.Until)
+	if err != nil {
+		return nil, err
+	}
+	result := &LogQueryResult{Server: serverName, Records: []LogRecord{}, Files: len(files)}
+
+	// Resume after the cursor's file; a cursor for a file that has since
+	// been pruned resumes from the oldest file still there
+	if afterFile != "" {
+		for i, f := range files {
+			if f.name == afterFile {
+				files = files[i:]
+				break
+			}
+		}
+	}
+
+	for _, f := range files {
+		skip := 0
+		if f.name == afterFile {
+			skip = afterLine
+		}
+		more, err := w.queryFile(ctx, serverName, f, skip, match, q.Since, q.Until, q.Limit, result)
+		if err != nil {
+			return nil, err
+		}
+		if more {
+			last := result.Records[len(result.Records)-1]
+			result.NextCursor = fmt.Sprintf("%s:%d", last.File, last.Line)
+			break
+		}
+	}
+	return result, ctx.Err()
+}
+
+// queryFiles lists a server's logs overlapping [since, until], oldest first.
+func (w *Writer) queryFiles(serverName string, since, until time.Time) ([]queryFile, error) {
+	names, err := w.ListLogs(serverName)
+	if err != nil {
+		return nil, err
+	}
+	var files []queryFile
+	for _, name := range names {
+		path, _, err := w.ResolveLogPath(serverName, name)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		f := queryFile{name: name, start: logStartTime(name), end: info.ModTime()}
+		f.index = readTimeIndex(filepath.Join(filepath.Dir(path), IndexName(name)))
+		if !since.IsZero() && f.end.Before(since) {
+			continue
+		}
+		if !until.IsZero() && f.start != nil && f.start.After(until) {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].begin().Before(files[j].begin())
+	})
+	return files, nil
+}
+
+// begin is when a file started, or its last write for custom names.
+func (f queryFile) begin() time.Time {
+	if f.start != nil {
+		return *f.start
+	}
+	return f.end
+}
+
+// queryFile appends f's matching lines after line skip, and within [since,
+// until] when f has a time index, to result, reporting whether a match
+// beyond limit exists.
+func (w *Writer) queryFile(ctx context.Context, serverName string, f queryFile, skip int, match func(string) bool, since, until time.Time, limit int, result *LogQueryResult) (bool, error) {
+	path, compressed, err := w.ResolveLogPath(serverName, f.name)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if compressed {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return false, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	// Lines older than the index, in a log continued across an upgrade,
+	// date from the file's start
+	var start time.Time
+	if f.index != nil {
+		start = f.index[0].at
+		if f.start != nil {
+			start = *f.start
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if lineNo%4096 == 0 && ctx.Err() != nil {
+			return false, nil
+		}
+		if lineNo <= skip {
+			continue
+		}
+		var at *time.Time
+		if f.index != nil {
+			t := f.index.lineTime(lineNo, start)
+			// Lines are in time order, so nothing after one past until
+			// matches
+			if !until.IsZero() && t.After(until) {
+				break
+			}
+			if !since.IsZero() && t.Before(since.Truncate(time.Second)) {
+				continue
+			}
+			at = &t
+		}
+		if !match(scanner.Text()) {
+			continue
+		}
+		if len(result.Records) == limit {
+			return true, nil
+		}
+		result.Records = append(result.Records, LogRecord{
+			File:      f.name,
+			Line:      lineNo,
+			Text:      scanner.Text(),
+			Time:      at,
+			FileStart: f.start,
+			FileEnd:   f.end,
+		})
+	}
+	return false, scanner.Err()
+}
+
+// parseCursor splits a NextCursor into the file and line it points at.
+func parseCursor(cursor string) (string, int, error) {
+	if cursor == "" {
+		return "", 0, nil
+	}
+	i := strings.LastIndexByte(cursor, ':')
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	line, err := strconv.Atoi(cursor[i+1:])
+	if err != nil || line < 0 {
+		return "", 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return cursor[:i], line, nil
+}
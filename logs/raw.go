@@ -0,0 +1,76 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Raw capture keeps the untouched SOL byte stream next to each cleaned log,
+// as <name>.raw with a current.raw symlink, so nothing the cleaning
+// heuristics strip is ever lost. Raw files rotate, compress and expire with
+// their log.
+
+// RawName returns the raw capture file paired with a log file name.
+func RawName(logName string) string {
+	return strings.TrimSuffix(filepath.Base(logName), ".log") + ".raw"
+}
+
+// isRawFile reports whether name is a plain or gzip-compressed raw capture.
+func isRawFile(name string) bool {
+	return strings.HasSuffix(name, ".raw") || strings.HasSuffix(name, ".raw.gz")
+}
+
+// SetRawCapture turns raw capture on or off for subsequent writes.
+func (w *Writer) SetRawCapture(enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rawCapture = enabled
+	if !enabled {
+		w.closeRawFiles()
+	}
+}
+
+// writeRaw appends data to the raw file paired with the current log. Must be
+// called with w.mu held, after the current log file has been opened.
+func (w *Writer) writeRaw(serverName string, data []byte) error {
+	f, exists := w.rawFiles[serverName]
+	if !exists {
+		dir := filepath.Join(w.basePath, serverName)
+		target, err := os.Readlink(filepath.Join(dir, "current.log"))
+		if err != nil {
+			return fmt.Errorf("no current log for raw capture: %w", err)
+		}
+		name := RawName(target)
+		f, err = os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create raw capture file: %w", err)
+		}
+		symlinkPath := filepath.Join(dir, "current.raw")
+		if existing, err := os.Readlink(symlinkPath); err != nil || existing != name {
+			os.Remove(symlinkPath)
+			os.Symlink(name, symlinkPath)
+		}
+		w.rawFiles[serverName] = f
+	}
+	_, err := f.Write(data)
+	return err
+}
+
+// closeRaw closes a server's raw file so the next write follows the current
+// log. Must be called with w.mu held.
+func (w *Writer) closeRaw(serverName string) {
+	if f, exists := w.rawFiles[serverName]; exists {
+		f.Close()
+		delete(w.rawFiles, serverName)
+	}
+}
+
+// closeRawFiles closes all raw files. Must be called with w.mu held.
+func (w *Writer) closeRawFiles() {
+	for _, f := range w.rawFiles {
+		f.Close()
+	}
+	w.rawFiles = make(map[string]*os.File)
+}
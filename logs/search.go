@@ -0,0 +1,274 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Search limits
+const (
+	defaultSearchLimit = 500
+	maxSearchLimit     = 10000
+	maxSearchContext   = 10
+	searchWorkers      = 4
+)
+
+// SearchQuery describes a full-text search over stored logs.
+type SearchQuery struct {
+	Pattern    string
+	Regex      bool
+	IgnoreCase bool
+	Context    int // lines of context before and after each match
+	Limit      int // maximum matches returned
+}
+
+// SearchMatch is one matching line with its location and surrounding lines.
+type SearchMatch struct {
+	Server    string     `json:"server"`
+	File      string     `json:"file"`
+	Line      int        `json:"line"`
+	Text      string     `json:"text"`
+	Before    []string   `json:"before,omitempty"`
+	After     []string   `json:"after,omitempty"`
+	FileStart *time.Time `json:"fileStart,omitempty"` // from the log file name
+	FileEnd   time.Time  `json:"fileEnd"`             // last modification of the log file
+}
+
+// SearchResult is the response for a search across one or more servers.
+type SearchResult struct {
+	Query     string        `json:"query"`
+	Matches   []SearchMatch `json:"matches"`
+	Files     int           `json:"filesSearched"`
+	Truncated bool          `json:"truncated"`
+}
+
+// compile turns the query into a line matcher.
+func (q *SearchQuery) compile() (func(string) bool, error) {
+	if q.Pattern == "" {
+		return nil, fmt.Errorf("empty search pattern")
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultSearchLimit
+	}
+	if q.Limit > maxSearchLimit {
+		q.Limit = maxSearchLimit
+	}
+	if q.Context < 0 {
+		q.Context = 0
+	}
+	if q.Context > maxSearchContext {
+		q.Context = maxSearchContext
+	}
+
+	if q.Regex {
+		expr := q.Pattern
+		if q.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		return re.MatchString, nil
+	}
+	if q.IgnoreCase {
+		needle := strings.ToLower(q.Pattern)
+		return func(line string) bool { return strings.Contains(strings.ToLower(line), needle) }, nil
+	}
+	return func(line string) bool { return strings.Contains(line, q.Pattern) }, nil
+}
+
+// Search greps all stored logs of one server, newest file first.
+func (w *Writer) Search(ctx context.Context, serverName string, q SearchQuery) (*SearchResult, error) {
+	return w.SearchServers(ctx, []string{serverName}, q)
+}
+
+// SearchServers greps the logs of several servers in parallel. Matches are
+// ordered by server, then newest file first, then line number.
+func (w *Writer) SearchServers(ctx context.Context, servers []string, q SearchQuery) (*SearchResult, error) {
+	match, err := q.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	type fileRef struct {
+		order  int
+		server string
+		file   string
+	}
+	var refs []fileRef
+	for _, server := range servers {
+		if server == "" || strings.ContainsAny(server, `/\`) || server == ".." {
+			continue
+		}
+		files, err := w.ListLogs(server)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			refs = append(refs, fileRef{order: len(refs), server: server, file: f})
+		}
+	}
+
+	result := &SearchResult{Query: q.Pattern, Files: len(refs)}
+	perFile := make([][]SearchMatch, len(refs))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		found int
+		wg    sync.WaitGroup
+	)
+	ch := make(chan fileRef)
+	for i := 0; i < searchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range ch {
+				matches, _ := w.searchFile(ctx, ref.server, ref.file, match, q)
+				mu.Lock()
+				perFile[ref.order] = matches
+				found += len(matches)
+				if found >= q.Limit {
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, ref := range refs {
+		select {
+		case ch <- ref:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	for _, m := range perFile {
+		result.Matches = append(result.Matches, m...)
+	}
+	if len(result.Matches) > q.Limit {
+		result.Matches = result.Matches[:q.Limit]
+		result.Truncated = true
+	} else if found >= q.Limit {
+		result.Truncated = true
+	}
+	if result.Matches == nil {
+		result.Matches = []SearchMatch{}
+	}
+	return result, nil
+}
+
+// searchFile scans one log file line by line, keeping a small ring of
+// preceding lines for context.
+func (w *Writer) searchFile(ctx context.Context, serverName, filename string, match func(string) bool, q SearchQuery) ([]SearchMatch, error) {
+	path, compressed, err := w.ResolveLogPath(serverName, filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = f
+	if compressed {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	fileStart := logStartTime(filename)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		matches []SearchMatch
+		before  []string
+		pending []int // indexes into matches still collecting "after" lines
+		lineNo  int
+	)
+	for scanner.Scan() {
+		lineNo++
+		if lineNo%4096 == 0 && ctx.Err() != nil {
+			break
+		}
+		line := scanner.Text()
+
+		// Feed trailing context to earlier matches
+		if len(pending) > 0 {
+			kept := pending[:0]
+			for _, i := range pending {
+				matches[i].After = append(matches[i].After, line)
+				if len(matches[i].After) < q.Context {
+					kept = append(kept, i)
+				}
+			}
+			pending = kept
+		}
+
+		if match(line) {
+			m := SearchMatch{
+				Server:    serverName,
+				File:      filename,
+				Line:      lineNo,
+				Text:      line,
+				FileStart: fileStart,
+				FileEnd:   info.ModTime(),
+			}
+			if len(before) > 0 {
+				m.Before = append([]string{}, before...)
+			}
+			matches = append(matches, m)
+			if q.Context > 0 {
+				pending = append(pending, len(matches)-1)
+			}
+			if len(matches) >= q.Limit {
+				break
+			}
+		}
+
+		if q.Context > 0 {
+			before = append(before, line)
+			if len(before) > q.Context {
+				before = before[1:]
+			}
+		}
+	}
+	return matches, scanner.Err()
+}
+
+// logStartTime parses the timestamp generated log names start with. Custom
+// rotation names have none.
+func logStartTime(filename string) *time.Time {
+	const layout = "2006-01-02_15-04-05"
+	if len(filename) < len(layout) {
+		return nil
+	}
+	t, err := time.ParseInLocation(layout, filename[:len(layout)], time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,288 @@
+package logs
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+// defaultSinkQueue is how many chunks a sink buffers before new ones are
+// dropped.
+const defaultSinkQueue = 1024
+
+// Sink receives console output for a server, e.g. to ship it off the box.
+// Each registered sink is fed from its own goroutine, one chunk at a time.
+type Sink interface {
+	Write(serverName string, data []byte) error
+}
+
+// SinkInput selects what a sink is fed.
+type SinkInput string
+
+const (
+	SinkRaw     SinkInput = "raw"     // SOL output as received
+	SinkCleaned SinkInput = "cleaned" // text appended to current.log, ANSI stripped and deduplicated
+)
+
+// SinkStatus reports a registered sink's delivery counters.
+type SinkStatus struct {
+	Name      string    `json:"name"`
+	Input     SinkInput `json:"input"`
+	Enabled   bool      `json:"enabled"`
+	Queued    int       `json:"queued"`  // chunks waiting
+	Written   uint64    `json:"written"` // chunks delivered
+	Dropped   uint64    `json:"dropped"` // chunks dropped because the queue was full
+	Errors    uint64    `json:"errors"`  // chunks the sink failed to take
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// SinkRegistry fans console output out from the log file writer to any
+// number of registered sinks. The file writer stays synchronous, since the
+// API, rotation and reboot detection read what it writes; every other sink
+// has its own queue and goroutine, so a slow or failing sink drops its own
+// chunks without holding up the console or the other sinks. It satisfies
+// sol.LogWriter.
+type SinkRegistry struct {
+	file *Writer
+
+	mu     sync.RWMutex
+	sinks  []*sinkEntry
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type sinkEntry struct {
+	name    string
+	sink    Sink
+	input   SinkInput
+	ch      chan sinkChunk
+	enabled atomic.Bool
+	written atomic.Uint64
+	dropped atomic.Uint64
+	errors  atomic.Uint64
+
+	errMu   sync.Mutex
+	lastErr string
+	failing bool
+}
+
+type sinkChunk struct {
+	server string
+	data   []byte
+}
+
+// NewSinkRegistry creates a registry around the log file writer.
+func NewSinkRegistry(file *Writer) *SinkRegistry {
+	r := &SinkRegistry{file: file}
+	file.OnCleaned(func(serverName string, data []byte) {
+		r.dispatch(SinkCleaned, serverName, data)
+	})
+	return r
+}
+
+// Register adds a sink fed with input. cfg sets its queue size and whether
+// it starts enabled. Register sinks before console output starts.
+func (r *SinkRegistry) Register(name string, s Sink, input SinkInput, cfg config.SinkConfig) {
+	queue := cfg.QueueSize
+	if queue <= 0 {
+		queue = defaultSinkQueue
+	}
+	e := &sinkEntry{name: name, sink: s, input: input, ch: make(chan sinkChunk, queue)}
+	e.enabled.Store(cfg.Enabled == nil || *cfg.Enabled)
+
+	r.mu.Lock()
+	r.sinks = append(r.sinks, e)
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for c := range e.ch {
+			e.deliver(c)
+		}
+	}()
+	log.Infof("Log sink %s registered (%s output, queue %d, enabled %v)", name, input, queue, e.enabled.Load())
+}
+
+// SetConfig applies enable flags from the logs.sinks config, e.g. on
+// reload. Sinks not mentioned are enabled.
+func (r *SinkRegistry) SetConfig(cfgs map[string]config.SinkConfig) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.sinks {
+		cfg := cfgs[e.name]
+		enabled := cfg.Enabled == nil || *cfg.Enabled
+		if e.enabled.Swap(enabled) == enabled {
+			continue
+		}
+		if enabled {
+			log.Infof("Log sink %s enabled", e.name)
+		} else {
+			log.Infof("Log sink %s disabled", e.name)
+		}
+	}
+}
+
+// Status returns every registered sink's counters, sorted by name.
+func (r *SinkRegistry) Status() []SinkStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]SinkStatus, 0, len(r.sinks))
+	for _, e := range r.sinks {
+		e.errMu.Lock()
+		lastErr := e.lastErr
+		e.errMu.Unlock()
+		out = append(out, SinkStatus{
+			Name:      e.name,
+			Input:     e.input,
+			Enabled:   e.enabled.Load(),
+			Queued:    len(e.ch),
+			Written:   e.written.Load(),
+			Dropped:   e.dropped.Load(),
+			Errors:    e.errors.Load(),
+			LastError: lastErr,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Write writes SOL output to the log file, then queues it for raw sinks.
+// Cleaned sinks are fed by the file writer as it appends to current.log.
+func (r *SinkRegistry) Write(serverName string, data []byte) error {
+	err := r.file.Write(serverName, data)
+	r.dispatch(SinkRaw, serverName, data)
+	return err
+}
+
+// WriteNote adds an annotation to the log file only.
+func (r *SinkRegistry) WriteNote(serverName string, data []byte) error {
+	return r.file.WriteNote(serverName, data)
+}
+
+// Rotate rotates the server's log file.
+func (r *SinkRegistry) Rotate(serverName string) error {
+	return r.file.Rotate(serverName)
+}
+
+// CanRotate reports whether the server's log file may be rotated.
+func (r *SinkRegistry) CanRotate(serverName string) bool {
+	return r.file.CanRotate(serverName)
+}
+
+// Position returns where the server's next output goes in its log file.
+func (r *SinkRegistry) Position(serverName string) (file string, offset int64, line int) {
+	return r.file.Position(serverName)
+}
+
+// Close stops feeding the sinks and waits up to timeout for their queues
+// to drain.
+func (r *SinkRegistry) Close(timeout time.Duration) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	for _, e := range r.sinks {
+		close(e.ch)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("Log sinks did not drain before shutdown")
+	}
+}
+
+// dispatch queues a chunk for every enabled sink taking input, dropping it
+// for sinks whose queue is full. It never blocks.
+func (r *SinkRegistry) dispatch(input SinkInput, serverName string, data []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	var chunk []byte
+	for _, e := range r.sinks {
+		if e.input != input {
+			continue
+		}
+		if !e.enabled.Load() {
+			continue
+		}
+		if chunk == nil {
+			chunk = append([]byte(nil), data...) // callers reuse their buffers
+		}
+		select {
+		case e.ch <- sinkChunk{serverName, chunk}:
+		default:
+			if e.dropped.Add(1) == 1 {
+				log.Warnf("Log sink %s is falling behind, dropping console output", e.name)
+			}
+		}
+	}
+}
+
+func (e *sinkEntry) deliver(c sinkChunk) {
+	err := e.sink.Write(c.server, c.data)
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	if err != nil {
+		e.errors.Add(1)
+		e.lastErr = err.Error()
+		if !e.failing {
+			log.Warnf("Log sink %s failed: %v", e.name, err)
+		}
+		e.failing = true
+		return
+	}
+	e.written.Add(1)
+	if e.failing {
+		log.Infof("Log sink %s recovered", e.name)
+	}
+	e.failing = false
+}
+
+// LineBuffer assembles a sink's chunks into complete lines per server. It
+// is not safe for concurrent use; a sink is fed from one goroutine.
+type LineBuffer struct {
+	partial map[string][]byte // unterminated last line per server
+}
+
+func NewLineBuffer() *LineBuffer {
+	return &LineBuffer{partial: make(map[string][]byte)}
+}
+
+// Lines appends data to the server's partial line and returns the lines it
+// completes, trailing whitespace trimmed and blank lines skipped.
+func (b *LineBuffer) Lines(serverName string, data []byte) []string {
+	buf := append(b.partial[serverName], data...)
+	var out []string
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(buf[:i]), " \t\r")
+		buf = buf[i+1:]
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	b.partial[serverName] = append([]byte(nil), buf...)
+	return out
+}
@@ -0,0 +1,96 @@
+package logs
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"ipmiserial/config"
+)
+
+// syslogFacilities maps facility names to RFC 5424 facility codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverityInfo is the severity of every console line.
+const syslogSeverityInfo = 6
+
+// SyslogSink forwards cleaned console lines to a syslog server, one RFC 5424
+// message per line with the console's server name as HOSTNAME. TCP uses
+// octet-counted framing (RFC 6587). A failed write drops the connection,
+// which is redialled on the next line.
+type SyslogSink struct {
+	network string
+	addr    string
+	pri     int
+	tag     string
+	lines   *LineBuffer
+	conn    net.Conn
+}
+
+// NewSyslogSink creates a sink for cfg.
+func NewSyslogSink(cfg config.SyslogConfig) (*SyslogSink, error) {
+	u, err := url.Parse(cfg.Address)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("syslog address %q: want udp://host:port or tcp://host:port", cfg.Address)
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("syslog address %q: unsupported scheme %q", cfg.Address, u.Scheme)
+	}
+	facility, ok := syslogFacilities[strings.ToLower(cfg.Facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "ipmiserial"
+	}
+	return &SyslogSink{
+		network: u.Scheme,
+		addr:    u.Host,
+		pri:     facility*8 + syslogSeverityInfo,
+		tag:     tag,
+		lines:   NewLineBuffer(),
+	}, nil
+}
+
+// Write takes a chunk of a server's cleaned log output (a SinkCleaned sink)
+// and sends each line it completes. The registry calls it from one
+// goroutine.
+func (s *SyslogSink) Write(serverName string, data []byte) error {
+	for _, line := range s.lines.Lines(serverName, data) {
+		if err := s.send(serverName, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) send(serverName, line string) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		s.pri, time.Now().UTC().Format(time.RFC3339Nano), serverName, s.tag, os.Getpid(), line)
+	if s.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
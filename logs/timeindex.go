@@ -0,0 +1,116 @@
+package logs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log lines carry no timestamps of their own, so each log file gets a time
+// index, <name>.idx, written as the log is. Each entry is "<lines> <unix
+// seconds>": the lines after the first <lines> were written from then on.
+// An entry is added at most once a second, so a line's time is known to
+// the second. Indexes rotate and expire with their log; logs written before
+// indexes existed have none.
+
+// indexInterval is the least time between two entries of a time index.
+const indexInterval = time.Second
+
+// IndexName returns the time index paired with a log file name.
+func IndexName(logName string) string {
+	return strings.TrimSuffix(filepath.Base(logName), ".log") + ".idx"
+}
+
+// isIndexFile reports whether name is a time index.
+func isIndexFile(name string) bool {
+	return strings.HasSuffix(name, ".idx")
+}
+
+// indexTime records in the time index of f, the server's current log, that
+// what is written next is written now. Must be called with w.mu held, just
+// before writing to f.
+func (w *Writer) indexTime(serverName string, f *os.File) {
+	now := time.Now()
+	if last, ok := w.idxLast[serverName]; ok && now.Sub(last) < indexInterval {
+		return
+	}
+	idx, exists := w.idxFiles[serverName]
+	if !exists {
+		var err error
+		idx, err = os.OpenFile(filepath.Join(filepath.Dir(f.Name()), IndexName(f.Name())), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+		w.idxFiles[serverName] = idx
+	}
+	fmt.Fprintf(idx, "%d %d\n", w.lines[serverName], now.Unix())
+	w.idxLast[serverName] = now
+}
+
+// closeIndex closes a server's time index so the next write starts the
+// index of the current log. Must be called with w.mu held.
+func (w *Writer) closeIndex(serverName string) {
+	if f, exists := w.idxFiles[serverName]; exists {
+		f.Close()
+		delete(w.idxFiles, serverName)
+	}
+	delete(w.idxLast, serverName)
+}
+
+// closeIndexFiles closes all time indexes. Must be called with w.mu held.
+func (w *Writer) closeIndexFiles() {
+	for _, f := range w.idxFiles {
+		f.Close()
+	}
+	w.idxFiles = make(map[string]*os.File)
+	w.idxLast = make(map[string]time.Time)
+}
+
+// timeIndex is a loaded time index, entries in line order.
+type timeIndex []indexEntry
+
+type indexEntry struct {
+	lines int
+	at    time.Time
+}
+
+// readTimeIndex loads the time index at path; nil if there is none.
+func readTimeIndex(path string) timeIndex {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var idx timeIndex
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines, secs, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		n, err1 := strconv.Atoi(lines)
+		s, err2 := strconv.ParseInt(secs, 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		idx = append(idx, indexEntry{lines: n, at: time.Unix(s, 0)})
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return idx[i].lines < idx[j].lines })
+	return idx
+}
+
+// lineTime returns when line (from 1) was written: the time of the last
+// entry before it, else fallback for lines older than the index.
+func (idx timeIndex) lineTime(line int, fallback time.Time) time.Time {
+	i := sort.Search(len(idx), func(i int) bool { return idx[i].lines >= line })
+	if i == 0 {
+		return fallback
+	}
+	return idx[i-1].at
+}
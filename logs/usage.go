@@ -0,0 +1,157 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerUsage is the disk space one server's log directory takes.
+type ServerUsage struct {
+	Name   string     `json:"name"`
+	Bytes  int64      `json:"bytes"`
+	Files  int        `json:"files"`
+	Oldest *time.Time `json:"oldest,omitempty"` // oldest rotated log
+}
+
+// Usage reports disk usage under the log path against logs.max_total_size_gb.
+type Usage struct {
+	TotalBytes  int64         `json:"totalBytes"`
+	LimitBytes  int64         `json:"limitBytes"` // 0 = no quota
+	Servers     []ServerUsage `json:"servers"`
+	PrunedFiles uint64        `json:"prunedFiles"` // rotated logs deleted to stay within the quota since startup
+	PrunedBytes uint64        `json:"prunedBytes"`
+	LastPrune   *time.Time    `json:"lastPrune,omitempty"`
+}
+
+// quotaFile is a rotated log the quota may delete.
+type quotaFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// SetMaxTotalSize sets the disk budget for all logs, in bytes; 0 disables
+// quota pruning.
+func (w *Writer) SetMaxTotalSize(bytes int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.maxTotalSize = bytes
+}
+
+// Usage walks the log directory and returns per-server and total usage.
+func (w *Writer) Usage() Usage {
+	servers, _ := w.scanUsage()
+	w.mu.Lock()
+	u := w.quota
+	u.LimitBytes = w.maxTotalSize
+	w.mu.Unlock()
+	u.Servers = servers
+	for _, s := range servers {
+		u.TotalBytes += s.Bytes
+	}
+	return u
+}
+
+// scanUsage returns the usage of every server directory, by name, and the
+// rotated logs in them that pruning may remove, oldest first. Files
+// current.log and current.raw point at, and the current log's time index,
+// are never candidates.
+func (w *Writer) scanUsage() ([]ServerUsage, []quotaFile) {
+	basePath := w.BasePath()
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return nil, nil
+	}
+
+	servers := make([]ServerUsage, 0, len(entries))
+	var prunable []quotaFile
+	for _, serverDir := range entries {
+		if !serverDir.IsDir() {
+			continue
+		}
+		serverPath := filepath.Join(basePath, serverDir.Name())
+		current, _ := os.Readlink(filepath.Join(serverPath, "current.log"))
+		currentRaw, _ := os.Readlink(filepath.Join(serverPath, "current.raw"))
+
+		su := ServerUsage{Name: serverDir.Name()}
+		filepath.WalkDir(serverPath, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			su.Bytes += info.Size()
+			su.Files++
+
+			name := d.Name()
+			if filepath.Dir(path) != serverPath || !(isLogFile(name) || isRawFile(name) || isIndexFile(name)) ||
+				name == current || name == currentRaw || (current != "" && name == IndexName(current)) {
+				return nil
+			}
+			if mt := info.ModTime(); su.Oldest == nil || mt.Before(*su.Oldest) {
+				su.Oldest = &mt
+			}
+			prunable = append(prunable, quotaFile{path: path, size: info.Size(), modTime: info.ModTime()})
+			return nil
+		})
+		servers = append(servers, su)
+	}
+
+	sort.Slice(prunable, func(i, j int) bool { return prunable[i].modTime.Before(prunable[j].modTime) })
+	return servers, prunable
+}
+
+// EnforceQuota deletes the oldest rotated logs, across all servers, until
+// the log directory fits in the configured budget. It returns the number of
+// files removed.
+func (w *Writer) EnforceQuota() int {
+	w.mu.Lock()
+	limit := w.maxTotalSize
+	w.mu.Unlock()
+	if limit <= 0 {
+		return 0
+	}
+
+	servers, prunable := w.scanUsage()
+	var total int64
+	for _, s := range servers {
+		total += s.Bytes
+	}
+	if total <= limit {
+		return 0
+	}
+
+	removed := 0
+	var freed int64
+	for _, f := range prunable {
+		if total <= limit {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Warnf("Quota: failed to remove %s: %v", f.path, err)
+			continue
+		}
+		log.Infof("Quota: removed %s (%d bytes, from %s)", f.path, f.size, f.modTime.Format(time.RFC3339))
+		total -= f.size
+		freed += f.size
+		removed++
+	}
+	if total > limit && len(prunable) > 0 {
+		log.Warnf("Quota: logs still take %d bytes of a %d byte budget with no rotated logs left to prune", total, limit)
+	}
+	if removed > 0 {
+		w.mu.Lock()
+		w.quota.PrunedFiles += uint64(removed)
+		w.quota.PrunedBytes += uint64(freed)
+		now := time.Now()
+		w.quota.LastPrune = &now
+		w.mu.Unlock()
+	}
+	return removed
+}
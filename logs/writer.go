@@ -5,49 +5,23 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-)
-
-// Cursor position pattern - matches all cursor positioning sequences
-var cursorPosRegex = regexp.MustCompile(`\x1b\[\d+;\d*[Hf]|\x1b\[\d+[Hf]`)
-
-// cleanCursorPositions converts row-start cursor positions (col 0 or 1) to newlines
-// and strips mid-row positions (col > 1). This prevents fragments like "<F1>" or
-// "ontroller Drivers...Done" from appearing as separate lines when the BMC uses
-// cursor positioning within the same row (e.g. \x1b[05;35H<F1>).
-func cleanCursorPositions(data []byte) []byte {
-	return cursorPosRegex.ReplaceAllFunc(data, func(match []byte) []byte {
-		semi := bytes.IndexByte(match, ';')
-		if semi == -1 {
-			// No column (e.g. \x1b[5H) → row start
-			return []byte("\n")
-		}
-		// Parse column between ';' and final letter
-		col := 0
-		for _, c := range match[semi+1 : len(match)-1] {
-			if c >= '0' && c <= '9' {
-				col = col*10 + int(c-'0')
-			}
-		}
-		if col <= 1 {
-			return []byte("\n") // row start
-		}
-		return nil // mid-row → strip (keeps text on one line)
-	})
-}
 
-// ANSI escape code pattern - matches all other escape sequences
-var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]|\x1b\][^\x07]*\x07|\x1b[()][AB012]|\x1b[=>]|\x1b[78]|\x1b[DMEHc]`)
+	"ipmiserial/vt"
+)
 
-// Orphaned ANSI fragments — bracket sequences left after ESC byte was stripped
-// Matches: [=3h [0m [01;00H [?25l etc. Also catches incomplete [01;01 (no letter)
-var orphanedAnsiRegex = regexp.MustCompile(`\[[=?]?[\d;]*[A-Za-z]|\[[=?]?[\d;]+$`)
-var orphanedAnsiLineRegex = regexp.MustCompile(`(?m)\[[=?]?[\d;]+$`)
+// Size of the virtual screen console output is applied to before it is
+// logged. It is wider than any real console so long kernel lines are not
+// wrapped; BIOS pages address rows and columns absolutely and fit as drawn.
+const (
+	logCols = 256
+	logRows = 50
+)
 
 // recentLines tracks recently written lines to suppress screen-redraw duplicates.
 // The BMC redraws the screen via cursor positioning; after cleaning, these become
@@ -104,35 +78,75 @@ func (rl *recentLines) checkLine(line string) (write bool, banner string) {
 }
 
 type Writer struct {
-	basePath      string
-	retentionDays int
-	files         map[string]*os.File
-	lastRotation  map[string]time.Time    // track last rotation time per server
-	pending       map[string][]byte       // partial data buffer per server
-	lastLine      map[string][]byte       // last written line per server (for dedup)
-	trailingNL    map[string]int          // trailing newline count from last write
-	repeats       map[string]*recentLines // line-level dedup per server
-	mu            sync.Mutex
-}
-
-func NewWriter(basePath string, retentionDays int) *Writer {
+	basePath          string
+	retentionDays     int
+	retentionFor      func(serverName string) int // per-server retention override, 0 = retentionDays
+	maxFileSize       int64                       // rotate current.log past this many bytes (0 = unlimited)
+	compressAfterDays int                         // gzip rotated logs older than this (0 = never)
+	sizes             map[string]int64            // current file size per server
+	lines             map[string]int              // current file line count per server
+	files             map[string]*os.File
+	rawCapture        bool                    // also keep the untouched stream in <name>.raw
+	rawFiles          map[string]*os.File     // open raw capture file per server
+	idxFiles          map[string]*os.File     // open time index per server, see indexTime
+	idxLast           map[string]time.Time    // last time index entry per server
+	lastRotation      map[string]time.Time    // track last rotation time per server
+	terms             map[string]*vt.Terminal // virtual screen per server, turning console output into text lines
+	pendingIdle       time.Duration           // write out unfinished rows after this long without data (0 = never)
+	pendingTimers     map[string]*time.Timer  // idle flush timer per server
+	lastLine          map[string][]byte       // last written line per server (for dedup)
+	trailingNL        map[string]int          // trailing newline count from last write
+	repeats           map[string]*recentLines // line-level dedup per server
+	mu                sync.Mutex
+	pathMu            sync.RWMutex // guards basePath for readers not holding mu
+	migration         *MigrationStatus
+	migMu             sync.Mutex
+	cleanedHooks      []func(serverName string, data []byte) // see OnCleaned
+	maxTotalSize      int64                                  // disk budget for all logs in bytes (0 = none), see EnforceQuota
+	quota             Usage                                  // pruning counters
+}
+
+func NewWriter(basePath string, retentionDays, maxFileSizeMB, compressAfterDays int) *Writer {
 	return &Writer{
-		basePath:      basePath,
-		retentionDays: retentionDays,
-		files:         make(map[string]*os.File),
-		lastRotation:  make(map[string]time.Time),
-		pending:       make(map[string][]byte),
-		lastLine:      make(map[string][]byte),
-		trailingNL:    make(map[string]int),
-		repeats:       make(map[string]*recentLines),
+		basePath:          basePath,
+		retentionDays:     retentionDays,
+		compressAfterDays: compressAfterDays,
+		maxFileSize:       int64(maxFileSizeMB) << 20,
+		sizes:             make(map[string]int64),
+		lines:             make(map[string]int),
+		files:             make(map[string]*os.File),
+		rawFiles:          make(map[string]*os.File),
+		idxFiles:          make(map[string]*os.File),
+		idxLast:           make(map[string]time.Time),
+		lastRotation:      make(map[string]time.Time),
+		terms:             make(map[string]*vt.Terminal),
+		pendingTimers:     make(map[string]*time.Timer),
+		lastLine:          make(map[string][]byte),
+		trailingNL:        make(map[string]int),
+		repeats:           make(map[string]*recentLines),
 	}
 }
 
+// OnCleaned registers fn to receive the cleaned text appended to each
+// server's log (e.g. to ship it to Loki). fn is called with the writer
+// locked, so it must not block. Call it before any writes.
+func (w *Writer) OnCleaned(fn func(serverName string, data []byte)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cleanedHooks = append(w.cleanedHooks, fn)
+}
+
 func (w *Writer) BasePath() string {
+	w.pathMu.RLock()
+	defer w.pathMu.RUnlock()
 	return w.basePath
 }
 
-func (w *Writer) Write(serverName string, data []byte) error {
+// WriteNote adds an ipmiserial annotation (e.g. a control banner) to the
+// cleaned log verbatim. It bypasses the console cleaning heuristics, which
+// would mangle it, and is not added to the raw capture, which holds nothing
+// but SOL output.
+func (w *Writer) WriteNote(serverName string, data []byte) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -140,32 +154,121 @@ func (w *Writer) Write(serverName string, data []byte) error {
 	if err != nil {
 		return err
 	}
+	// A console line left open is written again in full after the note
+	if term := w.terms[serverName]; term != nil {
+		term.BreakLine()
+	}
+	// Start on a fresh line without adding to a blank-line run
+	if w.trailingNL[serverName] > 0 {
+		data = bytes.TrimLeft(data, "\n")
+	}
+	w.indexTime(serverName, f)
+	n, err := f.Write(data)
+	w.sizes[serverName] += int64(n)
+	w.lines[serverName] += bytes.Count(data[:n], []byte("\n"))
+	if bytes.HasSuffix(data, []byte("\n")) {
+		w.trailingNL[serverName] = 1
+	} else {
+		w.trailingNL[serverName] = 0
+	}
+	return err
+}
 
-	// Prepend any pending data from previous chunk to handle split escape sequences
-	if prev, ok := w.pending[serverName]; ok && len(prev) > 0 {
-		data = append(prev, data...)
-		delete(w.pending, serverName)
+func (w *Writer) Write(serverName string, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := w.getOrCreateFile(serverName)
+	if err != nil {
+		return err
 	}
 
-	// If data ends mid-escape sequence, buffer the incomplete tail
-	if i := bytes.LastIndexByte(data, '\x1b'); i >= 0 && i > len(data)-6 {
-		tail := data[i:]
-		// Only buffer if the sequence is incomplete (doesn't end with a letter)
-		last := tail[len(tail)-1]
-		if !((last >= 'A' && last <= 'Z') || (last >= 'a' && last <= 'z')) {
-			w.pending[serverName] = append([]byte{}, tail...)
-			data = data[:i]
+	if w.rawCapture {
+		if err := w.writeRaw(serverName, data); err != nil {
+			log.Warnf("Raw capture for %s: %v", serverName, err)
 		}
 	}
 
-	cleaned := cleanLogData(data)
+	// Run the output through the server's virtual screen; it hands back
+	// the rows the output finished (see vt.Terminal.TakeText)
+	term := w.terminal(serverName)
+	term.Write(data)
+	if term.Pending() {
+		w.armPendingFlush(serverName)
+	}
+	return w.writeCleaned(serverName, f, term.TakeText())
+}
+
+// terminal returns the server's virtual screen. Must be called with w.mu
+// held.
+func (w *Writer) terminal(serverName string) *vt.Terminal {
+	term := w.terms[serverName]
+	if term == nil {
+		term = vt.New(logCols, logRows)
+		w.terms[serverName] = term
+	}
+	return term
+}
+
+// SetPendingFlush sets how long rows the console has drawn but not finished
+// (a prompt, a full-screen BIOS page) wait for more output before they are
+// written out as they stand, so the screen of a console that goes quiet is
+// not lost. Zero holds them until the console moves on.
+func (w *Writer) SetPendingFlush(idle time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pendingIdle = idle
+}
+
+// armPendingFlush (re)starts the server's idle flush timer. Must be called
+// with w.mu held.
+func (w *Writer) armPendingFlush(serverName string) {
+	if w.pendingIdle <= 0 {
+		return
+	}
+	if t, ok := w.pendingTimers[serverName]; ok {
+		t.Reset(w.pendingIdle)
+		return
+	}
+	w.pendingTimers[serverName] = time.AfterFunc(w.pendingIdle, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.flushPending(serverName)
+	})
+}
+
+// flushPending writes out a server's unfinished rows. Must be called with
+// w.mu held.
+func (w *Writer) flushPending(serverName string) {
+	term := w.terms[serverName]
+	if term == nil || !term.Pending() {
+		return
+	}
+	term.Flush()
+	f, err := w.getOrCreateFile(serverName)
+	if err == nil {
+		err = w.writeCleaned(serverName, f, term.TakeText())
+	}
+	if err != nil {
+		log.Warnf("Flushing pending output for %s: %v", serverName, err)
+	}
+}
+
+// writeCleaned deduplicates text lines from the virtual screen and appends
+// them to the server's log. Must be called with w.mu held.
+func (w *Writer) writeCleaned(serverName string, f *os.File, cleaned []byte) error {
 	if len(cleaned) == 0 {
 		return nil
 	}
 
+	// Collapse runs of blank lines into a single blank line
+	for bytes.Contains(cleaned, []byte("\n\n\n")) {
+		cleaned = bytes.ReplaceAll(cleaned, []byte("\n\n\n"), []byte("\n\n"))
+	}
+
 	// Deduplicate consecutive spinner lines (e.g. BIOS "DHCP..../", "DHCP....-").
-	// Strip leading newlines (cursor-position escapes become \n in cleaning)
-	// so the dedup check sees the actual content, not the \n prefix.
+	// Strip leading newlines (rows finished before a partly drawn one) so
+	// the dedup check sees the actual content, not the \n prefix.
 	content := bytes.TrimLeft(cleaned, "\n")
 	if len(content) > 0 && !bytes.Contains(content, []byte("\n")) {
 		trimmed := bytes.TrimRight(content, " \t")
@@ -243,62 +346,58 @@ func (w *Writer) Write(serverName string, data []byte) error {
 	}
 	w.trailingNL[serverName] = trailNL
 
-	_, err = f.Write(cleaned)
+	if w.maxFileSize > 0 && w.sizes[serverName] > 0 && w.sizes[serverName]+int64(len(cleaned)) > w.maxFileSize {
+		if nf, err := w.rotateForSize(serverName); err != nil {
+			log.Warnf("Size rotation failed for %s: %v", serverName, err)
+		} else {
+			f = nf
+		}
+	}
+
+	w.indexTime(serverName, f)
+	n, err := f.Write(cleaned)
+	w.sizes[serverName] += int64(n)
+	w.lines[serverName] += bytes.Count(cleaned[:n], []byte("\n"))
+	for _, fn := range w.cleanedHooks {
+		fn(serverName, cleaned)
+	}
 	return err
 }
 
-// cleanLogData removes ANSI escape codes and control characters from log data
-func cleanLogData(data []byte) []byte {
-	// Convert row-start cursor positions to newlines, strip mid-row positions
-	data = cleanCursorPositions(data)
-
-	// Remove other ANSI escape sequences
-	data = ansiRegex.ReplaceAll(data, nil)
-
-	// Remove orphaned ANSI fragments (from previously split sequences)
-	data = orphanedAnsiRegex.ReplaceAll(data, nil)
-	data = orphanedAnsiLineRegex.ReplaceAll(data, nil)
-
-	// Handle carriage returns: simulate terminal overwrite behavior.
-	// First normalize \r\n line endings to \n (standard SOL line terminator),
-	// then within each line, content after \r replaces content before it.
-	// e.g. "foo\rbar" → "bar" (BIOS spinner frames)
-	if bytes.ContainsRune(data, '\r') {
-		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
-		crLines := bytes.Split(data, []byte("\n"))
-		for i, line := range crLines {
-			if idx := bytes.LastIndexByte(line, '\r'); idx >= 0 {
-				crLines[i] = line[idx+1:]
-			}
-		}
-		data = bytes.Join(crLines, []byte("\n"))
+// rotateForSize starts a new log file once current.log reaches maxFileSize,
+// leaving marker lines in both files so the split is visible when reading
+// either one. Must be called with w.mu held.
+func (w *Writer) rotateForSize(serverName string) (*os.File, error) {
+	oldName := ""
+	if target, err := os.Readlink(filepath.Join(w.basePath, serverName, "current.log")); err == nil {
+		oldName = target
 	}
-
-	// Remove control characters except newline and tab
-	result := make([]byte, 0, len(data))
-	for _, c := range data {
-		if c == '\n' || c == '\t' || (c >= 32 && c < 127) {
-			result = append(result, c)
-		}
+	newName, err := w.rotateLocked(serverName, "")
+	if err != nil {
+		return nil, err
 	}
 
-	// Trim trailing whitespace from each line
-	lines := bytes.Split(result, []byte("\n"))
-	result = result[:0]
-	for i, line := range lines {
-		line = bytes.TrimRight(line, " \t")
-		if i > 0 {
-			result = append(result, '\n')
+	limit := w.maxFileSize >> 20
+	if oldName != "" {
+		if old, err := os.OpenFile(filepath.Join(w.basePath, serverName, oldName), os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			fmt.Fprintf(old, "\n--- [ipmiserial] size limit %d MB reached, continued in %s ---\n", limit, newName)
+			old.Close()
 		}
-		result = append(result, line...)
 	}
 
-	// Collapse runs of blank lines into a single blank line
-	for bytes.Contains(result, []byte("\n\n\n")) {
-		result = bytes.ReplaceAll(result, []byte("\n\n\n"), []byte("\n\n"))
-	}
+	f := w.files[serverName]
+	n, _ := fmt.Fprintf(f, "--- [ipmiserial] continued from %s (size limit %d MB) ---\n", oldName, limit)
+	w.sizes[serverName] += int64(n)
+	w.lines[serverName]++
+	log.Infof("Log for %s reached %d MB, rotated to %s", serverName, limit, newName)
+	return f, nil
+}
 
-	return result
+// SetMaxFileSize changes the size limit applied to subsequent writes.
+func (w *Writer) SetMaxFileSize(maxFileSizeMB int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.maxFileSize = int64(maxFileSizeMB) << 20
 }
 
 // CanRotate checks if enough time has passed since last rotation (2 minute cooldown)
@@ -323,11 +422,21 @@ func (w *Writer) RotateWithName(serverName, logName string) (string, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	// Record rotation time for the cooldown check
+	w.lastRotation[serverName] = time.Now()
+	return w.rotateLocked(serverName, logName)
+}
+
+// rotateLocked closes the current file, opens a new one and repoints the
+// current.log symlink. Must be called with w.mu held.
+func (w *Writer) rotateLocked(serverName, logName string) (string, error) {
 	// Close existing file
 	if f, exists := w.files[serverName]; exists {
 		f.Close()
 		delete(w.files, serverName)
 	}
+	w.closeRaw(serverName)
+	w.closeIndex(serverName)
 
 	dir := filepath.Join(w.basePath, serverName)
 	symlinkPath := filepath.Join(dir, "current.log")
@@ -335,8 +444,10 @@ func (w *Writer) RotateWithName(serverName, logName string) (string, error) {
 	// Remove current.log symlink
 	os.Remove(symlinkPath)
 
-	// Record rotation time and reset dedup state
-	w.lastRotation[serverName] = time.Now()
+	// Reset dedup state; a line left open continues in full in the new file
+	if term := w.terms[serverName]; term != nil {
+		term.BreakLine()
+	}
 	delete(w.lastLine, serverName)
 	delete(w.trailingNL, serverName)
 	delete(w.repeats, serverName)
@@ -349,6 +460,15 @@ func (w *Writer) RotateWithName(serverName, logName string) (string, error) {
 	// Use custom name or generate timestamp name
 	if logName == "" {
 		logName = time.Now().Format("2006-01-02_15-04-05")
+		// Size rotation can happen more than once a second; don't
+		// append to the file we just closed
+		base := logName
+		for i := 2; i <= 100; i++ {
+			if _, err := os.Stat(filepath.Join(dir, logName+".log")); os.IsNotExist(err) {
+				break
+			}
+			logName = fmt.Sprintf("%s_%d", base, i)
+		}
 	} else {
 		logName = filepath.Base(logName)
 	}
@@ -363,6 +483,8 @@ func (w *Writer) RotateWithName(serverName, logName string) (string, error) {
 	}
 
 	w.files[serverName] = f
+	w.sizes[serverName] = 0
+	w.lines[serverName] = 0
 
 	// Update current.log symlink
 	os.Symlink(logName, symlinkPath)
@@ -387,6 +509,10 @@ func (w *Writer) getOrCreateFile(serverName string) (*os.File, error) {
 		existingPath := filepath.Join(dir, target)
 		if f, err := os.OpenFile(existingPath, os.O_WRONLY|os.O_APPEND, 0644); err == nil {
 			w.files[serverName] = f
+			if info, err := f.Stat(); err == nil {
+				w.sizes[serverName] = info.Size()
+			}
+			w.lines[serverName] = countLines(existingPath)
 			log.Infof("Continuing existing log file: %s", existingPath)
 			return f, nil
 		}
@@ -402,6 +528,8 @@ func (w *Writer) getOrCreateFile(serverName string) (*os.File, error) {
 	}
 
 	w.files[serverName] = f
+	w.sizes[serverName] = 0
+	w.lines[serverName] = 0
 
 	// Update current.log symlink
 	os.Remove(symlinkPath)
@@ -413,7 +541,7 @@ func (w *Writer) getOrCreateFile(serverName string) (*os.File, error) {
 }
 
 func (w *Writer) ListLogs(serverName string) ([]string, error) {
-	dir := filepath.Join(w.basePath, serverName)
+	dir := filepath.Join(w.BasePath(), serverName)
 
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -429,12 +557,14 @@ func (w *Writer) ListLogs(serverName string) ([]string, error) {
 	}
 	var logs []logEntry
 	for _, entry := range entries {
-		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".log" && entry.Name() != "current.log" {
+		if !entry.IsDir() && isLogFile(entry.Name()) && entry.Name() != "current.log" {
 			info, err := entry.Info()
 			if err != nil {
 				continue
 			}
-			logs = append(logs, logEntry{name: entry.Name(), modTime: info.ModTime()})
+			// Compressed logs are listed under their plain name; OpenLog
+			// resolves either form
+			logs = append(logs, logEntry{name: strings.TrimSuffix(entry.Name(), ".gz"), modTime: info.ModTime()})
 		}
 	}
 
@@ -452,7 +582,7 @@ func (w *Writer) ListLogs(serverName string) ([]string, error) {
 }
 
 func (w *Writer) GetLogPath(serverName, filename string) string {
-	return filepath.Join(w.basePath, serverName, filename)
+	return filepath.Join(w.BasePath(), serverName, filename)
 }
 
 func (w *Writer) GetCurrentLogContent(serverName string) ([]byte, error) {
@@ -485,17 +615,50 @@ func (w *Writer) SyncFile(serverName string) {
 	}
 }
 
+// Position returns where a server's next log output goes: the current
+// file, its size and the lines already in it. file is empty until the
+// server's log has been opened.
+func (w *Writer) Position(serverName string) (file string, offset int64, line int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, exists := w.files[serverName]
+	if !exists {
+		return "", 0, 0
+	}
+	return filepath.Base(f.Name()), w.sizes[serverName], w.lines[serverName]
+}
+
+// countLines counts the lines in a log file being continued.
+func countLines(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	lines := 0
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		lines += bytes.Count(buf[:n], []byte("\n"))
+		if err != nil {
+			return lines
+		}
+	}
+}
+
 func (w *Writer) GetCurrentLogTarget(serverName string) (filename, fullPath string, err error) {
-	symlinkPath := filepath.Join(w.basePath, serverName, "current.log")
+	symlinkPath := filepath.Join(w.BasePath(), serverName, "current.log")
 	target, err := os.Readlink(symlinkPath)
 	if err != nil {
 		return "", "", err
 	}
-	return target, filepath.Join(w.basePath, serverName, target), nil
+	return target, filepath.Join(w.BasePath(), serverName, target), nil
 }
 
 func (w *Writer) ListServerDirs() []string {
-	entries, err := os.ReadDir(w.basePath)
+	entries, err := os.ReadDir(w.BasePath())
 	if err != nil {
 		return nil
 	}
@@ -508,14 +671,43 @@ func (w *Writer) ListServerDirs() []string {
 	return names
 }
 
+// SetCompressAfterDays changes the age at which Cleanup gzips rotated logs.
+func (w *Writer) SetCompressAfterDays(days int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.compressAfterDays = days
+}
+
+// SetRetentionDays changes the retention applied by subsequent Cleanup runs.
+func (w *Writer) SetRetentionDays(days int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.retentionDays = days
+}
+
+// SetServerRetention sets the lookup Cleanup uses for per-server retention
+// overrides. fn returns the days to keep a server's logs, or 0 to use the
+// global retention.
+func (w *Writer) SetServerRetention(fn func(serverName string) int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.retentionFor = fn
+}
+
 func (w *Writer) Cleanup() {
-	if w.retentionDays <= 0 {
+	w.mu.Lock()
+	defaultRetention := w.retentionDays
+	retentionFor := w.retentionFor
+	compressAfterDays := w.compressAfterDays
+	w.mu.Unlock()
+	if defaultRetention <= 0 && compressAfterDays <= 0 && retentionFor == nil {
 		return
 	}
 
-	cutoff := time.Now().AddDate(0, 0, -w.retentionDays)
+	compressCutoff := time.Now().AddDate(0, 0, -compressAfterDays)
 
-	entries, err := os.ReadDir(w.basePath)
+	basePath := w.BasePath()
+	entries, err := os.ReadDir(basePath)
 	if err != nil {
 		return
 	}
@@ -525,14 +717,33 @@ func (w *Writer) Cleanup() {
 			continue
 		}
 
-		serverPath := filepath.Join(w.basePath, serverDir.Name())
+		retentionDays := defaultRetention
+		if retentionFor != nil {
+			if days := retentionFor(serverDir.Name()); days > 0 {
+				retentionDays = days
+			}
+		}
+		if retentionDays <= 0 && compressAfterDays <= 0 {
+			continue
+		}
+		cutoff := time.Now().AddDate(0, 0, -retentionDays)
+
+		serverPath := filepath.Join(basePath, serverDir.Name())
 		logFiles, err := os.ReadDir(serverPath)
 		if err != nil {
 			continue
 		}
 
+		// Never touch the files current.log and current.raw point at, or the
+		// current log's time index
+		current, _ := os.Readlink(filepath.Join(serverPath, "current.log"))
+		currentRaw, _ := os.Readlink(filepath.Join(serverPath, "current.raw"))
+
 		for _, logFile := range logFiles {
-			if logFile.IsDir() || filepath.Ext(logFile.Name()) != ".log" {
+			name := logFile.Name()
+			if logFile.IsDir() || !(isLogFile(name) || isRawFile(name) || isIndexFile(name)) ||
+				name == "current.log" || name == "current.raw" || name == current || name == currentRaw ||
+				(current != "" && name == IndexName(current)) {
 				continue
 			}
 
@@ -541,10 +752,20 @@ func (w *Writer) Cleanup() {
 				continue
 			}
 
-			if info.ModTime().Before(cutoff) {
-				path := filepath.Join(serverPath, logFile.Name())
+			path := filepath.Join(serverPath, name)
+			if retentionDays > 0 && info.ModTime().Before(cutoff) {
 				os.Remove(path)
 				log.Infof("Cleaned up old log: %s", path)
+				continue
+			}
+
+			ext := filepath.Ext(name)
+			if compressAfterDays > 0 && (ext == ".log" || ext == ".raw") && info.ModTime().Before(compressCutoff) {
+				if err := gzipFile(path, info.ModTime()); err != nil {
+					log.Warnf("Failed to compress %s: %v", path, err)
+				} else {
+					log.Infof("Compressed old log: %s", path)
+				}
 			}
 		}
 	}
@@ -564,10 +785,21 @@ func (w *Writer) Close() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	for _, t := range w.pendingTimers {
+		t.Stop()
+	}
+	w.pendingTimers = make(map[string]*time.Timer)
+	for name := range w.terms {
+		if w.files[name] != nil {
+			w.flushPending(name)
+		}
+	}
 	for _, f := range w.files {
 		f.Close()
 	}
 	w.files = make(map[string]*os.File)
+	w.closeRawFiles()
+	w.closeIndexFiles()
 }
 
 func (w *Writer) ClearLogs(serverName string) error {
@@ -579,6 +811,9 @@ func (w *Writer) ClearLogs(serverName string) error {
 		f.Close()
 		delete(w.files, serverName)
 	}
+	w.closeRaw(serverName)
+	w.closeIndex(serverName)
+	delete(w.terms, serverName)
 
 	dir := filepath.Join(w.basePath, serverName)
 
@@ -605,6 +840,8 @@ func (w *Writer) ClearLogs(serverName string) error {
 		return err
 	}
 	w.files[serverName] = f
+	w.sizes[serverName] = 0
+	w.lines[serverName] = 0
 
 	// Update symlink
 	symlinkPath := filepath.Join(dir, "current.log")
@@ -615,6 +852,48 @@ func (w *Writer) ClearLogs(serverName string) error {
 	return nil
 }
 
+// ArchiveServer closes a server's files, forgets its state and moves its log
+// directory to dest. A server without logs is not an error.
+func (w *Writer) ArchiveServer(serverName, dest string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if f, exists := w.files[serverName]; exists {
+		f.Close()
+		delete(w.files, serverName)
+	}
+	w.closeRaw(serverName)
+	w.closeIndex(serverName)
+	delete(w.sizes, serverName)
+	delete(w.lines, serverName)
+	delete(w.lastRotation, serverName)
+	delete(w.terms, serverName)
+	if t, ok := w.pendingTimers[serverName]; ok {
+		t.Stop()
+		delete(w.pendingTimers, serverName)
+	}
+	delete(w.lastLine, serverName)
+	delete(w.trailingNL, serverName)
+	delete(w.repeats, serverName)
+
+	src := filepath.Join(w.basePath, serverName)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	// Different filesystem: copy, then remove the original. dest is new, so
+	// an incremental copy takes every file without touching migration stats.
+	if err := w.copyTree(src, dest, true, true); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
 func (w *Writer) ClearAllLogs() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -624,6 +903,9 @@ func (w *Writer) ClearAllLogs() error {
 		f.Close()
 	}
 	w.files = make(map[string]*os.File)
+	w.closeRawFiles()
+	w.closeIndexFiles()
+	w.terms = make(map[string]*vt.Terminal)
 
 	entries, err := os.ReadDir(w.basePath)
 	if err != nil {
@@ -661,6 +943,8 @@ func (w *Writer) ClearAllLogs() error {
 			continue
 		}
 		w.files[serverName] = f
+		w.sizes[serverName] = 0
+		w.lines[serverName] = 0
 
 		// Update symlink
 		symlinkPath := filepath.Join(serverPath, "current.log")
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -11,11 +12,19 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"ipmiserial/alerts"
+	"ipmiserial/bmcproxy"
 	"ipmiserial/config"
 	"ipmiserial/discovery"
+	"ipmiserial/events"
+	"ipmiserial/gateway"
 	"ipmiserial/logs"
+	"ipmiserial/mqtt"
+	"ipmiserial/playbooks"
 	"ipmiserial/server"
 	"ipmiserial/sol"
+	"ipmiserial/telemetry"
+	"ipmiserial/vault"
 )
 
 // Version info - increment based on change magnitude:
@@ -24,10 +33,18 @@ import (
 // Patch (0.0.z): Bug fixes, minor improvements
 var Version = "2.3.2"
 
+// shutdownTimeout bounds how long SOL sessions get to close on SIGTERM.
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	configPath := flag.String("config", "config.yaml", "Path to config file")
+	validate := flag.Bool("validate", false, "Check the config file, report any problems and exit")
 	flag.Parse()
 
+	if *validate {
+		os.Exit(runValidate(*configPath))
+	}
+
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp: true,
 	})
@@ -38,66 +55,212 @@ func main() {
 	}
 
 	// Log to file instead of stdout to avoid MikroTik container pipe saturation
-	os.MkdirAll(cfg.Logs.Path, 0755)
-	logFile, err := os.OpenFile(cfg.Logs.Path+"/ipmiserial.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	daemonCfg := cfg.Logs.Daemon
+	daemonLog, err := logs.OpenDaemonLog(cfg.Logs.Path, daemonCfg.MaxSizeMB, daemonCfg.MaxAgeDays, daemonCfg.MaxBackups)
 	if err == nil {
-		log.SetOutput(logFile)
+		log.SetOutput(daemonLog)
+		defer daemonLog.Close()
 	}
+	applyDaemonLogFormat(daemonCfg)
 
 	log.Infof("Starting Console Server v%s", Version)
-	log.Infof("  BMH API: %s (namespace: %s)", cfg.Discovery.BMHURL, cfg.Discovery.Namespace)
+	for _, p := range validateConfig(*configPath, cfg) {
+		log.Warnf("Config: %s", p)
+	}
+	for _, src := range cfg.Discovery.AllSources() {
+		log.Infof("  Discovery %s: %s %s (namespace: %s)", src.Name, src.Mode, src.BMHURL, src.Namespace)
+	}
 	log.Infof("  Log path: %s", cfg.Logs.Path)
 	log.Infof("  Web port: %d", cfg.Server.Port)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle shutdown signals
+	// Shutdown signals are handled once the SOL manager exists
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		log.Info("Shutting down...")
-		cancel()
-	}()
 
 	// Initialize components
-	logWriter := logs.NewWriter(cfg.Logs.Path, cfg.Logs.RetentionDays)
+	logWriter := logs.NewWriter(cfg.Logs.Path, cfg.Logs.RetentionDays, cfg.Logs.MaxFileSizeMB, cfg.Logs.CompressDays)
+	logWriter.SetRawCapture(cfg.Logs.RawCapture)
+	logWriter.SetPendingFlush(cfg.Logs.PendingFlush)
 	defer logWriter.Close()
 
+	// Fan console output out from the log files to the configured sinks
+	logSinks := logs.NewSinkRegistry(logWriter)
+	defer logSinks.Close(5 * time.Second)
+
+	if cfg.Logs.Loki.URL != "" {
+		lokiSink, err := logs.NewLokiSink(cfg.Logs.Loki, filepath.Dir(cfg.Logs.Path))
+		if err != nil {
+			log.Fatalf("Loki: %v", err)
+		}
+		logSinks.Register("loki", lokiSink, logs.SinkCleaned, cfg.Logs.Sinks["loki"])
+		go lokiSink.Run(ctx)
+		log.Infof("  Loki: %s", cfg.Logs.Loki.URL)
+	}
+	if cfg.Logs.Syslog.Address != "" {
+		syslogSink, err := logs.NewSyslogSink(cfg.Logs.Syslog)
+		if err != nil {
+			log.Fatalf("Syslog: %v", err)
+		}
+		logSinks.Register("syslog", syslogSink, logs.SinkCleaned, cfg.Logs.Sinks["syslog"])
+		log.Infof("  Syslog: %s", cfg.Logs.Syslog.Address)
+	}
+	if cfg.Logs.NATS.URL != "" {
+		natsSink, err := logs.NewNATSSink(cfg.Logs.NATS)
+		if err != nil {
+			log.Fatalf("NATS: %v", err)
+		}
+		if natsSink.Wants(logs.NATSCleaned) {
+			logSinks.Register("nats", natsSink, logs.SinkCleaned, cfg.Logs.Sinks["nats"])
+		}
+		if natsSink.Wants(logs.NATSRaw) {
+			logSinks.Register("nats-raw", natsSink.Raw(), logs.SinkRaw, cfg.Logs.Sinks["nats-raw"])
+		}
+		log.Infof("  NATS: %s (stream %s, subjects %s.<server>.*)", natsSink.URL(), cfg.Logs.NATS.Stream, cfg.Logs.NATS.SubjectPrefix)
+	}
+
+	if cfg.Telemetry.Endpoint != "" {
+		go telemetry.Run(ctx, cfg.Telemetry, Version)
+		log.Infof("  Telemetry: %s (OTLP/HTTP)", cfg.Telemetry.Endpoint)
+	}
+
 	rebootDetector := sol.NewRebootDetector(cfg.RebootDetection.SOLPatterns)
 
-	solManager := sol.NewManager(cfg.IPMI.Username, cfg.IPMI.Password, logWriter, rebootDetector, cfg.Logs.Path)
+	solManager := sol.NewManager(cfg.IPMI.Username, cfg.IPMI.Password, cfg.IPMI.Kg, logSinks, rebootDetector, cfg.Logs.Path)
+	solManager.SetBootHistory(cfg.Analytics.BootHistory)
+	solManager.SetRebootLoop(cfg.RebootDetection.LoopReboots, cfg.RebootDetection.LoopWindow, cfg.RebootDetection.LoopPauseRotation)
+	solManager.SetSOLRetry(cfg.IPMI.SOLRetries, cfg.IPMI.SOLRetryInterval)
+	solManager.SetSOLConfig(solConfig(cfg.IPMI.SOLConfig))
+	solManager.SetSOLAutoEnable(cfg.IPMI.SOLConfig.AutoEnable)
+	solManager.SetSerialConfig(serialConfigs(cfg))
+	solManager.SetBackoff(backoffConfigs(cfg))
+	solManager.SetQuirks(quirksConfigs(cfg))
+	solManager.SetTransports(transports(cfg))
+	solManager.SetIPMITool(ipmiTool(cfg.IPMI.IPMITool))
+	solManager.SetConnection(connectionConfigs(cfg))
+	solManager.SetBMCRateLimit(cfg.IPMI.AttemptsPerMinute)
+	solManager.SetMaxConnecting(cfg.IPMI.MaxConnecting)
+	solManager.SetConnectHistory(cfg.IPMI.ConnectHistory)
+	solManager.SetScrollback(scrollbackSizes(cfg))
+	solManager.SetChassisPollInterval(cfg.RebootDetection.ChassisPollInterval)
+	defer solManager.FlushAnalytics()
 
 	dataDir := filepath.Dir(cfg.Logs.Path) // e.g. /var/lib/data from /var/lib/data/logs
-	scanner := discovery.NewScanner(cfg.Discovery.BMHURL, cfg.Discovery.Namespace, dataDir)
+	scanner := discovery.NewScanner(dataDir)
+
+	// BMC sockets from the permitted source address and ports
+	sourcePorts, err := sol.NewSourcePorts(cfg.IPMI.SourceAddress, cfg.IPMI.SourcePorts, cfg.IPMI.DSCP)
+	if err != nil {
+		log.Fatalf("ipmi.source_ports: %v", err)
+	}
+	solManager.SetSourcePorts(sourcePorts)
+	scanner.SetProbeDialer(sourcePorts.Dial)
+	// ...or through a proxy or jump host
+	proxy, proxies, err := proxyDialers(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	solManager.SetProxies(proxy, proxies)
+	scanner.SetProbeProxies(proxy, proxies)
+	if err := scanner.SetCacheKey(cfg.Discovery.CacheKey); err != nil {
+		log.Fatalf("BMH cache: %v", err)
+	}
+	for _, src := range cfg.Discovery.AllSources() {
+		if err := scanner.AddSource(src); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	// Per-server credentials from Vault take precedence over BMH and config
+	if cfg.Vault.Address != "" {
+		if vaultCreds, err := vault.New(cfg.Vault); err != nil {
+			log.Warnf("%v; using BMH and config credentials", err)
+		} else {
+			solManager.SetCredentialProvider(vaultCreds)
+			vaultCreds.OnChange(scanner.NotifyChange)
+			go vaultCreds.Run(ctx)
+			log.Infof("  Vault: %s", cfg.Vault.Address)
+		}
+	}
 
 	// Add any statically configured servers (optional override)
 	for _, s := range cfg.Servers {
-		scanner.AddServer(s.Name, s.Host)
+		scanner.AddServer(s.Name, s.Host, s.Username, s.Password, s.Kg, s.Port, s.RetentionDays, s.Labels)
+	}
+
+	logWriter.SetServerRetention(func(name string) int {
+		if s, ok := scanner.GetServers()[name]; ok {
+			return s.RetentionDays
+		}
+		return 0
+	})
+	solManager.SetServerConnection(func(name string) sol.ConnectionSetting {
+		if s, ok := scanner.GetServers()[name]; ok {
+			return sol.ConnectionSetting(s.Connection)
+		}
+		return sol.ConnectionSetting{}
+	})
+
+	eventBus := events.NewBus(solManager)
+	webhooks := events.NewWebhooks(cfg.Events, eventBus)
+
+	if cfg.MQTT.Broker != "" {
+		mqttPublisher, err := mqtt.NewPublisher(cfg.MQTT, eventBus, scanner, solManager)
+		if err != nil {
+			log.Fatalf("MQTT: %v", err)
+		}
+		if cfg.MQTT.Console {
+			logSinks.Register("mqtt", mqttPublisher, logs.SinkCleaned, cfg.Logs.Sinks["mqtt"])
+		}
+		go mqttPublisher.Run(ctx)
+		log.Infof("  MQTT: %s (topics %s/...)", cfg.MQTT.Broker, cfg.MQTT.TopicPrefix)
 	}
 
 	scanner.OnChange(func(servers map[string]*discovery.Server) {
+		eventBus.ServersChanged(servers)
 		for name, s := range servers {
 			session := solManager.GetSession(name)
 			if s.Online && session == nil {
 				log.Infof("Starting SOL session for %s (%s) user=%s", name, s.IP, s.Username)
-				solManager.StartSession(name, s.IP, s.Username, s.Password)
+				solManager.StartSession(name, s.IP, s.Port, s.Username, s.Password, s.Kg)
 			} else if !s.Online && session != nil {
 				log.Infof("Stopping SOL session for %s (server offline)", name)
 				solManager.StopSession(name)
 			} else if s.Online && session != nil {
-				// Detect credential changes and restart session
-				if session.Username != s.Username || session.Password != s.Password {
-					log.Infof("Credentials changed for %s, restarting SOL session", name)
+				// Detect address/credential changes and restart session
+				if solManager.SessionChanged(session, s.IP, s.Port, s.Username, s.Password, s.Kg) {
+					log.Infof("Connection settings changed for %s, restarting SOL session", name)
 					solManager.StopSession(name)
-					solManager.StartSession(name, s.IP, s.Username, s.Password)
+					solManager.StartSession(name, s.IP, s.Port, s.Username, s.Password, s.Kg)
 				}
 			}
 		}
 	})
 
-	srv := server.New(cfg.Server.Port, scanner, solManager, logWriter, cfg.Servers, Version)
+	playbookEngine := playbooks.NewEngine(cfg.Playbooks, solManager, logWriter)
+
+	srv := server.New(cfg.Server.Port, scanner, solManager, logWriter, playbookEngine, cfg.Servers, Version)
+	srv.SetSSECompression(cfg.Server.SSECompression)
+	srv.SetCatchup(cfg.Server.Catchup)
+	srv.SetGRPCPort(cfg.Server.GRPCPort)
+	srv.SetReadOnly(cfg.Server.ReadOnly)
+	solManager.SetReadOnly(cfg.Server.ReadOnly)
+	srv.SetAccessLog(cfg.Server.AccessLog)
+	srv.SetStreamLimits(cfg.Server.Streams)
+	srv.SetGroupLabel(cfg.Server.GroupLabel)
+	srv.SetAuth(cfg.Auth)
+	srv.SetAPIKeys(server.NewKeyStore(dataDir))
+	srv.SetTLS(cfg.Server.TLS, dataDir)
+
+	alertEngine := alerts.NewEngine(cfg.Alerts, solManager, playbookEngine)
+	srv.SetAlerts(alertEngine)
+	alertEngine.OnFire(eventBus.PublishAlert)
+	srv.SetWebhooks(webhooks)
+	srv.SetLogSinks(logSinks)
+
+	serverReaper := newReaper(cfg.Prune, dataDir, scanner, solManager, logWriter, alertEngine)
 
 	// Start log cleanup routine
 	go func() {
@@ -113,10 +276,363 @@ func main() {
 		}
 	}()
 
+	// Keep logs within logs.max_total_size_gb between retention runs
+	logWriter.SetMaxTotalSize(gbToBytes(cfg.Logs.MaxTotalSizeGB))
+	go func() {
+		logWriter.EnforceQuota()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				logWriter.EnforceQuota()
+			}
+		}
+	}()
+
+	// After a live log migration, follow with the daemon's own log file
+	srv.OnLogMigration(func(newPath string) {
+		if daemonLog != nil {
+			if err := daemonLog.SetDir(newPath); err != nil {
+				log.Errorf("Failed to move %s to %s: %v", logs.DaemonLogName, newPath, err)
+			}
+		}
+		log.Warnf("Logs migrated to %s; update logs.path in %s before the next restart", newPath, *configPath)
+	})
+
+	var sshGateway *gateway.SSH
+	if cfg.SSH.Port > 0 {
+		if sshGateway, err = gateway.NewSSH(cfg.SSH, dataDir, scanner, solManager); err != nil {
+			log.Fatalf("SSH gateway: %v", err)
+		}
+	}
+
+	var conserver *gateway.Conserver
+	if cfg.Conserver.Port > 0 {
+		conserver = gateway.NewConserver(cfg.Conserver, scanner, solManager)
+	}
+
+	// Reload config on SIGHUP
+	rl := &reloader{
+		path:           *configPath,
+		cfg:            cfg,
+		logWriter:      logWriter,
+		logSinks:       logSinks,
+		rebootDetector: rebootDetector,
+		solManager:     solManager,
+		scanner:        scanner,
+		server:         srv,
+		playbooks:      playbookEngine,
+		sshGateway:     sshGateway,
+		conserver:      conserver,
+		alerts:         alertEngine,
+		webhooks:       webhooks,
+		reaper:         serverReaper,
+		daemonLog:      daemonLog,
+	}
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupChan:
+				rl.reload()
+			}
+		}
+	}()
+
+	// On shutdown, close SOL sessions on the BMCs and flush their output
+	// before the HTTP server and everything else stop
+	go func() {
+		<-sigChan
+		log.Info("Shutting down...")
+		shutdownCtx, stop := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := solManager.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("SOL shutdown: %v", err)
+		}
+		stop()
+		cancel()
+	}()
+
 	// Run components
+	go eventBus.Run(ctx)
+	go webhooks.Run(ctx)
+	// Static servers connect straight away instead of waiting for discovery
+	if len(cfg.Servers) > 0 {
+		scanner.NotifyChange()
+	}
 	go scanner.Run(ctx)
+	go scanner.RunProber(ctx, cfg.Discovery.Probe)
+	go solManager.RunSELCollector(ctx, cfg.SEL.PollInterval)
+	go solManager.RunAnalyticsFlush(ctx, cfg.Analytics.FlushInterval)
+	go solManager.RunSensorCollector(ctx, cfg.Sensors.PollInterval)
+	go solManager.RunPowerCollector(ctx, cfg.Power.PollInterval, cfg.Power.History)
+	go alertEngine.Run(ctx)
+	go serverReaper.run(ctx)
+
+	if sshGateway != nil {
+		go func() {
+			if err := sshGateway.Run(ctx); err != nil {
+				log.Errorf("SSH gateway error: %v", err)
+			}
+		}()
+	}
+	if conserver != nil {
+		go func() {
+			if err := conserver.Run(ctx); err != nil {
+				log.Errorf("Conserver gateway error: %v", err)
+			}
+		}()
+	}
+	if len(cfg.ConsoleProxy.Telnet) > 0 {
+		telnet := gateway.NewTelnet(cfg.ConsoleProxy, scanner, solManager)
+		go func() {
+			if err := telnet.Run(ctx); err != nil {
+				log.Errorf("Telnet console proxy error: %v", err)
+			}
+		}()
+	}
+	if len(cfg.ConsoleProxy.IPMI) > 0 {
+		ipmiProxy := gateway.NewIPMI(cfg.ConsoleProxy, scanner, solManager)
+		go func() {
+			if err := ipmiProxy.Run(ctx); err != nil {
+				log.Errorf("IPMI console proxy error: %v", err)
+			}
+		}()
+	}
 
 	if err := srv.Run(ctx); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// applyDaemonLogFormat sets the format and level of the daemon's own log.
+// Unknown values are reported and fall back to text and info.
+func applyDaemonLogFormat(cfg config.DaemonLogConfig) {
+	switch cfg.Format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	default:
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+		log.Warnf("Unknown logs.daemon.format %q, using text", cfg.Format)
+	}
+
+	level := log.InfoLevel
+	if cfg.Level != "" {
+		parsed, err := log.ParseLevel(cfg.Level)
+		if err != nil {
+			log.Warnf("Unknown logs.daemon.level %q, using info", cfg.Level)
+		} else {
+			level = parsed
+		}
+	}
+	log.SetLevel(level)
+}
+
+// solConfig converts the configured SOL parameters for go-sol. The
+// volatile bit rate follows the non-volatile one unless set.
+func solConfig(c config.SOLConfig) sol.SOLConfig {
+	volatile := c.VolatileBitRate
+	if volatile == 0 {
+		volatile = c.BitRate
+	}
+	return sol.SOLConfig{
+		BitRate:            c.BitRate,
+		VolatileBitRate:    volatile,
+		RetryCount:         c.RetryCount,
+		RetryInterval:      c.RetryInterval,
+		AccumulateInterval: c.AccumulateInterval,
+		SendThreshold:      c.SendThreshold,
+	}
+}
+
+// serialConfigs converts ipmi.serial and the per-server serial settings
+// for go-sol. Invalid settings, which validation reports, are left out.
+func serialConfigs(cfg *config.Config) (sol.SerialConfig, map[string]sol.SerialConfig) {
+	parse := func(c config.SerialConfig) (sol.SerialConfig, error) {
+		return sol.ParseSerialConfig(c.Mux, c.Channel, c.UARTCommand)
+	}
+	def, err := parse(cfg.IPMI.Serial)
+	if err != nil {
+		log.Warnf("ipmi.serial not applied: %v", err)
+	}
+	servers := make(map[string]sol.SerialConfig)
+	for _, s := range cfg.Servers {
+		if s.Serial == nil {
+			continue
+		}
+		if servers[s.Name], err = parse(*s.Serial); err != nil {
+			log.Warnf("serial settings for %s not applied: %v", s.Name, err)
+		}
+	}
+	return def, servers
+}
+
+// backoffConfigs converts ipmi.backoff and the per-server backoff settings,
+// which inherit its unset fields.
+func backoffConfigs(cfg *config.Config) (sol.Backoff, map[string]sol.Backoff) {
+	convert := func(c config.BackoffConfig) sol.Backoff {
+		return sol.Backoff{Min: c.Min, Max: c.Max, Reset: c.Reset, Jitter: c.Jitter}
+	}
+	def := convert(cfg.IPMI.Backoff)
+	servers := make(map[string]sol.Backoff)
+	for _, s := range cfg.Servers {
+		if s.Backoff != nil {
+			servers[s.Name] = def.Merge(convert(*s.Backoff))
+		}
+	}
+	return def, servers
+}
+
+// transports converts ipmi.transport and the per-server transports.
+// Invalid settings, which validation reports, are left out.
+func transports(cfg *config.Config) (sol.Transport, map[string]sol.Transport) {
+	def, err := sol.ParseTransport(cfg.IPMI.Transport)
+	if err != nil {
+		log.Warnf("ipmi.transport not applied: %v", err)
+	}
+	servers := make(map[string]sol.Transport)
+	for _, s := range cfg.Servers {
+		if s.Transport == "" {
+			continue
+		}
+		t, err := sol.ParseTransport(s.Transport)
+		if err != nil {
+			log.Warnf("transport for %s not applied: %v", s.Name, err)
+			continue
+		}
+		servers[s.Name] = t
+	}
+	return def, servers
+}
+
+// ipmiTool converts ipmi.ipmitool for the sol package.
+func ipmiTool(c config.IPMIToolConfig) sol.IPMITool {
+	return sol.IPMITool{Path: c.Path, Interface: c.Interface, CipherSuite: c.CipherSuite, Args: c.Args}
+}
+
+// quirksSetting converts a quirks config section for the sol package.
+func quirksSetting(c config.QuirksConfig) sol.QuirksSetting {
+	return sol.QuirksSetting{
+		Profile:            c.Profile,
+		Privilege:          c.Privilege,
+		ActivateRetries:    c.ActivateRetries,
+		ActivateRetryDelay: c.ActivateRetryDelay,
+		SOLInstance:        c.SOLInstance,
+		DeactivateActive:   c.DeactivateActive,
+	}
+}
+
+// quirksConfigs converts ipmi.quirks and the per-server quirks, which
+// inherit its unset fields. Invalid settings, which validation reports, are
+// left out.
+func quirksConfigs(cfg *config.Config) (sol.QuirksSetting, map[string]sol.QuirksSetting) {
+	def := quirksSetting(cfg.IPMI.Quirks)
+	if err := def.Validate(); err != nil {
+		log.Warnf("ipmi.quirks not applied: %v", err)
+		def = sol.QuirksSetting{}
+	}
+	servers := make(map[string]sol.QuirksSetting)
+	for _, s := range cfg.Servers {
+		if s.Quirks == nil {
+			continue
+		}
+		q := def.Merge(quirksSetting(*s.Quirks))
+		if err := q.Validate(); err != nil {
+			log.Warnf("quirks for %s not applied: %v", s.Name, err)
+			continue
+		}
+		servers[s.Name] = q
+	}
+	return def, servers
+}
+
+// connectionSetting converts a connection config section for the sol
+// package.
+func connectionSetting(c config.ConnectionConfig) sol.ConnectionSetting {
+	return sol.ConnectionSetting{
+		Timeout:           c.Timeout,
+		InactivityTimeout: c.InactivityTimeout,
+		KeepaliveInterval: c.KeepaliveInterval,
+		CipherSuite:       c.CipherSuite,
+		AllowV15:          c.AllowV15,
+	}
+}
+
+// connectionConfigs converts ipmi.connection and the per-server connection
+// settings, which inherit its unset fields. Invalid settings, which
+// validation reports, are left out.
+func connectionConfigs(cfg *config.Config) (sol.ConnectionSetting, map[string]sol.ConnectionSetting) {
+	def := connectionSetting(cfg.IPMI.Connection)
+	if err := def.Validate(); err != nil {
+		log.Warnf("ipmi.connection not applied: %v", err)
+		def = sol.ConnectionSetting{}
+	}
+	servers := make(map[string]sol.ConnectionSetting)
+	for _, s := range cfg.Servers {
+		if s.Connection == nil {
+			continue
+		}
+		c := def.Merge(connectionSetting(*s.Connection))
+		if err := c.Validate(); err != nil {
+			log.Warnf("connection settings for %s not applied: %v", s.Name, err)
+			continue
+		}
+		servers[s.Name] = c
+	}
+	return def, servers
+}
+
+// scrollbackSizes converts logs.scrollback_mb and the per-server overrides
+// to bytes.
+func scrollbackSizes(cfg *config.Config) (int, map[string]int) {
+	servers := make(map[string]int)
+	for _, s := range cfg.Servers {
+		if s.ScrollbackMB > 0 {
+			servers[s.Name] = int(s.ScrollbackMB * (1 << 20))
+		}
+	}
+	return int(cfg.Logs.ScrollbackMB * (1 << 20)), servers
+}
+
+// proxyDialers builds ipmi.proxy and the per-server proxies. Servers with
+// the same settings share a proxy, and so one jump host connection.
+func proxyDialers(cfg *config.Config) (bmcproxy.DialFunc, map[string]bmcproxy.DialFunc, error) {
+	built := make(map[config.ProxyConfig]bmcproxy.DialFunc)
+	build := func(c config.ProxyConfig) (bmcproxy.DialFunc, error) {
+		if dial, ok := built[c]; ok {
+			return dial, nil
+		}
+		dial, err := bmcproxy.New(c)
+		if err != nil {
+			return nil, err
+		}
+		built[c] = dial
+		return dial, nil
+	}
+	def, err := build(cfg.IPMI.Proxy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ipmi.proxy: %w", err)
+	}
+	servers := make(map[string]bmcproxy.DialFunc)
+	for _, s := range cfg.Servers {
+		if s.Proxy == nil {
+			continue
+		}
+		if servers[s.Name], err = build(*s.Proxy); err != nil {
+			return nil, nil, fmt.Errorf("proxy for %s: %w", s.Name, err)
+		}
+	}
+	return def, servers, nil
+}
+
+// gbToBytes converts logs.max_total_size_gb to bytes.
+func gbToBytes(gb float64) int64 {
+	return int64(gb * (1 << 30))
+}
@@ -0,0 +1,413 @@
+// Package mqtt publishes server status, lifecycle events and console lines
+// to an MQTT broker. It includes a minimal MQTT 3.1.1 client: connect with
+// optional credentials and a will, publish at QoS 0 or 1, keep alive and
+// disconnect. Subscriptions aren't needed and aren't supported.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Control packet types.
+const (
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPubAck     = 4
+	packetPingReq    = 12
+	packetPingResp   = 13
+	packetDisconnect = 14
+)
+
+// maxRemainingLength is the largest packet body MQTT can frame.
+const maxRemainingLength = 268435455
+
+// connAckErrors are the CONNACK return codes refusing a connection.
+var connAckErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// ErrClosed is returned for publishes on a closed connection.
+var ErrClosed = errors.New("mqtt: connection closed")
+
+// Message is an application message.
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte // 0 or 1
+	Retain  bool
+}
+
+// Options configure a connection.
+type Options struct {
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration // 0 disables keep alive
+	Will      *Message      // published by the broker if the connection is lost
+	TLS       *tls.Config   // for tls://, ssl:// and mqtts:// brokers
+}
+
+// Client is a connection to a broker. Publish is safe for concurrent use.
+type Client struct {
+	conn      net.Conn
+	keepAlive time.Duration
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan struct{} // QoS 1 publishes awaiting PUBACK
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Dial connects to broker, a tcp://host[:1883] or tls://host[:8883] URL,
+// and completes the MQTT handshake.
+func Dial(ctx context.Context, broker string, opts Options) (*Client, error) {
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("mqtt: broker %q: want tcp://host:port or tls://host:port", broker)
+	}
+	var useTLS bool
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "tls", "ssl", "mqtts":
+		useTLS, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("mqtt: broker %q: unsupported scheme %q", broker, u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		cfg := &tls.Config{}
+		if opts.TLS != nil {
+			cfg = opts.TLS.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	if _, err := conn.Write(connectPacket(opts)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	typ, body, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: reading CONNACK: %w", err)
+	}
+	if typ != packetConnAck || len(body) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: expected CONNACK, got packet type %d", typ)
+	}
+	if code := body[1]; code != 0 {
+		conn.Close()
+		if msg, ok := connAckErrors[code]; ok {
+			return nil, fmt.Errorf("mqtt: connection refused: %s", msg)
+		}
+		return nil, fmt.Errorf("mqtt: connection refused (code %d)", code)
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{
+		conn:      conn,
+		keepAlive: opts.KeepAlive,
+		pending:   make(map[uint16]chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.readLoop(r)
+	if c.keepAlive > 0 {
+		go c.pingLoop()
+	}
+	return c, nil
+}
+
+// Publish sends a message. At QoS 1 it waits for the broker's PUBACK or
+// ctx.
+func (c *Client) Publish(ctx context.Context, m Message) error {
+	var id uint16
+	var acked chan struct{}
+	if m.QoS > 0 {
+		c.mu.Lock()
+		for {
+			c.nextID++
+			if c.nextID != 0 && c.pending[c.nextID] == nil {
+				break
+			}
+		}
+		id = c.nextID
+		acked = make(chan struct{})
+		c.pending[id] = acked
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+		}()
+	}
+
+	pkt, err := publishPacket(m, id)
+	if err != nil {
+		return err
+	}
+	if err := c.write(pkt); err != nil {
+		return err
+	}
+	if acked == nil {
+		return nil
+	}
+	select {
+	case <-acked:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err reports why the connection ended.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Disconnect ends the session cleanly, so the broker doesn't publish the
+// will, and closes the connection.
+func (c *Client) Disconnect() error {
+	err := c.write([]byte{packetDisconnect << 4, 0})
+	c.shutdown(ErrClosed)
+	return err
+}
+
+// Close drops the connection without a DISCONNECT; the broker publishes
+// the will.
+func (c *Client) Close() error {
+	c.shutdown(ErrClosed)
+	return nil
+}
+
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+func (c *Client) write(pkt []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(pkt); err != nil {
+		c.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		if c.keepAlive > 0 {
+			// A ping goes out every keep alive, so silence for two means the
+			// broker or the path to it is gone
+			c.conn.SetReadDeadline(time.Now().Add(2 * c.keepAlive))
+		}
+		typ, body, err := readPacket(r)
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("mqtt: connection closed by broker")
+			}
+			c.shutdown(err)
+			return
+		}
+		if typ == packetPubAck && len(body) >= 2 {
+			id := binary.BigEndian.Uint16(body)
+			c.mu.Lock()
+			if ch := c.pending[id]; ch != nil {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+		}
+		// PINGRESP only proves the connection is alive; nothing else is
+		// expected without subscriptions
+	}
+}
+
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if c.write([]byte{packetPingReq << 4, 0}) != nil {
+				return
+			}
+		}
+	}
+}
+
+func connectPacket(opts Options) []byte {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if w := opts.Will; w != nil {
+		flags |= 0x04 | (w.QoS&0x03)<<3
+		if w.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, w.Topic)
+		payload = appendBytes(payload, w.Payload)
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+		if opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, opts.Password)
+		}
+	}
+	keepAlive := opts.KeepAlive / time.Second
+	if keepAlive > 0xFFFF {
+		keepAlive = 0xFFFF
+	}
+
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 = 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive))
+	body = append(body, payload...)
+	return framePacket(packetConnect<<4, body)
+}
+
+func publishPacket(m Message, id uint16) ([]byte, error) {
+	if m.Topic == "" {
+		return nil, errors.New("mqtt: empty topic")
+	}
+	if m.QoS > 1 {
+		return nil, fmt.Errorf("mqtt: QoS %d not supported", m.QoS)
+	}
+	header := byte(packetPublish<<4) | m.QoS<<1
+	if m.Retain {
+		header |= 0x01
+	}
+	var body []byte
+	body = appendString(body, m.Topic)
+	if m.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, m.Payload...)
+	if len(body) > maxRemainingLength {
+		return nil, fmt.Errorf("mqtt: message of %d bytes too large", len(m.Payload))
+	}
+	return framePacket(header, body), nil
+}
+
+// framePacket prepends the fixed header: the type and flags byte and the
+// variable-length remaining length.
+func framePacket(header byte, body []byte) []byte {
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(pkt, body...)
+}
+
+func readPacket(r *bufio.Reader) (typ byte, body []byte, err error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header >> 4, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,331 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/events"
+	"ipmiserial/logs"
+	"ipmiserial/sol"
+)
+
+const (
+	// publishTimeout bounds waiting for a QoS 1 acknowledgement.
+	publishTimeout = 10 * time.Second
+	// maxReconnectDelay caps the backoff between connection attempts.
+	maxReconnectDelay = time.Minute
+)
+
+// errNotConnected is returned for console lines while the broker is
+// unreachable; the log sink registry counts them.
+var errNotConnected = errors.New("mqtt: not connected")
+
+// Status is the retained message on <prefix>/<server>/status.
+type Status struct {
+	Server        string     `json:"server"`
+	IP            string     `json:"ip,omitempty"`
+	Online        bool       `json:"online"`
+	Connected     bool       `json:"connected"`
+	Standby       bool       `json:"standby,omitempty"`
+	State         string     `json:"state,omitempty"` // session state, e.g. active, backoff
+	LastError     string     `json:"lastError,omitempty"`
+	OS            string     `json:"os,omitempty"`
+	Booting       bool       `json:"booting,omitempty"`  // a boot has started but not completed
+	LastBoot      *time.Time `json:"lastBoot,omitempty"` // start of the current boot
+	RebootLooping bool       `json:"rebootLooping,omitempty"`
+	Time          time.Time  `json:"time"`
+}
+
+// Publisher keeps a broker connection open and publishes:
+//
+//	<prefix>/status            "online", or "offline" (retained; the will)
+//	<prefix>/<server>/status   Status as JSON (retained), on changes and every status_interval
+//	<prefix>/<server>/events   lifecycle events from the event bus, as JSON
+//	<prefix>/<server>/console  cleaned console lines, when registered as a log sink
+//
+// The connection is re-established with backoff when it drops; what
+// happens meanwhile is not queued, but every status is republished on
+// reconnect.
+type Publisher struct {
+	cfg        config.MQTTConfig
+	opts       Options
+	bus        *events.Bus
+	scanner    *discovery.Scanner
+	solManager *sol.Manager
+	servers    map[string]bool // nil = all
+	lines      *logs.LineBuffer
+
+	mu        sync.Mutex
+	client    *Client         // nil while disconnected
+	published map[string]bool // servers with a retained status
+}
+
+// NewPublisher checks cfg and creates a publisher; Run connects it.
+func NewPublisher(cfg config.MQTTConfig, bus *events.Bus, scanner *discovery.Scanner, solManager *sol.Manager) (*Publisher, error) {
+	if cfg.QoS < 0 || cfg.QoS > 1 {
+		return nil, fmt.Errorf("qos must be 0 or 1")
+	}
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "ipmiserial"
+	}
+	cfg.TopicPrefix = strings.TrimSuffix(cfg.TopicPrefix, "/")
+	clientID := cfg.ClientID
+	if clientID == "" {
+		host, _ := os.Hostname()
+		clientID = "ipmiserial-" + host
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" && !cfg.InsecureSkipVerify {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	p := &Publisher{
+		cfg: cfg,
+		opts: Options{
+			ClientID:  clientID,
+			Username:  cfg.Username,
+			Password:  cfg.Password,
+			KeepAlive: cfg.KeepAlive,
+			Will:      &Message{Topic: cfg.TopicPrefix + "/status", Payload: []byte("offline"), QoS: byte(cfg.QoS), Retain: true},
+			TLS:       tlsConfig,
+		},
+		bus:        bus,
+		scanner:    scanner,
+		solManager: solManager,
+		lines:      logs.NewLineBuffer(),
+		published:  make(map[string]bool),
+	}
+	if len(cfg.Servers) > 0 {
+		p.servers = make(map[string]bool)
+		for _, s := range cfg.Servers {
+			p.servers[s] = true
+		}
+	}
+	return p, nil
+}
+
+// Run publishes until ctx is done, then marks ipmiserial offline and
+// disconnects.
+func (p *Publisher) Run(ctx context.Context) {
+	evs := p.bus.Subscribe()
+	defer p.bus.Unsubscribe(evs)
+
+	statusTicker := time.NewTicker(p.cfg.StatusInterval)
+	if p.cfg.StatusInterval <= 0 {
+		statusTicker.Stop()
+	}
+	defer statusTicker.Stop()
+
+	reconnect := time.NewTimer(0)
+	defer reconnect.Stop()
+	delay := time.Second
+	var lost <-chan struct{}
+	for {
+		select {
+		case <-ctx.Done():
+			p.disconnect()
+			return
+		case <-reconnect.C:
+			client, err := p.connect(ctx)
+			if err != nil {
+				log.Warnf("MQTT: %s: %v, retrying in %v", p.cfg.Broker, err, delay)
+				reconnect.Reset(delay)
+				delay = min(delay*2, maxReconnectDelay)
+				continue
+			}
+			delay = time.Second
+			lost = client.Done()
+			log.Infof("MQTT: connected to %s as %s", p.cfg.Broker, p.opts.ClientID)
+			p.publishAll(ctx)
+		case <-lost:
+			p.mu.Lock()
+			err := p.client.Err()
+			p.client = nil
+			p.mu.Unlock()
+			lost = nil
+			log.Warnf("MQTT: connection to %s lost: %v", p.cfg.Broker, err)
+			reconnect.Reset(delay)
+		case <-statusTicker.C:
+			p.publishAll(ctx)
+		case ev := <-evs:
+			p.publishEvent(ctx, ev)
+		}
+	}
+}
+
+func (p *Publisher) connect(ctx context.Context) (*Client, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	client, err := Dial(dialCtx, p.cfg.Broker, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	online := Message{Topic: p.cfg.TopicPrefix + "/status", Payload: []byte("online"), QoS: byte(p.cfg.QoS), Retain: true}
+	if err := client.Publish(dialCtx, online); err != nil {
+		client.Close()
+		return nil, err
+	}
+	p.mu.Lock()
+	p.client = client
+	p.mu.Unlock()
+	return client, nil
+}
+
+// disconnect publishes "offline" itself, since a clean disconnect doesn't
+// trigger the will.
+func (p *Publisher) disconnect() {
+	p.mu.Lock()
+	client := p.client
+	p.client = nil
+	p.mu.Unlock()
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client.Publish(ctx, Message{Topic: p.cfg.TopicPrefix + "/status", Payload: []byte("offline"), QoS: byte(p.cfg.QoS), Retain: true})
+	client.Disconnect()
+}
+
+func (p *Publisher) publish(ctx context.Context, m Message) error {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+	if client == nil {
+		return errNotConnected
+	}
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	return client.Publish(ctx, m)
+}
+
+func (p *Publisher) wants(server string) bool {
+	return server != "" && (p.servers == nil || p.servers[server])
+}
+
+// serverTopic is <prefix>/<server>/<leaf>, with MQTT's separator and
+// wildcards in the server name replaced.
+func (p *Publisher) serverTopic(server, leaf string) string {
+	name := strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(server)
+	return p.cfg.TopicPrefix + "/" + name + "/" + leaf
+}
+
+// publishEvent sends a bus event and refreshes its server's status.
+func (p *Publisher) publishEvent(ctx context.Context, ev events.Event) {
+	if !p.wants(ev.Server) {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if err := p.publish(ctx, Message{Topic: p.serverTopic(ev.Server, "events"), Payload: data, QoS: byte(p.cfg.QoS)}); err != nil {
+		log.Debugf("MQTT: %s event for %s not sent: %v", ev.Type, ev.Server, err)
+		return
+	}
+	if ev.Type == events.ServerRemoved {
+		p.clearStatus(ctx, ev.Server)
+	} else {
+		p.publishStatus(ctx, ev.Server, p.scanner.GetServers()[ev.Server])
+	}
+}
+
+// publishAll republishes every server's status and clears the retained
+// status of servers that are gone.
+func (p *Publisher) publishAll(ctx context.Context) {
+	servers := p.scanner.GetServers()
+	for name, s := range servers {
+		if p.wants(name) {
+			p.publishStatus(ctx, name, s)
+		}
+	}
+	p.mu.Lock()
+	var gone []string
+	for name := range p.published {
+		if _, ok := servers[name]; !ok {
+			gone = append(gone, name)
+		}
+	}
+	p.mu.Unlock()
+	for _, name := range gone {
+		p.clearStatus(ctx, name)
+	}
+}
+
+func (p *Publisher) publishStatus(ctx context.Context, name string, s *discovery.Server) {
+	st := Status{Server: name, Time: time.Now()}
+	if s != nil {
+		st.IP, st.Online = s.IP, s.Online
+	}
+	if session := p.solManager.GetSession(name); session != nil {
+		st.Connected = session.Connected
+		st.Standby = session.Standby
+		st.State = string(session.Status().State)
+		st.LastError = session.LastError
+	}
+	a := p.solManager.GetAnalytics(name)
+	st.OS, st.RebootLooping = a.CurrentOS, a.RebootLooping
+	if b := a.CurrentBoot; b != nil {
+		start := b.StartTime
+		st.Booting, st.LastBoot = !b.Complete, &start
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return
+	}
+	if err := p.publish(ctx, Message{Topic: p.serverTopic(name, "status"), Payload: data, QoS: byte(p.cfg.QoS), Retain: true}); err != nil {
+		log.Debugf("MQTT: status for %s not sent: %v", name, err)
+		return
+	}
+	p.mu.Lock()
+	p.published[name] = true
+	p.mu.Unlock()
+}
+
+// clearStatus deletes a server's retained status: an empty retained
+// message removes it from the broker.
+func (p *Publisher) clearStatus(ctx context.Context, name string) {
+	if err := p.publish(ctx, Message{Topic: p.serverTopic(name, "status"), QoS: byte(p.cfg.QoS), Retain: true}); err != nil {
+		return
+	}
+	p.mu.Lock()
+	delete(p.published, name)
+	p.mu.Unlock()
+}
+
+// Write takes a chunk of a server's cleaned log output (a SinkCleaned
+// sink) and publishes each line it completes at QoS 0. The registry calls
+// it from one goroutine.
+func (p *Publisher) Write(serverName string, data []byte) error {
+	lines := p.lines.Lines(serverName, data)
+	if !p.wants(serverName) {
+		return nil
+	}
+	topic := p.serverTopic(serverName, "console")
+	for _, line := range lines {
+		if err := p.publish(context.Background(), Message{Topic: topic, Payload: []byte(line)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
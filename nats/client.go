@@ -0,0 +1,348 @@
+// Package nats is a minimal NATS client: connect with a user/password or
+// token, optionally over TLS, publish, and make requests (as JetStream's
+// API and publish acknowledgements need). Only the reply inbox is
+// subscribed to.
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxControlLine bounds a protocol line from the server.
+const maxControlLine = 4096
+
+// ErrClosed is returned for operations on a closed connection.
+var ErrClosed = errors.New("nats: connection closed")
+
+// Options configure a connection. Credentials in the URL's user info are
+// used when User and Token are empty.
+type Options struct {
+	Name     string // shown in the server's connection list
+	User     string
+	Password string
+	Token    string
+	TLS      *tls.Config // for tls:// URLs, or when the server requires TLS
+}
+
+// serverInfo is the part of the server's INFO the client uses.
+type serverInfo struct {
+	TLSRequired  bool `json:"tls_required"`
+	AuthRequired bool `json:"auth_required"`
+	MaxPayload   int  `json:"max_payload"`
+}
+
+// Conn is a connection to a NATS server. It is safe for concurrent use.
+type Conn struct {
+	conn       net.Conn
+	maxPayload int
+	inbox      string // reply subject prefix, "_INBOX.<random>."
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu      sync.Mutex
+	nextReq uint64
+	waiting map[string]chan []byte // reply subject -> waiting request
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Dial connects to a nats://host[:4222] or tls://host[:4222] URL.
+func Dial(ctx context.Context, rawURL string, opts Options) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("nats: url %q: want nats://host:port or tls://host:port", rawURL)
+	}
+	var wantTLS bool
+	switch u.Scheme {
+	case "nats":
+	case "tls":
+		wantTLS = true
+	default:
+		return nil, fmt.Errorf("nats: url %q: unsupported scheme %q", rawURL, u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if opts.User == "" && opts.Token == "" && u.User != nil {
+		if pw, ok := u.User.Password(); ok {
+			opts.User, opts.Password = u.User.Username(), pw
+		} else {
+			opts.Token = u.User.Username()
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	fail := func(err error) (*Conn, error) {
+		conn.Close()
+		return nil, err
+	}
+
+	// The server speaks first, in plain text, even when TLS follows
+	r := bufio.NewReaderSize(conn, 32<<10)
+	line, err := readLine(r)
+	if err != nil {
+		return fail(fmt.Errorf("nats: reading INFO: %w", err))
+	}
+	op, args, _ := strings.Cut(line, " ")
+	if op != "INFO" {
+		return fail(fmt.Errorf("nats: expected INFO, got %q", line))
+	}
+	var info serverInfo
+	if err := json.Unmarshal([]byte(args), &info); err != nil {
+		return fail(fmt.Errorf("nats: bad INFO: %w", err))
+	}
+	if wantTLS || info.TLSRequired {
+		cfg := &tls.Config{}
+		if opts.TLS != nil {
+			cfg = opts.TLS.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fail(err)
+		}
+		conn = tlsConn
+		r = bufio.NewReaderSize(conn, 32<<10)
+	}
+
+	connect := map[string]interface{}{
+		"verbose":      false,
+		"pedantic":     false,
+		"tls_required": wantTLS || info.TLSRequired,
+		"name":         opts.Name,
+		"lang":         "go",
+		"version":      "ipmiserial",
+		"protocol":     1,
+	}
+	if opts.Token != "" {
+		connect["auth_token"] = opts.Token
+	} else if opts.User != "" {
+		connect["user"], connect["pass"] = opts.User, opts.Password
+	}
+	data, _ := json.Marshal(connect)
+	inbox := "_INBOX." + randomToken() + "."
+	// PING is answered after CONNECT is processed, so PONG (rather than
+	// -ERR) means we're in
+	hello := "CONNECT " + string(data) + "\r\nSUB " + inbox + "* 1\r\nPING\r\n"
+	if _, err := io.WriteString(conn, hello); err != nil {
+		return fail(err)
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return fail(fmt.Errorf("nats: connecting: %w", err))
+		}
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return fail(fmt.Errorf("nats: %s", serverError(line)))
+		}
+		// +OK and INFO updates
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Conn{
+		conn:       conn,
+		maxPayload: info.MaxPayload,
+		inbox:      inbox,
+		w:          bufio.NewWriter(conn),
+		waiting:    make(map[string]chan []byte),
+		done:       make(chan struct{}),
+	}
+	go c.readLoop(r)
+	return c, nil
+}
+
+// Publish sends data to subject. It returns once the data is written, with
+// no acknowledgement; use Request for JetStream.
+func (c *Conn) Publish(subject string, data []byte) error {
+	return c.publish(subject, "", data)
+}
+
+// Request publishes data with a reply subject and waits for the response.
+func (c *Conn) Request(ctx context.Context, subject string, data []byte) ([]byte, error) {
+	c.mu.Lock()
+	c.nextReq++
+	reply := c.inbox + strconv.FormatUint(c.nextReq, 10)
+	ch := make(chan []byte, 1)
+	c.waiting[reply] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.waiting, reply)
+		c.mu.Unlock()
+	}()
+
+	if err := c.publish(subject, reply, data); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-ctx.Done():
+		return nil, fmt.Errorf("nats: no response on %s: %w", subject, ctx.Err())
+	}
+}
+
+func (c *Conn) publish(subject, reply string, data []byte) error {
+	if c.maxPayload > 0 && len(data) > c.maxPayload {
+		return fmt.Errorf("nats: payload of %d bytes exceeds the server's %d", len(data), c.maxPayload)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+	c.w.WriteString("PUB ")
+	c.w.WriteString(subject)
+	if reply != "" {
+		c.w.WriteString(" ")
+		c.w.WriteString(reply)
+	}
+	c.w.WriteString(" ")
+	c.w.WriteString(strconv.Itoa(len(data)))
+	c.w.WriteString("\r\n")
+	c.w.Write(data)
+	c.w.WriteString("\r\n")
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := c.w.Flush(); err != nil {
+		c.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+// Done is closed when the connection is lost or closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err reports why the connection ended.
+func (c *Conn) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.shutdown(ErrClosed)
+	return nil
+}
+
+func (c *Conn) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+func (c *Conn) readLoop(r *bufio.Reader) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("nats: connection closed by server")
+			}
+			c.shutdown(err)
+			return
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "PING":
+			c.writeMu.Lock()
+			c.w.WriteString("PONG\r\n")
+			c.w.Flush()
+			c.writeMu.Unlock()
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			f := strings.Fields(args)
+			if len(f) < 3 {
+				c.shutdown(fmt.Errorf("nats: malformed MSG %q", line))
+				return
+			}
+			n, err := strconv.Atoi(f[len(f)-1])
+			if err != nil || n < 0 {
+				c.shutdown(fmt.Errorf("nats: malformed MSG %q", line))
+				return
+			}
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				c.shutdown(err)
+				return
+			}
+			c.mu.Lock()
+			ch := c.waiting[f[0]]
+			c.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- payload[:n]:
+				default:
+				}
+			}
+		case "-ERR":
+			c.shutdown(fmt.Errorf("nats: %s", serverError(line)))
+			return
+		}
+		// +OK, PONG and INFO need no action
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxControlLine {
+		return "", errors.New("nats: control line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
+
+// serverError extracts the message from "-ERR 'message'".
+func serverError(line string) string {
+	return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'")
+}
+
+func randomToken() string {
+	b := make([]byte, 11)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
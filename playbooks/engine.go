@@ -0,0 +1,593 @@
+package playbooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+	"ipmiserial/logs"
+	"ipmiserial/sol"
+)
+
+// maxRuns bounds how many finished runs are kept for the API.
+const maxRuns = 100
+
+// maxStepsPerRun stops runaway playbooks that jump in a loop.
+const maxStepsPerRun = 100
+
+// ansiRegex strips escape sequences before wait_for pattern matching.
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]|\x1b\][^\x07]*\x07|\x1b[()][AB012]|\x1b[=>]|\x1b[78]|\x1b[DMEHc]`)
+
+// Run states
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+	StatePending   = "pending"
+	StateSkipped   = "skipped"
+)
+
+// StepStatus is the progress of a single step within a run.
+type StepStatus struct {
+	Index     int        `json:"index"`
+	Name      string     `json:"name,omitempty"`
+	Action    string     `json:"action"`
+	State     string     `json:"state"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+	Message   string     `json:"message,omitempty"`
+}
+
+// Run is one execution of a playbook against a server.
+type Run struct {
+	ID        string       `json:"id"`
+	Playbook  string       `json:"playbook"`
+	Server    string       `json:"server"`
+	Trigger   string       `json:"trigger"` // "manual" or the name of the triggering rule
+	State     string       `json:"state"`
+	StartedAt time.Time    `json:"startedAt"`
+	EndedAt   *time.Time   `json:"endedAt,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Steps     []StepStatus `json:"steps"`
+
+	cancel context.CancelFunc
+}
+
+// Engine executes playbooks defined in config against managed servers.
+type Engine struct {
+	playbooks  map[string]config.Playbook
+	solManager *sol.Manager
+	logWriter  *logs.Writer
+	runs       []*Run
+	onNotify   func(Notification)
+	mu         sync.RWMutex
+}
+
+// Notification is a notify step's message, sent on as an alert.
+type Notification struct {
+	Playbook string
+	Server   string
+	RunID    string
+	Severity string
+	Message  string
+	Notify   []string // notifier names; empty = all
+}
+
+func NewEngine(defs []config.Playbook, solManager *sol.Manager, logWriter *logs.Writer) *Engine {
+	e := &Engine{
+		solManager: solManager,
+		logWriter:  logWriter,
+	}
+	e.SetPlaybooks(defs)
+	return e
+}
+
+// SetPlaybooks replaces the playbook definitions. Runs already in progress
+// keep the definition they started with.
+func (e *Engine) SetPlaybooks(defs []config.Playbook) {
+	playbooks := make(map[string]config.Playbook)
+	for _, pb := range defs {
+		if err := Validate(pb); err != nil {
+			log.Warnf("Skipping playbook %q: %v", pb.Name, err)
+			continue
+		}
+		playbooks[pb.Name] = pb
+	}
+	if len(playbooks) > 0 {
+		log.Infof("Loaded %d playbooks", len(playbooks))
+	}
+
+	e.mu.Lock()
+	e.playbooks = playbooks
+	e.mu.Unlock()
+}
+
+// Validate checks a playbook definition for unknown actions, bad arguments,
+// and jumps to steps that don't exist.
+func Validate(pb config.Playbook) error {
+	if pb.Name == "" {
+		return fmt.Errorf("playbook name is required")
+	}
+	if len(pb.Steps) == 0 {
+		return fmt.Errorf("playbook has no steps")
+	}
+	names := make(map[string]bool)
+	for _, st := range pb.Steps {
+		if st.Name != "" {
+			names[st.Name] = true
+		}
+	}
+	checkJump := func(i int, target string) error {
+		switch target {
+		case "", "continue", "done", "fail":
+			return nil
+		}
+		if !names[target] {
+			return fmt.Errorf("step %d: jump target %q not found", i, target)
+		}
+		return nil
+	}
+	for i, st := range pb.Steps {
+		switch st.Action {
+		case "power":
+			if !sol.ValidPowerAction(st.State) {
+				return fmt.Errorf("step %d: invalid power state %q", i, st.State)
+			}
+		case "bootdev":
+			if !sol.ValidBootDevice(st.Device) {
+				return fmt.Errorf("step %d: invalid boot device %q", i, st.Device)
+			}
+		case "sleep":
+			if st.Duration <= 0 {
+				return fmt.Errorf("step %d: sleep requires a duration", i)
+			}
+		case "wait_for":
+			if st.Pattern == "" {
+				return fmt.Errorf("step %d: wait_for requires a pattern", i)
+			}
+			if _, err := regexp.Compile(st.Pattern); err != nil {
+				return fmt.Errorf("step %d: invalid pattern: %w", i, err)
+			}
+		case "wait_boot":
+			if st.Timeout < 0 {
+				return fmt.Errorf("step %d: wait_boot timeout must not be negative", i)
+			}
+		case "notify", "rotate":
+		default:
+			return fmt.Errorf("step %d: unknown action %q", i, st.Action)
+		}
+		if err := checkJump(i, st.OnSuccess); err != nil {
+			return err
+		}
+		if err := checkJump(i, st.OnTimeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnNotify registers fn to be called with every notify step's message.
+func (e *Engine) OnNotify(fn func(Notification)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onNotify = fn
+}
+
+// Playbooks returns the loaded playbook definitions.
+func (e *Engine) Playbooks() []config.Playbook {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]config.Playbook, 0, len(e.playbooks))
+	for _, pb := range e.playbooks {
+		out = append(out, pb)
+	}
+	return out
+}
+
+// Start launches a playbook against a server and returns the run immediately.
+func (e *Engine) Start(playbook, serverName, trigger string) (*Run, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pb, ok := e.playbooks[playbook]
+	if !ok {
+		return nil, fmt.Errorf("playbook not found: %s", playbook)
+	}
+	return e.start(pb, serverName, trigger)
+}
+
+// start launches pb. Must be called with e.mu held.
+func (e *Engine) start(pb config.Playbook, serverName, trigger string) (*Run, error) {
+	for _, r := range e.runs {
+		if r.Server == serverName && r.State == StateRunning {
+			return nil, fmt.Errorf("playbook %s already running on %s", r.Playbook, serverName)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &Run{
+		ID:        newRunID(),
+		Playbook:  pb.Name,
+		Server:    serverName,
+		Trigger:   trigger,
+		State:     StateRunning,
+		StartedAt: time.Now(),
+		cancel:    cancel,
+	}
+	for i, st := range pb.Steps {
+		run.Steps = append(run.Steps, StepStatus{Index: i, Name: st.Name, Action: st.Action, State: StatePending})
+	}
+	e.runs = append(e.runs, run)
+	if len(e.runs) > maxRuns {
+		e.runs = e.runs[len(e.runs)-maxRuns:]
+	}
+
+	log.Infof("Starting playbook %s on %s (run %s, trigger %s)", pb.Name, serverName, run.ID, trigger)
+	go e.execute(ctx, pb, run)
+	return e.snapshot(run), nil
+}
+
+// ReprovisionPlaybook is the name runs started by Reprovision carry.
+const ReprovisionPlaybook = "reprovision"
+
+// Reprovision describes a reprovision: the boot device to set, the power
+// action that reboots into it, the name of the log the new boot is written
+// to and how long to wait for the OS.
+type Reprovision struct {
+	Device     string        // default pxe
+	Persistent bool          // keep the boot device beyond the next boot
+	Power      string        // on, cycle or reset; default cycle
+	LogName    string        // default reprovision-<time>
+	Timeout    time.Duration // for the OS to come up; default 30m
+}
+
+// ReprovisionSteps builds the reprovision playbook: override the boot
+// device, rotate the log so the new boot gets its own named log, reboot,
+// then wait for boot analytics to see the OS come up.
+func ReprovisionSteps(r Reprovision) (config.Playbook, error) {
+	if r.Device == "" {
+		r.Device = "pxe"
+	}
+	if r.Power == "" {
+		r.Power = "cycle"
+	}
+	if r.LogName == "" {
+		r.LogName = "reprovision-" + time.Now().Format("2006-01-02_15-04-05")
+	}
+	if r.Timeout == 0 {
+		r.Timeout = 30 * time.Minute
+	}
+	switch r.Power {
+	case "on", "cycle", "reset":
+	default:
+		return config.Playbook{}, fmt.Errorf("power %q: want on, cycle or reset", r.Power)
+	}
+	pb := config.Playbook{
+		Name:        ReprovisionPlaybook,
+		Description: "Boot from " + r.Device + " and wait for the OS",
+		Steps: []config.PlaybookStep{
+			{Name: "bootdev", Action: "bootdev", Device: r.Device, Persistent: r.Persistent},
+			{Name: "rotate", Action: "rotate", LogName: r.LogName},
+			{Name: "power", Action: "power", State: r.Power},
+			{Name: "wait_os", Action: "wait_boot", Timeout: r.Timeout},
+		},
+	}
+	return pb, Validate(pb)
+}
+
+// Reprovision starts a reprovision of a server and returns the run, whose
+// ID tracks its progress, immediately.
+func (e *Engine) Reprovision(serverName string, r Reprovision) (*Run, error) {
+	pb, err := ReprovisionSteps(r)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.start(pb, serverName, "manual")
+}
+
+// Cancel stops a running playbook.
+func (e *Engine) Cancel(id string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, r := range e.runs {
+		if r.ID == id {
+			if r.State != StateRunning {
+				return fmt.Errorf("run %s is not running", id)
+			}
+			r.cancel()
+			return nil
+		}
+	}
+	return fmt.Errorf("run not found: %s", id)
+}
+
+// GetRun returns a copy of a run's current status.
+func (e *Engine) GetRun(id string) *Run {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, r := range e.runs {
+		if r.ID == id {
+			return e.snapshot(r)
+		}
+	}
+	return nil
+}
+
+// Runs returns copies of all known runs, newest first, optionally filtered by server.
+func (e *Engine) Runs(serverName string) []*Run {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]*Run, 0, len(e.runs))
+	for i := len(e.runs) - 1; i >= 0; i-- {
+		if serverName == "" || e.runs[i].Server == serverName {
+			out = append(out, e.snapshot(e.runs[i]))
+		}
+	}
+	return out
+}
+
+// snapshot copies a run for the API. Must be called with e.mu held.
+func (e *Engine) snapshot(r *Run) *Run {
+	c := *r
+	c.Steps = append([]StepStatus{}, r.Steps...)
+	c.cancel = nil
+	return &c
+}
+
+func (e *Engine) execute(ctx context.Context, pb config.Playbook, run *Run) {
+	defer run.cancel()
+
+	index := make(map[string]int)
+	for i, st := range pb.Steps {
+		if st.Name != "" {
+			index[st.Name] = i
+		}
+	}
+
+	state, errMsg := StateSucceeded, ""
+	executed := 0
+	i := 0
+	for i < len(pb.Steps) {
+		if executed >= maxStepsPerRun {
+			state, errMsg = StateFailed, "step limit exceeded (jump loop?)"
+			break
+		}
+		executed++
+
+		st := pb.Steps[i]
+		e.updateStep(run, i, StateRunning, "")
+		matched, msg, err := e.runStep(ctx, run, st)
+		if ctx.Err() != nil {
+			e.updateStep(run, i, StateCancelled, "")
+			state, errMsg = StateCancelled, "cancelled"
+			break
+		}
+		if err != nil {
+			e.updateStep(run, i, StateFailed, err.Error())
+			state, errMsg = StateFailed, fmt.Sprintf("step %d (%s): %v", i, st.Action, err)
+			break
+		}
+		e.updateStep(run, i, StateSucceeded, msg)
+
+		next := "continue"
+		if st.Action == "wait_for" || st.Action == "wait_boot" {
+			if matched && st.OnSuccess != "" {
+				next = st.OnSuccess
+			} else if !matched {
+				next = st.OnTimeout
+				if next == "" {
+					next = "fail"
+				}
+			}
+		}
+		switch next {
+		case "continue":
+			i++
+		case "done":
+			i = len(pb.Steps)
+		case "fail":
+			state, errMsg = StateFailed, fmt.Sprintf("step %d (%s): %s", i, st.Action, msg)
+			i = len(pb.Steps)
+		default:
+			i = index[next]
+		}
+	}
+
+	// Anything not reached is skipped
+	e.mu.Lock()
+	for j := range run.Steps {
+		if run.Steps[j].State == StatePending {
+			run.Steps[j].State = StateSkipped
+		}
+	}
+	now := time.Now()
+	run.State = state
+	run.Error = errMsg
+	run.EndedAt = &now
+	e.mu.Unlock()
+
+	log.Infof("Playbook %s on %s finished: %s %s", pb.Name, run.Server, state, errMsg)
+}
+
+func (e *Engine) updateStep(run *Run, i int, state, msg string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	st := &run.Steps[i]
+	st.State = state
+	if state == StateRunning {
+		st.StartedAt = &now
+	} else {
+		st.EndedAt = &now
+	}
+	if msg != "" {
+		st.Message = msg
+	}
+}
+
+// runStep executes one step of a run started at runStart. For wait_for and
+// wait_boot, matched reports whether the pattern or boot appeared before the
+// timeout; other steps always report true.
+func (e *Engine) runStep(ctx context.Context, run *Run, st config.PlaybookStep) (matched bool, msg string, err error) {
+	serverName, runStart := run.Server, run.StartedAt
+	switch st.Action {
+	case "power":
+		if err := e.solManager.PowerControl(serverName, st.State); err != nil {
+			return false, "", err
+		}
+		return true, "power " + st.State + " sent", nil
+
+	case "bootdev":
+		if err := e.solManager.SetBootDevice(serverName, st.Device, st.Persistent); err != nil {
+			return false, "", err
+		}
+		return true, "boot device set to " + st.Device, nil
+
+	case "sleep":
+		select {
+		case <-ctx.Done():
+			return false, "", ctx.Err()
+		case <-time.After(st.Duration):
+		}
+		return true, "slept " + st.Duration.String(), nil
+
+	case "wait_for":
+		return e.waitFor(ctx, serverName, st)
+
+	case "wait_boot":
+		return e.waitBoot(ctx, serverName, runStart, st)
+
+	case "notify":
+		msg := st.Message
+		if msg == "" {
+			msg = "playbook notification"
+		}
+		e.mu.RLock()
+		onNotify := e.onNotify
+		e.mu.RUnlock()
+		if onNotify == nil {
+			log.Warnf("Playbook notification for %s: %s", serverName, msg)
+			return true, msg, nil
+		}
+		onNotify(Notification{
+			Playbook: run.Playbook,
+			Server:   serverName,
+			RunID:    run.ID,
+			Severity: st.Severity,
+			Message:  msg,
+			Notify:   st.Notify,
+		})
+		return true, msg, nil
+
+	case "rotate":
+		if e.solManager.RotationPaused(serverName) {
+			return true, "rotation paused: reboot loop", nil
+		}
+		newFile, err := e.logWriter.RotateWithName(serverName, st.LogName)
+		if err != nil {
+			return false, "", err
+		}
+		e.solManager.RecordRotation(serverName)
+		e.solManager.OnLogRotation(serverName, newFile)
+		return true, "rotated to " + newFile, nil
+	}
+	return false, "", fmt.Errorf("unknown action %q", st.Action)
+}
+
+// waitFor watches the live console for a pattern until the step timeout.
+func (e *Engine) waitFor(ctx context.Context, serverName string, st config.PlaybookStep) (bool, string, error) {
+	re, err := regexp.Compile(st.Pattern)
+	if err != nil {
+		return false, "", err
+	}
+	timeout := st.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+
+	ch := e.solManager.Subscribe(serverName)
+	defer e.solManager.Unsubscribe(serverName, ch)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// Keep a small tail so patterns split across chunks still match
+	var window strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return false, "", ctx.Err()
+		case <-timer.C:
+			return false, fmt.Sprintf("pattern %q not seen within %v", st.Pattern, timeout), nil
+		case chunk, ok := <-ch:
+			if !ok {
+				return false, "", fmt.Errorf("console stream closed")
+			}
+			window.WriteString(ansiRegex.ReplaceAllString(string(chunk.Data), ""))
+			text := window.String()
+			if loc := re.FindStringIndex(text); loc != nil {
+				return true, fmt.Sprintf("matched %q", strings.TrimSpace(text[loc[0]:loc[1]])), nil
+			}
+			if len(text) > 4096 {
+				tail := text[len(text)-1024:]
+				window.Reset()
+				window.WriteString(tail)
+			}
+		}
+	}
+}
+
+// waitBoot polls boot analytics until a boot that started after since (the
+// run's start, so a boot begun by an earlier power or rotate step counts)
+// completes, i.e. the OS is up, or the step timeout passes.
+func (e *Engine) waitBoot(ctx context.Context, serverName string, since time.Time, st config.PlaybookStep) (bool, string, error) {
+	timeout := st.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Minute
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, "", ctx.Err()
+		case <-deadline.C:
+			return false, fmt.Sprintf("no completed boot within %v", timeout), nil
+		case <-ticker.C:
+		}
+		a := e.solManager.GetAnalytics(serverName)
+		boots := a.BootHistory
+		if a.CurrentBoot != nil {
+			boots = append(boots, *a.CurrentBoot)
+		}
+		for _, b := range boots {
+			if b.Complete && !b.StartTime.Before(since) {
+				msg := fmt.Sprintf("OS up after %.0fs", b.BootDuration)
+				if b.DetectedOS != "" {
+					msg = fmt.Sprintf("%s up after %.0fs", b.DetectedOS, b.BootDuration)
+				}
+				return true, msg, nil
+			}
+		}
+	}
+}
+
+func newRunID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/alerts"
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/logs"
+	"ipmiserial/sol"
+)
+
+// reaper archives servers that have been missing from discovery for longer
+// than prune.after: their log directory (with raw captures and SEL) is
+// moved to the archive along with their analytics and screen buffer, and
+// all in-memory state for them is dropped.
+type reaper struct {
+	dataDir    string
+	scanner    *discovery.Scanner
+	solManager *sol.Manager
+	logWriter  *logs.Writer
+	alerts     *alerts.Engine
+
+	mu           sync.Mutex
+	after        time.Duration
+	archivePath  string
+	missingSince map[string]time.Time
+}
+
+func newReaper(cfg config.PruneConfig, dataDir string, scanner *discovery.Scanner, solManager *sol.Manager,
+	logWriter *logs.Writer, alertEngine *alerts.Engine) *reaper {
+	r := &reaper{
+		dataDir:      dataDir,
+		scanner:      scanner,
+		solManager:   solManager,
+		logWriter:    logWriter,
+		alerts:       alertEngine,
+		missingSince: make(map[string]time.Time),
+	}
+	r.setConfig(cfg)
+	if data, err := os.ReadFile(r.statePath()); err == nil {
+		if err := json.Unmarshal(data, &r.missingSince); err != nil {
+			log.Warnf("Failed to parse %s: %v", r.statePath(), err)
+		}
+	}
+	return r
+}
+
+func (r *reaper) setConfig(cfg config.PruneConfig) {
+	if cfg.ArchivePath == "" {
+		cfg.ArchivePath = filepath.Join(r.dataDir, "archive")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.after = cfg.After
+	r.archivePath = cfg.ArchivePath
+}
+
+func (r *reaper) statePath() string {
+	return filepath.Join(r.dataDir, "prune.json")
+}
+
+// interval checks a few times per prune period, between a minute and an hour.
+func (r *reaper) interval() time.Duration {
+	r.mu.Lock()
+	after := r.after
+	r.mu.Unlock()
+	switch {
+	case after <= 0 || after/4 > time.Hour:
+		return time.Hour
+	case after/4 < time.Minute:
+		return time.Minute
+	}
+	return after / 4
+}
+
+func (r *reaper) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.interval()):
+			r.prune(time.Now())
+		}
+	}
+}
+
+// prune records newly missing servers and archives those missing too long.
+func (r *reaper) prune(now time.Time) {
+	r.mu.Lock()
+	after, archivePath := r.after, r.archivePath
+	r.mu.Unlock()
+	if after <= 0 {
+		return
+	}
+
+	present := r.scanner.GetServers()
+	if len(present) == 0 {
+		// An empty inventory is more likely a discovery problem than a
+		// decommissioned fleet; don't start the clock on everything
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, name := range r.logWriter.ListServerDirs() {
+		known[name] = true
+	}
+	for name := range r.solManager.GetAllAnalytics() {
+		known[name] = true
+	}
+	for name := range r.solManager.GetSessions() {
+		known[name] = true
+	}
+
+	r.mu.Lock()
+	changed := false
+	var expired []string
+	for name := range r.missingSince {
+		if present[name] != nil || !known[name] {
+			delete(r.missingSince, name)
+			changed = true
+		}
+	}
+	for name := range known {
+		if present[name] != nil {
+			continue
+		}
+		since, ok := r.missingSince[name]
+		if !ok {
+			r.missingSince[name] = now
+			changed = true
+			log.Infof("Server %s is no longer discovered; archiving it after %s", name, after)
+		} else if now.Sub(since) >= after {
+			expired = append(expired, name)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, name := range expired {
+		if err := r.archive(name, archivePath, now); err != nil {
+			log.Errorf("Failed to archive %s: %v", name, err)
+			continue
+		}
+		r.mu.Lock()
+		delete(r.missingSince, name)
+		r.mu.Unlock()
+		changed = true
+	}
+	if changed {
+		r.save()
+	}
+}
+
+// archive forgets a server and writes its data to <archivePath>/<name>_<time>:
+// analytics.json, screen.raw and the log directory as logs/.
+func (r *reaper) archive(name, archivePath string, now time.Time) error {
+	dest := filepath.Join(archivePath, name+"_"+now.Format("2006-01-02_15-04-05"))
+
+	// Stop the session first so nothing writes to the logs while they move.
+	// Analytics and screen are written before the logs are moved, so a
+	// failed move (retried next pass) doesn't lose them.
+	analytics, screen := r.solManager.ForgetServer(name)
+	r.alerts.Forget(name)
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	if analytics != nil {
+		data, err := json.MarshalIndent(analytics, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dest, "analytics.json"), data, 0644); err != nil {
+			return err
+		}
+	}
+	if len(screen) > 0 {
+		if err := os.WriteFile(filepath.Join(dest, "screen.raw"), screen, 0644); err != nil {
+			return err
+		}
+	}
+	if err := r.logWriter.ArchiveServer(name, filepath.Join(dest, "logs")); err != nil {
+		return err
+	}
+	log.Infof("Archived %s to %s", name, dest)
+	return nil
+}
+
+func (r *reaper) save() {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.missingSince, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(r.statePath(), data, 0644); err != nil {
+		log.Errorf("Failed to save %s: %v", r.statePath(), err)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/alerts"
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/events"
+	"ipmiserial/gateway"
+	"ipmiserial/logs"
+	"ipmiserial/playbooks"
+	"ipmiserial/server"
+	"ipmiserial/sol"
+)
+
+// reloader re-reads config.yaml (on SIGHUP) and applies what can change at
+// runtime. Active SOL sessions are only restarted when their own server
+// entry or effective credentials changed.
+type reloader struct {
+	path           string
+	cfg            *config.Config
+	logWriter      *logs.Writer
+	logSinks       *logs.SinkRegistry
+	rebootDetector *sol.RebootDetector
+	solManager     *sol.Manager
+	scanner        *discovery.Scanner
+	server         *server.Server
+	playbooks      *playbooks.Engine
+	sshGateway     *gateway.SSH       // nil when ssh.port is 0
+	conserver      *gateway.Conserver // nil when conserver.port is 0
+	alerts         *alerts.Engine
+	webhooks       *events.Webhooks
+	reaper         *reaper
+	daemonLog      *logs.DaemonLog // nil when ipmiserial.log could not be opened
+}
+
+func (r *reloader) reload() {
+	cfg, err := config.Load(r.path)
+	if err != nil {
+		log.Errorf("Config reload failed, keeping current config: %v", err)
+		return
+	}
+	old := r.cfg
+	log.Infof("Reloading config from %s", r.path)
+
+	if !reflect.DeepEqual(old.RebootDetection.SOLPatterns, cfg.RebootDetection.SOLPatterns) {
+		r.rebootDetector.SetPatterns(cfg.RebootDetection.SOLPatterns)
+		log.Infof("  Reboot patterns: %v", cfg.RebootDetection.SOLPatterns)
+	}
+
+	if old.RebootDetection.LoopReboots != cfg.RebootDetection.LoopReboots || old.RebootDetection.LoopWindow != cfg.RebootDetection.LoopWindow ||
+		old.RebootDetection.LoopPauseRotation != cfg.RebootDetection.LoopPauseRotation {
+		r.solManager.SetRebootLoop(cfg.RebootDetection.LoopReboots, cfg.RebootDetection.LoopWindow, cfg.RebootDetection.LoopPauseRotation)
+		log.Infof("  Reboot loop: %d boots within %v (pause rotation: %v)",
+			cfg.RebootDetection.LoopReboots, cfg.RebootDetection.LoopWindow, cfg.RebootDetection.LoopPauseRotation)
+	}
+
+	if old.RebootDetection.ChassisPollInterval != cfg.RebootDetection.ChassisPollInterval {
+		r.solManager.SetChassisPollInterval(cfg.RebootDetection.ChassisPollInterval)
+		log.Infof("  Chassis poll interval: %v", cfg.RebootDetection.ChassisPollInterval)
+	}
+
+	if old.Logs.Daemon != cfg.Logs.Daemon {
+		applyDaemonLogFormat(cfg.Logs.Daemon)
+		if r.daemonLog != nil {
+			r.daemonLog.SetLimits(cfg.Logs.Daemon.MaxSizeMB, cfg.Logs.Daemon.MaxAgeDays, cfg.Logs.Daemon.MaxBackups)
+		}
+		log.Infof("  Daemon log: %s, level %s, rotate at %d MB", cfg.Logs.Daemon.Format, cfg.Logs.Daemon.Level, cfg.Logs.Daemon.MaxSizeMB)
+	}
+
+	if old.Logs.RetentionDays != cfg.Logs.RetentionDays {
+		r.logWriter.SetRetentionDays(cfg.Logs.RetentionDays)
+		log.Infof("  Log retention: %d -> %d days", old.Logs.RetentionDays, cfg.Logs.RetentionDays)
+	}
+
+	if old.Logs.CompressDays != cfg.Logs.CompressDays {
+		r.logWriter.SetCompressAfterDays(cfg.Logs.CompressDays)
+		log.Infof("  Log compression: after %d days", cfg.Logs.CompressDays)
+	}
+
+	if old.Logs.RawCapture != cfg.Logs.RawCapture {
+		r.logWriter.SetRawCapture(cfg.Logs.RawCapture)
+		log.Infof("  Raw capture: %v", cfg.Logs.RawCapture)
+	}
+
+	if old.Logs.PendingFlush != cfg.Logs.PendingFlush {
+		r.logWriter.SetPendingFlush(cfg.Logs.PendingFlush)
+		log.Infof("  Pending flush: %v", cfg.Logs.PendingFlush)
+	}
+
+	if old.Logs.MaxTotalSizeGB != cfg.Logs.MaxTotalSizeGB {
+		r.logWriter.SetMaxTotalSize(gbToBytes(cfg.Logs.MaxTotalSizeGB))
+		log.Infof("  Log quota: %g -> %g GB", old.Logs.MaxTotalSizeGB, cfg.Logs.MaxTotalSizeGB)
+	}
+
+	if old.Logs.MaxFileSizeMB != cfg.Logs.MaxFileSizeMB {
+		r.logWriter.SetMaxFileSize(cfg.Logs.MaxFileSizeMB)
+		log.Infof("  Log size limit: %d -> %d MB", old.Logs.MaxFileSizeMB, cfg.Logs.MaxFileSizeMB)
+	}
+
+	oldSize, oldSizes := scrollbackSizes(old)
+	if size, sizes := scrollbackSizes(cfg); size != oldSize || !reflect.DeepEqual(sizes, oldSizes) {
+		r.solManager.SetScrollback(size, sizes)
+		log.Infof("  Scrollback: %g -> %g MB per server", old.Logs.ScrollbackMB, cfg.Logs.ScrollbackMB)
+	}
+
+	if !reflect.DeepEqual(old.Logs.Sinks, cfg.Logs.Sinks) {
+		r.logSinks.SetConfig(cfg.Logs.Sinks)
+		log.Info("  Log sinks updated")
+	}
+
+	if old.Server.SSECompression != cfg.Server.SSECompression {
+		r.server.SetSSECompression(cfg.Server.SSECompression)
+		log.Infof("  SSE compression: %v", cfg.Server.SSECompression)
+	}
+	if old.Server.Streams != cfg.Server.Streams {
+		r.server.SetStreamLimits(cfg.Server.Streams)
+		log.Infof("  Stream limits: %d per server, %d total, evict after %v", cfg.Server.Streams.MaxPerServer, cfg.Server.Streams.MaxTotal, cfg.Server.Streams.EvictAfter)
+	}
+	if old.Server.Catchup != cfg.Server.Catchup {
+		r.server.SetCatchup(cfg.Server.Catchup)
+		log.Infof("  Console catchup: %s", cfg.Server.Catchup)
+	}
+	if old.Server.ReadOnly != cfg.Server.ReadOnly {
+		r.server.SetReadOnly(cfg.Server.ReadOnly)
+		r.solManager.SetReadOnly(cfg.Server.ReadOnly)
+		log.Infof("  Read-only: %v", cfg.Server.ReadOnly)
+	}
+	if !reflect.DeepEqual(old.Server.AccessLog, cfg.Server.AccessLog) {
+		r.server.SetAccessLog(cfg.Server.AccessLog)
+		log.Infof("  Access log: %v (%s)", cfg.Server.AccessLog.Enabled, cfg.Server.AccessLog.Format)
+	}
+	if old.Server.GroupLabel != cfg.Server.GroupLabel {
+		r.server.SetGroupLabel(cfg.Server.GroupLabel)
+		log.Infof("  Group label: %s", cfg.Server.GroupLabel)
+	}
+
+	if !reflect.DeepEqual(old.Auth, cfg.Auth) {
+		r.server.SetAuth(cfg.Auth)
+		log.Infof("  API auth: %d tokens, %d users, %d exemptions", len(cfg.Auth.Tokens), len(cfg.Auth.Users), len(cfg.Auth.Exempt))
+	}
+
+	if !reflect.DeepEqual(old.Playbooks, cfg.Playbooks) {
+		r.playbooks.SetPlaybooks(cfg.Playbooks)
+	}
+
+	sessionsAffected := false
+	if old.IPMI.SOLRetries != cfg.IPMI.SOLRetries || old.IPMI.SOLRetryInterval != cfg.IPMI.SOLRetryInterval {
+		r.solManager.SetSOLRetry(cfg.IPMI.SOLRetries, cfg.IPMI.SOLRetryInterval)
+		log.Infof("  SOL retransmission: %d retries every %v (new sessions)", cfg.IPMI.SOLRetries, cfg.IPMI.SOLRetryInterval)
+	}
+	if old.IPMI.SOLConfig != cfg.IPMI.SOLConfig {
+		r.solManager.SetSOLConfig(solConfig(cfg.IPMI.SOLConfig))
+		r.solManager.SetSOLAutoEnable(cfg.IPMI.SOLConfig.AutoEnable)
+		log.Infof("  SOL configuration parameters updated (new sessions)")
+	}
+
+	if old.IPMI.Serial != cfg.IPMI.Serial || !reflect.DeepEqual(serverSerial(old), serverSerial(cfg)) {
+		r.solManager.SetSerialConfig(serialConfigs(cfg))
+		log.Infof("  Serial MUX/UART settings updated (new sessions)")
+	}
+
+	if old.IPMI.AttemptsPerMinute != cfg.IPMI.AttemptsPerMinute {
+		r.solManager.SetBMCRateLimit(cfg.IPMI.AttemptsPerMinute)
+		log.Infof("  BMC session attempts per minute: %d", cfg.IPMI.AttemptsPerMinute)
+	}
+	if old.IPMI.MaxConnecting != cfg.IPMI.MaxConnecting {
+		r.solManager.SetMaxConnecting(cfg.IPMI.MaxConnecting)
+		log.Infof("  Max concurrent connects: %d", cfg.IPMI.MaxConnecting)
+	}
+	if old.IPMI.SourceAddress != cfg.IPMI.SourceAddress || old.IPMI.SourcePorts != cfg.IPMI.SourcePorts || old.IPMI.DSCP != cfg.IPMI.DSCP {
+		if p, err := sol.NewSourcePorts(cfg.IPMI.SourceAddress, cfg.IPMI.SourcePorts, cfg.IPMI.DSCP); err != nil {
+			log.Errorf("  BMC source settings not applied: %v", err)
+		} else {
+			r.solManager.SetSourcePorts(p)
+			r.scanner.SetProbeDialer(p.Dial)
+			log.Infof("  BMC sockets: source %q ports %q DSCP %d (new sessions)", cfg.IPMI.SourceAddress, cfg.IPMI.SourcePorts, cfg.IPMI.DSCP)
+		}
+	}
+	if old.IPMI.Proxy != cfg.IPMI.Proxy || !reflect.DeepEqual(serverProxy(old), serverProxy(cfg)) {
+		if proxy, proxies, err := proxyDialers(cfg); err != nil {
+			log.Errorf("  BMC proxy settings not applied: %v", err)
+		} else {
+			r.solManager.SetProxies(proxy, proxies)
+			r.scanner.SetProbeProxies(proxy, proxies)
+			log.Infof("  BMC proxies updated (new sessions)")
+		}
+	}
+	if old.IPMI.ConnectHistory != cfg.IPMI.ConnectHistory {
+		r.solManager.SetConnectHistory(cfg.IPMI.ConnectHistory)
+		log.Infof("  Connect history: %d -> %d attempts", old.IPMI.ConnectHistory, cfg.IPMI.ConnectHistory)
+	}
+	if old.IPMI.Backoff != cfg.IPMI.Backoff || !reflect.DeepEqual(serverBackoff(old), serverBackoff(cfg)) {
+		r.solManager.SetBackoff(backoffConfigs(cfg))
+		log.Infof("  Reconnect backoff: %v to %v, reset after %v, jitter %.2f", cfg.IPMI.Backoff.Min, cfg.IPMI.Backoff.Max, cfg.IPMI.Backoff.Reset, cfg.IPMI.Backoff.Jitter)
+	}
+	if !reflect.DeepEqual(old.IPMI.Quirks, cfg.IPMI.Quirks) || !reflect.DeepEqual(serverQuirks(old), serverQuirks(cfg)) {
+		r.solManager.SetQuirks(quirksConfigs(cfg))
+		log.Infof("  BMC quirks updated (new sessions)")
+	}
+	if old.IPMI.Transport != cfg.IPMI.Transport || !reflect.DeepEqual(serverTransport(old), serverTransport(cfg)) {
+		r.solManager.SetTransports(transports(cfg))
+		log.Infof("  SOL transports updated (new sessions)")
+	}
+	if !reflect.DeepEqual(old.IPMI.Connection, cfg.IPMI.Connection) || !reflect.DeepEqual(serverConnection(old), serverConnection(cfg)) {
+		r.solManager.SetConnection(connectionConfigs(cfg))
+		log.Infof("  Connection settings updated (new sessions)")
+	}
+	if !reflect.DeepEqual(old.IPMI.IPMITool, cfg.IPMI.IPMITool) {
+		r.solManager.SetIPMITool(ipmiTool(cfg.IPMI.IPMITool))
+		log.Infof("  ipmitool settings updated (new sessions)")
+	}
+
+	if old.IPMI.Username != cfg.IPMI.Username || old.IPMI.Password != cfg.IPMI.Password || old.IPMI.Kg != cfg.IPMI.Kg {
+		r.solManager.SetDefaultCredentials(cfg.IPMI.Username, cfg.IPMI.Password, cfg.IPMI.Kg)
+		log.Info("  Global IPMI credentials changed")
+		sessionsAffected = true
+	}
+
+	// Diff static servers
+	oldServers := make(map[string]config.ServerEntry)
+	for _, s := range old.Servers {
+		oldServers[s.Name] = s
+	}
+	newServers := make(map[string]config.ServerEntry)
+	for _, s := range cfg.Servers {
+		newServers[s.Name] = s
+	}
+	for name := range oldServers {
+		if _, exists := newServers[name]; !exists {
+			if r.scanner.RemoveServer(name) {
+				r.solManager.RemoveSession(name)
+			}
+			log.Infof("  Server removed: %s", name)
+		}
+	}
+	for name, s := range newServers {
+		prev, existed := oldServers[name]
+		if existed && reflect.DeepEqual(prev, s) {
+			continue
+		}
+		r.scanner.AddServer(s.Name, s.Host, s.Username, s.Password, s.Kg, s.Port, s.RetentionDays, s.Labels)
+		sessionsAffected = true
+	}
+	if !reflect.DeepEqual(old.Servers, cfg.Servers) {
+		r.server.SetServers(cfg.Servers)
+	}
+
+	if r.sshGateway != nil && (!reflect.DeepEqual(old.SSH.AuthorizedKeys, cfg.SSH.AuthorizedKeys) ||
+		old.SSH.AuthorizedKeysFile != cfg.SSH.AuthorizedKeysFile) {
+		r.sshGateway.SetAuthorizedKeys(cfg.SSH)
+		log.Info("  SSH authorized keys reloaded")
+	}
+
+	if r.conserver != nil && (!reflect.DeepEqual(old.Conserver.Users, cfg.Conserver.Users) ||
+		!reflect.DeepEqual(old.Conserver.ReadOnly, cfg.Conserver.ReadOnly)) {
+		r.conserver.SetUsers(cfg.Conserver)
+		log.Info("  Conserver users reloaded")
+	}
+
+	if !reflect.DeepEqual(old.Alerts, cfg.Alerts) {
+		r.alerts.SetConfig(cfg.Alerts)
+		log.Infof("  Alerts: %d rules, %d notifiers", len(cfg.Alerts.Rules), len(cfg.Alerts.Notifiers))
+	}
+
+	if !reflect.DeepEqual(old.Events, cfg.Events) {
+		r.webhooks.SetConfig(cfg.Events)
+		log.Infof("  Webhooks: %d", len(cfg.Events.Webhooks))
+	}
+
+	if old.Analytics.BootHistory != cfg.Analytics.BootHistory {
+		r.solManager.SetBootHistory(cfg.Analytics.BootHistory)
+		log.Infof("  Boot history: %d -> %d boots", old.Analytics.BootHistory, cfg.Analytics.BootHistory)
+	}
+
+	if old.Prune != cfg.Prune {
+		r.reaper.setConfig(cfg.Prune)
+		log.Infof("  Prune: after %s", cfg.Prune.After)
+	}
+
+	// Settings that are bound at startup
+	if old.Server.Port != cfg.Server.Port || old.Server.TLS != cfg.Server.TLS || old.Server.GRPCPort != cfg.Server.GRPCPort ||
+		old.SSH.Port != cfg.SSH.Port || old.SSH.HostKey != cfg.SSH.HostKey || old.Conserver.Port != cfg.Conserver.Port || !reflect.DeepEqual(old.ConsoleProxy, cfg.ConsoleProxy) || filepath.Clean(cfg.Logs.Path) != filepath.Clean(r.logWriter.BasePath()) ||
+		!reflect.DeepEqual(old.Discovery, cfg.Discovery) || !reflect.DeepEqual(old.Logs.Loki, cfg.Logs.Loki) || old.Logs.Syslog != cfg.Logs.Syslog || !reflect.DeepEqual(old.Logs.NATS, cfg.Logs.NATS) || !reflect.DeepEqual(old.Telemetry, cfg.Telemetry) || !reflect.DeepEqual(old.MQTT, cfg.MQTT) || old.SEL != cfg.SEL || old.Sensors != cfg.Sensors || old.Power != cfg.Power || old.Analytics.FlushInterval != cfg.Analytics.FlushInterval || !reflect.DeepEqual(old.Vault, cfg.Vault) {
+		log.Warn("  server.port, server.tls, server.grpc_port, ssh.port, ssh.host_key, conserver.port, console_proxy, logs.path, logs.loki, logs.syslog, logs.nats, telemetry, mqtt, discovery, vault, sel, sensors, power and analytics.flush_interval changes require a restart (use /api/admin/migrate to move logs live)")
+	}
+
+	r.cfg = cfg
+
+	// Let the session reconciler start new servers and restart changed ones
+	if sessionsAffected {
+		r.scanner.NotifyChange()
+	}
+	log.Info("Config reload complete")
+}
+
+// serverBackoff collects the per-server backoff settings, to tell whether
+// they changed.
+func serverBackoff(cfg *config.Config) map[string]config.BackoffConfig {
+	out := make(map[string]config.BackoffConfig)
+	for _, s := range cfg.Servers {
+		if s.Backoff != nil {
+			out[s.Name] = *s.Backoff
+		}
+	}
+	return out
+}
+
+// serverTransport collects the per-server transports, to tell whether they
+// changed.
+func serverTransport(cfg *config.Config) map[string]string {
+	out := make(map[string]string)
+	for _, s := range cfg.Servers {
+		if s.Transport != "" {
+			out[s.Name] = s.Transport
+		}
+	}
+	return out
+}
+
+// serverQuirks collects the per-server quirks, to tell whether they
+// changed.
+func serverQuirks(cfg *config.Config) map[string]config.QuirksConfig {
+	out := make(map[string]config.QuirksConfig)
+	for _, s := range cfg.Servers {
+		if s.Quirks != nil {
+			out[s.Name] = *s.Quirks
+		}
+	}
+	return out
+}
+
+// serverConnection collects the per-server connection settings, to tell
+// whether they changed.
+func serverConnection(cfg *config.Config) map[string]config.ConnectionConfig {
+	out := make(map[string]config.ConnectionConfig)
+	for _, s := range cfg.Servers {
+		if s.Connection != nil {
+			out[s.Name] = *s.Connection
+		}
+	}
+	return out
+}
+
+// serverSerial collects the per-server serial settings, to tell whether
+// they changed.
+func serverSerial(cfg *config.Config) map[string]config.SerialConfig {
+	out := make(map[string]config.SerialConfig)
+	for _, s := range cfg.Servers {
+		if s.Serial != nil {
+			out[s.Name] = *s.Serial
+		}
+	}
+	return out
+}
+
+// serverProxy collects the per-server proxy settings, to tell whether they
+// changed.
+func serverProxy(cfg *config.Config) map[string]config.ProxyConfig {
+	out := make(map[string]config.ProxyConfig)
+	for _, s := range cfg.Servers {
+		if s.Proxy != nil {
+			out[s.Name] = *s.Proxy
+		}
+	}
+	return out
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+// Access log formats
+const (
+	AccessLogText = "text" // logrus fields, in the daemon log's own format
+	AccessLogCLF  = "clf"  // Apache combined log format plus the duration
+	AccessLogJSON = "json" // one JSON object per request
+)
+
+// defaultAccessLogExclude are health and metrics probes, which would
+// otherwise drown the log.
+var defaultAccessLogExclude = []string{"/healthz", "/readyz", "/metrics"}
+
+// accessLogSecretParams are query parameters that carry credentials; their
+// values are masked so they never reach shipped logs.
+var accessLogSecretParams = map[string]bool{
+	"api_key":      true,
+	"token":        true,
+	"access_token": true,
+	"password":     true,
+	"secret":       true,
+}
+
+// accessLogState is the applied server.access_log section.
+type accessLogState struct {
+	enabled bool
+	format  string
+	exclude []string
+}
+
+// accessUser lets the auth middleware, which runs inside the access log,
+// report who a request was authenticated as.
+type accessUser struct {
+	name string
+}
+
+type accessUserCtxKey struct{}
+
+// accessLogMu serialises the raw clf and json lines written to the log
+// output.
+var accessLogMu sync.Mutex
+
+// SetAccessLog applies the server.access_log section. With logging off,
+// requests are still logged at debug level.
+func (s *Server) SetAccessLog(cfg config.AccessLogConfig) {
+	st := &accessLogState{enabled: cfg.Enabled, format: cfg.Format, exclude: cfg.Exclude}
+	if st.format == "" {
+		st.format = AccessLogText
+	}
+	if st.exclude == nil {
+		st.exclude = defaultAccessLogExclude
+	}
+	s.accessLog.Store(st)
+}
+
+// accessLogMiddleware logs each request once it completes: status, bytes
+// written, duration and user. Event and console streams are logged once,
+// when they close, so their keepalives never add lines.
+func (s *Server) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := s.accessLog.Load()
+		if st == nil {
+			st = &accessLogState{format: AccessLogText, exclude: defaultAccessLogExclude}
+		}
+		if (!st.enabled && !log.IsLevelEnabled(log.DebugLevel)) || st.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		user := &accessUser{}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessUserCtxKey{}, user)))
+
+		status := sw.status
+		switch {
+		case status == 0 && r.Header.Get("Upgrade") != "":
+			status = http.StatusSwitchingProtocols // hijacked by a WebSocket attach
+		case status == 0:
+			status = http.StatusOK
+		}
+		if user.name == "" {
+			user.name = requestUser(r)
+		}
+		st.write(r, status, sw.bytes, time.Since(start), user.name)
+	})
+}
+
+func (st *accessLogState) excluded(path string) bool {
+	for _, prefix := range st.exclude {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *accessLogState) write(r *http.Request, status int, bytes int64, d time.Duration, user string) {
+	if !st.enabled {
+		log.WithFields(accessFields(r, status, bytes, d, user)).Debug("HTTP request")
+		return
+	}
+	switch st.format {
+	case AccessLogCLF:
+		writeAccessLine(fmt.Sprintf("%s - %s [%s] %q %d %d %q %q %.3f\n",
+			clientHost(r), orDash(user), time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+loggedURI(r)+" "+r.Proto, status, bytes,
+			orDash(loggedReferer(r)), orDash(r.UserAgent()), d.Seconds()))
+	case AccessLogJSON:
+		fields := accessFields(r, status, bytes, d, user)
+		fields["time"] = time.Now().Format(time.RFC3339Nano)
+		line, err := json.Marshal(fields)
+		if err != nil {
+			return
+		}
+		writeAccessLine(string(line) + "\n")
+	default:
+		log.WithFields(accessFields(r, status, bytes, d, user)).Info("HTTP request")
+	}
+}
+
+func accessFields(r *http.Request, status int, bytes int64, d time.Duration, user string) log.Fields {
+	fields := log.Fields{
+		"method":   r.Method,
+		"path":     loggedURI(r),
+		"status":   status,
+		"bytes":    bytes,
+		"duration": d.Seconds(),
+		"remote":   clientHost(r),
+	}
+	if user != "" {
+		fields["user"] = user
+	}
+	return fields
+}
+
+// loggedURI is the request URI with credential query parameters masked.
+func loggedURI(r *http.Request) string {
+	u := *r.URL
+	u.RawQuery = maskQuery(u.RawQuery)
+	return u.RequestURI()
+}
+
+// loggedReferer is the Referer with credential query parameters masked; a
+// UI page opened with ?api_key= passes it on to every request it makes.
+func loggedReferer(r *http.Request) string {
+	ref := r.Referer()
+	u, err := url.Parse(ref)
+	if err != nil || u.RawQuery == "" {
+		return ref
+	}
+	u.RawQuery = maskQuery(u.RawQuery)
+	return u.String()
+}
+
+// maskQuery replaces the values of credential parameters in a raw query.
+// It works pair by pair so the rest of the query logs as sent.
+func maskQuery(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	pairs := strings.Split(raw, "&")
+	for i, pair := range pairs {
+		key, _, hasValue := strings.Cut(pair, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if hasValue && accessLogSecretParams[strings.ToLower(key)] {
+			pairs[i] = pair[:strings.IndexByte(pair, '=')] + "=REDACTED"
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// orDash stands in "-" for an empty combined log format field.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// writeAccessLine writes a preformatted line straight to the log output,
+// bypassing the daemon log's formatter.
+func writeAccessLine(line string) {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	log.StandardLogger().Out.Write([]byte(line))
+}
+
+// setAccessUser records the principal a request was authenticated as for
+// its access log line.
+func setAccessUser(r *http.Request, name string) {
+	if u, ok := r.Context().Value(accessUserCtxKey{}).(*accessUser); ok {
+		u.name = name
+	}
+}
+
+// requestUser returns the user a request names without authenticating it:
+// basic auth or a proxy-supplied header.
+func requestUser(r *http.Request) string {
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		return u
+	}
+	return r.Header.Get("X-Remote-User")
+}
+
+// clientHost returns the client's address, from X-Forwarded-For when a
+// proxy set it.
+func clientHost(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return h
+	}
+	return r.RemoteAddr
+}
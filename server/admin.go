@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type migrateRequest struct {
+	Path      string `json:"path"`
+	RemoveOld bool   `json:"removeOld"`
+}
+
+// OnLogMigration registers a callback run after the log directory has been
+// switched to a new path (e.g. to reopen the daemon's own log file).
+func (s *Server) OnLogMigration(fn func(newPath string)) {
+	s.onLogMigration = fn
+}
+
+func (s *Server) handleMigrateLogs(w http.ResponseWriter, r *http.Request) {
+	var req migrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "path is required")
+		return
+	}
+
+	err := s.logWriter.Migrate(req.Path, req.RemoveOld, func(newPath string) {
+		s.solManager.SetDataPath(newPath)
+		if s.onLogMigration != nil {
+			s.onLogMigration(newPath)
+		}
+	})
+	if err != nil {
+		writeProblem(w, r, http.StatusConflict, CodeMigrationConflict, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.logWriter.Migration())
+}
+
+func (s *Server) handleMigrationStatus(w http.ResponseWriter, r *http.Request) {
+	status := s.logWriter.Migration()
+	if status == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNoMigration, "No migration has been run")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
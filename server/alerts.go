@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"ipmiserial/alerts"
+)
+
+// SetAlerts exposes the alert engine's history and notifier tests.
+func (s *Server) SetAlerts(engine *alerts.Engine) {
+	s.alerts = engine
+}
+
+func (s *Server) handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	visible := make([]*alerts.Alert, 0)
+	if s.alerts != nil {
+		for _, a := range s.alerts.History(r.URL.Query().Get("server"), 0) {
+			if !serverAllowed(r, a.Server) {
+				continue
+			}
+			visible = append(visible, a)
+			if limit > 0 && len(visible) >= limit {
+				break
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+func (s *Server) handleTestNotifier(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotifierNotFound, "Alerts are not configured")
+		return
+	}
+	var body struct {
+		Notifier string `json:"notifier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Notifier == "" {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "notifier is required")
+		return
+	}
+
+	if err := s.alerts.Test(r.Context(), body.Notifier); err != nil {
+		if !s.alerts.HasNotifier(body.Notifier) {
+			writeProblem(w, r, http.StatusNotFound, CodeNotifierNotFound, err.Error())
+			return
+		}
+		writeProblem(w, r, http.StatusBadGateway, CodeNotifyFailed, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Credential scopes. "read" is shorthand for servers, logs and analytics.
+const (
+	ScopeServers   = "servers"   // server list, status, power state, live console stream, playbook runs
+	ScopeLogs      = "logs"      // log listing, content and search
+	ScopeAnalytics = "analytics" // analytics, SEL, sensors, metrics, software facts, inventory, power-on report, event streams
+	ScopeControl   = "control"   // console input, power, boot device, playbooks, reconnect, log clear/rotate
+	ScopeAdmin     = "admin"     // key management, log migration, debug; never granted to API keys
+	scopeRead      = "read"
+)
+
+var scopeGrants = map[string][]string{
+	ScopeServers:   {ScopeServers},
+	ScopeLogs:      {ScopeLogs},
+	ScopeAnalytics: {ScopeAnalytics},
+	ScopeControl:   {ScopeControl},
+	ScopeAdmin:     {ScopeAdmin},
+	scopeRead:      {ScopeServers, ScopeLogs, ScopeAnalytics},
+}
+
+// expandScopes validates scope names and expands shorthands. Empty input
+// yields nil, which config credentials treat as full access.
+func expandScopes(scopes []string, allowAdmin bool) ([]string, error) {
+	var expanded []string
+	seen := make(map[string]bool)
+	for _, s := range scopes {
+		name := strings.ToLower(strings.TrimSpace(s))
+		grants, ok := scopeGrants[name]
+		if !ok || (name == ScopeAdmin && !allowAdmin) {
+			valid := "servers, logs, analytics, control or read"
+			if allowAdmin {
+				valid = "servers, logs, analytics, control, read or admin"
+			}
+			return nil, fmt.Errorf("unknown scope %q (use %s)", s, valid)
+		}
+		for _, g := range grants {
+			if !seen[g] {
+				seen[g] = true
+				expanded = append(expanded, g)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// keyLastUsedInterval limits how often a key's last-used time is persisted.
+const keyLastUsedInterval = time.Minute
+
+// APIKey is a scoped credential for dashboards and scripts. Only a hash of
+// the secret is kept; the token is shown once, when the key is created.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Servers   []string   `json:"servers,omitempty"` // names or "label:<selector>"; empty = all servers
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	LastUsed  *time.Time `json:"lastUsed,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+}
+
+func (k *APIKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && now.After(*k.ExpiresAt)
+}
+
+// KeyStore holds API keys, persisted as apikeys.json in the data directory.
+type KeyStore struct {
+	path string
+	mu   sync.Mutex
+	keys map[string]*APIKey
+}
+
+func NewKeyStore(dataDir string) *KeyStore {
+	ks := &KeyStore{
+		path: filepath.Join(dataDir, "apikeys.json"),
+		keys: make(map[string]*APIKey),
+	}
+	ks.load()
+	return ks
+}
+
+func (ks *KeyStore) load() {
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to read API keys: %v", err)
+		}
+		return
+	}
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		log.Warnf("Failed to parse API keys: %v", err)
+		return
+	}
+	for _, k := range keys {
+		ks.keys[k.ID] = k
+	}
+	log.Infof("Loaded %d API keys", len(keys))
+}
+
+// save writes the keys to disk atomically. Must be called with ks.mu held.
+func (ks *KeyStore) save() error {
+	keys := make([]*APIKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0755); err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ks.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Create adds a key and returns it with its token, which is not stored.
+func (ks *KeyStore) Create(name string, scopes, servers []string, expiresAt *time.Time) (*APIKey, string, error) {
+	expanded, err := expandScopes(scopes, false)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(expanded) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	if err := checkServerEntries(servers); err != nil {
+		return nil, "", err
+	}
+
+	idBytes := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	token := "ipk_" + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	k := &APIKey{
+		ID:        id,
+		Name:      name,
+		Scopes:    expanded,
+		Servers:   servers,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+		Hash:      hashToken(token),
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if _, exists := ks.keys[id]; exists {
+		return nil, "", fmt.Errorf("key id collision, try again")
+	}
+	ks.keys[id] = k
+	if err := ks.save(); err != nil {
+		delete(ks.keys, id)
+		return nil, "", err
+	}
+	log.Infof("API key %s (%s) created: scopes=%v servers=%v", id, name, expanded, servers)
+	return k.public(), token, nil
+}
+
+// Revoke deletes a key, reporting whether it existed.
+func (ks *KeyStore) Revoke(id string) (bool, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	k, ok := ks.keys[id]
+	if !ok {
+		return false, nil
+	}
+	delete(ks.keys, id)
+	if err := ks.save(); err != nil {
+		ks.keys[id] = k
+		return false, err
+	}
+	log.Infof("API key %s (%s) revoked", id, k.Name)
+	return true, nil
+}
+
+// List returns all keys, oldest first, without their hashes.
+func (ks *KeyStore) List() []*APIKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	out := make([]*APIKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		out = append(out, k.public())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Authenticate implements Authenticator for API key tokens.
+func (ks *KeyStore) Authenticate(r *http.Request) *Principal {
+	k := ks.Lookup(requestToken(r))
+	if k == nil {
+		return nil
+	}
+	return &Principal{Name: k.ID, Kind: "key", Scopes: k.Scopes, Servers: k.Servers}
+}
+
+// Lookup returns the key a token belongs to, or nil if the token is
+// unknown, wrong or expired.
+func (ks *KeyStore) Lookup(token string) *APIKey {
+	rest, ok := strings.CutPrefix(token, "ipk_")
+	if !ok {
+		return nil
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	k := ks.keys[id]
+	if k == nil || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashToken(token))) != 1 {
+		return nil
+	}
+	now := time.Now()
+	if k.expired(now) {
+		return nil
+	}
+	if k.LastUsed == nil || now.Sub(*k.LastUsed) >= keyLastUsedInterval {
+		k.LastUsed = &now
+		if err := ks.save(); err != nil {
+			log.Warnf("Failed to save API keys: %v", err)
+		}
+	}
+	return k.public()
+}
+
+// public returns a copy of the key without its hash.
+func (k *APIKey) public() *APIKey {
+	c := *k
+	c.Hash = ""
+	return &c
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type createKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Servers   []string   `json:"servers"`
+	ExpiresIn string     `json:"expiresIn"` // Go duration, e.g. "720h"
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type createKeyResponse struct {
+	*APIKey
+	Token string `json:"token"`
+}
+
+// SetAPIKeys enables scoped API keys backed by store.
+func (s *Server) SetAPIKeys(store *KeyStore) {
+	s.apiKeys = store
+}
+
+func (s *Server) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	if !s.keysAvailable(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.apiKeys.List())
+}
+
+func (s *Server) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	if !s.keysAvailable(w, r) {
+		return
+	}
+	var req createKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "name and scopes are required")
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid expiresIn duration")
+			return
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	key, token, err := s.apiKeys.Create(req.Name, req.Scopes, req.Servers, expiresAt)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createKeyResponse{APIKey: key, Token: token})
+}
+
+func (s *Server) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	if !s.keysAvailable(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+
+	found, err := s.apiKeys.Revoke(vars["id"])
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !found {
+		writeProblem(w, r, http.StatusNotFound, CodeKeyNotFound, "Key not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keysAvailable rejects key management while there is nothing to enforce
+// keys with, so keys can't be minted by anyone while the API is open.
+func (s *Server) keysAvailable(w http.ResponseWriter, r *http.Request) bool {
+	if s.apiKeys == nil || !s.authEnabled() {
+		writeProblem(w, r, http.StatusForbidden, CodeAuthDisabled, "API keys require auth credentials to be configured")
+		return false
+	}
+	return true
+}
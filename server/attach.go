@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/websocket"
+)
+
+// attachPingInterval is how often an attached WebSocket is pinged, so
+// proxies don't drop a quiet console.
+const attachPingInterval = 15 * time.Second
+
+// attachEvent is a text message to an attached client.
+type attachEvent struct {
+	Event      string `json:"event"`
+	Server     string `json:"server,omitempty"`
+	Holder     string `json:"holder,omitempty"`
+	InputOwned bool   `json:"inputOwned,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// attachCommand is a text message from an attached client.
+type attachCommand struct {
+	Type  string `json:"type"` // "break"
+	SysRq string `json:"sysrq,omitempty"`
+}
+
+// handleAttach attaches a WebSocket client to a console: the screen so far,
+// then live output as binary messages, while binary messages from the
+// client are typed into it. The client takes the console's input unless
+// another holds it (?force=true takes it over, ?watch=true only views);
+// it is released when the client disconnects.
+func (s *Server) handleAttach(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !websocket.IsUpgrade(r) {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "WebSocket upgrade required")
+		return
+	}
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+	open, ok := s.openStream(w, r, streamAttach, name)
+	if !ok {
+		return
+	}
+	defer s.streams.close(open)
+	conn, err := websocket.Upgrade(w, r, s.sseCompression.Load() && r.URL.Query().Get("compress") != "false")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ctx := r.Context()
+	who := clientIdentity(r)
+	p := requestPrincipal(r)
+	screen, ch := s.solManager.Attach(name, -1)
+	defer s.solManager.Unsubscribe(name, ch)
+	s.streams.subscribed(open, ch)
+
+	// Input needs the control scope and a writable server. noInput says
+	// why a client can't type; a client that merely lost the race for the
+	// hold can still try, as with the REST input endpoint.
+	var noInput error
+	detail := ""
+	switch {
+	case p != nil && !p.Allows(ScopeControl):
+		noInput = errors.New("credentials lack the control scope")
+	case s.readOnlyFor(p):
+		noInput = errors.New("server is read-only")
+	case r.URL.Query().Get("watch") == "true":
+		noInput = errors.New("attached with watch=true")
+	default:
+		if err := s.solManager.AcquireInput(name, who, r.URL.Query().Get("force") == "true"); err != nil {
+			detail = err.Error()
+		}
+	}
+	defer s.solManager.TrackViewer(name, who, streamAttach, noInput != nil)()
+	if noInput != nil {
+		detail = noInput.Error()
+	}
+	holder := s.solManager.InputHolder(name)
+	if !sendAttachEvent(conn, attachEvent{Event: "connected", Server: name, Holder: holder, InputOwned: holder != "" && holder == who, Detail: detail}) {
+		return
+	}
+	if len(screen.Data) > 0 {
+		if conn.WriteMessage(websocket.OpBinary, append([]byte("\x1b[2J\x1b[H"), screen.Data...)) != nil {
+			return
+		}
+	}
+
+	// Client messages are read on their own goroutine; rejections come back
+	// to be reported
+	rejected := make(chan string, 16)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			err = noInput
+			if err == nil && op == websocket.OpBinary && len(data) > 0 {
+				err = s.solManager.SendInput(name, who, data)
+			} else if err == nil && op == websocket.OpText {
+				var cmd attachCommand
+				if json.Unmarshal(data, &cmd) != nil || cmd.Type != "break" {
+					err = errors.New(`unknown command (expected {"type":"break"})`)
+				} else {
+					err = s.solManager.SendBreak(name, who, cmd.SysRq)
+				}
+			}
+			if err != nil {
+				select {
+				case rejected <- err.Error():
+				default:
+				}
+			}
+		}
+	}()
+
+	ping := time.NewTicker(attachPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			conn.CloseWith(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-readDone:
+			return
+		case <-ping.C:
+			if conn.Ping() != nil {
+				return
+			}
+		case reason := <-rejected:
+			if !sendAttachEvent(conn, attachEvent{Event: "input_rejected", Detail: reason}) {
+				return
+			}
+		case chunk, ok := <-ch:
+			if !ok {
+				conn.CloseWith(websocket.CloseNormal, "console stream closed")
+				return
+			}
+			if err := conn.WriteMessage(websocket.OpBinary, chunk.Data); err != nil {
+				log.Debugf("Attach %s: %v", name, err)
+				return
+			}
+		}
+	}
+}
+
+func sendAttachEvent(conn *websocket.Conn, ev attachEvent) bool {
+	data, _ := json.Marshal(ev)
+	return conn.WriteMessage(websocket.OpText, data) == nil
+}
@@ -0,0 +1,446 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+
+	"ipmiserial/config"
+)
+
+// tokenCookie carries the token for the web UI, so fetch, htmx and
+// EventSource requests are authenticated without extra headers.
+const tokenCookie = "ipmiserial_token"
+
+type ctxKey int
+
+const principalCtxKey ctxKey = iota
+
+// Fleet-wide routes that filter their results to a principal's servers.
+// Other routes without a {name} are refused for server-restricted principals.
+var serverFilteredRoutes = map[string]bool{
+	"/api/servers":                    true,
+	"/api/events":                     true,
+	"/api/alerts":                     true,
+	"/api/analytics":                  true,
+	"/api/software":                   true,
+	"/api/logs/search":                true,
+	"/api/playbooks":                  true,
+	"/api/playbooks/runs":             true,
+	"/api/playbooks/runs/{id}":        true,
+	"/api/playbooks/runs/{id}/cancel": true,
+	"/metrics":                        true,
+}
+
+// Principal is the identity an authenticated request acts as.
+type Principal struct {
+	Name    string   // "admin", token name, username or API key id
+	Kind    string   // admin, token, user, key
+	Scopes  []string // nil = every scope, including admin
+	Servers []string // names or "label:<selector>"; empty = all servers
+	// ReadOnly overrides server.read_only for this principal when set
+	ReadOnly *bool
+
+	labels func(name string) map[string]string // resolves label: entries in Servers
+}
+
+// Allows reports whether the principal holds scope.
+func (p *Principal) Allows(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsServer reports whether the principal may access the named server.
+func (p *Principal) AllowsServer(name string) bool {
+	if len(p.Servers) == 0 {
+		return true
+	}
+	for _, s := range p.Servers {
+		if matchServerEntry(s, name, p.labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies one kind of credential. It returns nil when the
+// request carries no credential it accepts.
+type Authenticator interface {
+	Authenticate(r *http.Request) *Principal
+}
+
+// tokenAuth accepts static bearer tokens (the admin token and auth.tokens).
+type tokenAuth []staticToken
+
+type staticToken struct {
+	token     []byte
+	principal *Principal
+}
+
+func (ta tokenAuth) Authenticate(r *http.Request) *Principal {
+	token := requestToken(r)
+	if token == "" {
+		return nil
+	}
+	var found *Principal
+	for _, t := range ta {
+		// Compare against every token so timing doesn't reveal which matched
+		if subtle.ConstantTimeCompare([]byte(token), t.token) == 1 {
+			found = t.principal
+		}
+	}
+	return found
+}
+
+// verifiedTTL is how long a checked basic auth password is remembered.
+const verifiedTTL = 5 * time.Minute
+
+// basicAuth accepts HTTP basic auth users from auth.users, whose passwords
+// are kept as bcrypt hashes. bcrypt is slow by design and clients send
+// basic auth with every request, so a password that checked out is
+// remembered for verifiedTTL as an HMAC under a key made for this config.
+type basicAuth struct {
+	users map[string]basicUser
+	key   []byte
+
+	mu       sync.Mutex
+	verified map[string]verifiedLogin
+}
+
+type basicUser struct {
+	hash      []byte // bcrypt
+	principal *Principal
+}
+
+type verifiedLogin struct {
+	mac []byte
+	at  time.Time
+}
+
+func newBasicAuth() *basicAuth {
+	key := make([]byte, sha256.Size)
+	rand.Read(key)
+	return &basicAuth{users: make(map[string]basicUser), key: key, verified: make(map[string]verifiedLogin)}
+}
+
+// unknownUserHash is checked against for users that don't exist, so timing
+// doesn't reveal which do.
+var unknownUserHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (ba *basicAuth) Authenticate(r *http.Request) *Principal {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	u, exists := ba.users[username]
+	if !exists {
+		bcrypt.CompareHashAndPassword(unknownUserHash(), []byte(password))
+		return nil
+	}
+
+	m := hmac.New(sha256.New, ba.key)
+	m.Write([]byte(password))
+	mac := m.Sum(nil)
+	ba.mu.Lock()
+	v, seen := ba.verified[username]
+	ba.mu.Unlock()
+	if seen && time.Since(v.at) < verifiedTTL && hmac.Equal(mac, v.mac) {
+		return u.principal
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return nil
+	}
+	ba.mu.Lock()
+	ba.verified[username] = verifiedLogin{mac: mac, at: time.Now()}
+	ba.mu.Unlock()
+	return u.principal
+}
+
+// authState is the auth configuration in effect, replaced as a whole by SetAuth.
+type authState struct {
+	authenticators []Authenticator
+	exempt         []exemption
+	basic          bool // offer a Basic challenge so browsers prompt
+}
+
+// exemption is a route served without auth: a path or route template,
+// optionally limited to one method, with a trailing * matching a prefix.
+type exemption struct {
+	method string
+	path   string
+	prefix bool
+}
+
+func parseExemption(s string) exemption {
+	var e exemption
+	s = strings.TrimSpace(s)
+	if method, path, ok := strings.Cut(s, " "); ok {
+		e.method = strings.ToUpper(method)
+		s = strings.TrimSpace(path)
+	}
+	if strings.HasSuffix(s, "*") {
+		e.prefix = true
+		s = strings.TrimSuffix(s, "*")
+	}
+	e.path = s
+	return e
+}
+
+func (e exemption) matches(r *http.Request) bool {
+	if e.method != "" && e.method != r.Method {
+		return false
+	}
+	for _, p := range []string{canonicalRoute(r.URL.Path), routeTemplate(r)} {
+		if p == e.path || (e.prefix && strings.HasPrefix(p, e.path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAuth applies the auth section of the config. With no admin token,
+// tokens or users configured the API is open and API keys are not enforced.
+// Invalid entries are logged and skipped.
+func (s *Server) SetAuth(cfg config.AuthConfig) {
+	st := &authState{}
+
+	var tokens tokenAuth
+	if cfg.AdminToken != "" {
+		tokens = append(tokens, staticToken{
+			token:     []byte(cfg.AdminToken),
+			principal: &Principal{Name: "admin", Kind: "admin"},
+		})
+	}
+	for _, t := range cfg.Tokens {
+		scopes, err := expandScopes(t.Scopes, true)
+		if err == nil && t.Token == "" {
+			err = fmt.Errorf("token is empty")
+		}
+		if err != nil {
+			log.Errorf("Skipping auth token %q: %v", t.Name, err)
+			continue
+		}
+		tokens = append(tokens, staticToken{
+			token:     []byte(t.Token),
+			principal: &Principal{Name: t.Name, Kind: "token", Scopes: scopes, Servers: t.Servers, ReadOnly: t.ReadOnly},
+		})
+	}
+	if len(tokens) > 0 {
+		st.authenticators = append(st.authenticators, tokens)
+	}
+
+	users := newBasicAuth()
+	for _, u := range cfg.Users {
+		scopes, err := expandScopes(u.Scopes, true)
+		var hash []byte
+		switch {
+		case err != nil:
+		case u.Username == "":
+			err = fmt.Errorf("username is empty")
+		case u.PasswordBcrypt != "":
+			hash = []byte(u.PasswordBcrypt)
+			_, err = bcrypt.Cost(hash)
+		case u.Password != "":
+			hash, err = bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		default:
+			err = fmt.Errorf("password or password_bcrypt is required")
+		}
+		if err != nil {
+			log.Errorf("Skipping auth user %q: %v", u.Username, err)
+			continue
+		}
+		users.users[u.Username] = basicUser{
+			hash:      hash,
+			principal: &Principal{Name: u.Username, Kind: "user", Scopes: scopes, Servers: u.Servers, ReadOnly: u.ReadOnly},
+		}
+	}
+	if len(users.users) > 0 {
+		st.authenticators = append(st.authenticators, users)
+		st.basic = true
+	}
+
+	for _, e := range cfg.Exempt {
+		st.exempt = append(st.exempt, parseExemption(e))
+	}
+
+	s.authMu.Lock()
+	s.auth = st
+	s.authMu.Unlock()
+}
+
+func (s *Server) authState() *authState {
+	s.authMu.RLock()
+	defer s.authMu.RUnlock()
+	return s.auth
+}
+
+// authEnabled reports whether any credential is configured.
+func (s *Server) authEnabled() bool {
+	st := s.authState()
+	return st != nil && len(st.authenticators) > 0
+}
+
+// authMiddleware requires credentials on /api and /htmx routes (console
+// streams included) and /metrics once auth is configured, and enforces the principal's
+// scopes and servers.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := s.authState()
+		if st == nil || len(st.authenticators) == 0 || !authRequired(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, e := range st.exempt {
+			if e.matches(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		p := s.authenticate(st, r)
+		if p == nil {
+			if st.basic {
+				w.Header().Add("WWW-Authenticate", `Basic realm="ipmiserial"`)
+			}
+			_, _, hasBasic := r.BasicAuth()
+			if requestToken(r) == "" && !hasBasic {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="ipmiserial"`)
+				writeProblem(w, r, http.StatusUnauthorized, CodeAuthRequired, "Authentication required")
+				return
+			}
+			w.Header().Add("WWW-Authenticate", `Bearer realm="ipmiserial", error="invalid_token"`)
+			writeProblem(w, r, http.StatusUnauthorized, CodeInvalidToken, "Invalid or expired credentials")
+			return
+		}
+
+		setAccessUser(r, p.Name)
+		scope := routeScope(r)
+		if !p.Allows(scope) {
+			log.Debugf("%s %s denied %s %s: missing scope %s", p.Kind, p.Name, r.Method, r.URL.Path, scope)
+			writeProblem(w, r, http.StatusForbidden, CodeInsufficientScope, "Credentials lack the "+scope+" scope")
+			return
+		}
+		if name := mux.Vars(r)["name"]; name != "" {
+			if !p.AllowsServer(name) {
+				writeProblem(w, r, http.StatusForbidden, CodeServerForbidden, "Credentials are not valid for server "+name)
+				return
+			}
+		} else if len(p.Servers) > 0 && !serverFilteredRoutes[routeTemplate(r)] {
+			writeProblem(w, r, http.StatusForbidden, CodeServerForbidden, "Credentials are restricted to specific servers")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalCtxKey, p)))
+	})
+}
+
+// authenticate returns the principal a request's credentials belong to, or
+// nil if none match. The principal is a copy that resolves label selectors
+// in its servers list against the current server labels.
+func (s *Server) authenticate(st *authState, r *http.Request) *Principal {
+	authenticators := st.authenticators
+	if s.apiKeys != nil {
+		authenticators = append(authenticators[:len(authenticators):len(authenticators)], s.apiKeys)
+	}
+	for _, a := range authenticators {
+		if p := a.Authenticate(r); p != nil {
+			q := *p
+			q.labels = s.scanner.Labels
+			return &q
+		}
+	}
+	return nil
+}
+
+// authRequired reports whether a request is under the protected routes.
+func authRequired(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/htmx/") || r.URL.Path == "/metrics"
+}
+
+// requestToken returns the bearer token, X-API-Key header, api_key query
+// parameter (for EventSource clients) or web UI cookie, in that order.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		return token
+	}
+	if token := r.URL.Query().Get("api_key"); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(tokenCookie); err == nil {
+		if token, err := url.QueryUnescape(c.Value); err == nil {
+			return token
+		}
+	}
+	return ""
+}
+
+// routeTemplate returns the request's route template without the API
+// version, e.g. /api/servers/{name} for /api/v1/servers/x.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return canonicalRoute(tpl)
+		}
+	}
+	return canonicalRoute(r.URL.Path)
+}
+
+// routeScope maps a request to the scope it needs.
+func routeScope(r *http.Request) string {
+	tpl := routeTemplate(r)
+	switch {
+	case strings.HasPrefix(tpl, "/api/admin/"), strings.HasPrefix(tpl, "/api/debug/"):
+		return ScopeAdmin
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		return ScopeControl
+	case strings.Contains(tpl, "/analytics"), strings.HasSuffix(tpl, "/sel"), strings.HasSuffix(tpl, "/software"),
+		strings.HasSuffix(tpl, "/inventory"),
+		strings.HasSuffix(tpl, "/timeline"), strings.HasSuffix(tpl, "/events"), strings.HasSuffix(tpl, "/sensors"),
+		strings.HasSuffix(tpl, "/power/reading"),
+		tpl == "/api/alerts", tpl == "/metrics":
+		return ScopeAnalytics
+	case strings.Contains(tpl, "/logs"), strings.HasSuffix(tpl, "/log"):
+		return ScopeLogs
+	}
+	return ScopeServers
+}
+
+// requestPrincipal returns who a request was authenticated as, or nil when
+// auth is off or the route is exempt.
+func requestPrincipal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalCtxKey).(*Principal)
+	return p
+}
+
+// serverAllowed reports whether the request's credentials cover a server.
+func serverAllowed(r *http.Request, name string) bool {
+	p := requestPrincipal(r)
+	return p == nil || p.AllowsServer(name)
+}
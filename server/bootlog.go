@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/sol"
+)
+
+// handleBootLog returns exactly one boot's console output: the log from
+// where the boot started to where the next one did. {index} is the boot's
+// position in the analytics bootHistory (oldest first), or "current" for
+// the boot under way, whose output runs to the end of the log so far. A
+// boot that spans a rotation is stitched together from its log files. The
+// boundaries come back in X-Boot-Log-Start and X-Boot-Log-End headers as
+// file:offset.
+func (s *Server) handleBootLog(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	analytics := s.solManager.GetAnalytics(name)
+	var boot *sol.BootEvent
+	if vars["index"] == "current" {
+		boot = analytics.CurrentBoot
+	} else {
+		i, err := strconv.Atoi(vars["index"])
+		if err != nil || i < 0 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "index must be a bootHistory index or current")
+			return
+		}
+		if i < len(analytics.BootHistory) {
+			boot = &analytics.BootHistory[i]
+		}
+	}
+	if boot == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeBootNotFound, "Boot not found")
+		return
+	}
+	if boot.LogStart == nil || boot.LogStart.File == "" {
+		writeProblem(w, r, http.StatusNotFound, CodeLogNotFound, "No log position recorded for this boot")
+		return
+	}
+
+	start, end := *boot.LogStart, sol.LogPosition{Offset: -1}
+	if boot.LogEnd != nil && boot.LogEnd.File != "" {
+		end = *boot.LogEnd
+	} else if boot == analytics.CurrentBoot {
+		end.File, end.Offset, end.Line = s.logWriter.Position(name)
+	}
+
+	files, err := s.bootLogFiles(name, start.File, end.File)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if len(files) == 0 {
+		writeProblem(w, r, http.StatusNotFound, CodeLogNotFound, "The boot's log file has been removed")
+		return
+	}
+	if files[len(files)-1] != end.File {
+		end = sol.LogPosition{Offset: -1} // end file gone: read what remains
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Boot-Log-Start", fmt.Sprintf("%s:%d", start.File, start.Offset))
+	if end.File != "" {
+		h.Set("X-Boot-Log-End", fmt.Sprintf("%s:%d", end.File, end.Offset))
+	}
+	for i, file := range files {
+		from, to := int64(0), int64(-1)
+		if i == 0 {
+			from = start.Offset
+		}
+		if i == len(files)-1 {
+			to = end.Offset
+		}
+		if err := s.copyLogRange(w, name, file, from, to); err != nil {
+			return
+		}
+	}
+}
+
+// bootLogFiles lists a server's log files from first to last inclusive,
+// oldest first. last may be empty or gone, in which case only first is
+// returned; nothing is returned if first is gone.
+func (s *Server) bootLogFiles(name, first, last string) ([]string, error) {
+	files, err := s.logWriter.ListLogs(name)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(files)
+	i := slices.Index(files, first)
+	if i < 0 {
+		return nil, nil
+	}
+	j := slices.Index(files, last)
+	if j < i {
+		return files[i : i+1], nil
+	}
+	return files[i : j+1], nil
+}
+
+// copyLogRange writes a log file's bytes from offset from up to offset to,
+// or to its end when to is negative.
+func (s *Server) copyLogRange(w io.Writer, name, file string, from, to int64) error {
+	f, _, err := s.logWriter.OpenLog(name, file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(from, io.SeekStart); err != nil {
+		return err
+	}
+	if to < 0 {
+		_, err = io.Copy(w, f)
+	} else if to > from {
+		_, err = io.CopyN(w, f, to-from)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"ipmiserial/discovery"
+	"ipmiserial/sol"
+)
+
+const (
+	// bulkParallel is how many servers a bulk action runs on at once by
+	// default, and bulkMaxParallel the most a request may ask for.
+	bulkParallel    = 8
+	bulkMaxParallel = 64
+)
+
+// Bulk actions
+const (
+	bulkRotate         = "rotate"
+	bulkClear          = "clear"
+	bulkRestartSession = "restart-session"
+	bulkPowerPrefix    = "power-" // power-on, power-off, power-cycle, power-reset, power-soft
+)
+
+type bulkRequest struct {
+	Servers  []string `json:"servers"`  // server names
+	Pattern  string   `json:"pattern"`  // regular expression matched against server names
+	Labels   string   `json:"labels"`   // label selector, e.g. rack=r12,env!=prod
+	Action   string   `json:"action"`   // rotate, clear, restart-session or power-<on|off|cycle|reset|soft>
+	LogName  string   `json:"logName"`  // rotate: name of the new log (default: the time)
+	Parallel int      `json:"parallel"` // servers acted on at once (default 8)
+}
+
+// bulkResult is the outcome of a bulk action on one server.
+type bulkResult struct {
+	Server string `json:"server"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"` // e.g. the new log file
+	Error  string `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Action    string       `json:"action"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []bulkResult `json:"results"` // by server name
+}
+
+// handleBulk runs one action on every selected server concurrently and
+// reports each server's outcome. Servers are selected by name and/or
+// pattern and/or labels; names that don't exist and servers the caller may not access
+// fail without being touched.
+func (s *Server) handleBulk(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	action, err := s.bulkAction(req)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	names, err := s.selectServers(r, req)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	parallel := req.Parallel
+	if parallel <= 0 {
+		parallel = bulkParallel
+	}
+	parallel = min(parallel, bulkMaxParallel)
+
+	known := s.scanner.GetServers()
+	results := make([]bulkResult, len(names))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		results[i].Server = name
+		if _, ok := known[name]; !ok {
+			results[i].Error = "server not found"
+			continue
+		}
+		if !serverAllowed(r, name) {
+			results[i].Error = "forbidden"
+			continue
+		}
+		wg.Add(1)
+		go func(res *bulkResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			detail, err := action(res.Server)
+			res.OK, res.Detail = err == nil, detail
+			if err != nil {
+				res.Error = err.Error()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	resp := bulkResponse{Action: req.Action, Results: results}
+	for _, res := range results {
+		if res.OK {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// bulkAction returns the function that runs req's action on one server.
+func (s *Server) bulkAction(req bulkRequest) (func(name string) (string, error), error) {
+	switch {
+	case req.Action == bulkRotate:
+		return func(name string) (string, error) {
+			return s.rotateLog(name, req.LogName)
+		}, nil
+	case req.Action == bulkClear:
+		return func(name string) (string, error) {
+			return "", s.logWriter.ClearLogs(name)
+		}, nil
+	case req.Action == bulkRestartSession:
+		return func(name string) (string, error) {
+			if s.solManager.GetSession(name) == nil {
+				srv := s.scanner.GetServers()[name]
+				if srv == nil {
+					return "", fmt.Errorf("server not found")
+				}
+				s.solManager.StartSession(name, srv.IP, srv.Port, srv.Username, srv.Password, srv.Kg)
+				return "started", nil
+			}
+			s.solManager.RestartSession(name)
+			return "restarted", nil
+		}, nil
+	case strings.HasPrefix(req.Action, bulkPowerPrefix) && sol.ValidPowerAction(strings.TrimPrefix(req.Action, bulkPowerPrefix)):
+		power := strings.TrimPrefix(req.Action, bulkPowerPrefix)
+		return func(name string) (string, error) {
+			return "", s.solManager.PowerControl(name, power)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown action %q (rotate, clear, restart-session or power-on, power-off, power-cycle, power-reset, power-soft)", req.Action)
+}
+
+// selectServers resolves a bulk request's selector to sorted server names:
+// the listed names plus every known server matching both the pattern and
+// the labels, whichever are given, that the caller may access.
+func (s *Server) selectServers(r *http.Request, req bulkRequest) ([]string, error) {
+	if len(req.Servers) == 0 && req.Pattern == "" && req.Labels == "" {
+		return nil, fmt.Errorf("select servers by servers, pattern or labels")
+	}
+	selected := make(map[string]bool)
+	for _, name := range req.Servers {
+		selected[name] = true
+	}
+	if req.Pattern != "" || req.Labels != "" {
+		re, err := regexp.Compile(req.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		sel, err := discovery.ParseSelector(req.Labels)
+		if err != nil {
+			return nil, err
+		}
+		for name, srv := range s.scanner.GetServers() {
+			if re.MatchString(name) && sel.Matches(srv.Labels) && serverAllowed(r, name) {
+				selected[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,388 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// grpcService is the path prefix of ConsoleService's methods.
+const grpcService = "/ipmiserial.v1.ConsoleService/"
+
+// SetGRPCPort sets the port the gRPC ConsoleService listens on (0 = off).
+// It takes effect when Run starts.
+func (s *Server) SetGRPCPort(port int) {
+	s.grpcPort = port
+}
+
+// runGRPC serves ConsoleService until ctx is done: over TLS with the web
+// server's certificate when HTTPS is on, else as cleartext HTTP/2 (h2c).
+func (s *Server) runGRPC(ctx context.Context) {
+	var protocols http.Protocols
+	srv := &http.Server{
+		Addr:      fmt.Sprintf(":%d", s.grpcPort),
+		Handler:   http.HandlerFunc(s.serveGRPC),
+		Protocols: &protocols,
+	}
+	if s.tlsEnabled() {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			log.Errorf("gRPC server: %v", err)
+			return
+		}
+		srv.TLSConfig = tlsConfig
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	var err error
+	if srv.TLSConfig != nil {
+		log.Infof("gRPC server on port %d (TLS)", s.grpcPort)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		log.Infof("gRPC server on port %d", s.grpcPort)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("gRPC server error: %v", err)
+	}
+}
+
+// serveGRPC dispatches a ConsoleService call.
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	log.Debugf("gRPC %s from %s", r.URL.Path, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/grpc")
+	st := &grpcStream{r: r, w: w}
+	var err error
+	defer func() { st.finish(err) }()
+
+	var p *Principal
+	if as := s.authState(); as != nil && len(as.authenticators) > 0 {
+		if p = s.authenticate(as, r); p == nil {
+			err = grpcErrorf(grpcUnauthenticated, "authentication required")
+			return
+		}
+		st.r = r.WithContext(context.WithValue(r.Context(), principalCtxKey, p))
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, grpcService) {
+	case "ListServers":
+		err = s.grpcListServers(st, p)
+	case "StreamConsole":
+		err = s.grpcStreamConsole(st, p)
+	case "GetAnalytics":
+		err = s.grpcGetAnalytics(st, p)
+	case "PowerControl":
+		err = s.grpcPowerControl(st, p)
+	default:
+		err = grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+	}
+}
+
+// grpcAllow checks the caller's scope and, when name is set, that the server
+// exists and the caller may use it.
+func (s *Server) grpcAllow(p *Principal, scope, name string) error {
+	if p != nil && !p.Allows(scope) {
+		return grpcErrorf(grpcPermissionDenied, "credentials lack the %s scope", scope)
+	}
+	if name == "" {
+		return nil
+	}
+	if _, ok := s.scanner.GetServers()[name]; !ok {
+		if _, _, err := s.logWriter.GetCurrentLogTarget(name); err != nil {
+			return grpcErrorf(grpcNotFound, "server %q not found", name)
+		}
+	}
+	if p != nil && !p.AllowsServer(name) {
+		return grpcErrorf(grpcPermissionDenied, "credentials are not valid for server %s", name)
+	}
+	return nil
+}
+
+// grpcUnary reads a unary call's request message.
+func grpcUnary(st *grpcStream) ([]pbField, error) {
+	fields, err := st.recv()
+	if err == io.EOF {
+		return nil, grpcErrorf(grpcInvalidArgument, "missing request message")
+	}
+	return fields, err
+}
+
+func (s *Server) grpcListServers(st *grpcStream, p *Principal) error {
+	if _, err := grpcUnary(st); err != nil {
+		return err
+	}
+	if err := s.grpcAllow(p, ScopeServers, ""); err != nil {
+		return err
+	}
+	resp := &pbMessage{}
+	for _, info := range s.serverInfos(st.r) {
+		m := &pbMessage{}
+		m.string(1, info.Name)
+		m.string(2, info.IP)
+		m.bool(3, info.Online)
+		m.bool(4, info.Connected)
+		m.bool(5, info.Standby)
+		m.string(6, info.LastError)
+		m.bool(7, info.AuthError)
+		m.string(8, info.Controller)
+		m.string(9, info.InputHolder)
+		m.bool(10, info.RebootLooping)
+		if info.SessionStatus != nil {
+			m.string(11, string(info.State))
+			m.int(12, int64(info.Attempts))
+		}
+		resp.message(1, m)
+	}
+	return st.send(resp)
+}
+
+// grpcStreamConsole attaches to a console: the screen so far, then live
+// output, while ConsoleInput data is typed into it.
+func (s *Server) grpcStreamConsole(st *grpcStream, p *Principal) error {
+	first, err := st.recv()
+	if err == io.EOF {
+		return grpcErrorf(grpcInvalidArgument, "the first ConsoleInput must name the server")
+	}
+	if err != nil {
+		return err
+	}
+	var name string
+	var input []byte
+	cursor := int64(-1)
+	for _, f := range first {
+		switch f.num {
+		case 1:
+			name = f.string()
+		case 2:
+			input = f.data
+		case 3:
+			if int64(f.value) > 0 {
+				cursor = int64(f.value)
+			}
+		}
+	}
+	if name == "" {
+		return grpcErrorf(grpcInvalidArgument, "the first ConsoleInput must name the server")
+	}
+	if err := s.grpcAllow(p, ScopeServers, name); err != nil {
+		return err
+	}
+
+	open, err := s.streams.open(streamGRPC, name, st.r)
+	if err != nil {
+		return grpcErrorf(grpcResourceExhausted, "%v", err)
+	}
+	defer s.streams.close(open)
+
+	ctx := st.r.Context()
+	who := clientIdentity(st.r)
+	screen, ch := s.solManager.Attach(name, cursor)
+	defer s.solManager.Unsubscribe(name, ch)
+	s.streams.subscribed(open, ch)
+	defer s.solManager.TrackViewer(name, who, streamGRPC, s.viewOnlyFor(p))()
+
+	catchup := &pbMessage{}
+	if screen.Resumed {
+		catchup.bytes(1, screen.Data)
+	} else if len(screen.Data) > 0 {
+		catchup.bytes(1, append([]byte("\x1b[2J\x1b[H"), screen.Data...))
+	}
+	catchup.int(2, screen.Offset)
+	catchup.bool(3, true)
+	catchup.bool(4, screen.Resumed)
+	if err := st.send(catchup); err != nil {
+		return err
+	}
+
+	// Input is read and typed on its own goroutine; rejections come back to
+	// be reported on the stream
+	canType := p == nil || p.Allows(ScopeControl)
+	readOnly := s.readOnlyFor(p)
+	rejected := make(chan string, 16)
+	recvDone := make(chan error, 1)
+	typeInput := func(data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		var reason string
+		if !canType {
+			reason = "credentials lack the control scope"
+		} else if readOnly {
+			reason = "server is read-only"
+		} else if err := s.solManager.SendInput(name, who, data); err != nil {
+			reason = err.Error()
+		}
+		if reason != "" {
+			select {
+			case rejected <- reason:
+			case <-ctx.Done():
+			}
+		}
+	}
+	go func() {
+		typeInput(input)
+		for {
+			fields, err := st.recv()
+			if err != nil {
+				recvDone <- err
+				return
+			}
+			for _, f := range fields {
+				if f.num == 2 {
+					typeInput(f.data)
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case chunk, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			out := &pbMessage{}
+			out.bytes(1, chunk.Data)
+			out.int(2, chunk.Offset)
+			if err := st.send(out); err != nil {
+				return err
+			}
+		case reason := <-rejected:
+			out := &pbMessage{}
+			out.string(5, reason)
+			if err := st.send(out); err != nil {
+				return err
+			}
+		case err := <-recvDone:
+			// The client half-closing only ends its input
+			if err != io.EOF {
+				return err
+			}
+			recvDone = nil
+		}
+	}
+}
+
+func (s *Server) grpcGetAnalytics(st *grpcStream, p *Principal) error {
+	fields, err := grpcUnary(st)
+	if err != nil {
+		return err
+	}
+	var name string
+	for _, f := range fields {
+		if f.num == 1 {
+			name = f.string()
+		}
+	}
+	if name == "" {
+		return grpcErrorf(grpcInvalidArgument, "server is required")
+	}
+	if err := s.grpcAllow(p, ScopeAnalytics, name); err != nil {
+		return err
+	}
+
+	resp := &pbMessage{}
+	resp.string(1, name)
+	if a := s.solManager.GetAnalytics(name); a != nil {
+		resp.string(2, a.CurrentOS)
+		resp.string(3, a.Hostname)
+		resp.int(4, int64(a.TotalReboots))
+		resp.bool(5, a.RebootLooping)
+		pbTimestamp(resp, 6, a.LastSeen)
+		if a.OSUpSince != nil {
+			pbTimestamp(resp, 7, *a.OSUpSince)
+		}
+		boot := a.CurrentBoot
+		if boot == nil && len(a.BootHistory) > 0 {
+			boot = &a.BootHistory[len(a.BootHistory)-1]
+		}
+		if boot != nil {
+			resp.bool(8, !boot.Complete)
+			pbTimestamp(resp, 9, boot.StartTime)
+			resp.double(10, boot.BootDuration)
+			resp.string(11, boot.DetectedOS)
+		}
+		doc, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		resp.bytes(12, doc)
+	}
+	return st.send(resp)
+}
+
+// pbTimestamp writes t as a google.protobuf.Timestamp field, unless zero.
+func pbTimestamp(m *pbMessage, field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	ts := &pbMessage{}
+	ts.int(1, t.Unix())
+	ts.int(2, int64(t.Nanosecond()))
+	m.message(field, ts)
+}
+
+func (s *Server) grpcPowerControl(st *grpcStream, p *Principal) error {
+	fields, err := grpcUnary(st)
+	if err != nil {
+		return err
+	}
+	var name, action string
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			name = f.string()
+		case 2:
+			action = f.string()
+		}
+	}
+	if name == "" || action == "" {
+		return grpcErrorf(grpcInvalidArgument, "server and action are required")
+	}
+	scope := ScopeControl
+	if action == "status" {
+		scope = ScopeServers
+	}
+	if err := s.grpcAllow(p, scope, name); err != nil {
+		return err
+	}
+	if action != "status" && s.readOnlyFor(p) {
+		return grpcErrorf(grpcPermissionDenied, "server is read-only")
+	}
+
+	resp := &pbMessage{}
+	resp.string(1, action)
+	if action == "status" {
+		on, err := s.solManager.GetPowerState(name)
+		if err != nil {
+			return grpcErrorf(grpcUnavailable, "%v", err)
+		}
+		resp.bool(2, on)
+	} else if err := s.solManager.PowerControl(name, action); err != nil {
+		if strings.HasPrefix(err.Error(), "invalid power action") {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		return grpcErrorf(grpcUnavailable, "%v", err)
+	}
+	return st.send(resp)
+}
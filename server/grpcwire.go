@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Just enough of the protobuf wire format and gRPC's HTTP/2 framing to serve
+// ConsoleService (proto/console.proto) without generated code.
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// pbMessage builds an encoded protobuf message. Fields holding their zero
+// value are left out, as proto3 does.
+type pbMessage struct {
+	buf []byte
+}
+
+func (m *pbMessage) tag(field, wire int) {
+	m.buf = binary.AppendUvarint(m.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (m *pbMessage) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	m.tag(field, wireVarint)
+	m.buf = binary.AppendUvarint(m.buf, v)
+}
+
+func (m *pbMessage) int(field int, v int64) { m.uint(field, uint64(v)) }
+
+func (m *pbMessage) bool(field int, v bool) {
+	if v {
+		m.uint(field, 1)
+	}
+}
+
+func (m *pbMessage) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	m.tag(field, wireFixed64)
+	m.buf = binary.LittleEndian.AppendUint64(m.buf, math.Float64bits(v))
+}
+
+func (m *pbMessage) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	m.tag(field, wireBytes)
+	m.buf = binary.AppendUvarint(m.buf, uint64(len(v)))
+	m.buf = append(m.buf, v...)
+}
+
+func (m *pbMessage) string(field int, v string) { m.bytes(field, []byte(v)) }
+
+// message embeds a message field; it is written even when empty, so a
+// repeated field keeps its empty elements.
+func (m *pbMessage) message(field int, sub *pbMessage) {
+	m.tag(field, wireBytes)
+	m.buf = binary.AppendUvarint(m.buf, uint64(len(sub.buf)))
+	m.buf = append(m.buf, sub.buf...)
+}
+
+// pbField is one decoded field: the value of a varint or fixed field, or the
+// contents of a length-delimited one.
+type pbField struct {
+	num   int
+	wire  int
+	value uint64
+	data  []byte
+}
+
+func (f pbField) string() string { return string(f.data) }
+
+var errMalformed = errors.New("malformed protobuf message")
+
+// pbFields decodes a message into its fields, in order. Unknown fields are
+// returned like any other, for the caller to skip.
+func pbFields(b []byte) ([]pbField, error) {
+	var fields []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errMalformed
+		}
+		b = b[n:]
+		f := pbField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.value, n = binary.Uvarint(b); n <= 0 {
+				return nil, errMalformed
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, errMalformed
+			}
+			f.value, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, errMalformed
+			}
+			f.value, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, errMalformed
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return nil, errMalformed
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcCanceled          = 1
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcMaxMessage bounds a request message (the gRPC default).
+const grpcMaxMessage = 4 << 20
+
+// grpcError is an RPC failure with its gRPC status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// grpcStream reads length-prefixed messages from a gRPC request and writes
+// them to the response, flushing each so streams are delivered as they go.
+type grpcStream struct {
+	r *http.Request
+	w http.ResponseWriter
+}
+
+// recv reads the next request message, io.EOF once the client has finished
+// sending.
+func (st *grpcStream) recv() ([]pbField, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(st.r.Body, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, grpcErrorf(grpcInvalidArgument, "truncated message")
+		}
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes exceeds %d", size, grpcMaxMessage)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(st.r.Body, buf); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "truncated message")
+	}
+	fields, err := pbFields(buf)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return fields, nil
+}
+
+func (st *grpcStream) send(m *pbMessage) error {
+	frame := make([]byte, 5, 5+len(m.buf))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(m.buf)))
+	if _, err := st.w.Write(append(frame, m.buf...)); err != nil {
+		return err
+	}
+	return http.NewResponseController(st.w).Flush()
+}
+
+// finish sets the call's status trailers from err.
+func (st *grpcStream) finish(err error) {
+	code, msg := grpcOK, ""
+	var ge *grpcError
+	switch {
+	case err == nil:
+	case errors.As(err, &ge):
+		code, msg = ge.code, ge.msg
+	case st.r.Context().Err() != nil:
+		code, msg = grpcCanceled, "canceled"
+	default:
+		code, msg = grpcInternal, err.Error()
+	}
+	h := st.w.Header()
+	h.Set(http.TrailerPrefix+"Grpc-Status", fmt.Sprint(code))
+	if msg != "" {
+		h.Set(http.TrailerPrefix+"Grpc-Message", grpcPercentEncode(msg))
+	}
+}
+
+// grpcPercentEncode escapes a status message as gRPC requires: bytes outside
+// printable ASCII, and '%'.
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
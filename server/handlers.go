@@ -1,29 +1,79 @@
 package server
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"ipmiserial/logs"
+	"ipmiserial/sol"
+
 	"github.com/gorilla/mux"
 )
 
 
 type ServerInfo struct {
-	Name      string `json:"name"`
-	IP        string `json:"ip"`
-	Online    bool   `json:"online"`
-	Connected bool   `json:"connected"`
-	LastError string `json:"lastError,omitempty"`
-	AuthError bool   `json:"authError,omitempty"`
+	Name          string        `json:"name"`
+	IP            string        `json:"ip"`
+	Online        bool          `json:"online"`
+	Connected     bool          `json:"connected"`
+	Standby       bool          `json:"standby,omitempty"` // powered off; SOL waits for power-on
+	LastError     string        `json:"lastError,omitempty"`
+	AuthError     bool          `json:"authError,omitempty"`
+	Controller    string        `json:"controller,omitempty"`    // client currently typing into the console
+	InputHolder   string        `json:"inputHolder,omitempty"`   // client holding exclusive input, if any
+	InputOwned    bool          `json:"inputOwned,omitempty"`    // the holder is the requesting client
+	RebootLooping bool          `json:"rebootLooping,omitempty"` // rebooting in a loop (see reboot_detection.loop_reboots)
+	SOL           *sol.SOLStats `json:"sol,omitempty"`           // SOL traffic counters (status endpoint only)
+
+	// Session lifecycle: state, when it was entered, connect attempts and
+	// the last successful connect (unset = never connected)
+	*sol.SessionStatus
+
+	Labels map[string]string `json:"labels,omitempty"`
+	Group  string            `json:"group,omitempty"` // value of the server.group_label label
+}
+
+// sessionStatus returns a session's lifecycle status, or state for a
+// server without a session. A queued session gets its queue position.
+func (s *Server) sessionStatus(name string, session *sol.Session, state sol.SessionState) *sol.SessionStatus {
+	if session == nil {
+		return &sol.SessionStatus{State: state}
+	}
+	st := session.Status()
+	if st.State == sol.SessionQueued {
+		st.QueuePosition = s.solManager.QueuePosition(name)
+	}
+	return &st
+}
+
+// clientIdentity describes the client behind a request as "user@host" for
+// console control banners. The user is the authenticated principal, else
+// basic auth or a proxy-supplied header; the host from X-Forwarded-For or the
+// remote address.
+func clientIdentity(r *http.Request) string {
+	var user string
+	if p := requestPrincipal(r); p != nil {
+		user = p.Name
+	} else {
+		user = requestUser(r)
+	}
+	if user == "" {
+		user = "anonymous"
+	}
+	return user + "@" + clientHost(r)
 }
 
 // isAuthError checks if an error string indicates IPMI credential failure.
@@ -44,8 +94,23 @@ func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleListServers(w http.ResponseWriter, r *http.Request) {
+	q, err := parseServerListQuery(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	page, total := q.apply(s.serverInfos(r))
+	setPageHeaders(w, r, q, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// serverInfos lists the servers the request may see, discovered servers and
+// servers known only by their log directory, by name.
+func (s *Server) serverInfos(r *http.Request) []ServerInfo {
 	servers := s.scanner.GetServers()
 	sessions := s.solManager.GetSessions()
+	who := clientIdentity(r)
 
 	// Build set of known names and IPs from scanner
 	seen := make(map[string]bool)
@@ -54,41 +119,52 @@ func (s *Server) handleListServers(w http.ResponseWriter, r *http.Request) {
 	for name, srv := range servers {
 		seen[name] = true
 		knownIPs[srv.IP] = true
+		if !serverAllowed(r, name) {
+			continue
+		}
 		info := ServerInfo{
 			Name:   name,
 			IP:     srv.IP,
 			Online: srv.Online,
+			Labels: srv.Labels,
+			Group:  s.serverGroup(srv.Labels),
 		}
+		info.SessionStatus = s.sessionStatus(name, sessions[name], sol.SessionDiscovering)
 		if session, exists := sessions[name]; exists {
 			info.Connected = session.Connected
+			info.Standby = session.Standby
 			info.LastError = session.LastError
 			info.AuthError = !session.Connected && isAuthError(session.LastError)
+			info.Controller = s.solManager.GetController(name)
 			if info.IP == "" && session.IP != "" {
 				info.IP = session.IP
 			}
 		}
+		info.InputHolder = s.solManager.InputHolder(name)
+		info.InputOwned = info.InputHolder != "" && info.InputHolder == who
+		info.RebootLooping = s.solManager.RebootLooping(name)
 		result = append(result, info)
 	}
 
 	// Add servers that have log directories but aren't in scanner
 	for _, name := range s.logWriter.ListServerDirs() {
-		if seen[name] || knownIPs[name] {
+		if seen[name] || knownIPs[name] || !serverAllowed(r, name) {
 			continue
 		}
-		info := ServerInfo{Name: name}
+		info := ServerInfo{Name: name, SessionStatus: s.sessionStatus(name, sessions[name], sol.SessionStopped)}
 		if session, exists := sessions[name]; exists {
 			info.Connected = session.Connected
 			info.LastError = session.LastError
 			info.AuthError = !session.Connected && isAuthError(session.LastError)
+			info.Controller = s.solManager.GetController(name)
 			if info.IP == "" && session.IP != "" {
 				info.IP = session.IP
 			}
 		}
 		result = append(result, info)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
 }
 
 func (s *Server) handleListLogs(w http.ResponseWriter, r *http.Request) {
@@ -97,7 +173,7 @@ func (s *Server) handleListLogs(w http.ResponseWriter, r *http.Request) {
 
 	logs, err := s.logWriter.ListLogs(name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
@@ -110,19 +186,31 @@ func (s *Server) handleGetLog(w http.ResponseWriter, r *http.Request) {
 	name := vars["name"]
 	filename := vars["filename"]
 
-	path := s.logWriter.GetLogPath(name, filename)
+	// ?raw=true returns the untouched SOL capture paired with this log
+	raw := r.URL.Query().Get("raw") == "true"
+	if raw {
+		filename = logs.RawName(filename)
+	}
 
-	data, err := os.ReadFile(path)
+	data, err := s.logWriter.ReadLog(name, filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			http.Error(w, "Log not found", http.StatusNotFound)
+			if raw {
+				writeProblem(w, r, http.StatusNotFound, CodeRawNotFound, "Raw capture not found (logs.raw_capture disabled when this log was written?)")
+			} else {
+				writeProblem(w, r, http.StatusNotFound, CodeLogNotFound, "Log not found")
+			}
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			internalError(w, r, err)
 		}
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if raw {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
 	w.Write(data)
 }
 
@@ -131,23 +219,27 @@ func (s *Server) handleLogInfo(w http.ResponseWriter, r *http.Request) {
 	name := vars["name"]
 	filename := vars["filename"]
 
-	path := s.logWriter.GetLogPath(name, filename)
-
-	info, err := os.Stat(path)
+	path, compressed, err := s.logWriter.ResolveLogPath(name, filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			http.Error(w, "Log not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeLogNotFound, "Log not found")
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			internalError(w, r, err)
 		}
 		return
 	}
+	info, err := os.Stat(path)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"filename": filename,
-		"size":     info.Size(),
-		"modified": info.ModTime(),
+		"filename":   filename,
+		"size":       info.Size(),
+		"modified":   info.ModTime(),
+		"compressed": compressed,
 	})
 }
 
@@ -158,22 +250,33 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	servers := s.scanner.GetServers()
 	srv, exists := servers[name]
 	if !exists {
-		http.Error(w, "Server not found", http.StatusNotFound)
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
 		return
 	}
 
 	session := s.solManager.GetSession(name)
 
 	info := ServerInfo{
-		Name:   name,
-		IP:     srv.IP,
-		Online: srv.Online,
+		Name:          name,
+		IP:            srv.IP,
+		Online:        srv.Online,
+		RebootLooping: s.solManager.RebootLooping(name),
+		Labels:        srv.Labels,
+		Group:         s.serverGroup(srv.Labels),
+		SessionStatus: s.sessionStatus(name, session, sol.SessionDiscovering),
 	}
 
 	if session != nil {
 		info.Connected = session.Connected
+		info.Standby = session.Standby
 		info.LastError = session.LastError
 		info.AuthError = !session.Connected && isAuthError(session.LastError)
+		info.Controller = s.solManager.GetController(name)
+		info.InputHolder = s.solManager.InputHolder(name)
+		info.InputOwned = info.InputHolder != "" && info.InputHolder == clientIdentity(r)
+		if stats, ok := s.solManager.SOLStats(name); ok {
+			info.SOL = &stats
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -185,7 +288,7 @@ func (s *Server) handleClearLogs(w http.ResponseWriter, r *http.Request) {
 	name := vars["name"]
 
 	if err := s.logWriter.ClearLogs(name); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
@@ -195,7 +298,7 @@ func (s *Server) handleClearLogs(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleClearAllLogs(w http.ResponseWriter, r *http.Request) {
 	if err := s.logWriter.ClearAllLogs(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
@@ -203,32 +306,59 @@ func (s *Server) handleClearAllLogs(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
+// Rotation refusals
+var (
+	errRotationCooldown = errors.New("rotation cooldown active")
+	errRotationPaused   = errors.New("rotation paused while the server is in a reboot loop")
+)
+
 func (s *Server) handleRotateLogs(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	// Enforce rotation cooldown — prevent mid-boot splits from duplicate calls
-	if !s.logWriter.CanRotate(name) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusTooEarly)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status":  "skipped",
-			"message": "rotation cooldown active",
-		})
-		return
-	}
-
 	// Get optional log name from query param or form
 	logName := r.URL.Query().Get("name")
 	if logName == "" {
 		logName = r.FormValue("name")
 	}
 
+	newFile, err := s.rotateLog(name, logName)
+	switch {
+	case errors.Is(err, errRotationCooldown):
+		writeProblem(w, r, http.StatusTooEarly, CodeRotationCooldown, "Rotation cooldown active")
+		return
+	case errors.Is(err, errRotationPaused):
+		writeProblem(w, r, http.StatusConflict, CodeRotationPaused, "Rotation paused while the server is in a reboot loop")
+		return
+	case err != nil:
+		internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "ok",
+		"message": "log rotated",
+		"file":    newFile,
+	})
+}
+
+// rotateLog starts a new log file for a server, named logName or by time,
+// and returns its name.
+func (s *Server) rotateLog(name, logName string) (string, error) {
+	// Enforce rotation cooldown — prevent mid-boot splits from duplicate calls
+	if !s.logWriter.CanRotate(name) {
+		return "", errRotationCooldown
+	}
+	// Keep a reboot loop's output together in one file
+	if s.solManager.RotationPaused(name) {
+		return "", errRotationPaused
+	}
+
 	// Rotate FIRST so the symlink points to the new file
 	newFile, err := s.logWriter.RotateWithName(name, logName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return "", err
 	}
 
 	// Record rotation time for power-on delay tracking
@@ -243,16 +373,10 @@ func (s *Server) handleRotateLogs(w http.ResponseWriter, r *http.Request) {
 	if session := s.solManager.GetSession(name); session == nil {
 		servers := s.scanner.GetServers()
 		if srv, exists := servers[name]; exists {
-			s.solManager.StartSession(name, srv.IP, srv.Username, srv.Password)
+			s.solManager.StartSession(name, srv.IP, srv.Port, srv.Username, srv.Password, srv.Kg)
 		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "ok",
-		"message": "log rotated",
-		"file":    newFile,
-	})
+	return newFile, nil
 }
 
 func (s *Server) handleMacLookup(w http.ResponseWriter, r *http.Request) {
@@ -262,11 +386,11 @@ func (s *Server) handleMacLookup(w http.ResponseWriter, r *http.Request) {
 	// Normalize the input MAC
 	normalized := normalizeMac(mac)
 
+	s.macMu.RLock()
 	serverName, found := s.macLookup[normalized]
+	s.macMu.RUnlock()
 	if !found {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(`{"error":"MAC address not found"}`))
+		writeProblem(w, r, http.StatusNotFound, CodeMACNotFound, "MAC address not found")
 		return
 	}
 
@@ -285,21 +409,23 @@ func (s *Server) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 		Command string `json:"command"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON body")
 		return
 	}
 	if body.Command == "" {
-		http.Error(w, "command is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "command is required")
 		return
 	}
 
-	if err := s.solManager.SendCommand(name, []byte(body.Command)); err != nil {
+	if err := s.solManager.SendInput(name, clientIdentity(r), []byte(body.Command)); err != nil {
 		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, err.Error())
 		} else if strings.Contains(err.Error(), "not connected") {
-			http.Error(w, err.Error(), http.StatusConflict)
+			writeProblem(w, r, http.StatusConflict, CodeNotConnected, err.Error())
+		} else if strings.Contains(err.Error(), "held by") {
+			writeProblem(w, r, http.StatusConflict, CodeInputHeld, err.Error())
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			internalError(w, r, err)
 		}
 		return
 	}
@@ -314,18 +440,130 @@ func (s *Server) handleInput(w http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
 	if err != nil || len(body) == 0 {
-		http.Error(w, "empty body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Empty body")
 		return
 	}
 
 	data, err := base64.StdEncoding.DecodeString(string(body))
 	if err != nil {
-		http.Error(w, "invalid base64", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid base64")
+		return
+	}
+
+	if err := s.solManager.SendInput(name, clientIdentity(r), data); err != nil {
+		code := CodeInputRejected
+		if strings.Contains(err.Error(), "held by") {
+			code = CodeInputHeld
+		}
+		writeProblem(w, r, http.StatusConflict, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleBreak sends a serial break, optionally followed by a magic SysRq
+// key: {"sysrq":"b"}. An empty body sends a plain break.
+// handleAcquireInput gives the client exclusive input to a console;
+// {"force":true} takes it over from another holder.
+func (s *Server) handleAcquireInput(w http.ResponseWriter, r *http.Request) {
+	s.handleInputHold(w, r, s.solManager.AcquireInput)
+}
+
+// handleReleaseInput gives up the client's hold on a console;
+// {"force":true} releases another client's hold.
+func (s *Server) handleReleaseInput(w http.ResponseWriter, r *http.Request) {
+	s.handleInputHold(w, r, s.solManager.ReleaseInput)
+}
+
+func (s *Server) handleInputHold(w http.ResponseWriter, r *http.Request, fn func(serverName, who string, force bool) error) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	var req struct {
+		Force bool `json:"force"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil && err != io.EOF {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON body")
+		return
+	}
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	who := clientIdentity(r)
+	if err := fn(name, who, req.Force); err != nil {
+		writeProblem(w, r, http.StatusConflict, CodeInputHeld, err.Error())
+		return
+	}
+
+	holder := s.solManager.InputHolder(name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"server":     name,
+		"holder":     holder,
+		"inputOwned": holder != "" && holder == who,
+	})
+}
+
+// viewersResponse lists who is on a console, so a client can check nobody
+// else is before sending something destructive.
+type viewersResponse struct {
+	Server     string       `json:"server"`
+	Holder     string       `json:"holder,omitempty"`     // client holding exclusive input
+	Controller string       `json:"controller,omitempty"` // client that typed last
+	Others     int          `json:"others"`               // viewers other than the caller
+	Viewers    []sol.Viewer `json:"viewers"`
+}
+
+// handleViewers lists the connections watching a server's console: SSE
+// streams, attaches and the SSH, telnet, IPMI and conserver gateways.
+func (s *Server) handleViewers(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	who := clientIdentity(r)
+	resp := viewersResponse{
+		Server:     name,
+		Holder:     s.solManager.InputHolder(name),
+		Controller: s.solManager.GetController(name),
+		Viewers:    s.solManager.GetViewers(name),
+	}
+	for _, v := range resp.Viewers {
+		if v.Who != who {
+			resp.Others++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleBreak(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	var req struct {
+		SysRq string `json:"sysrq"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil && err != io.EOF {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON body")
+		return
+	}
+	if req.SysRq != "" && !sol.ValidSysRq(req.SysRq) {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "sysrq must be a single key 0-9 or a-z")
 		return
 	}
 
-	if err := s.solManager.SendCommand(name, data); err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+	if err := s.solManager.SendBreak(name, clientIdentity(r), req.SysRq); err != nil {
+		code := CodeInputRejected
+		if strings.Contains(err.Error(), "held by") {
+			code = CodeInputHeld
+		}
+		writeProblem(w, r, http.StatusConflict, code, err.Error())
 		return
 	}
 
@@ -336,18 +574,17 @@ func (s *Server) handleReconnect(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	session := s.solManager.GetSession(name)
-	if session == nil {
+	// A session in backoff or on standby retries at once; any other is
+	// restarted
+	if !s.solManager.Reconnect(name) {
 		// No session — try to start one from scanner data
 		servers := s.scanner.GetServers()
 		srv, exists := servers[name]
 		if !exists {
-			http.Error(w, "server not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
 			return
 		}
-		s.solManager.StartSession(name, srv.IP, srv.Username, srv.Password)
-	} else {
-		go s.solManager.RestartSession(name)
+		s.solManager.StartSession(name, srv.IP, srv.Port, srv.Username, srv.Password, srv.Kg)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -375,13 +612,135 @@ func (s *Server) handleAnalytics(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(analytics)
 }
 
+func (s *Server) handleSEL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.solManager.GetSEL(name))
+}
+
+// handleConnectHistory returns a server's recent connect attempts, newest
+// first, optionally capped by ?limit=.
+func (s *Server) handleConnectHistory(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	attempts := s.solManager.GetConnectHistory(name)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "limit must be a non-negative integer")
+			return
+		}
+		if n < len(attempts) {
+			attempts = attempts[:n]
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+func (s *Server) handleSoftware(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.solManager.GetSoftware(name))
+}
+
+// handleInventory returns the BIOS, boot firmware, NIC and GPU details
+// picked out of a server's POST and boot output.
+func (s *Server) handleInventory(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.solManager.GetInventory(name))
+}
+
+// handleAllSoftware exports fleet-wide software facts as JSON, or as one
+// row per server/component with ?format=csv.
+func (s *Server) handleAllSoftware(w http.ResponseWriter, r *http.Request) {
+	facts := s.solManager.GetAllSoftware()
+	for name := range facts {
+		if !serverAllowed(r, name) {
+			delete(facts, name)
+		}
+	}
+
+	if r.URL.Query().Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(facts)
+		return
+	}
+
+	names := make([]string, 0, len(facts))
+	for name := range facts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=software.csv")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"server", "hostname", "kind", "name", "version", "previous_version", "first_seen", "last_seen"})
+	for _, name := range names {
+		f := facts[name]
+		for _, c := range f.Components {
+			cw.Write([]string{name, f.Hostname, c.Kind, c.Name, c.Version, c.PreviousVersion,
+				c.FirstSeen.Format(time.RFC3339), c.LastSeen.Format(time.RFC3339)})
+		}
+	}
+	cw.Flush()
+}
+
 func (s *Server) handleAllAnalytics(w http.ResponseWriter, r *http.Request) {
 	analytics := s.solManager.GetAllAnalytics()
+	for name := range analytics {
+		if !serverAllowed(r, name) {
+			delete(analytics, name)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(analytics)
 }
 
+func (s *Server) handlePipelineStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.solManager.GetPipelineStats())
+}
+
+func (s *Server) handlePowerOnReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.solManager.GetPowerOnReport())
+}
+
+// summaryWindow is the default period /api/analytics/summary covers.
+const summaryWindow = 7 * 24 * time.Hour
+
+// handleFleetSummary serves fleet boot health for the servers the caller
+// may see: success rate, boot duration percentiles, reboots per day and
+// PXE loops over ?window= (default 7 days).
+func (s *Server) handleFleetSummary(w http.ResponseWriter, r *http.Request) {
+	window := summaryWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "window must be a positive duration such as 24h")
+			return
+		}
+		window = d
+	}
+	summary := s.solManager.GetFleetSummary(window, func(name string) bool {
+		return serverAllowed(r, name)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
 // HTML fragment handlers for htmx
 
 func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
@@ -414,6 +773,19 @@ func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
 		hostnameHTML = fmt.Sprintf(`<p class="mb-1"><strong>Hostname:</strong> <span class="text-info">%s</span></p>`, html.EscapeString(data.Hostname))
 	}
 
+	powerOnHTML := ""
+	if stats, ok := s.solManager.GetPowerOnReport().Servers[name]; ok && stats.Rotation.Count > 0 {
+		powerOnHTML = fmt.Sprintf(`<p class="mb-1"><strong>Power-On p50/p90:</strong> <span class="text-info">%.1fs / %.1fs</span></p>`, stats.Rotation.P50, stats.Rotation.P90)
+	}
+	if data.PowerOnDegraded {
+		powerOnHTML += `<p class="mb-1"><span class="badge bg-warning text-dark">Power-on latency degraded</span></p>`
+	}
+
+	errorsHTML := ""
+	if data.ErrorCounts.Total() > 0 {
+		errorsHTML = fmt.Sprintf(`<p class="mb-1"><strong>Console Errors:</strong> <span class="text-danger">%s</span></p>`, data.ErrorCounts)
+	}
+
 	osHTML := ""
 	if data.CurrentOS != "" {
 		osHTML = fmt.Sprintf(`<p class="mb-1"><strong>OS/Image:</strong> <span class="text-info">%s</span></p>`, html.EscapeString(data.CurrentOS))
@@ -431,10 +803,23 @@ func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
 			currentBootHTML += fmt.Sprintf(`<p class="mb-1"><strong>Boot Duration:</strong> <span class="text-info">%.1fs</span></p>`, data.CurrentBoot.BootDuration)
 		} else {
 			currentBootHTML += `<p class="mb-1"><strong>Status:</strong> <span class="text-warning">In Progress...</span></p>`
+			if n := len(data.CurrentBoot.Stages); n > 0 {
+				stage := data.CurrentBoot.Stages[n-1]
+				currentBootHTML += fmt.Sprintf(`<p class="mb-1"><strong>Stage:</strong> <span class="text-info">%s</span> (%s)</p>`,
+					html.EscapeString(stage.Name), formatDuration(time.Since(stage.Start).Seconds()))
+			}
 		}
 		if data.CurrentBoot.DetectedOS != "" {
 			currentBootHTML += fmt.Sprintf(`<p class="mb-1"><strong>Detected OS:</strong> <span class="text-info">%s</span></p>`, html.EscapeString(data.CurrentBoot.DetectedOS))
 		}
+		if data.CurrentBoot.ErrorCounts.Total() > 0 {
+			currentBootHTML += fmt.Sprintf(`<p class="mb-1"><strong>Errors:</strong> <span class="text-danger">%s</span></p>`, data.CurrentBoot.ErrorCounts)
+			if n := len(data.CurrentBoot.Errors); n > 0 {
+				last := data.CurrentBoot.Errors[n-1]
+				currentBootHTML += fmt.Sprintf(`<p class="mb-1 small text-danger font-monospace text-truncate" title="%s">%s</p>`,
+					html.EscapeString(last.Line), html.EscapeString(last.Line))
+			}
+		}
 	}
 
 	// Boot Milestones
@@ -474,7 +859,7 @@ func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
 	// Boot History rows
 	bootHistoryHTML := ""
 	if data.CurrentBoot == nil && len(data.BootHistory) == 0 {
-		bootHistoryHTML = `<tr><td colspan="5" class="text-muted text-center">No boot history</td></tr>`
+		bootHistoryHTML = `<tr><td colspan="8" class="text-muted text-center">No boot history</td></tr>`
 	} else {
 		if data.CurrentBoot != nil {
 			networkIssues := ""
@@ -502,8 +887,8 @@ func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
 			if data.CurrentBoot.Complete {
 				statusCell = `<span class="text-success">Complete</span>`
 			}
-			bootHistoryHTML += fmt.Sprintf(`<tr><td>%s <span class="badge bg-info">Current</span></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
-				data.CurrentBoot.StartTime.Local().Format("Jan 2 15:04:05"), durationCell, osCell, networkCell, statusCell)
+			bootHistoryHTML += fmt.Sprintf(`<tr><td>%s <span class="badge bg-info">Current</span></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+				data.CurrentBoot.StartTime.Local().Format("Jan 2 15:04:05"), durationCell, stagesCell(data.CurrentBoot.Stages), osCell, networkCell, errorsCell(data.CurrentBoot.ErrorCounts), anomaliesCell(data.CurrentBoot.Anomalies), statusCell)
 		}
 		for i := len(data.BootHistory) - 1; i >= 0; i-- {
 			b := data.BootHistory[i]
@@ -532,8 +917,8 @@ func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
 			if !b.Complete {
 				statusCell = `<span class="text-warning">Incomplete</span>`
 			}
-			bootHistoryHTML += fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
-				b.StartTime.Local().Format("Jan 2 15:04:05"), durationCell, osCell, networkCell, statusCell)
+			bootHistoryHTML += fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+				b.StartTime.Local().Format("Jan 2 15:04:05"), durationCell, stagesCell(b.Stages), osCell, networkCell, errorsCell(b.ErrorCounts), anomaliesCell(b.Anomalies), statusCell)
 		}
 	}
 
@@ -542,7 +927,7 @@ func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
 <div class="card"><div class="card-header">Current Status</div>
 <div class="card-body">
 <p class="mb-1"><strong>Status:</strong> <span class="%s">%s</span></p>
-%s%s%s
+%s%s%s%s%s
 <p class="mb-0"><strong>Total Reboots:</strong> %d</p>
 </div></div></div>
 <div class="col-md-3 mb-3">
@@ -558,12 +943,48 @@ func (s *Server) handleAnalyticsHTML(w http.ResponseWriter, r *http.Request) {
 <div class="card mt-3"><div class="card-header">Boot History</div>
 <div class="card-body p-0">
 <table class="table table-striped mb-0">
-<thead><tr><th>Boot Time</th><th>Duration</th><th>OS/Image</th><th>Network Issues</th><th>Status</th></tr></thead>
+<thead><tr><th>Boot Time</th><th>Duration</th><th>Stages</th><th>OS/Image</th><th>Network Issues</th><th>Errors</th><th>Anomalies</th><th>Status</th></tr></thead>
 <tbody>%s</tbody></table></div></div>`,
-		statusClass, statusText, uptimeHTML, hostnameHTML, osHTML, data.TotalReboots,
+		statusClass, statusText, uptimeHTML, hostnameHTML, osHTML, powerOnHTML, errorsHTML, data.TotalReboots,
 		currentBootHTML, milestonesHTML, networkHTML, bootHistoryHTML)
 }
 
+// stagesCell renders a boot's stage durations for the history table.
+func stagesCell(stages []sol.BootStage) string {
+	if len(stages) == 0 {
+		return `<span class="text-muted">-</span>`
+	}
+	return fmt.Sprintf(`<span class="small">%s</span>`, html.EscapeString(sol.StageSummary(stages)))
+}
+
+// errorsCell renders a boot's console error counts for the history table.
+func errorsCell(c sol.ErrorCounts) string {
+	if c.Total() == 0 {
+		return `<span class="text-muted">None</span>`
+	}
+	return fmt.Sprintf(`<span class="text-danger">%s</span>`, c)
+}
+
+// anomaliesCell renders a boot's anomaly counts for the history table, with
+// the anomalies themselves in the tooltip.
+func anomaliesCell(sum *sol.AnomalySummary) string {
+	if sum == nil || sum.Counts.Total() == 0 {
+		return `<span class="text-muted">None</span>`
+	}
+	var lines []string
+	for _, a := range sum.Anomalies {
+		line := a.Kind
+		if a.Subject != "" {
+			line += " " + a.Subject
+		}
+		if a.Count > 1 {
+			line += fmt.Sprintf(" (x%d)", a.Count)
+		}
+		lines = append(lines, line)
+	}
+	return fmt.Sprintf(`<span class="text-warning" title="%s">%s</span>`, html.EscapeString(strings.Join(lines, "\n")), sum.Counts)
+}
+
 func (s *Server) handleLogListHTML(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
@@ -573,7 +994,7 @@ func (s *Server) handleLogListHTML(w http.ResponseWriter, r *http.Request) {
 
 	logs, err := s.logWriter.ListLogs(name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
@@ -615,23 +1036,18 @@ func (s *Server) handleLogContentHTML(w http.ResponseWriter, r *http.Request) {
 
 	chunkSize := int64(64 * 1024) // 64KB chunks
 
-	path := s.logWriter.GetLogPath(name, filename)
-
-	file, err := os.Open(path)
+	file, fileSize, err := s.logWriter.OpenLog(name, filename)
 	if err != nil {
 		if os.IsNotExist(err) {
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
 			fmt.Fprint(w, `<div class="text-muted p-3">Log not found</div>`)
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			internalError(w, r, err)
 		}
 		return
 	}
 	defer file.Close()
 
-	info, _ := file.Stat()
-	fileSize := info.Size()
-
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	if fileSize == 0 {
@@ -709,9 +1125,6 @@ func formatDuration(seconds float64) string {
 
 func (s *Server) handleDebugBMH(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	bmhURL := s.scanner.BMHURL()
-	fetchURL := s.scanner.BMHListURL()
-	client := &http.Client{Timeout: 5 * time.Second}
 
 	// Test gateway connectivity
 	gwErr := ""
@@ -722,38 +1135,54 @@ func (s *Server) handleDebugBMH(w http.ResponseWriter, r *http.Request) {
 		gwConn.Close()
 	}
 
-	// Test BMH API
-	bmhErr := ""
-	bmhStatus := 0
-	resp, err := client.Get(fetchURL)
-	if err != nil {
-		bmhErr = err.Error()
-	} else {
-		bmhStatus = resp.StatusCode
-		resp.Body.Close()
-	}
+	// Test BMH APIs
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	bmhProbe := s.scanner.ProbeBMH(ctx)
 
 	// Scanner internal state
 	scannerServers := s.scanner.GetServers()
 	scannerState := make(map[string]string)
 	for name, srv := range scannerServers {
-		scannerState[name] = fmt.Sprintf("ip=%s online=%v user=%s", srv.IP, srv.Online, srv.Username)
+		scannerState[name] = fmt.Sprintf("ip=%s online=%v user=%s source=%s", srv.IP, srv.Online, srv.Username, srv.Source)
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"bmh_url":        bmhURL,
-		"fetch_url":      fetchURL,
-		"bmh_status":     bmhStatus,
-		"bmh_error":      bmhErr,
+		"sources":        s.scanner.Sources(),
+		"bmh_probe":      bmhProbe,
 		"gateway_test":   "192.168.11.1:80",
 		"gateway_error":  gwErr,
 		"scanner_servers": scannerState,
 	})
 }
 
+// handleDiscoveryStatus reports the health of each discovery source: last
+// successful sync, last error, watch state, server count and name conflicts.
+func (s *Server) handleDiscoveryStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sources": s.scanner.Sources(),
+	})
+}
+
+// handleConnectQueue reports the connect pool: its limit, the sessions
+// connecting now and the servers queued for a slot, in order.
+func (s *Server) handleConnectQueue(w http.ResponseWriter, r *http.Request) {
+	q := s.solManager.GetConnectQueue()
+	queued := q.Queued[:0]
+	for _, name := range q.Queued {
+		if serverAllowed(r, name) {
+			queued = append(queued, name)
+		}
+	}
+	q.Queued = queued
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q)
+}
+
 func (s *Server) handleDebugLog(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	appLogPath := s.logWriter.BasePath() + "/ipmiserial.log"
+	appLogPath := s.logWriter.BasePath() + "/" + logs.DaemonLogName
 	data, err := os.ReadFile(appLogPath)
 	if err != nil {
 		fmt.Fprintf(w, "error reading log: %v\n", err)
@@ -770,6 +1199,11 @@ func (s *Server) handleRawDump(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
 	ch := s.solManager.Subscribe(name)
 	defer s.solManager.Unsubscribe(name, ch)
 
@@ -784,11 +1218,11 @@ func (s *Server) handleRawDump(w http.ResponseWriter, r *http.Request) {
 				fmt.Fprintf(w, "no data received in 5s\n")
 			}
 			return
-		case data, ok := <-ch:
+		case chunk, ok := <-ch:
 			if !ok {
 				return
 			}
-			fmt.Fprintf(w, "chunk %d (%d bytes): %x\n", i, len(data), data)
+			fmt.Fprintf(w, "chunk %d (%d bytes): %x\n", i, len(chunk.Data), chunk.Data)
 			i++
 		}
 	}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// startTime is when the process started, for /healthz uptime.
+var startTime = time.Now()
+
+// healthCheck is the outcome of one readiness check.
+type healthCheck struct {
+	Status string `json:"status"` // ok or fail
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthResponse is the /healthz and /readyz body.
+type healthResponse struct {
+	Status  string                 `json:"status"` // ok or fail
+	Version string                 `json:"version"`
+	Uptime  float64                `json:"uptime"` // seconds
+	Checks  map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// handleHealthz is the liveness probe: the process is up and serving HTTP.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, nil)
+}
+
+// handleReadyz is the readiness probe: the BMH API answered its last sync,
+// the data directory is writable and a discovery or SOL loop is running.
+// Any failing check answers 503.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]healthCheck{
+		"discovery":  result(s.checkDiscovery()),
+		"data_dir":   result(s.checkDataDir()),
+		"components": result(s.checkComponents()),
+	}
+	s.writeHealth(w, checks)
+}
+
+func (s *Server) writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	resp := healthResponse{
+		Status:  "ok",
+		Version: s.version,
+		Uptime:  time.Since(startTime).Seconds(),
+		Checks:  checks,
+	}
+	status := http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func result(detail string, err error) healthCheck {
+	if err != nil {
+		return healthCheck{Status: "fail", Detail: err.Error()}
+	}
+	return healthCheck{Status: "ok", Detail: detail}
+}
+
+// checkDiscovery passes when any discovery source's last sync succeeded,
+// or none is configured.
+func (s *Server) checkDiscovery() (string, error) {
+	sources := s.scanner.Sources()
+	if len(sources) == 0 {
+		return "no discovery sources", nil
+	}
+	var healthy []string
+	var failing []string
+	for _, src := range sources {
+		name := src.Name
+		if name == "" {
+			name = src.URL
+		}
+		if src.Healthy {
+			healthy = append(healthy, name)
+		} else if src.LastError != "" {
+			failing = append(failing, name+": "+src.LastError)
+		} else {
+			failing = append(failing, name+": not synced yet")
+		}
+	}
+	if len(healthy) == 0 {
+		return "", fmt.Errorf("%s", strings.Join(failing, "; "))
+	}
+	return fmt.Sprintf("%d of %d sources healthy", len(healthy), len(sources)), nil
+}
+
+// checkDataDir writes and removes a file in the log directory.
+func (s *Server) checkDataDir() (string, error) {
+	dir := s.logWriter.BasePath()
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return "", fmt.Errorf("%s is not writable: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return dir, nil
+}
+
+// checkComponents passes while the discovery loop or any SOL session is
+// running.
+func (s *Server) checkComponents() (string, error) {
+	sessions := len(s.solManager.GetSessions())
+	if !s.scanner.Running() && sessions == 0 {
+		return "", fmt.Errorf("no discovery loop or SOL sessions running")
+	}
+	return fmt.Sprintf("discovery running: %v, SOL sessions: %d", s.scanner.Running(), sessions), nil
+}
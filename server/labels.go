@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"ipmiserial/discovery"
+)
+
+// labelPrefix marks an entry of a token's, user's or API key's servers list
+// as a label selector rather than a server name, e.g. "label:rack=r12".
+const labelPrefix = "label:"
+
+// SetGroupLabel sets the label whose value groups servers in the server
+// list and the web UI; empty means "group".
+func (s *Server) SetGroupLabel(label string) {
+	if label == "" {
+		label = "group"
+	}
+	s.groupLabel.Store(label)
+}
+
+// serverGroup returns the group a server's labels put it in, "" for none.
+func (s *Server) serverGroup(labels map[string]string) string {
+	label, _ := s.groupLabel.Load().(string)
+	if label == "" {
+		label = "group"
+	}
+	return labels[label]
+}
+
+// checkServerEntries rejects malformed label selectors in a servers list.
+func checkServerEntries(servers []string) error {
+	for _, entry := range servers {
+		if selector, ok := strings.CutPrefix(entry, labelPrefix); ok {
+			if _, err := discovery.ParseSelector(selector); err != nil {
+				return fmt.Errorf("servers: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchServerEntry reports whether an entry of a principal's servers list
+// covers a server: the server's name, or a label selector its labels match.
+func matchServerEntry(entry, name string, labels func(string) map[string]string) bool {
+	selector, ok := strings.CutPrefix(entry, labelPrefix)
+	if !ok {
+		return entry == name
+	}
+	return labels != nil && discovery.MatchLabels(labels(name), selector)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/sol"
+)
+
+// sensorStatusValues maps sensor statuses to the ipmiserial_sensor_status
+// gauge. Unavailable sensors are left out of the metrics.
+var sensorStatusValues = map[string]int{
+	sol.SensorOK:       0,
+	sol.SensorWarning:  1,
+	sol.SensorCritical: 2,
+}
+
+func (s *Server) handleSensors(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	snap := s.solManager.GetSensors(name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sensorsResponse{name, snap})
+}
+
+// sensorsResponse is a server's latest sensor readings.
+type sensorsResponse struct {
+	Server string `json:"server"`
+	sol.SensorSnapshot
+}
+
+func (s *Server) handlePowerReading(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	reading := s.solManager.GetPowerReading(name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(powerReadingResponse{name, reading})
+}
+
+// powerReadingResponse is a server's latest DCMI power reading.
+type powerReadingResponse struct {
+	Server string `json:"server"`
+	sol.PowerReading
+}
+
+// handleMetrics serves the latest sensor and power readings in the
+// Prometheus text exposition format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	all := s.solManager.GetAllSensors()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		if serverAllowed(r, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# HELP ipmiserial_sensor_value Latest sensor reading in the sensor's unit.\n")
+	b.WriteString("# TYPE ipmiserial_sensor_value gauge\n")
+	for _, name := range names {
+		for _, sensor := range all[name].Sensors {
+			if sensor.Value == nil {
+				continue
+			}
+			fmt.Fprintf(&b, "ipmiserial_sensor_value{server=%s,sensor=%s,type=%s,unit=%s} %s\n",
+				promLabel(name), promLabel(sensor.Name), promLabel(sensor.Type), promLabel(sensor.Unit),
+				strconv.FormatFloat(*sensor.Value, 'g', -1, 64))
+		}
+	}
+
+	b.WriteString("# HELP ipmiserial_sensor_status Sensor health: 0 ok, 1 warning, 2 critical.\n")
+	b.WriteString("# TYPE ipmiserial_sensor_status gauge\n")
+	for _, name := range names {
+		for _, sensor := range all[name].Sensors {
+			if v, ok := sensorStatusValues[sensor.Status]; ok {
+				fmt.Fprintf(&b, "ipmiserial_sensor_status{server=%s,sensor=%s,type=%s} %d\n",
+					promLabel(name), promLabel(sensor.Name), promLabel(sensor.Type), v)
+			}
+		}
+	}
+
+	b.WriteString("# HELP ipmiserial_sensor_last_poll_timestamp_seconds Time of the last successful sensor poll.\n")
+	b.WriteString("# TYPE ipmiserial_sensor_last_poll_timestamp_seconds gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "ipmiserial_sensor_last_poll_timestamp_seconds{server=%s} %d\n",
+			promLabel(name), all[name].Updated.Unix())
+	}
+
+	power := s.solManager.GetAllPowerReadings()
+	powerNames := make([]string, 0, len(power))
+	for name := range power {
+		if serverAllowed(r, name) {
+			powerNames = append(powerNames, name)
+		}
+	}
+	sort.Strings(powerNames)
+
+	b.WriteString("# HELP ipmiserial_power_watts Instantaneous system power draw (DCMI).\n")
+	b.WriteString("# TYPE ipmiserial_power_watts gauge\n")
+	for _, name := range powerNames {
+		fmt.Fprintf(&b, "ipmiserial_power_watts{server=%s} %d\n", promLabel(name), power[name].Watts)
+	}
+	b.WriteString("# HELP ipmiserial_power_average_watts Average system power draw over the BMC's statistics period (DCMI).\n")
+	b.WriteString("# TYPE ipmiserial_power_average_watts gauge\n")
+	for _, name := range powerNames {
+		fmt.Fprintf(&b, "ipmiserial_power_average_watts{server=%s} %d\n", promLabel(name), power[name].Average)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// promLabel quotes a label value for the Prometheus text format.
+func promLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
@@ -0,0 +1,554 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/alerts"
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/events"
+	"ipmiserial/logs"
+	"ipmiserial/playbooks"
+	"ipmiserial/sol"
+)
+
+// The OpenAPI document is generated from the router, so every route is
+// listed, with summaries, parameters and bodies from apiOperations. Body
+// schemas are reflected from the Go types the handlers encode.
+
+// apiOperation documents one route, keyed "METHOD /path/{template}".
+type apiOperation struct {
+	Summary  string
+	Tag      string
+	Query    []apiParam
+	Body     interface{} // request body: a Go value, apiObject or apiText
+	Status   int         // success status (default 200)
+	Response interface{} // success body: a Go value, apiObject or apiText; nil = none
+}
+
+// apiParam is a query parameter; Type is string, integer or boolean.
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// apiObject is a JSON object whose fields are a type name (string,
+// integer, boolean, date-time) or a Go value to reflect.
+type apiObject map[string]interface{}
+
+// apiText is a non-JSON body of this media type.
+type apiText string
+
+type jsonSchema map[string]interface{}
+
+var (
+	searchParams = []apiParam{
+		{"q", "string", "Text or pattern to find (required)"},
+		{"regex", "boolean", "Treat q as a regular expression"},
+		{"ignoreCase", "boolean", "Case-insensitive match"},
+		{"context", "integer", "Lines of context around each match"},
+		{"limit", "integer", "Maximum matches"},
+	}
+	streamParams = []apiParam{
+		{"channels", "string", "Comma-separated: raw, dedup, analytics, state"},
+		{"catchup", "string", "Initial screen: auto, screen, log or none"},
+		{"catchup_size", "integer", "Bytes of log tail for log catchup"},
+		{"cursor", "string", "Resume after this stream position"},
+		{"compress", "boolean", "false disables gzip"},
+	}
+	inputHoldBody     = apiObject{"force": "boolean"}
+	inputHoldResponse = apiObject{"server": "string", "holder": "string", "inputOwned": "boolean"}
+	statusOK          = apiObject{"status": "string"}
+)
+
+var apiOperations = map[string]apiOperation{
+	"GET /api/versions": {Summary: "API versions and which to use", Tag: "Utilities", Response: apiObject{"current": "string", "versions": []apiVersion{}}},
+	"GET /api/version":  {Summary: "Server version", Tag: "Utilities", Response: apiObject{"version": "string"}},
+	"GET /api/servers": {Summary: "List servers with connection status; X-Total-Count has the number matching", Tag: "Servers",
+		Query: []apiParam{
+			{"prefix", "string", "Only names starting with this"},
+			{"online", "boolean", "Only servers whose BMC is (or isn't) reachable"},
+			{"connected", "boolean", "Only servers with (or without) a live SOL session"},
+			{"state", "string", "Only servers whose session is in this state: discovering, queued, connecting, handshaking, active, degraded, backoff, standby or stopped"},
+			{"label", "string", "Label selector, e.g. rack=r12,env!=prod"},
+			{"group", "string", "Only servers in this group (server.group_label)"},
+			{"sort", "string", "name (default), ip, state or group"},
+			{"order", "string", "asc (default) or desc"},
+			{"page", "integer", "Page number, from 1"},
+			{"limit", "integer", "Servers per page (default all, at most 1000)"},
+		},
+		Response: []ServerInfo{}},
+	"GET /api/servers/{name}/stream": {Summary: "Live console output (Server-Sent Events)", Tag: "Servers",
+		Query: streamParams, Response: apiText("text/event-stream")},
+	"GET /api/servers/{name}/attach": {Summary: "Interactive console (WebSocket): binary messages carry output and keystrokes, text messages JSON events and commands", Tag: "Servers",
+		Query:  []apiParam{{"force", "boolean", "Take input over from another client"}, {"watch", "boolean", "View only, without taking input"}, {"compress", "boolean", "false disables permessage-deflate"}},
+		Status: http.StatusSwitchingProtocols},
+	"GET /api/servers/{name}/events": {Summary: "Analytics and state events for a server (Server-Sent Events)", Tag: "Event Streams",
+		Query: []apiParam{{"channels", "string", "Comma-separated: analytics, state"}}, Response: apiText("text/event-stream")},
+	"GET /api/events": {Summary: "Analytics and state events for all servers (Server-Sent Events)", Tag: "Event Streams",
+		Query:    []apiParam{{"channels", "string", "Comma-separated: analytics, state"}, {"servers", "string", "Comma-separated server names"}},
+		Response: apiText("text/event-stream")},
+	"GET /api/servers/{name}/status": {Summary: "Detailed status, including SOL packet counters", Tag: "Servers", Response: ServerInfo{}},
+	"GET /api/servers/{name}/stats":  {Summary: "SOL session traffic: bytes, packets, retransmits, NACKs, ACK latency and throughput", Tag: "Servers", Response: sessionStats{}},
+	"GET /api/servers/{name}/sol/diag": {Summary: "SOL payload activation status, channel payload support and SOL configuration read from the BMC, with likely causes of activation failures", Tag: "Hardware",
+		Response: sol.SOLDiag{}},
+	"GET /api/servers/{name}/screen": {Summary: "What is on the console right now", Tag: "Hardware",
+		Query:    []apiParam{{"format", "string", "text (default) or html"}, {"cols", "integer", "Screen width"}, {"rows", "integer", "Screen height"}},
+		Response: apiText("text/plain")},
+	"GET /api/servers/{name}/scrollback": {Summary: "Page through recent raw console output by offset", Tag: "Hardware",
+		Query: []apiParam{{"before", "integer", "Return the output just before this offset"}, {"from", "integer", "Return the output from this offset"},
+			{"limit", "integer", "Page size in bytes (default 65536, at most 1048576)"}, {"format", "string", "json (default) or raw"}},
+		Response: sol.Scrollback{}},
+	"POST /api/servers/{name}/command": {Summary: "Type a command and Enter on the console", Tag: "Servers",
+		Body: apiObject{"command": "string"}, Response: statusOK},
+	"POST /api/servers/{name}/input": {Summary: "Send console input", Tag: "Servers",
+		Body: apiText("text/plain"), Status: http.StatusNoContent},
+	"POST /api/servers/{name}/input/acquire": {Summary: "Take exclusive keyboard input", Tag: "Servers",
+		Body: inputHoldBody, Response: inputHoldResponse},
+	"POST /api/servers/{name}/input/release": {Summary: "Give up exclusive keyboard input", Tag: "Servers",
+		Body: inputHoldBody, Response: inputHoldResponse},
+	"GET /api/servers/{name}/viewers": {Summary: "Who is watching or attached to the console, and who holds its input", Tag: "Servers",
+		Response: viewersResponse{}},
+	"POST /api/servers/{name}/break": {Summary: "Send a serial break, optionally followed by a SysRq key", Tag: "Servers",
+		Body: apiObject{"sysrq": "string"}, Status: http.StatusNoContent},
+	"POST /api/servers/{name}/reconnect": {Summary: "Retry a session in backoff or on standby at once, or restart a live one", Tag: "Servers",
+		Response: apiObject{"status": "string", "message": "string"}},
+	"GET /api/servers/{name}/history": {Summary: "SOL connect attempts, newest first", Tag: "Servers",
+		Query: []apiParam{{"limit", "integer", "Return at most this many attempts"}}, Response: []sol.ConnectAttempt{}},
+	"POST /api/refresh": {Summary: "Trigger an immediate discovery refresh", Tag: "Servers", Response: statusOK},
+	"POST /api/bulk": {Summary: "Run rotate, clear, restart-session or a power action on many servers at once", Tag: "Servers",
+		Body: bulkRequest{}, Response: bulkResponse{}},
+	"GET /api/discovery/status": {Summary: "Per-source discovery health", Tag: "Servers",
+		Response: apiObject{"sources": []discovery.SourceStatus{}}},
+	"GET /api/sessions/queue": {Summary: "Connect pool limit, connecting sessions and queued servers", Tag: "Servers",
+		Response: sol.ConnectQueue{}},
+	"GET /api/streams": {Summary: "Open console and event streams, their clients and drops, and the stream limits", Tag: "Servers",
+		Response: StreamsReport{}},
+
+	"GET /api/servers/{name}/logs": {Summary: "List log files", Tag: "Logs", Response: []string{}},
+	"GET /api/servers/{name}/boots/{index}/log": {Summary: "One boot's console output from the log, by bootHistory index or current", Tag: "Logs",
+		Response: apiText("text/plain")},
+	"GET /api/servers/{name}/logs/search": {Summary: "Search a server's logs", Tag: "Logs",
+		Query: searchParams, Response: logs.SearchResult{}},
+	"GET /api/servers/{name}/logs/query": {Summary: "Page through log lines oldest first", Tag: "Logs",
+		Query: []apiParam{
+			{"since", "string", "RFC 3339 time or duration ago"},
+			{"until", "string", "RFC 3339 time or duration ago"},
+			{"grep", "string", "Only lines containing this"},
+			{"regex", "boolean", "Treat grep as a regular expression"},
+			{"ignoreCase", "boolean", "Case-insensitive grep"},
+			{"limit", "integer", "Maximum records"},
+			{"cursor", "string", "nextCursor of the previous page"},
+		},
+		Response: logs.LogQueryResult{}},
+	"GET /api/servers/{name}/logs/{filename}": {Summary: "Log file content", Tag: "Logs",
+		Query: []apiParam{{"raw", "boolean", "The uncleaned SOL capture"}}, Response: apiText("text/plain")},
+	"GET /api/servers/{name}/logs/{filename}/info": {Summary: "Log file metadata", Tag: "Logs",
+		Response: apiObject{"filename": "string", "size": "integer", "modified": "date-time", "compressed": "boolean"}},
+	"POST /api/servers/{name}/logs/clear": {Summary: "Clear a server's logs", Tag: "Logs", Response: statusOK},
+	"POST /api/servers/{name}/logs/rotate": {Summary: "Start a new log file", Tag: "Logs",
+		Query:    []apiParam{{"name", "string", "Name for the new file"}},
+		Response: apiObject{"status": "string", "message": "string", "file": "string"}},
+	"POST /api/logs/clear": {Summary: "Clear logs for all servers", Tag: "Logs", Response: statusOK},
+	"GET /api/logs/search": {Summary: "Search logs across servers", Tag: "Logs",
+		Query:    append(searchParams[:len(searchParams):len(searchParams)], apiParam{"servers", "string", "Comma-separated server names"}),
+		Response: logs.SearchResult{}},
+	"GET /api/logs/sinks": {Summary: "Log sink queue and delivery counts", Tag: "Logs", Response: []logs.SinkStatus{}},
+	"GET /api/logs/usage": {Summary: "Log disk usage and quota", Tag: "Logs", Response: logs.Usage{}},
+
+	"GET /api/servers/{name}/analytics": {Summary: "Boot analytics for a server", Tag: "Analytics", Response: sol.ServerAnalytics{}},
+	"GET /api/analytics":                {Summary: "Boot analytics for all servers", Tag: "Analytics", Response: map[string]*sol.ServerAnalytics{}},
+	"GET /api/analytics/poweron":        {Summary: "Power-on delay percentiles", Tag: "Analytics", Response: sol.PowerOnReport{}},
+	"GET /api/analytics/pipeline":       {Summary: "Console actor queue statistics", Tag: "Analytics", Response: sol.PipelineStats{}},
+	"GET /api/analytics/summary": {Summary: "Fleet boot health", Tag: "Analytics",
+		Query: []apiParam{{"window", "string", "Go duration (default 168h)"}}, Response: sol.FleetSummary{}},
+	"GET /api/servers/{name}/software":  {Summary: "Software facts seen on the console", Tag: "Analytics", Response: sol.SoftwareFacts{}},
+	"GET /api/servers/{name}/inventory": {Summary: "BIOS, boot firmware, NIC and GPU inventory seen on the console", Tag: "Analytics", Response: sol.Inventory{}},
+	"GET /api/software": {Summary: "Fleet-wide software facts", Tag: "Analytics",
+		Query: []apiParam{{"format", "string", "csv for a flat export"}}, Response: map[string]*sol.SoftwareFacts{}},
+
+	"GET /api/servers/{name}/sel":     {Summary: "BMC System Event Log, newest first", Tag: "Hardware", Response: []sol.SELEntry{}},
+	"GET /api/servers/{name}/sensors": {Summary: "Latest sensor readings", Tag: "Hardware", Response: sensorsResponse{}},
+	"GET /api/servers/{name}/timeline": {Summary: "SEL entries interleaved with console events and alerts", Tag: "Hardware",
+		Query: []apiParam{
+			{"from", "string", "RFC 3339 (default 24h before to)"},
+			{"to", "string", "RFC 3339 (default now)"},
+			{"window", "string", "Go duration for SEL correlation (default 1m)"},
+		},
+		Response: timelineResponse{}},
+	"GET /api/servers/{name}/power":         {Summary: "Chassis power state", Tag: "Hardware", Response: apiObject{"poweredOn": "boolean"}},
+	"GET /api/servers/{name}/power/reading": {Summary: "DCMI power reading with recent samples tagged by boot stage", Tag: "Hardware", Response: powerReadingResponse{}},
+	"POST /api/servers/{name}/power": {Summary: "Power control", Tag: "Hardware",
+		Body: powerRequest{}, Response: apiObject{"status": "string", "action": "string"}},
+	"POST /api/servers/{name}/bootdev": {Summary: "Boot device override", Tag: "Hardware",
+		Body: bootDevRequest{}, Response: apiObject{"status": "string", "device": "string", "persistent": "boolean"}},
+	"GET /metrics": {Summary: "Prometheus metrics", Tag: "Hardware", Response: apiText("text/plain")},
+	"GET /healthz": {Summary: "Liveness probe", Tag: "Utilities", Response: healthResponse{}},
+	"GET /readyz": {Summary: "Readiness probe: discovery, data directory and component checks (503 when any fails)", Tag: "Utilities",
+		Response: healthResponse{}},
+
+	"GET /api/playbooks": {Summary: "Configured playbooks", Tag: "Playbooks", Response: []config.Playbook{}},
+	"POST /api/servers/{name}/playbooks/{playbook}/run": {Summary: "Start a playbook against a server", Tag: "Playbooks",
+		Status: http.StatusAccepted, Response: playbooks.Run{}},
+	"POST /api/servers/{name}/reprovision": {Summary: "Set the boot device, rotate to a named log, reboot and wait for the OS; poll the run for progress", Tag: "Playbooks",
+		Body: reprovisionRequest{}, Status: http.StatusAccepted, Response: playbooks.Run{}},
+	"GET /api/playbooks/runs": {Summary: "Recent playbook runs, newest first", Tag: "Playbooks",
+		Query: []apiParam{{"server", "string", "Only runs against this server"}}, Response: []*playbooks.Run{}},
+	"GET /api/playbooks/runs/{id}":         {Summary: "Status of a run", Tag: "Playbooks", Response: playbooks.Run{}},
+	"POST /api/playbooks/runs/{id}/cancel": {Summary: "Cancel a running playbook", Tag: "Playbooks", Response: statusOK},
+
+	"GET /api/alerts": {Summary: "Fired alerts, newest first", Tag: "Alerts",
+		Query:    []apiParam{{"server", "string", "Only alerts for this server"}, {"limit", "integer", "Maximum alerts"}},
+		Response: []*alerts.Alert{}},
+	"POST /api/admin/alerts/test": {Summary: "Send a test alert through a notifier", Tag: "Alerts",
+		Body: apiObject{"notifier": "string"}, Status: http.StatusNoContent},
+	"GET /api/admin/webhooks": {Summary: "Configured webhooks and their delivery counters", Tag: "Alerts",
+		Response: []events.WebhookStatus{}},
+	"POST /api/admin/webhooks/test": {Summary: "Send a signed test event to a webhook", Tag: "Alerts",
+		Body: apiObject{"webhook": "string"}, Status: http.StatusNoContent},
+
+	"GET /api/lookup/mac/{mac}": {Summary: "Find the server with a MAC address", Tag: "Utilities",
+		Response: apiObject{"mac": "string", "server": "string"}},
+	"POST /api/admin/migrate": {Summary: "Move the log directory live", Tag: "Utilities",
+		Body: migrateRequest{}, Status: http.StatusAccepted, Response: logs.MigrationStatus{}},
+	"GET /api/admin/migrate":        {Summary: "Progress of the current or last migration", Tag: "Utilities", Response: logs.MigrationStatus{}},
+	"GET /api/debug/bmh":            {Summary: "Discovery internals", Tag: "Utilities", Response: map[string]interface{}{}},
+	"GET /api/debug/rawdump/{name}": {Summary: "Recent raw SOL bytes", Tag: "Utilities", Response: apiText("text/plain")},
+	"GET /api/debug/log":            {Summary: "Recent daemon log lines", Tag: "Utilities", Response: apiText("text/plain")},
+	"GET /api/openapi.json":         {Summary: "This document", Tag: "Utilities", Response: apiText("application/json")},
+	"GET /api/docs":                 {Summary: "Swagger UI for this document", Tag: "Utilities", Response: apiText("text/html")},
+
+	"GET /api/admin/keys": {Summary: "List API keys", Tag: "Authentication", Response: []*APIKey{}},
+	"POST /api/admin/keys": {Summary: "Create an API key; the token is returned once", Tag: "Authentication",
+		Body: createKeyRequest{}, Status: http.StatusCreated, Response: createKeyResponse{}},
+	"DELETE /api/admin/keys/{id}": {Summary: "Revoke an API key", Tag: "Authentication", Status: http.StatusNoContent},
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// openAPI builds the OpenAPI 3.0 document for the routes on s.router.
+func (s *Server) openAPI() map[string]interface{} {
+	g := newSchemaGen()
+	paths := make(map[string]map[string]interface{})
+	s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		// Versioned API routes and documented routes outside /api
+		// (/metrics, probes); the unversioned /api aliases aren't listed
+		listed := strings.HasPrefix(tpl, apiV1+"/") || tpl == "/api/versions" ||
+			(!strings.HasPrefix(tpl, "/api") && apiOperations["GET "+tpl].Summary != "")
+		if !listed {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := pathParam.ReplaceAllString(tpl, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		for _, method := range methods {
+			paths[path][strings.ToLower(method)] = g.operation(method, tpl, route)
+		}
+		return nil
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "ipmiserial",
+			"version":     s.version,
+			"description": "IPMI Serial-over-LAN console server. The routes below are also served without /v1 as deprecated aliases. Errors are RFC 7807 problem documents. Credentials are only required once auth is configured.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth":   map[string]string{"type": "http", "scheme": "bearer"},
+				"basicAuth":    map[string]string{"type": "http", "scheme": "basic"},
+				"apiKeyHeader": map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"apiKeyQuery":  map[string]string{"type": "apiKey", "in": "query", "name": "api_key"},
+				"cookieAuth":   map[string]string{"type": "apiKey", "in": "cookie", "name": tokenCookie},
+			},
+		},
+		"security": []map[string][]string{
+			{"bearerAuth": {}}, {"basicAuth": {}}, {"apiKeyHeader": {}}, {"apiKeyQuery": {}}, {"cookieAuth": {}},
+		},
+	}
+}
+
+// operation documents one method of a route.
+func (g *schemaGen) operation(method, tpl string, route *mux.Route) map[string]interface{} {
+	doc := apiOperations[method+" "+canonicalRoute(tpl)]
+	op := map[string]interface{}{}
+	if doc.Summary != "" {
+		op["summary"] = doc.Summary
+	}
+	if doc.Tag != "" {
+		op["tags"] = []string{doc.Tag}
+	}
+	if id := handlerName(route.GetHandler()); id != "" {
+		op["operationId"] = id
+	}
+
+	var params []map[string]interface{}
+	for _, m := range pathParam.FindAllStringSubmatch(tpl, -1) {
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true, "schema": jsonSchema{"type": "string"},
+		})
+	}
+	for _, p := range doc.Query {
+		params = append(params, map[string]interface{}{
+			"name": p.Name, "in": "query", "description": p.Description, "schema": jsonSchema{"type": p.Type},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if doc.Body != nil {
+		op["requestBody"] = map[string]interface{}{"content": g.content(doc.Body)}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if doc.Response != nil {
+		success["content"] = g.content(doc.Response)
+	}
+	op["responses"] = map[string]interface{}{
+		fmt.Sprint(status): success,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				problemContentType: map[string]interface{}{"schema": g.schema(reflect.TypeOf(Problem{}))},
+			},
+		},
+	}
+	return op
+}
+
+// handlerName derives an operation ID from a handler method's name, e.g.
+// handleListServers -> listServers, handleAPIDocs -> apiDocs.
+func handlerName(h http.Handler) string {
+	if h == nil {
+		return ""
+	}
+	fn := runtime.FuncForPC(reflect.ValueOf(h).Pointer())
+	if fn == nil {
+		return ""
+	}
+	name := fn.Name()
+	name = name[strings.LastIndexByte(name, '.')+1:]
+	name = strings.TrimSuffix(name, "-fm")
+	name = strings.TrimPrefix(name, "handle")
+	if name == "" {
+		return ""
+	}
+	r := []rune(name)
+	for i := range r {
+		if !unicode.IsUpper(r[i]) || (i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1])) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// content is the media type map for a body.
+func (g *schemaGen) content(body interface{}) map[string]interface{} {
+	switch b := body.(type) {
+	case apiText:
+		return map[string]interface{}{string(b): map[string]interface{}{"schema": jsonSchema{"type": "string"}}}
+	case apiObject:
+		props := make(map[string]interface{}, len(b))
+		for name, v := range b {
+			if t, ok := v.(string); ok {
+				props[name] = namedType(t)
+			} else {
+				props[name] = g.schema(reflect.TypeOf(v))
+			}
+		}
+		return map[string]interface{}{"application/json": map[string]interface{}{
+			"schema": jsonSchema{"type": "object", "properties": props},
+		}}
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(body))}}
+}
+
+func namedType(t string) jsonSchema {
+	if t == "date-time" {
+		return jsonSchema{"type": "string", "format": "date-time"}
+	}
+	return jsonSchema{"type": t}
+}
+
+// schemaGen reflects Go types into JSON schemas, collecting named structs
+// as components.
+type schemaGen struct {
+	components map[string]interface{}
+	types      map[string]reflect.Type
+}
+
+func newSchemaGen() *schemaGen {
+	return &schemaGen{components: make(map[string]interface{}), types: make(map[string]reflect.Type)}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	rawType      = reflect.TypeOf(json.RawMessage{})
+)
+
+// schema returns the schema for values of type t as encoding/json writes
+// them.
+func (g *schemaGen) schema(t reflect.Type) jsonSchema {
+	switch t {
+	case timeType:
+		return jsonSchema{"type": "string", "format": "date-time"}
+	case durationType:
+		return jsonSchema{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case rawType:
+		return jsonSchema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonSchema{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return jsonSchema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": "string", "format": "byte"}
+		}
+		return jsonSchema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := g.componentName(t)
+		if _, done := g.components[name]; !done {
+			g.components[name] = jsonSchema{} // placeholder for recursive types
+			g.components[name] = g.object(t)
+		}
+		return jsonSchema{"$ref": "#/components/schemas/" + name}
+	}
+	return jsonSchema{}
+}
+
+// componentName names a struct's schema after the type, qualified by its
+// package if another package's type took the name first.
+func (g *schemaGen) componentName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	name := string(r)
+	if prev, taken := g.types[name]; taken && prev != t {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndexByte(pkg, '/')+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.types[name] = t
+	return name
+}
+
+// object returns a struct's object schema. Fields without omitempty are
+// required; embedded structs contribute their fields.
+func (g *schemaGen) object(t reflect.Type) jsonSchema {
+	props := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded := g.object(ft)
+			for k, v := range embedded["properties"].(map[string]interface{}) {
+				props[k] = v
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(","+opts+",", ",string,") {
+			props[name] = jsonSchema{"type": "string"}
+		} else {
+			props[name] = g.schema(f.Type)
+		}
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			required = append(required, name)
+		}
+	}
+	s := jsonSchema{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.openAPI())
+}
+
+// swaggerUI loads Swagger UI from the CDN, like the web UI's own assets.
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>ipmiserial API</title>
+    <link href="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui.css" rel="stylesheet">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+        SwaggerUIBundle({url: 'openapi.json', dom_id: '#swagger-ui'});
+    </script>
+</body>
+</html>
+`
+
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUI))
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/playbooks"
+)
+
+type reprovisionRequest struct {
+	Device     string `json:"device"`     // boot device, default pxe
+	Persistent bool   `json:"persistent"` // keep it beyond the next boot
+	Power      string `json:"power"`      // on, cycle or reset, default cycle
+	LogName    string `json:"logName"`    // log the new boot is written to, default reprovision-<time>
+	Timeout    string `json:"timeout"`    // Go duration to wait for the OS, default 30m
+}
+
+func (s *Server) handleListPlaybooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.playbooks.Playbooks())
+}
+
+func (s *Server) handleRunPlaybook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if _, exists := s.scanner.GetServers()[name]; !exists {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	run, err := s.playbooks.Start(vars["playbook"], name, "manual")
+	if err != nil {
+		writeProblem(w, r, http.StatusConflict, CodePlaybookConflict, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// handleReprovision starts the reprovision workflow: boot device override,
+// log rotation to a named log, power action, then waiting for boot
+// analytics to see the OS up. Progress is polled as a playbook run.
+func (s *Server) handleReprovision(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if _, exists := s.scanner.GetServers()[name]; !exists {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	var req reprovisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	opts := playbooks.Reprovision{Device: req.Device, Persistent: req.Persistent, Power: req.Power, LogName: req.LogName}
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid timeout")
+			return
+		}
+		opts.Timeout = d
+	}
+	if _, err := playbooks.ReprovisionSteps(opts); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	run, err := s.playbooks.Reprovision(name, opts)
+	if err != nil {
+		writeProblem(w, r, http.StatusConflict, CodePlaybookConflict, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/playbooks/runs/"+run.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+func (s *Server) handleListPlaybookRuns(w http.ResponseWriter, r *http.Request) {
+	runs := s.playbooks.Runs(r.URL.Query().Get("server"))
+	visible := runs[:0]
+	for _, run := range runs {
+		if serverAllowed(r, run.Server) {
+			visible = append(visible, run)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+func (s *Server) handleGetPlaybookRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	run := s.playbooks.GetRun(vars["id"])
+	if run == nil || !serverAllowed(r, run.Server) {
+		writeProblem(w, r, http.StatusNotFound, CodeRunNotFound, "Run not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+func (s *Server) handleCancelPlaybookRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if run := s.playbooks.GetRun(vars["id"]); run == nil || !serverAllowed(r, run.Server) {
+		writeProblem(w, r, http.StatusNotFound, CodeRunNotFound, "Run not found")
+		return
+	}
+	if err := s.playbooks.Cancel(vars["id"]); err != nil {
+		writeProblem(w, r, http.StatusConflict, CodePlaybookConflict, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "cancelling"})
+}
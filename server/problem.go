@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Machine-readable error codes carried in problem responses. Clients should
+// switch on these rather than on detail text, which may change.
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeInvalidJSON       = "invalid_json"
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeServerNotFound    = "server_not_found"
+	CodeLogNotFound       = "log_not_found"
+	CodeBootNotFound      = "boot_not_found"
+	CodeRawNotFound       = "raw_capture_not_found"
+	CodeRunNotFound       = "run_not_found"
+	CodeKeyNotFound       = "key_not_found"
+	CodeMACNotFound       = "mac_not_found"
+	CodeNoMigration       = "migration_not_found"
+	CodeMigrationConflict = "migration_conflict"
+	CodePlaybookConflict  = "playbook_conflict"
+	CodeNotifierNotFound  = "notifier_not_found"
+	CodeNotifyFailed      = "notify_failed"
+	CodeBMCError          = "bmc_error"
+	CodeWebhookNotFound   = "webhook_not_found"
+	CodeNotConnected      = "not_connected"
+	CodeInputRejected     = "input_rejected"
+	CodeInputHeld         = "input_held"
+	CodeRotationCooldown  = "rotation_cooldown"
+	CodeRotationPaused    = "rotation_paused"
+	CodeAuthRequired      = "auth_required"
+	CodeInvalidToken      = "invalid_token"
+	CodeInsufficientScope = "insufficient_scope"
+	CodeServerForbidden   = "server_forbidden"
+	CodeAuthDisabled      = "auth_disabled"
+	CodeReadOnly          = "read_only"
+	CodeTooManyStreams    = "too_many_streams"
+	CodeInternal          = "internal_error"
+)
+
+// problemContentType is the RFC 7807 media type for error responses.
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 error response. Code is an extension member
+// holding one of the Code* constants; Type is derived from it.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// writeProblem sends a problem+json error in place of http.Error.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	p := Problem{
+		Type:   "urn:ipmiserial:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+	if r != nil {
+		p.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// internalError reports an unexpected failure.
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, http.StatusInternalServerError, CodeInternal, err.Error())
+}
+
+// apiNotFound answers unmatched /api requests. gorilla/mux reports a method
+// mismatch inside a subrouter as not found, so the path is re-tried with the
+// other methods to tell 405 from 404.
+func apiNotFound(api *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			if method == r.Method {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if api.Match(probe, &match) && match.MatchErr == nil {
+				writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not supported here")
+				return
+			}
+		}
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "No such endpoint")
+	})
+}
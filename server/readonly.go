@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetReadOnly turns read-only mode on or off. A read-only server refuses
+// every mutating API call (clearing and rotating logs, power, boot device,
+// console input, playbooks, admin changes) with 403, so a dashboard can
+// share another instance's data directory safely. Tokens and users may
+// override it either way with read_only.
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.readOnly.Store(readOnly)
+}
+
+// viewOnlyFor reports whether a console viewer authenticated as p can't
+// type: it lacks the control scope, or may not change anything.
+func (s *Server) viewOnlyFor(p *Principal) bool {
+	return (p != nil && !p.Allows(ScopeControl)) || s.readOnlyFor(p)
+}
+
+// readOnlyFor reports whether requests by p may not change anything.
+func (s *Server) readOnlyFor(p *Principal) bool {
+	if p != nil && p.ReadOnly != nil {
+		return *p.ReadOnly
+	}
+	return s.readOnly.Load()
+}
+
+// readOnlyMiddleware refuses mutating /api and /htmx requests in read-only
+// mode. It runs after authMiddleware so per-principal overrides apply.
+func (s *Server) readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authRequired(r) && !isSafeMethod(r.Method) && s.readOnlyFor(requestPrincipal(r)) {
+			log.Debugf("Read-only: refused %s %s", r.Method, r.URL.Path)
+			writeProblem(w, r, http.StatusForbidden, CodeReadOnly, "Server is read-only")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
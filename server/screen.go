@@ -0,0 +1,252 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/vt"
+)
+
+// Screen snapshot size: an 80x25 console unless the request asks otherwise.
+const (
+	screenCols    = 80
+	screenRows    = 25
+	screenMaxSize = 500
+)
+
+// Scrollback page sizes, in bytes.
+const (
+	scrollbackPage    = 64 * 1024
+	scrollbackMaxPage = 1 << 20
+)
+
+// handleScreen renders what is on a server's console right now by replaying
+// its screen buffer through a terminal emulator: plain text by default, or
+// an HTML <pre> fragment with colors for ?format=html. ?cols= and ?rows=
+// set the emulated screen size.
+func (s *Server) handleScreen(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, ok := s.scanner.GetServers()[name]; !ok {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	q := r.URL.Query()
+	cols, ok := screenSize(q.Get("cols"), screenCols)
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("cols must be between 1 and %d", screenMaxSize))
+		return
+	}
+	rows, ok := screenSize(q.Get("rows"), screenRows)
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("rows must be between 1 and %d", screenMaxSize))
+		return
+	}
+
+	term := vt.New(cols, rows)
+	term.Write(s.solManager.GetScreenBuffer(name))
+
+	switch format := q.Get("format"); format {
+	case "", "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(term.String()))
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(screenHTML(term)))
+	default:
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "format must be text or html")
+	}
+}
+
+// handleScrollback pages through a server's held raw console output by
+// offset, the positions stream ids and attach catchups report: ?before=
+// returns the output just before an offset, for a viewer scrolling back
+// past what its terminal kept, ?from= the output from one, and neither the
+// newest. ?limit= caps the page in bytes. The page comes as JSON with the
+// data in base64, or with ?format=raw as the bytes themselves with the
+// offsets in X-Scrollback-* headers.
+func (s *Server) handleScrollback(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+
+	q := r.URL.Query()
+	from, ok := scrollbackOffset(q.Get("from"))
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "from must be a non-negative offset")
+		return
+	}
+	before, ok := scrollbackOffset(q.Get("before"))
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "before must be a non-negative offset")
+		return
+	}
+	if from >= 0 && before >= 0 {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "from and before are mutually exclusive")
+		return
+	}
+	limit := scrollbackPage
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > scrollbackMaxPage {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", scrollbackMaxPage))
+			return
+		}
+		limit = n
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "raw" {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "format must be json or raw")
+		return
+	}
+
+	page, _ := s.solManager.GetScrollback(name, from, before, limit)
+	if page.Data == nil {
+		page.Data = []byte{}
+	}
+	if format == "raw" {
+		h := w.Header()
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("X-Scrollback-Start", strconv.FormatInt(page.Start, 10))
+		h.Set("X-Scrollback-End", strconv.FormatInt(page.End, 10))
+		h.Set("X-Scrollback-From", strconv.FormatInt(page.From, 10))
+		h.Set("X-Scrollback-To", strconv.FormatInt(page.To, 10))
+		w.Write(page.Data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// scrollbackOffset parses a from/before parameter, -1 if empty.
+func scrollbackOffset(v string) (int64, bool) {
+	if v == "" {
+		return -1, true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// screenSize parses a cols/rows parameter, def if empty.
+func screenSize(v string, def int) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > screenMaxSize {
+		return 0, false
+	}
+	return n, true
+}
+
+// screenHTML renders the whole screen as a <pre> fragment with inline styles,
+// one <span> per run of cells sharing an attribute.
+func screenHTML(term *vt.Terminal) string {
+	cols, rows := term.Size()
+	var b strings.Builder
+	b.WriteString(`<pre class="ipmiserial-screen" style="background:#000;color:#aaa;font-family:monospace;margin:0;padding:4px">`)
+	for y := 0; y < rows; y++ {
+		// Trailing blanks are dropped unless colored, e.g. a setup page's
+		// background
+		width := cols
+		for width > 0 && term.Cell(width-1, y) == (vt.Cell{Ch: ' '}) {
+			width--
+		}
+		for x := 0; x < width; {
+			attr := term.Cell(x, y).Attr
+			var run strings.Builder
+			for ; x < width && term.Cell(x, y).Attr == attr; x++ {
+				run.WriteRune(term.Cell(x, y).Ch)
+			}
+			if style := attrStyle(attr); style != "" {
+				fmt.Fprintf(&b, `<span style="%s">%s</span>`, style, html.EscapeString(run.String()))
+			} else {
+				b.WriteString(html.EscapeString(run.String()))
+			}
+		}
+		if y < rows-1 {
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteString("</pre>")
+	return b.String()
+}
+
+// attrStyle returns the CSS for a cell attribute, empty for the default.
+func attrStyle(a vt.Attr) string {
+	fg, bg := colorCSS(a.FG, a.Bold), colorCSS(a.BG, false)
+	if a.Reverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = "#000"
+		}
+		if bg == "" {
+			bg = "#aaa"
+		}
+	}
+	var css []string
+	if fg != "" {
+		css = append(css, "color:"+fg)
+	}
+	if bg != "" {
+		css = append(css, "background:"+bg)
+	}
+	if a.Bold {
+		css = append(css, "font-weight:bold")
+	}
+	if a.Underline {
+		css = append(css, "text-decoration:underline")
+	}
+	return strings.Join(css, ";")
+}
+
+// vgaPalette is the 16-color palette consoles are drawn for (VGA text mode).
+var vgaPalette = [16]string{
+	"#000", "#a00", "#0a0", "#a50", "#00a", "#a0a", "#0aa", "#aaa",
+	"#555", "#f55", "#5f5", "#ff5", "#55f", "#f5f", "#5ff", "#fff",
+}
+
+// colorCSS returns the CSS color for c, empty for the default. Bold brightens
+// the eight normal colors, as on a VGA console.
+func colorCSS(c vt.Color, bold bool) string {
+	if r, g, b, ok := c.RGB(); ok {
+		return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	}
+	n, ok := c.Palette()
+	if !ok {
+		if bold {
+			return vgaPalette[15]
+		}
+		return ""
+	}
+	switch {
+	case n < 8 && bold:
+		return vgaPalette[n+8]
+	case n < 16:
+		return vgaPalette[n]
+	case n < 232:
+		// 6x6x6 color cube
+		n -= 16
+		level := func(v uint8) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	default:
+		gray := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ipmiserial/logs"
+
+	"github.com/gorilla/mux"
+)
+
+// parseSearchQuery reads q, regex, ignoreCase, context and limit parameters.
+func parseSearchQuery(r *http.Request) (logs.SearchQuery, bool) {
+	params := r.URL.Query()
+	q := logs.SearchQuery{
+		Pattern:    params.Get("q"),
+		Regex:      params.Get("regex") == "true",
+		IgnoreCase: params.Get("ignoreCase") == "true",
+	}
+	if q.Pattern == "" {
+		return q, false
+	}
+	if n, err := strconv.Atoi(params.Get("context")); err == nil {
+		q.Context = n
+	}
+	if n, err := strconv.Atoi(params.Get("limit")); err == nil {
+		q.Limit = n
+	}
+	return q, true
+}
+
+func (s *Server) handleSearchServerLogs(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	q, ok := parseSearchQuery(r)
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "q parameter is required")
+		return
+	}
+
+	result, err := s.logWriter.Search(r.Context(), name, q)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleQueryLogs pages through a server's log lines, oldest first, stitched
+// across rotated files and narrowed by since, until and grep.
+func (s *Server) handleQueryLogs(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	params := r.URL.Query()
+	q := logs.LogQuery{
+		Grep:       params.Get("grep"),
+		Regex:      params.Get("regex") == "true",
+		IgnoreCase: params.Get("ignoreCase") == "true",
+		Cursor:     params.Get("cursor"),
+	}
+	var ok bool
+	if q.Since, ok = parseQueryTime(params.Get("since")); !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "since must be an RFC 3339 time or a duration such as 2h")
+		return
+	}
+	if q.Until, ok = parseQueryTime(params.Get("until")); !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "until must be an RFC 3339 time or a duration such as 2h")
+		return
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Until.Before(q.Since) {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "since must be before until")
+		return
+	}
+	if n, err := strconv.Atoi(params.Get("limit")); err == nil {
+		q.Limit = n
+	}
+
+	result, err := s.logWriter.Query(r.Context(), name, q)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// parseQueryTime reads an RFC 3339 time, or a duration meaning that long
+// ago. Empty is the zero time.
+func parseQueryTime(v string) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(v, "-"))
+	if err != nil || d < 0 {
+		return time.Time{}, false
+	}
+	return time.Now().Add(-d), true
+}
+
+// handleSearchAllLogs searches every server's logs, or only those listed in
+// a comma-separated servers parameter.
+func (s *Server) handleSearchAllLogs(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseSearchQuery(r)
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "q parameter is required")
+		return
+	}
+
+	servers := s.logWriter.ListServerDirs()
+	if filter := r.URL.Query().Get("servers"); filter != "" {
+		servers = strings.Split(filter, ",")
+	}
+	allowed := servers[:0:0]
+	for _, name := range servers {
+		if serverAllowed(r, name) {
+			allowed = append(allowed, name)
+		}
+	}
+	servers = allowed
+
+	result, err := s.logWriter.SearchServers(r.Context(), servers, q)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
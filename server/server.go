@@ -5,15 +5,21 @@ import (
 	"embed"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 
+	"ipmiserial/alerts"
 	"ipmiserial/config"
 	"ipmiserial/discovery"
+	"ipmiserial/events"
 	"ipmiserial/logs"
+	"ipmiserial/playbooks"
 	"ipmiserial/sol"
 )
 
@@ -26,37 +32,69 @@ type Server struct {
 	scanner    *discovery.Scanner
 	solManager *sol.Manager
 	logWriter  *logs.Writer
+	playbooks  *playbooks.Engine
 	router     *mux.Router
 	httpServer *http.Server
 	macLookup  map[string]string // MAC -> server name
+	macMu      sync.RWMutex
+
+	onLogMigration func(newPath string)
+	sseCompression atomic.Bool
+	catchup        atomic.Value // string, one of the Catchup* sources
+
+	apiKeys *KeyStore
+	auth    *authState
+	authMu  sync.RWMutex
+
+	alerts   *alerts.Engine
+	webhooks *events.Webhooks
+	logSinks *logs.SinkRegistry
+
+	tls            config.TLSConfig
+	redirectServer *http.Server
+
+	grpcPort   int
+	readOnly   atomic.Bool
+	accessLog  atomic.Pointer[accessLogState]
+	streams    *streamRegistry
+	groupLabel atomic.Value // string, the label servers are grouped by
 }
 
-func New(port int, scanner *discovery.Scanner, solManager *sol.Manager, logWriter *logs.Writer, servers []config.ServerEntry, version string) *Server {
+func New(port int, scanner *discovery.Scanner, solManager *sol.Manager, logWriter *logs.Writer, playbookEngine *playbooks.Engine, servers []config.ServerEntry, version string) *Server {
 	s := &Server{
 		port:       port,
 		version:    version,
 		scanner:    scanner,
 		solManager: solManager,
 		logWriter:  logWriter,
+		playbooks:  playbookEngine,
 		router:     mux.NewRouter(),
-		macLookup:  make(map[string]string),
+		streams:    newStreamRegistry(),
 	}
 
-	// Build MAC lookup table
+	s.SetServers(servers)
+	s.setupRoutes()
+	return s
+}
+
+// SetServers rebuilds the MAC lookup table from the static server list.
+func (s *Server) SetServers(servers []config.ServerEntry) {
+	lookup := make(map[string]string)
 	for _, srv := range servers {
 		for _, mac := range srv.MACs {
 			// Normalize MAC: lowercase, no separators
 			normalized := normalizeMac(mac)
-			s.macLookup[normalized] = srv.Name
+			lookup[normalized] = srv.Name
 			log.Debugf("MAC lookup: %s -> %s", normalized, srv.Name)
 		}
 	}
-	if len(s.macLookup) > 0 {
-		log.Infof("Loaded %d MAC address mappings", len(s.macLookup))
+	if len(lookup) > 0 {
+		log.Infof("Loaded %d MAC address mappings", len(lookup))
 	}
 
-	s.setupRoutes()
-	return s
+	s.macMu.Lock()
+	s.macLookup = lookup
+	s.macMu.Unlock()
 }
 
 // normalizeMac converts MAC to lowercase without separators
@@ -69,68 +107,172 @@ func normalizeMac(mac string) string {
 }
 
 func (s *Server) setupRoutes() {
-	// API routes
-	api := s.router.PathPrefix("/api").Subrouter()
+	// API routes: the current version under /api/v1, and the unversioned
+	// /api as a deprecated alias for clients written before versioning
+	s.router.HandleFunc("/api/versions", s.handleAPIVersions).Methods("GET")
+	v1 := s.router.PathPrefix(apiV1).Subrouter()
+	v1.NotFoundHandler = apiNotFound(v1)
+	s.apiRoutes(v1)
+	legacy := s.router.PathPrefix("/api").Subrouter()
+	legacy.NotFoundHandler = apiNotFound(legacy)
+	legacy.Use(deprecatedAPI)
+	s.apiRoutes(legacy)
+
+	// HTMX HTML fragment routes
+	htmx := s.router.PathPrefix("/htmx").Subrouter()
+	htmx.HandleFunc("/servers/{name}/analytics", s.handleAnalyticsHTML).Methods("GET")
+	htmx.HandleFunc("/servers/{name}/logs", s.handleLogListHTML).Methods("GET")
+	htmx.HandleFunc("/servers/{name}/logs/{filename}", s.handleLogContentHTML).Methods("GET")
+
+	// Prometheus metrics
+	s.router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+
+	// Kubernetes liveness and readiness probes, outside /api so they need
+	// no credentials
+	s.router.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+	s.router.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
+
+	// Serve embedded web files with no-cache for JS/CSS
+	webContent, _ := fs.Sub(webFS, "web")
+	fileServer := http.FileServer(http.FS(webContent))
+	s.router.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".js") || strings.HasSuffix(r.URL.Path, ".css") {
+			w.Header().Set("Cache-Control", "no-cache, must-revalidate")
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+}
+
+// apiRoutes registers the REST API on api, a subrouter for one version
+// prefix.
+func (s *Server) apiRoutes(api *mux.Router) {
 	api.HandleFunc("/version", s.handleVersion).Methods("GET")
+	api.HandleFunc("/openapi.json", s.handleOpenAPI).Methods("GET")
+	api.HandleFunc("/docs", s.handleAPIDocs).Methods("GET")
 	api.HandleFunc("/servers", s.handleListServers).Methods("GET")
 	api.HandleFunc("/servers/{name}/stream", s.handleStream).Methods("GET")
+	api.HandleFunc("/servers/{name}/events", s.handleServerEvents).Methods("GET")
+	api.HandleFunc("/servers/{name}/attach", s.handleAttach).Methods("GET")
+	api.HandleFunc("/events", s.handleAllEvents).Methods("GET")
 	api.HandleFunc("/servers/{name}/logs", s.handleListLogs).Methods("GET")
+	api.HandleFunc("/servers/{name}/logs/search", s.handleSearchServerLogs).Methods("GET")
+	api.HandleFunc("/servers/{name}/logs/query", s.handleQueryLogs).Methods("GET")
 	api.HandleFunc("/servers/{name}/logs/{filename}", s.handleGetLog).Methods("GET")
 	api.HandleFunc("/servers/{name}/logs/{filename}/info", s.handleLogInfo).Methods("GET")
 	api.HandleFunc("/servers/{name}/status", s.handleStatus).Methods("GET")
+	api.HandleFunc("/servers/{name}/stats", s.handleSessionStats).Methods("GET")
+	api.HandleFunc("/servers/{name}/sol/diag", s.handleSOLDiag).Methods("GET")
+	api.HandleFunc("/servers/{name}/screen", s.handleScreen).Methods("GET")
+	api.HandleFunc("/servers/{name}/scrollback", s.handleScrollback).Methods("GET")
 	api.HandleFunc("/servers/{name}/command", s.handleSendCommand).Methods("POST")
 	api.HandleFunc("/servers/{name}/input", s.handleInput).Methods("POST")
+	api.HandleFunc("/servers/{name}/input/acquire", s.handleAcquireInput).Methods("POST")
+	api.HandleFunc("/servers/{name}/input/release", s.handleReleaseInput).Methods("POST")
+	api.HandleFunc("/servers/{name}/viewers", s.handleViewers).Methods("GET")
+	api.HandleFunc("/servers/{name}/break", s.handleBreak).Methods("POST")
 	api.HandleFunc("/servers/{name}/logs/clear", s.handleClearLogs).Methods("POST")
 	api.HandleFunc("/servers/{name}/logs/rotate", s.handleRotateLogs).Methods("POST")
 	api.HandleFunc("/logs/clear", s.handleClearAllLogs).Methods("POST")
+	api.HandleFunc("/logs/search", s.handleSearchAllLogs).Methods("GET")
+	api.HandleFunc("/logs/sinks", s.handleLogSinks).Methods("GET")
+	api.HandleFunc("/logs/usage", s.handleLogUsage).Methods("GET")
 	api.HandleFunc("/servers/{name}/analytics", s.handleAnalytics).Methods("GET")
+	api.HandleFunc("/servers/{name}/sel", s.handleSEL).Methods("GET")
+	api.HandleFunc("/servers/{name}/history", s.handleConnectHistory).Methods("GET")
+	api.HandleFunc("/servers/{name}/sensors", s.handleSensors).Methods("GET")
+	api.HandleFunc("/servers/{name}/timeline", s.handleTimeline).Methods("GET")
+	api.HandleFunc("/servers/{name}/software", s.handleSoftware).Methods("GET")
+	api.HandleFunc("/servers/{name}/boots/{index}/log", s.handleBootLog).Methods("GET")
+	api.HandleFunc("/servers/{name}/inventory", s.handleInventory).Methods("GET")
+	api.HandleFunc("/software", s.handleAllSoftware).Methods("GET")
+	api.HandleFunc("/servers/{name}/power", s.handlePowerStatus).Methods("GET")
+	api.HandleFunc("/servers/{name}/power/reading", s.handlePowerReading).Methods("GET")
+	api.HandleFunc("/servers/{name}/power", s.handlePower).Methods("POST")
+	api.HandleFunc("/servers/{name}/bootdev", s.handleBootDev).Methods("POST")
+	api.HandleFunc("/servers/{name}/playbooks/{playbook}/run", s.handleRunPlaybook).Methods("POST")
+	api.HandleFunc("/servers/{name}/reprovision", s.handleReprovision).Methods("POST")
+	api.HandleFunc("/playbooks", s.handleListPlaybooks).Methods("GET")
+	api.HandleFunc("/playbooks/runs", s.handleListPlaybookRuns).Methods("GET")
+	api.HandleFunc("/playbooks/runs/{id}", s.handleGetPlaybookRun).Methods("GET")
+	api.HandleFunc("/playbooks/runs/{id}/cancel", s.handleCancelPlaybookRun).Methods("POST")
+	api.HandleFunc("/alerts", s.handleListAlerts).Methods("GET")
+	api.HandleFunc("/admin/alerts/test", s.handleTestNotifier).Methods("POST")
+	api.HandleFunc("/admin/webhooks", s.handleListWebhooks).Methods("GET")
+	api.HandleFunc("/admin/webhooks/test", s.handleTestWebhook).Methods("POST")
 	api.HandleFunc("/analytics", s.handleAllAnalytics).Methods("GET")
+	api.HandleFunc("/analytics/poweron", s.handlePowerOnReport).Methods("GET")
+	api.HandleFunc("/analytics/pipeline", s.handlePipelineStats).Methods("GET")
+	api.HandleFunc("/analytics/summary", s.handleFleetSummary).Methods("GET")
 	api.HandleFunc("/lookup/mac/{mac}", s.handleMacLookup).Methods("GET")
 	api.HandleFunc("/servers/{name}/reconnect", s.handleReconnect).Methods("POST")
+	api.HandleFunc("/bulk", s.handleBulk).Methods("POST")
 	api.HandleFunc("/refresh", s.handleRefresh).Methods("POST")
+	api.HandleFunc("/discovery/status", s.handleDiscoveryStatus).Methods("GET")
+	api.HandleFunc("/sessions/queue", s.handleConnectQueue).Methods("GET")
+	api.HandleFunc("/streams", s.handleStreams).Methods("GET")
+	api.HandleFunc("/admin/migrate", s.handleMigrateLogs).Methods("POST")
+	api.HandleFunc("/admin/migrate", s.handleMigrationStatus).Methods("GET")
+	api.HandleFunc("/admin/keys", s.handleListKeys).Methods("GET")
+	api.HandleFunc("/admin/keys", s.handleCreateKey).Methods("POST")
+	api.HandleFunc("/admin/keys/{id}", s.handleRevokeKey).Methods("DELETE")
 	api.HandleFunc("/debug/bmh", s.handleDebugBMH).Methods("GET")
 	api.HandleFunc("/debug/rawdump/{name}", s.handleRawDump).Methods("GET")
 	api.HandleFunc("/debug/log", s.handleDebugLog).Methods("GET")
-
-	// HTMX HTML fragment routes
-	htmx := s.router.PathPrefix("/htmx").Subrouter()
-	htmx.HandleFunc("/servers/{name}/analytics", s.handleAnalyticsHTML).Methods("GET")
-	htmx.HandleFunc("/servers/{name}/logs", s.handleLogListHTML).Methods("GET")
-	htmx.HandleFunc("/servers/{name}/logs/{filename}", s.handleLogContentHTML).Methods("GET")
-
-	// Serve embedded web files with no-cache for JS/CSS
-	webContent, _ := fs.Sub(webFS, "web")
-	fileServer := http.FileServer(http.FS(webContent))
-	s.router.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, ".js") || strings.HasSuffix(r.URL.Path, ".css") {
-			w.Header().Set("Cache-Control", "no-cache, must-revalidate")
-		}
-		fileServer.ServeHTTP(w, r)
-	}))
-}
-
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Debugf("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
-		next.ServeHTTP(w, r)
-	})
 }
 
 func (s *Server) Run(ctx context.Context) error {
-	s.router.Use(loggingMiddleware)
+	s.router.Use(s.accessLogMiddleware)
+	s.router.Use(tracingMiddleware)
+	s.router.Use(s.authMiddleware)
+	s.router.Use(s.readOnlyMiddleware)
 	s.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
 		Handler: s.router,
+		// Requests end with ctx, so WebSocket attaches, which Shutdown
+		// doesn't track once hijacked, close on shutdown too
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	if s.tlsEnabled() {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		s.httpServer.TLSConfig = tlsConfig
+		if s.tls.RedirectPort > 0 {
+			s.redirectServer = &http.Server{
+				Addr:    fmt.Sprintf(":%d", s.tls.RedirectPort),
+				Handler: s.redirectHandler(),
+			}
+			go func() {
+				log.Infof("Redirecting HTTP on port %d to HTTPS", s.tls.RedirectPort)
+				if err := s.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Errorf("HTTP redirect server error: %v", err)
+				}
+			}()
+		}
+	}
+
+	if s.grpcPort > 0 {
+		go s.runGRPC(ctx)
 	}
 
 	go func() {
 		<-ctx.Done()
 		log.Info("Context done, shutting down HTTP server")
+		if s.redirectServer != nil {
+			s.redirectServer.Shutdown(context.Background())
+		}
 		s.httpServer.Shutdown(context.Background())
 	}()
 
-	log.Infof("Web server on port %d", s.port)
-	err := s.httpServer.ListenAndServe()
+	var err error
+	if s.tlsEnabled() {
+		log.Infof("Web server on port %d (HTTPS)", s.port)
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		log.Infof("Web server on port %d", s.port)
+		err = s.httpServer.ListenAndServe()
+	}
 	if err == http.ErrServerClosed {
 		log.Info("HTTP server closed cleanly")
 		return nil
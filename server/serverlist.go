@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ipmiserial/discovery"
+)
+
+// maxServerPageSize caps ?limit= on /api/servers.
+const maxServerPageSize = 1000
+
+// serverListQuery filters, sorts and pages the server list:
+// ?prefix=, ?online=, ?connected=, ?state= (a session state), ?label= (a
+// label selector such as rack=r12,env!=prod), ?group=,
+// ?sort=name|ip|state|group, ?order=asc|desc,
+// ?page= (from 1) and ?limit= (0 = everything, the default).
+type serverListQuery struct {
+	prefix    string
+	online    *bool
+	connected *bool
+	state     string
+	labels    discovery.Selector
+	group     *string
+	sort      string
+	desc      bool
+	page      int
+	limit     int
+}
+
+func parseServerListQuery(params url.Values) (serverListQuery, error) {
+	q := serverListQuery{prefix: params.Get("prefix"), state: params.Get("state"), sort: "name", page: 1}
+	for _, f := range []struct {
+		name string
+		dst  **bool
+	}{{"online", &q.online}, {"connected", &q.connected}} {
+		if v := params.Get(f.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be true or false", f.name)
+			}
+			*f.dst = &b
+		}
+	}
+	if v := params.Get("label"); v != "" {
+		sel, err := discovery.ParseSelector(v)
+		if err != nil {
+			return q, err
+		}
+		q.labels = sel
+	}
+	if params.Has("group") {
+		g := params.Get("group")
+		q.group = &g
+	}
+	if v := params.Get("sort"); v != "" {
+		switch v {
+		case "name", "ip", "state", "group":
+			q.sort = v
+		default:
+			return q, fmt.Errorf("sort must be name, ip, state or group")
+		}
+	}
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+	for _, f := range []struct {
+		name string
+		dst  *int
+		min  int
+	}{{"page", &q.page, 1}, {"limit", &q.limit, 0}} {
+		if v := params.Get(f.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < f.min {
+				return q, fmt.Errorf("%s must be an integer of at least %d", f.name, f.min)
+			}
+			*f.dst = n
+		}
+	}
+	if q.limit > maxServerPageSize {
+		q.limit = maxServerPageSize
+	}
+	return q, nil
+}
+
+// apply returns the page of infos the query selects and how many matched.
+func (q serverListQuery) apply(infos []ServerInfo) ([]ServerInfo, int) {
+	matched := infos[:0:0]
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name, q.prefix) ||
+			(q.online != nil && info.Online != *q.online) ||
+			(q.connected != nil && info.Connected != *q.connected) ||
+			(q.state != "" && (info.SessionStatus == nil || string(info.State) != q.state)) ||
+			(q.group != nil && info.Group != *q.group) ||
+			!q.labels.Matches(info.Labels) {
+			continue
+		}
+		matched = append(matched, info)
+	}
+
+	less := func(a, b ServerInfo) bool { return a.Name < b.Name }
+	switch q.sort {
+	case "ip":
+		less = func(a, b ServerInfo) bool {
+			if c := compareIP(a.IP, b.IP); c != 0 {
+				return c < 0
+			}
+			return a.Name < b.Name
+		}
+	case "group":
+		// Ungrouped servers last
+		less = func(a, b ServerInfo) bool {
+			if a.Group != b.Group {
+				return b.Group == "" || (a.Group != "" && a.Group < b.Group)
+			}
+			return a.Name < b.Name
+		}
+	case "state":
+		// Connected, then online, then unreachable
+		rank := func(i ServerInfo) int {
+			switch {
+			case i.Connected:
+				return 0
+			case i.Online:
+				return 1
+			}
+			return 2
+		}
+		less = func(a, b ServerInfo) bool {
+			if ra, rb := rank(a), rank(b); ra != rb {
+				return ra < rb
+			}
+			return a.Name < b.Name
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if q.desc {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+
+	total := len(matched)
+	if q.limit == 0 {
+		return matched, total
+	}
+	// Compare pages rather than offsets: page*limit overflows for huge pages
+	if q.page > pageCount(total, q.limit) {
+		return []ServerInfo{}, total
+	}
+	start := (q.page - 1) * q.limit
+	end := start + q.limit
+	if end > total {
+		end = total
+	}
+	return matched[start:end], total
+}
+
+// pageCount is how many pages of limit hold total servers.
+func pageCount(total, limit int) int {
+	return (total + limit - 1) / limit
+}
+
+// compareIP orders addresses numerically, with unparseable ones after.
+func compareIP(a, b string) int {
+	ia, ib := net.ParseIP(a), net.ParseIP(b)
+	switch {
+	case ia == nil && ib == nil:
+		return strings.Compare(a, b)
+	case ia == nil:
+		return 1
+	case ib == nil:
+		return -1
+	}
+	return bytes.Compare(ia.To16(), ib.To16())
+}
+
+// setPageHeaders reports the match count in X-Total-Count and links the
+// neighbouring pages.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, q serverListQuery, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if q.limit == 0 {
+		return
+	}
+	link := func(page int, rel string) {
+		u := *r.URL
+		params := u.Query()
+		params.Set("page", strconv.Itoa(page))
+		u.RawQuery = params.Encode()
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel))
+	}
+	if q.page < pageCount(total, q.limit) {
+		link(q.page+1, "next")
+	}
+	if q.page > 1 {
+		link(q.page-1, "prev")
+	}
+}
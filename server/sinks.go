@@ -0,0 +1,29 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ipmiserial/logs"
+)
+
+// SetLogSinks exposes the log sink registry's delivery status.
+func (s *Server) SetLogSinks(sinks *logs.SinkRegistry) {
+	s.logSinks = sinks
+}
+
+func (s *Server) handleLogSinks(w http.ResponseWriter, r *http.Request) {
+	status := make([]logs.SinkStatus, 0)
+	if s.logSinks != nil {
+		status = s.logSinks.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleLogUsage reports disk usage per server and in total, against the
+// logs.max_total_size_gb quota.
+func (s *Server) handleLogUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.logWriter.Usage())
+}
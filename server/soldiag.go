@@ -0,0 +1,27 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// handleSOLDiag queries the BMC's payload and SOL configuration state, over
+// the live session or a command-only one, for debugging activation
+// failures. A BMC that can't be reached or logged into is a 502.
+func (s *Server) handleSOLDiag(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, exists := s.scanner.GetServers()[name]; !exists {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+	diag, err := s.solManager.SOLDiagnostics(r.Context(), name)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadGateway, CodeBMCError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diag)
+}
@@ -2,124 +2,498 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/sol"
 )
 
 var clearScreenSeq = []byte("\x1b[2J")
 
-// sseWrite writes an SSE frame and flushes. Returns false if the connection is dead.
-func sseWrite(w http.ResponseWriter, rc *http.ResponseController, format string, args ...interface{}) bool {
-	if _, err := fmt.Fprintf(w, format, args...); err != nil {
+// sseStream writes SSE frames, gzip-compressed when the client accepts it.
+// Every frame is flushed through the compressor so events are never held
+// back; the shared compression window still pays off on base64 console data.
+// A frame that can't be written within timeout ends the stream, so a client
+// that stopped reading doesn't hold its handler forever.
+type sseStream struct {
+	w       io.Writer
+	gz      *gzip.Writer
+	rc      *http.ResponseController
+	timeout time.Duration // 0 = none
+}
+
+// newSSEStream sets the SSE headers and negotiates compression. Clients can
+// opt out with ?compress=false.
+func (s *Server) newSSEStream(w http.ResponseWriter, r *http.Request) *sseStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	st := &sseStream{w: w, rc: http.NewResponseController(w), timeout: s.streams.writeTimeout()}
+	if s.sseCompression.Load() && r.URL.Query().Get("compress") != "false" && acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		st.gz, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+		st.w = st.gz
+	}
+	return st
+}
+
+// write sends an SSE frame and flushes. Returns false if the connection is dead.
+func (st *sseStream) write(format string, args ...interface{}) bool {
+	if st.timeout > 0 {
+		st.rc.SetWriteDeadline(time.Now().Add(st.timeout))
+	}
+	if _, err := fmt.Fprintf(st.w, format, args...); err != nil {
 		return false
 	}
-	if err := rc.Flush(); err != nil {
+	if st.gz != nil {
+		if err := st.gz.Flush(); err != nil {
+			return false
+		}
+	}
+	if err := st.rc.Flush(); err != nil {
 		return false
 	}
 	return true
 }
 
+func (st *sseStream) close() {
+	if st.gz != nil {
+		st.gz.Close()
+	}
+	if st.timeout > 0 {
+		st.rc.SetWriteDeadline(time.Time{})
+	}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// SetSSECompression enables or disables gzip on new SSE streams.
+func (s *Server) SetSSECompression(enabled bool) {
+	s.sseCompression.Store(enabled)
+}
+
+// Catchup sources for a console stream's initial screen.
+const (
+	CatchupAuto   = "auto"   // screen buffer, falling back to the log tail
+	CatchupScreen = "screen" // raw screen buffer only; renders like the live stream
+	CatchupLog    = "log"    // tail of the cleaned log
+	CatchupNone   = "none"   // live output only
+)
+
+// ValidCatchup reports whether name is a catchup source.
+func ValidCatchup(name string) bool {
+	switch name {
+	case CatchupAuto, CatchupScreen, CatchupLog, CatchupNone:
+		return true
+	}
+	return false
+}
+
+// SetCatchup sets the default catchup source for new console streams.
+func (s *Server) SetCatchup(source string) {
+	if !ValidCatchup(source) {
+		log.Warnf("Unknown catchup source %q, using %s", source, CatchupAuto)
+		source = CatchupAuto
+	}
+	s.catchup.Store(source)
+}
+
+// catchupSource returns the request's ?catchup= or the configured default.
+func (s *Server) catchupSource(r *http.Request) (string, error) {
+	if v := r.URL.Query().Get("catchup"); v != "" {
+		if !ValidCatchup(v) {
+			return "", fmt.Errorf("unknown catchup %q (use auto, screen, log or none)", v)
+		}
+		return v, nil
+	}
+	if v, ok := s.catchup.Load().(string); ok {
+		return v, nil
+	}
+	return CatchupAuto, nil
+}
+
+// streamPingInterval is how often console streams send a ": ping" comment.
+const streamPingInterval = 15 * time.Second
+
+// Catchup sizes: the log tail sent by default, and the most ?catchup_size=
+// may ask for.
+const (
+	catchupLogSize = 4096
+	catchupMaxSize = 1 << 20
+)
+
+// catchupSize returns the request's ?catchup_size= in bytes, or 0 for the
+// default: the whole screen buffer, or the last 4KB of the log.
+func catchupSize(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("catchup_size")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > catchupMaxSize {
+		return 0, fmt.Errorf("catchup_size must be between 1 and %d bytes", catchupMaxSize)
+	}
+	return n, nil
+}
+
+// streamCursor returns the screen buffer offset a reconnecting viewer has
+// seen output up to: the Last-Event-ID header browsers send when
+// EventSource reconnects, or ?cursor=. It is -1 when there is none.
+func streamCursor(r *http.Request) int64 {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("cursor")
+	}
+	cursor, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || cursor < 0 {
+		return -1
+	}
+	return cursor
+}
+
+// screenTail returns the last size bytes of a screen buffer, starting at an
+// escape sequence or line break so the replay doesn't begin mid-sequence.
+func screenTail(buf []byte, size int) []byte {
+	if size <= 0 || len(buf) <= size {
+		return buf
+	}
+	tail := buf[len(buf)-size:]
+	if i := bytes.IndexAny(tail, "\x1b\n"); i >= 0 {
+		tail = tail[i:]
+	}
+	return tail
+}
+
+// Stream channels: the notification channels from sol plus dedup, which
+// turns the console bytes into cleaned, collapsed lines.
+const channelDedup = "dedup"
+
+var streamChannels = map[string]bool{
+	sol.ChannelRaw:       true,
+	channelDedup:         true,
+	sol.ChannelAnalytics: true,
+	sol.ChannelState:     true,
+}
+
+// parseChannels reads the comma-separated channels parameter, falling back
+// to def when it is absent.
+func parseChannels(r *http.Request, def ...string) (map[string]bool, error) {
+	names := def
+	if v := r.URL.Query().Get("channels"); v != "" {
+		names = strings.Split(v, ",")
+	}
+	channels := make(map[string]bool)
+	for _, c := range names {
+		c = strings.TrimSpace(c)
+		if !streamChannels[c] {
+			return nil, fmt.Errorf("unknown channel %q (use raw, dedup, analytics or state)", c)
+		}
+		channels[c] = true
+	}
+	return channels, nil
+}
+
+// handleStream streams a server's console. ?channels= selects raw console
+// bytes (default), dedup lines, analytics and state events.
 func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	channels, err := parseChannels(r, sol.ChannelRaw)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	s.streamServer(w, r, streamConsole, channels)
+}
+
+// handleServerEvents streams a server's analytics and state events without
+// console output; ?channels= can add dedup or raw.
+func (s *Server) handleServerEvents(w http.ResponseWriter, r *http.Request) {
+	channels, err := parseChannels(r, sol.ChannelAnalytics, sol.ChannelState)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	s.streamServer(w, r, streamEvents, channels)
+}
+
+func (s *Server) streamServer(w http.ResponseWriter, r *http.Request, kind string, channels map[string]bool) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
+	catchup, err := s.catchupSource(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	size, err := catchupSize(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
 	// Validate server exists — check log target first (no locks), fall back to scanner
 	_, _, logErr := s.logWriter.GetCurrentLogTarget(name)
 	if logErr != nil {
 		knownServers := s.scanner.GetServers()
 		if _, ok := knownServers[name]; !ok {
-			http.Error(w, "Server not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
 			return
 		}
 	}
 
-	// SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	open, ok := s.openStream(w, r, kind, name)
+	if !ok {
+		return
+	}
+	defer s.streams.close(open)
 
-	rc := http.NewResponseController(w)
+	// SSE headers
+	st := s.newSSEStream(w, r)
+	defer st.close()
 
-	if !sseWrite(w, rc, "event: connected\ndata: %s\n\n", name) {
+	if !st.write("event: connected\ndata: %s\n\n", name) {
 		return
 	}
 
-	// Catchup: prefer raw screen buffer (preserves ANSI/cursor positioning
-	// for correct terminal state). Fall back to cleaned log for servers
-	// without an active SOL session.
-	screenBuf := s.solManager.GetScreenBuffer(name)
-	if len(screenBuf) > 0 {
-		clearAndBuf := append([]byte("\x1b[2J\x1b[H"), screenBuf...)
-		encoded := base64.StdEncoding.EncodeToString(clearAndBuf)
-		if !sseWrite(w, rc, "data: %s\n\n", encoded) {
-			return
+	notifyCh := s.solManager.SubscribeNotify(name)
+	defer s.solManager.UnsubscribeNotify(name, notifyCh)
+
+	// A client holding the console's input releases it when its last
+	// stream closes.
+	defer s.solManager.TrackViewer(name, clientIdentity(r), kind, s.viewOnlyFor(requestPrincipal(r)))()
+
+	// Console bytes are only subscribed to when a channel needs them
+	var ch chan sol.Chunk
+	var dedup *sol.LineDeduper
+	if channels[sol.ChannelRaw] || channels[channelDedup] {
+		// Attaching returns the screen buffer at the moment of
+		// subscription, so catchup and live output neither overlap nor
+		// leave a gap. A reconnecting viewer's cursor resumes it where it
+		// left off instead.
+		cursor := int64(-1)
+		if channels[sol.ChannelRaw] && catchup != CatchupNone {
+			cursor = streamCursor(r)
 		}
-	} else if _, curPath, err := s.logWriter.GetCurrentLogTarget(name); err == nil && curPath != "" {
-		if f, err := os.Open(curPath); err == nil {
-			if info, _ := f.Stat(); info != nil {
-				size := info.Size()
-				const catchupSize = 4096
-				var offset int64
-				if size > catchupSize {
-					f.Seek(size-catchupSize, io.SeekStart)
-					offset = size - catchupSize
-				}
-				buf := make([]byte, size-offset)
-				n, _ := f.Read(buf)
-				if n > 0 {
-					encoded := base64.StdEncoding.EncodeToString(buf[:n])
-					if !sseWrite(w, rc, "data: %s\n\n", encoded) {
-						f.Close()
-						return
-					}
-				}
-			}
-			f.Close()
+		var screen sol.Catchup
+		screen, ch = s.solManager.Attach(name, cursor)
+		defer s.solManager.Unsubscribe(name, ch)
+		s.streams.subscribed(open, ch)
+		if channels[channelDedup] {
+			dedup = &sol.LineDeduper{}
+		}
+		if channels[sol.ChannelRaw] && !s.writeCatchup(st, name, catchup, size, screen) {
+			return
 		}
 	}
 
-	// Subscribe to raw SOL broadcast and notification events
-	ch := s.solManager.Subscribe(name)
-	defer s.solManager.Unsubscribe(name, ch)
-	notifyCh := s.solManager.SubscribeNotify(name)
-	defer s.solManager.UnsubscribeNotify(name, notifyCh)
-
 	// Heartbeat keeps the SSE connection alive when no SOL data is flowing
 	// (e.g. server sitting at login prompt). Uses a named event so proxies
 	// see real SSE data frames, not just comments they might ignore.
 	heartbeat := time.NewTicker(30 * time.Second)
 	defer heartbeat.Stop()
+	// Comment pings in between keep proxies with short idle timeouts from
+	// silently dropping the stream.
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
 
 	for {
 		select {
 		case <-r.Context().Done():
 			return
 		case <-heartbeat.C:
-			if !sseWrite(w, rc, "event: heartbeat\ndata: \n\n") {
+			if dedup != nil && !writeDedup(st, dedup.Flush()) {
+				return
+			}
+			if !st.write("event: heartbeat\ndata: \n\n") {
+				return
+			}
+		case <-ping.C:
+			if !st.write(": ping\n\n") {
 				return
 			}
 		case event := <-notifyCh:
-			if !sseWrite(w, rc, "event: %s\ndata: %s\n\n", event.Name, event.Data) {
+			if !channels[event.Channel] {
+				continue
+			}
+			if !st.write("event: %s\ndata: %s\n\n", event.Name, event.Data) {
 				return
 			}
-		case data, ok := <-ch:
+		case chunk, ok := <-ch:
 			if !ok {
 				return
 			}
+			data := chunk.Data
+			if dedup != nil && !writeDedup(st, dedup.Write(data)) {
+				return
+			}
+			if !channels[sol.ChannelRaw] {
+				continue
+			}
 			// BIOS redraws screen by positioning to row 1 without clearing.
 			// Inject clear screen so old content doesn't linger in xterm.js.
 			if containsRow1Cursor(data) {
 				data = append(clearScreenSeq, data...)
 			}
-			encoded := base64.StdEncoding.EncodeToString(data)
-			if !sseWrite(w, rc, "data: %s\n\n", encoded) {
+			if !writeRaw(st, data, chunk.Offset) {
+				return
+			}
+		}
+	}
+}
+
+// writeRaw sends console bytes as a data frame, with the screen buffer
+// offset after them as its id when they are part of the screen buffer.
+func writeRaw(st *sseStream, data []byte, offset int64) bool {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if offset > 0 {
+		return st.write("id: %d\ndata: %s\n\n", offset, encoded)
+	}
+	return st.write("data: %s\n\n", encoded)
+}
+
+// writeCatchup sends the screen so far to a raw-channel viewer, at most
+// size bytes of it (0 = the default for the source). A resumed viewer only
+// gets the output it missed, without clearing its screen.
+func (s *Server) writeCatchup(st *sseStream, name, source string, size int, screen sol.Catchup) bool {
+	if source == CatchupNone {
+		return true
+	}
+	if screen.Resumed {
+		if len(screen.Data) == 0 {
+			return true
+		}
+		return writeRaw(st, screen.Data, screen.Offset)
+	}
+	screenBuf := screen.Data
+	// The raw screen buffer preserves ANSI/cursor positioning for correct
+	// terminal state. In auto mode the cleaned log is the fallback for
+	// servers without an active SOL session.
+	if source != CatchupLog && len(screenBuf) > 0 {
+		clearAndBuf := append([]byte("\x1b[2J\x1b[H"), screenTail(screenBuf, size)...)
+		return writeRaw(st, clearAndBuf, screen.Offset)
+	}
+	if source == CatchupScreen {
+		return true
+	}
+	_, curPath, err := s.logWriter.GetCurrentLogTarget(name)
+	if err != nil || curPath == "" {
+		return true
+	}
+	f, err := os.Open(curPath)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	if info == nil {
+		return true
+	}
+	if size <= 0 {
+		size = catchupLogSize
+	}
+	fileSize := info.Size()
+	var offset int64
+	if fileSize > int64(size) {
+		offset = fileSize - int64(size)
+		f.Seek(offset, io.SeekStart)
+	}
+	buf := make([]byte, fileSize-offset)
+	n, _ := f.Read(buf)
+	if n > 0 {
+		encoded := base64.StdEncoding.EncodeToString(buf[:n])
+		return st.write("data: %s\n\n", encoded)
+	}
+	return true
+}
+
+// writeDedup sends dedup lines as line events, and collapsed runs as repeat
+// events.
+func writeDedup(st *sseStream, lines []sol.DedupLine) bool {
+	for _, l := range lines {
+		event := "line"
+		if l.Repeat > 0 {
+			event = "repeat"
+		}
+		data, _ := json.Marshal(l)
+		if !st.write("event: %s\ndata: %s\n\n", event, data) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleAllEvents streams analytics and state events for every server the
+// request may see, for status bots and dashboards.
+func (s *Server) handleAllEvents(w http.ResponseWriter, r *http.Request) {
+	channels, err := parseChannels(r, sol.ChannelAnalytics, sol.ChannelState)
+	if err != nil || channels[sol.ChannelRaw] || channels[channelDedup] {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "channels must be analytics and/or state")
+		return
+	}
+	servers := make(map[string]bool)
+	if v := r.URL.Query().Get("servers"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			servers[name] = true
+		}
+	}
+
+	open, ok := s.openStream(w, r, streamAllEvents, "")
+	if !ok {
+		return
+	}
+	defer s.streams.close(open)
+
+	st := s.newSSEStream(w, r)
+	defer st.close()
+
+	if !st.write("event: connected\ndata: *\n\n") {
+		return
+	}
+
+	notifyCh := s.solManager.SubscribeAllNotify()
+	defer s.solManager.UnsubscribeAllNotify(notifyCh)
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !st.write("event: heartbeat\ndata: \n\n") {
+				return
+			}
+		case event := <-notifyCh:
+			if !channels[event.Channel] || !serverAllowed(r, event.Server) ||
+				(len(servers) > 0 && !servers[event.Server]) {
+				continue
+			}
+			if !st.write("event: %s\ndata: %s\n\n", event.Name, event.Data) {
 				return
 			}
 		}
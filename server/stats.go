@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/sol"
+)
+
+// sessionStats is the body of GET /api/servers/{name}/stats: the SOL
+// session's counters and its average throughput since SOL was activated.
+type sessionStats struct {
+	Server string `json:"server"`
+	sol.SOLStats
+	Uptime  float64 `json:"uptime"`  // seconds since SOL was activated
+	InRate  float64 `json:"inRate"`  // average bytes per second received
+	OutRate float64 `json:"outRate"` // average bytes per second sent, counting retransmissions
+}
+
+func (s *Server) handleSessionStats(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, exists := s.scanner.GetServers()[name]; !exists {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+	stats, ok := s.solManager.SOLStats(name)
+	if !ok || stats.Since.IsZero() {
+		writeProblem(w, r, http.StatusConflict, CodeNotConnected, "No SOL session")
+		return
+	}
+
+	resp := sessionStats{Server: name, SOLStats: stats}
+	resp.Uptime = time.Since(stats.Since).Seconds()
+	if resp.Uptime > 0 {
+		resp.InRate = float64(stats.BytesIn) / resp.Uptime
+		resp.OutRate = float64(stats.BytesOut) / resp.Uptime
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
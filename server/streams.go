@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"ipmiserial/config"
+	"ipmiserial/sol"
+)
+
+// Stream kinds, as listed by /api/streams.
+const (
+	streamConsole   = "console"    // SSE /api/servers/{name}/stream
+	streamEvents    = "events"     // SSE /api/servers/{name}/events
+	streamAllEvents = "all-events" // SSE /api/events
+	streamAttach    = "attach"     // WebSocket /api/servers/{name}/attach
+	streamGRPC      = "grpc"       // gRPC StreamConsole
+)
+
+// Stream defaults, used until SetStreamLimits is called.
+const (
+	defaultMaxStreamsPerServer = 50
+	defaultMaxStreams          = 1000
+	defaultStreamEvictAfter    = 30 * time.Second
+)
+
+// StreamInfo describes one open stream.
+type StreamInfo struct {
+	ID     int64     `json:"id"`
+	Kind   string    `json:"kind"`
+	Server string    `json:"server,omitempty"` // empty for /api/events
+	Client string    `json:"client"`           // user@host
+	Since  time.Time `json:"since"`
+
+	// Console output missed because the client fell behind, and since when
+	// it has been too far behind to take any
+	Dropped        uint64     `json:"dropped"`
+	SaturatedSince *time.Time `json:"saturatedSince,omitempty"`
+}
+
+// StreamLimits are the applied server.streams settings.
+type StreamLimits struct {
+	MaxPerServer int     `json:"maxPerServer"` // 0 = unlimited
+	MaxTotal     int     `json:"maxTotal"`     // 0 = unlimited
+	EvictAfter   float64 `json:"evictAfter"`   // seconds; 0 = never evict
+}
+
+// StreamsReport is the /api/streams response.
+type StreamsReport struct {
+	Limits   StreamLimits `json:"limits"`
+	Open     int          `json:"open"`
+	Rejected uint64       `json:"rejected"` // streams refused at a limit since startup
+	Dropped  uint64       `json:"dropped"`  // console chunks dropped for slow viewers since startup
+	Evicted  uint64       `json:"evicted"`  // viewers disconnected for staying saturated since startup
+	Streams  []StreamInfo `json:"streams"`
+}
+
+// stream is an open stream and the console subscription it reads, if any.
+type stream struct {
+	info StreamInfo
+	ch   chan sol.Chunk
+}
+
+// streamRegistry counts open streams against the per-server and global
+// limits, so a burst of dashboards or a leaking client can't pile up
+// goroutines and channels without bound.
+type streamRegistry struct {
+	mu           sync.Mutex
+	maxPerServer int
+	maxTotal     int
+	evictAfter   time.Duration
+	nextID       int64
+	streams      map[int64]*stream
+	perServer    map[string]int
+	rejected     uint64
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{
+		maxPerServer: defaultMaxStreamsPerServer,
+		maxTotal:     defaultMaxStreams,
+		evictAfter:   defaultStreamEvictAfter,
+		streams:      make(map[int64]*stream),
+		perServer:    make(map[string]int),
+	}
+}
+
+// SetStreamLimits applies the server.streams section. Lower limits don't
+// close streams already open; they refuse new ones until enough have
+// closed.
+func (s *Server) SetStreamLimits(cfg config.StreamsConfig) {
+	reg := s.streams
+	reg.mu.Lock()
+	reg.maxPerServer = max(cfg.MaxPerServer, 0)
+	reg.maxTotal = max(cfg.MaxTotal, 0)
+	reg.evictAfter = max(cfg.EvictAfter, 0)
+	reg.mu.Unlock()
+	s.solManager.SetSlowViewerEviction(cfg.EvictAfter)
+}
+
+// open registers a stream, or fails if a limit is reached.
+func (reg *streamRegistry) open(kind, server string, r *http.Request) (*stream, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.maxTotal > 0 && len(reg.streams) >= reg.maxTotal {
+		reg.rejected++
+		return nil, fmt.Errorf("too many open streams (server.streams.max_total %d)", reg.maxTotal)
+	}
+	if server != "" && reg.maxPerServer > 0 && reg.perServer[server] >= reg.maxPerServer {
+		reg.rejected++
+		return nil, fmt.Errorf("too many open streams for %s (server.streams.max_per_server %d)", server, reg.maxPerServer)
+	}
+	reg.nextID++
+	st := &stream{info: StreamInfo{
+		ID:     reg.nextID,
+		Kind:   kind,
+		Server: server,
+		Client: clientIdentity(r),
+		Since:  time.Now(),
+	}}
+	reg.streams[st.info.ID] = st
+	if server != "" {
+		reg.perServer[server]++
+	}
+	return st, nil
+}
+
+// subscribed records the console subscription a stream reads, for its
+// drop counts.
+func (reg *streamRegistry) subscribed(st *stream, ch chan sol.Chunk) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	st.ch = ch
+}
+
+func (reg *streamRegistry) close(st *stream) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.streams[st.info.ID]; !ok {
+		return
+	}
+	delete(reg.streams, st.info.ID)
+	if st.info.Server != "" {
+		if reg.perServer[st.info.Server]--; reg.perServer[st.info.Server] <= 0 {
+			delete(reg.perServer, st.info.Server)
+		}
+	}
+}
+
+// writeTimeout is how long one write to a stream may block before the
+// client is given up on; the eviction time, or none.
+func (reg *streamRegistry) writeTimeout() time.Duration {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.evictAfter
+}
+
+// openStream registers a stream for a request, writing a 503 problem when
+// a limit is reached. The caller must close the returned stream.
+func (s *Server) openStream(w http.ResponseWriter, r *http.Request, kind, server string) (*stream, bool) {
+	st, err := s.streams.open(kind, server, r)
+	if err != nil {
+		w.Header().Set("Retry-After", "10")
+		writeProblem(w, r, http.StatusServiceUnavailable, CodeTooManyStreams, err.Error())
+		return nil, false
+	}
+	return st, true
+}
+
+// handleStreams lists open streams with their clients and how well they
+// keep up, plus the limits and fleet-wide drop and eviction counts.
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	reg := s.streams
+	reg.mu.Lock()
+	report := StreamsReport{
+		Limits: StreamLimits{
+			MaxPerServer: reg.maxPerServer,
+			MaxTotal:     reg.maxTotal,
+			EvictAfter:   reg.evictAfter.Seconds(),
+		},
+		Open:     len(reg.streams),
+		Rejected: reg.rejected,
+		Streams:  []StreamInfo{},
+	}
+	var open []stream
+	for _, st := range reg.streams {
+		if st.info.Server == "" || serverAllowed(r, st.info.Server) {
+			open = append(open, *st)
+		}
+	}
+	reg.mu.Unlock()
+
+	for _, st := range open {
+		info := st.info
+		if st.ch != nil {
+			sub := s.solManager.SubscriberStats(info.Server, st.ch)
+			info.Dropped, info.SaturatedSince = sub.Dropped, sub.SaturatedSince
+		}
+		report.Streams = append(report.Streams, info)
+	}
+	sort.Slice(report.Streams, func(i, j int) bool { return report.Streams[i].ID < report.Streams[j].ID })
+
+	pipeline := s.solManager.GetPipelineStats()
+	report.Dropped, report.Evicted = pipeline.Dropped, pipeline.Evicted
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/sol"
+)
+
+// Timeline defaults: the range covers the last day, and SEL entries up to a
+// minute before a console event are linked to it.
+const (
+	timelineRange  = 24 * time.Hour
+	timelineWindow = time.Minute
+)
+
+// timelineEntry is one SEL record, console event or alert on a server's
+// timeline.
+type timelineEntry struct {
+	Time     time.Time     `json:"time"`
+	Source   string        `json:"source"` // sel, console or alert
+	Kind     string        `json:"kind"`
+	Message  string        `json:"message"`
+	Severity string        `json:"severity,omitempty"`
+	Excerpt  []string      `json:"excerpt,omitempty"`
+	SEL      *sol.SELEntry `json:"sel,omitempty"`
+	// Related lists SEL entries shortly before a console event or alert;
+	// Correlated marks SEL entries that are related to something
+	Related    []timelineRef `json:"related,omitempty"`
+	Correlated bool          `json:"correlated,omitempty"`
+}
+
+// timelineRef points at another entry by its index in the timeline.
+type timelineRef struct {
+	Index  int     `json:"index"`
+	Before float64 `json:"before"` // seconds
+}
+
+// handleTimeline interleaves a server's SEL entries with its console events
+// (boots, stages, milestones, errors, link changes) and alerts for a time range,
+// linking each boot, error, link loss and alert to the SEL entries that
+// preceded it.
+func (s *Server) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, exists := s.scanner.GetServers()[name]; !exists && s.solManager.GetSession(name) == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeServerNotFound, "Server not found")
+		return
+	}
+	q := r.URL.Query()
+
+	to := time.Now()
+	from := time.Time{}
+	window := timelineWindow
+	var err error
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "to must be an RFC 3339 time")
+			return
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "from must be an RFC 3339 time")
+			return
+		}
+	} else {
+		from = to.Add(-timelineRange)
+	}
+	if v := q.Get("window"); v != "" {
+		if window, err = time.ParseDuration(v); err != nil || window < 0 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "window must be a duration such as 30s")
+			return
+		}
+	}
+	if !from.Before(to) {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "from must be before to")
+		return
+	}
+
+	entries := make([]timelineEntry, 0)
+	add := func(e timelineEntry) {
+		if !e.Time.Before(from) && !e.Time.After(to) {
+			entries = append(entries, e)
+		}
+	}
+
+	for _, sel := range s.solManager.GetSEL(name) {
+		sel := sel
+		msg := sel.SensorType
+		if sel.Event != "" {
+			msg += ": " + sel.Event
+		}
+		if sel.Deasserted {
+			msg += " (deasserted)"
+		}
+		add(timelineEntry{Time: sel.Time, Source: "sel", Kind: "sel", Message: msg, SEL: &sel})
+	}
+
+	analytics := s.solManager.GetAnalytics(name)
+	boots := analytics.BootHistory
+	if analytics.CurrentBoot != nil {
+		boots = append(boots, *analytics.CurrentBoot)
+	}
+	for _, b := range boots {
+		if b.PowerCmdTime != nil {
+			add(timelineEntry{Time: *b.PowerCmdTime, Source: "console", Kind: "power_command", Message: "Power command sent"})
+		}
+		add(timelineEntry{Time: b.StartTime, Source: "console", Kind: "boot_start", Message: "Boot started"})
+		for _, st := range b.Stages {
+			if st.Name != sol.StageBIOS {
+				add(timelineEntry{Time: st.Start, Source: "console", Kind: "stage", Message: st.Name})
+			}
+		}
+		for _, m := range b.Milestones {
+			add(timelineEntry{Time: m.Time, Source: "console", Kind: "milestone", Message: m.Name})
+		}
+		for _, e := range b.Errors {
+			add(timelineEntry{Time: e.Time, Source: "console", Kind: "error", Message: e.Kind + ": " + e.Line})
+		}
+		for _, n := range b.NetworkEvents {
+			add(timelineEntry{Time: n.Time, Source: "console", Kind: "link_" + n.Event, Message: n.Interface + " link " + n.Event})
+		}
+		if b.Complete && !b.EndTime.IsZero() {
+			msg := fmt.Sprintf("Boot complete in %.0fs", b.BootDuration)
+			if b.DetectedOS != "" {
+				msg += " (" + b.DetectedOS + ")"
+			}
+			add(timelineEntry{Time: b.EndTime, Source: "console", Kind: "boot_complete", Message: msg})
+		}
+	}
+
+	if s.alerts != nil {
+		for _, a := range s.alerts.History(name, 0) {
+			add(timelineEntry{Time: a.Time, Source: "alert", Kind: a.Rule, Message: a.Message, Severity: a.Severity, Excerpt: a.Excerpt})
+		}
+	}
+
+	// SEL first on ties: the hardware event is the likely cause
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.Before(entries[j].Time)
+		}
+		return entries[i].Source == "sel" && entries[j].Source != "sel"
+	})
+	correlate(entries, window)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timelineResponse{name, from, to, window.String(), entries})
+}
+
+// timelineResponse is a server's timeline for a time range.
+type timelineResponse struct {
+	Server  string          `json:"server"`
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Window  string          `json:"window"`
+	Entries []timelineEntry `json:"entries"`
+}
+
+// correlate links alerts, console errors, boot starts and link losses to the
+// SEL entries within window before them. entries must be sorted by time.
+func correlate(entries []timelineEntry, window time.Duration) {
+	for i := range entries {
+		e := &entries[i]
+		if e.Source != "alert" && e.Kind != "error" && e.Kind != "boot_start" && e.Kind != "link_down" {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			before := e.Time.Sub(entries[j].Time)
+			if before > window {
+				break
+			}
+			if entries[j].Source != "sel" {
+				continue
+			}
+			e.Related = append(e.Related, timelineRef{Index: j, Before: before.Seconds()})
+			entries[j].Correlated = true
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+// selfSignedValidity is how long a generated certificate is valid for.
+const selfSignedValidity = 5 * 365 * 24 * time.Hour
+
+// SetTLS configures HTTPS. With self_signed and no cert/key files, the
+// certificate lives in <dataDir>/tls and is generated on first start.
+func (s *Server) SetTLS(cfg config.TLSConfig, dataDir string) {
+	if cfg.SelfSigned {
+		if cfg.CertFile == "" {
+			cfg.CertFile = filepath.Join(dataDir, "tls", "cert.pem")
+		}
+		if cfg.KeyFile == "" {
+			cfg.KeyFile = filepath.Join(dataDir, "tls", "key.pem")
+		}
+	}
+	s.tls = cfg
+}
+
+func (s *Server) tlsEnabled() bool {
+	return s.tls.CertFile != "" && s.tls.KeyFile != ""
+}
+
+// tlsConfig loads (or generates) the certificate and returns the listener
+// config.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.tls.SelfSigned {
+		if err := ensureSelfSigned(s.tls.CertFile, s.tls.KeyFile); err != nil {
+			return nil, fmt.Errorf("self-signed certificate: %w", err)
+		}
+	}
+	cl := &certLoader{certFile: s.tls.CertFile, keyFile: s.tls.KeyFile}
+	if err := cl.load(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cl.getCertificate,
+	}, nil
+}
+
+// certLoader serves the configured certificate, re-reading the files when
+// they change so renewed certificates are picked up without a restart.
+type certLoader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (cl *certLoader) load() error {
+	cert, err := tls.LoadX509KeyPair(cl.certFile, cl.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	cl.cert = &cert
+	cl.modTime = cl.filesModTime()
+	return nil
+}
+
+func (cl *certLoader) filesModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{cl.certFile, cl.keyFile} {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (cl *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if mt := cl.filesModTime(); mt.After(cl.modTime) {
+		// Keep serving the old certificate if the new pair is half-written
+		if err := cl.load(); err != nil {
+			log.Warnf("Keeping current TLS certificate: %v", err)
+			cl.modTime = mt
+		} else {
+			log.Infof("Reloaded TLS certificate from %s", cl.certFile)
+		}
+	}
+	return cl.cert, nil
+}
+
+// ensureSelfSigned generates an ECDSA certificate for this host unless the
+// files already exist.
+func ensureSelfSigned(certFile, keyFile string) error {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"ipmiserial"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" {
+		tmpl.DNSNames = append(tmpl.DNSNames, hostname)
+	}
+	// Cover every interface address so the UI can be reached by IP
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	log.Infof("Generated self-signed TLS certificate %s (%s, valid until %s)",
+		certFile, hostname, tmpl.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// redirectHandler sends plain HTTP requests to the HTTPS port.
+func (s *Server) redirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if s.port != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(s.port))
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ipmiserial/telemetry"
+)
+
+// statusWriter records the response status and size for tracing and the
+// access log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// streamRoutes are event streams, which stay open for the life of a viewer
+// and so are not traced.
+var streamRoutes = map[string]bool{
+	"/api/servers/{name}/stream": true,
+	"/api/servers/{name}/events": true,
+	"/api/servers/{name}/attach": true,
+	"/api/events":                true,
+}
+
+// tracingMiddleware wraps each API request in a server span named after its
+// route template (e.g. "GET /api/servers/{name}") and records its latency.
+// A caller's W3C traceparent header is honoured.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !telemetry.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := r.URL.Path
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tmpl, err := cur.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		if streamRoutes[canonicalRoute(route)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ctx, span := telemetry.StartServer(r.Context(), r.Method+" "+route, r.Header.Get("traceparent"),
+			telemetry.String("http.request.method", r.Method),
+			telemetry.String("http.route", route),
+			telemetry.String("url.path", r.URL.Path))
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttr(telemetry.Int("http.response.status_code", sw.status))
+		if sw.status >= 500 {
+			span.SetError(fmt.Errorf("%s", http.StatusText(sw.status)))
+		}
+		span.End()
+		telemetry.HTTPServerDuration.Observe(start,
+			telemetry.String("http.request.method", r.Method),
+			telemetry.String("http.route", route),
+			telemetry.Int("http.response.status_code", sw.status))
+	})
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// apiV1 prefixes the current API. The same routes are served under plain
+// /api as a deprecated alias; when a breaking change needs /api/v2, v1 and
+// the alias stay as they are.
+const apiV1 = "/api/v1"
+
+// apiVersion describes one API prefix for /api/versions.
+type apiVersion struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Status  string `json:"status"` // current or deprecated
+}
+
+var apiVersions = []apiVersion{
+	{Version: "v1", Path: apiV1, Status: "current"},
+	{Version: "unversioned", Path: "/api", Status: "deprecated"},
+}
+
+// canonicalRoute strips the version from an API path or route template, so
+// /api/v1/servers and its alias /api/servers are one route to auth,
+// exemptions, tracing and the OpenAPI document.
+func canonicalRoute(path string) string {
+	if rest, ok := strings.CutPrefix(path, apiV1); ok && (rest == "" || rest[0] == '/') {
+		return "/api" + rest
+	}
+	return path
+}
+
+// deprecatedAPI marks responses from the unversioned alias as deprecated
+// (draft-ietf-httpapi-deprecation-header) and links the v1 route to use
+// instead.
+func deprecatedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiV1+strings.TrimPrefix(r.URL.Path, "/api")+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAPIVersions lets clients find the API prefixes this server speaks
+// and which one to use.
+func (s *Server) handleAPIVersions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Current  string       `json:"current"`
+		Versions []apiVersion `json:"versions"`
+	}{"v1", apiVersions})
+}
@@ -24,19 +24,23 @@ function updateHash(server, tab) {
 
 async function fetchServers() {
     try {
-        const response = await fetch('/api/servers');
+        const response = await fetch('/api/v1/servers');
+        if (response.status === 401) {
+            promptForToken();
+            return;
+        }
         const newServers = await response.json();
 
-        // Check if server list changed
-        const serverNames = newServers.map(s => s.name).sort().join(',');
-        const oldServerNames = servers.map(s => s.name).sort().join(',');
+        // Check if server list or grouping changed
+        const serverNames = newServers.map(s => `${s.name}=${s.group || ''}`).sort().join(',');
+        const oldServerNames = servers.map(s => `${s.name}=${s.group || ''}`).sort().join(',');
 
         if (serverNames !== oldServerNames) {
-            servers = newServers.sort((a, b) => a.name.localeCompare(b.name));
+            servers = newServers.sort(compareServers);
             renderServerTabs();
         } else {
             // Just update status
-            servers = newServers.sort((a, b) => a.name.localeCompare(b.name));
+            servers = newServers.sort(compareServers);
             updateServerStatus();
         }
     } catch (error) {
@@ -44,6 +48,59 @@ async function fetchServers() {
     }
 }
 
+// Servers sort by group (server.group_label), ungrouped last, then by name
+function compareServers(a, b) {
+    const ga = a.group || '', gb = b.group || '';
+    if (ga !== gb) {
+        if (!ga) return 1;
+        if (!gb) return -1;
+        return ga.localeCompare(gb);
+    }
+    return a.name.localeCompare(b.name);
+}
+
+// Collapsed server groups, remembered across reloads
+const collapsedGroups = new Set(JSON.parse(localStorage.getItem('ipmiserial_collapsed_groups') || '[]'));
+
+function escapeAttr(s) {
+    return s.replace(/&/g, '&amp;').replace(/"/g, '&quot;').replace(/</g, '&lt;');
+}
+
+function toggleGroup(group) {
+    if (collapsedGroups.has(group)) {
+        collapsedGroups.delete(group);
+    } else {
+        collapsedGroups.add(group);
+    }
+    localStorage.setItem('ipmiserial_collapsed_groups', JSON.stringify([...collapsedGroups]));
+    applyGroupCollapse();
+}
+
+// Hide the tabs of collapsed groups, except the selected server's
+function applyGroupCollapse() {
+    document.querySelectorAll('#server-tabs .server-group').forEach(header => {
+        const collapsed = collapsedGroups.has(header.dataset.group);
+        header.classList.toggle('collapsed', collapsed);
+    });
+    document.querySelectorAll('#server-tabs .group-member').forEach(item => {
+        const hidden = collapsedGroups.has(item.dataset.group) && item.dataset.server !== currentServer;
+        item.style.display = hidden ? 'none' : '';
+    });
+}
+
+// When the API requires auth, ask once for a token and keep it in a cookie
+// so fetch, htmx and EventSource requests all carry it
+let tokenPrompted = false;
+function promptForToken() {
+    if (tokenPrompted) return;
+    tokenPrompted = true;
+    const token = window.prompt('This console server requires an API token:');
+    if (token) {
+        document.cookie = `ipmiserial_token=${encodeURIComponent(token)}; path=/; SameSite=Strict${location.protocol === 'https:' ? '; Secure' : ''}`;
+        window.location.reload();
+    }
+}
+
 function renderServerTabs() {
     const tabsContainer = document.getElementById('server-tabs');
     const contentContainer = document.getElementById('server-content');
@@ -54,9 +111,18 @@ function renderServerTabs() {
         return;
     }
 
-    // Build tabs
+    // Build tabs, under a collapsible header per group when servers are grouped
+    const grouped = servers.some(s => s.group);
+    const groupSizes = {};
+    servers.forEach(s => { groupSizes[s.group || ''] = (groupSizes[s.group || ''] || 0) + 1; });
     tabsContainer.innerHTML = servers.map((server, index) => `
-        <li class="nav-item">
+        ${grouped && (index === 0 || (servers[index - 1].group || '') !== (server.group || '')) ? `
+        <li class="nav-item server-group" data-group="${escapeAttr(server.group || '')}">
+            <a class="nav-link" href="#" onclick="toggleGroup(this.parentElement.dataset.group); return false;">
+                ${escapeAttr(server.group || 'ungrouped')} <span class="badge bg-secondary">${groupSizes[server.group || '']}</span>
+            </a>
+        </li>` : ''}
+        <li class="nav-item ${grouped ? 'group-member' : ''}" data-group="${escapeAttr(server.group || '')}" data-server="${server.name}">
             <a class="nav-link ${index === 0 ? 'active' : ''}"
                id="tab-${server.name}"
                href="#"
@@ -83,10 +149,17 @@ function renderServerTabs() {
                     </li>
                 </ul>
                 <div>
-                    <span id="status-${server.name}" class="badge ${server.connected ? 'bg-success' : (server.authError ? 'bg-warning' : 'bg-danger')} me-2">
-                        ${server.connected ? 'Connected' : (server.authError ? 'Auth Error' : 'Disconnected')}
+                    <span id="status-${server.name}" class="badge ${statusBadge(server).cls} me-2" title="${statusBadge(server).title}">
+                        ${statusBadge(server).text}
                     </span>
+                    <span id="input-${server.name}" class="badge bg-info text-dark me-2" style="${server.inputHolder ? '' : 'display: none;'}">
+                        ${server.inputOwned ? 'Input: you' : (server.inputHolder ? 'Input: ' + server.inputHolder : '')}
+                    </span>
+                    <span id="loop-${server.name}" class="badge bg-danger me-2" style="${server.rebootLooping ? '' : 'display: none;'}">Reboot loop</span>
+                    <button class="btn btn-outline-primary btn-sm me-1" id="input-btn-${server.name}" onclick="toggleInput('${server.name}')">${server.inputOwned ? 'Release Input' : 'Take Input'}</button>
                     <button class="btn btn-outline-warning btn-sm me-1" id="reconnect-${server.name}" onclick="reconnectServer('${server.name}')">Reconnect</button>
+                    <button class="btn btn-outline-danger btn-sm me-1" onclick="sendBreak('${server.name}')" title="Send a serial break">Break</button>
+                    <button class="btn btn-outline-danger btn-sm me-1" onclick="sendSysRq('${server.name}')" title="Send a magic SysRq key">SysRq</button>
                     <button class="btn btn-outline-info btn-sm me-1" onclick="copySelection('${server.name}')">Copy Selection</button>
                     <button class="btn btn-outline-secondary btn-sm" onclick="clearServerLogs('${server.name}')">Clear Logs</button>
                 </div>
@@ -162,23 +235,48 @@ function updateServerStatus() {
             }
         }
 
+        // Update input owner indicator
+        const inputBadge = document.getElementById(`input-${server.name}`);
+        if (inputBadge) {
+            inputBadge.style.display = server.inputHolder ? '' : 'none';
+            inputBadge.textContent = server.inputOwned ? 'Input: you' : `Input: ${server.inputHolder || ''}`;
+        }
+        const loopBadge = document.getElementById(`loop-${server.name}`);
+        if (loopBadge) {
+            loopBadge.style.display = server.rebootLooping ? '' : 'none';
+        }
+        const inputBtn = document.getElementById(`input-btn-${server.name}`);
+        if (inputBtn) {
+            inputBtn.textContent = server.inputOwned ? 'Release Input' : 'Take Input';
+        }
+
         // Update badge
         const badge = document.getElementById(`status-${server.name}`);
         if (badge) {
-            if (server.connected) {
-                badge.className = 'badge bg-success me-2';
-                badge.textContent = 'Connected';
-            } else if (server.authError) {
-                badge.className = 'badge bg-warning me-2';
-                badge.textContent = 'Auth Error';
-            } else {
-                badge.className = 'badge bg-danger me-2';
-                badge.textContent = 'Disconnected';
-            }
+            const status = statusBadge(server);
+            badge.className = `badge ${status.cls} me-2`;
+            badge.textContent = status.text;
+            badge.title = status.title;
         }
     });
 }
 
+// Connection badge for a server: its class, text and a tooltip with the
+// session state, so "retrying" reads differently from "never worked"
+function statusBadge(server) {
+    const attempts = server.attempts ? `, ${server.attempts} attempt${server.attempts === 1 ? '' : 's'}` : '';
+    const title = server.state ? `${server.state}${attempts}${server.lastConnected ? '' : ', never connected'}` : '';
+    if (server.connected) {
+        return { cls: server.state === 'degraded' ? 'bg-warning' : 'bg-success', text: server.state === 'degraded' ? 'Degraded' : 'Connected', title };
+    }
+    if (server.standby) return { cls: 'bg-secondary', text: 'Powered Off', title };
+    if (server.authError) return { cls: 'bg-warning', text: 'Auth Error', title };
+    if (server.state === 'queued') return { cls: 'bg-info text-dark', text: server.queuePosition ? `Queued #${server.queuePosition}` : 'Queued', title };
+    if (server.state === 'connecting' || server.state === 'handshaking') return { cls: 'bg-info text-dark', text: 'Connecting', title };
+    if (server.state === 'backoff') return { cls: 'bg-danger', text: server.lastConnected ? 'Retrying' : 'Never Connected', title };
+    return { cls: 'bg-danger', text: 'Disconnected', title };
+}
+
 function createTerminal() {
     const term = new Terminal({
         cursorBlink: false,
@@ -249,7 +347,7 @@ function initServerSession(name) {
     // Send keyboard input to SOL session
     term.onData((data) => {
         const encoded = btoa(data);
-        fetch(`/api/servers/${encodeURIComponent(name)}/input`, {
+        fetch(`/api/v1/servers/${encodeURIComponent(name)}/input`, {
             method: 'POST',
             body: encoded
         }).catch(() => {});
@@ -275,8 +373,12 @@ function startServerStream(name) {
         session.eventSource.close();
     }
 
-    // Always get catchup — server sends raw screen buffer for correct terminal state
-    const url = `/api/servers/${encodeURIComponent(name)}/stream`;
+    // Catchup is the raw screen buffer for correct terminal state; with the
+    // cursor of the last output seen, only what was missed since is sent
+    let url = `/api/v1/servers/${encodeURIComponent(name)}/stream`;
+    if (session.cursor) {
+        url += `?cursor=${encodeURIComponent(session.cursor)}`;
+    }
     const eventSource = new EventSource(url);
 
     eventSource.addEventListener('connected', (event) => {
@@ -289,6 +391,9 @@ function startServerStream(name) {
     });
 
     eventSource.onmessage = (event) => {
+        if (event.lastEventId) {
+            session.cursor = event.lastEventId;
+        }
         const decoded = atob(event.data);
         session.terminal.write(decoded);
     };
@@ -331,7 +436,7 @@ async function checkForNewLogs(serverName) {
     if (!session) return;
 
     try {
-        const response = await fetch(`/api/servers/${encodeURIComponent(serverName)}/logs`);
+        const response = await fetch(`/api/v1/servers/${encodeURIComponent(serverName)}/logs`);
         const logs = await response.json();
 
         if (logs && logs.length > 0) {
@@ -370,6 +475,7 @@ function selectServer(name) {
         tab.classList.remove('active');
     });
     document.getElementById(`tab-${name}`).classList.add('active');
+    applyGroupCollapse();
 
     // Update panel visibility
     document.querySelectorAll('#server-content .tab-pane').forEach(panel => {
@@ -377,7 +483,7 @@ function selectServer(name) {
     });
     document.getElementById(`panel-${name}`).classList.add('show', 'active');
 
-    // Start SSE stream with screen buffer catchup for correct terminal state
+    // Start SSE stream, resuming after the output this terminal already has
     startServerStream(name);
 
     // Refit the terminal
@@ -533,7 +639,7 @@ async function clearServerLogs(serverName) {
     if (!confirm(`Clear all logs for ${serverName}?`)) return;
 
     try {
-        await fetch(`/api/servers/${encodeURIComponent(serverName)}/logs/clear`, { method: 'POST' });
+        await fetch(`/api/v1/servers/${encodeURIComponent(serverName)}/logs/clear`, { method: 'POST' });
         // Reset state
         delete logState[serverName];
         document.getElementById(`log-content-${serverName}`).innerHTML =
@@ -549,7 +655,7 @@ async function clearAllLogs() {
     if (!confirm('Clear ALL logs for ALL servers?')) return;
 
     try {
-        await fetch('/api/logs/clear', { method: 'POST' });
+        await fetch('/api/v1/logs/clear', { method: 'POST' });
         // Reset state for all servers
         servers.forEach(server => {
             delete logState[server.name];
@@ -607,7 +713,7 @@ async function reconnectServer(serverName) {
     btn.disabled = true;
 
     try {
-        await fetch(`/api/servers/${encodeURIComponent(serverName)}/reconnect`, { method: 'POST' });
+        await fetch(`/api/v1/servers/${encodeURIComponent(serverName)}/reconnect`, { method: 'POST' });
     } catch (error) {
         console.error('Failed to reconnect:', error);
     }
@@ -618,6 +724,60 @@ async function reconnectServer(serverName) {
     }, 3000);
 }
 
+// Take or give up exclusive keyboard input. Other viewers keep watching but
+// their keystrokes are rejected while someone holds the console.
+async function toggleInput(serverName) {
+    const server = servers.find(s => s.name === serverName);
+    const action = server && server.inputOwned ? 'release' : 'acquire';
+    const url = `/api/v1/servers/${encodeURIComponent(serverName)}/input/${action}`;
+    try {
+        let response = await fetch(url, { method: 'POST' });
+        if (response.status === 409 && action === 'acquire') {
+            const problem = await response.json().catch(() => ({}));
+            if (!confirm(`${problem.detail || 'Input is held by another client'}. Take over?`)) return;
+            response = await fetch(url, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ force: true })
+            });
+        }
+        if (!response.ok) {
+            const problem = await response.json().catch(() => ({}));
+            alert(problem.detail || `Request failed (${response.status})`);
+        }
+    } catch (error) {
+        console.error(`Failed to ${action} input:`, error);
+    }
+    fetchServers();
+}
+
+async function sendBreak(serverName, sysrq) {
+    const options = { method: 'POST' };
+    if (sysrq) {
+        options.headers = { 'Content-Type': 'application/json' };
+        options.body = JSON.stringify({ sysrq });
+    }
+    try {
+        const response = await fetch(`/api/v1/servers/${encodeURIComponent(serverName)}/break`, options);
+        if (!response.ok) {
+            const problem = await response.json().catch(() => ({}));
+            alert(problem.detail || `Break failed (${response.status})`);
+        }
+    } catch (error) {
+        console.error('Failed to send break:', error);
+    }
+}
+
+function sendSysRq(serverName) {
+    const key = prompt('SysRq key (e.g. s = sync, u = remount ro, b = reboot, t = task dump, h = help):');
+    if (!key) return;
+    if (!/^[0-9a-z]$/.test(key)) {
+        alert('SysRq key must be a single character 0-9 or a-z');
+        return;
+    }
+    sendBreak(serverName, key);
+}
+
 function showCopyFeedback(btn) {
     const originalText = btn.textContent;
     btn.textContent = 'Copied!';
@@ -659,7 +819,7 @@ showSubTab = function(serverName, tab) {
 // Fetch and display version
 async function fetchVersion() {
     try {
-        const response = await fetch('/api/version');
+        const response = await fetch('/api/v1/version');
         const data = await response.json();
         document.getElementById('version-display').textContent = 'v' + data.version;
     } catch (error) {
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ipmiserial/events"
+)
+
+// SetWebhooks exposes webhook delivery status and tests.
+func (s *Server) SetWebhooks(w *events.Webhooks) {
+	s.webhooks = w
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	status := make([]events.WebhookStatus, 0)
+	if s.webhooks != nil {
+		status = s.webhooks.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		writeProblem(w, r, http.StatusNotFound, CodeWebhookNotFound, "Webhooks are not configured")
+		return
+	}
+	var body struct {
+		Webhook string `json:"webhook"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Webhook == "" {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "webhook is required")
+		return
+	}
+
+	if err := s.webhooks.Test(r.Context(), body.Webhook); err != nil {
+		if !s.webhooks.Has(body.Webhook) {
+			writeProblem(w, r, http.StatusNotFound, CodeWebhookNotFound, err.Error())
+			return
+		}
+		writeProblem(w, r, http.StatusBadGateway, CodeNotifyFailed, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,471 @@
+package sol
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/telemetry"
+)
+
+// actorQueueDepth is the number of chunks a server's actor buffers before
+// the SOL read loop waits for it.
+const actorQueueDepth = 4096
+
+type actorMsgKind int
+
+const (
+	msgData        actorMsgKind = iota // SOL output: broadcast, screen buffer, log, analytics
+	msgBanner                          // injected banner: broadcast and log only
+	msgReset                           // new SOL connection: clear viewers and the screen buffer
+	msgSubscribe                       // add a live subscriber, replying with its catchup
+	msgUnsubscribe                     // remove and close a live subscriber
+	msgSnapshot                        // reply with the screen buffer
+	msgSubStats                        // reply with a live subscriber's stats
+)
+
+type actorMsg struct {
+	kind   actorMsgKind
+	data   []byte
+	log    []byte
+	at     time.Time
+	ch     chan Chunk
+	cursor int64
+	reply  chan Catchup
+	stats  chan SubscriberStats
+}
+
+// Chunk is a piece of console output sent to live subscribers. Offset is the
+// screen buffer offset after it, or 0 for output that isn't part of the
+// screen buffer (banners and resets).
+type Chunk struct {
+	Data   []byte
+	Offset int64
+}
+
+// Catchup is what a subscriber replays on attaching: the screen buffer, or
+// when Resumed only the output after the subscriber's cursor. Offset is the
+// screen buffer offset it ends at.
+type Catchup struct {
+	Data    []byte
+	Offset  int64
+	Resumed bool
+}
+
+// subscriberDepth is the chunks buffered for each live subscriber; output
+// beyond it is dropped for that subscriber.
+const subscriberDepth = 64
+
+// subscriber is a live output channel and how well it keeps up.
+type subscriber struct {
+	ch        chan Chunk
+	dropped   uint64
+	fullSince time.Time // first drop since the last chunk it took; zero while keeping up
+}
+
+// SubscriberStats reports how well one live subscriber keeps up: chunks
+// dropped because its buffer was full, and since when it has been full.
+type SubscriberStats struct {
+	Dropped        uint64     `json:"dropped"`
+	SaturatedSince *time.Time `json:"saturatedSince,omitempty"`
+}
+
+// ActorStats reports one server actor's throughput and backlog.
+type ActorStats struct {
+	Queued      int    `json:"queued"`
+	Capacity    int    `json:"capacity"`
+	Subscribers int    `json:"subscribers"`
+	Submitted   uint64 `json:"submitted"`
+	Processed   uint64 `json:"processed"`
+	Blocked     uint64 `json:"blocked"` // submits that found the queue full and had to wait
+	Dropped     uint64 `json:"dropped"` // chunks dropped for subscribers that fell behind
+	Evicted     uint64 `json:"evicted"` // subscribers disconnected for staying saturated
+}
+
+// PipelineStats aggregates the console data path across all server actors.
+type PipelineStats struct {
+	Actors    int                   `json:"actors"`
+	Queued    int                   `json:"queued"`
+	Capacity  int                   `json:"capacity"`
+	Submitted uint64                `json:"submitted"`
+	Processed uint64                `json:"processed"`
+	Blocked   uint64                `json:"blocked"`
+	Dropped   uint64                `json:"dropped"`
+	Evicted   uint64                `json:"evicted"`
+	Servers   map[string]ActorStats `json:"servers"`
+}
+
+// ServerActor owns one server's console data path. A single goroutine
+// receives SOL chunks over a channel and, in arrival order, fans them out to
+// live subscribers, appends them to the screen buffer, writes the log and
+// runs analytics. Subscribers and the screen buffer are only touched by that
+// goroutine, so servers never contend with each other on the read path and
+// tearing a server down is a matter of closing its actor.
+type ServerActor struct {
+	name      string
+	logWriter LogWriter
+	analytics *Analytics
+	in        chan actorMsg
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Owned by the actor goroutine, except that the screen buffer locks
+	// itself for resizing and scrollback reads
+	screen *ScreenBuffer
+	subs   []*subscriber
+
+	evictAfter atomic.Int64 // nanoseconds a subscriber may stay saturated; 0 = never evict
+	subCount   atomic.Int32
+	submitted  atomic.Uint64
+	processed  atomic.Uint64
+	blocked    atomic.Uint64
+	dropped    atomic.Uint64
+	evicted    atomic.Uint64
+}
+
+// NewServerActor starts the actor for a server. logWriter and analytics may
+// be nil.
+func NewServerActor(name string, logWriter LogWriter, analytics *Analytics, depth int) *ServerActor {
+	a := &ServerActor{
+		name:      name,
+		logWriter: logWriter,
+		analytics: analytics,
+		in:        make(chan actorMsg, depth),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		screen:    NewScreenBuffer(defaultScreenBufSize),
+	}
+	go a.run()
+	return a
+}
+
+func (a *ServerActor) run() {
+	defer close(a.done)
+	for {
+		select {
+		case msg := <-a.in:
+			a.handle(msg)
+		case <-a.quit:
+			// Finish what was queued before the close
+			for {
+				select {
+				case msg := <-a.in:
+					a.handle(msg)
+				default:
+					for _, sub := range a.subs {
+						close(sub.ch)
+					}
+					a.subs = nil
+					a.subCount.Store(0)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *ServerActor) handle(msg actorMsg) {
+	switch msg.kind {
+	case msgData:
+		a.screen.Write(msg.data)
+		a.broadcast(Chunk{Data: msg.data, Offset: a.screen.Offset()})
+		var pos *LogPosition
+		if a.logWriter != nil {
+			pos = &LogPosition{}
+			pos.File, pos.Offset, pos.Line = a.logWriter.Position(a.name)
+			start := time.Now()
+			a.logWriter.Write(a.name, msg.data)
+			telemetry.LogWriteDuration.Observe(start, telemetry.String("server", a.name))
+		}
+		a.analyze(msg, pos)
+		a.processed.Add(1)
+	case msgBanner:
+		a.broadcast(Chunk{Data: msg.data})
+		if a.logWriter != nil && msg.log != nil {
+			a.logWriter.WriteNote(a.name, msg.log)
+		}
+	case msgReset:
+		a.broadcast(Chunk{Data: msg.data})
+		a.screen.Reset()
+	case msgSubscribe:
+		a.subs = append(a.subs, &subscriber{ch: msg.ch})
+		a.subCount.Store(int32(len(a.subs)))
+		msg.reply <- a.catchup(msg.cursor)
+	case msgUnsubscribe:
+		// An evicted subscriber is already gone and closed
+		for i, sub := range a.subs {
+			if sub.ch == msg.ch {
+				a.subs = append(a.subs[:i], a.subs[i+1:]...)
+				close(msg.ch)
+				break
+			}
+		}
+		a.subCount.Store(int32(len(a.subs)))
+	case msgSnapshot:
+		msg.reply <- Catchup{Data: a.screen.Bytes(), Offset: a.screen.Offset()}
+	case msgSubStats:
+		var st SubscriberStats
+		for _, sub := range a.subs {
+			if sub.ch == msg.ch {
+				st.Dropped = sub.dropped
+				if !sub.fullSince.IsZero() {
+					since := sub.fullSince
+					st.SaturatedSince = &since
+				}
+				break
+			}
+		}
+		msg.stats <- st
+	}
+}
+
+// catchup returns the output after cursor if the screen buffer still holds
+// it, else the newest catchupSize bytes; older output is left to the
+// scrollback API. A negative cursor never resumes.
+func (a *ServerActor) catchup(cursor int64) Catchup {
+	offset := a.screen.Offset()
+	if cursor >= 0 {
+		if data, ok := a.screen.Since(cursor); ok {
+			return Catchup{Data: data, Offset: offset, Resumed: true}
+		}
+	}
+	return Catchup{Data: a.screen.Tail(catchupSize), Offset: offset}
+}
+
+func (a *ServerActor) analyze(msg actorMsg, pos *LogPosition) {
+	if a.analytics == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Analytics panic for %s: %v", a.name, r)
+		}
+	}()
+	a.analytics.ProcessTextAt(a.name, string(msg.data), msg.at, pos)
+}
+
+// broadcast sends a chunk to every live subscriber without waiting: a
+// subscriber whose buffer is full misses it. One that has stayed full for
+// the eviction time is closed, ending its stream, so a stuck viewer doesn't
+// hold its goroutine and channel forever; a browser reconnects and resumes
+// from the screen buffer.
+func (a *ServerActor) broadcast(chunk Chunk) {
+	var now time.Time
+	evictAfter := time.Duration(a.evictAfter.Load())
+	kept := a.subs[:0]
+	for _, sub := range a.subs {
+		select {
+		case sub.ch <- chunk:
+			sub.fullSince = time.Time{}
+		default:
+			if now.IsZero() {
+				now = time.Now()
+			}
+			sub.dropped++
+			a.dropped.Add(1)
+			if sub.fullSince.IsZero() {
+				sub.fullSince = now
+			} else if evictAfter > 0 && now.Sub(sub.fullSince) >= evictAfter {
+				log.Warnf("Evicting a %s viewer saturated for %v (%d chunks dropped)", a.name, now.Sub(sub.fullSince).Round(time.Second), sub.dropped)
+				close(sub.ch)
+				a.evicted.Add(1)
+				continue
+			}
+		}
+		kept = append(kept, sub)
+	}
+	clear(a.subs[len(kept):])
+	a.subs = kept
+	a.subCount.Store(int32(len(a.subs)))
+}
+
+// send queues a message, waiting if the queue is full. It returns false once
+// the actor has been closed.
+func (a *ServerActor) send(msg actorMsg) bool {
+	select {
+	case <-a.quit:
+		return false
+	default:
+	}
+	select {
+	case a.in <- msg:
+		return true
+	default:
+	}
+	a.blocked.Add(1)
+	select {
+	case a.in <- msg:
+		return true
+	case <-a.quit:
+		return false
+	}
+}
+
+// Submit queues a chunk of SOL output, stamped with the current time.
+func (a *ServerActor) Submit(data []byte) {
+	a.submitted.Add(1)
+	a.send(actorMsg{kind: msgData, data: data, at: time.Now()})
+}
+
+// Banner broadcasts data to live viewers and writes logLine (if non-nil) to
+// the log, without adding either to the screen buffer.
+func (a *ServerActor) Banner(data, logLine []byte) {
+	a.send(actorMsg{kind: msgBanner, data: data, log: logLine})
+}
+
+// Reset clears live viewers' screens and the screen buffer.
+func (a *ServerActor) Reset() {
+	a.send(actorMsg{kind: msgReset, data: []byte("\x1b[2J\x1b[H")})
+}
+
+// Attach subscribes to live output and returns the screen buffer as it was
+// at that point, so a viewer can replay it without gaps or duplicates. A
+// viewer that has seen output up to cursor (a Chunk or Catchup offset) gets
+// only what followed, if the screen buffer still holds it; pass -1 for the
+// whole buffer. The channel is closed by Unsubscribe or when the actor shuts
+// down.
+func (a *ServerActor) Attach(cursor int64) (Catchup, chan Chunk) {
+	ch := make(chan Chunk, subscriberDepth)
+	reply := make(chan Catchup, 1)
+	if !a.send(actorMsg{kind: msgSubscribe, ch: ch, cursor: cursor, reply: reply}) {
+		close(ch)
+		return Catchup{}, ch
+	}
+	select {
+	case catchup := <-reply:
+		return catchup, ch
+	case <-a.done:
+		return Catchup{}, ch
+	}
+}
+
+func (a *ServerActor) Unsubscribe(ch chan Chunk) {
+	a.send(actorMsg{kind: msgUnsubscribe, ch: ch})
+}
+
+// SubscriberStats returns how well the subscriber on ch keeps up. An
+// unknown or evicted subscriber reports zero.
+func (a *ServerActor) SubscriberStats(ch chan Chunk) SubscriberStats {
+	reply := make(chan SubscriberStats, 1)
+	if !a.send(actorMsg{kind: msgSubStats, ch: ch, stats: reply}) {
+		return SubscriberStats{}
+	}
+	select {
+	case st := <-reply:
+		return st
+	case <-a.done:
+		return SubscriberStats{}
+	}
+}
+
+// Snapshot returns a copy of the screen buffer, or nil once closed.
+func (a *ServerActor) Snapshot() []byte {
+	reply := make(chan Catchup, 1)
+	if !a.send(actorMsg{kind: msgSnapshot, reply: reply}) {
+		return nil
+	}
+	select {
+	case snapshot := <-reply:
+		return snapshot.Data
+	case <-a.done:
+		return nil
+	}
+}
+
+// Close stops the actor after it has processed everything already queued,
+// and closes all subscriber channels.
+func (a *ServerActor) Close() {
+	a.closeOnce.Do(func() { close(a.quit) })
+	<-a.done
+}
+
+func (a *ServerActor) Stats() ActorStats {
+	return ActorStats{
+		Queued:      len(a.in),
+		Capacity:    cap(a.in),
+		Subscribers: int(a.subCount.Load()),
+		Submitted:   a.submitted.Load(),
+		Processed:   a.processed.Load(),
+		Blocked:     a.blocked.Load(),
+		Dropped:     a.dropped.Load(),
+		Evicted:     a.evicted.Load(),
+	}
+}
+
+// actor returns the server's actor, starting one if needed. An actor lives
+// until the server is removed, so callers check the name is a known server.
+func (m *Manager) actor(serverName string) *ServerActor {
+	m.actorMu.Lock()
+	defer m.actorMu.Unlock()
+	a := m.actors[serverName]
+	if a == nil {
+		a = NewServerActor(serverName, m.logWriter, m.analytics, actorQueueDepth)
+		a.evictAfter.Store(int64(m.evictAfter.Load()))
+		a.screen.Resize(m.scrollbackForLocked(serverName))
+		m.actors[serverName] = a
+	}
+	return a
+}
+
+func (m *Manager) existingActor(serverName string) *ServerActor {
+	m.actorMu.Lock()
+	defer m.actorMu.Unlock()
+	return m.actors[serverName]
+}
+
+// removeActor shuts down a server's actor, disconnecting its viewers.
+func (m *Manager) removeActor(serverName string) {
+	m.actorMu.Lock()
+	a := m.actors[serverName]
+	delete(m.actors, serverName)
+	m.actorMu.Unlock()
+	if a != nil {
+		a.Close()
+	}
+}
+
+func (m *Manager) GetPipelineStats() PipelineStats {
+	m.actorMu.Lock()
+	actors := make(map[string]*ServerActor, len(m.actors))
+	for name, a := range m.actors {
+		actors[name] = a
+	}
+	m.actorMu.Unlock()
+
+	st := PipelineStats{Actors: len(actors), Servers: make(map[string]ActorStats, len(actors))}
+	for name, a := range actors {
+		as := a.Stats()
+		st.Servers[name] = as
+		st.Queued += as.Queued
+		st.Capacity += as.Capacity
+		st.Submitted += as.Submitted
+		st.Processed += as.Processed
+		st.Blocked += as.Blocked
+		st.Dropped += as.Dropped
+		st.Evicted += as.Evicted
+	}
+	return st
+}
+
+// SetSlowViewerEviction sets how long a live subscriber may stay too far
+// behind to take any output before it is disconnected; 0 never evicts.
+func (m *Manager) SetSlowViewerEviction(d time.Duration) {
+	m.actorMu.Lock()
+	defer m.actorMu.Unlock()
+	m.evictAfter.Store(int64(max(d, 0)))
+	for _, a := range m.actors {
+		a.evictAfter.Store(int64(max(d, 0)))
+	}
+}
+
+// SubscriberStats returns how well a server's live subscriber on ch keeps
+// up with its output.
+func (m *Manager) SubscriberStats(serverName string, ch chan Chunk) SubscriberStats {
+	if a := m.existingActor(serverName); a != nil {
+		return a.SubscriberStats(ch)
+	}
+	return SubscriberStats{}
+}
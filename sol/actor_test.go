@@ -0,0 +1,147 @@
+package sol_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/logs"
+	"ipmiserial/sol"
+)
+
+// bootStorm is synthetic boot output — BIOS, iPXE, kernel and systemd — for
+// exercising the console read path.
+var bootStorm = []string{
+	"American Megatrends Version 2.20.1271. Copyright (C) 2020 American Megatrends, Inc.\r\n",
+	"Press <DEL> to run Setup\r\n",
+	"Intel(R) Boot Agent GE v1.5.89\r\nCLIENT MAC ADDR: 00 25 90 AA BB CC\r\n",
+	"iPXE initialising devices...ok\r\niPXE 1.21.1+ -- Open Source Network Boot Firmware\r\n",
+	"http://10.0.0.1/coreos-kernel... ok\r\nhttp://10.0.0.1/coreos-initramfs... ok\r\n",
+	"[    0.000000] Linux version 6.5.6-300.fc39.x86_64 (mockbuild@) (gcc 13.2.1)\r\n",
+	"[    1.234567] systemd[1]: systemd 254.5-2.fc39 running in system mode (+PAM +AUDIT)\r\n",
+	"[  OK  ] Started Journal Service.\r\n[  OK  ] Reached target Local File Systems.\r\n",
+	"[    5.111111] eth0: link up, 10000Mbps, full-duplex\r\n",
+	"Welcome to Fedora CoreOS 39.20231101.3.0!\r\n",
+	"[  OK  ] Started OpenSSH server daemon.\r\n",
+	"node1 login: ",
+}
+
+// memLog is a LogWriter that keeps each server's log in memory.
+type memLog struct {
+	mu   sync.Mutex
+	data map[string]*bytes.Buffer
+}
+
+func (l *memLog) Write(serverName string, data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.data == nil {
+		l.data = make(map[string]*bytes.Buffer)
+	}
+	if l.data[serverName] == nil {
+		l.data[serverName] = &bytes.Buffer{}
+	}
+	l.data[serverName].Write(data)
+	return nil
+}
+
+func (l *memLog) WriteNote(serverName string, data []byte) error { return l.Write(serverName, data) }
+func (l *memLog) Rotate(string) error                            { return nil }
+func (l *memLog) CanRotate(string) bool                          { return false }
+func (l *memLog) Position(string) (string, int64, int)           { return "", 0, 0 }
+
+func (l *memLog) String(serverName string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.data[serverName] == nil {
+		return ""
+	}
+	return l.data[serverName].String()
+}
+
+// TestActorLossless floods an actor with a one-slot queue: Submit must wait
+// rather than drop, and every chunk must reach the log in order.
+func TestActorLossless(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	w := &memLog{}
+	a := sol.NewServerActor("node1", w, sol.NewAnalytics(""), 1)
+
+	var want bytes.Buffer
+	for i := 0; i < 5000; i++ {
+		chunk := fmt.Sprintf("%04d %s", i, bootStorm[i%len(bootStorm)])
+		want.WriteString(chunk)
+		a.Submit([]byte(chunk))
+	}
+	a.Close()
+
+	st := a.Stats()
+	if st.Submitted != 5000 || st.Processed != 5000 {
+		t.Errorf("Stats: %d submitted, %d processed; want 5000, 5000", st.Submitted, st.Processed)
+	}
+	if got := w.String("node1"); got != want.String() {
+		t.Errorf("log has %d bytes, want %d in submission order", len(got), want.Len())
+	}
+}
+
+// BenchmarkReadPath times the per-chunk work a SOL read loop does for many
+// servers booting at once: the log write and analytics run inline, against
+// handing the chunk to the server's actor. Each parallel goroutine is one
+// server. Chunks are submitted back to back, so once an actor's queue fills
+// its Submit waits and the figure becomes sustained throughput; blocked/op
+// shows how often that happened.
+//
+//	go test -run '^$' -bench ReadPath -cpu 32 ./sol
+func BenchmarkReadPath(b *testing.B) {
+	log.SetLevel(log.ErrorLevel)
+	for _, useActors := range []bool{false, true} {
+		name := "inline"
+		if useActors {
+			name = "actors"
+		}
+		b.Run(name, func(b *testing.B) {
+			writer := logs.NewWriter(b.TempDir(), 0, 0, 0)
+			defer writer.Close()
+			analytics := sol.NewAnalytics("")
+
+			var mu sync.Mutex
+			var actors []*sol.ServerActor
+			var servers atomic.Int32
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				server := fmt.Sprintf("server%02d", servers.Add(1))
+				var actor *sol.ServerActor
+				if useActors {
+					actor = sol.NewServerActor(server, writer, analytics, 4096)
+					mu.Lock()
+					actors = append(actors, actor)
+					mu.Unlock()
+				}
+				for i := 0; pb.Next(); i++ {
+					text := bootStorm[i%len(bootStorm)]
+					if useActors {
+						actor.Submit([]byte(text))
+					} else {
+						writer.Write(server, []byte(text))
+						analytics.ProcessText(server, text)
+					}
+				}
+			})
+			b.StopTimer()
+
+			var blocked uint64
+			for _, a := range actors {
+				a.Close()
+				blocked += a.Stats().Blocked
+			}
+			if useActors {
+				b.ReportMetric(float64(blocked)/float64(b.N), "blocked/op")
+			}
+		})
+	}
+}
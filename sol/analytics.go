@@ -1,9 +1,8 @@
 package sol
 
 import (
-	"encoding/json"
-	"os"
-	"path/filepath"
+	"fmt"
+	"maps"
 	"regexp"
 	"strings"
 	"sync"
@@ -40,11 +39,28 @@ type BootEvent struct {
 	BootDuration  float64         `json:"bootDuration,omitempty"`    // seconds
 	PowerOnDelay  float64         `json:"powerOnDelay,omitempty"`    // seconds from rotation to first console output
 	RotationTime  *time.Time      `json:"rotationTime,omitempty"`   // when log rotation triggered this boot
+	PowerCmdDelay float64         `json:"powerCmdDelay,omitempty"`   // seconds from power command to first console output
+	PowerCmdTime  *time.Time      `json:"powerCmdTime,omitempty"`   // when a power on/cycle/reset command was sent
 	Complete      bool            `json:"complete"`
 	DetectedOS    string          `json:"detectedOS,omitempty"`
 	Milestones    []BootMilestone `json:"milestones,omitempty"`
+	Stages        []BootStage     `json:"stages,omitempty"`        // BIOS/POST, PXE, bootloader, kernel, initrd, systemd, login
 	NetworkEvents []NetworkEvent  `json:"networkEvents,omitempty"`
 	NetworkStats  []NetworkStats  `json:"networkStats,omitempty"`
+	Errors        []ErrorEvent    `json:"errors,omitempty"`
+	ErrorCounts   ErrorCounts     `json:"errorCounts"`
+	Anomalies     *AnomalySummary `json:"anomalies,omitempty"` // failed units, I/O errors, link flaps, firmware warnings
+	LogStart      *LogPosition    `json:"logStart,omitempty"`  // where the boot's console output starts in the log
+	LogEnd        *LogPosition    `json:"logEnd,omitempty"`    // where the next boot's starts; unset for the current boot
+}
+
+// LogPosition is a place in a server's console log. The log is written as
+// the console finishes lines, so a boot's position can be off by the line
+// that was being drawn when it started.
+type LogPosition struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"` // bytes into File
+	Line   int    `json:"line"`   // lines in File before Offset
 }
 
 type ServerAnalytics struct {
@@ -56,43 +72,64 @@ type ServerAnalytics struct {
 	TotalReboots  int          `json:"totalReboots"`
 	CurrentOS     string       `json:"currentOS,omitempty"`
 	Hostname      string       `json:"hostname,omitempty"`
+	PowerOnDegraded bool       `json:"powerOnDegraded,omitempty"` // latest power-on delay well above this server's median
+	Software      []SoftwareComponent `json:"software,omitempty"`
+	Inventory     map[string]InventoryItem `json:"inventory,omitempty"` // BIOS, boot firmware, NICs and GPUs seen during POST and boot
+	ErrorCounts   ErrorCounts  `json:"errorCounts"` // panics, oopses, OOM kills and machine checks across all boots
+	RebootLooping   bool       `json:"rebootLooping,omitempty"`   // reboot_detection.loop_reboots boots within loop_window, until a boot reaches the OS
+	RebootLoopSince *time.Time `json:"rebootLoopSince,omitempty"` // start of the first boot of the loop
 
 	// Unexported: pending rotation tracking
 	pendingRotation *time.Time `json:"-"`
 	rotationDelay   float64    `json:"-"` // computed when first console output arrives
 	rotationTime    *time.Time `json:"-"` // carried until BIOS creates new boot event
+	pendingPowerCmd *time.Time `json:"-"`
+	powerCmdDelay   float64    `json:"-"`
+	powerCmdTime    *time.Time `json:"-"`
+	lastError       map[string]time.Time `json:"-"` // kind -> last recorded, for collapsing
 }
 
 type osDetector struct {
 	name    string
-	pattern *regexp.Regexp
+	pattern *matcher
 }
 
 type milestoneDetector struct {
 	name     string
-	pattern  *regexp.Regexp
+	pattern  *matcher
 	repeats  bool // true = count each occurrence (e.g. GRUB boot)
 }
 
 type Analytics struct {
 	servers             map[string]*ServerAnalytics
-	biosPatterns        []*regexp.Regexp
-	osPatterns          []*regexp.Regexp
+	biosPatterns        *patternSet
+	osPatterns          *patternSet
 	osDetectors         []osDetector
 	milestoneDetectors  []milestoneDetector
-	hostPattern         *regexp.Regexp
-	netUpPattern        *regexp.Regexp
-	netDownPattern      *regexp.Regexp
+	hostPattern         *matcher
+	netUpPattern        *matcher
+	netDownPattern      *matcher
 	dataPath            string
+	bootHistory         int             // boots kept per server, besides the current one
+	loopReboots         int             // boots within loopWindow that make a reboot loop (0 = off)
+	loopWindow          time.Duration
+	dirty               map[string]bool // servers changed since the last flush
 	mu                  sync.RWMutex
+	flushMu             sync.Mutex      // serializes flushes with forget
+
+	// onEvent receives detected events after a.mu is released. Set once by
+	// the Manager before any text is processed.
+	onEvent func(AnalyticsEvent)
+	// onText receives every chunk of ANSI-stripped console text, in order
+	onText func(serverName, text string, at time.Time)
 }
 
 func NewAnalytics(dataPath string) *Analytics {
 	a := &Analytics{
 		servers:      make(map[string]*ServerAnalytics),
-		biosPatterns: make([]*regexp.Regexp, 0),
-		osPatterns:   make([]*regexp.Regexp, 0),
 		dataPath:     dataPath,
+		bootHistory:  defaultBootHistory,
+		dirty:        make(map[string]bool),
 	}
 
 	// Load existing data
@@ -131,17 +168,8 @@ func NewAnalytics(dataPath string) *Analytics {
 		`anaconda.*started`,
 	}
 
-	for _, p := range biosPatterns {
-		if re, err := regexp.Compile("(?i)" + p); err == nil {
-			a.biosPatterns = append(a.biosPatterns, re)
-		}
-	}
-
-	for _, p := range osPatterns {
-		if re, err := regexp.Compile("(?i)" + p); err == nil {
-			a.osPatterns = append(a.osPatterns, re)
-		}
-	}
+	a.biosPatterns, _ = newPatternSet("(?i)", biosPatterns)
+	a.osPatterns, _ = newPatternSet("(?i)", osPatterns)
 
 	// OS/Image detection patterns
 	osDetectors := []struct {
@@ -167,7 +195,7 @@ func NewAnalytics(dataPath string) *Analytics {
 	}
 
 	for _, d := range osDetectors {
-		if re, err := regexp.Compile("(?i)" + d.pattern); err == nil {
+		if re, err := newMatcher("(?i)" + d.pattern); err == nil {
 			a.osDetectors = append(a.osDetectors, osDetector{
 				name:    d.name,
 				pattern: re,
@@ -189,7 +217,7 @@ func NewAnalytics(dataPath string) *Analytics {
 		{"Login Ready", `login:\s*$`, false},
 	}
 	for _, m := range milestones {
-		if re, err := regexp.Compile("(?i)" + m.pattern); err == nil {
+		if re, err := newMatcher("(?i)" + m.pattern); err == nil {
 			a.milestoneDetectors = append(a.milestoneDetectors, milestoneDetector{
 				name:    m.name,
 				pattern: re,
@@ -199,23 +227,47 @@ func NewAnalytics(dataPath string) *Analytics {
 	}
 
 	// Hostname detection pattern (common login prompts)
-	a.hostPattern = regexp.MustCompile(`(?m)^([a-zA-Z0-9][a-zA-Z0-9\-]{0,62}) login:`)
+	a.hostPattern = mustMatcher(`(?m)^([a-zA-Z0-9][a-zA-Z0-9\-]{0,62}) login:`)
 
 	// Network interface up/down patterns
 	// Common patterns: "eth0: link up", "enp0s31f6: link down", "NIC Link is Up", etc.
-	a.netUpPattern = regexp.MustCompile(`(?i)([a-z]{2,}[0-9]+[a-z0-9]*):?\s+(?:link\s+)?(?:is\s+)?up|NIC Link is Up.*?([a-z]{2,}[0-9]+)`)
-	a.netDownPattern = regexp.MustCompile(`(?i)([a-z]{2,}[0-9]+[a-z0-9]*):?\s+(?:link\s+)?(?:is\s+)?down|NIC Link is Down.*?([a-z]{2,}[0-9]+)`)
+	a.netUpPattern = mustMatcher(`(?i)([a-z]{2,}[0-9]+[a-z0-9]*):?\s+(?:link\s+)?(?:is\s+)?up|NIC Link is Up.*?([a-z]{2,}[0-9]+)`)
+	a.netDownPattern = mustMatcher(`(?i)([a-z]{2,}[0-9]+[a-z0-9]*):?\s+(?:link\s+)?(?:is\s+)?down|NIC Link is Down.*?([a-z]{2,}[0-9]+)`)
 
 	return a
 }
 
 func (a *Analytics) ProcessText(serverName, text string) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+	a.ProcessTextAt(serverName, text, time.Now(), nil)
+}
 
+// ProcessTextAt processes console text that arrived at the given time.
+// Server actors use it so queued chunks keep their arrival timestamps.
+// logPos is where the text starts in the server's log, or nil when it
+// isn't logged; boots record it as their log boundaries.
+func (a *Analytics) ProcessTextAt(serverName, text string, now time.Time, logPos *LogPosition) {
 	// Strip ANSI escape codes so pattern matching works on terminal output
 	// with embedded color/cursor sequences (systemd, Fedora installer, etc.)
-	text = ansiStripRegex.ReplaceAllString(text, "")
+	if strings.IndexByte(text, 0x1b) >= 0 {
+		text = ansiStripRegex.ReplaceAllString(text, "")
+	}
+	if a.onText != nil {
+		a.onText(serverName, text, now)
+	}
+
+	// Run all patterns before taking the lock so actors for
+	// different servers can match in parallel
+	tm := a.match(text)
+
+	// Events are dispatched once the lock is released
+	var events []AnalyticsEvent
+	emit := func(typ, detail string) {
+		events = append(events, AnalyticsEvent{Server: serverName, Type: typ, Time: now, Detail: detail})
+	}
+	defer func() { a.dispatch(events) }()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
 	server, exists := a.servers[serverName]
 	if !exists {
@@ -226,32 +278,40 @@ func (a *Analytics) ProcessText(serverName, text string) {
 		a.servers[serverName] = server
 	}
 
-	server.LastSeen = time.Now()
+	server.LastSeen = now
 	changed := false
 
 	// Consume pending rotation on first console output after rotation
-	if server.pendingRotation != nil {
-		server.rotationDelay = time.Since(*server.pendingRotation).Seconds()
+	if server.pendingRotation != nil && !now.Before(*server.pendingRotation) {
+		server.rotationDelay = now.Sub(*server.pendingRotation).Seconds()
 		server.rotationTime = server.pendingRotation
 		server.pendingRotation = nil
 		log.Infof("Power-on delay for %s: %.1fs", serverName, server.rotationDelay)
 	}
+	if server.pendingPowerCmd != nil && !now.Before(*server.pendingPowerCmd) {
+		server.powerCmdDelay = now.Sub(*server.pendingPowerCmd).Seconds()
+		server.powerCmdTime = server.pendingPowerCmd
+		server.pendingPowerCmd = nil
+		log.Infof("Power command delay for %s: %.1fs", serverName, server.powerCmdDelay)
+	}
 
 	// Check for BIOS (boot start)
-	if a.matchesBIOS(text) {
+	if tm.bios {
 		log.Debugf("BIOS detected for %s, CurrentBoot=%v", serverName, server.CurrentBoot != nil)
 		// If we have a current boot, this is a reboot - archive the old boot
 		if server.CurrentBoot != nil {
 			// Only archive if the boot has been running for more than 30 seconds
 			// This prevents multiple BIOS messages in same boot from creating duplicates
-			elapsed := time.Since(server.CurrentBoot.StartTime)
+			elapsed := now.Sub(server.CurrentBoot.StartTime)
 			log.Debugf("Existing boot elapsed: %v", elapsed)
 			if elapsed > 30*time.Second {
 				log.Debugf("Archiving previous boot for %s (was complete=%v)", serverName, server.CurrentBoot.Complete)
+				summarizeAnomalies(server.CurrentBoot)
+				server.CurrentBoot.LogEnd = logPos
 				server.BootHistory = append(server.BootHistory, *server.CurrentBoot)
-				// Keep only last 10 boots
-				if len(server.BootHistory) > 10 {
-					server.BootHistory = server.BootHistory[1:]
+				// Keep only the configured number of boots
+				if n := len(server.BootHistory) - a.bootHistory; n > 0 {
+					server.BootHistory = server.BootHistory[n:]
 				}
 				server.CurrentBoot = nil
 				server.OSUpSince = nil
@@ -263,9 +323,11 @@ func (a *Analytics) ProcessText(serverName, text string) {
 		if server.CurrentBoot == nil {
 			log.Infof("Starting new boot tracking for %s, TotalReboots will be %d", serverName, server.TotalReboots+1)
 			server.CurrentBoot = &BootEvent{
-				StartTime: time.Now(),
+				StartTime: now,
 				Complete:  false,
+				LogStart:  logPos,
 			}
+			startStages(server.CurrentBoot)
 			// Apply rotation data if available
 			if server.rotationTime != nil {
 				server.CurrentBoot.RotationTime = server.rotationTime
@@ -273,60 +335,121 @@ func (a *Analytics) ProcessText(serverName, text string) {
 				server.rotationTime = nil
 				server.rotationDelay = 0
 			}
+			if server.powerCmdTime != nil {
+				server.CurrentBoot.PowerCmdTime = server.powerCmdTime
+				server.CurrentBoot.PowerCmdDelay = server.powerCmdDelay
+				server.powerCmdTime = nil
+				server.powerCmdDelay = 0
+			}
+			if detail := a.checkPowerOnDegradation(server); detail != "" {
+				emit(EventPowerOnDegraded, detail)
+			}
 			server.TotalReboots++
 			changed = true
+			emit(EventBootStart, "")
+			if detail := a.checkRebootLoop(server, now); detail != "" {
+				emit(EventRebootLoop, detail)
+			}
+		}
+	}
+
+	// Track boot stages
+	if server.CurrentBoot != nil {
+		for _, name := range trackStages(server.CurrentBoot, tm.stages, now) {
+			changed = true
+			emit(EventStage, name)
 		}
 	}
 
 	// Check for OS up (boot complete)
-	if a.matchesOS(text) {
+	if tm.os {
 		if server.CurrentBoot != nil && !server.CurrentBoot.Complete {
-			server.CurrentBoot.EndTime = time.Now()
+			server.CurrentBoot.EndTime = now
 			server.CurrentBoot.BootDuration = server.CurrentBoot.EndTime.Sub(server.CurrentBoot.StartTime).Seconds()
 			server.CurrentBoot.Complete = true
-			now := time.Now()
-			server.OSUpSince = &now
+			finishStages(server.CurrentBoot)
+			upSince := now
+			server.OSUpSince = &upSince
 			changed = true
+			emit(EventBootComplete, fmt.Sprintf("%.1fs", server.CurrentBoot.BootDuration))
+			if a.endRebootLoop(server) {
+				emit(EventRebootLoopEnd, "")
+			}
 		} else if server.OSUpSince == nil {
 			// OS is up but we didn't see boot (service started after boot)
-			now := time.Now()
-			server.OSUpSince = &now
+			upSince := now
+			server.OSUpSince = &upSince
 			changed = true
 		}
 	}
 
 	// Detect OS/Image type
-	if detectedOS := a.detectOS(text); detectedOS != "" {
+	if detectedOS := tm.detectedOS; detectedOS != "" {
 		if server.CurrentOS != detectedOS {
 			server.CurrentOS = detectedOS
 			if server.CurrentBoot != nil {
 				server.CurrentBoot.DetectedOS = detectedOS
 			}
 			changed = true
+			emit(EventOSDetected, detectedOS)
 		}
 	}
 
 	// Detect hostname
-	if hostname := a.detectHostname(text); hostname != "" {
+	if hostname := tm.hostname; hostname != "" {
 		if server.Hostname != hostname {
 			server.Hostname = hostname
 			changed = true
+			emit(EventHostname, hostname)
 		}
 	}
 
+	// Track firmware/OS/service versions
+	for _, o := range trackSoftware(server, tm.software, now) {
+		changed = true
+		emit(EventSoftware, strings.TrimSpace(o.name+" "+o.version))
+	}
+
+	// Track BIOS, NIC and GPU models and firmware
+	if trackInventory(server, tm.inventory, now) {
+		changed = true
+	}
+
 	// Track boot milestones
 	if server.CurrentBoot != nil {
-		if a.trackMilestones(server.CurrentBoot, text) {
+		for _, name := range trackMilestones(server.CurrentBoot, tm.milestones, now) {
 			changed = true
+			emit(EventMilestone, name)
 		}
 	}
 
+	// Track kernel panics, oopses, OOM kills and machine checks
+	for _, e := range trackErrors(server, tm.errors, now) {
+		changed = true
+		emit(EventError, e.Kind+": "+e.Line)
+	}
+
+	// Track failed units, I/O errors and firmware warnings for the boot's
+	// anomaly summary
+	if server.CurrentBoot != nil && len(tm.anomalies) > 0 {
+		trackAnomalies(server.CurrentBoot, tm.anomalies, now)
+		changed = true
+	}
+
 	// Track network interface events
-	a.trackNetworkEvents(server, text)
+	if server.CurrentBoot != nil {
+		for _, iface := range tm.netUp {
+			emit(EventLinkUp, iface)
+		}
+		for _, iface := range tm.netDown {
+			emit(EventLinkDown, iface)
+		}
+	}
+	a.trackNetworkEvents(server, tm.netUp, tm.netDown, now)
 
-	// Save on significant changes
+	// Persist significant changes on the next flush
 	if changed {
-		a.save()
+		a.dirty[serverName] = true
 	}
 }
 
@@ -344,6 +467,8 @@ func (a *Analytics) GetServerAnalytics(serverName string) *ServerAnalytics {
 		for i, b := range server.BootHistory {
 			copy.BootHistory[i] = *copyBootEvent(&b)
 		}
+		copy.Software = append([]SoftwareComponent(nil), server.Software...)
+		copy.Inventory = maps.Clone(server.Inventory)
 		return &copy
 	}
 	return &ServerAnalytics{
@@ -366,6 +491,8 @@ func (a *Analytics) GetAllAnalytics() map[string]*ServerAnalytics {
 		for i, b := range server.BootHistory {
 			copy.BootHistory[i] = *copyBootEvent(&b)
 		}
+		copy.Software = append([]SoftwareComponent(nil), server.Software...)
+		copy.Inventory = maps.Clone(server.Inventory)
 		result[name] = &copy
 	}
 	return result
@@ -389,6 +516,26 @@ func (a *Analytics) RecordRotation(serverName string) {
 	log.Infof("Recorded rotation for %s at %s", serverName, now.Format(time.RFC3339))
 }
 
+// RecordPowerCommand marks that a power on/cycle/reset was just sent to the
+// BMC, so the delay until the first console output can be measured.
+func (a *Analytics) RecordPowerCommand(serverName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	server, exists := a.servers[serverName]
+	if !exists {
+		server = &ServerAnalytics{
+			ServerName:  serverName,
+			BootHistory: make([]BootEvent, 0),
+		}
+		a.servers[serverName] = server
+	}
+
+	now := time.Now()
+	server.pendingPowerCmd = &now
+	log.Infof("Recorded power command for %s at %s", serverName, now.Format(time.RFC3339))
+}
+
 func copyBootEvent(b *BootEvent) *BootEvent {
 	if b == nil {
 		return nil
@@ -412,12 +559,60 @@ func copyBootEvent(b *BootEvent) *BootEvent {
 			copy.NetworkStats[i] = s
 		}
 	}
+	copy.Errors = append([]ErrorEvent(nil), b.Errors...)
+	copy.Stages = append([]BootStage(nil), b.Stages...)
+	if b.Anomalies != nil {
+		anomalies := *b.Anomalies
+		anomalies.Anomalies = append([]Anomaly(nil), b.Anomalies.Anomalies...)
+		copy.Anomalies = &anomalies
+	}
+	summarizeAnomalies(&copy)
 	return &copy
 }
 
-func (a *Analytics) matchesBIOS(text string) bool {
+// textMatches holds the pattern results for one chunk of console text.
+type textMatches struct {
+	bios       bool
+	os         bool
+	detectedOS string
+	hostname   string
+	milestones []milestoneDetector
+	stages     []string
+	netUp      []string
+	netDown    []string
+	software   []softwareObservation
+	errors     []ErrorEvent
+	anomalies  []Anomaly
+	inventory  []InventoryItem
+}
+
+// match runs every detector over text. It only reads immutable pattern
+// state, so it is safe to call without a.mu.
+func (a *Analytics) match(text string) *textMatches {
+	ct := newChunkText(text)
+	tm := &textMatches{
+		bios:       a.matchesBIOS(ct),
+		os:         a.osPatterns.match(ct),
+		detectedOS: a.detectOS(ct),
+		hostname:   a.detectHostname(ct),
+		netUp:      matchInterfaces(a.netUpPattern, ct),
+		netDown:    matchInterfaces(a.netDownPattern, ct),
+		software:   matchSoftware(ct),
+		errors:     matchErrors(ct),
+		anomalies:  matchAnomalies(ct),
+		inventory:  matchInventory(ct),
+		stages:     matchStages(ct),
+	}
+	for _, md := range a.milestoneDetectors {
+		if md.pattern.match(ct) {
+			tm.milestones = append(tm.milestones, md)
+		}
+	}
+	return tm
+}
+
+func (a *Analytics) matchesBIOS(ct *chunkText) bool {
 	// Simple substring checks for common boot indicators
-	lowerText := strings.ToLower(text)
 	simplePatterns := []string{
 		"ipxe",
 		"pxe->",
@@ -432,116 +627,49 @@ func (a *Analytics) matchesBIOS(text string) bool {
 		"network boot",
 	}
 	for _, p := range simplePatterns {
-		if strings.Contains(lowerText, p) {
-			log.Debugf("BIOS pattern matched (simple): %q in text len=%d", p, len(text))
-			return true
-		}
-	}
-
-	for _, p := range a.biosPatterns {
-		if p.MatchString(text) {
-			log.Debugf("BIOS pattern matched (regex): %v", p)
+		if ct.contains(p) {
+			log.Debugf("BIOS pattern matched (simple): %q in text len=%d", p, len(ct.text))
 			return true
 		}
 	}
-	return false
-}
 
-func (a *Analytics) matchesOS(text string) bool {
-	for _, p := range a.osPatterns {
-		if p.MatchString(text) {
-			return true
-		}
+	if m := a.biosPatterns.find(ct); m != "" {
+		log.Debugf("BIOS pattern matched (regex): %q", m)
+		return true
 	}
 	return false
 }
 
-func (a *Analytics) getFilePath() string {
-	return filepath.Join(a.dataPath, "analytics.json")
-}
-
-func (a *Analytics) save() {
-	if a.dataPath == "" {
-		return
-	}
-
-	data := struct {
-		Servers map[string]*ServerAnalytics `json:"servers"`
-	}{
-		Servers: a.servers,
-	}
-
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		log.Errorf("Failed to marshal analytics: %v", err)
-		return
-	}
-
-	if err := os.MkdirAll(a.dataPath, 0755); err != nil {
-		log.Errorf("Failed to create analytics directory: %v", err)
-		return
-	}
-
-	if err := os.WriteFile(a.getFilePath(), jsonData, 0644); err != nil {
-		log.Errorf("Failed to save analytics: %v", err)
-	}
-}
-
-func (a *Analytics) load() {
-	if a.dataPath == "" {
-		return
-	}
-
-	jsonData, err := os.ReadFile(a.getFilePath())
-	if err != nil {
-		if !os.IsNotExist(err) {
-			log.Errorf("Failed to read analytics: %v", err)
-		}
-		return
-	}
-
-	var data struct {
-		Servers map[string]*ServerAnalytics `json:"servers"`
-	}
-
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		log.Errorf("Failed to unmarshal analytics: %v", err)
-		return
-	}
-
-	if data.Servers != nil {
-		a.servers = data.Servers
-		log.Infof("Loaded analytics for %d servers", len(a.servers))
-	}
+// setDataPath points analytics persistence at a new data directory.
+func (a *Analytics) setDataPath(dataPath string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dataPath = dataPath
 }
 
-func (a *Analytics) detectOS(text string) string {
+func (a *Analytics) detectOS(ct *chunkText) string {
 	for _, detector := range a.osDetectors {
-		if detector.pattern.MatchString(text) {
+		if detector.pattern.match(ct) {
 			return detector.name
 		}
 	}
 	return ""
 }
 
-func (a *Analytics) detectHostname(text string) string {
+func (a *Analytics) detectHostname(ct *chunkText) string {
 	if a.hostPattern == nil {
 		return ""
 	}
-	matches := a.hostPattern.FindStringSubmatch(text)
+	matches := a.hostPattern.findSubmatch(ct)
 	if len(matches) >= 2 {
 		return matches[1]
 	}
 	return ""
 }
 
-func (a *Analytics) trackMilestones(boot *BootEvent, text string) bool {
-	changed := false
-	now := time.Now()
-	for _, md := range a.milestoneDetectors {
-		if !md.pattern.MatchString(text) {
-			continue
-		}
+func trackMilestones(boot *BootEvent, matched []milestoneDetector, now time.Time) []string {
+	var changed []string
+	for _, md := range matched {
 		// Check if this milestone already exists
 		found := false
 		for i := range boot.Milestones {
@@ -550,7 +678,7 @@ func (a *Analytics) trackMilestones(boot *BootEvent, text string) bool {
 				if md.repeats {
 					boot.Milestones[i].Count++
 					boot.Milestones[i].Time = now // update to latest
-					changed = true
+					changed = append(changed, md.name)
 				}
 				break
 			}
@@ -561,61 +689,49 @@ func (a *Analytics) trackMilestones(boot *BootEvent, text string) bool {
 				Time:  now,
 				Count: 1,
 			})
-			changed = true
+			changed = append(changed, md.name)
 		}
 	}
 	return changed
 }
 
-func (a *Analytics) trackNetworkEvents(server *ServerAnalytics, text string) {
-	if server.CurrentBoot == nil {
-		return
+// matchInterfaces returns the interface names of all link events in text.
+func matchInterfaces(re *matcher, ct *chunkText) []string {
+	if re == nil {
+		return nil
 	}
-
-	now := time.Now()
-
-	// Check for link up events
-	if a.netUpPattern != nil {
-		matches := a.netUpPattern.FindAllStringSubmatch(text, -1)
-		for _, match := range matches {
-			iface := ""
-			for i := 1; i < len(match); i++ {
-				if match[i] != "" {
-					iface = match[i]
-					break
-				}
-			}
-			if iface != "" {
-				server.CurrentBoot.NetworkEvents = append(server.CurrentBoot.NetworkEvents, NetworkEvent{
-					Interface: iface,
-					Event:     "up",
-					Time:      now,
-				})
-				a.updateNetworkStats(server.CurrentBoot, iface, "up")
+	var ifaces []string
+	for _, match := range re.findAllSubmatch(ct) {
+		for i := 1; i < len(match); i++ {
+			if match[i] != "" {
+				ifaces = append(ifaces, match[i])
+				break
 			}
 		}
 	}
+	return ifaces
+}
+
+func (a *Analytics) trackNetworkEvents(server *ServerAnalytics, up, down []string, now time.Time) {
+	if server.CurrentBoot == nil {
+		return
+	}
 
-	// Check for link down events
-	if a.netDownPattern != nil {
-		matches := a.netDownPattern.FindAllStringSubmatch(text, -1)
-		for _, match := range matches {
-			iface := ""
-			for i := 1; i < len(match); i++ {
-				if match[i] != "" {
-					iface = match[i]
-					break
-				}
-			}
-			if iface != "" {
-				server.CurrentBoot.NetworkEvents = append(server.CurrentBoot.NetworkEvents, NetworkEvent{
-					Interface: iface,
-					Event:     "down",
-					Time:      now,
-				})
-				a.updateNetworkStats(server.CurrentBoot, iface, "down")
-			}
-		}
+	for _, iface := range up {
+		server.CurrentBoot.NetworkEvents = append(server.CurrentBoot.NetworkEvents, NetworkEvent{
+			Interface: iface,
+			Event:     "up",
+			Time:      now,
+		})
+		a.updateNetworkStats(server.CurrentBoot, iface, "up")
+	}
+	for _, iface := range down {
+		server.CurrentBoot.NetworkEvents = append(server.CurrentBoot.NetworkEvents, NetworkEvent{
+			Interface: iface,
+			Event:     "down",
+			Time:      now,
+		})
+		a.updateNetworkStats(server.CurrentBoot, iface, "down")
 	}
 }
 
@@ -0,0 +1,224 @@
+package sol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Boot anomaly kinds: error-class console lines that don't stop a boot but
+// are worth a look afterwards.
+const (
+	AnomalyFailedUnit = "failed_unit" // a systemd unit failed to start
+	AnomalyIOError    = "io_error"    // disk or filesystem I/O error
+	AnomalyLinkFlap   = "link_flap"   // a network link went down and came back
+	AnomalyFirmware   = "firmware"    // firmware bug or warning, firmware that failed to load
+)
+
+// maxBootAnomalies bounds the distinct anomalies kept per boot; counts
+// keep going.
+const maxBootAnomalies = 50
+
+// Anomaly is one kind of trouble in a boot, with all its occurrences for
+// the same subject (unit, device, interface or firmware file) merged.
+type Anomaly struct {
+	Kind    string    `json:"kind"`
+	Subject string    `json:"subject,omitempty"`
+	Count   int       `json:"count"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Line    string    `json:"line,omitempty"` // first console line that matched
+}
+
+// AnomalyCounts tallies a boot's anomalies by kind.
+type AnomalyCounts struct {
+	FailedUnits int `json:"failedUnits"`
+	IOErrors    int `json:"ioErrors"`
+	LinkFlaps   int `json:"linkFlaps"`
+	Firmware    int `json:"firmware"`
+}
+
+// Total returns the number of anomalies of every kind.
+func (c AnomalyCounts) Total() int {
+	return c.FailedUnits + c.IOErrors + c.LinkFlaps + c.Firmware
+}
+
+// String summarizes the non-zero counts, e.g. "2 failed units, 1 I/O error".
+func (c AnomalyCounts) String() string {
+	var parts []string
+	for _, p := range []struct {
+		n            int
+		one, several string
+	}{
+		{c.FailedUnits, "failed unit", "failed units"},
+		{c.IOErrors, "I/O error", "I/O errors"},
+		{c.LinkFlaps, "link flap", "link flaps"},
+		{c.Firmware, "firmware warning", "firmware warnings"},
+	} {
+		switch {
+		case p.n == 1:
+			parts = append(parts, "1 "+p.one)
+		case p.n > 1:
+			parts = append(parts, fmt.Sprintf("%d %s", p.n, p.several))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (c *AnomalyCounts) add(kind string, n int) {
+	switch kind {
+	case AnomalyFailedUnit:
+		c.FailedUnits += n
+	case AnomalyIOError:
+		c.IOErrors += n
+	case AnomalyLinkFlap:
+		c.LinkFlaps += n
+	case AnomalyFirmware:
+		c.Firmware += n
+	}
+}
+
+// AnomalySummary is what went wrong in a boot short of a crash. It is kept
+// up to date while the boot runs and finished when the next boot starts;
+// link flaps come from the boot's link up and down events.
+type AnomalySummary struct {
+	Counts    AnomalyCounts `json:"counts"`
+	Anomalies []Anomaly     `json:"anomalies"` // most frequent first
+}
+
+type anomalyDetector struct {
+	kind    string
+	pattern *matcher // the first non-empty group, if any, is the subject
+}
+
+// anomalyDetectors recognise failed systemd units, disk and filesystem I/O
+// errors and firmware complaints. Link flaps are counted from link up and
+// down events instead.
+var anomalyDetectors = func() []anomalyDetector {
+	defs := []struct {
+		kind, pattern string
+	}{
+		{AnomalyFailedUnit, `\[FAILED\] Failed to (?:start|mount) (.+?)\.?\s*$`},
+		{AnomalyFailedUnit, `(\S+\.(?:service|mount|socket|target)): (?:Failed with result|Main process exited, code=(?:exited|killed|dumped), status=[1-9])`},
+		{AnomalyIOError, `(?:Buffer )?I/O error,? (?:on )?dev(?:ice)? (\w+)`},
+		{AnomalyIOError, `EXT4-fs error \(device (\w+)\)|XFS \(([\w-]+)\): (?:metadata I/O error|log I/O error|Corruption)|BTRFS error \(device (\w+)\)`},
+		{AnomalyIOError, `(ata\d+(?:\.\d+)?): (?:exception Emask|failed command)|(nvme\d+): (?:I/O \d+ QID \d+ timeout|controller is down)|(sd[a-z]+).*(?:Medium Error|critical medium error)`},
+		{AnomalyFirmware, `Direct firmware load for (\S+) failed|firmware: failed to load (\S+)`},
+		{AnomalyFirmware, `\[Firmware (?:Bug|Warn|Info)\]|ACPI (?:BIOS )?(?:Error|Warning)`},
+	}
+	var out []anomalyDetector
+	for _, d := range defs {
+		if re, err := newMatcher("(?im)" + d.pattern); err == nil {
+			out = append(out, anomalyDetector{kind: d.kind, pattern: re})
+		} else {
+			log.Warnf("Bad anomaly pattern %q: %v", d.pattern, err)
+		}
+	}
+	return out
+}()
+
+// matchAnomalies returns every anomaly line in console text.
+func matchAnomalies(ct *chunkText) []Anomaly {
+	var found []Anomaly
+	for _, d := range anomalyDetectors {
+		if !ct.mayMatch(d.pattern.factors) {
+			continue
+		}
+		for _, loc := range d.pattern.re.FindAllStringSubmatchIndex(ct.text, -1) {
+			a := Anomaly{Kind: d.kind, Line: lineAround(ct.text, loc[0], loc[1])}
+			for g := 2; g+1 < len(loc); g += 2 {
+				if loc[g] >= 0 && loc[g+1] > loc[g] {
+					a.Subject = ct.text[loc[g]:loc[g+1]]
+					break
+				}
+			}
+			found = append(found, a)
+		}
+	}
+	return found
+}
+
+// trackAnomalies merges matched anomalies into the current boot's summary.
+// Must be called with a.mu held.
+func trackAnomalies(boot *BootEvent, found []Anomaly, now time.Time) {
+	if boot == nil || len(found) == 0 {
+		return
+	}
+	if boot.Anomalies == nil {
+		boot.Anomalies = &AnomalySummary{}
+	}
+	sum := boot.Anomalies
+	for _, f := range found {
+		sum.Counts.add(f.Kind, 1)
+		merged := false
+		for i := range sum.Anomalies {
+			if a := &sum.Anomalies[i]; a.Kind == f.Kind && a.Subject == f.Subject {
+				a.Count++
+				a.Last = now
+				merged = true
+				break
+			}
+		}
+		if !merged && len(sum.Anomalies) < maxBootAnomalies {
+			f.Count, f.First, f.Last = 1, now, now
+			sum.Anomalies = append(sum.Anomalies, f)
+		}
+	}
+}
+
+// summarizeAnomalies finishes a boot's anomaly summary: link flaps from its
+// network events, and the most frequent anomalies first. It can be run
+// again as the boot goes on.
+func summarizeAnomalies(boot *BootEvent) {
+	// A link that went down and came back up flapped
+	var flaps []Anomaly
+	down := make(map[string]time.Time)
+	for _, e := range boot.NetworkEvents {
+		switch e.Event {
+		case "down":
+			if _, ok := down[e.Interface]; !ok {
+				down[e.Interface] = e.Time
+			}
+		case "up":
+			since, ok := down[e.Interface]
+			if !ok {
+				continue
+			}
+			delete(down, e.Interface)
+			i := 0
+			for i < len(flaps) && flaps[i].Subject != e.Interface {
+				i++
+			}
+			if i == len(flaps) {
+				flaps = append(flaps, Anomaly{Kind: AnomalyLinkFlap, Subject: e.Interface, First: since})
+			}
+			flaps[i].Count++
+			flaps[i].Last = since
+		}
+	}
+	if boot.Anomalies == nil {
+		if len(flaps) == 0 {
+			return
+		}
+		boot.Anomalies = &AnomalySummary{}
+	}
+
+	sum := boot.Anomalies
+	kept := sum.Anomalies[:0]
+	for _, a := range sum.Anomalies {
+		if a.Kind != AnomalyLinkFlap {
+			kept = append(kept, a)
+		}
+	}
+	sum.Anomalies = append(kept, flaps...)
+	sum.Counts.LinkFlaps = 0
+	for _, f := range flaps {
+		sum.Counts.add(AnomalyLinkFlap, f.Count)
+	}
+	sort.SliceStable(sum.Anomalies, func(i, j int) bool {
+		return sum.Anomalies[i].Count > sum.Anomalies[j].Count
+	})
+}
@@ -0,0 +1,102 @@
+package sol
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff spaces a session's reconnect attempts. Zero fields take the
+// defaults: 1s to 60s, reset after 30s connected, no jitter.
+type Backoff struct {
+	Min    time.Duration // first wait
+	Max    time.Duration // longest wait
+	Reset  time.Duration // a connection that lasted this long starts again from Min
+	Jitter float64       // fraction of each wait randomised away, 0-1
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Min <= 0 {
+		b.Min = time.Second
+	}
+	if b.Max <= 0 {
+		b.Max = 60 * time.Second
+	}
+	if b.Max < b.Min {
+		b.Max = b.Min
+	}
+	if b.Reset <= 0 {
+		b.Reset = 30 * time.Second
+	}
+	b.Jitter = min(max(b.Jitter, 0), 1)
+	return b
+}
+
+// Merge returns b with o's non-zero fields taking precedence.
+func (b Backoff) Merge(o Backoff) Backoff {
+	if o.Min > 0 {
+		b.Min = o.Min
+	}
+	if o.Max > 0 {
+		b.Max = o.Max
+	}
+	if o.Reset > 0 {
+		b.Reset = o.Reset
+	}
+	if o.Jitter > 0 {
+		b.Jitter = o.Jitter
+	}
+	return b
+}
+
+// wait returns the jittered wait for the nth failure in a row (from 1).
+func (b Backoff) wait(n int) time.Duration {
+	d := b.Min
+	for i := 1; i < n && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+	if b.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * b.Jitter * float64(d))
+	}
+	return d
+}
+
+// SetBackoff sets the reconnect backoff, with per-server overrides. It
+// applies from each session's next wait.
+func (m *Manager) SetBackoff(def Backoff, servers map[string]Backoff) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backoffDefault = def
+	m.backoffServers = servers
+}
+
+// backoffFor returns a server's reconnect backoff.
+func (m *Manager) backoffFor(serverName string) Backoff {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.backoffServers[serverName]
+	if !ok {
+		b = m.backoffDefault
+	}
+	return b.withDefaults()
+}
+
+// Reconnect retries a server's SOL session now: a session waiting in
+// backoff or on standby retries at once, any other is restarted. It
+// returns false if the server has no session.
+func (m *Manager) Reconnect(serverName string) bool {
+	session := m.GetSession(serverName)
+	if session == nil {
+		return false
+	}
+	switch session.Status().State {
+	case SessionBackoff, SessionStandby:
+		select {
+		case session.wake <- struct{}{}:
+		default:
+		}
+	default:
+		go m.RestartSession(serverName)
+	}
+	return true
+}
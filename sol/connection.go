@@ -0,0 +1,133 @@
+package sol
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Connection defaults, for settings left zero
+const (
+	defaultConnectTimeout    = 30 * time.Second
+	defaultInactivityTimeout = 2 * time.Minute
+
+	// healthStaleThreshold is how long the health check lets a session
+	// go without BMC packets before restarting it, unless its inactivity
+	// timeout is longer.
+	healthStaleThreshold = 90 * time.Second
+)
+
+// ConnectionSetting tunes a session's connection to its BMC. Zero fields
+// take the defaults.
+type ConnectionSetting struct {
+	Timeout           time.Duration // connect, from authentication to SOL activation; default 30s
+	InactivityTimeout time.Duration // BMC silence before reconnecting; default 2m
+	KeepaliveInterval time.Duration // Get Device ID on an idle session; default InactivityTimeout/3, at least 10s
+	CipherSuite       int           // preferred RMCP+ cipher suite, 1 or 2 (integrity); default 1
+	AllowV15          *bool         // accept an IPMI v1.5 session (MD5, no encryption) from a BMC without RMCP+; default false
+}
+
+// Validate checks the durations and cipher suite.
+func (c ConnectionSetting) Validate() error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout %v is negative", c.Timeout)
+	}
+	if c.InactivityTimeout < 0 {
+		return fmt.Errorf("inactivity_timeout %v is negative", c.InactivityTimeout)
+	}
+	if c.KeepaliveInterval < 0 {
+		return fmt.Errorf("keepalive_interval %v is negative", c.KeepaliveInterval)
+	}
+	if c.CipherSuite < 0 || c.CipherSuite > 2 {
+		return fmt.Errorf("cipher_suite %d: want 1 or 2", c.CipherSuite)
+	}
+	if d := c.withDefaults(); c.KeepaliveInterval > 0 && d.KeepaliveInterval >= d.InactivityTimeout {
+		return fmt.Errorf("keepalive_interval %v must be shorter than inactivity_timeout %v", d.KeepaliveInterval, d.InactivityTimeout)
+	}
+	return nil
+}
+
+// Merge returns c with o's set fields taking precedence.
+func (c ConnectionSetting) Merge(o ConnectionSetting) ConnectionSetting {
+	if o.Timeout != 0 {
+		c.Timeout = o.Timeout
+	}
+	if o.InactivityTimeout != 0 {
+		c.InactivityTimeout = o.InactivityTimeout
+	}
+	if o.KeepaliveInterval != 0 {
+		c.KeepaliveInterval = o.KeepaliveInterval
+	}
+	if o.CipherSuite != 0 {
+		c.CipherSuite = o.CipherSuite
+	}
+	if o.AllowV15 != nil {
+		c.AllowV15 = o.AllowV15
+	}
+	return c
+}
+
+// withDefaults fills in the connect and inactivity timeouts. The keepalive
+// interval and cipher suite are left to go-sol.
+func (c ConnectionSetting) withDefaults() ConnectionSetting {
+	if c.Timeout == 0 {
+		c.Timeout = defaultConnectTimeout
+	}
+	if c.InactivityTimeout == 0 {
+		c.InactivityTimeout = defaultInactivityTimeout
+	}
+	return c
+}
+
+// allowsV15 reports whether a BMC without RMCP+ may get an IPMI v1.5
+// session. It must be asked for, since an unauthenticated capabilities
+// reply claiming no RMCP+ would otherwise downgrade the session.
+func (c ConnectionSetting) allowsV15() bool {
+	return c.AllowV15 != nil && *c.AllowV15
+}
+
+// staleThreshold is how long the health check waits on a silent session:
+// past the inactivity timeout, so go-sol gets to notice first.
+func (c ConnectionSetting) staleThreshold() time.Duration {
+	if t := c.withDefaults().InactivityTimeout; t > healthStaleThreshold {
+		return t
+	}
+	return healthStaleThreshold
+}
+
+// SetConnection sets how sessions connect to BMCs, by default and per
+// server, for sessions connected from now on.
+func (m *Manager) SetConnection(def ConnectionSetting, servers map[string]ConnectionSetting) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connDefault, m.connServers = def, servers
+}
+
+// SetServerConnection sets a lookup of per-server connection overrides
+// from outside the config, e.g. BareMetalHost annotations, which take
+// precedence over SetConnection's.
+func (m *Manager) SetServerConnection(fn func(serverName string) ConnectionSetting) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connOverride = fn
+}
+
+// connectionFor returns a server's connection setting with the defaults
+// filled in. An override that doesn't validate on top of the configured
+// setting is ignored. The caller holds mu.
+func (m *Manager) connectionFor(serverName string) ConnectionSetting {
+	c, ok := m.connServers[serverName]
+	if !ok {
+		c = m.connDefault
+	}
+	if m.connOverride != nil {
+		o := c.Merge(m.connOverride(serverName))
+		if err := o.Validate(); err != nil {
+			log.Warnf("Connection overrides for %s not applied: %v", serverName, err)
+		} else {
+			c = o
+		}
+	}
+	return c.withDefaults()
+}
@@ -0,0 +1,131 @@
+package sol
+
+import (
+	"context"
+	"sync"
+)
+
+// connectPool bounds how many sessions connect at once: clearing stale BMC
+// sessions, authenticating and activating SOL. Sessions beyond the limit
+// queue in arrival order, so a fleet starting up (or reconnecting after a
+// network outage) is brought up a batch at a time instead of flooding the
+// management network.
+type connectPool struct {
+	limit  int // 0 = unlimited
+	active int
+	queue  []*connectWaiter
+	mu     sync.Mutex
+}
+
+type connectWaiter struct {
+	server string
+	ready  chan struct{} // closed when the waiter holds a slot
+}
+
+func newConnectPool() *connectPool {
+	return &connectPool{}
+}
+
+// SetMaxConnecting caps the sessions connecting at once; the rest wait in a
+// queue and report state queued. Zero removes the cap.
+func (m *Manager) SetMaxConnecting(n int) {
+	p := m.connects
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = max(n, 0)
+	p.dispatch()
+}
+
+// acquire waits for a connect slot, calling queued first if it has to
+// wait. The returned func gives the slot back.
+func (p *connectPool) acquire(ctx context.Context, server string, queued func()) (func(), error) {
+	p.mu.Lock()
+	if len(p.queue) == 0 && (p.limit == 0 || p.active < p.limit) {
+		p.active++
+		p.mu.Unlock()
+		return p.release, nil
+	}
+	w := &connectWaiter{server: server, ready: make(chan struct{})}
+	p.queue = append(p.queue, w)
+	p.mu.Unlock()
+	queued()
+
+	select {
+	case <-w.ready:
+		return p.release, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Granted as ctx ended; hand the slot on
+			p.active--
+			p.dispatch()
+		default:
+			p.remove(w)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (p *connectPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	p.dispatch()
+}
+
+// dispatch hands free slots to queued waiters, oldest first. Must be
+// called with p.mu held.
+func (p *connectPool) dispatch() {
+	for len(p.queue) > 0 && (p.limit == 0 || p.active < p.limit) {
+		w := p.queue[0]
+		p.queue = p.queue[1:]
+		p.active++
+		close(w.ready)
+	}
+}
+
+// remove drops a waiter from the queue. Must be called with p.mu held.
+func (p *connectPool) remove(w *connectWaiter) {
+	for i, q := range p.queue {
+		if q == w {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// ConnectQueue reports the sessions connecting now and the servers waiting
+// for a connect slot, in queue order.
+type ConnectQueue struct {
+	Limit      int      `json:"limit"` // 0 = unlimited
+	Connecting int      `json:"connecting"`
+	Queued     []string `json:"queued"`
+}
+
+// GetConnectQueue returns the connect pool's occupancy and queue.
+func (m *Manager) GetConnectQueue() ConnectQueue {
+	p := m.connects
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q := ConnectQueue{Limit: p.limit, Connecting: p.active, Queued: make([]string, 0, len(p.queue))}
+	for _, w := range p.queue {
+		q.Queued = append(q.Queued, w.server)
+	}
+	return q
+}
+
+// QueuePosition returns a server's place in the connect queue, from 1, or
+// 0 if it isn't waiting.
+func (m *Manager) QueuePosition(serverName string) int {
+	p := m.connects
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, w := range p.queue {
+		if w.server == serverName {
+			return i + 1
+		}
+	}
+	return 0
+}
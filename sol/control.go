@@ -0,0 +1,338 @@
+package sol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// controlIdleTimeout is how long a writer keeps implicit control of a console
+// after its last keystroke. A new writer arriving after this period is not
+// announced as "taking" control from anyone.
+const controlIdleTimeout = 10 * time.Minute
+
+// inputController records which client last wrote to a console, and
+// whether it holds input explicitly (AcquireInput). While held, other
+// clients can watch but their input is rejected.
+type inputController struct {
+	who  string
+	last time.Time
+	held bool
+}
+
+// SetReadOnly sets whether the console gateways (SSH, telnet, IPMI and
+// conserver) refuse input and breaks. The API applies server.read_only
+// itself, since its tokens and users may override it.
+func (m *Manager) SetReadOnly(readOnly bool) {
+	m.readOnly.Store(readOnly)
+}
+
+// ReadOnly reports whether the console gateways refuse input.
+func (m *Manager) ReadOnly() bool {
+	return m.readOnly.Load()
+}
+
+// SendInput writes data to a server's console on behalf of a client.
+// If a different client than the previous writer takes over, a banner is
+// injected into the live stream and the log so other operators see it.
+// Input is rejected while another client holds the console.
+func (m *Manager) SendInput(serverName, who string, data []byte) error {
+	if err := m.checkInput(serverName, who); err != nil {
+		return err
+	}
+	if err := m.SendCommand(serverName, data); err != nil {
+		return err
+	}
+	m.claimControl(serverName, who)
+	return nil
+}
+
+// SendBreak sends a serial break to a server's console on behalf of a
+// client. A non-empty sysrq key is sent right after the break, which the
+// Linux kernel reads as a magic SysRq command (e.g. "s" sync, "b" reboot).
+func (m *Manager) SendBreak(serverName, who, sysrq string) error {
+	if sysrq != "" && !ValidSysRq(sysrq) {
+		return fmt.Errorf("invalid SysRq key %q", sysrq)
+	}
+	if err := m.checkInput(serverName, who); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	session, exists := m.sessions[serverName]
+	m.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("server not found: %s", serverName)
+	}
+	if !session.Connected || session.console == nil {
+		return fmt.Errorf("server not connected: %s", serverName)
+	}
+	if err := session.console.SendBreak(); err != nil {
+		return err
+	}
+	if sysrq != "" {
+		if err := session.console.Write([]byte(sysrq)); err != nil {
+			return err
+		}
+	}
+
+	m.claimControl(serverName, who)
+	if who == "" {
+		who = "unknown"
+	}
+	if sysrq != "" {
+		m.announce(serverName, fmt.Sprintf("SysRq %s sent by %s", sysrq, who))
+	} else {
+		m.announce(serverName, fmt.Sprintf("break sent by %s", who))
+	}
+	return nil
+}
+
+// ValidSysRq reports whether key is a single magic SysRq command key.
+func ValidSysRq(key string) bool {
+	if len(key) != 1 {
+		return false
+	}
+	c := key[0]
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z'
+}
+
+// GetController returns the client that most recently wrote to the console,
+// or "" if nobody has written within controlIdleTimeout.
+func (m *Manager) GetController(serverName string) string {
+	m.ctrlMu.Lock()
+	defer m.ctrlMu.Unlock()
+	c := m.controllers[serverName]
+	if c == nil || time.Since(c.last) > controlIdleTimeout {
+		return ""
+	}
+	return c.who
+}
+
+func (m *Manager) claimControl(serverName, who string) {
+	if who == "" {
+		who = "unknown"
+	}
+
+	m.ctrlMu.Lock()
+	prev := m.controllers[serverName]
+	active := prev != nil && time.Since(prev.last) <= controlIdleTimeout
+	taken := active && prev.who != who
+	held := active && prev.held && !taken
+	m.controllers[serverName] = &inputController{who: who, last: time.Now(), held: held}
+	m.ctrlMu.Unlock()
+
+	if !active || prev.who != who {
+		m.publishState(serverName, StateController, "", who)
+	}
+
+	if taken {
+		m.announce(serverName, fmt.Sprintf("control taken by %s", who))
+	}
+}
+
+// checkInput rejects input from who while another client holds the console.
+func (m *Manager) checkInput(serverName, who string) error {
+	if who == "" {
+		who = "unknown"
+	}
+	m.ctrlMu.Lock()
+	defer m.ctrlMu.Unlock()
+	c := m.controllers[serverName]
+	if c != nil && c.held && c.who != who && time.Since(c.last) <= controlIdleTimeout {
+		return fmt.Errorf("input is held by %s", c.who)
+	}
+	return nil
+}
+
+// AcquireInput gives who exclusive write access to a server's console.
+// Acquiring a console held by another client fails unless force is set, in
+// which case it is taken over. The hold lapses after controlIdleTimeout
+// without input, or when the holder's last viewer disconnects (TrackViewer).
+func (m *Manager) AcquireInput(serverName, who string, force bool) error {
+	if who == "" {
+		who = "unknown"
+	}
+
+	m.ctrlMu.Lock()
+	prev := m.controllers[serverName]
+	active := prev != nil && time.Since(prev.last) <= controlIdleTimeout
+	if active && prev.held && prev.who != who && !force {
+		m.ctrlMu.Unlock()
+		return fmt.Errorf("input is held by %s", prev.who)
+	}
+	already := active && prev.held && prev.who == who
+	m.controllers[serverName] = &inputController{who: who, last: time.Now(), held: true}
+	m.ctrlMu.Unlock()
+
+	if already {
+		return nil
+	}
+	m.publishState(serverName, StateInputAcquired, "", who)
+	if active && prev.held {
+		m.announce(serverName, fmt.Sprintf("input taken by %s from %s", who, prev.who))
+	} else {
+		m.announce(serverName, fmt.Sprintf("input acquired by %s", who))
+	}
+	return nil
+}
+
+// ReleaseInput gives up who's hold on a server's console. Only the holder
+// can release it unless force is set. Releasing a console nobody holds is a
+// no-op.
+func (m *Manager) ReleaseInput(serverName, who string, force bool) error {
+	if who == "" {
+		who = "unknown"
+	}
+
+	m.ctrlMu.Lock()
+	c := m.controllers[serverName]
+	if c == nil || !c.held || time.Since(c.last) > controlIdleTimeout {
+		m.ctrlMu.Unlock()
+		return nil
+	}
+	if c.who != who && !force {
+		m.ctrlMu.Unlock()
+		return fmt.Errorf("input is held by %s", c.who)
+	}
+	holder := c.who
+	c.held = false
+	m.ctrlMu.Unlock()
+
+	m.publishState(serverName, StateInputReleased, "", holder)
+	if holder == who {
+		m.announce(serverName, fmt.Sprintf("input released by %s", who))
+	} else {
+		m.announce(serverName, fmt.Sprintf("input of %s released by %s", holder, who))
+	}
+	return nil
+}
+
+// InputHolder returns the client holding a server's console, or "" if
+// nobody does.
+func (m *Manager) InputHolder(serverName string) string {
+	m.ctrlMu.Lock()
+	defer m.ctrlMu.Unlock()
+	c := m.controllers[serverName]
+	if c == nil || !c.held || time.Since(c.last) > controlIdleTimeout {
+		return ""
+	}
+	return c.who
+}
+
+// Viewer is one client connection watching a server's console.
+type Viewer struct {
+	Who        string    `json:"who"`    // user@host
+	User       string    `json:"user"`   // authenticated or claimed user, "anonymous" if none
+	Remote     string    `json:"remote"` // client address
+	Via        string    `json:"via"`    // console or events (SSE), attach, grpc, ssh, telnet, ipmi or conserver
+	Since      time.Time `json:"since"`
+	ReadOnly   bool      `json:"readOnly"`   // can't type here: watch or spy only, no control scope, or a read-only server
+	Holder     bool      `json:"holder"`     // who holds the console's input
+	Controller bool      `json:"controller"` // who typed last, within controlIdleTimeout
+}
+
+// TrackViewer records that who is watching a server's console over via (a
+// live stream, WebSocket or SSH attach, ...), and returns a func to call
+// when it disconnects. readOnly marks a connection that can't type. When a
+// client holding the console loses its last viewer, its hold is released.
+func (m *Manager) TrackViewer(serverName, who, via string, readOnly bool) func() {
+	if who == "" {
+		who = "unknown"
+	}
+	v := &Viewer{Who: who, Via: via, Since: time.Now(), ReadOnly: readOnly}
+	if i := strings.LastIndex(who, "@"); i >= 0 {
+		v.User, v.Remote = who[:i], who[i+1:]
+	} else {
+		v.User = who
+	}
+	m.ctrlMu.Lock()
+	m.viewers[serverName] = append(m.viewers[serverName], v)
+	m.ctrlMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.ctrlMu.Lock()
+			views := m.viewers[serverName]
+			last := true
+			for i := len(views) - 1; i >= 0; i-- {
+				if views[i] == v {
+					views = append(views[:i], views[i+1:]...)
+				} else if views[i].Who == who {
+					last = false
+				}
+			}
+			if len(views) == 0 {
+				delete(m.viewers, serverName)
+			} else {
+				m.viewers[serverName] = views
+			}
+			c := m.controllers[serverName]
+			release := last && c != nil && c.held && c.who == who
+			if release {
+				c.held = false
+			}
+			m.ctrlMu.Unlock()
+
+			if release {
+				m.publishState(serverName, StateInputReleased, "", who)
+				m.announce(serverName, fmt.Sprintf("input released (%s disconnected)", who))
+			}
+		})
+	}
+}
+
+// Viewers returns the clients watching a server's console, sorted.
+func (m *Manager) Viewers(serverName string) []string {
+	m.ctrlMu.Lock()
+	defer m.ctrlMu.Unlock()
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(m.viewers[serverName]))
+	for _, v := range m.viewers[serverName] {
+		if !seen[v.Who] {
+			seen[v.Who] = true
+			names = append(names, v.Who)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetViewers returns each connection watching a server's console, oldest
+// first, marking the input holder's and the last writer's.
+func (m *Manager) GetViewers(serverName string) []Viewer {
+	m.ctrlMu.Lock()
+	defer m.ctrlMu.Unlock()
+	var holder, controller string
+	if c := m.controllers[serverName]; c != nil && time.Since(c.last) <= controlIdleTimeout {
+		controller = c.who
+		if c.held {
+			holder = c.who
+		}
+	}
+	viewers := make([]Viewer, 0, len(m.viewers[serverName]))
+	for _, v := range m.viewers[serverName] {
+		view := *v
+		view.Holder = view.Who == holder
+		view.Controller = view.Who == controller
+		viewers = append(viewers, view)
+	}
+	sort.SliceStable(viewers, func(i, j int) bool { return viewers[i].Since.Before(viewers[j].Since) })
+	return viewers
+}
+
+// announce injects an informational banner into the live stream and the
+// server's log. The banner is not added to the screen buffer, which only
+// holds raw SOL output.
+func (m *Manager) announce(serverName, msg string) {
+	log.Infof("Console %s: %s", serverName, msg)
+	m.actor(serverName).Banner(
+		[]byte("\r\n\x1b[7m[ipmiserial] "+msg+"\x1b[0m\r\n"),
+		[]byte("\n[ipmiserial] "+msg+"\n"),
+	)
+}
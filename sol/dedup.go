@@ -0,0 +1,86 @@
+package sol
+
+import (
+	"bytes"
+	"strings"
+)
+
+// maxDedupLine bounds a line held while waiting for its newline.
+const maxDedupLine = 4096
+
+// DedupLine is one cleaned console line. A line with Repeat > 0 reports
+// that the previous line appeared Repeat more times in a row.
+type DedupLine struct {
+	Text   string `json:"text"`
+	Repeat int    `json:"repeat,omitempty"`
+}
+
+// LineDeduper turns raw console bytes into ANSI-stripped lines, collapsing
+// runs of identical lines (spinners, retry loops, repeated warnings) into
+// a single repeat count. It is not safe for concurrent use.
+type LineDeduper struct {
+	partial []byte
+	last    string
+	repeats int
+}
+
+// Write consumes console output and returns the lines it completed.
+func (d *LineDeduper) Write(data []byte) []DedupLine {
+	var out []DedupLine
+	d.partial = append(d.partial, data...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		out = d.line(out, d.partial[:i])
+		d.partial = d.partial[i+1:]
+	}
+	if len(d.partial) > maxDedupLine {
+		out = d.line(out, d.partial)
+		d.partial = nil
+	}
+	// Don't let the backing array grow without bound
+	if len(d.partial) == 0 {
+		d.partial = d.partial[:0:0]
+	}
+	return out
+}
+
+// Flush reports a pending run of repeats, e.g. when output goes quiet.
+func (d *LineDeduper) Flush() []DedupLine {
+	if d.repeats == 0 {
+		return nil
+	}
+	out := []DedupLine{{Text: d.last, Repeat: d.repeats}}
+	d.repeats = 0
+	return out
+}
+
+func (d *LineDeduper) line(out []DedupLine, raw []byte) []DedupLine {
+	text := StripANSI(string(raw))
+	// A carriage return redraws the line; keep what was drawn last
+	text = strings.TrimRight(text, "\r")
+	if i := strings.LastIndexByte(text, '\r'); i >= 0 {
+		text = text[i+1:]
+	}
+	text = strings.TrimRight(text, " \t")
+	if text == "" {
+		return out
+	}
+	if text == d.last {
+		d.repeats++
+		return out
+	}
+	out = append(out, d.Flush()...)
+	d.last = text
+	return append(out, DedupLine{Text: text})
+}
+
+// StripANSI removes terminal escape sequences from console text.
+func StripANSI(text string) string {
+	if strings.IndexByte(text, 0x1b) < 0 {
+		return text
+	}
+	return ansiStripRegex.ReplaceAllString(text, "")
+}
@@ -0,0 +1,157 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gwest/go-sol"
+)
+
+// diagTimeout bounds opening a command-only session and running the
+// diagnostic commands.
+const diagTimeout = 20 * time.Second
+
+// privilegeNames names IPMI privilege levels.
+var privilegeNames = map[uint8]string{
+	1: "callback",
+	2: "user",
+	3: "operator",
+	4: "admin",
+	5: "oem",
+}
+
+// SOLDiag is what a BMC reports about SOL, for working out why Activate
+// Payload fails. Each command's result is independent: a command the BMC
+// rejects leaves its section out and its error in Errors.
+type SOLDiag struct {
+	Server     string            `json:"server"`
+	Live       bool              `json:"live"`                 // run over the connected SOL session rather than a command-only one
+	Activation *SOLActivation    `json:"activation,omitempty"` // Get Payload Activation Status (SOL)
+	Channel    *ChannelPayloads  `json:"channel,omitempty"`    // Get Channel Payload Support
+	Config     *SOLParameters    `json:"config,omitempty"`     // Get SOL Configuration Parameters
+	Errors     map[string]string `json:"errors,omitempty"`     // "activation", "channel" or "config" -> error
+	Hints      []string          `json:"hints,omitempty"`      // likely causes of activation failures
+}
+
+// SOLActivation is the BMC's SOL instance capacity and active instances.
+type SOLActivation struct {
+	Capacity int   `json:"capacity"`
+	Active   []int `json:"active"` // on any session
+}
+
+// ChannelPayloads lists the payload types the BMC's LAN channel supports.
+type ChannelPayloads struct {
+	SOL          bool  `json:"sol"`
+	Standard     []int `json:"standard"`     // 0 IPMI, 1 SOL, 2 OEM explicit
+	SessionSetup []int `json:"sessionSetup"` // 16-21 RMCP+ open session and RAKP
+	OEM          []int `json:"oem"`
+}
+
+// SOLParameters are the SOL configuration parameters as the BMC has them.
+type SOLParameters struct {
+	Enabled            bool   `json:"enabled"`
+	Privilege          string `json:"privilege"` // minimum privilege to activate SOL
+	ForceEncryption    bool   `json:"forceEncryption"`
+	ForceAuth          bool   `json:"forceAuthentication"`
+	BitRate            int    `json:"bitRate"`            // baud; 0 = the BMC's serial port setting
+	VolatileBitRate    int    `json:"volatileBitRate"`    // baud; 0 = the BMC's serial port setting
+	RetryCount         int    `json:"retryCount"`         // -1 = no retries
+	RetryInterval      int    `json:"retryInterval"`      // milliseconds
+	AccumulateInterval int    `json:"accumulateInterval"` // milliseconds
+	SendThreshold      int    `json:"sendThreshold"`
+}
+
+// SOLDiagnostics runs Get Payload Activation Status, Get Channel Payload
+// Support and Get SOL Configuration Parameters against a server's BMC, over
+// the live session or a command-only one while SOL is down, and suggests
+// what would make activation fail.
+func (m *Manager) SOLDiagnostics(ctx context.Context, serverName string) (*SOLDiag, error) {
+	ctx, cancel := context.WithTimeout(ctx, diagTimeout)
+	defer cancel()
+	s, release, err := m.commandSession(ctx, serverName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	d := &SOLDiag{Server: serverName, Errors: make(map[string]string)}
+	if session := m.GetSession(serverName); session != nil {
+		d.Live = session.Connected && session.solSession == s
+	}
+
+	if a, err := s.GetPayloadActivationStatus(ctx, sol.PayloadSOL); err != nil {
+		d.Errors["activation"] = err.Error()
+	} else {
+		d.Activation = &SOLActivation{Capacity: a.Capacity, Active: a.Active}
+	}
+	if p, err := s.GetChannelPayloadSupport(ctx); err != nil {
+		d.Errors["channel"] = err.Error()
+	} else {
+		d.Channel = &ChannelPayloads{
+			SOL:          p.Supports(sol.PayloadSOL),
+			Standard:     payloadTypes(p.Standard),
+			SessionSetup: payloadTypes(p.SessionSetup),
+			OEM:          payloadTypes(p.OEM),
+		}
+	}
+	if access, err := s.GetSOLAccess(ctx); err != nil {
+		d.Errors["config"] = err.Error()
+	} else {
+		d.Config = &SOLParameters{
+			Enabled:         access.Enabled,
+			Privilege:       privilegeNames[access.Privilege],
+			ForceEncryption: access.ForceEncryption,
+			ForceAuth:       access.ForceAuth,
+		}
+		if c, err := s.GetSOLConfig(ctx); err != nil {
+			d.Errors["config"] = err.Error()
+		} else {
+			d.Config.BitRate, d.Config.VolatileBitRate = c.BitRate, c.VolatileBitRate
+			d.Config.RetryCount = c.RetryCount
+			d.Config.RetryInterval = int(c.RetryInterval / time.Millisecond)
+			d.Config.AccumulateInterval = int(c.AccumulateInterval / time.Millisecond)
+			d.Config.SendThreshold = c.SendThreshold
+		}
+	}
+	if len(d.Errors) == 0 {
+		d.Errors = nil
+	}
+	d.Hints = diagHints(d)
+	return d, nil
+}
+
+// payloadTypes converts payload types for JSON, which would encode a
+// []uint8 as base64.
+func payloadTypes(types []uint8) []int {
+	out := make([]int, len(types))
+	for i, t := range types {
+		out[i] = int(t)
+	}
+	return out
+}
+
+// diagHints maps what the BMC reported to Activate Payload completion codes
+// it would cause.
+func diagHints(d *SOLDiag) []string {
+	var hints []string
+	if c := d.Config; c != nil {
+		if !c.Enabled {
+			hints = append(hints, "SOL is disabled on the BMC, so activation fails with 0x81 (payload type disabled); enable it with ipmitool sol set enabled true, or set ipmi.sol_config.auto_enable")
+		}
+		if c.Privilege == "oem" {
+			hints = append(hints, "SOL requires OEM privilege but sessions are opened as admin; lower it with ipmitool sol set privilege-level admin")
+		}
+	}
+	if ch := d.Channel; ch != nil && !ch.SOL {
+		hints = append(hints, "the LAN channel does not list SOL as a supported payload")
+	}
+	if a := d.Activation; a != nil && !d.Live && len(a.Active) > 0 {
+		msg := fmt.Sprintf("SOL instance %v is active on another session, so activation fails with 0x80 until it is deactivated (ipmitool sol deactivate) or times out", a.Active)
+		if a.Capacity > 0 && len(a.Active) >= a.Capacity {
+			msg += "; all instances are in use (0x82)"
+		}
+		hints = append(hints, msg)
+	}
+	return hints
+}
@@ -0,0 +1,164 @@
+package sol
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Console error kinds
+const (
+	ErrorPanic = "panic"
+	ErrorOops  = "oops"
+	ErrorOOM   = "oom"
+	ErrorMCE   = "mce"
+)
+
+const (
+	// maxBootErrors bounds the errors kept per boot; counts keep going.
+	maxBootErrors = 50
+	// errorCollapse merges matches of one kind this close together, since a
+	// single oops or machine check prints several matching lines.
+	errorCollapse = 10 * time.Second
+	// maxErrorLine bounds the console line stored with an error.
+	maxErrorLine = 200
+)
+
+// ErrorEvent is a kernel or hardware error seen on the console.
+type ErrorEvent struct {
+	Kind string    `json:"kind"` // panic, oops, oom, mce
+	Line string    `json:"line"` // console line that matched
+	Time time.Time `json:"time"`
+}
+
+// ErrorCounts tallies console errors by kind.
+type ErrorCounts struct {
+	Panic int `json:"panic"`
+	Oops  int `json:"oops"`
+	OOM   int `json:"oom"`
+	MCE   int `json:"mce"`
+}
+
+// Total returns the number of errors of every kind.
+func (c ErrorCounts) Total() int {
+	return c.Panic + c.Oops + c.OOM + c.MCE
+}
+
+// String summarizes the non-zero counts, e.g. "1 panic, 2 OOM".
+func (c ErrorCounts) String() string {
+	var parts []string
+	for _, p := range []struct {
+		n    int
+		name string
+	}{{c.Panic, "panic"}, {c.Oops, "oops"}, {c.OOM, "OOM"}, {c.MCE, "MCE"}} {
+		if p.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", p.n, p.name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (c *ErrorCounts) add(kind string) {
+	switch kind {
+	case ErrorPanic:
+		c.Panic++
+	case ErrorOops:
+		c.Oops++
+	case ErrorOOM:
+		c.OOM++
+	case ErrorMCE:
+		c.MCE++
+	}
+}
+
+type errorDetector struct {
+	kind    string
+	pattern *matcher
+}
+
+// errorDetectors recognise kernel panics, oops traces, OOM kills and machine
+// checks. Each OOM kill prints one "Out of memory: Kill" line; the
+// "invoked oom-killer" header is deliberately not matched so kills aren't
+// counted twice.
+var errorDetectors = func() []errorDetector {
+	defs := []struct {
+		kind, pattern string
+	}{
+		{ErrorPanic, `Kernel panic - not syncing`},
+		{ErrorOops, `Oops: [0-9a-f]{4}|BUG: unable to handle|BUG: kernel NULL pointer dereference|general protection fault|kernel BUG at`},
+		{ErrorOOM, `Out of memory: Kill`},
+		{ErrorMCE, `\[Hardware Error\]|Machine check events logged|Machine Check Exception`},
+	}
+	var out []errorDetector
+	for _, d := range defs {
+		if re, err := newMatcher("(?i)" + d.pattern); err == nil {
+			out = append(out, errorDetector{kind: d.kind, pattern: re})
+		} else {
+			log.Warnf("Bad error pattern %q: %v", d.pattern, err)
+		}
+	}
+	return out
+}()
+
+// matchErrors returns every error line in console text, in order of kind.
+func matchErrors(ct *chunkText) []ErrorEvent {
+	var found []ErrorEvent
+	for _, d := range errorDetectors {
+		if !ct.mayMatch(d.pattern.factors) {
+			continue
+		}
+		for _, loc := range d.pattern.re.FindAllStringIndex(ct.text, -1) {
+			found = append(found, ErrorEvent{Kind: d.kind, Line: lineAround(ct.text, loc[0], loc[1])})
+		}
+	}
+	return found
+}
+
+// lineAround returns the trimmed line containing text[start:end].
+func lineAround(text string, start, end int) string {
+	if i := strings.LastIndexAny(text[:start], "\r\n"); i >= 0 {
+		start = i + 1
+	} else {
+		start = 0
+	}
+	if i := strings.IndexAny(text[end:], "\r\n"); i >= 0 {
+		end += i
+	} else {
+		end = len(text)
+	}
+	line := strings.TrimSpace(text[start:end])
+	if len(line) > maxErrorLine {
+		line = line[:maxErrorLine]
+	}
+	return line
+}
+
+// trackErrors records matched errors against the server and its current
+// boot, collapsing repeats of one kind within errorCollapse. Returns the
+// errors that were recorded. Must be called with a.mu held.
+func trackErrors(server *ServerAnalytics, found []ErrorEvent, now time.Time) []ErrorEvent {
+	var recorded []ErrorEvent
+	for _, e := range found {
+		if last, ok := server.lastError[e.Kind]; ok && now.Sub(last) < errorCollapse {
+			continue
+		}
+		if server.lastError == nil {
+			server.lastError = make(map[string]time.Time)
+		}
+		server.lastError[e.Kind] = now
+		e.Time = now
+
+		server.ErrorCounts.add(e.Kind)
+		if boot := server.CurrentBoot; boot != nil {
+			boot.ErrorCounts.add(e.Kind)
+			if len(boot.Errors) < maxBootErrors {
+				boot.Errors = append(boot.Errors, e)
+			}
+		}
+		log.Warnf("Console %s on %s: %s", e.Kind, server.ServerName, e.Line)
+		recorded = append(recorded, e)
+	}
+	return recorded
+}
@@ -0,0 +1,118 @@
+package sol
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Notification channels. Console streams can pick which they receive, so
+// lightweight consumers don't need the console byte firehose.
+const (
+	ChannelRaw       = "raw"       // console bytes and the logchange event
+	ChannelAnalytics = "analytics" // AnalyticsEvent as JSON
+	ChannelState     = "state"     // StateEvent as JSON
+)
+
+// Analytics event types
+const (
+	EventBootStart       = "boot_start"
+	EventBootComplete    = "boot_complete"
+	EventOSDetected      = "os_detected"
+	EventHostname        = "hostname"
+	EventMilestone       = "milestone"
+	EventSoftware        = "software"
+	EventLinkUp          = "link_up"
+	EventLinkDown        = "link_down"
+	EventPowerOnDegraded = "power_on_degraded" // detail: "Xs vs median Ys"
+	EventError           = "error"
+	EventStage           = "stage"
+	EventRebootLoop      = "reboot_loop"     // detail: "N boots within W"
+	EventRebootLoopEnd   = "reboot_loop_end" // a boot reached the OS
+)
+
+// State event types
+const (
+	StateConnected     = "connected"
+	StateDisconnected  = "disconnected"
+	StateConnectFailed = "connect_failed"
+	StateController    = "controller"
+	StateInputAcquired = "input_acquired"
+	StateInputReleased = "input_released"
+	StateLogChange     = "logchange"
+	StateStandby       = "standby"     // chassis powered off, SOL waits for power-on
+	StateStandbyEnd    = "standby_end" // detail: "power on", "power command" or "standby disabled"
+)
+
+// AnalyticsEvent is a notable change detected in a server's console output.
+type AnalyticsEvent struct {
+	Server string    `json:"server"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Detail string    `json:"detail,omitempty"` // OS, hostname, milestone, interface, "name version", boot duration, "kind: line", stage
+}
+
+// StateEvent is a change in a server's SOL session or console ownership.
+type StateEvent struct {
+	Server string    `json:"server"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Error  string    `json:"error,omitempty"`
+	Detail string    `json:"detail,omitempty"` // controller identity or new log file
+}
+
+// allServers is the notifySubs key for subscribers to every server.
+const allServers = ""
+
+func (a *Analytics) dispatch(events []AnalyticsEvent) {
+	if a.onEvent == nil {
+		return
+	}
+	for _, ev := range events {
+		a.onEvent(ev)
+	}
+}
+
+// publishAnalytics forwards an analytics event to notification subscribers.
+func (m *Manager) publishAnalytics(ev AnalyticsEvent) {
+	m.publish(ev.Server, ChannelAnalytics, ev)
+}
+
+// publishState sends a state event for a server.
+func (m *Manager) publishState(serverName, typ, errStr, detail string) {
+	m.publish(serverName, ChannelState, StateEvent{
+		Server: serverName,
+		Type:   typ,
+		Time:   time.Now(),
+		Error:  errStr,
+		Detail: detail,
+	})
+}
+
+func (m *Manager) publish(serverName, channel string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Warnf("Failed to marshal %s event for %s: %v", channel, serverName, err)
+		return
+	}
+	m.notify(serverName, SSEEvent{Name: channel, Data: string(data), Channel: channel, Server: serverName})
+}
+
+// OnConsoleText registers fn to receive each server's console text with
+// ANSI escapes stripped, in arrival order, from the server's actor. Unlike
+// Subscribe it never drops data, so fn must be quick. Call it before any
+// session starts.
+func (m *Manager) OnConsoleText(fn func(serverName, text string, at time.Time)) {
+	m.analytics.onText = fn
+}
+
+// SubscribeAllNotify subscribes to analytics and state events from every
+// server. Raw-channel events are not delivered.
+func (m *Manager) SubscribeAllNotify() chan SSEEvent {
+	return m.SubscribeNotify(allServers)
+}
+
+func (m *Manager) UnsubscribeAllNotify(ch chan SSEEvent) {
+	m.UnsubscribeNotify(allServers, ch)
+}
@@ -0,0 +1,151 @@
+package sol
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultConnectHistory is the number of connect attempts kept per server.
+const defaultConnectHistory = 100
+
+// ConnectAttempt is one SOL connect attempt: when it started, how long it
+// took to connect or fail, and for a connection that came up, when and why
+// it ended.
+type ConnectAttempt struct {
+	Time      time.Time  `json:"time"`
+	Duration  float64    `json:"duration"`        // seconds from start to connected, or to failure
+	Connected bool       `json:"connected"`       // SOL came up
+	Error     string     `json:"error,omitempty"` // why the connect failed, or why the connection ended
+	Ended     *time.Time `json:"ended,omitempty"` // connected: when the connection was lost or closed
+}
+
+// ConnectHistory keeps a ring of each server's latest connect attempts,
+// persisted to <dataPath>/<server>/history.json, so failures that came and
+// went while nobody watched can be looked at afterwards. Attempts are at
+// most a few a minute per server, so each is written through at once.
+type ConnectHistory struct {
+	dataPath string
+	limit    int
+	servers  map[string][]ConnectAttempt // oldest first
+	mu       sync.Mutex
+	saveMu   sync.Mutex
+}
+
+func NewConnectHistory(dataPath string) *ConnectHistory {
+	return &ConnectHistory{
+		dataPath: dataPath,
+		limit:    defaultConnectHistory,
+		servers:  make(map[string][]ConnectAttempt),
+	}
+}
+
+// SetConnectHistory changes how many connect attempts are kept per server;
+// zero or less means the default. Longer histories are trimmed at each
+// server's next attempt.
+func (m *Manager) SetConnectHistory(n int) {
+	if n <= 0 {
+		n = defaultConnectHistory
+	}
+	m.history.mu.Lock()
+	defer m.history.mu.Unlock()
+	m.history.limit = n
+}
+
+// GetConnectHistory returns a server's connect attempts, newest first.
+func (m *Manager) GetConnectHistory(serverName string) []ConnectAttempt {
+	h := m.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	attempts := h.attemptsLocked(serverName)
+	out := make([]ConnectAttempt, len(attempts))
+	for i, a := range attempts {
+		out[len(out)-1-i] = a
+	}
+	return out
+}
+
+// record appends an attempt, dropping the oldest beyond the limit.
+func (h *ConnectHistory) record(serverName string, a ConnectAttempt) {
+	h.mu.Lock()
+	attempts := append(h.attemptsLocked(serverName), a)
+	if len(attempts) > h.limit {
+		attempts = append([]ConnectAttempt(nil), attempts[len(attempts)-h.limit:]...)
+	}
+	h.servers[serverName] = attempts
+	h.mu.Unlock()
+	h.save(serverName)
+}
+
+// ended marks the server's open connection, if any, as over.
+func (h *ConnectHistory) ended(serverName string, reason string) {
+	h.mu.Lock()
+	attempts := h.attemptsLocked(serverName)
+	n := len(attempts)
+	if n == 0 || !attempts[n-1].Connected || attempts[n-1].Ended != nil {
+		h.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	attempts[n-1].Ended = &now
+	attempts[n-1].Error = reason
+	h.mu.Unlock()
+	h.save(serverName)
+}
+
+// attemptsLocked returns a server's attempts, loading them from disk on
+// first use. Must be called with h.mu held.
+func (h *ConnectHistory) attemptsLocked(serverName string) []ConnectAttempt {
+	if attempts, ok := h.servers[serverName]; ok {
+		return attempts
+	}
+	var attempts []ConnectAttempt
+	if h.dataPath != "" {
+		if data, err := os.ReadFile(h.filePath(serverName)); err == nil {
+			if err := json.Unmarshal(data, &attempts); err != nil {
+				log.Warnf("Failed to parse connect history for %s: %v", serverName, err)
+			}
+		}
+	}
+	h.servers[serverName] = attempts
+	return attempts
+}
+
+// filePath must be called with h.mu held.
+func (h *ConnectHistory) filePath(serverName string) string {
+	return filepath.Join(h.dataPath, serverName, "history.json")
+}
+
+func (h *ConnectHistory) save(serverName string) {
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+
+	h.mu.Lock()
+	if h.dataPath == "" {
+		h.mu.Unlock()
+		return
+	}
+	data, err := json.MarshalIndent(h.servers[serverName], "", "  ")
+	path := h.filePath(serverName)
+	h.mu.Unlock()
+	if err != nil {
+		log.Errorf("Failed to marshal connect history for %s: %v", serverName, err)
+		return
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		log.Errorf("Failed to save connect history for %s: %v", serverName, err)
+	}
+}
+
+// setDataPath points the history at a new data directory. Histories are
+// reloaded from there on next use.
+func (h *ConnectHistory) setDataPath(dataPath string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dataPath = dataPath
+	h.servers = make(map[string][]ConnectAttempt)
+}
@@ -0,0 +1,184 @@
+package sol
+
+import (
+	"maps"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Inventory item kinds
+const (
+	InventorySystem     = "system"     // board or system model, from the kernel's DMI line
+	InventoryBIOS       = "bios"       // system firmware
+	InventoryBootloader = "bootloader" // network boot firmware
+	InventoryNIC        = "nic"        // network adapter
+	InventoryGPU        = "gpu"        // display or compute adapter
+)
+
+// maxInventoryItems bounds the per-server inventory.
+const maxInventoryItems = 100
+
+// InventoryItem is a piece of hardware or firmware seen on a server's
+// console during POST and boot.
+type InventoryItem struct {
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name,omitempty"`     // model or description
+	Device    string    `json:"device,omitempty"`   // PCI address or iPXE device
+	Driver    string    `json:"driver,omitempty"`   // kernel driver
+	Firmware  string    `json:"firmware,omitempty"` // firmware or BIOS version; the driver version for NVIDIA's module
+	Date      string    `json:"date,omitempty"`     // firmware release date, as printed
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Inventory is the per-server hardware and firmware document, keyed by
+// kind, or kind:device for one of several devices, e.g. nic:0000:3b:00.0.
+type Inventory struct {
+	Server string                   `json:"server"`
+	Items  map[string]InventoryItem `json:"items"`
+}
+
+type inventoryDetector struct {
+	kind    string
+	name    string // fixed name, unless the pattern captures one
+	pattern *matcher
+}
+
+// inventoryDetectors pick hardware and firmware facts out of POST and
+// kernel output. Named groups fill the item: name, device, driver,
+// firmware and date.
+var inventoryDetectors = func() []inventoryDetector {
+	defs := []struct {
+		kind, name, pattern string
+	}{
+		// System and BIOS
+		{InventorySystem, "", `DMI: (?P<name>[^\r\n]+?), BIOS \S+ \d{2}/\d{2}/\d{4}`},
+		{InventoryBIOS, "", `DMI: [^\r\n]+?, BIOS (?P<firmware>\S+) (?P<date>\d{2}/\d{2}/\d{4})`},
+		{InventoryBIOS, "", `BIOS Date:\s*(?P<date>\S+).*?Ver(?:sion)?:?\s*(?P<firmware>[\w.\-]+)`},
+		{InventoryBIOS, "AMI Aptio", `Version (?P<firmware>\d+\.\d+\.\d+)\.?\s*Copyright.*American Megatrends`},
+		{InventoryBIOS, "", `(?:System|BIOS) (?:Firmware|BIOS) Version:?\s+(?P<firmware>[\w.\-]+)`},
+
+		// Network boot
+		{InventoryBootloader, "iPXE", `iPXE (?P<firmware>\d+\.\d+\.\d+[\w+\-.]*)`},
+		{InventoryBootloader, "Intel Boot Agent", `Intel\(R\) Boot Agent\s+(?:GE|XE|FE|CL|PXE)?\s*v?(?P<firmware>\d+\.\d+\.\d+)`},
+		{InventoryNIC, "", `(?P<device>net\d+): [0-9a-f:]{17} using (?P<name>\S+) on (?:PCI)?\S+`},
+
+		// NIC adapters and firmware, as the kernel drivers print them
+		{InventoryNIC, "", `(?P<driver>ixgbe|igb|e1000e|i40e|ice|bnxt_en|tg3|mlx4_core|mlx5_core|qede|be2net|cxgb4) (?P<device>[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.\d)(?: \S+)?: (?P<name>(?:Intel|Broadcom|Mellanox|QLogic|Emulex|Chelsio)[^\r\n]*(?:Connection|Adapter|Controller))`},
+		{InventoryNIC, "", `(?P<driver>i40e|ice) (?P<device>[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.\d): fw (?P<firmware>\S+ api \S+ nvm \S+)`},
+		{InventoryNIC, "", `(?P<driver>mlx[45]_core|bnxt_en|qede|be2net|cxgb4|tg3|ixgbe|igb|e1000e) (?P<device>[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.\d)(?: \S+)?: (?:[Ff]irmware [Vv]ersion|FW [Vv]er(?:sion)?|fw ver(?:sion)?)[:=]?\s*(?P<firmware>[\w.\-/]+)`},
+
+		// GPUs: PCI display class devices, their drivers and VBIOS
+		{InventoryGPU, "", `pci (?P<device>[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.\d): \[(?P<name>[0-9a-f]{4}:[0-9a-f]{4})\] type \d+ class 0x03`},
+		{InventoryGPU, "", `NVRM: loading (?P<name>NVIDIA UNIX[^\r\n]*?Kernel Module)\s+(?P<firmware>\d+\.\d+(?:\.\d+)?)`},
+		{InventoryGPU, "", `\[drm\] Initialized (?P<driver>nvidia-drm|amdgpu|radeon|i915|xe|ast|mgag200|nouveau) \d+\.\d+\.\d+ \d+ for (?P<device>[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.\d)`},
+		{InventoryGPU, "", `(?P<driver>amdgpu) (?P<device>[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.\d): amdgpu: ATOM BIOS: (?P<firmware>\S+)`},
+		{InventoryGPU, "", `(?P<driver>nvidia) (?P<device>[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.\d): (?:VBIOS|vbios) [Vv]ersion:? (?P<firmware>[\w.]+)`},
+	}
+	var out []inventoryDetector
+	for _, d := range defs {
+		if re, err := newMatcher("(?i)" + d.pattern); err == nil {
+			out = append(out, inventoryDetector{kind: d.kind, name: d.name, pattern: re})
+		} else {
+			log.Warnf("Bad inventory pattern %q: %v", d.pattern, err)
+		}
+	}
+	return out
+}()
+
+// matchInventory extracts inventory items from console text.
+func matchInventory(ct *chunkText) []InventoryItem {
+	var found []InventoryItem
+	for _, d := range inventoryDetectors {
+		names := d.pattern.re.SubexpNames()
+		for _, m := range d.pattern.findAllSubmatch(ct) {
+			item := InventoryItem{Kind: d.kind, Name: d.name}
+			for i, g := range names {
+				v := strings.TrimSpace(m[i])
+				switch g {
+				case "name":
+					item.Name = v
+				case "device":
+					item.Device = v
+				case "driver":
+					item.Driver = v
+				case "firmware":
+					item.Firmware = strings.TrimRight(v, ".,;")
+				case "date":
+					item.Date = v
+				}
+			}
+			found = append(found, item)
+		}
+	}
+	return found
+}
+
+// inventoryKey is an item's key in the inventory map. Boot firmware is
+// keyed by name, since a PXE ROM chainloading iPXE is two bootloaders in
+// the same boot.
+func inventoryKey(item InventoryItem) string {
+	switch {
+	case item.Device != "":
+		return item.Kind + ":" + item.Device
+	case item.Kind == InventoryBootloader:
+		return item.Kind + ":" + item.Name
+	}
+	return item.Kind
+}
+
+// trackInventory merges items into a server's inventory, filling in the
+// fields each one saw. Returns whether anything was added or changed.
+// Must be called with a.mu held.
+func trackInventory(server *ServerAnalytics, found []InventoryItem, now time.Time) bool {
+	changed := false
+	for _, f := range found {
+		key := inventoryKey(f)
+		item, ok := server.Inventory[key]
+		if !ok {
+			if len(server.Inventory) >= maxInventoryItems {
+				continue
+			}
+			if server.Inventory == nil {
+				server.Inventory = make(map[string]InventoryItem)
+			}
+			item = InventoryItem{Kind: f.Kind, Device: f.Device, FirstSeen: now}
+			changed = true
+		}
+		if item.Firmware != "" && f.Firmware != "" && f.Firmware != item.Firmware {
+			log.Infof("Firmware change on %s: %s %s -> %s", server.ServerName, key, item.Firmware, f.Firmware)
+		}
+		for _, field := range []struct {
+			cur  *string
+			seen string
+		}{
+			{&item.Name, f.Name}, {&item.Driver, f.Driver}, {&item.Firmware, f.Firmware}, {&item.Date, f.Date},
+		} {
+			if field.seen != "" && field.seen != *field.cur {
+				*field.cur = field.seen
+				changed = true
+			}
+		}
+		item.LastSeen = now
+		server.Inventory[key] = item
+	}
+	return changed
+}
+
+func (m *Manager) GetInventory(serverName string) *Inventory {
+	return m.analytics.GetInventory(serverName)
+}
+
+// GetInventory returns the hardware and firmware inventory for one server.
+func (a *Analytics) GetInventory(serverName string) *Inventory {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	inv := &Inventory{Server: serverName, Items: map[string]InventoryItem{}}
+	if server, exists := a.servers[serverName]; exists && server.Inventory != nil {
+		inv.Items = maps.Clone(server.Inventory)
+	}
+	return inv
+}
@@ -0,0 +1,384 @@
+package sol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Transport is how a server's SOL console is reached.
+type Transport string
+
+const (
+	TransportNative   Transport = "native"   // go-sol (default)
+	TransportIPMITool Transport = "ipmitool" // ipmitool sol activate under a PTY
+)
+
+// ParseTransport converts a configured transport; empty is native.
+func ParseTransport(s string) (Transport, error) {
+	switch Transport(s) {
+	case "", TransportNative:
+		return TransportNative, nil
+	case TransportIPMITool:
+		return TransportIPMITool, nil
+	}
+	return "", fmt.Errorf("transport %q: want native or ipmitool", s)
+}
+
+// IPMITool says how the ipmitool transport runs ipmitool.
+type IPMITool struct {
+	Path        string   // executable; default ipmitool on PATH
+	Interface   string   // -I; default lanplus
+	CipherSuite int      // -C; 0 = ipmitool's default
+	Args        []string // more options, before the sol command
+}
+
+// SetTransports sets how SOL consoles are reached, by default and per
+// server, for sessions connected from now on.
+func (m *Manager) SetTransports(def Transport, servers map[string]Transport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transportDefault, m.transportServers = def, servers
+}
+
+// SetIPMITool sets how the ipmitool transport runs ipmitool.
+func (m *Manager) SetIPMITool(tool IPMITool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ipmitool = tool
+}
+
+// transportFor returns the server's transport. The caller holds mu.
+func (m *Manager) transportFor(serverName string) Transport {
+	if t, ok := m.transportServers[serverName]; ok {
+		return t
+	}
+	if m.transportDefault == "" {
+		return TransportNative
+	}
+	return m.transportDefault
+}
+
+// ipmitoolBanner starts the line ipmitool prints once SOL is activated.
+var ipmitoolBanner = []byte("[SOL Session operational")
+
+// ipmitoolBreakNote is what ipmitool prints when it sends a break.
+var ipmitoolBreakNote = []byte("~B [send break]\n")
+
+// ipmitoolConsole is a SOL console run by ipmitool sol activate, for BMCs
+// the native stack can't handle. ipmitool wants a terminal, to go raw and
+// read its ~ escapes, so it runs under a PTY; its stderr is kept for the
+// error when it exits.
+type ipmitoolConsole struct {
+	cmd     *exec.Cmd
+	pty     *os.File
+	stderr  tailBuffer
+	since   time.Time
+	readCh  chan []byte
+	errCh   chan error
+	ready   chan struct{} // closed when the banner has been read
+	exited  chan struct{} // closed when ipmitool has exited
+	stop    chan struct{} // closed by Close
+	waitErr error         // ipmitool's exit status, once exited
+
+	mu        sync.Mutex // serialises input
+	lineStart bool       // the last input ended a line, so ~ starts an escape
+	closeOnce sync.Once
+
+	lastRecv   atomic.Int64 // UnixNano of the last output
+	packetsIn  atomic.Uint64
+	bytesIn    atomic.Uint64
+	packetsOut atomic.Uint64
+	bytesOut   atomic.Uint64
+}
+
+// args returns the options that reach a server's BMC. The password
+// and BMC key go in the environment (-E, -K), not on the command line.
+func (t IPMITool) args(session *Session, kg []byte) []string {
+	iface := t.Interface
+	if iface == "" {
+		iface = "lanplus"
+	}
+	args := []string{"-I", iface, "-H", session.IP, "-E"}
+	if session.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(session.Port))
+	}
+	if session.Username != "" {
+		args = append(args, "-U", session.Username)
+	}
+	if t.CipherSuite != 0 {
+		args = append(args, "-C", strconv.Itoa(t.CipherSuite))
+	}
+	if len(kg) > 0 {
+		args = append(args, "-K")
+	}
+	return append(args, t.Args...)
+}
+
+// command returns an ipmitool command for a server's BMC.
+func (t IPMITool) command(ctx context.Context, session *Session, kg []byte, sub ...string) *exec.Cmd {
+	path := t.Path
+	if path == "" {
+		path = "ipmitool"
+	}
+	cmd := exec.CommandContext(ctx, path, append(t.args(session, kg), sub...)...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+session.Password)
+	if len(kg) > 0 {
+		cmd.Env = append(cmd.Env, "IPMI_KGKEY="+string(kg))
+	}
+	return cmd
+}
+
+// startIPMITool deactivates any SOL payload left active on the BMC and
+// runs ipmitool sol activate, returning once ipmitool reports the session
+// operational. ctx bounds the start only; the console runs until Close or
+// ipmitool exits.
+func startIPMITool(ctx context.Context, tool IPMITool, session *Session, kg []byte) (*ipmitoolConsole, error) {
+	if bytes.IndexByte(kg, 0) >= 0 {
+		return nil, errors.New("ipmitool can't take a BMC key containing zero bytes")
+	}
+	if out, err := tool.command(ctx, session, kg, "sol", "deactivate").CombinedOutput(); err != nil {
+		log.Debugf("ipmitool sol deactivate on %s: %v: %s", session.ServerName, err, bytes.TrimSpace(out))
+	}
+
+	c := &ipmitoolConsole{
+		cmd:       tool.command(context.Background(), session, kg, "sol", "activate"),
+		since:     time.Now(),
+		readCh:    make(chan []byte, 256),
+		errCh:     make(chan error, 1),
+		ready:     make(chan struct{}),
+		exited:    make(chan struct{}),
+		stop:      make(chan struct{}),
+		lineStart: true,
+	}
+	c.cmd.Stderr = &c.stderr
+	pty, err := startPTY(c.cmd)
+	if err != nil {
+		return nil, fmt.Errorf("start ipmitool: %w", err)
+	}
+	c.pty = pty
+	go func() {
+		c.waitErr = c.cmd.Wait()
+		close(c.exited)
+	}()
+	go c.readLoop()
+
+	select {
+	case <-c.ready:
+		c.lastRecv.Store(time.Now().UnixNano())
+		return c, nil
+	case <-c.exited:
+		c.Close()
+		return nil, c.exitError()
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop passes ipmitool's output on, dropping everything up to and
+// including its banner and its notes on sent breaks.
+func (c *ipmitoolConsole) readLoop() {
+	var pending []byte // output before the banner
+	started := false
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.pty.Read(buf)
+		if n > 0 {
+			data := buf[:n]
+			if !started {
+				pending = append(pending, data...)
+				i := bytes.Index(pending, ipmitoolBanner)
+				if i < 0 {
+					continue
+				}
+				end := bytes.IndexByte(pending[i:], '\n')
+				if end < 0 {
+					continue
+				}
+				data, pending, started = pending[i+end+1:], nil, true
+				close(c.ready)
+			}
+			data = bytes.ReplaceAll(data, ipmitoolBreakNote, nil)
+			if len(data) > 0 {
+				c.lastRecv.Store(time.Now().UnixNano())
+				c.packetsIn.Add(1)
+				c.bytesIn.Add(uint64(len(data)))
+				select {
+				case c.readCh <- append([]byte(nil), data...):
+				case <-c.stop:
+					return
+				}
+			}
+		}
+		if err != nil {
+			// EIO once ipmitool has exited and the PTY has no writer
+			break
+		}
+	}
+	<-c.exited
+	if !isClosed(c.stop) {
+		c.errCh <- c.exitError()
+	}
+}
+
+// exitError describes why ipmitool exited, from its last words on stderr.
+func (c *ipmitoolConsole) exitError() error {
+	status := "ipmitool exited"
+	if c.waitErr != nil {
+		status = fmt.Sprintf("ipmitool exited: %v", c.waitErr)
+	}
+	if msg := c.stderr.lastLine(); msg != "" {
+		return fmt.Errorf("%s: %s", status, msg)
+	}
+	return errors.New(status)
+}
+
+// Read returns the console output channel.
+func (c *ipmitoolConsole) Read() <-chan []byte { return c.readCh }
+
+// Err returns a channel that receives why ipmitool exited, unless Close
+// stopped it.
+func (c *ipmitoolConsole) Err() <-chan error { return c.errCh }
+
+// Write sends console input. A ~ at the start of a line is doubled, so
+// ipmitool passes it on rather than taking it as an escape.
+func (c *ipmitoolConsole) Write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]byte, 0, len(data)+1)
+	for _, b := range data {
+		if c.lineStart && b == '~' {
+			out = append(out, '~')
+		}
+		out = append(out, b)
+		c.lineStart = b == '\r' || b == '\n'
+	}
+	return c.write(out)
+}
+
+// SendBreak sends a serial break with ipmitool's ~B escape, which it only
+// takes at the start of a line.
+func (c *ipmitoolConsole) SendBreak() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.lineStart {
+		return errors.New("ipmitool sends a break only at the start of a line; send Enter first")
+	}
+	c.lineStart = false
+	return c.write([]byte("~B"))
+}
+
+// write writes to ipmitool's terminal. The caller holds mu.
+func (c *ipmitoolConsole) write(p []byte) error {
+	if isClosed(c.exited) {
+		return errors.New("ipmitool has exited")
+	}
+	if _, err := c.pty.Write(p); err != nil {
+		return err
+	}
+	c.packetsOut.Add(1)
+	c.bytesOut.Add(uint64(len(p)))
+	return nil
+}
+
+// Close ends the session: with ipmitool's ~. escape, which deactivates the
+// payload and closes the BMC session, or failing that by signal.
+func (c *ipmitoolConsole) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.mu.Lock()
+		if c.lineStart {
+			c.write([]byte("~."))
+		}
+		c.mu.Unlock()
+		if !c.waitExit(2 * time.Second) {
+			c.cmd.Process.Signal(syscall.SIGTERM)
+			if !c.waitExit(2 * time.Second) {
+				c.cmd.Process.Kill()
+				<-c.exited
+			}
+		}
+		c.pty.Close()
+	})
+	return nil
+}
+
+// waitExit waits up to d for ipmitool to exit and reports whether it has.
+func (c *ipmitoolConsole) waitExit(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.exited:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// LastRecvTime is when output last arrived. ipmitool keeps the session
+// alive itself and exits when the BMC stops answering, so while it runs the
+// console counts as heard from now.
+func (c *ipmitoolConsole) LastRecvTime() time.Time {
+	if !isClosed(c.exited) {
+		return time.Now()
+	}
+	return time.Unix(0, c.lastRecv.Load())
+}
+
+// Stats counts output reads and input writes as packets; ipmitool reports
+// nothing about retransmission.
+func (c *ipmitoolConsole) Stats() SOLStats {
+	return SOLStats{
+		Since:      c.since,
+		PacketsIn:  c.packetsIn.Load(),
+		BytesIn:    c.bytesIn.Load(),
+		PacketsOut: c.packetsOut.Load(),
+		BytesOut:   c.bytesOut.Load(),
+	}
+}
+
+// isClosed reports whether ch is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// tailBuffer keeps the last 4KB written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 4096 {
+		t.buf = t.buf[len(t.buf)-4096:]
+	}
+	return len(p), nil
+}
+
+// lastLine returns the last non-empty line written.
+func (t *tailBuffer) lastLine() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(string(t.buf)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -3,75 +3,173 @@ package sol
 import (
 	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gwest/go-sol"
 	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/telemetry"
 )
 
 type Session struct {
 	ServerName   string
 	IP           string
+	Port         int
 	Username     string
 	Password     string
+	Kg           string
 	Connected    bool
+	Standby      bool // powered off; SOL waits for power-on instead of retrying
 	LastError    string
 	LastActivity time.Time
 	cancel       context.CancelFunc
-	solSession   *sol.Session
+	solSession   *sol.Session      // native session, also for IPMI commands; nil with ipmitool
+	console      console           // live SOL console: solSession or an ipmitool process
+	connection   ConnectionSetting // the console's, for the health check
+	wake         chan struct{}     // ends standby or a backoff wait early
+
+	status   SessionStatus // lifecycle state; read with Status
+	statusMu sync.Mutex
 }
 
+// console is a connected SOL console.
+type console interface {
+	Read() <-chan []byte
+	Err() <-chan error
+	Write(data []byte) error
+	SendBreak() error
+	Close() error
+	LastRecvTime() time.Time
+	Stats() SOLStats
+}
+
+// SOLConfig holds the SOL configuration parameters written to BMCs before
+// activation.
+type SOLConfig = sol.SOLConfig
+
+// SOLStats counts a session's SOL traffic: packets and bytes each way,
+// retransmissions, NACKs and ACK latency.
+type SOLStats = sol.Stats
+
 // SSEEvent is a named event sent to SSE subscribers (e.g. logchange).
 type SSEEvent struct {
-	Name string
-	Data string
+	Name    string
+	Data    string
+	Channel string // ChannelRaw, ChannelAnalytics or ChannelState
+	Server  string
 }
 
 type Manager struct {
-	username       string
-	password       string
-	logPath        string
-	sessions       map[string]*Session
-	mu             sync.RWMutex
-	logWriter      LogWriter
-	rebootDetector *RebootDetector
-	analytics      *Analytics
-	subscribers    map[string][]chan []byte
-	subMu          sync.RWMutex
-	screenBufs     map[string]*ScreenBuffer
-	notifySubs     map[string][]chan SSEEvent
-	notifyMu       sync.RWMutex
+	username         string
+	password         string
+	kg               string
+	logPath          string
+	sessions         map[string]*Session
+	mu               sync.RWMutex
+	logWriter        LogWriter
+	rebootDetector   *RebootDetector
+	analytics        *Analytics
+	actors           map[string]*ServerActor
+	actorMu          sync.Mutex
+	evictAfter       atomic.Int64   // nanoseconds; new actors start with it
+	screenSize       int            // screen buffer bytes; 0 = defaultScreenBufSize
+	screenSizes      map[string]int // per-server screen buffer bytes
+	notifySubs       map[string][]chan SSEEvent
+	notifyMu         sync.RWMutex
+	controllers      map[string]*inputController
+	viewers          map[string][]*Viewer // server -> open viewer connections
+	ctrlMu           sync.Mutex
+	sel              *SELCollector
+	history          *ConnectHistory
+	sensors          *SensorCollector
+	power            *PowerCollector
+	limiter          *bmcLimiter
+	connects         *connectPool
+	solRetries       int                      // go-sol RetryCount for new sessions
+	solRetryDelay    time.Duration            // go-sol RetryInterval for new sessions
+	solConfig        SOLConfig                // written to BMCs before activation; zero = none
+	solAutoEnable    bool                     // enable SOL on BMCs where it is disabled
+	serialDefault    SerialConfig             // serial routing before activation; zero = none
+	serialServers    map[string]SerialConfig  // per-server overrides of serialDefault
+	backoffDefault   Backoff                  // reconnect backoff; zero fields = defaults
+	backoffServers   map[string]Backoff       // per-server overrides of backoffDefault
+	chassisPoll      time.Duration            // power-on polling while on standby; 0 = no standby
+	credentials      CredentialProvider       // external per-server credentials, nil = none
+	sourcePorts      *SourcePorts             // source address, ports and DSCP of BMC sockets; nil = any
+	proxyDefault     DialFunc                 // route to BMCs through a proxy; nil = direct
+	proxyServers     map[string]DialFunc      // per-server routes replacing proxyDefault; nil = direct
+	quirksDefault    QuirksSetting            // BMC handshake quirks; zero = detected from Get Device ID
+	quirksServers    map[string]QuirksSetting // per-server overrides of quirksDefault
+	transportDefault Transport                // how SOL consoles are reached; empty = native
+	transportServers map[string]Transport     // per-server overrides of transportDefault
+	ipmitool         IPMITool                 // how the ipmitool transport runs it
+
+	connDefault  ConnectionSetting              // connect and inactivity timeouts etc.; zero fields = defaults
+	connServers  map[string]ConnectionSetting   // per-server overrides of connDefault
+	connOverride func(string) ConnectionSetting // overrides from outside the config; nil = none
+
+	pauseRotationOnLoop atomic.Bool // refuse log rotation while a server is in a reboot loop
+	readOnly            atomic.Bool // gateways take no console input (server.read_only)
+
+	sessionWG    sync.WaitGroup // running runSession goroutines
+	shuttingDown bool           // set by Shutdown; guarded by mu
 }
 
 type LogWriter interface {
 	Write(serverName string, data []byte) error
+	WriteNote(serverName string, data []byte) error
 	Rotate(serverName string) error
 	CanRotate(serverName string) bool
+	Position(serverName string) (file string, offset int64, line int)
 }
 
-func NewManager(username, password string, logWriter LogWriter, rebootDetector *RebootDetector, dataPath string) *Manager {
+func NewManager(username, password, kg string, logWriter LogWriter, rebootDetector *RebootDetector, dataPath string) *Manager {
 	m := &Manager{
 		username:       username,
 		password:       password,
+		kg:             kg,
 		logPath:        dataPath,
 		sessions:       make(map[string]*Session),
 		logWriter:      logWriter,
 		rebootDetector: rebootDetector,
 		analytics:      NewAnalytics(dataPath),
-		subscribers:    make(map[string][]chan []byte),
-		screenBufs:     make(map[string]*ScreenBuffer),
+		actors:         make(map[string]*ServerActor),
 		notifySubs:     make(map[string][]chan SSEEvent),
+		controllers:    make(map[string]*inputController),
+		viewers:        make(map[string][]*Viewer),
+		sel:            NewSELCollector(dataPath),
+		history:        NewConnectHistory(dataPath),
+		sensors:        NewSensorCollector(),
+		power:          NewPowerCollector(),
+		limiter:        newBMCLimiter(),
+		connects:       newConnectPool(),
 	}
+	m.analytics.onEvent = m.publishAnalytics
 	go m.healthCheck()
 	return m
 }
 
+// SetDataPath moves analytics, SEL, connect history and session log directories to a new data
+// path, e.g. after the log directory has been migrated.
+func (m *Manager) SetDataPath(dataPath string) {
+	m.mu.Lock()
+	m.logPath = dataPath
+	m.mu.Unlock()
+	m.analytics.setDataPath(dataPath)
+	m.sel.setDataPath(dataPath)
+	m.history.setDataPath(dataPath)
+}
+
 func (m *Manager) GetAnalytics(serverName string) *ServerAnalytics {
 	return m.analytics.GetServerAnalytics(serverName)
 }
@@ -84,41 +182,217 @@ func (m *Manager) RecordRotation(serverName string) {
 	m.analytics.RecordRotation(serverName)
 }
 
-func (m *Manager) StartSession(serverName, ip, username, password string) {
+func (m *Manager) RecordPowerCommand(serverName string) {
+	m.analytics.RecordPowerCommand(serverName)
+}
+
+// CredentialProvider supplies BMC credentials from an external store, such
+// as Vault. Non-empty values it returns take precedence over discovered and
+// configured ones.
+type CredentialProvider interface {
+	Lookup(serverName string) (username, password, kg string, ok bool)
+}
+
+// SetCredentialProvider sets the store consulted for each session's
+// credentials; nil uses only discovered and configured ones.
+func (m *Manager) SetCredentialProvider(p CredentialProvider) {
 	m.mu.Lock()
-	if existing, exists := m.sessions[serverName]; exists {
-		if existing.cancel != nil {
-			existing.cancel()
-		}
-		if existing.solSession != nil {
-			existing.solSession.Close()
-		}
+	defer m.mu.Unlock()
+	m.credentials = p
+}
+
+// providerCredentials overrides username, password and kg with the
+// credential provider's values for serverName. It may block on the
+// provider, so must be called without m.mu held.
+func (m *Manager) providerCredentials(serverName, username, password, kg string) (string, string, string) {
+	m.mu.RLock()
+	p := m.credentials
+	m.mu.RUnlock()
+	if p == nil {
+		return username, password, kg
+	}
+	u, pw, k, ok := p.Lookup(serverName)
+	if !ok {
+		return username, password, kg
 	}
+	if u != "" {
+		username = u
+	}
+	if pw != "" {
+		password = pw
+	}
+	if k != "" {
+		kg = k
+	}
+	return username, password, kg
+}
 
-	// Use per-server credentials, fall back to global config
+// resolveCredentials applies the global IPMI credentials to empty per-server
+// values. Must be called with m.mu held.
+func (m *Manager) resolveCredentials(username, password, kg string) (string, string, string) {
 	if username == "" {
 		username = m.username
 	}
 	if password == "" {
 		password = m.password
 	}
+	if kg == "" {
+		kg = m.kg
+	}
+	return username, password, kg
+}
+
+// SessionChanged reports whether a running session's address or effective
+// credentials differ from the given discovery values, i.e. whether it needs
+// a restart to pick them up.
+func (m *Manager) SessionChanged(session *Session, ip string, port int, username, password, kg string) bool {
+	username, password, kg = m.providerCredentials(session.ServerName, username, password, kg)
+	m.mu.RLock()
+	username, password, kg = m.resolveCredentials(username, password, kg)
+	m.mu.RUnlock()
+	return session.IP != ip || session.Port != port || session.Username != username ||
+		session.Password != password || session.Kg != kg
+}
+
+// SetDefaultCredentials replaces the global IPMI credentials used for servers
+// without their own. Running sessions are not touched.
+func (m *Manager) SetDefaultCredentials(username, password, kg string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.username = username
+	m.password = password
+	m.kg = kg
+}
+
+// SetSOLRetry sets outbound SOL retransmission for sessions connected from
+// now on: resends of an unacknowledged packet and the ACK wait. Zero values
+// use the go-sol defaults.
+func (m *Manager) SetSOLRetry(retries int, interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.solRetries = retries
+	m.solRetryDelay = interval
+}
+
+// SetSOLConfig sets the SOL configuration parameters (bit rate, BMC retry,
+// character accumulate) written to each BMC before SOL is activated, for
+// sessions connected from now on.
+func (m *Manager) SetSOLConfig(c SOLConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.solConfig = c
+}
+
+// SetSOLAutoEnable sets whether sessions connected from now on enable SOL
+// on the BMC, and the SOL payload for their user, when activation fails
+// because it is disabled.
+func (m *Manager) SetSOLAutoEnable(enable bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.solAutoEnable = enable
+}
+
+// SetSerialConfig sets the serial MUX setting and console UART selection
+// applied to each BMC before SOL is activated, by default and per server,
+// for sessions connected from now on.
+func (m *Manager) SetSerialConfig(def SerialConfig, servers map[string]SerialConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.serialDefault, m.serialServers = def, servers
+}
+
+// SOLStats returns a server's SOL traffic counters for its current
+// connection.
+func (m *Manager) SOLStats(serverName string) (SOLStats, bool) {
+	m.mu.RLock()
+	session, exists := m.sessions[serverName]
+	m.mu.RUnlock()
+	if !exists || session.console == nil {
+		return SOLStats{}, false
+	}
+	return session.console.Stats(), true
+}
+
+// ParseKg decodes a configured BMC key. Values starting with "0x" are hex
+// (as with ipmitool -y), anything else is used as-is (ipmitool -k). Keys are
+// at most 20 bytes.
+func ParseKg(kg string) ([]byte, error) {
+	if kg == "" {
+		return nil, nil
+	}
+	key := []byte(kg)
+	if strings.HasPrefix(kg, "0x") || strings.HasPrefix(kg, "0X") {
+		decoded, err := hex.DecodeString(kg[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex kg: %w", err)
+		}
+		key = decoded
+	}
+	if len(key) > 20 {
+		return nil, fmt.Errorf("kg is %d bytes, maximum is 20", len(key))
+	}
+	return key, nil
+}
+
+func (m *Manager) StartSession(serverName, ip string, port int, username, password, kg string) {
+	username, password, kg = m.providerCredentials(serverName, username, password, kg)
+	m.mu.Lock()
+	if m.shuttingDown {
+		m.mu.Unlock()
+		log.Debugf("Not starting SOL session for %s: shutting down", serverName)
+		return
+	}
+	existing := m.sessions[serverName]
+	if existing != nil {
+		if existing.cancel != nil {
+			existing.cancel()
+		}
+		if existing.console != nil {
+			existing.console.Close()
+		}
+	}
+
+	username, password, kg = m.resolveCredentials(username, password, kg)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	session := &Session{
 		ServerName: serverName,
 		IP:         ip,
+		Port:       port,
 		Username:   username,
 		Password:   password,
+		Kg:         kg,
 		Connected:  false,
 		cancel:     cancel,
+		wake:       make(chan struct{}, 1),
 	}
+	session.setState(SessionDiscovering)
+	session.inherit(existing)
 	m.sessions[serverName] = session
+	m.sessionWG.Add(1)
 	m.mu.Unlock()
 
-	go m.runSession(ctx, session)
+	go func() {
+		defer m.sessionWG.Done()
+		m.runSession(ctx, session)
+	}()
 }
 
+// StopSession disconnects a server, e.g. when it goes offline or before a
+// restart. Its actor stays, so viewers keep their screen and subscription
+// and see output again once a session is started.
 func (m *Manager) StopSession(serverName string) {
+	m.stopSession(serverName)
+}
+
+// RemoveSession disconnects a server and tears down its data path, closing
+// any live subscriptions. Used when a server is removed.
+func (m *Manager) RemoveSession(serverName string) {
+	m.stopSession(serverName)
+	m.removeActor(serverName)
+}
+
+func (m *Manager) stopSession(serverName string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -126,10 +400,10 @@ func (m *Manager) StopSession(serverName string) {
 		if session.cancel != nil {
 			session.cancel()
 		}
-		if session.solSession != nil {
-			session.solSession.Close()
+		if session.console != nil {
+			session.console.Close()
 		}
-		go clearBMCSessions(session.IP, session.Username, session.Password)
+		go clearBMCSessions(m.proxyFor(serverName), session.IP, session.Username, session.Password)
 		delete(m.sessions, serverName)
 	}
 }
@@ -144,14 +418,19 @@ func (m *Manager) RestartSession(serverName string) {
 		return
 	}
 	ip := session.IP
+	port := session.Port
 	username := session.Username
 	password := session.Password
+	kg := session.Kg
 	m.mu.Unlock()
 
 	log.Infof("Restarting SOL session for %s", serverName)
-	m.StopSession(serverName)
-	clearBMCSessions(ip, username, password)
-	m.StartSession(serverName, ip, username, password)
+	m.stopSession(serverName)
+	clearBMCSessions(m.bmcProxy(serverName), ip, username, password)
+	m.StartSession(serverName, ip, port, username, password, kg)
+	if s := m.GetSession(serverName); s != nil {
+		s.inherit(session)
+	}
 }
 
 func (m *Manager) GetSession(serverName string) *Session {
@@ -168,10 +447,10 @@ func (m *Manager) SendCommand(serverName string, data []byte) error {
 	if !exists {
 		return fmt.Errorf("server not found: %s", serverName)
 	}
-	if !session.Connected || session.solSession == nil {
+	if !session.Connected || session.console == nil {
 		return fmt.Errorf("server not connected: %s", serverName)
 	}
-	return session.solSession.Write(data)
+	return session.console.Write(data)
 }
 
 func (m *Manager) GetSessions() map[string]*Session {
@@ -185,44 +464,30 @@ func (m *Manager) GetSessions() map[string]*Session {
 	return result
 }
 
-func (m *Manager) Subscribe(serverName string) chan []byte {
-	ch := make(chan []byte, 64)
-	m.subMu.Lock()
-	m.subscribers[serverName] = append(m.subscribers[serverName], ch)
-	m.subMu.Unlock()
+func (m *Manager) Subscribe(serverName string) chan Chunk {
+	_, ch := m.actor(serverName).Attach(-1)
 	return ch
 }
 
-func (m *Manager) Unsubscribe(serverName string, ch chan []byte) {
-	m.subMu.Lock()
-	defer m.subMu.Unlock()
-	subs := m.subscribers[serverName]
-	for i, s := range subs {
-		if s == ch {
-			m.subscribers[serverName] = append(subs[:i], subs[i+1:]...)
-			close(ch)
-			return
-		}
-	}
+// Attach subscribes to a server's live output and returns the screen buffer
+// as of the moment of subscription, for gap-free terminal catchup. With a
+// cursor (-1 for none) from an earlier stream, only the output since is
+// returned when the screen buffer still holds it.
+func (m *Manager) Attach(serverName string, cursor int64) (Catchup, chan Chunk) {
+	return m.actor(serverName).Attach(cursor)
 }
 
-func (m *Manager) getOrCreateScreenBuf(name string) *ScreenBuffer {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if m.screenBufs[name] == nil {
-		m.screenBufs[name] = NewScreenBuffer(defaultScreenBufSize)
+func (m *Manager) Unsubscribe(serverName string, ch chan Chunk) {
+	if a := m.existingActor(serverName); a != nil {
+		a.Unsubscribe(ch)
 	}
-	return m.screenBufs[name]
 }
 
 func (m *Manager) GetScreenBuffer(serverName string) []byte {
-	m.mu.RLock()
-	sb := m.screenBufs[serverName]
-	m.mu.RUnlock()
-	if sb == nil {
-		return nil
+	if a := m.existingActor(serverName); a != nil {
+		return a.Snapshot()
 	}
-	return sb.Bytes()
+	return nil
 }
 
 func (m *Manager) SubscribeNotify(serverName string) chan SSEEvent {
@@ -249,6 +514,9 @@ func (m *Manager) UnsubscribeNotify(serverName string, ch chan SSEEvent) {
 func (m *Manager) notify(serverName string, event SSEEvent) {
 	m.notifyMu.RLock()
 	subs := m.notifySubs[serverName]
+	if event.Channel != ChannelRaw {
+		subs = append(subs[:len(subs):len(subs)], m.notifySubs[allServers]...)
+	}
 	m.notifyMu.RUnlock()
 	for _, ch := range subs {
 		select {
@@ -263,64 +531,82 @@ func (m *Manager) notify(serverName string, event SSEEvent) {
 // continue showing actual SOL output. The BIOS handles its own screen
 // clearing via cursor positioning when it starts a new boot.
 func (m *Manager) OnLogRotation(serverName, newLogFile string) {
-	m.notify(serverName, SSEEvent{Name: "logchange", Data: newLogFile})
+	m.notify(serverName, SSEEvent{Name: "logchange", Data: newLogFile, Channel: ChannelRaw, Server: serverName})
+	m.publishState(serverName, StateLogChange, "", newLogFile)
 	log.Infof("Log rotation notified for %s: %s", serverName, newLogFile)
 }
 
-func (m *Manager) broadcast(serverName string, data []byte) {
-	m.subMu.RLock()
-	subs := m.subscribers[serverName]
-	m.subMu.RUnlock()
-	for _, ch := range subs {
-		// Non-blocking send — drop data for slow clients
-		select {
-		case ch <- data:
-		default:
-		}
-	}
-}
-
 // healthCheck periodically inspects all connected sessions for staleness.
 // It checks go-sol's lastRecvTime (which tracks ALL BMC packets, including
 // keepalive responses) rather than LastActivity (which only tracks SOL data).
 // This correctly handles idle servers that produce no console output.
+// Each check logs the session's traffic counters, at info level when
+// packets were retransmitted, NACKed or dropped since the previous check.
 func (m *Manager) healthCheck() {
 	ticker := time.NewTicker(60 * time.Second)
 	defer ticker.Stop()
 
-	const staleThreshold = 90 * time.Second
+	prev := make(map[string]SOLStats) // counters at the last check, to spot a link going bad
 
 	for range ticker.C {
 		m.mu.RLock()
 		var stale []string
+		seen := make(map[string]bool)
 		for name, session := range m.sessions {
 			if !session.Connected {
 				continue
 			}
-			if session.solSession == nil {
-				log.Warnf("Health check: %s marked connected but solSession is nil, will restart", name)
+			if session.console == nil {
+				log.Warnf("Health check: %s marked connected but has no console, will restart", name)
 				stale = append(stale, name)
 				continue
 			}
-			lastRecv := session.solSession.LastRecvTime()
+			lastRecv := session.console.LastRecvTime()
 			idle := time.Since(lastRecv)
-			if idle > staleThreshold {
-				log.Warnf("Health check: %s no BMC packets for %v (threshold %v), will restart", name, idle.Round(time.Second), staleThreshold)
+			if staleThreshold := session.connection.staleThreshold(); idle > staleThreshold {
+				log.Warnf("Health check: %s no BMC packets for %v (threshold %v, %s), will restart", name, idle.Round(time.Second), staleThreshold, statsSummary(session.console.Stats()))
 				stale = append(stale, name)
 				continue
 			}
-			log.Debugf("Health check: %s ok (last BMC packet %v ago)", name, idle.Round(time.Second))
+			stats := session.console.Stats()
+			seen[name] = true
+			losing := false
+			if last, ok := prev[name]; ok && last.Since.Equal(stats.Since) &&
+				(stats.Retransmits > last.Retransmits || stats.Nacks > last.Nacks || stats.Dropped > last.Dropped) {
+				losing = true
+				log.Infof("Health check: %s link losing packets: %d retransmits, %d nacks, %d dropped since last check (%s)", name,
+					stats.Retransmits-last.Retransmits, stats.Nacks-last.Nacks, stats.Dropped-last.Dropped, statsSummary(stats))
+			} else {
+				log.Debugf("Health check: %s ok (last BMC packet %v ago, %s)", name, idle.Round(time.Second), statsSummary(stats))
+			}
+			session.setDegraded(losing)
+			prev[name] = stats
 		}
 		m.mu.RUnlock()
+		for name := range prev {
+			if !seen[name] {
+				delete(prev, name)
+			}
+		}
 
 		for _, name := range stale {
 			m.RestartSession(name)
 		}
+		m.limiter.prune()
 	}
 }
 
+// statsSummary formats SOL counters for the health check log.
+func statsSummary(st SOLStats) string {
+	return fmt.Sprintf("in %d pkts/%d B, out %d pkts/%d B, %d retransmits, %d nacks, %d dropped, ack %.1fms",
+		st.PacketsIn, st.BytesIn, st.PacketsOut, st.BytesOut, st.Retransmits, st.Nacks, st.Dropped, st.AckLatency)
+}
+
 func (m *Manager) runSession(ctx context.Context, session *Session) {
-	backoff := time.Second
+	waits := 0       // backoff waits in a row
+	failing := false // connect_failed already published for this outage
+	failures := 0    // connects in a row that failed outright
+	defer session.setState(SessionStopped)
 
 	for {
 		select {
@@ -329,38 +615,97 @@ func (m *Manager) runSession(ctx context.Context, session *Session) {
 		default:
 		}
 
-		log.Infof("Connecting native SOL to %s (%s)", session.ServerName, session.IP)
+		m.mu.RLock()
+		transport := m.transportFor(session.ServerName)
+		m.mu.RUnlock()
+		log.Infof("Connecting %s SOL to %s (%s)", transport, session.ServerName, session.IP)
 
+		session.attempting()
 		connectTime := time.Now()
 		err := m.connectSOL(ctx, session)
+		m.recordAttempt(ctx, session, connectTime, err)
+		backoff := m.backoffFor(session.ServerName)
 		if err != nil {
 			session.Connected = false
+			// A session that connected has already published disconnected;
+			// report repeated connect failures only once per outage
+			if session.LastActivity.After(connectTime) {
+				failing = false
+				failures = 0
+			} else if !failing && ctx.Err() == nil {
+				m.publishState(session.ServerName, StateConnectFailed, err.Error(), "")
+				failing = true
+			}
 			session.LastError = err.Error()
 			log.Errorf("SOL connection failed for %s: %v", session.ServerName, err)
 
-			// If we were connected for more than 30 seconds, reset backoff
-			// (this was a session that worked, not an immediate connection failure)
-			if time.Since(connectTime) > 30*time.Second {
-				backoff = time.Second
+			// A BMC may refuse SOL while the host is off; rather than
+			// retrying, wait on standby for power-on
+			failures++
+			if failures >= standbyAfter && m.poweredOff(ctx, session) {
+				if !m.standby(ctx, session) {
+					return
+				}
+				waits = 0
+				failing = false
+				failures = 0
+				continue
+			}
+
+			// A session that stayed connected past the reset threshold
+			// worked; start the backoff over rather than after a failure
+			if time.Since(connectTime) > backoff.Reset {
+				waits = 0
 			}
 		}
 
+		// Jittered exponential backoff, so servers that dropped together
+		// (e.g. a management switch blip) don't reconnect together
+		waits++
+		wait := backoff.wait(waits)
+		select {
+		case <-session.wake: // stale, from a standby that already ended
+		default:
+		}
+		session.backingOff(wait)
+		timer := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return
-		case <-time.After(backoff):
-			backoff = backoff * 2
-			if backoff > 60*time.Second {
-				backoff = 60 * time.Second
-			}
+		case <-session.wake:
+			timer.Stop()
+			log.Infof("Reconnecting %s without waiting out the backoff", session.ServerName)
+		case <-timer.C:
 		}
 	}
 }
 
+// recordAttempt adds a finished connect attempt to the server's history: a
+// failed connect, or a connection that came up and has now ended.
+func (m *Manager) recordAttempt(ctx context.Context, session *Session, start time.Time, err error) {
+	reason := ""
+	if ctx.Err() != nil {
+		reason = "session stopped"
+	} else if err != nil {
+		reason = err.Error()
+	}
+	if st := session.Status(); st.LastConnected != nil && st.LastConnected.After(start) {
+		m.history.ended(session.ServerName, reason)
+		return
+	}
+	m.history.record(session.ServerName, ConnectAttempt{
+		Time:     start,
+		Duration: time.Since(start).Seconds(),
+		Error:    reason,
+	})
+}
+
 // clearBMCSessions clears stale Redfish sessions on Dell iDRAC before/after SOL operations.
-// Non-Dell BMCs will simply not respond and we skip silently.
-func clearBMCSessions(ip, username, password string) {
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+// Non-Dell BMCs will simply not respond and we skip silently. dial is the
+// server's proxy, nil = direct.
+func clearBMCSessions(dial DialFunc, ip, username, password string) {
+	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, DialContext: dial}
 	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
 
 	sessURL := fmt.Sprintf("https://%s/redfish/v1/Sessions", ip)
@@ -410,90 +755,196 @@ func clearBMCSessions(ip, username, password string) {
 
 func (m *Manager) connectSOL(ctx context.Context, session *Session) error {
 	// Ensure log directory exists
+	m.mu.RLock()
 	logDir := filepath.Join(m.logPath, session.ServerName)
+	retries, retryDelay := m.solRetries, m.solRetryDelay
+	var solConfig *SOLConfig
+	if !m.solConfig.IsZero() {
+		c := m.solConfig
+		solConfig = &c
+	}
+	autoEnable := m.solAutoEnable
+	dial, proxy := m.bmcDial(session.ServerName), m.proxyFor(session.ServerName)
+	quirks := m.quirksFor(session.ServerName)
+	transport, tool := m.transportFor(session.ServerName), m.ipmitool
+	conn := m.connectionFor(session.ServerName)
+	serial, ok := m.serialServers[session.ServerName]
+	if !ok {
+		serial = m.serialDefault
+	}
+	m.mu.RUnlock()
+	var serialConfig *SerialConfig
+	if !serial.IsZero() {
+		serialConfig = &serial
+	}
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return fmt.Errorf("failed to create log dir: %w", err)
 	}
+	kg, err := ParseKg(session.Kg)
+	if err != nil {
+		return err
+	}
+	if err := m.limiter.wait(ctx, bmcAddress(session)); err != nil {
+		return err
+	}
+
+	// Take a connect slot, queueing behind other sessions when
+	// ipmi.max_connecting are already connecting
+	release, err := m.connects.acquire(ctx, session.ServerName, func() { session.setState(SessionQueued) })
+	if err != nil {
+		return err
+	}
+	session.setState(SessionConnecting)
+
+	// Trace the connect: clearing stale sessions, then each go-sol phase
+	serverAttr := telemetry.String("server", session.ServerName)
+	spanCtx, span := telemetry.Start(ctx, "sol.connect", serverAttr, telemetry.String("bmc.host", session.IP))
+	connectStart := time.Now()
 
 	// Clear stale sessions before connecting
-	clearBMCSessions(session.IP, session.Username, session.Password)
-
-	// Create native SOL session using per-server credentials
-	solSession := sol.New(sol.Config{
-		Host:              session.IP,
-		Port:              623,
-		Username:          session.Username,
-		Password:          session.Password,
-		Timeout:           30 * time.Second,
-		InactivityTimeout: 2 * time.Minute,
-		Logf: func(format string, args ...interface{}) {
-			log.Debugf("[go-sol] "+format, args...)
-		},
-	})
+	clearBMCSessions(proxy, session.IP, session.Username, session.Password)
+	telemetry.Record(spanCtx, "sol.clear_sessions", connectStart, time.Now(), nil)
 
 	// Connect with timeout
-	connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	err := solSession.Connect(connectCtx)
+	connectCtx, cancel := context.WithTimeout(ctx, conn.Timeout)
+	var con console
+	var solSession *sol.Session
+	if transport == TransportIPMITool {
+		start := time.Now()
+		var c *ipmitoolConsole
+		c, err = startIPMITool(connectCtx, tool, session, kg)
+		if err == nil {
+			con = c
+		}
+		telemetry.Record(spanCtx, "sol.ipmitool", start, time.Now(), err)
+		telemetry.SOLConnectDuration.Observe(start, serverAttr,
+			telemetry.String("phase", "ipmitool"), telemetry.Bool("error", err != nil))
+	} else {
+		// Create native SOL session using per-server credentials
+		solSession = sol.New(sol.Config{
+			Host:              session.IP,
+			Port:              session.Port, // 0 = go-sol default (623)
+			Username:          session.Username,
+			Password:          session.Password,
+			Kg:                kg,
+			Timeout:           conn.Timeout,
+			InactivityTimeout: conn.InactivityTimeout,
+			KeepaliveInterval: conn.KeepaliveInterval,
+			CipherSuite:       conn.CipherSuite,
+			NoV15:             !conn.allowsV15(),
+			RetryCount:        retries,
+			RetryInterval:     retryDelay,
+			SOL:               solConfig,
+			Serial:            serialConfig,
+			AutoEnable:        autoEnable,
+			Dial:              dial,
+			Quirks:            quirks,
+			Logf: func(format string, args ...interface{}) {
+				log.Debugf("[go-sol] "+format, args...)
+			},
+			Phase: func(name string, start time.Time, err error) {
+				if (name == sol.PhaseRAKP || name == sol.PhaseActivateSession) && err == nil {
+					session.setState(SessionHandshaking)
+				}
+				switch {
+				case name == sol.PhaseActivateSession && err == nil:
+					log.Warnf("%s's BMC has no RMCP+; using an IPMI v1.5 session (MD5 authentication, no encryption)", session.ServerName)
+				case name == sol.PhaseSOLConfig && err != nil:
+					log.Warnf("SOL configuration for %s not applied: %v", session.ServerName, err)
+				case name == sol.PhaseSerial && err != nil:
+					log.Warnf("Serial MUX/UART selection for %s not applied: %v", session.ServerName, err)
+				case name == sol.PhaseSOLEnable && err != nil:
+					log.Errorf("SOL is disabled on %s's BMC and enabling it failed: %v", session.ServerName, err)
+				case name == sol.PhaseSOLEnable:
+					log.Warnf("SOL was disabled on %s's BMC; enabled it for user %s", session.ServerName, session.Username)
+				}
+				telemetry.Record(spanCtx, "sol."+name, start, time.Now(), err)
+				telemetry.SOLConnectDuration.Observe(start, serverAttr,
+					telemetry.String("phase", name), telemetry.Bool("error", err != nil))
+			},
+		})
+		err = solSession.Connect(connectCtx)
+		con = solSession
+	}
 	cancel()
-
+	release()
+	span.SetError(err)
+	span.End()
+	telemetry.SOLConnectDuration.Observe(connectStart, serverAttr,
+		telemetry.String("phase", "total"), telemetry.Bool("error", err != nil))
+
+	if errors.Is(err, sol.ErrNoRMCPP) {
+		return fmt.Errorf("SOL connect failed: %w (set connection.allow_v15 to accept IPMI v1.5)", err)
+	}
 	if err != nil {
 		return fmt.Errorf("SOL connect failed: %w", err)
 	}
 
 	session.solSession = solSession
+	session.console = con
+	session.connection = conn
 	session.Connected = true
 	session.LastError = ""
 	session.LastActivity = time.Now()
-	log.Infof("Native SOL connected to %s", session.ServerName)
-
-	// Clear screen for all SSE subscribers so xterm.js starts fresh
-	m.broadcast(session.ServerName, []byte("\x1b[2J\x1b[H"))
+	attemptStart := connectStart
+	if st := session.Status(); st.LastAttempt != nil {
+		attemptStart = *st.LastAttempt // before any rate limit or queue wait
+	}
+	session.connected()
+	m.history.record(session.ServerName, ConnectAttempt{
+		Time:      attemptStart,
+		Duration:  time.Since(attemptStart).Seconds(),
+		Connected: true,
+	})
+	if solSession != nil {
+		log.Infof("Native SOL connected to %s (BMC quirks: %s)", session.ServerName, solSession.Quirks().Profile)
+		if suite := solSession.CipherSuite(); !solSession.V15() && suite < conn.CipherSuite {
+			log.Warnf("%s's BMC refused cipher suite %d; using suite %d", session.ServerName, conn.CipherSuite, suite)
+		}
+	} else {
+		log.Infof("ipmitool SOL connected to %s", session.ServerName)
+	}
+	m.publishState(session.ServerName, StateConnected, "", "")
 
-	// Reset screen buffer for new connection
-	sb := m.getOrCreateScreenBuf(session.ServerName)
-	sb.Reset()
+	// Clear screen for all SSE subscribers so xterm.js starts fresh, and
+	// reset the screen buffer for the new connection
+	actor := m.actor(session.ServerName)
+	actor.Reset()
 
 	// Read data from SOL and distribute
-	readCh := solSession.Read()
-	errCh := solSession.Err()
+	readCh := con.Read()
+	errCh := con.Err()
 
 	for {
 		select {
 		case <-ctx.Done():
-			solSession.Close()
+			con.Close()
 			session.Connected = false
-			go clearBMCSessions(session.IP, session.Username, session.Password)
+			m.publishState(session.ServerName, StateDisconnected, "", "")
+			go clearBMCSessions(proxy, session.IP, session.Username, session.Password)
 			return ctx.Err()
 
 		case err := <-errCh:
-			solSession.Close()
+			con.Close()
 			session.Connected = false
-			go clearBMCSessions(session.IP, session.Username, session.Password)
-			return fmt.Errorf("SOL error: %w", err)
+			err = fmt.Errorf("SOL error: %w", err)
+			m.publishState(session.ServerName, StateDisconnected, err.Error(), "")
+			go clearBMCSessions(proxy, session.IP, session.Username, session.Password)
+			return err
 
 		case data, ok := <-readCh:
 			if !ok {
 				session.Connected = false
-				return fmt.Errorf("SOL session closed")
+				err := fmt.Errorf("SOL session closed")
+				m.publishState(session.ServerName, StateDisconnected, err.Error(), "")
+				return err
 			}
 
 			session.LastActivity = time.Now()
 
-			// Broadcast raw data to SSE subscribers
-			m.broadcast(session.ServerName, data)
-
-			// Write to screen buffer for catchup on server switch
-			sb.Write(data)
-
-			// Write to log file (cleaned)
-			if m.logWriter != nil {
-				m.logWriter.Write(session.ServerName, data)
-			}
-
-			// Process for analytics
-			if m.analytics != nil {
-				m.analytics.ProcessText(session.ServerName, string(data))
-			}
+			// Hand off to the server's actor: broadcast, screen buffer,
+			// log and analytics happen in order off the read path
+			actor.Submit(data)
 		}
 	}
 }
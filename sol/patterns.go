@@ -0,0 +1,284 @@
+package sol
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Console analytics runs dozens of case-insensitive regexes over every chunk
+// of SOL output, and almost none of them match. Each pattern therefore
+// carries the literal substrings its parsed form requires (at least one must
+// occur for the regex to match), and the regex only runs when a lowercase
+// substring check passes. Patterns with no extractable literal always run.
+
+// factor is a required literal, interned so per-chunk results can be kept
+// in a slice instead of a map.
+type factor struct {
+	id  int
+	lit string
+}
+
+var factorTable = struct {
+	sync.Mutex
+	ids   map[string]int
+	count atomic.Int32
+}{ids: make(map[string]int)}
+
+func internFactor(lit string) factor {
+	factorTable.Lock()
+	defer factorTable.Unlock()
+	id, ok := factorTable.ids[lit]
+	if !ok {
+		id = len(factorTable.ids)
+		factorTable.ids[lit] = id
+		factorTable.count.Store(int32(id + 1))
+	}
+	return factor{id: id, lit: lit}
+}
+
+// Per-chunk factor results
+const (
+	factorUnknown uint8 = iota
+	factorPresent
+	factorAbsent
+)
+
+// chunkText is one chunk of console text prepared for matching. Literal
+// checks are cached so detectors that share a literal only scan once.
+type chunkText struct {
+	text    string
+	lower   string
+	factors []uint8 // indexed by factor id
+}
+
+func newChunkText(text string) *chunkText {
+	return &chunkText{
+		text:    text,
+		lower:   strings.ToLower(text),
+		factors: make([]uint8, factorTable.count.Load()),
+	}
+}
+
+// contains reports whether the lowercased chunk contains s.
+func (ct *chunkText) contains(s string) bool {
+	return strings.Contains(ct.lower, s)
+}
+
+// has reports whether the chunk contains f, caching the result.
+func (ct *chunkText) has(f factor) bool {
+	if f.id >= len(ct.factors) {
+		// Interned after this chunk was created (patterns reloaded)
+		return ct.contains(f.lit)
+	}
+	switch ct.factors[f.id] {
+	case factorPresent:
+		return true
+	case factorAbsent:
+		return false
+	}
+	found := ct.contains(f.lit)
+	if found {
+		ct.factors[f.id] = factorPresent
+	} else {
+		ct.factors[f.id] = factorAbsent
+	}
+	return found
+}
+
+// mayMatch reports whether any of the factors occur; nil factors always pass.
+func (ct *chunkText) mayMatch(factors []factor) bool {
+	if factors == nil {
+		return true
+	}
+	for _, f := range factors {
+		if ct.has(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// matcher is a compiled regex with its substring pre-check.
+type matcher struct {
+	re      *regexp.Regexp
+	factors []factor
+}
+
+func newMatcher(expr string) (*matcher, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &matcher{re: re, factors: internFactors(literalFactorsOf(expr))}, nil
+}
+
+func mustMatcher(expr string) *matcher {
+	m, err := newMatcher(expr)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m *matcher) match(ct *chunkText) bool {
+	return ct.mayMatch(m.factors) && m.re.MatchString(ct.text)
+}
+
+func (m *matcher) findSubmatch(ct *chunkText) []string {
+	if !ct.mayMatch(m.factors) {
+		return nil
+	}
+	return m.re.FindStringSubmatch(ct.text)
+}
+
+func (m *matcher) findAllSubmatch(ct *chunkText) [][]string {
+	if !ct.mayMatch(m.factors) {
+		return nil
+	}
+	return m.re.FindAllStringSubmatch(ct.text, -1)
+}
+
+// patternSet answers "does any of these patterns match" with a single
+// alternation, skipped entirely when none of the members' literals occur.
+type patternSet struct {
+	any     *regexp.Regexp
+	factors []factor // union of member factors; nil if any member has none
+}
+
+// newPatternSet compiles patterns (each prefixed with flags, e.g. "(?i)")
+// into one set. Patterns that fail to compile are returned as errors and
+// left out.
+func newPatternSet(flags string, patterns []string) (*patternSet, []error) {
+	var (
+		alts    []string
+		errs    []error
+		factors []string
+		always  bool
+	)
+	for _, p := range patterns {
+		expr := flags + "(?:" + p + ")"
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		alts = append(alts, expr)
+		f := literalFactorsOf(expr)
+		if f == nil {
+			always = true
+		}
+		factors = append(factors, f...)
+	}
+	ps := &patternSet{}
+	if len(alts) == 0 {
+		return ps, errs
+	}
+	ps.any = regexp.MustCompile(strings.Join(alts, "|"))
+	if !always {
+		ps.factors = internFactors(dedupe(factors))
+	}
+	return ps, errs
+}
+
+func (ps *patternSet) match(ct *chunkText) bool {
+	return ps.any != nil && ct.mayMatch(ps.factors) && ps.any.MatchString(ct.text)
+}
+
+// find returns the text matched by the set, for debug logging.
+func (ps *patternSet) find(ct *chunkText) string {
+	if ps.any == nil || !ct.mayMatch(ps.factors) {
+		return ""
+	}
+	return ps.any.FindString(ct.text)
+}
+
+func internFactors(lits []string) []factor {
+	if lits == nil {
+		return nil
+	}
+	out := make([]factor, len(lits))
+	for i, lit := range lits {
+		out[i] = internFactor(lit)
+	}
+	return out
+}
+
+// literalFactorsOf returns lowercase substrings of which at least one must
+// appear in any text expr matches, or nil if none can be derived.
+func literalFactorsOf(expr string) []string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return dedupe(literalFactors(re.Simplify()))
+}
+
+func literalFactors(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{strings.ToLower(string(re.Rune))}
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalFactors(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return literalFactors(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Any child's requirement is the whole concatenation's requirement;
+		// pick the most selective one
+		var best []string
+		for _, sub := range re.Sub {
+			if f := literalFactors(sub); f != nil && factorScore(f) > factorScore(best) {
+				best = f
+			}
+		}
+		return best
+	case syntax.OpAlternate:
+		// Every branch must contribute, or the set proves nothing
+		var all []string
+		for _, sub := range re.Sub {
+			f := literalFactors(sub)
+			if f == nil {
+				return nil
+			}
+			all = append(all, f...)
+		}
+		return all
+	}
+	return nil
+}
+
+// factorScore rates a factor set by its shortest literal; longer literals
+// reject more text.
+func factorScore(factors []string) int {
+	if factors == nil {
+		return 0
+	}
+	shortest := -1
+	for _, f := range factors {
+		if shortest < 0 || len(f) < shortest {
+			shortest = len(f)
+		}
+	}
+	return shortest
+}
+
+func dedupe(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	seen := make(map[string]bool, len(ss))
+	out := ss[:0:0]
+	for _, s := range ss {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
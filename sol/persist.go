@@ -0,0 +1,175 @@
+package sol
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Analytics are kept per server in <dataPath>/<server>/analytics.json, next
+// to that server's logs and sel.json. Console processing only marks a
+// server dirty; a background flush writes the changed servers, so no JSON
+// encoding or disk I/O happens on the SOL read path.
+
+// defaultBootHistory is the number of past boots kept per server.
+const defaultBootHistory = 10
+
+// legacyAnalyticsFile is the single-file store used before per-server files.
+const legacyAnalyticsFile = "analytics.json"
+
+func (a *Analytics) serverFilePath(dataPath, serverName string) string {
+	return filepath.Join(dataPath, serverName, "analytics.json")
+}
+
+// SetBootHistory changes how many past boots are kept per server. Longer
+// histories are trimmed at each server's next boot.
+func (a *Analytics) SetBootHistory(n int) {
+	if n <= 0 {
+		n = defaultBootHistory
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.bootHistory = n
+}
+
+// load reads the per-server files, then migrates servers only found in the
+// legacy single file.
+func (a *Analytics) load() {
+	if a.dataPath == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(a.dataPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to read analytics: %v", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(a.serverFilePath(a.dataPath, e.Name()))
+		if err != nil {
+			continue
+		}
+		server := &ServerAnalytics{}
+		if err := json.Unmarshal(data, server); err != nil {
+			log.Errorf("Failed to unmarshal analytics for %s: %v", e.Name(), err)
+			continue
+		}
+		a.servers[e.Name()] = server
+	}
+
+	legacy := filepath.Join(a.dataPath, legacyAnalyticsFile)
+	if data, err := os.ReadFile(legacy); err == nil {
+		var old struct {
+			Servers map[string]*ServerAnalytics `json:"servers"`
+		}
+		if err := json.Unmarshal(data, &old); err != nil {
+			log.Errorf("Failed to unmarshal %s: %v", legacy, err)
+		} else {
+			for name, server := range old.Servers {
+				if _, exists := a.servers[name]; !exists {
+					a.servers[name] = server
+					a.dirty[name] = true
+				}
+			}
+			if a.flush() {
+				os.Remove(legacy)
+				log.Infof("Migrated %s to per-server files", legacy)
+			}
+		}
+	}
+
+	if len(a.servers) > 0 {
+		log.Infof("Loaded analytics for %d servers", len(a.servers))
+	}
+}
+
+// flush writes every dirty server's file, reporting whether all succeeded.
+// Servers whose write failed stay dirty for the next flush.
+func (a *Analytics) flush() bool {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	a.mu.Lock()
+	dataPath := a.dataPath
+	if dataPath == "" || len(a.dirty) == 0 {
+		a.mu.Unlock()
+		return true
+	}
+	pending := make(map[string][]byte, len(a.dirty))
+	for name := range a.dirty {
+		server, exists := a.servers[name]
+		if !exists {
+			continue
+		}
+		data, err := json.MarshalIndent(server, "", "  ")
+		if err != nil {
+			log.Errorf("Failed to marshal analytics for %s: %v", name, err)
+			continue
+		}
+		pending[name] = data
+	}
+	a.dirty = make(map[string]bool)
+	a.mu.Unlock()
+
+	ok := true
+	for name, data := range pending {
+		if err := writeFileAtomic(a.serverFilePath(dataPath, name), data); err != nil {
+			log.Errorf("Failed to save analytics for %s: %v", name, err)
+			a.mu.Lock()
+			a.dirty[name] = true
+			a.mu.Unlock()
+			ok = false
+		}
+	}
+	return ok
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// RunAnalyticsFlush writes changed analytics at the given interval until
+// ctx is cancelled, then once more.
+func (m *Manager) RunAnalyticsFlush(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.analytics.flush()
+			return
+		case <-ticker.C:
+			m.analytics.flush()
+		}
+	}
+}
+
+// FlushAnalytics writes changed analytics now, e.g. before exiting.
+func (m *Manager) FlushAnalytics() {
+	m.analytics.flush()
+}
+
+// SetBootHistory changes how many past boots analytics keeps per server.
+func (m *Manager) SetBootHistory(n int) {
+	m.analytics.SetBootHistory(n)
+}
@@ -0,0 +1,106 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gwest/go-sol"
+	log "github.com/sirupsen/logrus"
+)
+
+// powerActions maps API power action names to Chassis Control values.
+var powerActions = map[string]sol.ChassisAction{
+	"on":    sol.ChassisPowerOn,
+	"off":   sol.ChassisPowerOff,
+	"cycle": sol.ChassisPowerCycle,
+	"reset": sol.ChassisHardReset,
+	"soft":  sol.ChassisSoftOff,
+}
+
+// bootDevices maps API boot device names to boot flag selectors.
+var bootDevices = map[string]sol.BootDevice{
+	"none":  sol.BootNone,
+	"pxe":   sol.BootPXE,
+	"disk":  sol.BootDisk,
+	"cdrom": sol.BootCDROM,
+	"bios":  sol.BootBIOS,
+}
+
+// ValidPowerAction reports whether action is a supported power action.
+func ValidPowerAction(action string) bool {
+	_, ok := powerActions[action]
+	return ok
+}
+
+// ValidBootDevice reports whether dev is a supported boot device name.
+func ValidBootDevice(dev string) bool {
+	_, ok := bootDevices[dev]
+	return ok
+}
+
+// PowerControl sends a chassis power action (on, off, cycle, reset, soft)
+// over the live session, or a command-only session while SOL is down.
+// Actions that start a boot are recorded for power-on delay analytics and
+// end standby.
+func (m *Manager) PowerControl(serverName, action string) error {
+	ca, ok := powerActions[action]
+	if !ok {
+		return fmt.Errorf("invalid power action: %s", action)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, release, err := m.commandSession(ctx, serverName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := s.ChassisControl(ctx, ca); err != nil {
+		return fmt.Errorf("chassis control %s: %w", action, err)
+	}
+
+	log.Infof("Power %s sent to %s", action, serverName)
+	if action == "on" || action == "cycle" || action == "reset" {
+		m.RecordPowerCommand(serverName)
+		m.wake(serverName)
+	}
+	m.announce(serverName, "power "+action+" requested")
+	return nil
+}
+
+// SetBootDevice sets a one-time (or persistent) boot device override.
+func (m *Manager) SetBootDevice(serverName, dev string, persistent bool) error {
+	bd, ok := bootDevices[dev]
+	if !ok {
+		return fmt.Errorf("invalid boot device: %s", dev)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, release, err := m.commandSession(ctx, serverName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := s.SetBootDevice(ctx, bd, persistent); err != nil {
+		return fmt.Errorf("set boot device %s: %w", dev, err)
+	}
+	log.Infof("Boot device for %s set to %s (persistent=%v)", serverName, dev, persistent)
+	return nil
+}
+
+// GetPowerState returns true if the chassis reports power on.
+func (m *Manager) GetPowerState(serverName string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, release, err := m.commandSession(ctx, serverName)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	status, err := s.GetChassisStatus(ctx)
+	if err != nil {
+		return false, err
+	}
+	return status.PowerOn, nil
+}
@@ -0,0 +1,171 @@
+package sol
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Power-on degradation thresholds: a boot is flagged when its delay exceeds
+// degradeFactor × the server's historical median by at least degradeMinSecs,
+// once degradeMinSamples earlier boots are available.
+const (
+	degradeFactor     = 2.0
+	degradeMinSecs    = 10.0
+	degradeMinSamples = 5
+)
+
+// DelayStats summarizes a set of delay samples in seconds.
+type DelayStats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min,omitempty"`
+	Max   float64 `json:"max,omitempty"`
+	P50   float64 `json:"p50,omitempty"`
+	P90   float64 `json:"p90,omitempty"`
+	P95   float64 `json:"p95,omitempty"`
+	P99   float64 `json:"p99,omitempty"`
+	Last  float64 `json:"last,omitempty"`
+}
+
+// PowerOnStats is the power-on latency report for one server or the fleet.
+type PowerOnStats struct {
+	Rotation     DelayStats `json:"rotationToOutput"`     // log rotation → first console output
+	PowerCommand DelayStats `json:"powerCommandToOutput"` // power command → first console output
+	Degraded     bool       `json:"degraded,omitempty"`
+}
+
+// PowerOnReport holds per-server and fleet-wide power-on statistics.
+type PowerOnReport struct {
+	Servers map[string]PowerOnStats `json:"servers"`
+	Fleet   PowerOnStats            `json:"fleet"`
+}
+
+func (m *Manager) GetPowerOnReport() *PowerOnReport {
+	return m.analytics.GetPowerOnReport()
+}
+
+// GetPowerOnReport computes power-on delay percentiles from boot history.
+func (a *Analytics) GetPowerOnReport() *PowerOnReport {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	report := &PowerOnReport{Servers: make(map[string]PowerOnStats)}
+	var fleetRot, fleetCmd []float64
+	for name, server := range a.servers {
+		rot, cmd := powerOnSamples(server)
+		if len(rot) == 0 && len(cmd) == 0 {
+			continue
+		}
+		report.Servers[name] = PowerOnStats{
+			Rotation:     computeDelayStats(rot),
+			PowerCommand: computeDelayStats(cmd),
+			Degraded:     server.PowerOnDegraded,
+		}
+		fleetRot = append(fleetRot, rot...)
+		fleetCmd = append(fleetCmd, cmd...)
+		if server.PowerOnDegraded {
+			report.Fleet.Degraded = true
+		}
+	}
+	report.Fleet.Rotation = computeDelayStats(fleetRot)
+	report.Fleet.PowerCommand = computeDelayStats(fleetCmd)
+	return report
+}
+
+// powerOnSamples returns rotation and power-command delays in boot order.
+func powerOnSamples(server *ServerAnalytics) (rot, cmd []float64) {
+	boots := server.BootHistory
+	if server.CurrentBoot != nil {
+		boots = append(boots[:len(boots):len(boots)], *server.CurrentBoot)
+	}
+	for _, b := range boots {
+		if b.PowerOnDelay > 0 {
+			rot = append(rot, b.PowerOnDelay)
+		}
+		if b.PowerCmdDelay > 0 {
+			cmd = append(cmd, b.PowerCmdDelay)
+		}
+	}
+	return rot, cmd
+}
+
+// checkPowerOnDegradation compares the current boot's power-on delay with the
+// server's history and flags the server when latency has degraded. It
+// returns the delay against the median when the server has just become
+// degraded, and "" otherwise. Must be called with a.mu held.
+func (a *Analytics) checkPowerOnDegradation(server *ServerAnalytics) string {
+	if server.CurrentBoot == nil {
+		return ""
+	}
+	latest := server.CurrentBoot.PowerCmdDelay
+	if latest == 0 {
+		latest = server.CurrentBoot.PowerOnDelay
+	}
+	if latest == 0 {
+		return ""
+	}
+
+	var history []float64
+	for _, b := range server.BootHistory {
+		d := b.PowerCmdDelay
+		if d == 0 {
+			d = b.PowerOnDelay
+		}
+		if d > 0 {
+			history = append(history, d)
+		}
+	}
+	if len(history) < degradeMinSamples {
+		server.PowerOnDegraded = false
+		return ""
+	}
+
+	median := percentile(sortedCopy(history), 50)
+	degraded := latest > median*degradeFactor && latest-median >= degradeMinSecs
+	var detail string
+	if degraded && !server.PowerOnDegraded {
+		detail = fmt.Sprintf("%.1fs vs median %.1fs", latest, median)
+		log.Warnf("Power-on latency degraded for %s: %s", server.ServerName, detail)
+	}
+	server.PowerOnDegraded = degraded
+	return detail
+}
+
+func computeDelayStats(samples []float64) DelayStats {
+	if len(samples) == 0 {
+		return DelayStats{}
+	}
+	sorted := sortedCopy(samples)
+	return DelayStats{
+		Count: len(samples),
+		Min:   sorted[0],
+		Max:   sorted[len(sorted)-1],
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Last:  samples[len(samples)-1],
+	}
+}
+
+func sortedCopy(samples []float64) []float64 {
+	out := append([]float64{}, samples...)
+	sort.Float64s(out)
+	return out
+}
+
+// percentile returns the nearest-rank percentile of an already sorted slice.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
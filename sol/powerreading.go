@@ -0,0 +1,173 @@
+package sol
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gwest/go-sol"
+	log "github.com/sirupsen/logrus"
+)
+
+// PowerSample is one power reading, tagged with the boot stage the console
+// was in so draw can be lined up with the boot.
+type PowerSample struct {
+	Time    time.Time `json:"time"`
+	Watts   int       `json:"watts"`
+	Average int       `json:"average"`
+	Stage   string    `json:"stage,omitempty"` // boot stage of an incomplete boot
+}
+
+// PowerReading is a server's latest DCMI power reading and recent samples.
+type PowerReading struct {
+	Updated     time.Time     `json:"updated"`
+	Unsupported bool          `json:"unsupported,omitempty"` // the BMC has no DCMI power management
+	Watts       int           `json:"watts"`                 // instantaneous
+	Min         int           `json:"min"`                   // over the BMC's statistics period
+	Max         int           `json:"max"`
+	Average     int           `json:"average"`
+	Period      float64       `json:"period"` // statistics period, seconds
+	History     []PowerSample `json:"history"`
+}
+
+// powerState is a server's power readings and whether its BMC answered
+// Get Power Reading on the current SOL session.
+type powerState struct {
+	reading     PowerReading
+	unsupported *sol.Session // session whose BMC rejected Get Power Reading
+}
+
+// PowerCollector polls DCMI power readings over each live SOL session and
+// keeps the latest reading and a bounded history per server in memory.
+type PowerCollector struct {
+	history int
+	servers map[string]*powerState
+	mu      sync.RWMutex
+}
+
+func NewPowerCollector() *PowerCollector {
+	return &PowerCollector{servers: make(map[string]*powerState)}
+}
+
+// RunPowerCollector polls the power reading of every connected session at
+// the given interval until ctx is cancelled, keeping history samples per
+// server. A zero interval disables polling.
+func (m *Manager) RunPowerCollector(ctx context.Context, interval time.Duration, history int) {
+	if interval <= 0 {
+		return
+	}
+	m.power.mu.Lock()
+	m.power.history = history
+	m.power.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for name, session := range m.GetSessions() {
+			if !session.Connected || session.solSession == nil {
+				continue
+			}
+			if err := m.power.collect(ctx, name, session.solSession, m.bootStage(name)); err != nil {
+				log.Debugf("Power reading for %s failed: %v", name, err)
+			}
+		}
+	}
+}
+
+// bootStage returns the stage of a server's boot in progress, if any.
+func (m *Manager) bootStage(serverName string) string {
+	b := m.GetAnalytics(serverName).CurrentBoot
+	if b == nil || b.Complete || len(b.Stages) == 0 {
+		return ""
+	}
+	return b.Stages[len(b.Stages)-1].Name
+}
+
+// GetPowerReading returns a server's latest power reading.
+func (m *Manager) GetPowerReading(serverName string) PowerReading {
+	m.power.mu.RLock()
+	defer m.power.mu.RUnlock()
+	if st, ok := m.power.servers[serverName]; ok {
+		return copyPowerReading(st.reading)
+	}
+	return PowerReading{History: []PowerSample{}}
+}
+
+// GetAllPowerReadings returns the latest power reading of every server
+// whose BMC has answered.
+func (m *Manager) GetAllPowerReadings() map[string]PowerReading {
+	m.power.mu.RLock()
+	defer m.power.mu.RUnlock()
+	out := make(map[string]PowerReading, len(m.power.servers))
+	for name, st := range m.power.servers {
+		if !st.reading.Updated.IsZero() && !st.reading.Unsupported {
+			out[name] = copyPowerReading(st.reading)
+		}
+	}
+	return out
+}
+
+func copyPowerReading(r PowerReading) PowerReading {
+	r.History = append([]PowerSample{}, r.History...)
+	return r
+}
+
+func (c *PowerCollector) collect(ctx context.Context, serverName string, s *sol.Session, stage string) error {
+	c.mu.Lock()
+	st, ok := c.servers[serverName]
+	if !ok {
+		st = &powerState{reading: PowerReading{History: []PowerSample{}}}
+		c.servers[serverName] = st
+	}
+	skip := st.unsupported == s
+	c.mu.Unlock()
+	if skip {
+		return nil // asked once per session
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	r, err := s.GetPowerReading(ctx)
+	var ce *sol.CompletionError
+	if errors.As(err, &ce) {
+		c.mu.Lock()
+		st.unsupported = s
+		st.reading.Unsupported = true
+		st.reading.Updated = time.Now()
+		c.mu.Unlock()
+		log.Infof("BMC of %s does not support DCMI power readings (%v)", serverName, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !r.Active {
+		return nil // power measurement is off; the values mean nothing
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st.unsupported = nil
+	history := append(st.reading.History, PowerSample{Time: now, Watts: r.Current, Average: r.Average, Stage: stage})
+	if c.history > 0 && len(history) > c.history {
+		history = history[len(history)-c.history:]
+	}
+	st.reading = PowerReading{
+		Updated: now,
+		Watts:   r.Current,
+		Min:     r.Min,
+		Max:     r.Max,
+		Average: r.Average,
+		Period:  r.Period.Seconds(),
+		History: history,
+	}
+	return nil
+}
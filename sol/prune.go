@@ -0,0 +1,50 @@
+package sol
+
+// ForgetServer stops a removed server's session and drops everything held
+// in memory for it: the actor and screen buffer, analytics, SEL, sensor
+// state and connect history, boot detection, the input controller and viewers. It returns the
+// analytics and screen buffer as they were, so the caller can archive them.
+func (m *Manager) ForgetServer(serverName string) (*ServerAnalytics, []byte) {
+	screen := m.GetScreenBuffer(serverName)
+	m.RemoveSession(serverName)
+
+	analytics := m.analytics.forget(serverName)
+
+	m.sel.mu.Lock()
+	delete(m.sel.servers, serverName)
+	m.sel.mu.Unlock()
+
+	m.sensors.mu.Lock()
+	delete(m.sensors.servers, serverName)
+	m.sensors.mu.Unlock()
+
+	m.history.mu.Lock()
+	delete(m.history.servers, serverName)
+	m.history.mu.Unlock()
+
+	m.rebootDetector.Forget(serverName)
+
+	m.ctrlMu.Lock()
+	delete(m.controllers, serverName)
+	delete(m.viewers, serverName)
+	m.ctrlMu.Unlock()
+
+	return analytics, screen
+}
+
+// forget removes a server from analytics and returns the removed entry (nil
+// if there was none). Its file stays with its logs; holding flushMu ensures
+// no flush is still writing it afterwards.
+func (a *Analytics) forget(serverName string) *ServerAnalytics {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	server, ok := a.servers[serverName]
+	if !ok {
+		return nil
+	}
+	delete(a.servers, serverName)
+	delete(a.dirty, serverName)
+	return server
+}
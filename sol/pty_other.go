@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sol
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// startPTY is not supported here, so neither is the ipmitool transport.
+func startPTY(cmd *exec.Cmd) (*os.File, error) {
+	return nil, errors.New("the ipmitool transport needs a PTY, which is only supported on Linux")
+}
@@ -0,0 +1,115 @@
+package sol
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gwest/go-sol"
+)
+
+// QuirksSetting picks a BMC's handshake quirks: a go-sol profile, by
+// default the one its Get Device ID matches, with fields overriding it.
+type QuirksSetting struct {
+	Profile            string        // auto (or empty), generic, dell, supermicro or hpe
+	Privilege          string        // first, before_activate or skip; empty = the profile's
+	ActivateRetries    int           // 0 = the profile's, negative = none
+	ActivateRetryDelay time.Duration // 0 = the profile's
+	SOLInstance        int           // 1-15; 0 = the profile's
+	DeactivateActive   *bool         // nil = the profile's
+}
+
+// quirkPrivileges maps configured privilege orders to go-sol's.
+var quirkPrivileges = map[string]sol.PrivilegeOrder{
+	"first":           sol.PrivilegeFirst,
+	"before_activate": sol.PrivilegeBeforeActivate,
+	"skip":            sol.PrivilegeSkip,
+}
+
+// Validate checks the profile name and overrides.
+func (q QuirksSetting) Validate() error {
+	if q.Profile != "" && q.Profile != "auto" {
+		if _, ok := sol.QuirksProfile(q.Profile); !ok {
+			return fmt.Errorf("quirks profile %q: want auto, %s", q.Profile, strings.Join(sol.QuirksProfiles(), ", "))
+		}
+	}
+	if _, ok := quirkPrivileges[q.Privilege]; q.Privilege != "" && !ok {
+		return fmt.Errorf("quirks privilege %q: want first, before_activate or skip", q.Privilege)
+	}
+	if q.SOLInstance < 0 || q.SOLInstance > 15 {
+		return fmt.Errorf("quirks sol_instance %d: want 1-15", q.SOLInstance)
+	}
+	if q.ActivateRetryDelay < 0 {
+		return fmt.Errorf("quirks activate_retry_delay %v is negative", q.ActivateRetryDelay)
+	}
+	return nil
+}
+
+// Merge returns q with o's set fields taking precedence.
+func (q QuirksSetting) Merge(o QuirksSetting) QuirksSetting {
+	if o.Profile != "" {
+		q.Profile = o.Profile
+	}
+	if o.Privilege != "" {
+		q.Privilege = o.Privilege
+	}
+	if o.ActivateRetries != 0 {
+		q.ActivateRetries = o.ActivateRetries
+	}
+	if o.ActivateRetryDelay != 0 {
+		q.ActivateRetryDelay = o.ActivateRetryDelay
+	}
+	if o.SOLInstance != 0 {
+		q.SOLInstance = o.SOLInstance
+	}
+	if o.DeactivateActive != nil {
+		q.DeactivateActive = o.DeactivateActive
+	}
+	return q
+}
+
+// resolve returns the quirks for a BMC with Get Device ID id (nil if it
+// didn't answer): the profile, detected or named, with the overrides.
+func (q QuirksSetting) resolve(id *sol.DeviceID) sol.Quirks {
+	quirks, ok := sol.QuirksProfile(q.Profile)
+	if !ok {
+		quirks = sol.QuirksFor(id)
+	}
+	if p, ok := quirkPrivileges[q.Privilege]; ok {
+		quirks.Privilege = p
+	}
+	switch {
+	case q.ActivateRetries < 0:
+		quirks.ActivateRetries = 0
+	case q.ActivateRetries > 0:
+		quirks.ActivateRetries = q.ActivateRetries
+	}
+	if q.ActivateRetryDelay > 0 {
+		quirks.ActivateRetryDelay = q.ActivateRetryDelay
+	}
+	if q.SOLInstance > 0 {
+		quirks.SOLInstance = uint8(q.SOLInstance)
+	}
+	if q.DeactivateActive != nil {
+		quirks.DeactivateActive = *q.DeactivateActive
+	}
+	return quirks
+}
+
+// SetQuirks sets how BMC handshake quirks are chosen, by default and per
+// server, for sessions connected from now on.
+func (m *Manager) SetQuirks(def QuirksSetting, servers map[string]QuirksSetting) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quirksDefault, m.quirksServers = def, servers
+}
+
+// quirksFor returns the go-sol Config.Quirks for a server. The caller holds
+// mu.
+func (m *Manager) quirksFor(serverName string) func(id *sol.DeviceID) sol.Quirks {
+	q, ok := m.quirksServers[serverName]
+	if !ok {
+		q = m.quirksDefault
+	}
+	return q.resolve
+}
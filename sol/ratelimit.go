@@ -0,0 +1,90 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rateWindow is the span session attempts are counted over.
+const rateWindow = time.Minute
+
+// bmcLimiter caps the session attempts made against each BMC in any
+// minute, so reconnects, power commands and standby probes together can't
+// trip a BMC's login lockout or IP blocking. Attempts are counted per BMC
+// address, whichever server or path makes them.
+type bmcLimiter struct {
+	perMinute int                    // 0 = unlimited
+	attempts  map[string][]time.Time // BMC address -> attempts within rateWindow, oldest first
+	mu        sync.Mutex
+}
+
+func newBMCLimiter() *bmcLimiter {
+	return &bmcLimiter{attempts: make(map[string][]time.Time)}
+}
+
+// SetBMCRateLimit caps session attempts per BMC per minute, across SOL
+// connects, command-only sessions (power, diagnostics, standby probes) and
+// restarts. Zero removes the cap.
+func (m *Manager) SetBMCRateLimit(perMinute int) {
+	m.limiter.mu.Lock()
+	defer m.limiter.mu.Unlock()
+	m.limiter.perMinute = max(perMinute, 0)
+}
+
+// wait blocks until an attempt against bmc is allowed and records it. It
+// fails if ctx ends first.
+func (l *bmcLimiter) wait(ctx context.Context, bmc string) error {
+	logged := false
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		recent := l.attempts[bmc]
+		for len(recent) > 0 && now.Sub(recent[0]) >= rateWindow {
+			recent = recent[1:]
+		}
+		if l.perMinute == 0 || len(recent) < l.perMinute {
+			l.attempts[bmc] = append(recent, now)
+			l.mu.Unlock()
+			return nil
+		}
+		l.attempts[bmc] = recent
+		delay := recent[0].Add(rateWindow).Sub(now)
+		l.mu.Unlock()
+
+		if !logged {
+			log.Infof("BMC %s: %d session attempts in the last minute, waiting %v", bmc, len(recent), delay.Round(time.Second))
+			logged = true
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("BMC %s rate limited: %w", bmc, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// prune forgets BMCs without recent attempts.
+func (l *bmcLimiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for bmc, recent := range l.attempts {
+		if len(recent) == 0 || now.Sub(recent[len(recent)-1]) >= rateWindow {
+			delete(l.attempts, bmc)
+		}
+	}
+}
+
+// bmcAddress keys a session's BMC for rate limiting.
+func bmcAddress(session *Session) string {
+	if session.Port != 0 && session.Port != 623 {
+		return fmt.Sprintf("%s:%d", session.IP, session.Port)
+	}
+	return session.IP
+}
@@ -1,8 +1,6 @@
 package sol
 
 import (
-	"regexp"
-	"strings"
 	"sync"
 	"time"
 )
@@ -12,9 +10,30 @@ type serverState struct {
 	lastReboot time.Time // last time we detected a reboot
 }
 
+// builtinBIOSPatterns indicate we're in the boot process. User-configured
+// patterns are added to these.
+var builtinBIOSPatterns = []string{
+	`American Megatrends`,
+	`Press <DEL> to run Setup`,
+	`Press DEL to run Setup`,
+	`BIOS Date:`,
+	`Supermicro`,
+	`Intel\(R\) Boot Agent`,
+	`PXE-`,
+	`PXE->`,
+	`PXELINUX`,
+	`iPXE initialising`,
+	`iPXE \d+\.\d+`,
+	`Open Source Network Boot Firmware`,
+	`Booting baremetalservices`,
+	`UNDI code segment`,
+	`CLIENT MAC ADDR:`,
+	`free base memory after PXE`,
+}
+
 type RebootDetector struct {
-	biosPatterns []*regexp.Regexp
-	osPatterns   []*regexp.Regexp
+	biosPatterns *patternSet
+	osPatterns   *patternSet
 	states       map[string]*serverState
 	cooldown     time.Duration
 	mu           sync.Mutex
@@ -22,32 +41,10 @@ type RebootDetector struct {
 
 func NewRebootDetector(patterns []string) *RebootDetector {
 	rd := &RebootDetector{
-		biosPatterns: make([]*regexp.Regexp, 0),
-		osPatterns:   make([]*regexp.Regexp, 0),
 		states:       make(map[string]*serverState),
 		cooldown:     2 * time.Minute,
 	}
 
-	// BIOS/POST patterns - indicate we're in boot process
-	biosPatterns := []string{
-		`American Megatrends`,
-		`Press <DEL> to run Setup`,
-		`Press DEL to run Setup`,
-		`BIOS Date:`,
-		`Supermicro`,
-		`Intel\(R\) Boot Agent`,
-		`PXE-`,
-		`PXE->`,
-		`PXELINUX`,
-		`iPXE initialising`,
-		`iPXE \d+\.\d+`,
-		`Open Source Network Boot Firmware`,
-		`Booting baremetalservices`,
-		`UNDI code segment`,
-		`CLIENT MAC ADDR:`,
-		`free base memory after PXE`,
-	}
-
 	// OS patterns - indicate the OS is running
 	osPatterns := []string{
 		`\[\s*\d+\.\d+\]`,           // Linux dmesg timestamps like [    0.000000]
@@ -62,27 +59,25 @@ func NewRebootDetector(patterns []string) *RebootDetector {
 		`NTP sync`,                  // NTP messages
 	}
 
-	// Add user-configured patterns to BIOS patterns
-	allBiosPatterns := append(biosPatterns, patterns...)
+	rd.osPatterns, _ = newPatternSet("(?i)", osPatterns)
 
-	for _, p := range allBiosPatterns {
-		re, err := regexp.Compile("(?i)" + p)
-		if err == nil {
-			rd.biosPatterns = append(rd.biosPatterns, re)
-		}
-	}
+	rd.SetPatterns(patterns)
+	return rd
+}
 
-	for _, p := range osPatterns {
-		re, err := regexp.Compile("(?i)" + p)
-		if err == nil {
-			rd.osPatterns = append(rd.osPatterns, re)
-		}
-	}
+// SetPatterns replaces the user-configured BIOS patterns without resetting
+// per-server boot state.
+func (rd *RebootDetector) SetPatterns(patterns []string) {
+	compiled, _ := newPatternSet("(?i)", append(append([]string{}, builtinBIOSPatterns...), patterns...))
 
-	return rd
+	rd.mu.Lock()
+	rd.biosPatterns = compiled
+	rd.mu.Unlock()
 }
 
 func (rd *RebootDetector) Check(serverName, text string) bool {
+	ct := newChunkText(text)
+
 	rd.mu.Lock()
 	defer rd.mu.Unlock()
 
@@ -95,20 +90,20 @@ func (rd *RebootDetector) Check(serverName, text string) bool {
 	// Check cooldown
 	if time.Since(state.lastReboot) < rd.cooldown {
 		// Still in cooldown, but update OS state if we see OS patterns
-		if rd.matchesOS(text) {
+		if rd.matchesOS(ct) {
 			state.inOS = true
 		}
 		return false
 	}
 
 	// Check if we see OS patterns - mark that OS is running
-	if rd.matchesOS(text) {
+	if rd.matchesOS(ct) {
 		state.inOS = true
 		return false
 	}
 
 	// Check if we see BIOS patterns
-	if rd.matchesBIOS(text) {
+	if rd.matchesBIOS(ct) {
 		// Only trigger if we were previously in OS state
 		// This means we transitioned from OS -> BIOS = reboot
 		if state.inOS {
@@ -124,18 +119,12 @@ func (rd *RebootDetector) Check(serverName, text string) bool {
 	return false
 }
 
-func (rd *RebootDetector) matchesBIOS(text string) bool {
-	for _, p := range rd.biosPatterns {
-		if p.MatchString(text) {
-			return true
-		}
-	}
-	return false
+func (rd *RebootDetector) matchesBIOS(ct *chunkText) bool {
+	return rd.biosPatterns.match(ct)
 }
 
-func (rd *RebootDetector) matchesOS(text string) bool {
+func (rd *RebootDetector) matchesOS(ct *chunkText) bool {
 	// Also check for common OS indicators without regex
-	lowerText := strings.ToLower(text)
 	simplePatterns := []string{
 		"welcome to",
 		"kernel:",
@@ -150,17 +139,19 @@ func (rd *RebootDetector) matchesOS(text string) bool {
 		"kubelet",
 	}
 	for _, p := range simplePatterns {
-		if strings.Contains(lowerText, p) {
+		if ct.contains(p) {
 			return true
 		}
 	}
 
-	for _, p := range rd.osPatterns {
-		if p.MatchString(text) {
-			return true
-		}
-	}
-	return false
+	return rd.osPatterns.match(ct)
+}
+
+// Forget drops a removed server's boot state.
+func (rd *RebootDetector) Forget(serverName string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	delete(rd.states, serverName)
 }
 
 // MarkOSRunning can be called externally to mark that the OS is running
@@ -0,0 +1,93 @@
+package sol
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetRebootLoop sets how many boots within window make a reboot loop.
+// Zero reboots disables detection.
+func (a *Analytics) SetRebootLoop(reboots int, window time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.loopReboots = reboots
+	a.loopWindow = window
+}
+
+// checkRebootLoop runs as a boot starts and marks the server looping once
+// loopReboots boots have started within loopWindow. It returns the event
+// detail when the loop is first detected. Must be called with a.mu held.
+func (a *Analytics) checkRebootLoop(server *ServerAnalytics, now time.Time) string {
+	if a.loopReboots <= 0 || a.loopWindow <= 0 || server.RebootLooping {
+		return ""
+	}
+	cutoff := now.Add(-a.loopWindow)
+	n := 0
+	first := now
+	if server.CurrentBoot != nil && !server.CurrentBoot.StartTime.Before(cutoff) {
+		n++
+		first = server.CurrentBoot.StartTime
+	}
+	for i := len(server.BootHistory) - 1; i >= 0; i-- {
+		start := server.BootHistory[i].StartTime
+		if start.Before(cutoff) {
+			break
+		}
+		n++
+		first = start
+	}
+	if n < a.loopReboots {
+		return ""
+	}
+	server.RebootLooping = true
+	server.RebootLoopSince = &first
+	log.Warnf("Reboot loop on %s: %d boots within %v", server.ServerName, n, a.loopWindow)
+	return fmt.Sprintf("%d boots within %v", n, a.loopWindow)
+}
+
+// endRebootLoop clears the loop once a boot reaches the OS. It reports
+// whether the server was looping. Must be called with a.mu held.
+func (a *Analytics) endRebootLoop(server *ServerAnalytics) bool {
+	if !server.RebootLooping {
+		return false
+	}
+	log.Infof("Reboot loop on %s ended: boot reached the OS", server.ServerName)
+	server.RebootLooping = false
+	server.RebootLoopSince = nil
+	return true
+}
+
+// rebootLooping reports whether the server is in a reboot loop that is
+// still going: the latest boot started within the loop window. A server
+// that stopped rebooting without reaching the OS (e.g. powered off) drops
+// out once the window passes.
+func (a *Analytics) rebootLooping(serverName string, now time.Time) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	server, ok := a.servers[serverName]
+	if !ok || !server.RebootLooping || server.CurrentBoot == nil {
+		return false
+	}
+	return now.Sub(server.CurrentBoot.StartTime) < a.loopWindow
+}
+
+// SetRebootLoop configures reboot-loop detection: reboots boots within
+// window make a loop (0 disables it). With pauseRotation, log rotation is
+// refused while a server loops, so the whole loop stays in one log file.
+func (m *Manager) SetRebootLoop(reboots int, window time.Duration, pauseRotation bool) {
+	m.analytics.SetRebootLoop(reboots, window)
+	m.pauseRotationOnLoop.Store(pauseRotation)
+}
+
+// RebootLooping reports whether the server is rebooting in a loop.
+func (m *Manager) RebootLooping(serverName string) bool {
+	return m.analytics.rebootLooping(serverName, time.Now())
+}
+
+// RotationPaused reports whether log rotation is held off for the server
+// because it is in a reboot loop.
+func (m *Manager) RotationPaused(serverName string) bool {
+	return m.pauseRotationOnLoop.Load() && m.RebootLooping(serverName)
+}
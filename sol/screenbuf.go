@@ -1,46 +1,175 @@
 package sol
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
-const defaultScreenBufSize = 64 * 1024 // 64KB
+const (
+	defaultScreenBufSize = 1 << 20   // 1MB, logs.scrollback_mb
+	catchupSize          = 64 * 1024 // screen replayed to a viewer that attaches without a cursor
+	screenBufGrowth      = 64 * 1024 // smallest allocation while a buffer grows to its size
+)
 
 // ScreenBuffer maintains a rolling buffer of raw SOL bytes.
 // Used for terminal catchup when switching between servers —
-// replaying raw bytes into xterm.js produces correct screen state.
+// replaying raw bytes into xterm.js produces correct screen state —
+// and for scrollback beyond what a viewer still holds.
+//
+// Positions in the stream of bytes written are offsets: the buffer holds
+// the bytes from Start up to Offset. A viewer that has seen output up to an
+// offset can resume from it with Since while those bytes are still held.
+// Offsets start at the buffer's creation time in nanoseconds rather than
+// zero, so a cursor from an earlier buffer (or an earlier process) never
+// matches.
+//
+// The buffer is a ring: memory grows with the output up to the size, then
+// the oldest bytes are overwritten in place.
 type ScreenBuffer struct {
 	mu   sync.RWMutex
-	data []byte
+	data []byte // len grows to max; once full, the oldest byte is at head
+	head int
 	max  int
+	end  int64 // offset after the last byte written
 }
 
 func NewScreenBuffer(maxSize int) *ScreenBuffer {
+	maxSize = max(maxSize, 1)
 	return &ScreenBuffer{
-		data: make([]byte, 0, maxSize),
+		data: make([]byte, 0, min(maxSize, screenBufGrowth)),
 		max:  maxSize,
+		end:  time.Now().UnixNano(),
 	}
 }
 
 func (sb *ScreenBuffer) Write(p []byte) {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
-	sb.data = append(sb.data, p...)
-	if len(sb.data) > sb.max {
-		excess := len(sb.data) - sb.max
-		copy(sb.data, sb.data[excess:])
-		sb.data = sb.data[:sb.max]
+	sb.end += int64(len(p))
+	if len(p) > sb.max {
+		p = p[len(p)-sb.max:]
 	}
+	if room := sb.max - len(sb.data); room > 0 {
+		n := min(room, len(p))
+		if len(sb.data)+n > cap(sb.data) {
+			grown := make([]byte, len(sb.data), min(sb.max, max(2*cap(sb.data), len(sb.data)+n, screenBufGrowth)))
+			copy(grown, sb.data)
+			sb.data = grown
+		}
+		sb.data = append(sb.data, p[:n]...)
+		p = p[n:]
+	}
+	for len(p) > 0 {
+		n := copy(sb.data[sb.head:], p)
+		p = p[n:]
+		sb.head = (sb.head + n) % len(sb.data)
+	}
+}
+
+// copyOut copies the held bytes from the i-th oldest into out. Must be
+// called with sb.mu held.
+func (sb *ScreenBuffer) copyOut(out []byte, i int) {
+	pos := (sb.head + i) % max(len(sb.data), 1)
+	n := copy(out, sb.data[pos:])
+	copy(out[n:], sb.data)
 }
 
 func (sb *ScreenBuffer) Bytes() []byte {
 	sb.mu.RLock()
 	defer sb.mu.RUnlock()
 	out := make([]byte, len(sb.data))
-	copy(out, sb.data)
+	sb.copyOut(out, 0)
 	return out
 }
 
+// Tail returns a copy of the newest n bytes held.
+func (sb *ScreenBuffer) Tail(n int) []byte {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	n = min(n, len(sb.data))
+	out := make([]byte, n)
+	sb.copyOut(out, len(sb.data)-n)
+	return out
+}
+
+// Offset returns the offset after the last byte written.
+func (sb *ScreenBuffer) Offset() int64 {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.end
+}
+
+// Since returns a copy of the bytes written after offset, or false if they
+// are no longer all held (or offset is from before a Reset).
+func (sb *ScreenBuffer) Since(offset int64) ([]byte, bool) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	start := sb.end - int64(len(sb.data))
+	if offset < start || offset > sb.end {
+		return nil, false
+	}
+	out := make([]byte, sb.end-offset)
+	sb.copyOut(out, int(offset-start))
+	return out, true
+}
+
+// Scrollback is a range of a server's held console output. Data is raw,
+// escape sequences included, from offset From up to To; Start and End
+// bound everything held, so a client paging back knows when it has reached
+// the oldest byte.
+type Scrollback struct {
+	Start int64  `json:"start"` // offset of the oldest byte held
+	End   int64  `json:"end"`   // offset after the newest byte
+	From  int64  `json:"from"`  // offset of Data's first byte
+	To    int64  `json:"to"`    // offset after Data's last byte
+	Data  []byte `json:"data"`  // base64 in JSON
+}
+
+// Page returns at most limit held bytes: those just before offset before
+// when it is 0 or more, else those from offset from when it is 0 or more,
+// else the newest. Offsets outside what is held are clamped to it.
+func (sb *ScreenBuffer) Page(from, before int64, limit int) Scrollback {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	start := sb.end - int64(len(sb.data))
+	page := Scrollback{Start: start, End: sb.end}
+	n := int64(max(limit, 0))
+	switch {
+	case before >= 0:
+		page.To = min(max(before, start), sb.end)
+		page.From = max(page.To-n, start)
+	case from >= 0:
+		page.From = min(max(from, start), sb.end)
+		page.To = min(page.From+n, sb.end)
+	default:
+		page.To = sb.end
+		page.From = max(page.To-n, start)
+	}
+	page.Data = make([]byte, page.To-page.From)
+	sb.copyOut(page.Data, int(page.From-start))
+	return page
+}
+
+// Resize changes the most the buffer holds, keeping the newest bytes.
+func (sb *ScreenBuffer) Resize(maxSize int) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	maxSize = max(maxSize, 1)
+	if maxSize == sb.max {
+		return
+	}
+	keep := min(len(sb.data), maxSize)
+	data := make([]byte, keep, min(maxSize, max(keep, screenBufGrowth)))
+	sb.copyOut(data, len(sb.data)-keep)
+	sb.data, sb.head, sb.max = data, 0, maxSize
+}
+
+// Reset empties the buffer. The reset takes up one offset, so viewers that
+// saw output from before it can't resume past it.
 func (sb *ScreenBuffer) Reset() {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
 	sb.data = sb.data[:0]
+	sb.head = 0
+	sb.end++
 }
@@ -0,0 +1,39 @@
+package sol
+
+// SetScrollback sets how many bytes of raw console output each server's
+// screen buffer holds, with per-server overrides; zero or less means the
+// default. Buffers already running are resized, keeping their newest bytes.
+func (m *Manager) SetScrollback(def int, servers map[string]int) {
+	m.actorMu.Lock()
+	defer m.actorMu.Unlock()
+	m.screenSize = def
+	m.screenSizes = servers
+	for name, a := range m.actors {
+		a.screen.Resize(m.scrollbackForLocked(name))
+	}
+}
+
+// scrollbackForLocked returns a server's screen buffer size. Must be called
+// with m.actorMu held.
+func (m *Manager) scrollbackForLocked(serverName string) int {
+	n, ok := m.screenSizes[serverName]
+	if !ok || n <= 0 {
+		n = m.screenSize
+	}
+	if n <= 0 {
+		n = defaultScreenBufSize
+	}
+	return n
+}
+
+// GetScrollback returns at most limit bytes of a server's held console
+// output: those before offset before when it is 0 or more, else those from
+// offset from when it is 0 or more, else the newest. It returns false if
+// the server has no console yet.
+func (m *Manager) GetScrollback(serverName string, from, before int64, limit int) (Scrollback, bool) {
+	a := m.existingActor(serverName)
+	if a == nil {
+		return Scrollback{}, false
+	}
+	return a.screen.Page(from, before, limit), true
+}
@@ -0,0 +1,310 @@
+package sol
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gwest/go-sol"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxSELEntries bounds the stored SEL history per server.
+const maxSELEntries = 1000
+
+// SELEntry is a decoded System Event Log record as stored and served by the API.
+type SELEntry struct {
+	ID           uint16    `json:"id"`
+	Time         time.Time `json:"time"`
+	RecordType   uint8     `json:"recordType"`
+	SensorType   string    `json:"sensorType,omitempty"`
+	SensorNumber uint8     `json:"sensorNumber"`
+	Event        string    `json:"event,omitempty"`
+	Deasserted   bool      `json:"deasserted,omitempty"`
+	Raw          string    `json:"raw"`
+	CollectedAt  time.Time `json:"collectedAt"`
+}
+
+// selState tracks what has been collected from one server's SEL.
+type selState struct {
+	Entries      []SELEntry `json:"entries"`
+	LastAddition time.Time  `json:"lastAddition"`
+}
+
+// SELCollector periodically reads the BMC SEL over each live SOL session
+// and persists decoded entries to <logPath>/<server>/sel.json.
+type SELCollector struct {
+	dataPath string
+	servers  map[string]*selState
+	mu       sync.RWMutex
+}
+
+func NewSELCollector(dataPath string) *SELCollector {
+	return &SELCollector{
+		dataPath: dataPath,
+		servers:  make(map[string]*selState),
+	}
+}
+
+// RunSELCollector polls the SEL of every connected session at the given interval
+// until ctx is cancelled. A zero interval disables collection.
+func (m *Manager) RunSELCollector(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for name, session := range m.GetSessions() {
+			if !session.Connected || session.solSession == nil {
+				continue
+			}
+			if err := m.sel.collect(ctx, name, session.solSession); err != nil {
+				log.Debugf("SEL collection for %s failed: %v", name, err)
+			}
+		}
+	}
+}
+
+// GetSEL returns the stored SEL entries for a server, newest first.
+func (m *Manager) GetSEL(serverName string) []SELEntry {
+	return m.sel.Entries(serverName)
+}
+
+// Entries returns a server's SEL entries, newest first. Servers not yet
+// collected this run are read from disk without being cached, so lookups
+// for arbitrary names leave no state behind.
+func (c *SELCollector) Entries(serverName string) []SELEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st, ok := c.servers[serverName]
+	if !ok {
+		st = c.load(serverName)
+	}
+	out := make([]SELEntry, len(st.Entries))
+	for i, e := range st.Entries {
+		out[len(out)-1-i] = e
+	}
+	return out
+}
+
+func (c *SELCollector) collect(ctx context.Context, serverName string, s *sol.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	info, err := s.GetSELInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	st := c.state(serverName)
+	c.mu.RLock()
+	unchanged := !info.LastAddition.IsZero() && info.LastAddition.Equal(st.LastAddition)
+	c.mu.RUnlock()
+	if unchanged || info.Entries == 0 {
+		return nil
+	}
+
+	c.mu.RLock()
+	known := make(map[string]bool, len(st.Entries))
+	for _, e := range st.Entries {
+		known[selKey(e.ID, e.Time)] = true
+	}
+	c.mu.RUnlock()
+
+	var added []SELEntry
+	id := uint16(sol.SELFirstRecord)
+	for i := 0; i <= int(info.Entries) && id != sol.SELLastRecord; i++ {
+		rec, next, err := s.GetSELEntry(ctx, id)
+		if err != nil {
+			return fmt.Errorf("get SEL entry 0x%04X: %w", id, err)
+		}
+		entry := decodeSELRecord(rec)
+		if !known[selKey(entry.ID, entry.Time)] {
+			added = append(added, entry)
+		}
+		id = next
+	}
+
+	c.mu.Lock()
+	st.Entries = append(st.Entries, added...)
+	sort.SliceStable(st.Entries, func(i, j int) bool {
+		return st.Entries[i].Time.Before(st.Entries[j].Time)
+	})
+	if len(st.Entries) > maxSELEntries {
+		st.Entries = st.Entries[len(st.Entries)-maxSELEntries:]
+	}
+	st.LastAddition = info.LastAddition
+	c.mu.Unlock()
+
+	if len(added) > 0 {
+		log.Infof("Collected %d new SEL entries for %s", len(added), serverName)
+	}
+	c.save(serverName)
+	return nil
+}
+
+func selKey(id uint16, t time.Time) string {
+	return fmt.Sprintf("%04x-%d", id, t.Unix())
+}
+
+// state returns the in-memory SEL state for a server, loading it from disk on first use.
+func (c *SELCollector) state(serverName string) *selState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st, ok := c.servers[serverName]; ok {
+		return st
+	}
+	st := c.load(serverName)
+	c.servers[serverName] = st
+	return st
+}
+
+// load reads a server's stored SEL state; c.mu must be held.
+func (c *SELCollector) load(serverName string) *selState {
+	st := &selState{}
+	if data, err := os.ReadFile(c.filePath(serverName)); err == nil {
+		if err := json.Unmarshal(data, st); err != nil {
+			log.Warnf("Failed to parse SEL store for %s: %v", serverName, err)
+		}
+	}
+	return st
+}
+
+// setDataPath points the collector at a new data directory.
+func (c *SELCollector) setDataPath(dataPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dataPath = dataPath
+}
+
+// filePath must be called with c.mu held.
+func (c *SELCollector) filePath(serverName string) string {
+	return filepath.Join(c.dataPath, serverName, "sel.json")
+}
+
+func (c *SELCollector) save(serverName string) {
+	c.mu.RLock()
+	if c.dataPath == "" {
+		c.mu.RUnlock()
+		return
+	}
+	data, err := json.MarshalIndent(c.servers[serverName], "", "  ")
+	path := c.filePath(serverName)
+	c.mu.RUnlock()
+	if err != nil {
+		log.Errorf("Failed to marshal SEL for %s: %v", serverName, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Errorf("Failed to create SEL directory: %v", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Errorf("Failed to save SEL for %s: %v", serverName, err)
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+func decodeSELRecord(rec *sol.SELRecord) SELEntry {
+	e := SELEntry{
+		ID:           rec.ID,
+		Time:         rec.Timestamp,
+		RecordType:   rec.Type,
+		SensorNumber: rec.SensorNumber,
+		Deasserted:   rec.Deasserted,
+		Raw:          hex.EncodeToString(rec.Raw),
+		CollectedAt:  time.Now(),
+	}
+	if rec.Type != 0x02 {
+		e.Event = fmt.Sprintf("OEM record type 0x%02X", rec.Type)
+		return e
+	}
+	e.SensorType = selSensorTypes[rec.SensorType]
+	if e.SensorType == "" {
+		e.SensorType = fmt.Sprintf("Sensor type 0x%02X", rec.SensorType)
+	}
+
+	offset := rec.EventData[0] & 0x0F
+	if rec.EventType == 0x6F {
+		// Sensor-specific event: look up the offset for this sensor type
+		if events, ok := selSensorEvents[rec.SensorType]; ok && int(offset) < len(events) {
+			e.Event = events[offset]
+		}
+	} else if rec.EventType == 0x01 {
+		e.Event = selThresholdEvents[offset%12]
+	}
+	if e.Event == "" {
+		e.Event = fmt.Sprintf("Event type 0x%02X offset %d", rec.EventType, offset)
+	}
+	return e
+}
+
+// selSensorTypes maps IPMI sensor type codes to names (IPMI v2.0 table 42-3).
+var selSensorTypes = map[uint8]string{
+	0x01: "Temperature",
+	0x02: "Voltage",
+	0x03: "Current",
+	0x04: "Fan",
+	0x05: "Physical Security",
+	0x06: "Platform Security",
+	0x07: "Processor",
+	0x08: "Power Supply",
+	0x09: "Power Unit",
+	0x0C: "Memory",
+	0x0D: "Drive Slot",
+	0x0F: "System Firmware Progress",
+	0x10: "Event Logging Disabled",
+	0x12: "System Event",
+	0x13: "Critical Interrupt",
+	0x14: "Button/Switch",
+	0x19: "Chipset",
+	0x1D: "System Boot Initiated",
+	0x1F: "OS Boot",
+	0x20: "OS Critical Stop",
+	0x21: "Slot/Connector",
+	0x23: "Watchdog",
+	0x28: "Management Subsystem Health",
+	0x2B: "Version Change",
+}
+
+// selSensorEvents holds the sensor-specific event offsets for common sensor types.
+var selSensorEvents = map[uint8][]string{
+	0x05: {"General chassis intrusion", "Drive bay intrusion", "I/O card area intrusion", "Processor area intrusion", "LAN leash lost", "Unauthorized dock", "Fan area intrusion"},
+	0x07: {"IERR", "Thermal trip", "FRB1/BIST failure", "FRB2/Hang in POST failure", "FRB3/Processor startup failure", "Configuration error", "Uncorrectable CPU-complex error", "Processor presence detected", "Processor disabled", "Terminator presence detected", "Processor automatically throttled", "Machine check exception (uncorrectable)", "Correctable machine check error"},
+	0x08: {"Presence detected", "Power supply failure detected", "Predictive failure", "Power supply input lost (AC/DC)", "Power supply input lost or out-of-range", "Power supply input out-of-range, but present", "Configuration error", "Power supply inactive"},
+	0x09: {"Power off/down", "Power cycle", "240VA power down", "Interlock power down", "AC lost", "Soft power control failure", "Power unit failure detected", "Predictive failure"},
+	0x0C: {"Correctable ECC", "Uncorrectable ECC", "Parity", "Memory scrub failed", "Memory device disabled", "Correctable ECC logging limit reached", "Presence detected", "Configuration error", "Spare", "Memory automatically throttled", "Critical overtemperature"},
+	0x0F: {"System firmware error (POST error)", "System firmware hang", "System firmware progress"},
+	0x10: {"Correctable memory error logging disabled", "Event type logging disabled", "Log area reset/cleared", "All event logging disabled", "SEL full", "SEL almost full"},
+	0x12: {"System reconfigured", "OEM system boot event", "Undetermined system hardware failure", "Entry added to auxiliary log", "PEF action", "Timestamp clock synch"},
+	0x13: {"Front panel NMI/diagnostic interrupt", "Bus timeout", "I/O channel check NMI", "Software NMI", "PCI PERR", "PCI SERR", "EISA fail safe timeout", "Bus correctable error", "Bus uncorrectable error", "Fatal NMI", "Bus fatal error", "Bus degraded"},
+	0x1D: {"Initiated by power up", "Initiated by hard reset", "Initiated by warm reset", "User requested PXE boot", "Automatic boot to diagnostic", "OS/run-time software initiated hard reset", "OS/run-time software initiated warm reset", "System restart"},
+	0x20: {"Critical stop during OS load", "Run-time critical stop", "OS graceful stop", "OS graceful shutdown", "Soft shutdown initiated by PEF", "Agent not responding"},
+	0x23: {"Timer expired", "Hard reset", "Power down", "Power cycle", "", "", "", "", "Timer interrupt"},
+}
+
+// selThresholdEvents names the generic threshold event offsets (event type 0x01).
+var selThresholdEvents = []string{
+	"Lower non-critical going low", "Lower non-critical going high",
+	"Lower critical going low", "Lower critical going high",
+	"Lower non-recoverable going low", "Lower non-recoverable going high",
+	"Upper non-critical going low", "Upper non-critical going high",
+	"Upper critical going low", "Upper critical going high",
+	"Upper non-recoverable going low", "Upper non-recoverable going high",
+}
@@ -0,0 +1,238 @@
+package sol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gwest/go-sol"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sensor statuses
+const (
+	SensorOK          = "ok"
+	SensorWarning     = "warning"
+	SensorCritical    = "critical"
+	SensorUnavailable = "unavailable"
+)
+
+// bmcOwnerID is the slave address of the BMC. Sensors owned by other
+// controllers would need bridged requests and are skipped.
+const bmcOwnerID = 0x20
+
+// Sensor is one sensor's latest reading as served by the API.
+type Sensor struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Number uint8    `json:"number"`
+	Value  *float64 `json:"value,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	Status string   `json:"status"` // ok, warning, critical, unavailable
+	States []string `json:"states,omitempty"`
+}
+
+// SensorSnapshot is the result of the latest poll of one server.
+type SensorSnapshot struct {
+	Updated time.Time `json:"updated"`
+	Sensors []Sensor  `json:"sensors"`
+}
+
+// sensorState caches a server's SDR records (re-read only when the
+// repository changes) and its latest readings.
+type sensorState struct {
+	records      []*sol.SDRRecord
+	lastAddition time.Time
+	snapshot     SensorSnapshot
+}
+
+// SensorCollector polls sensor readings over each live SOL session and keeps
+// the latest snapshot per server in memory.
+type SensorCollector struct {
+	servers map[string]*sensorState
+	mu      sync.RWMutex
+}
+
+func NewSensorCollector() *SensorCollector {
+	return &SensorCollector{servers: make(map[string]*sensorState)}
+}
+
+// RunSensorCollector polls the sensors of every connected session at the
+// given interval until ctx is cancelled. A zero interval disables polling.
+func (m *Manager) RunSensorCollector(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for name, session := range m.GetSessions() {
+			if !session.Connected || session.solSession == nil {
+				continue
+			}
+			if err := m.sensors.collect(ctx, name, session.solSession); err != nil {
+				log.Debugf("Sensor polling for %s failed: %v", name, err)
+			}
+		}
+	}
+}
+
+// GetSensors returns a server's latest sensor readings.
+func (m *Manager) GetSensors(serverName string) SensorSnapshot {
+	m.sensors.mu.RLock()
+	defer m.sensors.mu.RUnlock()
+	if st, ok := m.sensors.servers[serverName]; ok {
+		return st.snapshot
+	}
+	return SensorSnapshot{Sensors: []Sensor{}}
+}
+
+// GetAllSensors returns the latest sensor readings of every polled server.
+func (m *Manager) GetAllSensors() map[string]SensorSnapshot {
+	m.sensors.mu.RLock()
+	defer m.sensors.mu.RUnlock()
+	out := make(map[string]SensorSnapshot, len(m.sensors.servers))
+	for name, st := range m.sensors.servers {
+		if !st.snapshot.Updated.IsZero() {
+			out[name] = st.snapshot
+		}
+	}
+	return out
+}
+
+func (c *SensorCollector) collect(ctx context.Context, serverName string, s *sol.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	info, err := s.GetSDRRepositoryInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	st, ok := c.servers[serverName]
+	if !ok {
+		st = &sensorState{}
+		c.servers[serverName] = st
+	}
+	records := st.records
+	stale := records == nil || !info.LastAddition.Equal(st.lastAddition)
+	c.mu.Unlock()
+
+	if stale {
+		all, err := s.ListSDR(ctx)
+		if err != nil {
+			return err
+		}
+		records = make([]*sol.SDRRecord, 0, len(all))
+		for _, rec := range all {
+			if (rec.Type == sol.SDRTypeFull || rec.Type == sol.SDRTypeCompact) &&
+				rec.OwnerID == bmcOwnerID && rec.OwnerLUN == 0 {
+				records = append(records, rec)
+			}
+		}
+		log.Debugf("Read %d sensor records for %s", len(records), serverName)
+	}
+
+	sensors := make([]Sensor, 0, len(records))
+	for _, rec := range records {
+		reading, err := s.GetSensorReading(ctx, rec.Number)
+		var ce *sol.CompletionError
+		if errors.As(err, &ce) {
+			// e.g. 0xCB: sensor not present
+			reading, err = &sol.SensorReading{Unavailable: true}, nil
+		}
+		if err != nil {
+			return fmt.Errorf("read sensor %q: %w", rec.Name, err)
+		}
+		sensors = append(sensors, decodeSensor(rec, reading))
+	}
+
+	c.mu.Lock()
+	st.records = records
+	st.lastAddition = info.LastAddition
+	st.snapshot = SensorSnapshot{Updated: time.Now(), Sensors: sensors}
+	c.mu.Unlock()
+	return nil
+}
+
+// sensorThresholds names the threshold comparison bits; bits 1, 2, 4 and 5
+// are critical or worse.
+var sensorThresholds = []struct {
+	state  string
+	status string
+}{
+	{"below lower non-critical", SensorWarning},
+	{"below lower critical", SensorCritical},
+	{"below lower non-recoverable", SensorCritical},
+	{"above upper non-critical", SensorWarning},
+	{"above upper critical", SensorCritical},
+	{"above upper non-recoverable", SensorCritical},
+}
+
+// sensorSeverity rates sensor-specific state offsets that aren't healthy;
+// offsets not listed (e.g. "Presence detected") are ok.
+var sensorSeverity = map[uint8]map[int]string{
+	0x08: {1: SensorCritical, 2: SensorWarning, 3: SensorCritical, 4: SensorCritical, 5: SensorWarning, 6: SensorWarning, 7: SensorWarning},
+	0x09: {2: SensorWarning, 3: SensorWarning, 4: SensorCritical, 5: SensorCritical, 6: SensorCritical, 7: SensorWarning},
+}
+
+func decodeSensor(rec *sol.SDRRecord, reading *sol.SensorReading) Sensor {
+	sensor := Sensor{
+		Name:   rec.Name,
+		Type:   selSensorTypes[rec.SensorType],
+		Number: rec.Number,
+		Unit:   rec.Unit,
+		Status: SensorOK,
+	}
+	if sensor.Type == "" {
+		sensor.Type = fmt.Sprintf("Sensor type 0x%02X", rec.SensorType)
+	}
+	if reading.Unavailable || reading.ScanningDisabled {
+		sensor.Status = SensorUnavailable
+		return sensor
+	}
+
+	if v, ok := rec.Convert(reading.Raw); ok {
+		sensor.Value = &v
+	}
+	worse := func(status string) {
+		if status == SensorCritical || sensor.Status == SensorOK {
+			sensor.Status = status
+		}
+	}
+	switch rec.EventType {
+	case 0x01:
+		for bit, t := range sensorThresholds {
+			if reading.State&(1<<bit) != 0 {
+				sensor.States = append(sensor.States, t.state)
+				worse(t.status)
+			}
+		}
+	case 0x6F:
+		events := selSensorEvents[rec.SensorType]
+		for bit := 0; bit < 15; bit++ {
+			if reading.State&(1<<bit) == 0 {
+				continue
+			}
+			if bit < len(events) && events[bit] != "" {
+				sensor.States = append(sensor.States, events[bit])
+			} else {
+				sensor.States = append(sensor.States, fmt.Sprintf("State %d", bit))
+			}
+			if status, ok := sensorSeverity[rec.SensorType][bit]; ok {
+				worse(status)
+			}
+		}
+	}
+	return sensor
+}
@@ -0,0 +1,48 @@
+package sol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gwest/go-sol"
+)
+
+// SerialConfig is the serial MUX setting and console UART selection applied
+// to a BMC before SOL is activated.
+type SerialConfig = sol.SerialConfig
+
+// serialMuxSettings maps configured serial MUX settings to go-sol's.
+var serialMuxSettings = map[string]sol.MuxSetting{
+	"":             sol.MuxGet,
+	"system":       sol.MuxRequestSystem,
+	"bmc":          sol.MuxRequestBMC,
+	"force-system": sol.MuxForceSystem,
+	"force-bmc":    sol.MuxForceBMC,
+}
+
+// ParseSerialConfig converts a configured serial MUX setting (system, bmc,
+// force-system, force-bmc or empty to leave it alone), serial channel (0 =
+// the first RS-232 channel) and raw UART selection request ("netfn cmd
+// data..." in hex, as with ipmitool raw).
+func ParseSerialConfig(mux string, channel int, uartCommand string) (SerialConfig, error) {
+	setting, ok := serialMuxSettings[mux]
+	if !ok {
+		return SerialConfig{}, fmt.Errorf("serial mux %q: want system, bmc, force-system or force-bmc", mux)
+	}
+	if channel < 0 || channel > 0x0F {
+		return SerialConfig{}, fmt.Errorf("serial channel %d: want 0-15", channel)
+	}
+	var uart []byte
+	for _, field := range strings.Fields(uartCommand) {
+		b, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(field), "0x"), 16, 8)
+		if err != nil {
+			return SerialConfig{}, fmt.Errorf("uart command: %q is not a hex byte", field)
+		}
+		uart = append(uart, byte(b))
+	}
+	if len(uart) == 1 {
+		return SerialConfig{}, fmt.Errorf("uart command: want netfn, cmd and any data bytes")
+	}
+	return SerialConfig{Channel: uint8(channel), Mux: setting, UART: uart}, nil
+}
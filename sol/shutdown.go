@@ -0,0 +1,52 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Shutdown drains every session for exit. Each SOL payload is deactivated
+// and its RMCP+ session closed, so BMCs are not left holding stale
+// sessions; a connect in progress finishes its handshake first. It then
+// flushes the console pipeline (queued output reaches the logs and sinks)
+// and analytics. No new sessions start afterwards. If ctx ends before every
+// session has stopped, the rest is still flushed and an error returned.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.shuttingDown = true
+	n := len(m.sessions)
+	for _, session := range m.sessions {
+		if session.cancel != nil {
+			session.cancel()
+		}
+	}
+	m.mu.Unlock()
+	log.Infof("Draining %d SOL sessions", n)
+
+	done := make(chan struct{})
+	go func() {
+		m.sessionWG.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+		log.Info("All SOL sessions closed")
+	case <-ctx.Done():
+		err = fmt.Errorf("SOL sessions still closing: %w", ctx.Err())
+		log.Warn("Timed out waiting for SOL sessions to close")
+	}
+
+	// Close the actors, which finish writing what they have queued
+	m.actorMu.Lock()
+	actors := m.actors
+	m.actors = make(map[string]*ServerActor)
+	m.actorMu.Unlock()
+	for _, a := range actors {
+		a.Close()
+	}
+	m.analytics.flush()
+	return err
+}
@@ -0,0 +1,208 @@
+package sol
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxSoftwareComponents bounds the per-server software facts list.
+const maxSoftwareComponents = 100
+
+// SoftwareComponent is one piece of software seen on a server's console.
+type SoftwareComponent struct {
+	Name            string    `json:"name"`
+	Kind            string    `json:"kind"` // os, kernel, firmware, bootloader, service
+	Version         string    `json:"version,omitempty"`
+	PreviousVersion string    `json:"previousVersion,omitempty"`
+	FirstSeen       time.Time `json:"firstSeen"`
+	LastSeen        time.Time `json:"lastSeen"`
+	VersionChanged  time.Time `json:"versionChanged,omitempty"`
+}
+
+// SoftwareFacts is the per-server software inventory document.
+type SoftwareFacts struct {
+	Server     string              `json:"server"`
+	Hostname   string              `json:"hostname,omitempty"`
+	OS         string              `json:"os,omitempty"`
+	Components []SoftwareComponent `json:"components"`
+}
+
+type softwareDetector struct {
+	name    string // fixed name, or "" to take it from the first capture group
+	kind    string
+	pattern *matcher // last capture group is the version (optional)
+}
+
+// softwareDetectors extract component names and versions from console text.
+var softwareDetectors = func() []softwareDetector {
+	defs := []struct {
+		name, kind, pattern string
+	}{
+		// Firmware and boot chain
+		{"AMI BIOS", "firmware", `BIOS Date:\s*\S+.*?Ver(?:sion)?:?\s*([\w.\-]+)`},
+		{"AMI Aptio", "firmware", `Version (\d+\.\d+\.\d+)\.?\s*Copyright.*American Megatrends`},
+		{"Intel Boot Agent", "firmware", `Intel\(R\) Boot Agent\s+(?:GE|XE|FE|CL|PXE)?\s*v?(\d+\.\d+\.\d+)`},
+		{"iPXE", "bootloader", `iPXE (\d+\.\d+\.\d+[\w+\-.]*)`},
+		{"GRUB", "bootloader", `GNU GRUB\s+version\s+(\S+)`},
+		{"PXELINUX", "bootloader", `PXELINUX (\d+\.\d+)`},
+
+		// Kernel and OS
+		{"Linux", "kernel", `Linux version (\d+\.\d+\.\d+\S*)`},
+		{"", "os", `Welcome to ((?:Fedora CoreOS|Red Hat Enterprise Linux CoreOS|Ubuntu|Debian GNU/Linux|Fedora Linux|Rocky Linux|AlmaLinux|CentOS Stream|Red Hat Enterprise Linux|Alpine Linux))\s+v?(\d[\w.\-]*)`},
+		{"", "os", `((?:Ubuntu|Rocky Linux|AlmaLinux|Alpine Linux)) v?(\d+\.\d+(?:\.\d+)?)`},
+		{"Fedora", "os", `Fedora release (\d+)`},
+		{"VMware ESXi", "os", `VMware ESXi (\d+\.\d+\.\d+)`},
+		{"FreeBSD", "os", `FreeBSD (\d+\.\d+-\w+)`},
+
+		// Services
+		{"systemd", "service", `systemd (\d+[\w.\-~]*) running in system mode`},
+		{"OpenSSH", "service", `OpenSSH_(\d+\.\d+\w*)`},
+		{"kubelet", "service", `kubelet.*?(?:Kubernetes |kubeletVersion="?)v(\d+\.\d+\.\d+\S*?)["\s,]`},
+		{"kubelet", "service", `Started (?:kubelet|Kubernetes Kubelet)`},
+		{"k3s", "service", `k3s version v(\d+\.\d+\.\d+\S*)`},
+		{"containerd", "service", `containerd.*?version[=\s]"?v?(\d+\.\d+\.\d+)`},
+		{"containerd", "service", `Started containerd`},
+		{"dockerd", "service", `(?:Docker daemon|dockerd).*?version=(\d+\.\d+\.\d+\S*)`},
+		{"dockerd", "service", `Started Docker Application Container Engine`},
+		{"CRI-O", "service", `(?:crio|CRI-O).*?[Vv]ersion[:=\s]+"?(\d+\.\d+\.\d+)`},
+	}
+	var out []softwareDetector
+	for _, d := range defs {
+		if re, err := newMatcher("(?i)" + d.pattern); err == nil {
+			out = append(out, softwareDetector{name: d.name, kind: d.kind, pattern: re})
+		} else {
+			log.Warnf("Bad software pattern %q: %v", d.pattern, err)
+		}
+	}
+	return out
+}()
+
+// softwareObservation is one component/version seen in a chunk of text.
+type softwareObservation struct {
+	name, kind, version string
+}
+
+// matchSoftware extracts component names and versions from console text.
+func matchSoftware(ct *chunkText) []softwareObservation {
+	var obs []softwareObservation
+	for _, d := range softwareDetectors {
+		m := d.pattern.findSubmatch(ct)
+		if m == nil {
+			continue
+		}
+		name, version := d.name, ""
+		groups := m[1:]
+		if name == "" && len(groups) > 0 {
+			name, groups = strings.TrimSpace(groups[0]), groups[1:]
+		}
+		if len(groups) > 0 {
+			version = strings.TrimRight(groups[len(groups)-1], ".,;")
+		}
+		if name != "" {
+			obs = append(obs, softwareObservation{name: name, kind: d.kind, version: version})
+		}
+	}
+	return obs
+}
+
+// trackSoftware merges observations into a server's software inventory.
+// Returns the observations that added a component or changed a version.
+// Must be called with a.mu held.
+func trackSoftware(server *ServerAnalytics, obs []softwareObservation, now time.Time) []softwareObservation {
+	var changed []softwareObservation
+	for _, o := range obs {
+		if recordSoftware(server, o.name, o.kind, o.version, now) {
+			changed = append(changed, o)
+		}
+	}
+	return changed
+}
+
+// recordSoftware merges one observation into the inventory.
+func recordSoftware(server *ServerAnalytics, name, kind, version string, now time.Time) bool {
+	for i := range server.Software {
+		c := &server.Software[i]
+		if c.Name != name || c.Kind != kind {
+			continue
+		}
+		c.LastSeen = now
+		if version == "" || version == c.Version {
+			return false
+		}
+		if c.Version != "" {
+			log.Infof("Software change on %s: %s %s -> %s", server.ServerName, name, c.Version, version)
+			c.PreviousVersion = c.Version
+			c.VersionChanged = now
+		}
+		c.Version = version
+		return true
+	}
+
+	if len(server.Software) >= maxSoftwareComponents {
+		return false
+	}
+	server.Software = append(server.Software, SoftwareComponent{
+		Name:      name,
+		Kind:      kind,
+		Version:   version,
+		FirstSeen: now,
+		LastSeen:  now,
+	})
+	return true
+}
+
+func (m *Manager) GetSoftware(serverName string) *SoftwareFacts {
+	return m.analytics.GetSoftware(serverName)
+}
+
+func (m *Manager) GetAllSoftware() map[string]*SoftwareFacts {
+	return m.analytics.GetAllSoftware()
+}
+
+// GetSoftware returns the software facts document for one server.
+func (a *Analytics) GetSoftware(serverName string) *SoftwareFacts {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	server, exists := a.servers[serverName]
+	if !exists {
+		return &SoftwareFacts{Server: serverName, Components: []SoftwareComponent{}}
+	}
+	return softwareFacts(server)
+}
+
+// GetAllSoftware returns software facts for every server with any detected software.
+func (a *Analytics) GetAllSoftware() map[string]*SoftwareFacts {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := make(map[string]*SoftwareFacts)
+	for name, server := range a.servers {
+		if len(server.Software) > 0 {
+			result[name] = softwareFacts(server)
+		}
+	}
+	return result
+}
+
+// softwareFacts copies a server's inventory, sorted by kind then name.
+// Must be called with a.mu held.
+func softwareFacts(server *ServerAnalytics) *SoftwareFacts {
+	components := append([]SoftwareComponent{}, server.Software...)
+	kindOrder := map[string]int{"firmware": 0, "bootloader": 1, "kernel": 2, "os": 3, "service": 4}
+	sort.Slice(components, func(i, j int) bool {
+		if components[i].Kind != components[j].Kind {
+			return kindOrder[components[i].Kind] < kindOrder[components[j].Kind]
+		}
+		return components[i].Name < components[j].Name
+	})
+	return &SoftwareFacts{
+		Server:     server.ServerName,
+		Hostname:   server.Hostname,
+		OS:         server.CurrentOS,
+		Components: components,
+	}
+}
@@ -0,0 +1,151 @@
+package sol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gwest/go-sol"
+)
+
+// DialFunc opens a socket to a BMC, as go-sol's Config.Dial.
+type DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// SourcePorts opens the UDP sockets to BMCs (SOL and command sessions, and
+// reachability probes) from ipmi.source_address, each on a free port of
+// ipmi.source_ports, marked with ipmi.dscp, for management networks that
+// only let known source ports through. Ports are not tracked: a socket
+// walks the range from where the last one stopped and takes the first port
+// the OS lets it bind.
+type SourcePorts struct {
+	address string // source IP; empty = any
+	lo, hi  int    // port range; 0 = any port
+	dscp    int
+
+	mu   sync.Mutex
+	next int // port the next socket tries first
+}
+
+// NewSourcePorts parses the source settings; ports is a single port or a
+// "low-high" range. It returns nil, dialing as go-sol does by default,
+// when nothing is set.
+func NewSourcePorts(address, ports string, dscp int) (*SourcePorts, error) {
+	if address == "" && ports == "" && dscp == 0 {
+		return nil, nil
+	}
+	if address != "" && net.ParseIP(address) == nil {
+		return nil, fmt.Errorf("invalid source address %q", address)
+	}
+	if dscp < 0 || dscp > 63 {
+		return nil, fmt.Errorf("DSCP %d out of range 0-63", dscp)
+	}
+	lo, hi, err := ParsePortRange(ports)
+	if err != nil {
+		return nil, err
+	}
+	return &SourcePorts{address: address, lo: lo, hi: hi, dscp: dscp, next: lo}, nil
+}
+
+// ParsePortRange parses "port" or "low-high"; empty is 0, 0 (any port).
+func ParsePortRange(s string) (lo, hi int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	first, last, isRange := strings.Cut(s, "-")
+	if lo, err = strconv.Atoi(strings.TrimSpace(first)); err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", first)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+			return 0, 0, fmt.Errorf("invalid port %q", last)
+		}
+	}
+	if lo < 1 || hi > 65535 || lo > hi {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return lo, hi, nil
+}
+
+// Dial opens a UDP socket to addr from the next free source port. It fails
+// when every port in the range is taken. A nil SourcePorts dials from any
+// port.
+func (p *SourcePorts) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p == nil {
+		return sol.SocketOptions{}.Dial(ctx, network, addr)
+	}
+	if p.lo == 0 {
+		return p.options(0).Dial(ctx, network, addr)
+	}
+
+	n := p.hi - p.lo + 1
+	for i := 0; i < n; i++ {
+		p.mu.Lock()
+		port := p.next
+		p.next++
+		if p.next > p.hi {
+			p.next = p.lo
+		}
+		p.mu.Unlock()
+
+		conn, err := p.options(port).Dial(ctx, network, addr)
+		if errors.Is(err, syscall.EADDRINUSE) {
+			continue
+		}
+		return conn, err
+	}
+	return nil, fmt.Errorf("no free source port in %d-%d", p.lo, p.hi)
+}
+
+func (p *SourcePorts) options(port int) sol.SocketOptions {
+	return sol.SocketOptions{
+		LocalAddr: net.JoinHostPort(p.address, strconv.Itoa(port)),
+		DSCP:      p.dscp,
+	}
+}
+
+// SetSourcePorts sets how sessions connected from now on open their
+// sockets; nil is any source port, unmarked.
+func (m *Manager) SetSourcePorts(p *SourcePorts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sourcePorts = p
+}
+
+// SetProxies sets the proxies sessions connected from now on reach BMCs
+// through, by default and per server (bmcproxy.New); nil is direct, from
+// the source ports. Redfish session clearing uses them too.
+func (m *Manager) SetProxies(def DialFunc, servers map[string]DialFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxyDefault, m.proxyServers = def, servers
+}
+
+// proxyFor returns the server's proxy, nil = direct. The caller holds mu.
+func (m *Manager) proxyFor(serverName string) DialFunc {
+	if dial, ok := m.proxyServers[serverName]; ok {
+		return dial
+	}
+	return m.proxyDefault
+}
+
+// bmcProxy is proxyFor for callers not holding mu.
+func (m *Manager) bmcProxy(serverName string) DialFunc {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.proxyFor(serverName)
+}
+
+// bmcDial returns how the server's BMC sockets are opened: through its
+// proxy, or direct from the source ports. The caller holds mu.
+func (m *Manager) bmcDial(serverName string) DialFunc {
+	if dial := m.proxyFor(serverName); dial != nil {
+		return dial
+	}
+	return m.sourcePorts.Dial
+}
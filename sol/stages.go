@@ -0,0 +1,123 @@
+package sol
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Boot stages, in the order a boot passes through them
+const (
+	StageBIOS       = "bios"
+	StagePXE        = "pxe"
+	StageBootloader = "bootloader"
+	StageKernel     = "kernel"
+	StageInitrd     = "initrd"
+	StageSystemd    = "systemd"
+	StageLogin      = "login"
+)
+
+// BootStage is one phase of a boot. Duration is filled in when the next
+// stage starts or the boot completes.
+type BootStage struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration,omitempty"` // seconds
+}
+
+type stageDetector struct {
+	name    string
+	pattern *matcher
+}
+
+// stageDetectors recognise the start of each stage after BIOS/POST, which
+// starts with the boot itself. Stages only move forward, so a later stage
+// seen first (e.g. no PXE on a disk boot) simply skips the earlier ones.
+var stageDetectors = func() []stageDetector {
+	defs := []struct {
+		name, pattern string
+	}{
+		{StagePXE, `PXE-|PXE->|iPXE initialising|Intel\(R\) Boot Agent|CLIENT MAC ADDR:|UNDI code segment|Open Source Network Boot Firmware`},
+		{StageBootloader, `GNU GRUB|Booting .*CoreOS|Booting a command list|PXELINUX \d|SYSLINUX \d|Loading Linux |systemd-boot`},
+		{StageKernel, `Linux version \d+\.\d+|\[\s*0\.000000\]`},
+		{StageInitrd, `Run /init as init process|Running in initrd|\(Initramfs\)|dracut-`},
+		{StageSystemd, `Switching root|Reached target .*Multi-User`},
+		{StageLogin, `login:\s*$`},
+	}
+	var out []stageDetector
+	for _, d := range defs {
+		if re, err := newMatcher("(?im)" + d.pattern); err == nil {
+			out = append(out, stageDetector{name: d.name, pattern: re})
+		} else {
+			log.Warnf("Bad stage pattern %q: %v", d.pattern, err)
+		}
+	}
+	return out
+}()
+
+// stageOrder maps a stage name to its position in the boot.
+var stageOrder = map[string]int{
+	StageBIOS: 0, StagePXE: 1, StageBootloader: 2, StageKernel: 3,
+	StageInitrd: 4, StageSystemd: 5, StageLogin: 6,
+}
+
+// matchStages returns the stages whose start appears in the text, in boot order.
+func matchStages(ct *chunkText) []string {
+	var names []string
+	for _, d := range stageDetectors {
+		if d.pattern.match(ct) {
+			names = append(names, d.name)
+		}
+	}
+	return names
+}
+
+// startStages begins a boot's stage tracking with BIOS/POST.
+func startStages(boot *BootEvent) {
+	boot.Stages = []BootStage{{Name: StageBIOS, Start: boot.StartTime}}
+}
+
+// trackStages enters every matched stage later than the boot's current one,
+// closing the previous stage. Tracking continues after the boot is marked
+// complete (the OS-up patterns often match inside the initrd) until login.
+// Returns the stages entered. Must be called with a.mu held.
+func trackStages(boot *BootEvent, matched []string, now time.Time) []string {
+	if len(boot.Stages) == 0 {
+		return nil
+	}
+	var entered []string
+	for _, name := range matched {
+		cur := &boot.Stages[len(boot.Stages)-1]
+		if stageOrder[name] <= stageOrder[cur.Name] {
+			continue
+		}
+		cur.Duration = now.Sub(cur.Start).Seconds()
+		boot.Stages = append(boot.Stages, BootStage{Name: name, Start: now})
+		entered = append(entered, name)
+	}
+	return entered
+}
+
+// finishStages closes the last stage when the boot completes.
+func finishStages(boot *BootEvent) {
+	if n := len(boot.Stages); n > 0 {
+		last := &boot.Stages[n-1]
+		last.Duration = boot.EndTime.Sub(last.Start).Seconds()
+	}
+}
+
+// StageSummary renders stage durations compactly, e.g. "bios 42s, pxe 8s".
+// The running stage of an incomplete boot is shown without a duration.
+func StageSummary(stages []BootStage) string {
+	parts := make([]string, 0, len(stages))
+	for i, s := range stages {
+		if s.Duration == 0 && i == len(stages)-1 {
+			parts = append(parts, s.Name)
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %.0fs", s.Name, s.Duration))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,180 @@
+package sol
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gwest/go-sol"
+	log "github.com/sirupsen/logrus"
+)
+
+// standbyAfter is how many SOL connects in a row must fail before the
+// chassis power state is checked.
+const standbyAfter = 2
+
+// chassisProbeTimeout bounds a command-only session and its request.
+const chassisProbeTimeout = 15 * time.Second
+
+// SetChassisPollInterval sets how often a powered-off server's chassis is
+// polled for power-on while its SOL session is on standby. Zero disables
+// standby: SOL connects are retried whatever the power state.
+func (m *Manager) SetChassisPollInterval(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chassisPoll = interval
+}
+
+// openCommandSession opens an IPMI session to the server's BMC without
+// activating SOL. The caller must close it.
+func (m *Manager) openCommandSession(ctx context.Context, session *Session) (*sol.Session, error) {
+	kg, err := ParseKg(session.Kg)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.limiter.wait(ctx, bmcAddress(session)); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	dial, quirks := m.bmcDial(session.ServerName), m.quirksFor(session.ServerName)
+	conn := m.connectionFor(session.ServerName)
+	m.mu.RUnlock()
+	s := sol.New(sol.Config{
+		Host:        session.IP,
+		Port:        session.Port, // 0 = go-sol default (623)
+		Username:    session.Username,
+		Password:    session.Password,
+		Kg:          kg,
+		Timeout:     chassisProbeTimeout,
+		CipherSuite: conn.CipherSuite,
+		NoV15:       !conn.allowsV15(),
+		Dial:        dial,
+		Quirks:      quirks,
+		Logf: func(format string, args ...interface{}) {
+			log.Debugf("[go-sol] "+format, args...)
+		},
+	})
+	if err := s.Open(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// commandSession returns a go-sol session for IPMI commands: the live SOL
+// session if connected, else (e.g. on standby) a command-only session. The
+// returned func releases it.
+func (m *Manager) commandSession(ctx context.Context, serverName string) (*sol.Session, func(), error) {
+	m.mu.RLock()
+	session, exists := m.sessions[serverName]
+	m.mu.RUnlock()
+
+	if !exists {
+		return nil, nil, fmt.Errorf("server not found: %s", serverName)
+	}
+	if session.Connected && session.solSession != nil {
+		return session.solSession, func() {}, nil
+	}
+	s, err := m.openCommandSession(ctx, session)
+	if err != nil {
+		return nil, nil, fmt.Errorf("server not connected: %s: %w", serverName, err)
+	}
+	return s, func() { s.Close() }, nil
+}
+
+// chassisPowerOn reads the chassis power state over a command-only session.
+func (m *Manager) chassisPowerOn(ctx context.Context, session *Session) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, chassisProbeTimeout)
+	defer cancel()
+	s, err := m.openCommandSession(ctx, session)
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+	status, err := s.GetChassisStatus(ctx)
+	if err != nil {
+		return false, err
+	}
+	return status.PowerOn, nil
+}
+
+// poweredOff reports whether standby is enabled and the chassis reports
+// power off. Errors (BMC unreachable, bad credentials) count as not off, so
+// the caller keeps retrying SOL and reporting the real failure.
+func (m *Manager) poweredOff(ctx context.Context, session *Session) bool {
+	m.mu.RLock()
+	interval := m.chassisPoll
+	m.mu.RUnlock()
+	if interval <= 0 {
+		return false
+	}
+	on, err := m.chassisPowerOn(ctx, session)
+	if err != nil {
+		log.Debugf("Chassis status for %s: %v", session.ServerName, err)
+		return false
+	}
+	return !on
+}
+
+// standby parks a powered-off server's session: rather than retrying SOL,
+// the chassis is polled every chassis_poll_interval until it reports power
+// on, or a power command wakes it. It returns false if ctx ended first.
+func (m *Manager) standby(ctx context.Context, session *Session) bool {
+	select {
+	case <-session.wake: // stale wake from before standby
+	default:
+	}
+	session.Standby = true
+	session.setState(SessionStandby)
+	defer func() { session.Standby = false }()
+	log.Infof("%s is powered off, SOL on standby until power-on", session.ServerName)
+	m.publishState(session.ServerName, StateStandby, "", "chassis powered off")
+
+	reason := "power on"
+	for {
+		m.mu.RLock()
+		interval := m.chassisPoll
+		m.mu.RUnlock()
+		if interval <= 0 {
+			reason = "standby disabled"
+			break
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-session.wake:
+			timer.Stop()
+			reason = "power command"
+		case <-timer.C:
+			on, err := m.chassisPowerOn(ctx, session)
+			if err != nil {
+				log.Debugf("Chassis status for %s: %v", session.ServerName, err)
+				continue
+			}
+			if !on {
+				continue
+			}
+		}
+		break
+	}
+
+	log.Infof("%s leaving standby (%s), connecting SOL", session.ServerName, reason)
+	m.publishState(session.ServerName, StateStandbyEnd, "", reason)
+	return true
+}
+
+// wake ends a server's standby at once, e.g. after a power-on command.
+func (m *Manager) wake(serverName string) {
+	m.mu.RLock()
+	session, exists := m.sessions[serverName]
+	m.mu.RUnlock()
+	if !exists || !session.Standby {
+		return
+	}
+	select {
+	case session.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,125 @@
+package sol
+
+import (
+	"time"
+)
+
+// SessionState is where a server's SOL session is in its lifecycle.
+type SessionState string
+
+const (
+	SessionDiscovering SessionState = "discovering" // known, but no connect attempted yet
+	SessionQueued      SessionState = "queued"      // waiting for a connect slot (ipmi.max_connecting)
+	SessionConnecting  SessionState = "connecting"  // opening and authenticating the RMCP+ session
+	SessionHandshaking SessionState = "handshaking" // authenticated; setting privilege, SOL config and activating
+	SessionActive      SessionState = "active"      // SOL is up
+	SessionDegraded    SessionState = "degraded"    // SOL is up but the link is losing packets
+	SessionBackoff     SessionState = "backoff"     // waiting to retry after a failed or lost connection
+	SessionStandby     SessionState = "standby"     // powered off; waiting for power-on instead of retrying
+	SessionStopped     SessionState = "stopped"     // no session runs for the server
+)
+
+// SessionStatus is a session's state and connect history. A session that
+// has never connected has no LastConnected, which tells "retrying" apart
+// from "never worked".
+type SessionStatus struct {
+	State         SessionState `json:"state"`
+	Since         *time.Time   `json:"stateSince,omitempty"`    // when State was entered
+	Attempts      int          `json:"attempts"`                // connect attempts since the last success
+	TotalAttempts int          `json:"totalAttempts"`           // connect attempts over the session's life
+	Connects      int          `json:"connects"`                // successful connects over the session's life
+	LastAttempt   *time.Time   `json:"lastAttempt,omitempty"`   // start of the latest connect attempt
+	LastConnected *time.Time   `json:"lastConnected,omitempty"` // latest successful connect
+	NextRetry     *time.Time   `json:"nextRetry,omitempty"`     // in backoff: when the next attempt starts
+	QueuePosition int          `json:"queuePosition,omitempty"` // queued: place in the connect queue, from 1
+}
+
+// Status returns a copy of the session's state and connect history.
+func (s *Session) Status() SessionStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	st := s.status
+	if st.State == "" {
+		st.State = SessionDiscovering
+	}
+	return st
+}
+
+// setState moves the session to state, keeping Since when it is already
+// there.
+func (s *Session) setState(state SessionState) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.setStateLocked(state)
+}
+
+func (s *Session) setStateLocked(state SessionState) {
+	if s.status.State != state {
+		now := time.Now()
+		s.status.State = state
+		s.status.Since = &now
+	}
+	if state != SessionBackoff {
+		s.status.NextRetry = nil
+	}
+}
+
+// attempting records the start of a connect attempt.
+func (s *Session) attempting() {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	now := time.Now()
+	s.status.Attempts++
+	s.status.TotalAttempts++
+	s.status.LastAttempt = &now
+	s.setStateLocked(SessionConnecting)
+}
+
+// connected records a successful connect.
+func (s *Session) connected() {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	now := time.Now()
+	s.status.Attempts = 0
+	s.status.Connects++
+	s.status.LastConnected = &now
+	s.setStateLocked(SessionActive)
+}
+
+// backingOff records the wait before the next connect attempt.
+func (s *Session) backingOff(wait time.Duration) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.setStateLocked(SessionBackoff)
+	next := time.Now().Add(wait)
+	s.status.NextRetry = &next
+}
+
+// setDegraded moves a connected session between active and degraded.
+func (s *Session) setDegraded(degraded bool) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	switch {
+	case degraded && s.status.State == SessionActive:
+		s.setStateLocked(SessionDegraded)
+	case !degraded && s.status.State == SessionDegraded:
+		s.setStateLocked(SessionActive)
+	}
+}
+
+// inherit carries the connect history of the session this one replaces,
+// so a restart doesn't make a server look like it never connected.
+func (s *Session) inherit(prev *Session) {
+	if prev == nil || prev == s {
+		return
+	}
+	st := prev.Status()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status.Attempts += st.Attempts
+	s.status.TotalAttempts += st.TotalAttempts
+	s.status.Connects += st.Connects
+	if s.status.LastConnected == nil {
+		s.status.LastConnected = st.LastConnected
+	}
+}
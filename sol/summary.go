@@ -0,0 +1,153 @@
+package sol
+
+import (
+	"sort"
+	"time"
+)
+
+// pxeLoopMin is how many boots in a row must stall in PXE before a server
+// counts as stuck in a PXE loop.
+const pxeLoopMin = 3
+
+// BootSummary is boot success and duration statistics for a set of boots.
+type BootSummary struct {
+	Boots         int        `json:"boots"`     // finished boots that started in the window
+	Succeeded     int        `json:"succeeded"` // reached the OS
+	Failed        int        `json:"failed"`    // restarted before reaching the OS
+	SuccessRate   float64    `json:"successRate"`
+	BootDuration  DelayStats `json:"bootDuration"`  // seconds, successful boots
+	RebootsPerDay float64    `json:"rebootsPerDay"` // boots started per day over the window
+}
+
+// PXELoop is a server whose recent boots keep stalling in PXE.
+type PXELoop struct {
+	Server string    `json:"server"`
+	Boots  int       `json:"boots"` // consecutive boots that got no further than PXE
+	Since  time.Time `json:"since"` // start of the first of them
+}
+
+// FleetSummary aggregates boot history across servers for fleet health
+// reporting.
+type FleetSummary struct {
+	Window   string                 `json:"window"`
+	Servers  int                    `json:"servers"` // servers with boots in the window
+	Fleet    BootSummary            `json:"fleet"`
+	Booting  []string               `json:"booting"`  // servers with a boot in progress
+	PXELoops []PXELoop              `json:"pxeLoops"` // servers stuck in PXE loops
+	ByServer map[string]BootSummary `json:"byServer"`
+}
+
+// GetFleetSummary summarizes boots that started within window across the
+// servers include accepts (all when nil).
+func (m *Manager) GetFleetSummary(window time.Duration, include func(name string) bool) *FleetSummary {
+	return m.analytics.GetFleetSummary(window, include)
+}
+
+// GetFleetSummary summarizes boot history: success rate, boot duration
+// percentiles and reboot frequency per server and fleet-wide, which servers
+// are booting, and which are stuck in PXE loops. A boot fails when the next
+// one starts before it reached the OS; a boot still in progress counts only
+// towards reboot frequency.
+func (a *Analytics) GetFleetSummary(window time.Duration, include func(name string) bool) *FleetSummary {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	now := time.Now()
+	since := now.Add(-window)
+	days := window.Hours() / 24
+
+	summary := &FleetSummary{
+		Window:   window.String(),
+		Booting:  []string{},
+		PXELoops: []PXELoop{},
+		ByServer: make(map[string]BootSummary),
+	}
+	var fleetDurations []float64
+	for name, server := range a.servers {
+		if include != nil && !include(name) {
+			continue
+		}
+		var s BootSummary
+		var durations []float64
+		started := 0
+		for _, b := range server.BootHistory {
+			if b.StartTime.Before(since) {
+				continue
+			}
+			started++
+			s.Boots++
+			if b.Complete {
+				s.Succeeded++
+				if b.BootDuration > 0 {
+					durations = append(durations, b.BootDuration)
+				}
+			} else {
+				s.Failed++
+			}
+		}
+		if cur := server.CurrentBoot; cur != nil && !cur.StartTime.Before(since) {
+			started++
+			if cur.Complete {
+				s.Boots++
+				s.Succeeded++
+				if cur.BootDuration > 0 {
+					durations = append(durations, cur.BootDuration)
+				}
+			}
+		}
+		if server.CurrentBoot != nil && !server.CurrentBoot.Complete {
+			summary.Booting = append(summary.Booting, name)
+		}
+		if loop, ok := pxeLoop(server); ok {
+			loop.Server = name
+			summary.PXELoops = append(summary.PXELoops, loop)
+		}
+		if started == 0 {
+			continue
+		}
+
+		s.BootDuration = computeDelayStats(durations)
+		s.SuccessRate = successRate(s.Succeeded, s.Boots)
+		if days > 0 {
+			s.RebootsPerDay = float64(started) / days
+		}
+		summary.ByServer[name] = s
+		summary.Servers++
+		summary.Fleet.Boots += s.Boots
+		summary.Fleet.Succeeded += s.Succeeded
+		summary.Fleet.Failed += s.Failed
+		summary.Fleet.RebootsPerDay += s.RebootsPerDay
+		fleetDurations = append(fleetDurations, durations...)
+	}
+	summary.Fleet.SuccessRate = successRate(summary.Fleet.Succeeded, summary.Fleet.Boots)
+	summary.Fleet.BootDuration = computeDelayStats(fleetDurations)
+	sort.Strings(summary.Booting)
+	sort.Slice(summary.PXELoops, func(i, j int) bool { return summary.PXELoops[i].Server < summary.PXELoops[j].Server })
+	return summary
+}
+
+func successRate(succeeded, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(succeeded) / float64(total)
+}
+
+// pxeLoop reports whether a server's latest boots, the one in progress
+// included, all stalled in PXE without reaching the bootloader.
+func pxeLoop(server *ServerAnalytics) (PXELoop, bool) {
+	boots := server.BootHistory
+	if server.CurrentBoot != nil {
+		boots = append(boots[:len(boots):len(boots)], *server.CurrentBoot)
+	}
+	var loop PXELoop
+	for i := len(boots) - 1; i >= 0; i-- {
+		b := boots[i]
+		if b.Complete || len(b.Stages) == 0 || b.Stages[len(b.Stages)-1].Name != StagePXE {
+			break
+		}
+		loop.Boots++
+		loop.Since = b.StartTime
+	}
+	return loop, loop.Boots >= pxeLoopMin
+}
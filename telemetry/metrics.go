@@ -0,0 +1,159 @@
+package telemetry
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket bounds, in seconds
+var (
+	requestBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	writeBounds   = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5}
+)
+
+// Exported histograms
+var (
+	// SOLConnectDuration times SOL session setup, per phase ("total" for
+	// the whole connect), with the server name and result.
+	SOLConnectDuration = newHistogram("ipmiserial.sol.connect.duration",
+		"Duration of SOL session setup phases", requestBounds)
+	// HTTPServerDuration times API requests by route, method and status.
+	HTTPServerDuration = newHistogram("ipmiserial.http.server.duration",
+		"Duration of HTTP API requests", requestBounds)
+	// LogWriteDuration times console log writes per server.
+	LogWriteDuration = newHistogram("ipmiserial.log.write.duration",
+		"Duration of console log writes", writeBounds)
+)
+
+var registry []*Histogram
+
+// Histogram is a cumulative latency histogram, one series per attribute
+// set. Observations are dropped while telemetry is off.
+type Histogram struct {
+	name        string
+	description string
+	bounds      []float64
+
+	mu     sync.Mutex
+	points map[string]*histPoint
+}
+
+type histPoint struct {
+	attrs  []Attr
+	count  uint64
+	sum    float64
+	counts []uint64 // len(bounds)+1
+}
+
+func newHistogram(name, description string, bounds []float64) *Histogram {
+	h := &Histogram{name: name, description: description, bounds: bounds, points: make(map[string]*histPoint)}
+	registry = append(registry, h)
+	return h
+}
+
+// Observe records the time since start.
+func (h *Histogram) Observe(start time.Time, attrs ...Attr) {
+	h.ObserveDuration(time.Since(start), attrs...)
+}
+
+// ObserveDuration records d.
+func (h *Histogram) ObserveDuration(d time.Duration, attrs ...Attr) {
+	if active.Load() == nil {
+		return
+	}
+	v := d.Seconds()
+	key := seriesKey(attrs)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p := h.points[key]
+	if p == nil {
+		p = &histPoint{attrs: attrs, counts: make([]uint64, len(h.bounds)+1)}
+		h.points[key] = p
+	}
+	p.count++
+	p.sum += v
+	p.counts[sort.SearchFloat64s(h.bounds, v)]++
+}
+
+// seriesKey identifies an attribute set regardless of order.
+func seriesKey(attrs []Attr) string {
+	parts := make([]string, len(attrs))
+	for i, a := range attrs {
+		var v string
+		switch {
+		case a.value.StringValue != nil:
+			v = *a.value.StringValue
+		case a.value.IntValue != nil:
+			v = *a.value.IntValue
+		case a.value.BoolValue != nil:
+			v = strconv.FormatBool(*a.value.BoolValue)
+		}
+		parts[i] = a.Key + "=" + v
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\x00")
+}
+
+type otlpMetric struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Unit        string        `json:"unit"`
+	Histogram   otlpHistogram `json:"histogram"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"` // 2 = cumulative
+}
+
+type otlpHistPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+}
+
+// snapshotMetrics returns every histogram with observations, cumulative
+// since start.
+func snapshotMetrics(start time.Time) []otlpMetric {
+	now := nanos(time.Now())
+	var out []otlpMetric
+	for _, h := range registry {
+		h.mu.Lock()
+		if len(h.points) == 0 {
+			h.mu.Unlock()
+			continue
+		}
+		m := otlpMetric{
+			Name:        h.name,
+			Description: h.description,
+			Unit:        "s",
+			Histogram:   otlpHistogram{AggregationTemporality: 2},
+		}
+		for _, p := range h.points {
+			counts := make([]string, len(p.counts))
+			for i, c := range p.counts {
+				counts[i] = strconv.FormatUint(c, 10)
+			}
+			m.Histogram.DataPoints = append(m.Histogram.DataPoints, otlpHistPoint{
+				Attributes:        attrs(p.attrs),
+				StartTimeUnixNano: nanos(start),
+				TimeUnixNano:      now,
+				Count:             strconv.FormatUint(p.count, 10),
+				Sum:               p.sum,
+				BucketCounts:      counts,
+				ExplicitBounds:    h.bounds,
+			})
+		}
+		h.mu.Unlock()
+		out = append(out, m)
+	}
+	return out
+}
@@ -0,0 +1,243 @@
+// Package telemetry exports traces and metrics to an OpenTelemetry
+// collector over OTLP/HTTP (JSON encoding). Until Run is called, spans and
+// measurements are no-ops, so instrumented code needs no checks.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+// Export limits
+const (
+	maxQueuedSpans = 4096 // finished spans held between exports; more are dropped
+	exportBatch    = 512  // spans per export request
+)
+
+// exporter batches finished spans and pushes them, with the current metric
+// values, to the collector.
+type exporter struct {
+	endpoint string
+	headers  map[string]string
+	resource resource
+	interval time.Duration
+	client   *http.Client
+	started  time.Time
+
+	mu      sync.Mutex
+	spans   []otlpSpan
+	dropped int
+	flushCh chan struct{}
+}
+
+// active is the running exporter, nil when telemetry is off.
+var active atomic.Pointer[exporter]
+
+// Enabled reports whether telemetry is being exported.
+func Enabled() bool {
+	return active.Load() != nil
+}
+
+// Run enables telemetry for cfg and exports until ctx is cancelled, then
+// flushes what is left. It returns once exporting has stopped. A config
+// without an endpoint leaves telemetry off.
+func Run(ctx context.Context, cfg config.TelemetryConfig, version string) {
+	if cfg.Endpoint == "" {
+		return
+	}
+	e := &exporter{
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		headers:  cfg.Headers,
+		interval: cfg.ExportInterval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		started:  time.Now(),
+		flushCh:  make(chan struct{}, 1),
+	}
+	if e.interval <= 0 {
+		e.interval = 10 * time.Second
+	}
+	name := cfg.ServiceName
+	if name == "" {
+		name = "ipmiserial"
+	}
+	e.resource = resource{Attributes: attrs([]Attr{
+		String("service.name", name),
+		String("service.version", version),
+	})}
+	active.Store(e)
+	defer active.Store(nil)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			e.export(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		case <-e.flushCh:
+		}
+		e.export(ctx)
+	}
+}
+
+// enqueue queues a finished span for export.
+func (e *exporter) enqueue(s otlpSpan) {
+	e.mu.Lock()
+	if len(e.spans) >= maxQueuedSpans {
+		e.dropped++
+		e.mu.Unlock()
+		return
+	}
+	e.spans = append(e.spans, s)
+	full := len(e.spans) >= exportBatch
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// export sends queued spans and a snapshot of every metric.
+func (e *exporter) export(ctx context.Context) {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		log.Warnf("Telemetry: span queue full, dropped %d spans", dropped)
+	}
+
+	scope := scope{Name: "ipmiserial"}
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > exportBatch {
+			n = exportBatch
+		}
+		body := map[string]interface{}{
+			"resourceSpans": []interface{}{map[string]interface{}{
+				"resource":   e.resource,
+				"scopeSpans": []interface{}{map[string]interface{}{"scope": scope, "spans": spans[:n]}},
+			}},
+		}
+		if err := e.post(ctx, "/v1/traces", body); err != nil {
+			log.Warnf("Telemetry: export %d spans: %v", n, err)
+		}
+		spans = spans[n:]
+	}
+
+	metrics := snapshotMetrics(e.started)
+	if len(metrics) == 0 {
+		return
+	}
+	body := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     e.resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": scope, "metrics": metrics}},
+		}},
+	}
+	if err := e.post(ctx, "/v1/metrics", body); err != nil {
+		log.Warnf("Telemetry: export metrics: %v", err)
+	}
+}
+
+func (e *exporter) post(ctx context.Context, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// OTLP JSON types shared by traces and metrics
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 as a JSON string
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Attr is a span or metric attribute.
+type Attr struct {
+	Key   string
+	value anyValue
+}
+
+// String returns a string attribute.
+func String(key, v string) Attr {
+	return Attr{Key: key, value: anyValue{StringValue: &v}}
+}
+
+// Int returns an integer attribute.
+func Int(key string, v int) Attr {
+	s := strconv.Itoa(v)
+	return Attr{Key: key, value: anyValue{IntValue: &s}}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, v bool) Attr {
+	return Attr{Key: key, value: anyValue{BoolValue: &v}}
+}
+
+func attrs(in []Attr) []keyValue {
+	out := make([]keyValue, len(in))
+	for i, a := range in {
+		out[i] = keyValue{Key: a.Key, Value: a.value}
+	}
+	return out
+}
+
+// nanos formats a time as OTLP's fixed64 nanoseconds, a JSON string.
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
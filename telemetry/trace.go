@@ -0,0 +1,187 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLP span kinds
+const (
+	kindInternal = 1
+	kindServer   = 2
+)
+
+// OTLP status codes
+const (
+	statusOK    = 1
+	statusError = 2
+)
+
+// Span is an operation being timed. Methods on a nil Span do nothing, which
+// is what Start returns while telemetry is off.
+type Span struct {
+	exp      *exporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs []Attr
+	err   error
+	ended bool
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type spanKey struct{}
+
+// Start begins a span named name, a child of the span in ctx if there is
+// one. The returned context carries the new span.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	e := active.Load()
+	if e == nil {
+		return ctx, nil
+	}
+	s := newSpan(e, name, kindInternal, time.Now(), attrs)
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// StartServer begins a span for an inbound request. traceparent is the
+// request's W3C Trace Context header; when valid, the span joins the
+// caller's trace.
+func StartServer(ctx context.Context, name, traceparent string, attrs ...Attr) (context.Context, *Span) {
+	e := active.Load()
+	if e == nil {
+		return ctx, nil
+	}
+	s := newSpan(e, name, kindServer, time.Now(), attrs)
+	if traceID, parentID, ok := parseTraceparent(traceparent); ok {
+		s.traceID = traceID
+		s.parentID = parentID
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Record exports an already finished operation as a child of the span in
+// ctx, e.g. a phase timed by a library callback.
+func Record(ctx context.Context, name string, start, end time.Time, err error, attrs ...Attr) {
+	e := active.Load()
+	if e == nil {
+		return
+	}
+	s := newSpan(e, name, kindInternal, start, attrs)
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	}
+	s.err = err
+	s.finish(end)
+}
+
+func newSpan(e *exporter, name string, kind int, start time.Time, attrs []Attr) *Span {
+	s := &Span{exp: e, name: name, kind: kind, start: start, attrs: attrs}
+	rand.Read(s.traceID[:])
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// SetAttr adds attributes to the span.
+func (s *Span) SetAttr(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed with err. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Only the first call
+// counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.finish(time.Now())
+}
+
+func (s *Span) finish(end time.Time) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: nanos(s.start),
+		EndTimeUnixNano:   nanos(end),
+		Attributes:        attrs(s.attrs),
+		Status:            otlpStatus{Code: statusOK},
+	}
+	if s.parentID != ([8]byte{}) {
+		out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != nil {
+		out.Status = otlpStatus{Code: statusError, Message: s.err.Error()}
+	}
+	s.mu.Unlock()
+	s.exp.enqueue(out)
+}
+
+// parseTraceparent reads a W3C traceparent header:
+// version-traceid-parentid-flags, e.g. 00-<32 hex>-<16 hex>-01.
+func parseTraceparent(h string) (traceID [16]byte, parentID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return traceID, parentID, false
+	}
+	if traceID == ([16]byte{}) || parentID == ([8]byte{}) {
+		return traceID, parentID, false
+	}
+	return traceID, parentID, true
+}
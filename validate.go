@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+
+	"ipmiserial/bmcproxy"
+	"ipmiserial/config"
+	"ipmiserial/discovery"
+	"ipmiserial/events"
+	"ipmiserial/logs"
+	"ipmiserial/playbooks"
+	"ipmiserial/sol"
+)
+
+// configProblem is one mistake found in config.yaml, located by its YAML
+// path (e.g. servers[2].macs[0]).
+type configProblem struct {
+	Field   string
+	Message string
+}
+
+func (p configProblem) String() string {
+	if p.Field == "" {
+		return p.Message
+	}
+	return p.Field + ": " + p.Message
+}
+
+// configChecker collects problems.
+type configChecker struct {
+	problems []configProblem
+}
+
+func (c *configChecker) add(field, format string, args ...interface{}) {
+	c.problems = append(c.problems, configProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *configChecker) regexp(field, expr string) {
+	if _, err := regexp.Compile(expr); err != nil {
+		c.add(field, "invalid regexp: %v", err)
+	}
+}
+
+func (c *configChecker) port(field string, port int) {
+	if port < 0 || port > 65535 {
+		c.add(field, "port %d out of range", port)
+	}
+}
+
+// backoff checks reconnect backoff settings.
+func (c *configChecker) backoff(field string, b config.BackoffConfig) {
+	if b.Min < 0 || b.Max < 0 || b.Reset < 0 {
+		c.add(field, "durations must not be negative")
+	}
+	if b.Min > 0 && b.Max > 0 && b.Max < b.Min {
+		c.add(field+".max", "%v is less than min %v", b.Max, b.Min)
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		c.add(field+".jitter", "%v: want 0-1", b.Jitter)
+	}
+}
+
+// scrollback checks a console scrollback size. Every server holds one in
+// memory, so sizes past a gigabyte are refused as typos.
+func (c *configChecker) scrollback(field string, mb float64) {
+	if mb < 0 || mb > 1024 {
+		c.add(field, "%g MB: want 0-1024", mb)
+	}
+}
+
+// serverEntries checks the label selectors ("label:<selector>") in a
+// credential's servers list.
+func (c *configChecker) serverEntries(field string, servers []string) {
+	for j, entry := range servers {
+		if selector, ok := strings.CutPrefix(entry, "label:"); ok {
+			if _, err := discovery.ParseSelector(selector); err != nil {
+				c.add(fmt.Sprintf("%s[%d]", field, j), "%v", err)
+			}
+		}
+	}
+}
+
+// validateConfig checks the config loaded from path for mistakes that would
+// otherwise only surface at runtime, deep inside a subsystem: missing or
+// duplicate names, unknown keys, malformed MACs, keys and patterns, dangling
+// references and an unusable log path.
+func validateConfig(path string, cfg *config.Config) []configProblem {
+	c := &configChecker{}
+	c.unknownKeys(path)
+
+	names := make(map[string]int)
+	usesIPMITool := false // ipmitool must then be installed
+	for i, s := range cfg.Servers {
+		field := fmt.Sprintf("servers[%d]", i)
+		if s.Name == "" {
+			c.add(field+".name", "required")
+		} else if prev, dup := names[s.Name]; dup {
+			c.add(field+".name", "duplicate server name %q (also servers[%d])", s.Name, prev)
+		} else {
+			names[s.Name] = i
+		}
+		if s.Host == "" {
+			c.add(field+".host", "required")
+		}
+		for j, mac := range s.MACs {
+			if _, err := net.ParseMAC(mac); err != nil {
+				c.add(fmt.Sprintf("%s.macs[%d]", field, j), "malformed MAC address %q", mac)
+			}
+		}
+		if _, err := sol.ParseKg(s.Kg); err != nil {
+			c.add(field+".kg", "%v", err)
+		}
+		c.port(field+".port", s.Port)
+		if s.RetentionDays < 0 {
+			c.add(field+".retention_days", "must not be negative")
+		}
+		c.scrollback(field+".scrollback_mb", s.ScrollbackMB)
+		if s.Backoff != nil {
+			c.backoff(field+".backoff", *s.Backoff)
+		}
+		if s.Serial != nil {
+			if _, err := sol.ParseSerialConfig(s.Serial.Mux, s.Serial.Channel, s.Serial.UARTCommand); err != nil {
+				c.add(field+".serial", "%v", err)
+			}
+		}
+		if s.Proxy != nil {
+			if _, err := bmcproxy.New(*s.Proxy); err != nil {
+				c.add(field+".proxy", "%v", err)
+			}
+		}
+		if t, err := sol.ParseTransport(s.Transport); err != nil {
+			c.add(field+".transport", "%v", err)
+		} else if t == sol.TransportIPMITool {
+			usesIPMITool = true
+		}
+		if s.Quirks != nil {
+			if err := quirksSetting(*s.Quirks).Validate(); err != nil {
+				c.add(field+".quirks", "%v", err)
+			}
+		}
+		if s.Connection != nil {
+			// checked as inherited: a keepalive must be shorter than the
+			// inactivity timeout, wherever either is set
+			conn := connectionSetting(cfg.IPMI.Connection).Merge(connectionSetting(*s.Connection))
+			if err := conn.Validate(); err != nil {
+				c.add(field+".connection", "%v", err)
+			}
+		}
+		for k := range s.Labels {
+			if k == "" || strings.ContainsAny(k, "=!,") {
+				c.add(field+".labels", "invalid label key %q", k)
+			}
+		}
+	}
+	if _, err := sol.ParseKg(cfg.IPMI.Kg); err != nil {
+		c.add("ipmi.kg", "%v", err)
+	}
+	if err := solConfig(cfg.IPMI.SOLConfig).Validate(); err != nil {
+		c.add("ipmi.sol_config", "%v", err)
+	}
+	c.backoff("ipmi.backoff", cfg.IPMI.Backoff)
+	if cfg.IPMI.AttemptsPerMinute < 0 {
+		c.add("ipmi.attempts_per_minute", "must not be negative")
+	}
+	if cfg.IPMI.MaxConnecting < 0 {
+		c.add("ipmi.max_connecting", "must not be negative")
+	}
+	if cfg.IPMI.ConnectHistory < 0 {
+		c.add("ipmi.connect_history", "must not be negative")
+	}
+	if _, err := sol.NewSourcePorts(cfg.IPMI.SourceAddress, cfg.IPMI.SourcePorts, cfg.IPMI.DSCP); err != nil {
+		c.add("ipmi.source_ports", "%v", err)
+	}
+	if _, err := bmcproxy.New(cfg.IPMI.Proxy); err != nil {
+		c.add("ipmi.proxy", "%v", err)
+	}
+	if _, err := sol.ParseSerialConfig(cfg.IPMI.Serial.Mux, cfg.IPMI.Serial.Channel, cfg.IPMI.Serial.UARTCommand); err != nil {
+		c.add("ipmi.serial", "%v", err)
+	}
+	if err := quirksSetting(cfg.IPMI.Quirks).Validate(); err != nil {
+		c.add("ipmi.quirks", "%v", err)
+	}
+	if err := connectionSetting(cfg.IPMI.Connection).Validate(); err != nil {
+		c.add("ipmi.connection", "%v", err)
+	}
+	if t, err := sol.ParseTransport(cfg.IPMI.Transport); err != nil {
+		c.add("ipmi.transport", "%v", err)
+	} else if t == sol.TransportIPMITool {
+		usesIPMITool = true
+	}
+	if tool := cfg.IPMI.IPMITool; usesIPMITool {
+		path := tool.Path
+		if path == "" {
+			path = "ipmitool"
+		}
+		if _, err := exec.LookPath(path); err != nil {
+			c.add("ipmi.ipmitool.path", "%v", err)
+		}
+	}
+	if cs := cfg.IPMI.IPMITool.CipherSuite; cs < 0 || cs > 17 {
+		c.add("ipmi.ipmitool.cipher_suite", "%d: want 0-17", cs)
+	}
+	if v := cfg.Vault; v.Address != "" {
+		if v.Token == "" && (v.RoleID == "" || v.SecretID == "") {
+			c.add("vault", "token or role_id and secret_id are required")
+		}
+		if v.CacheTTL < 0 {
+			c.add("vault.cache_ttl", "must not be negative")
+		}
+	}
+
+	for i, src := range cfg.Discovery.AllSources() {
+		field := "discovery"
+		if len(cfg.Discovery.Sources) > 0 {
+			field = fmt.Sprintf("discovery.sources[%d]", i)
+		}
+		switch src.Mode {
+		case "", "mkube":
+			if src.BMHURL == "" {
+				c.add(field+".bmh_url", "required in mkube mode")
+			}
+		case "kubernetes":
+		default:
+			c.add(field+".mode", "unknown mode %q (mkube or kubernetes)", src.Mode)
+		}
+	}
+
+	for i, p := range cfg.RebootDetection.SOLPatterns {
+		c.regexp(fmt.Sprintf("reboot_detection.sol_patterns[%d]", i), p)
+	}
+
+	c.logPath(cfg.Logs.Path)
+	if cfg.Logs.RetentionDays < 0 {
+		c.add("logs.retention_days", "must not be negative")
+	}
+	if cfg.Logs.MaxTotalSizeGB < 0 {
+		c.add("logs.max_total_size_gb", "must not be negative")
+	}
+	c.scrollback("logs.scrollback_mb", cfg.Logs.ScrollbackMB)
+	if cfg.Server.Streams.MaxPerServer < 0 {
+		c.add("server.streams.max_per_server", "must not be negative")
+	}
+	if cfg.Server.Streams.MaxTotal < 0 {
+		c.add("server.streams.max_total", "must not be negative")
+	}
+	if cfg.Server.Streams.EvictAfter < 0 {
+		c.add("server.streams.evict_after", "must not be negative")
+	}
+	switch cfg.Server.AccessLog.Format {
+	case "", "text", "clf", "json":
+	default:
+		c.add("server.access_log.format", "unknown format %q (text, clf or json)", cfg.Server.AccessLog.Format)
+	}
+	for i, prefix := range cfg.Server.AccessLog.Exclude {
+		if !strings.HasPrefix(prefix, "/") {
+			c.add(fmt.Sprintf("server.access_log.exclude[%d]", i), "%q must start with /", prefix)
+		}
+	}
+	switch cfg.Logs.Daemon.Format {
+	case "", "text", "json":
+	default:
+		c.add("logs.daemon.format", "unknown format %q (text or json)", cfg.Logs.Daemon.Format)
+	}
+	if cfg.Logs.Daemon.Level != "" {
+		if _, err := log.ParseLevel(cfg.Logs.Daemon.Level); err != nil {
+			c.add("logs.daemon.level", "%v", err)
+		}
+	}
+
+	if n := cfg.Logs.NATS; n.URL != "" {
+		if u, err := url.Parse(n.URL); err != nil || u.Host == "" || (u.Scheme != "nats" && u.Scheme != "tls") {
+			c.add("logs.nats.url", "want nats://host:port or tls://host:port")
+		}
+		outputs := map[string]bool{logs.NATSCleaned: true, logs.NATSRaw: true, logs.NATSBoth: true, logs.NATSNone: true}
+		if !outputs[n.Output] {
+			c.add("logs.nats.output", "unknown output %q (cleaned, raw, both or none)", n.Output)
+		}
+		for name, out := range n.Servers {
+			if !outputs[out] {
+				c.add("logs.nats.servers."+name, "unknown output %q (cleaned, raw, both or none)", out)
+			}
+		}
+	}
+
+	c.port("server.port", cfg.Server.Port)
+	c.port("server.grpc_port", cfg.Server.GRPCPort)
+	c.port("ssh.port", cfg.SSH.Port)
+	c.port("conserver.port", cfg.Conserver.Port)
+	for i, t := range cfg.Auth.Tokens {
+		c.serverEntries(fmt.Sprintf("auth.tokens[%d].servers", i), t.Servers)
+	}
+	for i, u := range cfg.Auth.Users {
+		c.serverEntries(fmt.Sprintf("auth.users[%d].servers", i), u.Servers)
+		switch {
+		case u.PasswordBcrypt != "":
+			if _, err := bcrypt.Cost([]byte(u.PasswordBcrypt)); err != nil {
+				c.add(fmt.Sprintf("auth.users[%d].password_bcrypt", i), "not a bcrypt hash: %v", err)
+			}
+		case len(u.Password) > 72:
+			c.add(fmt.Sprintf("auth.users[%d].password", i), "longer than bcrypt's 72 bytes")
+		case u.Password == "":
+			c.add(fmt.Sprintf("auth.users[%d]", i), "password or password_bcrypt is required")
+		}
+	}
+	switch cfg.Server.Catchup {
+	case "", "auto", "screen", "log", "none":
+	default:
+		c.add("server.catchup", "unknown mode %q (auto, screen, log or none)", cfg.Server.Catchup)
+	}
+	if m := cfg.MQTT; m.Broker != "" {
+		if u, err := url.Parse(m.Broker); err != nil || u.Host == "" {
+			c.add("mqtt.broker", "want tcp://host:port or tls://host:port")
+		} else {
+			switch u.Scheme {
+			case "tcp", "mqtt", "tls", "ssl", "mqtts":
+			default:
+				c.add("mqtt.broker", "unsupported scheme %q (tcp or tls)", u.Scheme)
+			}
+		}
+		if m.QoS < 0 || m.QoS > 1 {
+			c.add("mqtt.qos", "must be 0 or 1")
+		}
+	}
+	if cfg.Power.History < 0 {
+		c.add("power.history", "must not be negative")
+	}
+	switch cfg.ConsoleProxy.Security {
+	case "", "integrity", "encryption", "none":
+	default:
+		c.add("console_proxy.security", "unknown security %q: want integrity, encryption or none", cfg.ConsoleProxy.Security)
+	}
+	for i, ro := range cfg.Conserver.ReadOnly {
+		if _, ok := cfg.Conserver.Users[ro]; !ok && len(cfg.Conserver.Users) > 0 {
+			c.add(fmt.Sprintf("conserver.read_only[%d]", i), "unknown user %q", ro)
+		}
+	}
+
+	pbNames := make(map[string]bool)
+	for i, pb := range cfg.Playbooks {
+		field := fmt.Sprintf("playbooks[%d]", i)
+		if err := playbooks.Validate(pb); err != nil {
+			c.add(field, "%v", err)
+		}
+		if pbNames[pb.Name] {
+			c.add(field+".name", "duplicate playbook name %q", pb.Name)
+		}
+		pbNames[pb.Name] = true
+	}
+
+	notifiers := make(map[string]bool)
+	for i, n := range cfg.Alerts.Notifiers {
+		field := fmt.Sprintf("alerts.notifiers[%d]", i)
+		if n.Name == "" {
+			c.add(field+".name", "required")
+		} else if notifiers[n.Name] {
+			c.add(field+".name", "duplicate notifier name %q", n.Name)
+		}
+		notifiers[n.Name] = true
+		switch n.Type {
+		case "webhook", "slack":
+			if n.URL == "" {
+				c.add(field+".url", "required for %s notifiers", n.Type)
+			}
+		case "email":
+			if n.SMTPHost == "" || n.From == "" || len(n.To) == 0 {
+				c.add(field, "smtp_host, from and to are required for email notifiers")
+			} else if _, _, err := net.SplitHostPort(n.SMTPHost); err != nil {
+				c.add(field+".smtp_host", "must be host:port")
+			}
+		default:
+			c.add(field+".type", "unknown type %q (webhook, slack or email)", n.Type)
+		}
+	}
+	eventTypes := make(map[string]bool)
+	for _, t := range events.Types {
+		eventTypes[t] = true
+	}
+	webhooks := make(map[string]bool)
+	for i, h := range cfg.Events.Webhooks {
+		field := fmt.Sprintf("events.webhooks[%d]", i)
+		if h.Name == "" {
+			c.add(field+".name", "required")
+		} else if webhooks[h.Name] {
+			c.add(field+".name", "duplicate webhook name %q", h.Name)
+		}
+		webhooks[h.Name] = true
+		if h.URL == "" {
+			c.add(field+".url", "required")
+		} else if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.add(field+".url", "must be an http or https URL")
+		}
+		for j, t := range h.Events {
+			if !eventTypes[t] {
+				c.add(fmt.Sprintf("%s.events[%d]", field, j), "unknown event type %q", t)
+			}
+		}
+		if h.MaxRetries != nil && *h.MaxRetries < 0 {
+			c.add(field+".max_retries", "must not be negative")
+		}
+	}
+
+	rules := make(map[string]bool)
+	for i, r := range cfg.Alerts.Rules {
+		field := fmt.Sprintf("alerts.rules[%d]", i)
+		if r.Name == "" {
+			c.add(field+".name", "required")
+		} else if rules[r.Name] {
+			c.add(field+".name", "duplicate rule name %q", r.Name)
+		}
+		rules[r.Name] = true
+		if r.Pattern == "" && r.BootTimeout == 0 && !r.RebootLoop && !r.PowerOnDegraded {
+			c.add(field, "needs a pattern, boot_timeout, reboot_loop or power_on_degraded")
+		}
+		if r.Pattern != "" {
+			c.regexp(field+".pattern", r.Pattern)
+		}
+		for j, n := range r.Notify {
+			if !notifiers[n] {
+				c.add(fmt.Sprintf("%s.notify[%d]", field, j), "unknown notifier %q", n)
+			}
+		}
+		if r.Playbook != "" && !pbNames[r.Playbook] {
+			c.add(field+".playbook", "unknown playbook %q", r.Playbook)
+		}
+	}
+	for i, pb := range cfg.Playbooks {
+		for j, st := range pb.Steps {
+			for k, n := range st.Notify {
+				if !notifiers[n] {
+					c.add(fmt.Sprintf("playbooks[%d].steps[%d].notify[%d]", i, j, k), "unknown notifier %q", n)
+				}
+			}
+		}
+	}
+
+	return c.problems
+}
+
+// unknownKeys reports keys config.Load silently ignores, usually typos.
+func (c *configChecker) unknownKeys(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var cfg config.Config
+	err = dec.Decode(&cfg)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		for _, msg := range typeErr.Errors {
+			c.add("", "%s", msg)
+		}
+	} else if err != nil && err != io.EOF {
+		c.add("", "%v", err)
+	}
+}
+
+// logPath checks that the log directory exists and is writable, or can be
+// created.
+func (c *configChecker) logPath(path string) {
+	if path == "" {
+		c.add("logs.path", "required")
+		return
+	}
+	dir := path
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				c.add("logs.path", "%s is not a directory", dir)
+				return
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			c.add("logs.path", "%v", err)
+			return
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".ipmiserial-validate-*")
+	if err != nil {
+		c.add("logs.path", "%s is not writable: %v", dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// runValidate implements -validate: it prints a report of the config's
+// problems and returns the exit status, 1 if there are any.
+func runValidate(path string) int {
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	problems := validateConfig(path, cfg)
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", path)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d problem(s)\n", path, len(problems))
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "  %s\n", p)
+	}
+	return 1
+}
@@ -0,0 +1,388 @@
+// Package vault reads per-server BMC credentials from a HashiCorp Vault KV
+// v2 secrets engine over its HTTP API.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"ipmiserial/config"
+)
+
+const (
+	defaultMount    = "secret"
+	defaultPath     = "ipmiserial"
+	defaultAppRole  = "approle"
+	defaultCacheTTL = 5 * time.Minute
+
+	// refreshInterval is how often Run checks for stale entries and a
+	// token due for renewal, and retries failed reads and logins.
+	refreshInterval = 30 * time.Second
+
+	// readTimeout bounds one Vault request.
+	readTimeout = 10 * time.Second
+)
+
+// Credentials are a server's BMC login as stored in Vault. Empty fields
+// fall back to the discovered or configured value.
+type Credentials struct {
+	Username string
+	Password string
+	Kg       string
+}
+
+// entry is a cached read. found is false for servers Vault has no secret
+// for, so they aren't looked up again until the entry goes stale. failed
+// marks a negative entry for a server whose secret hasn't been read yet or
+// couldn't be: it falls back to the BMH or config credentials, and Run
+// retries it on every refresh.
+type entry struct {
+	creds   Credentials
+	found   bool
+	failed  bool
+	fetched time.Time
+}
+
+// Provider serves credentials from a cache kept fresh by Run. A miss is
+// read from Vault in the background, so lookups never wait on Vault.
+type Provider struct {
+	cfg    config.VaultConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	token         string
+	renewAt       time.Time // zero = the token does not expire
+	authenticated bool      // false until a login or token lookup succeeds
+	cache         map[string]*entry
+	onChange      func()
+}
+
+// New returns a provider for cfg, logging in with AppRole if configured.
+// Only configuration problems are errors: if Vault can't be reached the
+// provider starts anyway, serving no credentials, and Run keeps trying.
+func New(cfg config.VaultConfig) (*Provider, error) {
+	if cfg.Token == "" && (cfg.RoleID == "" || cfg.SecretID == "") {
+		return nil, fmt.Errorf("vault: token or role_id and secret_id are required")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = defaultMount
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultPath
+	}
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = defaultAppRole
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultCacheTTL
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" && !cfg.InsecureSkipVerify {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("vault: read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("vault: no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	p := &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: readTimeout, Transport: transport},
+		token:  cfg.Token,
+		cache:  make(map[string]*entry),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readTimeout)
+	defer cancel()
+	if err := p.authenticate(ctx); err != nil {
+		log.Warnf("%v; using BMH and config credentials until it succeeds", err)
+	}
+	return p, nil
+}
+
+// OnChange registers fn to be called when a refresh finds a server's
+// credentials changed in Vault.
+func (p *Provider) OnChange(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onChange = fn
+}
+
+// Lookup returns a server's cached credentials without waiting on Vault.
+// ok is false when Vault has none for it, or they haven't been read yet or
+// couldn't be; the caller then uses its own. A server not seen before gets
+// a negative entry and is read in the background, and OnChange's callback
+// runs if a secret turns up.
+func (p *Provider) Lookup(serverName string) (username, password, kg string, ok bool) {
+	p.mu.Lock()
+	e, cached := p.cache[serverName]
+	if !cached {
+		p.cache[serverName] = &entry{failed: true, fetched: time.Now()}
+	}
+	p.mu.Unlock()
+	if !cached {
+		go p.fetch(serverName)
+		return "", "", "", false
+	}
+	return e.creds.Username, e.creds.Password, e.creds.Kg, e.found
+}
+
+// fetch reads a server's secret into the cache for Lookup.
+func (p *Provider) fetch(serverName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), readTimeout)
+	defer cancel()
+	creds, found, err := p.read(ctx, serverName)
+	if err != nil {
+		log.Warnf("Vault: reading credentials for %s: %v; using BMH and config credentials", serverName, err)
+		return
+	}
+	p.mu.Lock()
+	p.cache[serverName] = &entry{creds: creds, found: found, fetched: time.Now()}
+	fn := p.onChange
+	p.mu.Unlock()
+	if found && fn != nil {
+		fn()
+	}
+}
+
+// Run renews the token, or retries authenticating if that has not yet
+// succeeded, and re-reads stale and failed cache entries until ctx is done.
+func (p *Provider) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			authenticated := p.authenticated
+			p.mu.Unlock()
+			if !authenticated {
+				if err := p.authenticate(ctx); err != nil {
+					log.Warn(err)
+					continue
+				}
+				log.Infof("Vault: authenticated")
+			}
+			p.renew(ctx)
+			p.refresh(ctx)
+		}
+	}
+}
+
+// refresh re-reads entries older than the cache TTL, and failed ones. A
+// failed read keeps the cached value.
+func (p *Provider) refresh(ctx context.Context) {
+	p.mu.Lock()
+	var stale []string
+	for name, e := range p.cache {
+		if e.failed || time.Since(e.fetched) >= p.cfg.CacheTTL {
+			stale = append(stale, name)
+		}
+	}
+	p.mu.Unlock()
+
+	changed := false
+	for _, name := range stale {
+		creds, found, err := p.read(ctx, name)
+		if errors.Is(err, errUnreachable) {
+			// The rest would wait out the same outage; retry next time
+			log.Warnf("Vault: refreshing credentials: %v", err)
+			break
+		}
+		if err != nil {
+			log.Warnf("Vault: refreshing credentials for %s: %v", name, err)
+			continue
+		}
+		p.mu.Lock()
+		e := p.cache[name]
+		if e.creds != creds || e.found != found {
+			log.Infof("Vault: credentials for %s changed", name)
+			changed = true
+		}
+		p.cache[name] = &entry{creds: creds, found: found, fetched: time.Now()}
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	fn := p.onChange
+	p.mu.Unlock()
+	if changed && fn != nil {
+		fn()
+	}
+}
+
+// renew extends the token's lease once half of it has passed, logging in
+// again with AppRole if that fails.
+func (p *Provider) renew(ctx context.Context) {
+	p.mu.Lock()
+	due := !p.renewAt.IsZero() && time.Now().After(p.renewAt)
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+	var resp authResponse
+	err := p.do(ctx, "POST", "/v1/auth/token/renew-self", struct{}{}, &resp)
+	if err == nil {
+		p.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+		return
+	}
+	log.Warnf("Vault: token renewal failed: %v", err)
+	if p.cfg.RoleID != "" {
+		if err := p.login(ctx); err != nil {
+			log.Errorf("Vault: AppRole login failed: %v", err)
+		}
+	}
+}
+
+// authenticate logs in with AppRole, or looks up the static token's lease.
+func (p *Provider) authenticate(ctx context.Context) error {
+	if p.cfg.Token == "" {
+		return p.login(ctx)
+	}
+	var resp struct {
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if err := p.do(ctx, "GET", "/v1/auth/token/lookup-self", nil, &resp); err != nil {
+		return fmt.Errorf("vault: token lookup: %w", err)
+	}
+	p.setToken(p.cfg.Token, resp.Data.TTL, resp.Data.Renewable)
+	return nil
+}
+
+type authResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+func (p *Provider) login(ctx context.Context) error {
+	body := map[string]string{"role_id": p.cfg.RoleID, "secret_id": p.cfg.SecretID}
+	var resp authResponse
+	if err := p.do(ctx, "POST", "/v1/auth/"+p.cfg.AppRoleMount+"/login", body, &resp); err != nil {
+		return fmt.Errorf("vault: AppRole login: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault: AppRole login returned no token")
+	}
+	p.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	return nil
+}
+
+// setToken stores a token and schedules its renewal (or, for a token that
+// can't be renewed, a fresh AppRole login) at half its lease.
+func (p *Provider) setToken(token string, leaseSeconds int, renewable bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if token != "" {
+		p.token = token
+	}
+	p.authenticated = true
+	p.renewAt = time.Time{}
+	if leaseSeconds > 0 && (renewable || p.cfg.RoleID != "") {
+		p.renewAt = time.Now().Add(time.Duration(leaseSeconds) * time.Second / 2)
+	}
+}
+
+// read fetches a server's secret. found is false if there is none.
+func (p *Provider) read(ctx context.Context, serverName string) (Credentials, bool, error) {
+	path := "/v1/" + p.cfg.Mount + "/data/" + strings.Trim(p.cfg.Path, "/") + "/" + url.PathEscape(serverName)
+	var resp struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	err := p.do(ctx, "GET", path, nil, &resp)
+	if err == errNotFound {
+		return Credentials{}, false, nil
+	}
+	if err != nil {
+		return Credentials{}, false, err
+	}
+	str := func(key string) string {
+		s, _ := resp.Data.Data[key].(string)
+		return s
+	}
+	return Credentials{Username: str("username"), Password: str("password"), Kg: str("kg")}, true, nil
+}
+
+var (
+	errNotFound    = errors.New("not found")
+	errUnreachable = errors.New("unreachable")
+)
+
+// do sends a Vault API request and decodes the JSON response into out.
+func (p *Provider) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reqBody *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.cfg.Address+path, reqBody)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	token := p.token
+	p.mu.Unlock()
+	if token != "" && !strings.HasSuffix(path, "/login") {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if len(e.Errors) > 0 {
+			return fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, strings.Join(e.Errors, "; "))
+		}
+		return fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
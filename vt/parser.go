@@ -0,0 +1,369 @@
+package vt
+
+import "unicode/utf8"
+
+type parseState int
+
+const (
+	stateGround    parseState = iota
+	stateEscape               // after ESC
+	stateCharset              // after ESC ( or ESC ), awaiting the set
+	stateCSI                  // after ESC [
+	stateString               // OSC, DCS, APC or PM body, until BEL or ST
+	stateStringEsc            // ESC inside a string: ST if followed by '\'
+)
+
+// maxParams bounds the parameters kept for one control sequence.
+const maxParams = 16
+
+type parser struct {
+	state    parseState
+	params   []int
+	cur      int  // parameter being parsed
+	hasCur   bool // a digit has been seen for cur
+	private  byte // '?', '>', '=' or '<' prefix, 0 if none
+	charset  int  // G set ESC ( / ESC ) designates
+	utf8     [utf8.UTFMax]byte
+	utf8Len  int
+	utf8Want int
+}
+
+// feed advances the parser by one byte of console output.
+func (t *Terminal) feed(c byte) {
+	p := &t.p
+	switch p.state {
+	case stateEscape:
+		t.escape(c)
+		return
+	case stateCharset:
+		t.charsets[p.charset] = c == '0'
+		p.state = stateGround
+		return
+	case stateCSI:
+		t.csiByte(c)
+		return
+	case stateString:
+		switch c {
+		case 0x07:
+			p.state = stateGround
+		case 0x1b:
+			p.state = stateStringEsc
+		}
+		return
+	case stateStringEsc:
+		if c == '\\' {
+			p.state = stateGround
+		} else {
+			p.state = stateString
+		}
+		return
+	}
+
+	// Ground: a UTF-8 continuation, a control or a character
+	if p.utf8Want > 0 {
+		if c&0xC0 == 0x80 {
+			p.utf8[p.utf8Len] = c
+			p.utf8Len++
+			if p.utf8Len == p.utf8Want {
+				r, _ := utf8.DecodeRune(p.utf8[:p.utf8Len])
+				p.utf8Want = 0
+				if r != utf8.RuneError {
+					t.print(r)
+				}
+			}
+			return
+		}
+		p.utf8Want = 0 // invalid sequence: drop it and take c afresh
+	}
+	switch {
+	case c < 0x20:
+		t.control(c)
+	case c < 0x7f:
+		t.print(rune(c))
+	case c == 0x7f:
+		// DEL is ignored
+	case c&0xE0 == 0xC0, c&0xF0 == 0xE0, c&0xF8 == 0xF0:
+		p.utf8[0] = c
+		p.utf8Len = 1
+		p.utf8Want = 2
+		if c&0xF0 == 0xE0 {
+			p.utf8Want = 3
+		} else if c&0xF8 == 0xF0 {
+			p.utf8Want = 4
+		}
+	default:
+		// Stray continuation byte or 8-bit code page character: dropped
+	}
+}
+
+// control handles a C0 control character.
+func (t *Terminal) control(c byte) {
+	switch c {
+	case 0x08: // BS
+		if t.x > 0 {
+			t.x--
+		}
+		t.wrapNext = false
+	case 0x09: // HT
+		t.x = clamp((t.x/8+1)*8, 0, t.cols-1)
+		t.wrapNext = false
+	case 0x0a, 0x0b, 0x0c: // LF, VT, FF
+		t.lineFeed()
+	case 0x0d: // CR
+		t.x = 0
+		t.wrapNext = false
+	case 0x0e: // SO
+		t.shifted = true
+	case 0x0f: // SI
+		t.shifted = false
+	case 0x1b:
+		t.p.state = stateEscape
+	}
+}
+
+// escape handles the byte after ESC.
+func (t *Terminal) escape(c byte) {
+	p := &t.p
+	p.state = stateGround
+	switch c {
+	case '[':
+		p.state = stateCSI
+		p.params = p.params[:0]
+		p.cur, p.hasCur, p.private = 0, false, 0
+	case ']', 'P', '_', '^', 'X':
+		p.state = stateString
+	case '(', ')':
+		p.state = stateCharset
+		p.charset = int(c - '(')
+	case '7':
+		t.saved = cursor{x: t.x, y: t.y, attr: t.attr}
+	case '8':
+		t.moveTo(t.saved.x, t.saved.y)
+		t.attr = t.saved.attr
+	case 'D': // IND
+		t.lineFeed()
+	case 'E': // NEL
+		t.x = 0
+		t.lineFeed()
+	case 'M': // RI
+		t.reverseIndex()
+	case 'c': // RIS
+		t.reset()
+	case 0x1b:
+		p.state = stateEscape
+	}
+}
+
+// csiByte collects a control sequence's parameters and runs it on the
+// final byte.
+func (t *Terminal) csiByte(c byte) {
+	p := &t.p
+	switch {
+	case c >= '0' && c <= '9':
+		if p.cur < 1<<16 {
+			p.cur = p.cur*10 + int(c-'0')
+		}
+		p.hasCur = true
+	case c == ';' || c == ':':
+		p.push()
+	case c >= '<' && c <= '?':
+		p.private = c
+	case c >= 0x20 && c <= 0x2f:
+		// Intermediate bytes are ignored
+	case c >= 0x40 && c <= 0x7e:
+		p.push()
+		p.state = stateGround
+		t.csi(c)
+	case c == 0x1b:
+		p.state = stateEscape // abandoned sequence
+	case c < 0x20:
+		t.control(c) // C0 controls act even inside a sequence
+	default:
+		p.state = stateGround
+	}
+}
+
+func (p *parser) push() {
+	if len(p.params) < maxParams {
+		v := p.cur
+		if !p.hasCur {
+			v = -1 // omitted
+		}
+		p.params = append(p.params, v)
+	}
+	p.cur, p.hasCur = 0, false
+}
+
+// param returns parameter i, or def when it is missing or zero.
+func (p *parser) param(i, def int) int {
+	if i >= len(p.params) || p.params[i] <= 0 {
+		return def
+	}
+	return p.params[i]
+}
+
+// csi runs a control sequence.
+func (t *Terminal) csi(final byte) {
+	p := &t.p
+	if p.private != 0 {
+		if p.private == '?' && (final == 'h' || final == 'l') {
+			t.privateMode()
+		}
+		return
+	}
+	n := p.param(0, 1)
+	switch final {
+	case 'A': // CUU
+		t.moveTo(t.x, t.y-n)
+	case 'B', 'e': // CUD, VPR
+		t.moveTo(t.x, t.y+n)
+	case 'C', 'a': // CUF, HPR
+		t.moveTo(t.x+n, t.y)
+	case 'D': // CUB
+		t.moveTo(t.x-n, t.y)
+	case 'E': // CNL
+		t.moveTo(0, t.y+n)
+	case 'F': // CPL
+		t.moveTo(0, t.y-n)
+	case 'G', '`': // CHA, HPA
+		t.moveTo(n-1, t.y)
+	case 'd': // VPA
+		t.moveTo(t.x, n-1)
+	case 'H', 'f': // CUP
+		t.moveTo(p.param(1, 1)-1, n-1)
+	case 'J': // ED
+		switch p.param(0, 0) {
+		case 0:
+			t.eraseCells(t.y, t.x, t.cols-1)
+			if t.y+1 < t.rows {
+				t.eraseRows(t.y+1, t.rows-1)
+			}
+		case 1:
+			if t.y > 0 {
+				t.eraseRows(0, t.y-1)
+			}
+			t.eraseCells(t.y, 0, t.x)
+		case 2, 3:
+			t.eraseRows(0, t.rows-1)
+		}
+	case 'K': // EL
+		switch p.param(0, 0) {
+		case 0:
+			t.eraseCells(t.y, t.x, t.cols-1)
+		case 1:
+			t.eraseCells(t.y, 0, t.x)
+		case 2:
+			t.eraseCells(t.y, 0, t.cols-1)
+		}
+	case 'L': // IL
+		if t.y >= t.top && t.y <= t.bot {
+			t.scrollDown(t.y, t.bot, n)
+		}
+	case 'M': // DL
+		if t.y >= t.top && t.y <= t.bot {
+			t.scrollUp(t.y, t.bot, n)
+		}
+	case '@': // ICH
+		line := t.lines[t.y]
+		n = clamp(n, 0, t.cols-t.x)
+		for x := t.cols - 1; x >= t.x+n; x-- {
+			t.set(x, t.y, line[x-n])
+		}
+		t.eraseCells(t.y, t.x, t.x+n-1)
+	case 'P': // DCH
+		line := t.lines[t.y]
+		n = clamp(n, 0, t.cols-t.x)
+		for x := t.x; x < t.cols-n; x++ {
+			t.set(x, t.y, line[x+n])
+		}
+		t.eraseCells(t.y, t.cols-n, t.cols-1)
+	case 'X': // ECH
+		t.eraseCells(t.y, t.x, t.x+n-1)
+	case 'S': // SU
+		t.scrollUp(t.top, t.bot, n)
+	case 'T': // SD
+		t.scrollDown(t.top, t.bot, n)
+	case 'm': // SGR
+		t.sgr()
+	case 'r': // DECSTBM
+		top, bot := p.param(0, 1)-1, p.param(1, t.rows)-1
+		if top < bot && bot < t.rows {
+			t.top, t.bot = top, bot
+			t.moveTo(0, 0)
+		}
+	case 's': // SCOSC
+		t.saved = cursor{x: t.x, y: t.y, attr: t.attr}
+	case 'u': // SCORC
+		t.moveTo(t.saved.x, t.saved.y)
+		t.attr = t.saved.attr
+	}
+}
+
+// privateMode handles DEC private mode set/reset. Only switching to and
+// from the alternate screen matters here; it is treated as a clear.
+func (t *Terminal) privateMode() {
+	for _, mode := range t.p.params {
+		switch mode {
+		case 47, 1047, 1049:
+			t.eraseRows(0, t.rows-1)
+		}
+	}
+}
+
+// sgr applies Select Graphic Rendition parameters.
+func (t *Terminal) sgr() {
+	ps := t.p.params
+	if len(ps) == 0 {
+		ps = []int{0}
+	}
+	for i := 0; i < len(ps); i++ {
+		switch v := ps[i]; {
+		case v <= 0:
+			t.attr = Attr{}
+		case v == 1:
+			t.attr.Bold = true
+		case v == 4:
+			t.attr.Underline = true
+		case v == 7:
+			t.attr.Reverse = true
+		case v == 22:
+			t.attr.Bold = false
+		case v == 24:
+			t.attr.Underline = false
+		case v == 27:
+			t.attr.Reverse = false
+		case v >= 30 && v <= 37:
+			t.attr.FG = PaletteColor(uint8(v - 30))
+		case v == 39:
+			t.attr.FG = DefaultColor
+		case v >= 40 && v <= 47:
+			t.attr.BG = PaletteColor(uint8(v - 40))
+		case v == 49:
+			t.attr.BG = DefaultColor
+		case v >= 90 && v <= 97:
+			t.attr.FG = PaletteColor(uint8(v - 90 + 8))
+		case v >= 100 && v <= 107:
+			t.attr.BG = PaletteColor(uint8(v - 100 + 8))
+		case v == 38 || v == 48:
+			c, used := extendedColor(ps[i+1:])
+			i += used
+			if v == 38 {
+				t.attr.FG = c
+			} else {
+				t.attr.BG = c
+			}
+		}
+	}
+}
+
+// extendedColor parses the arguments of SGR 38/48: 5;n or 2;r;g;b. It
+// returns the color and how many parameters it used.
+func extendedColor(ps []int) (Color, int) {
+	if len(ps) >= 2 && ps[0] == 5 {
+		return PaletteColor(uint8(clamp(ps[1], 0, 255))), 2
+	}
+	if len(ps) >= 4 && ps[0] == 2 {
+		return RGBColor(uint8(clamp(ps[1], 0, 255)), uint8(clamp(ps[2], 0, 255)), uint8(clamp(ps[3], 0, 255))), 4
+	}
+	return DefaultColor, len(ps)
+}
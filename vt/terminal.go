@@ -0,0 +1,340 @@
+// Package vt is a small VT100/ANSI terminal emulator. It keeps a virtual
+// screen of cells that console output is applied to, so the console can be
+// read back as it would look on a real terminal: cursor positioning, erases,
+// scroll regions and overwrites land where the BMC meant them to.
+//
+// Besides the screen itself, a Terminal turns the output into a stream of
+// stable text lines (see TakeText): a row is written out when the cursor
+// leaves it with a line feed, when it scrolls off, or when it is erased, so
+// full-screen BIOS pages come out once, laid out as drawn, and redraws of
+// the same row collapse into its final content.
+package vt
+
+import "strings"
+
+// Color is a cell color: DefaultColor, a palette index (PaletteColor) or
+// 24-bit RGB (RGBColor).
+type Color uint32
+
+// DefaultColor is the terminal's default foreground or background.
+const DefaultColor Color = 0
+
+const (
+	paletteFlag Color = 1 << 24
+	rgbFlag     Color = 1 << 25
+)
+
+// PaletteColor returns color n of the 256-color palette (0-7 normal, 8-15
+// bright).
+func PaletteColor(n uint8) Color { return paletteFlag | Color(n) }
+
+// RGBColor returns a 24-bit color.
+func RGBColor(r, g, b uint8) Color {
+	return rgbFlag | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// Palette returns the palette index of a palette color.
+func (c Color) Palette() (uint8, bool) {
+	return uint8(c), c&paletteFlag != 0
+}
+
+// RGB returns the components of a 24-bit color.
+func (c Color) RGB() (r, g, b uint8, ok bool) {
+	return uint8(c >> 16), uint8(c >> 8), uint8(c), c&rgbFlag != 0
+}
+
+// Attr is a cell's graphic rendition (SGR).
+type Attr struct {
+	FG, BG    Color
+	Bold      bool
+	Underline bool
+	Reverse   bool
+}
+
+// Cell is one character position on the screen.
+type Cell struct {
+	Ch   rune
+	Attr Attr
+}
+
+var blank = Cell{Ch: ' '}
+
+// Terminal is a virtual screen fed with console output through Write. It
+// is not safe for concurrent use.
+type Terminal struct {
+	cols, rows int
+	lines      [][]Cell
+
+	x, y     int
+	wrapNext bool // at the right margin; the next character wraps
+	attr     Attr
+	top, bot int // scroll region, inclusive
+	saved    cursor
+
+	charsets [2]bool // G0/G1 designated as DEC line drawing
+	shifted  bool    // G1 invoked (SO)
+
+	p parser
+
+	// Text stream (see TakeText)
+	dirty   []bool   // row changed since it was last written out
+	emitted []string // row text already written as an unterminated line
+	open    int      // row whose text ends the stream without a newline, -1 if none
+	text    []byte
+}
+
+type cursor struct {
+	x, y int
+	attr Attr
+}
+
+// New creates a blank terminal of cols x rows.
+func New(cols, rows int) *Terminal {
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	t := &Terminal{
+		cols:    cols,
+		rows:    rows,
+		lines:   make([][]Cell, rows),
+		dirty:   make([]bool, rows),
+		emitted: make([]string, rows),
+		open:    -1,
+		bot:     rows - 1,
+	}
+	for i := range t.lines {
+		t.lines[i] = blankLine(cols)
+	}
+	return t
+}
+
+func blankLine(cols int) []Cell {
+	l := make([]Cell, cols)
+	for i := range l {
+		l[i] = blank
+	}
+	return l
+}
+
+// Size returns the screen size.
+func (t *Terminal) Size() (cols, rows int) {
+	return t.cols, t.rows
+}
+
+// Cursor returns the cursor position, zero-based.
+func (t *Terminal) Cursor() (x, y int) {
+	return t.x, t.y
+}
+
+// Cell returns the cell at column x, row y (zero-based).
+func (t *Terminal) Cell(x, y int) Cell {
+	if x < 0 || x >= t.cols || y < 0 || y >= t.rows {
+		return blank
+	}
+	return t.lines[y][x]
+}
+
+// Line returns row y as text with trailing blanks trimmed.
+func (t *Terminal) Line(y int) string {
+	if y < 0 || y >= t.rows {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range t.lines[y] {
+		b.WriteRune(c.Ch)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// String returns the screen as text, one line per row, trailing blank rows
+// dropped.
+func (t *Terminal) String() string {
+	last := t.rows - 1
+	for last >= 0 && t.Line(last) == "" {
+		last--
+	}
+	var b strings.Builder
+	for y := 0; y <= last; y++ {
+		b.WriteString(t.Line(y))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Write applies console output to the screen. Escape sequences and UTF-8
+// characters may be split across writes. It never fails.
+func (t *Terminal) Write(p []byte) (int, error) {
+	for _, c := range p {
+		t.feed(c)
+	}
+	return len(p), nil
+}
+
+// print puts a character at the cursor and advances it, wrapping at the
+// right margin.
+func (t *Terminal) print(r rune) {
+	if t.wrapNext {
+		t.x = 0
+		t.lineFeed()
+	}
+	if t.lineDrawing() {
+		r = decGraphics(r)
+	}
+	t.set(t.x, t.y, Cell{Ch: r, Attr: t.attr})
+	if t.x == t.cols-1 {
+		t.wrapNext = true
+	} else {
+		t.x++
+	}
+}
+
+func (t *Terminal) lineDrawing() bool {
+	if t.shifted {
+		return t.charsets[1]
+	}
+	return t.charsets[0]
+}
+
+// set writes a cell, marking the row dirty if its text changed.
+func (t *Terminal) set(x, y int, c Cell) {
+	if t.lines[y][x].Ch != c.Ch {
+		t.dirty[y] = true
+	}
+	t.lines[y][x] = c
+}
+
+func (t *Terminal) moveTo(x, y int) {
+	t.x = clamp(x, 0, t.cols-1)
+	t.y = clamp(y, 0, t.rows-1)
+	t.wrapNext = false
+}
+
+// lineFeed moves the cursor down a row, scrolling at the bottom of the
+// scroll region. The row the cursor leaves is written out.
+func (t *Terminal) lineFeed() {
+	if t.y == t.bot && t.top != t.y {
+		t.leave(t.top) // about to scroll off, and above the cursor row
+	}
+	t.endLine(t.y)
+	if t.y == t.bot {
+		t.scrollUp(t.top, t.bot, 1)
+	} else if t.y < t.rows-1 {
+		t.y++
+	}
+	t.wrapNext = false
+}
+
+// reverseIndex moves the cursor up a row, scrolling down at the top of the
+// scroll region.
+func (t *Terminal) reverseIndex() {
+	if t.y == t.top {
+		t.scrollDown(t.top, t.bot, 1)
+	} else if t.y > 0 {
+		t.y--
+	}
+	t.wrapNext = false
+}
+
+// scrollUp moves rows top+n..bot up n rows; the top n leave the screen.
+func (t *Terminal) scrollUp(top, bot, n int) {
+	n = clamp(n, 0, bot-top+1)
+	for i := top; i < top+n; i++ {
+		t.leave(i)
+	}
+	t.shiftRows(top, bot, n)
+}
+
+// scrollDown moves rows top..bot-n down n rows; the bottom n leave the
+// screen.
+func (t *Terminal) scrollDown(top, bot, n int) {
+	n = clamp(n, 0, bot-top+1)
+	for i := bot - n + 1; i <= bot; i++ {
+		t.leave(i)
+	}
+	t.shiftRows(top, bot, -n)
+}
+
+// shiftRows moves the rows of top..bot up by n (down if negative), filling
+// with blank rows.
+func (t *Terminal) shiftRows(top, bot, n int) {
+	if n == 0 {
+		return
+	}
+	lines := make([][]Cell, bot-top+1)
+	dirty := make([]bool, len(lines))
+	emitted := make([]string, len(lines))
+	for i := range lines {
+		src := top + i + n
+		if src < top || src > bot {
+			lines[i] = blankLine(t.cols)
+			continue
+		}
+		lines[i] = t.lines[src]
+		dirty[i] = t.dirty[src]
+		emitted[i] = t.emitted[src]
+	}
+	copy(t.lines[top:], lines)
+	copy(t.dirty[top:], dirty)
+	copy(t.emitted[top:], emitted)
+	if t.open >= top && t.open <= bot {
+		t.open -= n // leave has closed it if it went off
+	}
+}
+
+// eraseRows blanks rows from..to (inclusive) in the current background,
+// writing out their text first.
+func (t *Terminal) eraseRows(from, to int) {
+	for y := from; y <= to; y++ {
+		t.leave(y)
+		t.lines[y] = blankLine(t.cols)
+		if t.attr.BG != DefaultColor {
+			for x := range t.lines[y] {
+				t.lines[y][x].Attr.BG = t.attr.BG
+			}
+		}
+	}
+}
+
+// eraseCells blanks columns from..to (inclusive) of row y.
+func (t *Terminal) eraseCells(y, from, to int) {
+	from = clamp(from, 0, t.cols-1)
+	to = clamp(to, 0, t.cols-1)
+	for x := from; x <= to; x++ {
+		t.set(x, y, Cell{Ch: ' ', Attr: Attr{BG: t.attr.BG}})
+	}
+}
+
+// reset returns the terminal to its initial state, writing out the screen.
+func (t *Terminal) reset() {
+	t.attr = Attr{}
+	t.eraseRows(0, t.rows-1)
+	t.x, t.y, t.wrapNext = 0, 0, false
+	t.top, t.bot = 0, t.rows-1
+	t.saved = cursor{}
+	t.charsets = [2]bool{}
+	t.shifted = false
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// decGraphics maps the DEC special graphics set to Unicode box drawing.
+func decGraphics(r rune) rune {
+	const from = "`afgjklmnopqrstuvwxyz{|}~"
+	const to = "◆▒°±┘┐┌└┼⎺⎻─⎼⎽├┤┴┬│≤≥π≠£·"
+	if i := strings.IndexRune(from, r); i >= 0 {
+		rs := []rune(to)
+		return rs[i]
+	}
+	return r
+}
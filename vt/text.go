@@ -0,0 +1,119 @@
+package vt
+
+import "strings"
+
+// TakeText returns the text lines written out since the last call and
+// clears them. Lines end in '\n' except possibly the last, which Flush may
+// leave open for the rest of its row.
+func (t *Terminal) TakeText() []byte {
+	out := t.text
+	t.text = nil
+	return out
+}
+
+// Pending reports whether any row has changed without being written out,
+// i.e. whether Flush would produce text.
+func (t *Terminal) Pending() bool {
+	for _, d := range t.dirty {
+		if d {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush writes out every changed row, e.g. once the console has gone quiet
+// on a prompt or a full-screen page. The cursor row, if it is the last one
+// changed, is left open: when more is typed on it, only the new text
+// follows.
+func (t *Terminal) Flush() {
+	last := -1
+	for y, d := range t.dirty {
+		if d {
+			last = y
+		}
+	}
+	for y := 0; y <= last; y++ {
+		if !t.dirty[y] {
+			continue
+		}
+		t.emit(y, y != t.y || y != last)
+	}
+}
+
+// BreakLine ends an open line without writing anything, e.g. because
+// other text was put after it; the row is written out in full when it
+// ends.
+func (t *Terminal) BreakLine() {
+	if t.open < 0 {
+		return
+	}
+	t.emitted[t.open] = ""
+	t.dirty[t.open] = true
+	t.open = -1
+}
+
+// endLine writes out row y as the cursor leaves it with a line feed. An
+// unchanged blank row still makes a blank line.
+func (t *Terminal) endLine(y int) {
+	switch {
+	case t.dirty[y]:
+		t.emit(y, true)
+	case t.open == y:
+		t.closeOpen()
+	case t.Line(y) == "":
+		if t.open >= 0 {
+			t.closeOpen()
+		}
+		t.text = append(t.text, '\n')
+	}
+}
+
+// leave writes out row y before it is scrolled off or erased.
+func (t *Terminal) leave(y int) {
+	if t.dirty[y] && t.Line(y) != "" {
+		t.emit(y, true)
+	} else if t.open == y {
+		t.closeOpen()
+	}
+	t.dirty[y] = false
+	t.emitted[y] = ""
+}
+
+// emit writes row y's text, as a complete line or left open. Text already
+// written for the row while it was open is not repeated if the row only
+// grew since.
+func (t *Terminal) emit(y int, complete bool) {
+	if t.open >= 0 && t.open != y {
+		t.closeOpen()
+	}
+	text := t.Line(y)
+	prev := t.emitted[y]
+	switch {
+	case prev == "":
+		t.text = append(t.text, text...)
+	case strings.HasPrefix(text, prev):
+		t.text = append(t.text, text[len(prev):]...)
+	case text == "":
+		// The open line was erased; just end it
+	default:
+		t.text = append(t.text, '\n')
+		t.text = append(t.text, text...)
+	}
+	t.dirty[y] = false
+	if complete || text == "" {
+		t.text = append(t.text, '\n')
+		t.emitted[y] = ""
+		t.open = -1
+		return
+	}
+	t.emitted[y] = text
+	t.open = y
+}
+
+// closeOpen ends the open line. Its row counts as written out.
+func (t *Terminal) closeOpen() {
+	t.text = append(t.text, '\n')
+	t.emitted[t.open] = ""
+	t.open = -1
+}
@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// permessage-deflate (RFC 7692): messages are compressed with a sync flush,
+// RSV1 marks a compressed message, and by default each side keeps its
+// compression window across messages, which is what makes small console
+// chunks shrink.
+
+const deflateExtension = "permessage-deflate"
+
+// deflateWindow is the LZ77 window compress/flate uses (2^15 bytes). Offers
+// limiting the server's window below it are declined.
+const deflateWindow = 1 << 15
+
+// minCompressSize is the smallest message worth compressing; keystrokes and
+// short events are sent as they are.
+const minCompressSize = 16
+
+// deflateTail ends every sync flush; senders strip it and receivers put it
+// back. deflateFinal is an empty final block, so a message inflates to EOF.
+var (
+	deflateTail  = []byte{0x00, 0x00, 0xff, 0xff}
+	deflateFinal = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+var errTooBig = errors.New("message too big")
+
+// deflateParams are the negotiated context takeover settings.
+type deflateParams struct {
+	serverNoTakeover bool
+	clientNoTakeover bool
+}
+
+// extensions splits a Sec-WebSocket-Extensions header into extensions, each
+// its name followed by its parameters.
+func extensions(h http.Header) [][]string {
+	var exts [][]string
+	for _, v := range h.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(v, ",") {
+			var parts []string
+			for _, p := range strings.Split(ext, ";") {
+				if p = strings.TrimSpace(p); p != "" {
+					parts = append(parts, p)
+				}
+			}
+			if len(parts) > 0 {
+				exts = append(exts, parts)
+			}
+		}
+	}
+	return exts
+}
+
+// acceptDeflate picks the first permessage-deflate offer in a client's
+// handshake the server can honour and returns the response header value.
+func acceptDeflate(h http.Header) (deflateParams, string, bool) {
+offers:
+	for _, ext := range extensions(h) {
+		if !strings.EqualFold(ext[0], deflateExtension) {
+			continue
+		}
+		var p deflateParams
+		seen := make(map[string]bool)
+		for _, param := range ext[1:] {
+			name, value, _ := strings.Cut(param, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			if seen[name] {
+				continue offers
+			}
+			seen[name] = true
+			switch name {
+			case "server_no_context_takeover":
+				p.serverNoTakeover = true
+			case "client_no_context_takeover":
+				// The client could stop taking over; no need, the server
+				// inflates with the previous messages as dictionary
+			case "server_max_window_bits":
+				if value != "15" {
+					continue offers
+				}
+			case "client_max_window_bits":
+				// The client would accept a limit; none is asked for
+			default:
+				continue offers
+			}
+		}
+		resp := deflateExtension
+		if p.serverNoTakeover {
+			resp += "; server_no_context_takeover"
+		}
+		return p, resp, true
+	}
+	return deflateParams{}, "", false
+}
+
+// dialDeflate checks a server's handshake response against the client's
+// bare permessage-deflate offer.
+func dialDeflate(h http.Header) (p deflateParams, ok bool, err error) {
+	for _, ext := range extensions(h) {
+		if !strings.EqualFold(ext[0], deflateExtension) || ok {
+			return p, false, errors.New("websocket: server accepted an extension that wasn't offered")
+		}
+		ok = true
+		for _, param := range ext[1:] {
+			name, _, _ := strings.Cut(param, "=")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "server_no_context_takeover":
+				p.serverNoTakeover = true
+			case "client_no_context_takeover":
+				p.clientNoTakeover = true
+			case "server_max_window_bits":
+				// Inflating handles any window
+			default:
+				return p, false, errors.New("websocket: bad permessage-deflate response " + strings.Join(ext, "; "))
+			}
+		}
+	}
+	return p, ok, nil
+}
+
+// enableDeflate sets up compression once negotiated, from this side's view
+// of which ends keep their context.
+func (c *Conn) enableDeflate(p deflateParams) {
+	c.deflate = true
+	if c.client {
+		c.noTakeover, c.peerNoTakeover = p.clientNoTakeover, p.serverNoTakeover
+	} else {
+		c.noTakeover, c.peerNoTakeover = p.serverNoTakeover, p.clientNoTakeover
+	}
+}
+
+// compress deflates a message, without the sync flush tail. c.wmu must be
+// held; the result is only valid until the next call. Below
+// BestCompression, compress/flate codes messages under 128 bytes without
+// looking back at earlier ones, and most console chunks are that short; a
+// serial console's output rate makes the extra CPU irrelevant.
+func (c *Conn) compress(data []byte) ([]byte, error) {
+	if c.fw == nil {
+		c.fw, _ = flate.NewWriter(&c.fbuf, flate.BestCompression)
+	}
+	c.fbuf.Reset()
+	if _, err := c.fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := c.fw.Flush(); err != nil {
+		return nil, err
+	}
+	if c.noTakeover {
+		c.fw.Reset(&c.fbuf)
+	}
+	return bytes.TrimSuffix(c.fbuf.Bytes(), deflateTail), nil
+}
+
+// decompress inflates a compressed message. Unless the peer resets its
+// context per message, the output of earlier compressed messages is the
+// dictionary. Only the reading goroutine calls it.
+func (c *Conn) decompress(payload []byte) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail), bytes.NewReader(deflateFinal))
+	var dict []byte
+	if !c.peerNoTakeover {
+		dict = c.dict
+	}
+	if c.fr == nil {
+		c.fr = flate.NewReaderDict(src, dict)
+	} else {
+		c.fr.(flate.Resetter).Reset(src, dict)
+	}
+	data, err := io.ReadAll(io.LimitReader(c.fr, MaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxMessageSize {
+		return nil, errTooBig
+	}
+	if !c.peerNoTakeover {
+		c.dict = append(c.dict, data...)
+		if n := len(c.dict) - deflateWindow; n > 0 {
+			c.dict = append(c.dict[:0], c.dict[n:]...)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,469 @@
+// Package websocket is a minimal RFC 6455 WebSocket implementation, server
+// and client side, for interactive console attach. It handles the
+// handshake, masking, fragmented messages, ping/pong/close control frames
+// and permessage-deflate compression; subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types.
+const (
+	OpText   = 1
+	OpBinary = 2
+
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// Close status codes.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseInvalidData   = 1007
+	CloseTooBig        = 1009
+)
+
+// MaxMessageSize caps a received message, fragments included.
+const MaxMessageSize = 1 << 20
+
+// acceptGUID is appended to Sec-WebSocket-Key to form Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrBadHandshake is returned by Dial when the server does not switch
+// protocols; the response is returned with its body readable.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// CloseError is returned by ReadMessage once the peer closes the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed (%d)", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed (%d): %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others
+// write.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // frames we send are masked
+
+	wmu    sync.Mutex
+	closed bool // close frame sent
+
+	// permessage-deflate, when negotiated: fw and fbuf compress under wmu,
+	// fr and dict (recent inflated output) belong to the reader
+	deflate        bool
+	noTakeover     bool // reset our compressor after each message
+	peerNoTakeover bool // the peer resets its compressor after each message
+	fw             *flate.Writer
+	fbuf           bytes.Buffer
+	fr             io.ReadCloser
+	dict           []byte
+}
+
+// IsUpgrade reports whether r asks to switch to WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the server handshake for r and takes over its
+// connection. With compress, a permessage-deflate offer from the client is
+// accepted. On error nothing has been written, so the caller can still
+// send an HTTP error response.
+func Upgrade(w http.ResponseWriter, r *http.Request, compress bool) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		return nil, errors.New("not a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported Sec-WebSocket-Version (13 required)")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	// Deadlines set by the HTTP server don't apply to the upgraded stream
+	conn.SetDeadline(time.Time{})
+	c := &Conn{conn: conn, br: brw.Reader}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if compress {
+		if p, ext, ok := acceptDeflate(r.Header); ok {
+			c.enableDeflate(p)
+			resp += "Sec-WebSocket-Extensions: " + ext + "\r\n"
+		}
+	}
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Dial opens a client connection to a ws://, wss://, http:// or https://
+// URL. header is sent with the handshake (e.g. Authorization); tlsConfig
+// may be nil. permessage-deflate is offered and used if the server agrees.
+func Dial(ctx context.Context, rawURL string, header http.Header, tlsConfig *tls.Config) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme = "https"
+		secure = true
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secure {
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		cfg.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+
+	// The handshake must finish in time; the stream itself has no deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Extensions", deflateExtension)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerHasToken(resp.Header, "Upgrade", "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		conn.Close()
+		return nil, resp, ErrBadHandshake
+	}
+	c := &Conn{conn: conn, br: br, client: true}
+	if p, ok, err := dialDeflate(resp.Header); err != nil {
+		conn.Close()
+		return nil, resp, err
+	} else if ok {
+		c.enableDeflate(p)
+	}
+	if !stop() {
+		conn.Close()
+		return nil, nil, ctx.Err()
+	}
+	conn.SetDeadline(time.Time{})
+	return c, resp, nil
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs dropped along the way, and compressed messages inflated. Once
+// the peer closes, the close is echoed and a *CloseError returned.
+func (c *Conn) ReadMessage() (op int, data []byte, err error) {
+	var msgOp int
+	var msg []byte
+	var compressed bool
+	for {
+		fin, rsv1, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if rsv1 && frameOp != OpText && frameOp != OpBinary {
+			return 0, nil, c.fail(CloseProtocolError, "RSV1 set on a continuation or control frame")
+		}
+		switch frameOp {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			ce := &CloseError{Code: 1005}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			c.CloseWith(CloseNormal, "")
+			return 0, nil, ce
+		case opContinuation:
+			if msgOp == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		case OpText, OpBinary:
+			if msgOp != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			msgOp = frameOp
+			compressed = rsv1
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", frameOp))
+		}
+		if len(msg)+len(payload) > MaxMessageSize {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		msg = append(msg, payload...)
+		if !fin {
+			continue
+		}
+		if compressed {
+			if msg, err = c.decompress(msg); errors.Is(err, errTooBig) {
+				return 0, nil, c.fail(CloseTooBig, "message too big")
+			} else if err != nil {
+				return 0, nil, c.fail(CloseInvalidData, "bad compressed message: "+err.Error())
+			}
+		}
+		return msgOp, msg, nil
+	}
+}
+
+// readFrame reads one frame, unmasking its payload. RSV1 is only allowed
+// with permessage-deflate.
+func (c *Conn) readFrame() (fin, rsv1 bool, op int, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	rsv1 = hdr[0]&0x40 != 0
+	op = int(hdr[0] & 0x0f)
+	if hdr[0]&0x30 != 0 || rsv1 && !c.deflate {
+		return false, false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client {
+		return false, false, 0, nil, c.fail(CloseProtocolError, "bad masking")
+	}
+
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (n > 125 || !fin) {
+		return false, false, 0, nil, c.fail(CloseProtocolError, "malformed control frame")
+	}
+	if n > MaxMessageSize {
+		return false, false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, rsv1, op, payload, nil
+}
+
+// WriteMessage sends a text or binary message as a single frame,
+// compressed if permessage-deflate was negotiated and it is long enough to
+// gain.
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+// Ping sends a ping, which keeps idle connections open through proxies.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if op == opClose {
+		c.closed = true
+	}
+	first := 0x80 | byte(op)
+	if c.deflate && op < opClose && len(payload) >= minCompressSize {
+		compressed, err := c.compress(payload)
+		if err != nil {
+			return err
+		}
+		payload = compressed
+		first |= 0x40
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, first)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range payload {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// CloseWith sends a close frame with a status code and reason, if one
+// hasn't been sent yet. The connection stays open for the peer's reply;
+// call Close to release it.
+func (c *Conn) CloseWith(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return c.writeFrame(opClose, append(payload, reason...))
+}
+
+// Close sends a normal close frame if none was sent and closes the
+// connection.
+func (c *Conn) Close() error {
+	c.CloseWith(CloseNormal, "")
+	return c.conn.Close()
+}
+
+// fail closes the connection after a protocol error.
+func (c *Conn) fail(code int, reason string) error {
+	c.CloseWith(code, reason)
+	c.conn.Close()
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma-separated header lists token.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer upgrades each request and sends every message back.
+func echoServer(t *testing.T, compress bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, compress)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer conn.Close()
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if conn.WriteMessage(op, data) != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server) (*Conn, *http.Response) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, resp, err := Dial(ctx, srv.URL, nil, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, resp
+}
+
+func TestEcho(t *testing.T) {
+	line := "[  OK  ] Started Journal Service.\r\n[  OK  ] Reached target Local File Systems.\r\n"
+	msgs := [][]byte{
+		[]byte("k"),
+		[]byte(line),
+		[]byte(line),
+		[]byte(strings.Repeat(line, 3000)),
+		[]byte(line + "login: "),
+	}
+	for _, compress := range []bool{false, true} {
+		conn, resp := dial(t, echoServer(t, compress))
+		ext := resp.Header.Get("Sec-WebSocket-Extensions")
+		if compress != (ext == deflateExtension) {
+			t.Errorf("compress=%v: Sec-WebSocket-Extensions %q", compress, ext)
+		}
+		for i, msg := range msgs {
+			if err := conn.WriteMessage(OpBinary, msg); err != nil {
+				t.Fatalf("compress=%v: WriteMessage %d: %v", compress, i, err)
+			}
+			op, got, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("compress=%v: ReadMessage %d: %v", compress, i, err)
+			}
+			if op != OpBinary || !bytes.Equal(got, msg) {
+				t.Errorf("compress=%v: message %d came back as op %d, %d bytes; want %d bytes", compress, i, op, len(got), len(msg))
+			}
+		}
+		if compress != (conn.fw != nil) {
+			t.Errorf("compress=%v: compressor used = %v", compress, conn.fw != nil)
+		}
+	}
+}
+
+func TestCompressTakeover(t *testing.T) {
+	line := []byte("[    5.111111] eth0: link up, 10000Mbps, full-duplex\r\n")
+	for _, noTakeover := range []bool{false, true} {
+		c := &Conn{noTakeover: noTakeover}
+		first, _ := c.compress(line)
+		n := len(first)
+		second, _ := c.compress(line)
+		if shrunk := len(second) < n/2; shrunk == noTakeover {
+			t.Errorf("noTakeover=%v: repeated message compressed to %d bytes, first %d", noTakeover, len(second), n)
+		}
+	}
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	for _, tt := range []struct {
+		offer string
+		resp  string
+	}{
+		{"", ""},
+		{"x-webkit-deflate-frame", ""},
+		{"permessage-deflate", "permessage-deflate"},
+		{"permessage-deflate; client_max_window_bits", "permessage-deflate"},
+		{"permessage-deflate; server_no_context_takeover; client_no_context_takeover", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=10", ""},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate"},
+		{"permessage-deflate; server_max_window_bits=15", "permessage-deflate"},
+		{"permessage-deflate; mystery", ""},
+		{"permessage-deflate; server_no_context_takeover; server_no_context_takeover", ""},
+	} {
+		h := http.Header{}
+		if tt.offer != "" {
+			h.Set("Sec-WebSocket-Extensions", tt.offer)
+		}
+		_, resp, ok := acceptDeflate(h)
+		if ok != (tt.resp != "") || resp != tt.resp {
+			t.Errorf("offer %q: response %q, %v; want %q", tt.offer, resp, ok, tt.resp)
+		}
+	}
+}
+
+func TestDialDeflate(t *testing.T) {
+	for _, tt := range []struct {
+		resp string
+		want deflateParams
+		ok   bool
+		err  bool
+	}{
+		{"", deflateParams{}, false, false},
+		{"permessage-deflate", deflateParams{}, true, false},
+		{"permessage-deflate; client_no_context_takeover; server_max_window_bits=12", deflateParams{clientNoTakeover: true}, true, false},
+		{"permessage-deflate; client_max_window_bits=12", deflateParams{}, false, true},
+		{"permessage-deflate, permessage-deflate", deflateParams{}, false, true},
+		{"x-other", deflateParams{}, false, true},
+	} {
+		h := http.Header{}
+		if tt.resp != "" {
+			h.Set("Sec-WebSocket-Extensions", tt.resp)
+		}
+		p, ok, err := dialDeflate(h)
+		if (err != nil) != tt.err || ok != tt.ok || p != tt.want {
+			t.Errorf("response %q: %+v, %v, %v", tt.resp, p, ok, err)
+		}
+	}
+}